@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/client/internal/output"
+	"github.com/spf13/cobra"
+)
+
+type presignFlagValues struct {
+	taskID  string
+	reqKey  string
+	expires string
+}
+
+var presignFlags presignFlagValues
+
+var presignCmd = &cobra.Command{
+	Use:   "presign",
+	Short: "Print a presigned link to query task status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, formatter, err := newClientAndFormatter(cmd)
+		if err != nil {
+			return err
+		}
+
+		expires, err := time.ParseDuration(strings.TrimSpace(presignFlags.expires))
+		if err != nil {
+			return fmt.Errorf("invalid --expires: %w", err)
+		}
+		u, err := client.PresignGetResultURL(presignFlags.taskID, strings.TrimSpace(presignFlags.reqKey), expires)
+		if err != nil {
+			return err
+		}
+
+		out, err := formatter.FormatPresignResult(output.PresignResult{TaskID: presignFlags.taskID, URL: u, ExpiresIn: int64(expires / time.Second)})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), out)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(presignCmd)
+
+	presignCmd.Flags().StringVar(&presignFlags.taskID, "task-id", "", "Task ID")
+	presignCmd.Flags().StringVar(&presignFlags.reqKey, "req-key", "", "req_key of the task (default: image generation)")
+	presignCmd.Flags().StringVar(&presignFlags.expires, "expires", "15m", "Link lifetime, e.g. 15m, 1h; the relay caps it at SIGV4_PRESIGN_MAX_EXPIRES")
+	if err := presignCmd.MarkFlagRequired("task-id"); err != nil {
+		cobra.CheckErr(err)
+	}
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jimeng-relay/client/internal/config"
+	"github.com/jimeng-relay/client/internal/output"
+)
+
+func TestPresignCommand(t *testing.T) {
+	t.Setenv(config.EnvAccessKey, "test-ak")
+	t.Setenv(config.EnvSecretKey, "test-sk")
+	t.Setenv(config.EnvHost, "relay.example.com")
+	t.Setenv(config.EnvScheme, "https")
+
+	rootCmd := RootCmd()
+	b := new(bytes.Buffer)
+	rootCmd.SetOut(b)
+	rootCmd.SetArgs([]string{"presign", "--task-id", "task-1", "--expires", "30m", "--format", "json"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("presign: %v", err)
+	}
+
+	var res output.PresignResult
+	if err := json.Unmarshal(b.Bytes(), &res); err != nil {
+		t.Fatalf("decode output %q: %v", b.String(), err)
+	}
+	if res.TaskID != "task-1" || res.ExpiresIn != 1800 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+	q := u.Query()
+	if u.Host != "relay.example.com" || u.Path != "/v1/get-result" || q.Get("task_id") != "task-1" ||
+		q.Get("X-Expires") != "1800" || q.Get("X-Signature") == "" || !strings.HasPrefix(q.Get("X-Credential"), "test-ak/") {
+		t.Fatalf("unexpected presigned url %s", res.URL)
+	}
+}
+
+func TestPresignCommand_InvalidExpires(t *testing.T) {
+	t.Setenv(config.EnvAccessKey, "test-ak")
+	t.Setenv(config.EnvSecretKey, "test-sk")
+	t.Setenv(config.EnvHost, "relay.example.com")
+
+	rootCmd := RootCmd()
+	rootCmd.SetOut(new(bytes.Buffer))
+	rootCmd.SetErr(new(bytes.Buffer))
+	rootCmd.SetArgs([]string{"presign", "--task-id", "task-1", "--expires", "soon"})
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "--expires") {
+		t.Fatalf("expected an --expires error, got %v", err)
+	}
+}
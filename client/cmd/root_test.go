@@ -21,7 +21,7 @@ func TestRootHelp(t *testing.T) {
 	}
 
 	out := b.String()
-	subcommands := []string{"submit", "query", "wait", "download"}
+	subcommands := []string{"submit", "query", "wait", "download", "presign"}
 	for _, sub := range subcommands {
 		if !contains(out, sub) {
 			t.Errorf("expected help output to contain %s", sub)
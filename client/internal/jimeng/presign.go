@@ -0,0 +1,167 @@
+package jimeng
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/client/internal/api"
+)
+
+const presignScopeSuffix = "request"
+
+// PresignOptions describes a relay URL to sign in its query string.
+type PresignOptions struct {
+	// Method defaults to GET; the relay only accepts GET and HEAD for presigned URLs.
+	Method    string
+	URL       string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Expires   time.Duration
+	Now       time.Time
+}
+
+// PresignURL signs opts.URL with X-* query parameters the relay accepts in place
+// of an Authorization header. Anyone holding the result can use it until it
+// expires, so share it only as widely as the underlying key.
+func PresignURL(opts PresignOptions) (string, error) {
+	method := strings.ToUpper(strings.TrimSpace(opts.Method))
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		return "", fmt.Errorf("presign: method %s is not supported", method)
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return "", fmt.Errorf("presign: access key and secret key are required")
+	}
+	if opts.Expires < time.Second {
+		return "", fmt.Errorf("presign: expires must be at least 1s")
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return "", fmt.Errorf("presign: parse url: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("presign: url must be absolute")
+	}
+	region := opts.Region
+	if region == "" {
+		region = "cn-north-1"
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+
+	xDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := strings.Join([]string{date, region, "cv", presignScopeSuffix}, "/")
+
+	q := u.Query()
+	q.Del("X-Signature")
+	q.Set("X-Algorithm", "HMAC-SHA256")
+	q.Set("X-Credential", opts.AccessKey+"/"+scope)
+	q.Set("X-Date", xDate)
+	q.Set("X-Expires", strconv.FormatInt(int64(opts.Expires/time.Second), 10))
+	q.Set("X-SignedHeaders", "host")
+
+	segments := strings.Split(u.Path, "/")
+	for i := range segments {
+		segments[i] = presignEscape(segments[i])
+	}
+	path := strings.Join(segments, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		presignCanonicalQuery(q),
+		"host:" + strings.ToLower(u.Host) + "\n",
+		"host",
+		hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"HMAC-SHA256", xDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := presignHMAC([]byte(opts.SecretKey), date)
+	for _, part := range []string{region, "cv", presignScopeSuffix} {
+		key = presignHMAC(key, part)
+	}
+	q.Set("X-Signature", hex.EncodeToString(presignHMAC(key, stringToSign)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// PresignGetResultURL returns a presigned GET /v1/get-result link for taskID
+// using the client's relay host and credentials. An empty reqKey defaults to
+// the image generation req_key, matching GetResult.
+func (c *Client) PresignGetResultURL(taskID, reqKey string, expires time.Duration) (string, error) {
+	if strings.TrimSpace(taskID) == "" {
+		return "", fmt.Errorf("presign: task_id is required")
+	}
+	if strings.TrimSpace(c.config.Host) == "" {
+		return "", fmt.Errorf("presign: relay host is not configured")
+	}
+	if reqKey == "" {
+		reqKey = api.ReqKeyJimengT2IV40
+	}
+	scheme := c.config.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	target := url.URL{
+		Scheme:   scheme,
+		Host:     c.config.Host,
+		Path:     "/v1/get-result",
+		RawQuery: url.Values{"req_key": {reqKey}, "task_id": {taskID}}.Encode(),
+	}
+	return PresignURL(PresignOptions{
+		URL:       target.String(),
+		AccessKey: c.config.Credentials.AccessKey,
+		SecretKey: c.config.Credentials.SecretKey,
+		Region:    c.config.Region,
+		Expires:   expires,
+	})
+}
+
+func presignCanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, presignEscape(k)+"="+presignEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func presignEscape(s string) string {
+	e := url.QueryEscape(s)
+	e = strings.ReplaceAll(e, "+", "%20")
+	e = strings.ReplaceAll(e, "*", "%2A")
+	return strings.ReplaceAll(e, "%7E", "~")
+}
+
+func presignHMAC(key []byte, message string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(message))
+	return h.Sum(nil)
+}
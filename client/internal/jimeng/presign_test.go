@@ -0,0 +1,68 @@
+package jimeng
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/client/internal/config"
+)
+
+// goldenPresignSignature matches the relay's own presign test for the same
+// inputs, so a change to either canonical form fails one of the two tests.
+const goldenPresignSignature = "a8e2bef9674883a9e565ad25d9edca45a720dbe0d21ff12f29f47564c76dad1f"
+
+func TestPresignURL_MatchesRelaySignature(t *testing.T) {
+	signed, err := PresignURL(PresignOptions{
+		URL:       "https://relay.example.com/v1/get-result?task_id=t1&req_key=jimeng_t2i_v40",
+		AccessKey: "ak_test",
+		SecretKey: "sk_test_secret",
+		Expires:   15 * time.Minute,
+		Now:       time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("PresignURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("parse signed url: %v", err)
+	}
+	q := u.Query()
+	if got := q.Get("X-Credential"); got != "ak_test/20260224/cn-north-1/cv/request" {
+		t.Errorf("X-Credential = %q", got)
+	}
+	if got := q.Get("X-Signature"); got != goldenPresignSignature {
+		t.Errorf("X-Signature = %q, want %q", got, goldenPresignSignature)
+	}
+}
+
+func TestPresignGetResultURL(t *testing.T) {
+	c, err := NewClient(config.Config{
+		Credentials: config.Credentials{AccessKey: "ak_test", SecretKey: "sk_test_secret"},
+		Region:      "cn-north-1",
+		Host:        "relay.example.com:8080",
+		Scheme:      "http",
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	signed, err := c.PresignGetResultURL("t1", "", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("PresignGetResultURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("parse signed url: %v", err)
+	}
+	if u.Scheme != "http" || u.Host != "relay.example.com:8080" || u.Path != "/v1/get-result" {
+		t.Errorf("unexpected url: %s", signed)
+	}
+	q := u.Query()
+	if q.Get("task_id") != "t1" || q.Get("req_key") != "jimeng_t2i_v40" || q.Get("X-Expires") != "600" {
+		t.Errorf("unexpected query: %s", u.RawQuery)
+	}
+
+	if _, err := c.PresignGetResultURL("", "", time.Minute); err == nil {
+		t.Errorf("expected error for empty task id")
+	}
+}
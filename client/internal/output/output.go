@@ -107,3 +107,29 @@ func (f *Formatter) FormatVideoDownloadResult(res VideoDownloadResult) (string,
 		return "", fmt.Errorf("unsupported format: %q", format)
 	}
 }
+
+type PresignResult struct {
+	TaskID    string `json:"task_id"`
+	URL       string `json:"url"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+func (f *Formatter) FormatPresignResult(res PresignResult) (string, error) {
+	format := FormatText
+	if f != nil && f.Format != "" {
+		format = f.Format
+	}
+
+	switch format {
+	case FormatJSON:
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case FormatText:
+		return fmt.Sprintf("URL=%s", res.URL), nil
+	default:
+		return "", fmt.Errorf("unsupported format: %q", format)
+	}
+}
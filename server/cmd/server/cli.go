@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"strings"
+
+	"github.com/jimeng-relay/server/internal/config"
+)
+
+// configFlag registers --config on fs, so every command reads the same
+// configuration as serve.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "YAML config file (.yaml/.yml) or dotenv file")
+}
+
+func configOptions(configFile string) config.Options {
+	var opts config.Options
+	if path := strings.TrimSpace(configFile); path != "" {
+		opts.ConfigFile = &path
+	}
+	return opts
+}
+
+// loadCLIConfig loads the configuration from configFile (or ./.env) and the
+// environment like serve, without requiring the upstream credentials or API
+// key master keys most commands do not use.
+func loadCLIConfig(configFile string) (config.Config, error) {
+	opts := configOptions(configFile)
+	opts.SkipCredentials = true
+	return config.Load(opts)
+}
+
+func writeJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jimeng-relay/server/internal/config"
+	"github.com/jimeng-relay/server/internal/secretcrypto"
+)
+
+func runCryptoCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return printCryptoUsage(out)
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		return printCryptoUsage(out)
+	case "rekey":
+		fs := flag.NewFlagSet("crypto rekey", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		configFile := configFlag(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("parse crypto rekey flags: %w", err)
+		}
+		if fs.NArg() != 0 {
+			return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+		}
+
+		ctx := context.Background()
+		cfg, err := loadCLIConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := config.ValidateEncryptionKeys(cfg); err != nil {
+			return err
+		}
+		provider, err := newKeyProvider(cfg)
+		if err != nil {
+			return err
+		}
+		secretCipher, err := newSecretCipher(ctx, provider)
+		if err != nil {
+			return err
+		}
+		ring, ok := secretCipher.(*secretcrypto.Keyring)
+		if !ok {
+			return fmt.Errorf("rekey needs keys with ids: set %s, or use id:base64 entries in the key file", config.EnvAPIKeyEncryptionKeys)
+		}
+		repos, cleanup, err := openRepositories(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		scanned, rewritten, err := repos.APIKeySecrets.RewriteSecretCiphertexts(ctx, ring.Rekey)
+		if err != nil {
+			return fmt.Errorf("rekey api key secrets: %w", err)
+		}
+		return writeJSON(out, map[string]any{
+			"primary_key_id": ring.PrimaryID(),
+			"total":          scanned,
+			"rekeyed":        rewritten,
+		})
+	case "generate-data-key":
+		fs := flag.NewFlagSet("crypto generate-data-key", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		id := fs.String("id", "", "id for the new data key")
+		configFile := configFlag(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("parse crypto generate-data-key flags: %w", err)
+		}
+		if fs.NArg() != 0 {
+			return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+		}
+		if strings.TrimSpace(*id) == "" {
+			return fmt.Errorf("--id is required")
+		}
+
+		cfg, err := loadCLIConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := config.ValidateEncryptionKeys(cfg); err != nil {
+			return err
+		}
+		if cfg.APIKeyKeyProvider != config.KeyProviderKMS {
+			return fmt.Errorf("generate-data-key requires %s=%s", config.EnvAPIKeyKeyProvider, config.KeyProviderKMS)
+		}
+		provider, err := newKeyProvider(cfg)
+		if err != nil {
+			return err
+		}
+		blob, err := provider.(*secretcrypto.KMSProvider).GenerateDataKey(context.Background())
+		if err != nil {
+			return err
+		}
+		// Only the wrapped key is printed; append the entry to the wrapped key
+		// list to make it the new primary key.
+		return writeJSON(out, map[string]any{
+			"id":    strings.TrimSpace(*id),
+			"entry": strings.TrimSpace(*id) + ":" + base64.StdEncoding.EncodeToString(blob),
+		})
+	default:
+		return fmt.Errorf("unknown crypto subcommand %q", args[0])
+	}
+}
+
+func printCryptoUsage(out io.Writer) error {
+	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server crypto rekey                        re-encrypt every stored api key secret with the newest key"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server crypto generate-data-key --id <id>  ask the KMS for a new wrapped data key ("+config.EnvAPIKeyKeyProvider+"=kms)"); err != nil {
+		return err
+	}
+	return nil
+}
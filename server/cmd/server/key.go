@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/config"
+	"github.com/jimeng-relay/server/internal/models"
+	apikeyservice "github.com/jimeng-relay/server/internal/service/apikey"
+)
+
+func runKeyCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return printKeyUsage(out)
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		return printKeyUsage(out)
+	case "create":
+		return runKeyCreate(context.Background(), args[1:], out)
+	case "list":
+		return runKeyList(context.Background(), args[1:], out)
+	case "revoke":
+		return runKeyRevoke(context.Background(), args[1:], out)
+	case "rotate":
+		return runKeyRotate(context.Background(), args[1:], out)
+	case "update":
+		return runKeyUpdate(context.Background(), args[1:], out)
+	default:
+		return fmt.Errorf("unknown key subcommand %q", args[0])
+	}
+}
+
+func runKeyCreate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("key create", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	description := fs.String("description", "", "human-friendly description")
+	expiresAt := fs.String("expires-at", "", "RFC3339 expiration timestamp")
+	clientCertSubject := fs.String("client-cert-subject", "", "require a TLS client certificate with this subject, e.g. CN=worker,O=Example")
+	owner := fs.String("owner", "", "person or team responsible for the key")
+	labels := labelFlags{}
+	fs.Var(labels, "label", "name=value label, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse key create flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	var expiry *time.Time
+	if strings.TrimSpace(*expiresAt) != "" {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*expiresAt))
+		if err != nil {
+			return fmt.Errorf("parse --expires-at: %w", err)
+		}
+		expiry = &parsed
+	}
+
+	_, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	created, err := svc.Create(ctx, apikeyservice.CreateRequest{
+		Description:       strings.TrimSpace(*description),
+		Owner:             strings.TrimSpace(*owner),
+		Labels:            labels,
+		ExpiresAt:         expiry,
+		ClientCertSubject: strings.TrimSpace(*clientCertSubject),
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, created)
+}
+
+func runKeyList(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("key list", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	unusedSince := fs.String("unused-since", "", "only active keys not used within this window, e.g. 30d")
+	selector := fs.String("selector", "", "only keys whose labels match, e.g. team=growth,environment!=prod")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse key list flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	var filter apikeyservice.ListFilter
+	if v := strings.TrimSpace(*unusedSince); v != "" {
+		window, err := parseSince(v)
+		if err != nil {
+			return fmt.Errorf("invalid --unused-since: %w", err)
+		}
+		filter.UnusedSince = time.Now().UTC().Add(-window)
+	}
+	sel, err := models.ParseLabelSelector(*selector)
+	if err != nil {
+		return fmt.Errorf("invalid --selector: %w", err)
+	}
+	filter.Selector = sel
+
+	_, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	items, err := svc.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]any{"items": items})
+}
+
+func runKeyRevoke(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("key revoke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	id := fs.String("id", "", "key id")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse key revoke flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	idv := strings.TrimSpace(*id)
+	if idv == "" {
+		return errors.New("--id is required")
+	}
+	_, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := svc.Revoke(ctx, idv); err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]string{"id": idv, "status": "revoked"})
+}
+
+func runKeyRotate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("key rotate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	id := fs.String("id", "", "key id")
+	description := fs.String("description", "", "new description")
+	expiresAt := fs.String("expires-at", "", "RFC3339 expiration timestamp")
+	grace := fs.Duration("grace-period", 5*time.Minute, "grace period before old key revocation")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse key rotate flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	idv := strings.TrimSpace(*id)
+	if idv == "" {
+		return errors.New("--id is required")
+	}
+
+	var expiry *time.Time
+	if strings.TrimSpace(*expiresAt) != "" {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*expiresAt))
+		if err != nil {
+			return fmt.Errorf("parse --expires-at: %w", err)
+		}
+		expiry = &parsed
+	}
+
+	desc := strings.TrimSpace(*description)
+	req := apikeyservice.RotateRequest{ID: idv, ExpiresAt: expiry, GracePeriod: *grace}
+	if desc != "" {
+		req.Description = &desc
+	}
+
+	_, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	rotated, err := svc.Rotate(ctx, req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, rotated)
+}
+
+func runKeyUpdate(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("key update", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	id := fs.String("id", "", "key id")
+	description := fs.String("description", "", "new description; empty clears it")
+	owner := fs.String("owner", "", "new owner; empty clears it")
+	labels := labelFlags{}
+	fs.Var(labels, "label", "name=value label to set, repeatable")
+	var remove stringList
+	fs.Var(&remove, "remove-label", "label name to remove, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse key update flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	idv := strings.TrimSpace(*id)
+	if idv == "" {
+		return errors.New("--id is required")
+	}
+
+	req := apikeyservice.UpdateRequest{ID: idv, SetLabels: labels, RemoveLabels: remove}
+	// Only flags that were passed change the key, so --owner "" clears the
+	// owner while leaving it out keeps it.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "description":
+			v := strings.TrimSpace(*description)
+			req.Description = &v
+		case "owner":
+			v := strings.TrimSpace(*owner)
+			req.Owner = &v
+		}
+	})
+
+	_, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	updated, err := svc.Update(ctx, req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, updated)
+}
+
+// labelFlags collects repeated name=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	parts := make([]string, 0, len(l))
+	for name, value := range l {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (l labelFlags) Set(s string) error {
+	name, value, err := models.ParseLabel(s)
+	if err != nil {
+		return err
+	}
+	l[name] = value
+	return nil
+}
+
+// stringList collects repeated flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, strings.TrimSpace(s))
+	return nil
+}
+
+// newCLIKeyService opens the key service for CLI commands and returns the
+// configuration it was built from.
+func newCLIKeyService(ctx context.Context, configFile string) (config.Config, func(), *apikeyservice.Service, error) {
+	cfg, err := loadCLIConfig(configFile)
+	if err != nil {
+		return config.Config{}, nil, nil, err
+	}
+	if err := config.ValidateEncryptionKeys(cfg); err != nil {
+		return config.Config{}, nil, nil, err
+	}
+	provider, err := newKeyProvider(cfg)
+	if err != nil {
+		return config.Config{}, nil, nil, err
+	}
+	secretCipher, err := newSecretCipher(ctx, provider)
+	if err != nil {
+		return config.Config{}, nil, nil, err
+	}
+
+	repos, cleanup, err := openRepositories(ctx, cfg)
+	if err != nil {
+		return config.Config{}, nil, nil, err
+	}
+	svc := apikeyservice.NewService(repos.APIKeys, apikeyservice.Config{SecretCipher: secretCipher, Activity: repos.APIKeyActivity, Metadata: repos.APIKeyMetadata})
+	return cfg, cleanup, svc, nil
+}
+
+func printKeyUsage(out io.Writer) error {
+	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server key create --description <text> [--owner <name>] [--label name=value ...] [--expires-at RFC3339] [--client-cert-subject DN]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server key list [--unused-since 30d] [--selector team=growth]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server key revoke --id <key-id>"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server key rotate --id <key-id> [--description <text>] [--expires-at RFC3339] [--grace-period 5m]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server key update --id <key-id> [--description <text>] [--owner <name>] [--label name=value ...] [--remove-label name ...]"); err != nil {
+		return err
+	}
+	return nil
+}
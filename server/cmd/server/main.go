@@ -2,34 +2,53 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jimeng-relay/server/internal/config"
-	relayhandler "github.com/jimeng-relay/server/internal/handler/relay"
+	adminhandler "github.com/jimeng-relay/server/internal/handler/admin"
+	authhandler "github.com/jimeng-relay/server/internal/handler/auth"
 	"github.com/jimeng-relay/server/internal/handler/health"
+	relayhandler "github.com/jimeng-relay/server/internal/handler/relay"
+	usagehandler "github.com/jimeng-relay/server/internal/handler/usage"
 	"github.com/jimeng-relay/server/internal/logging"
+	"github.com/jimeng-relay/server/internal/middleware/bearer"
+	"github.com/jimeng-relay/server/internal/middleware/drain"
 	"github.com/jimeng-relay/server/internal/middleware/observability"
 	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/relay/schema"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
 	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/repository/memory"
 	"github.com/jimeng-relay/server/internal/repository/postgres"
 	"github.com/jimeng-relay/server/internal/repository/sqlite"
 	"github.com/jimeng-relay/server/internal/secretcrypto"
-	apikeyservice "github.com/jimeng-relay/server/internal/service/apikey"
 	auditservice "github.com/jimeng-relay/server/internal/service/audit"
+	"github.com/jimeng-relay/server/internal/service/auditchain"
+	"github.com/jimeng-relay/server/internal/service/auditquery"
+	"github.com/jimeng-relay/server/internal/service/auditsink"
+	"github.com/jimeng-relay/server/internal/service/authtoken"
 	idempotencyservice "github.com/jimeng-relay/server/internal/service/idempotency"
+	"github.com/jimeng-relay/server/internal/service/keyactivity"
 	"github.com/jimeng-relay/server/internal/service/keymanager"
+	"github.com/jimeng-relay/server/internal/service/policy"
+	statsservice "github.com/jimeng-relay/server/internal/service/stats"
+	"github.com/jimeng-relay/server/internal/tlsconfig"
 )
 
 const (
@@ -38,6 +57,8 @@ const (
 	defaultWriteTimeout      = 60 * time.Second
 	defaultIdleTimeout       = 120 * time.Second
 	defaultMaxHeaderBytes    = 1 << 20
+
+	seenSignatureSweepInterval = time.Minute
 )
 
 func main() {
@@ -48,17 +69,32 @@ func main() {
 
 func run(args []string, out io.Writer) error {
 	if len(args) == 0 {
-		return runServer()
+		return runServer(config.Options{})
 	}
 
 	switch args[0] {
 	case "serve":
-		if len(args) > 1 {
-			return fmt.Errorf("unexpected arguments for serve: %s", strings.Join(args[1:], " "))
+		opts, err := parseServeFlags(args[1:])
+		if err != nil {
+			return err
 		}
-		return runServer()
+		return runServer(opts)
 	case "key":
 		return runKeyCommand(args[1:], out)
+	case "presign":
+		return runPresignCommand(args[1:], out)
+	case "audit":
+		return runAuditCommand(args[1:], out)
+	case "usage":
+		return runUsageCommand(args[1:], out)
+	case "stats":
+		return runStatsCommand(args[1:], out)
+	case "crypto":
+		return runCryptoCommand(args[1:], out)
+	case "migrate":
+		return runMigrateCommand(args[1:], out)
+	case "db":
+		return runDBCommand(args[1:], out)
 	case "help", "-h", "--help":
 		return printUsage(out)
 	default:
@@ -66,42 +102,166 @@ func run(args []string, out io.Writer) error {
 	}
 }
 
-func runServer() error {
-	cfg, err := config.Load(config.Options{})
+func parseServeFlags(args []string) (config.Options, error) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return config.Options{}, fmt.Errorf("parse serve flags: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return config.Options{}, fmt.Errorf("unexpected arguments for serve: %s", strings.Join(fs.Args(), " "))
+	}
+	return configOptions(*configFile), nil
+}
+
+func runServer(opts config.Options) error {
+	cfg, err := config.Load(opts)
 	if err != nil {
 		return fmt.Errorf("missing required configuration: %w", err)
 	}
 
-	logger := logging.NewLogger(slog.LevelInfo)
-	if strings.ToLower(os.Getenv("DEBUG")) == "true" {
-		logger = logging.NewLogger(slog.LevelDebug)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.LogLevel)
+	logger := logging.NewLogger(logLevel)
+	if cfg.LogLevel == slog.LevelDebug {
 		log.Printf("DEBUG mode enabled")
 	}
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	repos, cleanup, err := openRepositories(ctx, cfg)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
-	secretCipher, err := newSecretCipher(cfg.APIKeyEncryptionKey)
+	keyProvider, err := newKeyProvider(cfg)
+	if err != nil {
+		return err
+	}
+	secretCipher, err := newSecretCipher(ctx, keyProvider)
 	if err != nil {
 		return err
 	}
 
-	auditSvc := auditservice.NewService(repos.DownstreamRequests, repos.UpstreamAttempts, repos.AuditEvents, auditservice.Config{})
-	idempotencySvc := idempotencyservice.NewService(repos.IdempotencyRecords, idempotencyservice.Config{})
+	sinks, err := newAuditSinks(cfg, logger)
+	if err != nil {
+		return err
+	}
+	sinkEnqueuers := make([]auditsink.Enqueuer, 0, len(sinks))
+	for _, d := range sinks {
+		// Dispatchers outlive ctx so requests still draining after SIGTERM
+		// can be forwarded; they are closed once the server has stopped.
+		go d.Run(context.Background())
+		sinkEnqueuers = append(sinkEnqueuers, d)
+		log.Printf("Audit sink %s enabled", d.Name())
+	}
+	defer closeAuditSinks(sinks, cfg.ShutdownTimeout, logger)
+
+	auditSvc := auditservice.NewService(repos.DownstreamRequests, repos.UpstreamAttempts, repos.AuditEvents, auditservice.Config{
+		BodyModes: map[models.DownstreamAction]auditservice.BodyMode{
+			models.DownstreamActionCVSync2AsyncSubmitTask: auditservice.BodyMode(cfg.AuditBodySubmit),
+			models.DownstreamActionCVSync2AsyncGetResult:  auditservice.BodyMode(cfg.AuditBodyGetResult),
+		},
+		BodyMaxBytes: cfg.AuditBodyMaxBytes,
+		Sinks:        sinkEnqueuers,
+	})
+	if cfg.AuditCheckpointKey != "" {
+		chain, err := newAuditChainService(repos.AuditChain, cfg.AuditCheckpointKey, cfg.AuditCheckpointInterval, logger)
+		if err != nil {
+			return err
+		}
+		go chain.Run(ctx)
+		log.Printf("Audit chain checkpoints: every %s", cfg.AuditCheckpointInterval)
+	}
+	idempotencySvc := idempotencyservice.NewService(repos.IdempotencyRecords, idempotencyservice.Config{TTL: cfg.IdempotencyTTL})
 	keyManager := keymanager.NewService(logger)
-	upstreamClient, err := upstream.NewClient(cfg, upstream.Options{KeyManager: keyManager})
+	upstreamOpts := upstream.Options{KeyManager: keyManager}
+	if cfg.UpstreamCoordination == config.UpstreamCoordinationPostgres {
+		if repos.Coordinator == nil {
+			return fmt.Errorf("%s=%s is not supported by database_type %s", config.EnvUpstreamCoordination, cfg.UpstreamCoordination, cfg.DatabaseType)
+		}
+		upstreamOpts.Coordinator = repos.Coordinator
+	}
+	upstreamClient, err := upstream.NewClient(cfg, upstreamOpts)
 	if err != nil {
 		return fmt.Errorf("init upstream client: %w", err)
 	}
-	authn := sigv4.New(repos.APIKeys, sigv4.Config{SecretCipher: secretCipher, ExpectedRegion: cfg.Region, ExpectedService: "cv"})
+	var keyCache *sigv4.KeyCache
+	if cfg.APIKeyCacheTTL > 0 {
+		keyCache = sigv4.NewKeyCache(sigv4.KeyCacheConfig{TTL: cfg.APIKeyCacheTTL})
+		if cfg.APIKeyCacheSyncInterval > 0 {
+			go syncKeyCache(ctx, keyCache, repos.APIKeys, cfg.APIKeyCacheSyncInterval, logger)
+		}
+	}
+	var replayStore sigv4.ReplayStore
+	switch cfg.ReplayStore {
+	case config.ReplayStoreMemory:
+		replayStore = sigv4.NewMemoryReplayStore(nil)
+	case config.ReplayStoreDatabase:
+		replayStore = repos.SeenSignatures
+		go sweepSeenSignatures(ctx, repos.SeenSignatures, logger)
+	}
+	var replayExempt func(*http.Request) bool
+	if !cfg.ReplayProtectGetResult {
+		replayExempt = isGetResultRequest
+	}
+	var keyActivity sigv4.ActivityRecorder
+	if cfg.APIKeyActivityFlush > 0 {
+		recorder, err := keyactivity.NewRecorder(repos.APIKeyActivity, keyactivity.Config{FlushInterval: cfg.APIKeyActivityFlush, Logger: logger})
+		if err != nil {
+			return err
+		}
+		go recorder.Run(ctx)
+		// Deferred after the server stops, so drained requests are counted.
+		defer flushKeyActivity(recorder, cfg.ShutdownTimeout, logger)
+		keyActivity = recorder
+	}
+	authn := sigv4.New(repos.APIKeys, sigv4.Config{
+		SecretCipher:      secretCipher,
+		ExpectedRegion:    cfg.Region,
+		ExpectedService:   "cv",
+		KeyCache:          keyCache,
+		ReplayStore:       replayStore,
+		ReplayExempt:      replayExempt,
+		MaxPresignExpires: cfg.PresignMaxExpires,
+		Activity:          keyActivity,
+	})
 	app := http.NewServeMux()
-	submitRoutes := relayhandler.NewSubmitHandler(upstreamClient, auditSvc, idempotencySvc, repos.IdempotencyRecords, logger).Routes()
+	submitHandler := relayhandler.NewSubmitHandler(upstreamClient, auditSvc, idempotencySvc, repos.IdempotencyRecords, logger)
+	if cfg.SubmitValidation != config.SubmitValidationOff {
+		submitHandler.WithValidator(schema.NewValidator(schema.Config{Strict: cfg.SubmitValidation == config.SubmitValidationStrict}))
+	}
+	var policyLoader *policy.Loader
+	if cfg.PolicyFile != "" {
+		policyLoader, err = policy.NewLoader(cfg.PolicyFile, policy.LoaderConfig{ReloadInterval: cfg.PolicyReloadInterval, Logger: logger})
+		if err != nil {
+			return err
+		}
+		go policyLoader.Run(ctx)
+		submitHandler.WithPolicy(policyLoader)
+		log.Printf("Content policy: %s (version %s, reload every %s)", cfg.PolicyFile, policyLoader.Policy().Version(), cfg.PolicyReloadInterval)
+	}
+	submitRoutes := submitHandler.Routes()
 	getResultRoutes := relayhandler.NewGetResultHandler(upstreamClient, auditSvc, logger).Routes()
 	app.Handle("/v1/submit", submitRoutes)
 	app.Handle("/v1/get-result", getResultRoutes)
+	usageSvc, err := newUsageService(repos.Usage, cfg.UsagePriceFile)
+	if err != nil {
+		return err
+	}
+	if cfg.UsagePriceFile != "" {
+		log.Printf("Usage prices: %s", cfg.UsagePriceFile)
+	}
+	app.Handle("/v1/usage", usagehandler.NewHandler(usageSvc, logger).Routes())
+	if cfg.StatsRollupInterval > 0 {
+		statsSvc, err := statsservice.NewService(repos.StatsRollups, statsservice.Config{Interval: cfg.StatsRollupInterval, Logger: logger})
+		if err != nil {
+			return err
+		}
+		go statsSvc.Run(ctx)
+		log.Printf("Stats rollups: every %s", cfg.StatsRollupInterval)
+	}
 	app.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		action := r.URL.Query().Get("Action")
 		switch action {
@@ -114,19 +274,45 @@ func runServer() error {
 		}
 	})
 
+	relayAuth := authn
+	if cfg.AuthTokenSigningKey != "" {
+		tokenSvc, err := newAuthTokenService(repos.APIKeys, cfg)
+		if err != nil {
+			return err
+		}
+		app.Handle("/v1/auth/token", authhandler.NewTokenHandler(tokenSvc, logger).Routes())
+		relayAuth = bearer.New(tokenSvc, bearer.Config{Fallback: authn, ScopeFor: tokenScopeFor})
+	}
+
 	obs := observability.Middleware(logger)
 	mux := http.NewServeMux()
 
 	// Health endpoints (no auth required)
-	healthHandler := health.NewHandler(nil)
+	drainer := drain.New()
+	healthHandler := health.NewHandler(nil).WithDraining(drainer.Draining).WithChecks(health.ChecksConfig{
+		Checks:   readinessChecks(cfg, repos, secretCipher, keyProvider, upstreamClient),
+		Timeout:  cfg.ReadyCheckTimeout,
+		CacheTTL: cfg.ReadyCacheTTL,
+	})
 	mux.HandleFunc("/health", healthHandler.Health)
 	mux.HandleFunc("/ready", healthHandler.Ready)
 
-	mux.Handle("/", observability.RecoverMiddleware(logger)(obs(authn(app))))
+	// The admin API has its own token and stays off the relay's SigV4 path.
+	if cfg.AdminAPIToken != "" {
+		adminRoutes := adminhandler.NewHandler(auditquery.NewService(repos.AuditEvents, repos.DownstreamRequests, repos.APIKeys), cfg.AdminAPIToken, logger).Routes()
+		mux.Handle("/admin/", observability.RecoverMiddleware(logger)(obs(drainer.Middleware(adminRoutes))))
+	}
+	mux.Handle("/", observability.RecoverMiddleware(logger)(obs(drainer.Middleware(relayAuth(app)))))
 
 	log.Printf("Starting jimeng-relay server on port %s...", cfg.ServerPort)
 	log.Printf("Upstream concurrent limit: %d, queue size: %d", cfg.UpstreamMaxConcurrent, cfg.UpstreamMaxQueue)
 	log.Printf("Upstream submit min interval: %s", cfg.UpstreamSubmitMinInterval)
+	log.Printf("Upstream limit coordination: %s", cfg.UpstreamCoordination)
+	log.Printf("API key cache TTL: %s, sync every %s", cfg.APIKeyCacheTTL, cfg.APIKeyCacheSyncInterval)
+	log.Printf("SigV4 replay store: %s (get-result protected: %t)", cfg.ReplayStore, cfg.ReplayProtectGetResult)
+	log.Printf("Bearer tokens: %t (max ttl %s)", cfg.AuthTokenSigningKey != "", cfg.AuthTokenMaxTTL)
+	log.Printf("Submit schema validation: %s", cfg.SubmitValidation)
+	log.Printf("Admin API: %t", cfg.AdminAPIToken != "")
 	log.Printf("API key lifecycle is managed via CLI: ./jimeng-server key ...")
 	log.Printf("Registered relay submit routes: POST /v1/submit, POST /?Action=CVSync2AsyncSubmitTask")
 	log.Printf("Registered relay get-result routes: POST /v1/get-result, POST /?Action=CVSync2AsyncGetResult")
@@ -140,264 +326,533 @@ func runServer() error {
 		MaxHeaderBytes:    defaultMaxHeaderBytes,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
 		return fmt.Errorf("listen on :%s: %w", cfg.ServerPort, err)
 	}
-	return nil
+	var tlsReloader *tlsconfig.Reloader
+	if cfg.TLSCertFile != "" {
+		tlsReloader, err = tlsconfig.New(tlsconfig.Config{
+			CertFile:       cfg.TLSCertFile,
+			KeyFile:        cfg.TLSKeyFile,
+			MinVersion:     cfg.TLSMinVersion,
+			ClientCAFile:   cfg.TLSClientCAFile,
+			ClientAuth:     cfg.TLSClientAuth,
+			ReloadInterval: cfg.TLSReloadInterval,
+			Logger:         logger,
+		})
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("init tls: %w", err)
+		}
+		go tlsReloader.Run(ctx)
+		ln = tls.NewListener(ln, tlsReloader.TLSConfig())
+		log.Printf("TLS enabled: min version %s, client auth %s, reload every %s", cfg.TLSMinVersion, cfg.TLSClientAuth, cfg.TLSReloadInterval)
+	}
+	log.Printf("Graceful shutdown timeout: %s", cfg.ShutdownTimeout)
+	log.Printf("Readiness checks: timeout %s, cache %s, upstream probe %t", cfg.ReadyCheckTimeout, cfg.ReadyCacheTTL, cfg.ReadyUpstreamProbe)
+	reloader := &configReloader{opts: opts, current: cfg, upstream: upstreamClient, logLevel: logLevel, policy: policyLoader, tls: tlsReloader, logger: logger}
+	go reloader.Run(ctx)
+	log.Printf("SIGHUP reloads limits, log level, policy rules and TLS certificates")
+	return serve(ctx, srv, ln, drainer, cfg.ShutdownTimeout)
 }
 
-type repositories struct {
-	APIKeys            repository.APIKeyRepository
-	DownstreamRequests repository.DownstreamRequestRepository
-	UpstreamAttempts   repository.UpstreamAttemptRepository
-	AuditEvents        repository.AuditEventRepository
-	IdempotencyRecords repository.IdempotencyRecordRepository
+// configReloader re-reads configuration on SIGHUP and applies the settings that
+// are safe to change on a running server: upstream concurrency, queue size,
+// submit interval, log level, policy rules and TLS certificate files.
+// Anything else needs a restart.
+type configReloader struct {
+	opts     config.Options
+	current  config.Config
+	upstream *upstream.Client
+	logLevel *slog.LevelVar
+	policy   *policy.Loader
+	tls      *tlsconfig.Reloader
+	logger   *slog.Logger
 }
 
-func openRepositories(ctx context.Context, cfg config.Config) (repositories, func(), error) {
-	dbType := strings.ToLower(strings.TrimSpace(cfg.DatabaseType))
-	switch dbType {
-	case "", "sqlite":
-		repos, err := sqlite.Open(ctx, cfg.DatabaseURL)
-		if err != nil {
-			return repositories{}, nil, fmt.Errorf("open sqlite repository: %w", err)
-		}
-		cleanup := func() { _ = repos.Close() }
-		return repositories{APIKeys: repos.APIKeys, DownstreamRequests: repos.DownstreamRequests, UpstreamAttempts: repos.UpstreamAttempts, AuditEvents: repos.AuditEvents, IdempotencyRecords: repos.IdempotencyRecords}, cleanup, nil
-	case "postgres", "postgresql":
-		db, err := postgres.Open(ctx, cfg.DatabaseURL)
-		if err != nil {
-			return repositories{}, nil, fmt.Errorf("open postgres repository: %w", err)
+func (r *configReloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := r.Reload(); err != nil {
+				r.logger.Error("config reload failed; keeping current settings", "error", err.Error())
+			}
 		}
-		return repositories{APIKeys: db.APIKeys(), DownstreamRequests: db.DownstreamRequests(), UpstreamAttempts: db.UpstreamAttempts(), AuditEvents: db.AuditEvents(), IdempotencyRecords: db.IdempotencyRecords()}, db.Close, nil
-	default:
-		return repositories{}, nil, fmt.Errorf("unsupported database_type: %s", cfg.DatabaseType)
 	}
 }
 
-func newSecretCipher(encodedKey string) (secretcrypto.Cipher, error) {
-	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+// Reload loads the configuration again and applies it. An invalid file or
+// environment leaves every setting unchanged.
+func (r *configReloader) Reload() error {
+	next, err := config.Load(r.opts)
 	if err != nil {
-		return nil, fmt.Errorf("decode %s: %w", config.EnvAPIKeyEncryptionKey, err)
-	}
-	c, err := secretcrypto.NewAESCipher(raw)
-	if err != nil {
-		return nil, fmt.Errorf("init api key secret cipher: %w", err)
+		return err
 	}
-	return c, nil
-}
 
-func runKeyCommand(args []string, out io.Writer) error {
-	if len(args) == 0 {
-		return printKeyUsage(out)
+	r.upstream.SetLimits(next.UpstreamMaxConcurrent, next.UpstreamMaxQueue, next.UpstreamSubmitMinInterval)
+	r.logLevel.Set(next.LogLevel)
+
+	applied := r.current
+	applied.UpstreamMaxConcurrent = next.UpstreamMaxConcurrent
+	applied.UpstreamMaxQueue = next.UpstreamMaxQueue
+	applied.UpstreamSubmitMinInterval = next.UpstreamSubmitMinInterval
+	applied.LogLevel = next.LogLevel
+	if applied != next {
+		r.logger.Warn("config reload ignored settings that require a restart")
 	}
+	r.current = applied
 
-	switch args[0] {
-	case "help", "-h", "--help":
-		return printKeyUsage(out)
-	case "create":
-		ctx := context.Background()
-		_, cleanup, svc, err := newCLIKeyService(ctx)
-		if err != nil {
-			return err
-		}
-		defer cleanup()
-		return runKeyCreate(ctx, svc, args[1:], out)
-	case "list":
-		ctx := context.Background()
-		_, cleanup, svc, err := newCLIKeyService(ctx)
-		if err != nil {
-			return err
-		}
-		defer cleanup()
-		return runKeyList(ctx, svc, out)
-	case "revoke":
-		ctx := context.Background()
-		_, cleanup, svc, err := newCLIKeyService(ctx)
+	r.logger.Info("config reloaded",
+		"upstream_max_concurrent", next.UpstreamMaxConcurrent,
+		"upstream_max_queue", next.UpstreamMaxQueue,
+		"upstream_submit_min_interval", next.UpstreamSubmitMinInterval.String(),
+		"log_level", next.LogLevel.String(),
+	)
+
+	var errs []error
+	if r.policy != nil {
+		changed, err := r.policy.Reload()
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("reload policy: %w", err))
+		} else if changed {
+			r.logger.Info("policy reloaded", "version", r.policy.Policy().Version())
 		}
-		defer cleanup()
-		return runKeyRevoke(ctx, svc, args[1:], out)
-	case "rotate":
-		ctx := context.Background()
-		_, cleanup, svc, err := newCLIKeyService(ctx)
+	}
+	if r.tls != nil {
+		changed, err := r.tls.Reload()
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("reload tls: %w", err))
+		} else if changed {
+			r.logger.Info("tls certificate reloaded")
 		}
-		defer cleanup()
-		return runKeyRotate(ctx, svc, args[1:], out)
-	default:
-		return fmt.Errorf("unknown key subcommand %q", args[0])
 	}
+	return errors.Join(errs...)
 }
 
-func runKeyCreate(ctx context.Context, svc *apikeyservice.Service, args []string, out io.Writer) error {
-	fs := flag.NewFlagSet("key create", flag.ContinueOnError)
-	fs.SetOutput(io.Discard)
-	description := fs.String("description", "", "human-friendly description")
-	expiresAt := fs.String("expires-at", "", "RFC3339 expiration timestamp")
-	if err := fs.Parse(args); err != nil {
-		return fmt.Errorf("parse key create flags: %w", err)
+// serve runs srv until ctx is cancelled by SIGINT/SIGTERM, then drains: /ready
+// turns 503, new relay requests are refused, and accepted ones (including those
+// queued for an upstream slot) get up to timeout to finish before the remaining
+// connections are closed. Repositories are closed by the caller afterwards.
+// closeGrace bounds how long serve waits for handlers to return after the
+// drain deadline forces connections closed.
+var closeGrace = 5 * time.Second
+
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, drainer *drain.Drainer, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("serve on %s: %w", ln.Addr(), err)
+	case <-ctx.Done():
+	}
+
+	drainer.Start()
+	log.Printf("Shutdown requested; draining %d in-flight request(s) for up to %s", drainer.InFlight(), timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := drainer.Wait(shutdownCtx); err != nil {
+		log.Printf("Drain deadline reached with %d request(s) still in flight; closing connections", drainer.InFlight())
+		_ = srv.Close()
+		<-errCh
+		// Close cancels handler contexts but does not wait for the handlers.
+		// Give them a moment to write their audit and idempotency records
+		// before the caller closes the sinks and the database.
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), closeGrace)
+		defer cancelGrace()
+		if err := drainer.Wait(graceCtx); err != nil {
+			log.Printf("Abandoning %d request(s) still running %s after closing connections", drainer.InFlight(), closeGrace)
+		}
+		return nil
 	}
-	if fs.NArg() != 0 {
-		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
 	}
+	<-errCh
+	log.Printf("Shutdown complete")
+	return nil
+}
 
-	var expiry *time.Time
-	if strings.TrimSpace(*expiresAt) != "" {
-		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*expiresAt))
-		if err != nil {
-			return fmt.Errorf("parse --expires-at: %w", err)
-		}
-		expiry = &parsed
+// tokenScopeFor maps relay routes to the bearer token scope they require.
+// Everything else, including the token endpoint itself, needs SigV4.
+func tokenScopeFor(r *http.Request) string {
+	switch {
+	case isGetResultRequest(r):
+		return authtoken.ScopeGetResult
+	case r.URL.Path == "/v1/submit" || r.URL.Query().Get("Action") == "CVSync2AsyncSubmitTask":
+		return authtoken.ScopeSubmit
+	default:
+		return ""
 	}
+}
 
-	created, err := svc.Create(ctx, apikeyservice.CreateRequest{Description: strings.TrimSpace(*description), ExpiresAt: expiry})
+func newAuthTokenService(keys repository.APIKeyRepository, cfg config.Config) (*authtoken.Service, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.AuthTokenSigningKey))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("decode %s: %w", config.EnvAuthTokenSigningKey, err)
 	}
-	return writeJSON(out, created)
+	svc, err := authtoken.NewService(keys, authtoken.Config{SigningKey: raw, MaxTTL: cfg.AuthTokenMaxTTL})
+	if err != nil {
+		return nil, fmt.Errorf("init %s: %w", config.EnvAuthTokenSigningKey, err)
+	}
+	return svc, nil
 }
 
-func runKeyList(ctx context.Context, svc *apikeyservice.Service, out io.Writer) error {
-	items, err := svc.List(ctx)
+// newAuditChainService decodes the checkpoint key; an empty key yields a
+// service that verifies the chain but skips signature checks.
+func newAuditChainService(repo repository.AuditChainRepository, encodedKey string, interval time.Duration, logger *slog.Logger) (*auditchain.Service, error) {
+	var raw []byte
+	if encodedKey = strings.TrimSpace(encodedKey); encodedKey != "" {
+		var err error
+		raw, err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", config.EnvAuditCheckpointKey, err)
+		}
+	}
+	svc, err := auditchain.NewService(repo, auditchain.Config{Key: raw, Interval: interval, Logger: logger})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("init %s: %w", config.EnvAuditCheckpointKey, err)
 	}
-	return writeJSON(out, map[string]any{"items": items})
+	return svc, nil
 }
 
-func runKeyRevoke(ctx context.Context, svc *apikeyservice.Service, args []string, out io.Writer) error {
-	fs := flag.NewFlagSet("key revoke", flag.ContinueOnError)
-	fs.SetOutput(io.Discard)
-	id := fs.String("id", "", "key id")
-	if err := fs.Parse(args); err != nil {
-		return fmt.Errorf("parse key revoke flags: %w", err)
+// newAuditSinks builds a dispatcher for each configured audit sink. Each sink
+// spools to its own subdirectory of AUDIT_SINK_SPOOL_DIR.
+func newAuditSinks(cfg config.Config, logger *slog.Logger) ([]*auditsink.Dispatcher, error) {
+	type configured struct {
+		sink   auditsink.Sink
+		policy string
 	}
-	if fs.NArg() != 0 {
-		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	var sinks []configured
+	if cfg.AuditSinkFilePath != "" {
+		s, err := auditsink.NewFileSink(auditsink.FileConfig{Path: cfg.AuditSinkFilePath, MaxBytes: cfg.AuditSinkFileMaxBytes, MaxBackups: cfg.AuditSinkFileMaxBackups})
+		if err != nil {
+			return nil, fmt.Errorf("init %s: %w", config.EnvAuditSinkFilePath, err)
+		}
+		sinks = append(sinks, configured{s, cfg.AuditSinkFilePolicy})
 	}
-	idv := strings.TrimSpace(*id)
-	if idv == "" {
-		return errors.New("--id is required")
+	if cfg.AuditSinkSyslogAddr != "" {
+		s, err := auditsink.NewSyslogSink(auditsink.SyslogConfig{Address: cfg.AuditSinkSyslogAddr, AppName: cfg.AuditSinkSyslogAppName, MaxMessageBytes: cfg.AuditSinkSyslogMaxBytes})
+		if err != nil {
+			return nil, fmt.Errorf("init %s: %w", config.EnvAuditSinkSyslogAddr, err)
+		}
+		sinks = append(sinks, configured{s, cfg.AuditSinkSyslogPolicy})
 	}
-	if err := svc.Revoke(ctx, idv); err != nil {
-		return err
+	if cfg.AuditSinkWebhookURL != "" {
+		s, err := auditsink.NewWebhookSink(auditsink.WebhookConfig{URL: cfg.AuditSinkWebhookURL, Token: cfg.AuditSinkWebhookToken, Timeout: cfg.AuditSinkWebhookTimeout, MaxEntryBytes: cfg.AuditSinkWebhookMaxBytes})
+		if err != nil {
+			return nil, fmt.Errorf("init %s: %w", config.EnvAuditSinkWebhookURL, err)
+		}
+		sinks = append(sinks, configured{s, cfg.AuditSinkWebhookPolicy})
 	}
-	return writeJSON(out, map[string]string{"id": idv, "status": "revoked"})
-}
 
-func runKeyRotate(ctx context.Context, svc *apikeyservice.Service, args []string, out io.Writer) error {
-	fs := flag.NewFlagSet("key rotate", flag.ContinueOnError)
-	fs.SetOutput(io.Discard)
-	id := fs.String("id", "", "key id")
-	description := fs.String("description", "", "new description")
-	expiresAt := fs.String("expires-at", "", "RFC3339 expiration timestamp")
-	grace := fs.Duration("grace-period", 5*time.Minute, "grace period before old key revocation")
-	if err := fs.Parse(args); err != nil {
-		return fmt.Errorf("parse key rotate flags: %w", err)
+	dispatchers := make([]*auditsink.Dispatcher, 0, len(sinks))
+	for _, c := range sinks {
+		policy, err := auditsink.ParsePolicy(c.policy)
+		if err != nil {
+			return nil, err
+		}
+		d, err := auditsink.NewDispatcher(c.sink, auditsink.DispatcherConfig{
+			Policy:        policy,
+			QueueSize:     cfg.AuditSinkQueueSize,
+			BatchSize:     cfg.AuditSinkBatchSize,
+			FlushInterval: cfg.AuditSinkFlushInterval,
+			SpoolDir:      filepath.Join(cfg.AuditSinkSpoolDir, c.sink.Name()),
+			SpoolMaxBytes: cfg.AuditSinkSpoolMaxBytes,
+			Logger:        logger,
+		})
+		if err != nil {
+			return nil, err
+		}
+		dispatchers = append(dispatchers, d)
 	}
-	if fs.NArg() != 0 {
-		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	return dispatchers, nil
+}
+
+// closeAuditSinks flushes queued entries to each sink, or to its spool.
+func closeAuditSinks(sinks []*auditsink.Dispatcher, timeout time.Duration, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, d := range sinks {
+		if err := d.Close(ctx); err != nil {
+			logger.Error("close audit sink failed", "sink", d.Name(), "error", err.Error())
+		}
+		st := d.Stats()
+		logger.Info("audit sink closed", "sink", d.Name(), "delivered", st.Delivered, "spooled", st.Spooled, "dropped", st.Dropped)
 	}
-	idv := strings.TrimSpace(*id)
-	if idv == "" {
-		return errors.New("--id is required")
+}
+
+// flushKeyActivity writes the key usage counted since the last flush.
+func flushKeyActivity(recorder *keyactivity.Recorder, timeout time.Duration, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := recorder.Flush(ctx); err != nil {
+		logger.Error("flush api key activity failed", "error", err.Error())
 	}
+}
 
-	var expiry *time.Time
-	if strings.TrimSpace(*expiresAt) != "" {
-		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*expiresAt))
-		if err != nil {
-			return fmt.Errorf("parse --expires-at: %w", err)
+// isGetResultRequest matches both get-result routes so they can skip replay checks.
+func isGetResultRequest(r *http.Request) bool {
+	return r.URL.Path == "/v1/get-result" || r.URL.Query().Get("Action") == "CVSync2AsyncGetResult"
+}
+
+// syncKeyCache periodically drops cached keys that were revoked, rotated or
+// edited by another process, such as the key CLI or another replica.
+func syncKeyCache(ctx context.Context, cache *sigv4.KeyCache, repo repository.APIKeyRepository, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := repo.List(ctx)
+			if err != nil {
+				logger.WarnContext(ctx, "list api keys for cache sync failed", "error", err)
+				continue
+			}
+			if n := cache.Sync(keys); n > 0 {
+				logger.InfoContext(ctx, "dropped changed api keys from cache", "count", n)
+			}
 		}
-		expiry = &parsed
 	}
+}
 
-	desc := strings.TrimSpace(*description)
-	req := apikeyservice.RotateRequest{ID: idv, ExpiresAt: expiry, GracePeriod: *grace}
-	if desc != "" {
-		req.Description = &desc
+// sweepSeenSignatures periodically deletes signatures that can no longer be replayed.
+func sweepSeenSignatures(ctx context.Context, repo repository.SeenSignatureRepository, logger *slog.Logger) {
+	ticker := time.NewTicker(seenSignatureSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := repo.DeleteExpired(ctx, time.Now().UTC()); err != nil {
+				logger.WarnContext(ctx, "delete expired request signatures failed", "error", err)
+			}
+		}
 	}
+}
 
-	rotated, err := svc.Rotate(ctx, req)
+type repositories struct {
+	APIKeys            repository.APIKeyRepository
+	APIKeyActivity     repository.APIKeyActivityRepository
+	DownstreamRequests repository.DownstreamRequestRepository
+	UpstreamAttempts   repository.UpstreamAttemptRepository
+	AuditEvents        repository.AuditEventRepository
+	AuditChain         repository.AuditChainRepository
+	IdempotencyRecords repository.IdempotencyRecordRepository
+	SeenSignatures     repository.SeenSignatureRepository
+	Usage              repository.UsageRepository
+	StatsRollups       repository.StatsRollupRepository
+	APIKeySecrets      repository.APIKeySecretRepository
+	APIKeyMetadata     repository.APIKeyMetadataRepository
+	Migrations         repository.Migrator
+	Bulk               repository.BulkRepository
+	// Coordinator is only available on backends that can share limits across replicas.
+	Coordinator upstream.Coordinator
+	Ping        func(ctx context.Context) error
+}
+
+// openRepositories opens the configured database. Pending migrations are
+// applied unless DATABASE_AUTO_MIGRATE is off, in which case they make the
+// open fail instead of running against an outdated schema.
+func openRepositories(ctx context.Context, cfg config.Config) (repositories, func(), error) {
+	repos, cleanup, err := openDatabase(ctx, cfg, cfg.DatabaseAutoMigrate)
 	if err != nil {
-		return err
+		return repositories{}, nil, err
+	}
+	if !cfg.DatabaseAutoMigrate {
+		statuses, err := repos.Migrations.MigrationStatus(ctx)
+		if err != nil {
+			cleanup()
+			return repositories{}, nil, fmt.Errorf("check schema migrations: %w", err)
+		}
+		if version, pending := schemaVersion(statuses); pending > 0 {
+			cleanup()
+			return repositories{}, nil, fmt.Errorf("database schema is at version %d with %d pending migrations; run jimeng-server migrate up", version, pending)
+		}
 	}
-	return writeJSON(out, rotated)
+	return repos, cleanup, nil
 }
 
-func newCLIKeyService(ctx context.Context) (repositories, func(), *apikeyservice.Service, error) {
-	// Load .env file if it exists (same as server mode)
-	if err := config.LoadEnvFile(".env"); err != nil {
-		return repositories{}, nil, nil, fmt.Errorf("load env file: %w", err)
+func openDatabase(ctx context.Context, cfg config.Config, migrate bool) (repositories, func(), error) {
+	dbType := strings.ToLower(strings.TrimSpace(cfg.DatabaseType))
+	switch dbType {
+	case "", "sqlite":
+		repos, err := sqlite.OpenWithOptions(ctx, cfg.DatabaseURL, sqlite.OpenOptions{SkipMigrations: !migrate})
+		if err != nil {
+			return repositories{}, nil, fmt.Errorf("open sqlite repository: %w", err)
+		}
+		cleanup := func() { _ = repos.Close() }
+		return repositories{APIKeys: repos.APIKeys, APIKeyActivity: repos.APIKeys, DownstreamRequests: repos.DownstreamRequests, UpstreamAttempts: repos.UpstreamAttempts, AuditEvents: repos.AuditEvents, AuditChain: repos.AuditEvents, IdempotencyRecords: repos.IdempotencyRecords, SeenSignatures: repos.SeenSignatures, Usage: repos.Usage, StatsRollups: repos.StatsRollups, APIKeySecrets: repos.APIKeys, APIKeyMetadata: repos.APIKeys, Migrations: repos.Migrations, Bulk: repos.Bulk, Ping: repos.Ping}, cleanup, nil
+	case "postgres", "postgresql":
+		db, err := postgres.OpenWithOptions(ctx, cfg.DatabaseURL, postgres.OpenOptions{SkipMigrations: !migrate})
+		if err != nil {
+			return repositories{}, nil, fmt.Errorf("open postgres repository: %w", err)
+		}
+		repos := repositories{APIKeys: db.APIKeys(), APIKeyActivity: db.APIKeyActivity(), DownstreamRequests: db.DownstreamRequests(), UpstreamAttempts: db.UpstreamAttempts(), AuditEvents: db.AuditEvents(), AuditChain: db.AuditChain(), IdempotencyRecords: db.IdempotencyRecords(), SeenSignatures: db.SeenSignatures(), Usage: db.Usage(), StatsRollups: db.StatsRollups(), APIKeySecrets: db.APIKeySecrets(), APIKeyMetadata: db.APIKeyMetadata(), Migrations: db.Migrations(), Bulk: db.Bulk(), Ping: db.Ping}
+		if cfg.UpstreamCoordination != config.UpstreamCoordinationPostgres {
+			return repos, db.Close, nil
+		}
+		// Slot holders are bounded by the local concurrency limit, so a lock
+		// pool of that size never makes them wait for a connection.
+		coord, err := db.Coordinator(postgres.CoordinatorOptions{MaxConns: int32(max(cfg.UpstreamMaxConcurrent, 1))})
+		if err != nil {
+			db.Close()
+			return repositories{}, nil, fmt.Errorf("open upstream coordinator: %w", err)
+		}
+		repos.Coordinator = coord
+		return repos, func() { coord.Close(); db.Close() }, nil
+	case "memory":
+		// DATABASE_URL is ignored and everything is lost when the process exits.
+		repos := memory.New()
+		return repositories{APIKeys: repos.APIKeys, APIKeyActivity: repos.APIKeys, DownstreamRequests: repos.DownstreamRequests, UpstreamAttempts: repos.UpstreamAttempts, AuditEvents: repos.AuditEvents, AuditChain: repos.AuditEvents, IdempotencyRecords: repos.IdempotencyRecords, SeenSignatures: repos.SeenSignatures, Usage: repos.Usage, StatsRollups: repos.StatsRollups, APIKeySecrets: repos.APIKeys, APIKeyMetadata: repos.APIKeys, Migrations: repos.Migrations, Bulk: repos.Bulk, Ping: repos.Ping}, func() {}, nil
+	default:
+		return repositories{}, nil, fmt.Errorf("unsupported database_type: %s", cfg.DatabaseType)
 	}
+}
+
+// readinessChecks builds the /ready probes. The database and cipher gate
+// readiness; queue saturation is informational and the upstream dial is opt-in.
+func readinessChecks(cfg config.Config, repos repositories, secretCipher secretcrypto.Cipher, keyProvider secretcrypto.KeyProvider, upstreamClient *upstream.Client) []health.Check {
+	checks := []health.Check{
+		{Name: "database", Run: func(ctx context.Context) (map[string]any, error) {
+			return map[string]any{"type": cfg.DatabaseType}, repos.Ping(ctx)
+		}},
+		{Name: "cipher", Run: func(context.Context) (map[string]any, error) {
+			return nil, checkCipher(secretCipher)
+		}},
+		{Name: "upstream_queue", Run: func(context.Context) (map[string]any, error) {
+			stats := upstreamClient.QueueStats()
+			return map[string]any{
+				"active":         stats.Active,
+				"max_concurrent": stats.MaxConcurrent,
+				"queued":         stats.Queued,
+				"max_queue":      stats.MaxQueue,
+				"saturated":      stats.Saturated,
+			}, nil
+		}},
+	}
+	// The cipher keeps its keys once built, so losing KMS access is reported
+	// without failing readiness. The provider cache bounds calls to one per TTL.
+	if kms, ok := keyProvider.(*secretcrypto.KMSProvider); ok {
+		checks = append(checks, health.Check{Name: "key_provider", Optional: true, Run: func(ctx context.Context) (map[string]any, error) {
+			_, err := kms.Keys(ctx)
+			return map[string]any{"provider": config.KeyProviderKMS}, err
+		}})
+	}
+	if cfg.ReadyUpstreamProbe {
+		checks = append(checks, health.Check{Name: "upstream", Optional: true, Run: func(ctx context.Context) (map[string]any, error) {
+			return nil, upstreamClient.Probe(ctx)
+		}})
+	}
+	return checks
+}
 
-	cfg := config.Config{
-		DatabaseType: strings.TrimSpace(os.Getenv(config.EnvDatabaseType)),
-		DatabaseURL:  strings.TrimSpace(os.Getenv(config.EnvDatabaseURL)),
+func checkCipher(c secretcrypto.Cipher) error {
+	const probe = "readiness-probe"
+	ciphertext, err := c.Encrypt(probe)
+	if err != nil {
+		return err
 	}
-	if cfg.DatabaseType == "" {
-		cfg.DatabaseType = "sqlite"
+	plaintext, err := c.Decrypt(ciphertext)
+	if err != nil {
+		return err
 	}
-	if cfg.DatabaseURL == "" {
-		cfg.DatabaseURL = "./jimeng-relay.db"
+	if plaintext != probe {
+		return fmt.Errorf("cipher round trip mismatch")
 	}
-	cfg.APIKeyEncryptionKey = strings.TrimSpace(os.Getenv(config.EnvAPIKeyEncryptionKey))
-	if cfg.APIKeyEncryptionKey == "" {
-		return repositories{}, nil, nil, fmt.Errorf("%s is required", config.EnvAPIKeyEncryptionKey)
+	return nil
+}
+
+// newKeyProvider selects where the master key comes from: the environment,
+// a key file, or data keys unwrapped by a KMS.
+func newKeyProvider(cfg config.Config) (secretcrypto.KeyProvider, error) {
+	switch cfg.APIKeyKeyProvider {
+	case config.KeyProviderFile:
+		return secretcrypto.FileProvider{Path: cfg.APIKeyKeyFile}, nil
+	case config.KeyProviderKMS:
+		wrapped, err := secretcrypto.ParseKeys(cfg.APIKeyKMSWrappedKeys)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", config.EnvAPIKeyKMSWrappedKeys, err)
+		}
+		ttl := cfg.APIKeyKMSCacheTTL
+		if ttl == 0 {
+			ttl = -1 // 0 in config disables the cache
+		}
+		p, err := secretcrypto.NewKMSProvider(secretcrypto.KMSConfig{
+			Endpoint:    cfg.APIKeyKMSEndpoint,
+			KeyID:       cfg.APIKeyKMSKeyID,
+			Token:       cfg.APIKeyKMSToken,
+			WrappedKeys: wrapped,
+			CacheTTL:    ttl,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("init kms key provider: %w", err)
+		}
+		return p, nil
+	default:
+		return secretcrypto.EnvProvider{Single: cfg.APIKeyEncryptionKey, Keyring: cfg.APIKeyEncryptionKeys}, nil
 	}
+}
 
-	repos, cleanup, err := openRepositories(ctx, cfg)
+// newSecretCipher builds a keyring when the provider names its keys, and
+// otherwise a single-key cipher that keeps writing v1 ciphertexts.
+func newSecretCipher(ctx context.Context, provider secretcrypto.KeyProvider) (secretcrypto.Cipher, error) {
+	keys, err := provider.Keys(ctx)
 	if err != nil {
-		return repositories{}, nil, nil, err
+		return nil, fmt.Errorf("load api key encryption keys: %w", err)
 	}
-	secretCipher, err := newSecretCipher(cfg.APIKeyEncryptionKey)
+	c, err := secretcrypto.NewCipher(keys)
 	if err != nil {
-		cleanup()
-		return repositories{}, nil, nil, err
+		return nil, fmt.Errorf("init api key secret cipher: %w", err)
 	}
-	svc := apikeyservice.NewService(repos.APIKeys, apikeyservice.Config{SecretCipher: secretCipher})
-	return repos, cleanup, svc, nil
-}
-
-func writeJSON(out io.Writer, v any) error {
-	enc := json.NewEncoder(out)
-	enc.SetIndent("", "  ")
-	return enc.Encode(v)
+	return c, nil
 }
 
 func printUsage(out io.Writer) error {
 	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server serve"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server serve [--config config.yaml]"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server key <create|list|revoke|rotate> [flags]"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server key <create|list|revoke|rotate|update> [flags]"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server help"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server presign --id <key-id> --url <relay-url> [--expires 15m] [--method GET]"); err != nil {
 		return err
 	}
-	return nil
-}
-
-func printKeyUsage(out io.Writer) error {
-	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server audit <verify|checkpoint>"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server usage report [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--key <key-id>] [--selector <sel>] [--group-by <label>] [--format json|csv]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server stats [--since 24h] [--key <key-id>] [--format table|json]"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server key create --description <text> [--expires-at RFC3339]"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server crypto <rekey|generate-data-key>"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server key list"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server migrate <up|down|status>"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server key revoke --id <key-id>"); err != nil {
+	if _, err := fmt.Fprintln(out, "  jimeng-server db copy --from <url> --to <url>"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server help"); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintln(out, "  jimeng-server key rotate --id <key-id> [--description <text>] [--expires-at RFC3339] [--grace-period 5m]"); err != nil {
+	if _, err := fmt.Fprintln(out, "Every command takes --config config.yaml to read the same configuration as serve."); err != nil {
 		return err
 	}
 	return nil
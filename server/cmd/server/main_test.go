@@ -2,10 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jimeng-relay/server/internal/config"
+	"github.com/jimeng-relay/server/internal/handler/health"
+	"github.com/jimeng-relay/server/internal/middleware/drain"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/relay/upstream"
+	"github.com/jimeng-relay/server/internal/repository/sqlite"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +33,7 @@ func TestRunServer_MissingConfig(t *testing.T) {
 	os.Clearenv()
 
 	// Attempt to run server without config
-	err := runServer()
+	err := runServer(config.Options{})
 
 	// Should return error due to missing required config
 	assert.Error(t, err)
@@ -44,3 +63,763 @@ func TestRun_UnknownCommand(t *testing.T) {
 	assert.Error(t, err)
 	assert.True(t, strings.Contains(err.Error(), "unknown command"))
 }
+
+func TestRun_PresignWithCreatedKey(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("DATABASE_URL", filepath.Join(t.TempDir(), "relay.db"))
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"key", "create", "--description", "presign"}, &out))
+	var created struct {
+		ID        string `json:"id"`
+		AccessKey string `json:"access_key"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &created))
+
+	out.Reset()
+	err := run([]string{"presign", "--id", created.ID, "--url", "https://relay.example.com/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1", "--expires", "5m"}, &out)
+	assert.NoError(t, err)
+	var signed struct {
+		URL string `json:"url"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &signed))
+	u, err := url.Parse(signed.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "300", u.Query().Get("X-Expires"))
+	assert.True(t, strings.HasPrefix(u.Query().Get("X-Credential"), created.AccessKey+"/"))
+	assert.NotEmpty(t, u.Query().Get("X-Signature"))
+
+	err = run([]string{"presign", "--id", created.ID, "--url", "https://relay.example.com/v1/get-result", "--expires", "2h"}, &out)
+	assert.Error(t, err)
+
+	// The lifetime cap and region come from the config file like the server's.
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("volc:\n  region: cn-test-1\nsecurity:\n  sigv4_presign_max_expires: 3h\n"), 0o600))
+	out.Reset()
+	assert.NoError(t, run([]string{"presign", "--id", created.ID, "--url", "https://relay.example.com/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1", "--expires", "2h", "--config", configPath}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &signed))
+	u, err = url.Parse(signed.URL)
+	assert.NoError(t, err)
+	assert.Contains(t, u.Query().Get("X-Credential"), "/cn-test-1/cv/")
+}
+
+func TestRun_KeyListUsage(t *testing.T) {
+	os.Clearenv()
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	t.Setenv("DATABASE_URL", dbPath)
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -60)
+	repos, err := sqlite.Open(ctx, dbPath)
+	assert.NoError(t, err)
+	for _, id := range []string{"key_idle", "key_busy"} {
+		assert.NoError(t, repos.APIKeys.Create(ctx, models.APIKey{
+			ID:                  id,
+			AccessKey:           "ak_" + id,
+			SecretKeyHash:       "hash",
+			SecretKeyCiphertext: "cipher",
+			CreatedAt:           old,
+			UpdatedAt:           old,
+			Status:              models.APIKeyStatusActive,
+		}))
+	}
+	assert.NoError(t, repos.APIKeys.RecordActivity(ctx, []models.APIKeyActivity{
+		{APIKeyID: "key_busy", Day: now.AddDate(0, 0, -10).Truncate(24 * time.Hour), Requests: 4, LastUsedAt: now.AddDate(0, 0, -10), LastUsedIP: "10.0.0.1"},
+		{APIKeyID: "key_busy", Day: now.Truncate(24 * time.Hour), Requests: 2, LastUsedAt: now, LastUsedIP: "10.0.0.2"},
+	}))
+	assert.NoError(t, repos.Close())
+
+	var listed struct {
+		Items []struct {
+			ID           string     `json:"id"`
+			LastUsedAt   *time.Time `json:"last_used_at"`
+			LastUsedIP   string     `json:"last_used_ip"`
+			RequestCount int64      `json:"request_count"`
+			Requests7d   int64      `json:"requests_7d"`
+			Requests30d  int64      `json:"requests_30d"`
+		} `json:"items"`
+	}
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"key", "list"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &listed))
+	for _, item := range listed.Items {
+		if item.ID != "key_busy" {
+			continue
+		}
+		assert.Equal(t, "10.0.0.2", item.LastUsedIP)
+		assert.NotNil(t, item.LastUsedAt)
+		assert.Equal(t, int64(6), item.RequestCount)
+		assert.Equal(t, int64(2), item.Requests7d)
+		assert.Equal(t, int64(6), item.Requests30d)
+	}
+
+	out.Reset()
+	listed.Items = nil
+	assert.NoError(t, run([]string{"key", "list", "--unused-since", "30d"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &listed))
+	if assert.Len(t, listed.Items, 1) {
+		assert.Equal(t, "key_idle", listed.Items[0].ID)
+	}
+
+	assert.Error(t, run([]string{"key", "list", "--unused-since", "soon"}, &out))
+}
+
+func TestRun_KeyLabels(t *testing.T) {
+	os.Clearenv()
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	t.Setenv("DATABASE_URL", dbPath)
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	type keyJSON struct {
+		ID     string            `json:"id"`
+		Owner  string            `json:"owner"`
+		Labels map[string]string `json:"labels"`
+	}
+	create := func(args ...string) keyJSON {
+		t.Helper()
+		var out bytes.Buffer
+		assert.NoError(t, run(append([]string{"key", "create"}, args...), &out))
+		var k keyJSON
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &k))
+		return k
+	}
+	growth := create("--owner", "alice", "--label", "team=growth", "--label", "environment=prod")
+	search := create("--label", "team=search")
+	assert.Equal(t, "alice", growth.Owner)
+	assert.Equal(t, map[string]string{"team": "growth", "environment": "prod"}, growth.Labels)
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"key", "update", "--id", search.ID, "--owner", "bob", "--label", "environment=staging"}, &out))
+	var updated keyJSON
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &updated))
+	assert.Equal(t, "bob", updated.Owner)
+	assert.Equal(t, map[string]string{"team": "search", "environment": "staging"}, updated.Labels)
+
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "update", "--id", growth.ID, "--owner", "", "--remove-label", "environment"}, &out))
+	updated = keyJSON{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &updated))
+	assert.Empty(t, updated.Owner)
+	assert.Equal(t, map[string]string{"team": "growth"}, updated.Labels)
+
+	var listed struct {
+		Items []keyJSON `json:"items"`
+	}
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "list", "--selector", "environment"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &listed))
+	if assert.Len(t, listed.Items, 1) {
+		assert.Equal(t, search.ID, listed.Items[0].ID)
+	}
+
+	ctx := context.Background()
+	repos, err := sqlite.Open(ctx, dbPath)
+	assert.NoError(t, err)
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	for i, keyID := range []string{growth.ID, search.ID, search.ID} {
+		id := fmt.Sprintf("req-%d", i)
+		assert.NoError(t, repos.DownstreamRequests.Create(ctx, models.DownstreamRequest{
+			ID: "d-" + id, RequestID: id, APIKeyID: keyID, Action: models.DownstreamActionCVSync2AsyncSubmitTask,
+			Method: "POST", Path: "/v1/submit", Body: map[string]any{"req_key": "jimeng_t2i_v40"}, ReceivedAt: at,
+		}))
+		assert.NoError(t, repos.UpstreamAttempts.Create(ctx, models.UpstreamAttempt{
+			ID: "u-" + id, RequestID: id, AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: 200, SentAt: at,
+		}))
+	}
+	assert.NoError(t, repos.Close())
+
+	out.Reset()
+	assert.NoError(t, run([]string{"usage", "report", "--from", "2026-03-01", "--to", "2026-03-31", "--group-by", "team", "--selector", "team!=billing", "--format", "csv"}, &out))
+	assert.Equal(t, "day,team,req_key,requests,images,video_seconds,frames,cost,currency,unpriced\n"+
+		"2026-03-01,growth,jimeng_t2i_v40,1,1,0,0,0,,1\n"+
+		"2026-03-01,search,jimeng_t2i_v40,2,2,0,0,0,,2\n", out.String())
+
+	assert.Error(t, run([]string{"key", "create", "--label", "Team=growth"}, &out))
+	assert.Error(t, run([]string{"key", "update", "--id", growth.ID}, &out))
+	assert.Error(t, run([]string{"key", "list", "--selector", "team=="}, &out))
+	assert.Error(t, run([]string{"usage", "report", "--group-by", "Team"}, &out))
+}
+
+func TestRun_AuditCheckpointAndVerify(t *testing.T) {
+	os.Clearenv()
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	t.Setenv("DATABASE_URL", dbPath)
+	t.Setenv("AUDIT_CHECKPOINT_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+
+	ctx := context.Background()
+	repos, err := sqlite.Open(ctx, dbPath)
+	assert.NoError(t, err)
+	for _, id := range []string{"aevt_1", "aevt_2"} {
+		assert.NoError(t, repos.AuditEvents.Create(ctx, &models.AuditEvent{
+			ID:        id,
+			RequestID: "req-" + id,
+			EventType: models.EventTypeRequestReceived,
+			Actor:     "system",
+			Action:    "relay_call",
+			Resource:  "relay.call",
+			CreatedAt: time.Now().UTC(),
+		}))
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"audit", "checkpoint"}, &out))
+	assert.Contains(t, out.String(), `"created": true`)
+
+	out.Reset()
+	assert.NoError(t, run([]string{"audit", "verify"}, &out))
+	assert.Contains(t, out.String(), `"ok": true`)
+
+	// A key set only in the config file is used too.
+	os.Unsetenv("AUDIT_CHECKPOINT_KEY")
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("audit:\n  checkpoint_key: ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=\n"), 0o600))
+	out.Reset()
+	assert.NoError(t, run([]string{"audit", "verify", "--config", configPath}, &out))
+	assert.Contains(t, out.String(), `"signatures_checked": true`)
+
+	// Without the key, checkpoints are only skipped when asked to.
+	out.Reset()
+	assert.Error(t, run([]string{"audit", "verify"}, &out))
+	out.Reset()
+	assert.NoError(t, run([]string{"audit", "verify", "--no-signatures"}, &out))
+	assert.Contains(t, out.String(), `"signatures_checked": false`)
+	t.Setenv("AUDIT_CHECKPOINT_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+
+	_, err = repos.DB.ExecContext(ctx, `UPDATE audit_events SET actor = 'someone-else' WHERE id = 'aevt_1'`)
+	assert.NoError(t, err)
+	assert.NoError(t, repos.Close())
+
+	out.Reset()
+	err = run([]string{"audit", "verify"}, &out)
+	assert.Error(t, err)
+	assert.Contains(t, out.String(), `"kind": "modified"`)
+}
+
+func TestRun_UsageReport(t *testing.T) {
+	os.Clearenv()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "relay.db")
+	pricePath := filepath.Join(dir, "prices.yaml")
+	t.Setenv("DATABASE_URL", dbPath)
+	assert.NoError(t, os.WriteFile(pricePath, []byte("currency: CNY\nmodels:\n  jimeng_t2v_v30:\n    per_second: 0.28\n"), 0o600))
+
+	ctx := context.Background()
+	repos, err := sqlite.Open(ctx, dbPath)
+	assert.NoError(t, err)
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	for _, id := range []string{"req-1", "req-2"} {
+		assert.NoError(t, repos.DownstreamRequests.Create(ctx, models.DownstreamRequest{
+			ID: "d-" + id, RequestID: id, APIKeyID: "key_1", Action: models.DownstreamActionCVSync2AsyncSubmitTask,
+			Method: "POST", Path: "/v1/submit", Body: map[string]any{"req_key": "jimeng_t2v_v30", "frames": 121}, ReceivedAt: at,
+		}))
+		assert.NoError(t, repos.UpstreamAttempts.Create(ctx, models.UpstreamAttempt{
+			ID: "u-" + id, RequestID: id, AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: 200, SentAt: at,
+		}))
+	}
+	assert.NoError(t, repos.Close())
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"usage", "report", "--from", "2026-03-01", "--to", "2026-03-31", "--format", "csv", "--prices", pricePath}, &out))
+	assert.Contains(t, out.String(), "2026-03-01,key_1,jimeng_t2v_v30,2,0,10,242,2.8,CNY,0")
+
+	// The price file set in the config file is used without --prices.
+	configPath := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("usage:\n  price_file: "+pricePath+"\n"), 0o600))
+	out.Reset()
+	assert.NoError(t, run([]string{"usage", "report", "--from", "2026-03-01", "--to", "2026-03-31", "--format", "csv", "--config", configPath}, &out))
+	assert.Contains(t, out.String(), "2026-03-01,key_1,jimeng_t2v_v30,2,0,10,242,2.8,CNY,0")
+
+	out.Reset()
+	t.Setenv("USAGE_PRICE_FILE", pricePath)
+	assert.NoError(t, run([]string{"usage", "report", "--from", "2026-03-01", "--to", "2026-03-31", "--key", "key_2"}, &out))
+	assert.Contains(t, out.String(), `"rows": []`)
+
+	assert.Error(t, run([]string{"usage", "report", "--format", "xml"}, &out))
+}
+
+func TestRun_Stats(t *testing.T) {
+	os.Clearenv()
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	t.Setenv("DATABASE_URL", dbPath)
+
+	ctx := context.Background()
+	repos, err := sqlite.Open(ctx, dbPath)
+	assert.NoError(t, err)
+	at := time.Now().UTC().Add(-3 * time.Hour)
+	assert.NoError(t, repos.DownstreamRequests.Create(ctx, models.DownstreamRequest{
+		ID: "d-req-1", RequestID: "req-1", APIKeyID: "key_1", Action: models.DownstreamActionCVSync2AsyncSubmitTask,
+		Method: "POST", Path: "/v1/submit", ReceivedAt: at,
+	}))
+	for i, status := range []int{503, 200} {
+		assert.NoError(t, repos.UpstreamAttempts.Create(ctx, models.UpstreamAttempt{
+			ID: fmt.Sprintf("u-%d", i), RequestID: "req-1", AttemptNumber: i + 1, UpstreamAction: "CVSync2AsyncSubmitTask",
+			ResponseStatus: status, LatencyMs: int64(100 * (i + 1)), SentAt: at,
+		}))
+	}
+	assert.NoError(t, repos.Close())
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"stats", "--since", "1d", "--format", "json"}, &out))
+	var rollups []models.StatsRollup
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &rollups))
+	assert.Len(t, rollups, 2)
+	assert.Equal(t, "2xx", rollups[0].StatusClass)
+	assert.Equal(t, int64(1), rollups[0].Retries)
+	assert.Equal(t, "5xx", rollups[1].StatusClass)
+
+	out.Reset()
+	assert.NoError(t, run([]string{"stats", "--since", "24h", "--key", "key_1"}, &out))
+	assert.Contains(t, out.String(), "TOTAL")
+	assert.Contains(t, out.String(), "key_1")
+
+	out.Reset()
+	assert.NoError(t, run([]string{"stats", "--since", "1h", "--format", "json"}, &out))
+	assert.Equal(t, "[]\n", out.String())
+
+	assert.Error(t, run([]string{"stats", "--since", "soon"}, &out))
+	assert.Error(t, run([]string{"stats", "--format", "xml"}, &out))
+}
+
+func TestRun_CryptoRekey(t *testing.T) {
+	os.Clearenv()
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	t.Setenv("DATABASE_URL", dbPath)
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"key", "create", "--description", "rekey"}, &out))
+	var created struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &created))
+
+	// Without a keyring there is nothing to rotate to.
+	assert.Error(t, run([]string{"crypto", "rekey"}, &out))
+
+	os.Unsetenv("API_KEY_ENCRYPTION_KEY")
+	t.Setenv("API_KEY_ENCRYPTION_KEYS", "old:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=,new:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	out.Reset()
+	assert.NoError(t, run([]string{"crypto", "rekey"}, &out))
+	var result struct {
+		PrimaryKeyID string `json:"primary_key_id"`
+		Total        int64  `json:"total"`
+		Rekeyed      int64  `json:"rekeyed"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, "new", result.PrimaryKeyID)
+	assert.Equal(t, int64(1), result.Total)
+	assert.Equal(t, int64(1), result.Rekeyed)
+
+	repos, err := sqlite.Open(context.Background(), dbPath)
+	assert.NoError(t, err)
+	key, err := repos.APIKeys.GetByID(context.Background(), created.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, repos.Close())
+	assert.True(t, strings.HasPrefix(key.SecretKeyCiphertext, "v2:new:"), key.SecretKeyCiphertext)
+
+	// The old key can now be dropped: presign still decrypts the secret.
+	t.Setenv("API_KEY_ENCRYPTION_KEYS", "new:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	out.Reset()
+	assert.NoError(t, run([]string{"presign", "--id", created.ID, "--url", "https://relay.example.com/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1"}, &out))
+
+	out.Reset()
+	assert.NoError(t, run([]string{"crypto", "rekey"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, int64(0), result.Rekeyed)
+}
+
+func TestRun_KMSKeyProvider(t *testing.T) {
+	// The stand-in KMS "wraps" by reversing the key bytes.
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	reverse := func(b []byte) []byte {
+		out := make([]byte, len(b))
+		for i := range b {
+			out[len(b)-1-i] = b[i]
+		}
+		return out
+	}
+	var unwraps int
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CiphertextBlob string `json:"ciphertext_blob"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v1/generate-data-key":
+			_ = json.NewEncoder(w).Encode(map[string]string{"ciphertext_blob": base64.StdEncoding.EncodeToString(reverse(dataKey))})
+		case "/v1/decrypt":
+			unwraps++
+			blob, _ := base64.StdEncoding.DecodeString(req.CiphertextBlob)
+			_ = json.NewEncoder(w).Encode(map[string]string{"plaintext": base64.StdEncoding.EncodeToString(reverse(blob))})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer kms.Close()
+
+	os.Clearenv()
+	t.Setenv("DATABASE_URL", filepath.Join(t.TempDir(), "relay.db"))
+	t.Setenv("API_KEY_KEY_PROVIDER", "kms")
+	t.Setenv("API_KEY_KMS_ENDPOINT", kms.URL)
+	t.Setenv("API_KEY_KMS_KEY_ID", "relay-master")
+	t.Setenv("API_KEY_KMS_WRAPPED_KEYS", "bootstrap:AA==")
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"crypto", "generate-data-key", "--id", "dk1"}, &out))
+	var generated struct {
+		Entry string `json:"entry"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &generated))
+	assert.True(t, strings.HasPrefix(generated.Entry, "dk1:"), generated.Entry)
+	assert.NotContains(t, out.String(), base64.StdEncoding.EncodeToString(dataKey))
+
+	t.Setenv("API_KEY_KMS_WRAPPED_KEYS", generated.Entry)
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "create", "--description", "kms"}, &out))
+	var created struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &created))
+	out.Reset()
+	assert.NoError(t, run([]string{"presign", "--id", created.ID, "--url", "https://relay.example.com/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1"}, &out))
+	assert.Equal(t, 2, unwraps)
+
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	assert.Error(t, run([]string{"key", "list"}, &out))
+}
+
+func TestRun_Migrate(t *testing.T) {
+	os.Clearenv()
+	t.Setenv("DATABASE_URL", filepath.Join(t.TempDir(), "relay.db"))
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	t.Setenv("DATABASE_AUTO_MIGRATE", "false")
+
+	var out bytes.Buffer
+	err := run([]string{"key", "list"}, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "migrate up")
+	}
+
+	var result struct {
+		Applied    []int `json:"applied"`
+		Reverted   []int `json:"reverted"`
+		Version    int   `json:"version"`
+		Pending    int   `json:"pending"`
+		Migrations []struct {
+			Version int  `json:"version"`
+			Applied bool `json:"applied"`
+		} `json:"migrations"`
+	}
+	assert.NoError(t, run([]string{"migrate", "status"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, 0, result.Version)
+	assert.Positive(t, result.Pending)
+
+	out.Reset()
+	assert.NoError(t, run([]string{"migrate", "up"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, result.Applied)
+	assert.Equal(t, 0, result.Pending)
+
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "list"}, &out))
+
+	out.Reset()
+	assert.NoError(t, run([]string{"migrate", "down", "--steps", "1"}, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, []int{6}, result.Reverted)
+	assert.Equal(t, 5, result.Version)
+
+	assert.Error(t, run([]string{"migrate", "sideways"}, &out))
+}
+
+func TestRun_CommandsReadConfigFile(t *testing.T) {
+	os.Clearenv()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	dbPath := filepath.Join(dir, "configured.db")
+	path := filepath.Join(dir, "config.yaml")
+	body := "database:\n  url: " + dbPath + "\n  auto_migrate: false\nsecurity:\n  api_key_encryption_key: MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, run([]string{"migrate", "up", "--config", path}, &out))
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "create", "--description", "configured", "--config", path}, &out))
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "list", "--config", path}, &out))
+	assert.Contains(t, out.String(), "configured")
+	assert.FileExists(t, dbPath)
+	assert.NoFileExists(t, filepath.Join(dir, "jimeng-relay.db"))
+
+	// Without --config the command does not see the file's settings.
+	assert.Error(t, run([]string{"key", "list"}, &out))
+}
+
+func TestRun_DBCopy(t *testing.T) {
+	os.Clearenv()
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.db")
+	t.Setenv("DATABASE_URL", srcPath)
+	t.Setenv("API_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	var out bytes.Buffer
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, run([]string{"key", "create", "--description", "copy me", "--label", "team=growth"}, &out))
+	}
+
+	var report struct {
+		Tables []struct {
+			Table           string `json:"table"`
+			SourceRows      int64  `json:"source_rows"`
+			DestinationRows int64  `json:"destination_rows"`
+			Copied          int64  `json:"copied"`
+			Skipped         int64  `json:"skipped"`
+		} `json:"tables"`
+	}
+	args := []string{"db", "copy", "--from", "sqlite://" + srcPath, "--to", "sqlite://" + filepath.Join(dir, "dst.db"), "--batch-size", "2"}
+	out.Reset()
+	assert.NoError(t, run(args, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	if assert.NotEmpty(t, report.Tables) {
+		assert.Equal(t, "api_keys", report.Tables[0].Table)
+		assert.Equal(t, int64(3), report.Tables[0].Copied)
+		assert.Equal(t, int64(3), report.Tables[0].DestinationRows)
+	}
+
+	// A second run finds everything in place and copies nothing.
+	out.Reset()
+	assert.NoError(t, run(args, &out))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, int64(0), report.Tables[0].Copied)
+	assert.Equal(t, int64(3), report.Tables[0].Skipped)
+
+	// The copied keys are usable from the destination.
+	t.Setenv("DATABASE_URL", filepath.Join(dir, "dst.db"))
+	out.Reset()
+	assert.NoError(t, run([]string{"key", "list"}, &out))
+	assert.Equal(t, 3, strings.Count(out.String(), "copy me"))
+	assert.Equal(t, 3, strings.Count(out.String(), `"team": "growth"`))
+
+	assert.Error(t, run([]string{"db", "copy", "--from", "mysql://x", "--to", "sqlite://" + srcPath}, &out))
+	assert.Error(t, run([]string{"db", "copy", "--from", "sqlite://" + srcPath, "--to", "sqlite://" + srcPath}, &out))
+}
+
+func TestNewAuditSinks_OnePerDestinationWithOwnSpool(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{
+		AuditSinkFilePath:      filepath.Join(dir, "audit.jsonl"),
+		AuditSinkFilePolicy:    config.AuditSinkBestEffort,
+		AuditSinkWebhookURL:    "https://siem.example.com/ingest",
+		AuditSinkWebhookPolicy: config.AuditSinkFailClosed,
+		AuditSinkSpoolDir:      filepath.Join(dir, "spool"),
+	}
+	sinks, err := newAuditSinks(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+	if assert.Len(t, sinks, 2) {
+		assert.Equal(t, "file", sinks[0].Name())
+		assert.Equal(t, "webhook", sinks[1].Name())
+	}
+	assert.DirExists(t, filepath.Join(dir, "spool", "file"))
+	assert.DirExists(t, filepath.Join(dir, "spool", "webhook"))
+
+	cfg.AuditSinkSyslogAddr = "localhost:514"
+	_, err = newAuditSinks(cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestServe_DrainsInFlightRequestsOnShutdown(t *testing.T) {
+	drainer := drain.New()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", health.NewHandler(nil).WithDraining(drainer.Draining).Ready)
+	mux.Handle("/", drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	base := "http://" + ln.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, &http.Server{Handler: mux}, ln, drainer, 5*time.Second) }()
+
+	inFlight := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(base+"/v1/submit", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	<-entered
+
+	cancel()
+	assert.Eventually(t, drainer.Draining, time.Second, 5*time.Millisecond)
+
+	resp, err := http.Get(base + "/ready")
+	if err != nil {
+		t.Fatalf("GET /ready: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = http.Post(base+"/v1/submit", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("POST during drain: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-inFlight)
+	assert.NoError(t, <-serveErr)
+}
+
+func TestServe_ClosesConnectionsAfterDeadline(t *testing.T) {
+	drainer := drain.New()
+	entered := make(chan struct{})
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-r.Context().Done()
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, &http.Server{Handler: handler}, ln, drainer, 50*time.Millisecond) }()
+
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/v1/get-result"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+
+	start := time.Now()
+	cancel()
+	assert.NoError(t, <-serveErr)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestServe_WaitsForHandlersAfterClosingConnections(t *testing.T) {
+	drainer := drain.New()
+	entered := make(chan struct{})
+	var finished atomic.Bool
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-r.Context().Done()
+		// Stands in for the audit write a cancelled handler still makes.
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, &http.Server{Handler: handler}, ln, drainer, 50*time.Millisecond) }()
+
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/v1/get-result"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+
+	cancel()
+	assert.NoError(t, <-serveErr)
+	assert.True(t, finished.Load(), "serve returned before the handler finished")
+	assert.Equal(t, 0, drainer.InFlight())
+}
+
+func TestServe_AbandonsHandlersAfterCloseGrace(t *testing.T) {
+	grace := closeGrace
+	closeGrace = 50 * time.Millisecond
+	t.Cleanup(func() { closeGrace = grace })
+
+	drainer := drain.New()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	handler := drainer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, &http.Server{Handler: handler}, ln, drainer, 50*time.Millisecond) }()
+
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/v1/get-result"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+
+	start := time.Now()
+	cancel()
+	assert.NoError(t, <-serveErr)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, 1, drainer.InFlight())
+}
+
+func TestConfigReloader_AppliesSafeSettings(t *testing.T) {
+	os.Clearenv()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(body string) {
+		t.Helper()
+		base := "volc:\n  access_key: ak\n  secret_key: sk\nsecurity:\n  api_key_encryption_key: MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"
+		if err := os.WriteFile(path, []byte(base+body), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	writeConfig("limits:\n  upstream_max_concurrent: 1\n  upstream_max_queue: 10\n")
+
+	opts := config.Options{ConfigFile: &path}
+	cfg, err := config.Load(opts)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	client, err := upstream.NewClient(cfg, upstream.Options{})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	level := new(slog.LevelVar)
+	r := &configReloader{opts: opts, current: cfg, upstream: client, logLevel: level, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	writeConfig("limits:\n  upstream_max_concurrent: 4\n  upstream_max_queue: 50\nlogging:\n  level: debug\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	st := client.QueueStats()
+	assert.Equal(t, 4, st.MaxConcurrent)
+	assert.Equal(t, 50, st.MaxQueue)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	writeConfig("limits:\n  upstream_max_concurrent: nope\n")
+	assert.Error(t, r.Reload())
+	assert.Equal(t, 4, client.QueueStats().MaxConcurrent)
+}
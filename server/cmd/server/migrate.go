@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+func runMigrateCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return printMigrateUsage(out)
+	}
+
+	var to, steps int
+	var configFile *string
+	switch args[0] {
+	case "help", "-h", "--help":
+		return printMigrateUsage(out)
+	case "up", "down", "status":
+		fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		configFile = configFlag(fs)
+		switch args[0] {
+		case "up":
+			fs.IntVar(&to, "to", 0, "stop after this version (default: latest)")
+		case "down":
+			fs.IntVar(&steps, "steps", 1, "number of migrations to revert")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("parse migrate %s flags: %w", args[0], err)
+		}
+		if fs.NArg() != 0 {
+			return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+		}
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q", args[0])
+	}
+
+	ctx := context.Background()
+	cfg, err := loadCLIConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	repos, cleanup, err := openDatabase(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	result := map[string]any{}
+	switch args[0] {
+	case "up":
+		applied, err := repos.Migrations.MigrateUp(ctx, to)
+		if err != nil {
+			return err
+		}
+		result["applied"] = nonNilInts(applied)
+	case "down":
+		reverted, err := repos.Migrations.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		result["reverted"] = nonNilInts(reverted)
+	}
+	statuses, err := repos.Migrations.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	version, pending := schemaVersion(statuses)
+	result["version"] = version
+	result["pending"] = pending
+	if args[0] == "status" {
+		result["migrations"] = statuses
+	}
+	return writeJSON(out, result)
+}
+
+// schemaVersion returns the highest applied migration and how many known
+// migrations are not applied.
+func schemaVersion(statuses []repository.MigrationStatus) (version, pending int) {
+	for _, st := range statuses {
+		if st.Applied && st.Version > version {
+			version = st.Version
+		}
+		if !st.Applied {
+			pending++
+		}
+	}
+	return version, pending
+}
+
+func nonNilInts(v []int) []int {
+	if v == nil {
+		return []int{}
+	}
+	return v
+}
+
+func printMigrateUsage(out io.Writer) error {
+	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server migrate up [--to <version>]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server migrate down [--steps 1]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server migrate status"); err != nil {
+		return err
+	}
+	return nil
+}
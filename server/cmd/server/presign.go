@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/config"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+)
+
+func runPresignCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("presign", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	id := fs.String("id", "", "key id whose credentials sign the url")
+	target := fs.String("url", "", "absolute relay url, e.g. https://relay.example.com/v1/get-result?req_key=...&task_id=...")
+	method := fs.String("method", http.MethodGet, "GET or HEAD")
+	expires := fs.Duration("expires", 15*time.Minute, "url lifetime")
+	configFile := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse presign flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	idv := strings.TrimSpace(*id)
+	if idv == "" {
+		return errors.New("--id is required")
+	}
+	if strings.TrimSpace(*target) == "" {
+		return errors.New("--url is required")
+	}
+
+	ctx := context.Background()
+	cfg, cleanup, svc, err := newCLIKeyService(ctx, *configFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// Refuse lifetimes the server would reject rather than hand out a dead link.
+	if *expires > cfg.PresignMaxExpires {
+		return fmt.Errorf("--expires %s exceeds %s (%s)", *expires, config.EnvPresignMaxExpires, cfg.PresignMaxExpires)
+	}
+
+	creds, err := svc.Credentials(ctx, idv)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	signed, err := sigv4.PresignURL(sigv4.PresignRequest{
+		Method:    *method,
+		URL:       *target,
+		AccessKey: creds.AccessKey,
+		SecretKey: creds.SecretKey,
+		Region:    cfg.Region,
+		Service:   "cv",
+		Expires:   *expires,
+		Now:       now,
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]any{"url": signed, "expires_at": now.Add(*expires).Truncate(time.Second)})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	statsservice "github.com/jimeng-relay/server/internal/service/stats"
+)
+
+func runStatsCommand(args []string, out io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "--help":
+			return printStatsUsage(out)
+		}
+	}
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	since := fs.String("since", "24h", "how far back to report, e.g. 90m, 24h or 7d")
+	keyID := fs.String("key", "", "only report this api key id")
+	format := fs.String("format", "table", "table or json")
+	configFile := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("parse stats flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	f := strings.ToLower(strings.TrimSpace(*format))
+	if f != "table" && f != "json" {
+		return fmt.Errorf("--format must be table or json, got %q", *format)
+	}
+	window, err := parseSince(*since)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+
+	ctx := context.Background()
+	cfg, err := loadCLIConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	repos, cleanup, err := openRepositories(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	svc, err := statsservice.NewService(repos.StatsRollups, statsservice.Config{})
+	if err != nil {
+		return err
+	}
+	// Bring the rollups up to date first, so the report does not depend on
+	// a server having run the job recently.
+	if _, err := svc.Rollup(ctx); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	rollups, err := svc.List(ctx, now.Add(-window), now, strings.TrimSpace(*keyID))
+	if err != nil {
+		return err
+	}
+	if f == "json" {
+		if rollups == nil {
+			rollups = []models.StatsRollup{}
+		}
+		return writeJSON(out, rollups)
+	}
+	return statsservice.WriteTable(out, rollups)
+}
+
+// parseSince parses a look-back window as a Go duration or a whole number of
+// days such as 7d.
+func parseSince(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	var d time.Duration
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("expected a duration such as 24h or 7d, got %q", v)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("expected a duration such as 24h or 7d, got %q", v)
+		}
+		d = parsed
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be greater than zero, got %q", v)
+	}
+	return d, nil
+}
+
+func printStatsUsage(out io.Writer) error {
+	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server stats [--since 24h] [--key <key-id>] [--format table|json]"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  --since takes a duration (90m, 24h) or days (7d); rollups are per UTC hour"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/config"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+	usageservice "github.com/jimeng-relay/server/internal/service/usage"
+)
+
+func runUsageCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return printUsageCommandUsage(out)
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		return printUsageCommandUsage(out)
+	case "report":
+		fs := flag.NewFlagSet("usage report", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		from := fs.String("from", "", "start date (YYYY-MM-DD or RFC3339), default start of this month")
+		to := fs.String("to", "", "end date, inclusive for YYYY-MM-DD (default now)")
+		keyID := fs.String("key", "", "only report this api key id")
+		format := fs.String("format", "json", "json or csv")
+		prices := fs.String("prices", "", "price file (default "+config.EnvUsagePriceFile+")")
+		selector := fs.String("selector", "", "only keys whose labels match, e.g. environment=prod")
+		groupBy := fs.String("group-by", "", "sum usage per value of this key label, e.g. team")
+		configFile := configFlag(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("parse usage report flags: %w", err)
+		}
+		if fs.NArg() != 0 {
+			return fmt.Errorf("unexpected positional arguments: %s", strings.Join(fs.Args(), " "))
+		}
+		f := strings.ToLower(strings.TrimSpace(*format))
+		if f != "json" && f != "csv" {
+			return fmt.Errorf("--format must be json or csv, got %q", *format)
+		}
+		start, end, err := usageservice.ParseRange(*from, *to, time.Now())
+		if err != nil {
+			return err
+		}
+		labelQuery := usageservice.LabelQuery{GroupBy: strings.TrimSpace(*groupBy)}
+		if labelQuery.Selector, err = models.ParseLabelSelector(*selector); err != nil {
+			return fmt.Errorf("invalid --selector: %w", err)
+		}
+		if labelQuery.GroupBy != "" {
+			if err := models.ValidateLabelName(labelQuery.GroupBy); err != nil {
+				return fmt.Errorf("invalid --group-by: %w", err)
+			}
+		}
+
+		ctx := context.Background()
+		cfg, err := loadCLIConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		priceFile := strings.TrimSpace(*prices)
+		if priceFile == "" {
+			priceFile = cfg.UsagePriceFile
+		}
+		repos, cleanup, err := openRepositories(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		svc, err := newUsageService(repos.Usage, priceFile)
+		if err != nil {
+			return err
+		}
+		report, err := svc.Report(ctx, start, end, strings.TrimSpace(*keyID))
+		if err != nil {
+			return err
+		}
+		if !labelQuery.Selector.Empty() || labelQuery.GroupBy != "" {
+			keys, err := repos.APIKeys.List(ctx)
+			if err != nil {
+				return fmt.Errorf("list api keys: %w", err)
+			}
+			report = usageservice.ApplyLabels(report, keys, labelQuery)
+		}
+		if f == "csv" {
+			return usageservice.WriteCSV(out, report)
+		}
+		return writeJSON(out, report)
+	default:
+		return fmt.Errorf("unknown usage subcommand %q", args[0])
+	}
+}
+
+// newUsageService loads priceFile when set; without one usage is still
+// counted but reported as unpriced.
+func newUsageService(repo repository.UsageRepository, priceFile string) (*usageservice.Service, error) {
+	var prices *usageservice.PriceTable
+	if priceFile != "" {
+		var err error
+		if prices, err = usageservice.LoadPriceFile(priceFile); err != nil {
+			return nil, fmt.Errorf("load %s: %w", config.EnvUsagePriceFile, err)
+		}
+	}
+	return usageservice.NewService(repo, prices), nil
+}
+
+func printUsageCommandUsage(out io.Writer) error {
+	if _, err := fmt.Fprintln(out, "Usage:"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "  jimeng-server usage report [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--key <key-id>] [--selector <sel>] [--group-by <label>] [--format json|csv] [--prices prices.yaml]"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -19,12 +19,72 @@ const (
 	EnvPort                      = "PORT"
 	EnvDatabaseType              = "DATABASE_TYPE"
 	EnvDatabaseURL               = "DATABASE_URL"
+	EnvDatabaseAutoMigrate       = "DATABASE_AUTO_MIGRATE"
 	EnvAPIKeyEncryptionKey       = "API_KEY_ENCRYPTION_KEY"
+	EnvAPIKeyEncryptionKeys      = "API_KEY_ENCRYPTION_KEYS"
+	EnvAPIKeyKeyProvider         = "API_KEY_KEY_PROVIDER"
+	EnvAPIKeyKeyFile             = "API_KEY_KEY_FILE"
+	EnvAPIKeyKMSEndpoint         = "API_KEY_KMS_ENDPOINT"
+	EnvAPIKeyKMSKeyID            = "API_KEY_KMS_KEY_ID"
+	EnvAPIKeyKMSToken            = "API_KEY_KMS_TOKEN"
+	EnvAPIKeyKMSWrappedKeys      = "API_KEY_KMS_WRAPPED_KEYS"
+	EnvAPIKeyKMSCacheTTL         = "API_KEY_KMS_CACHE_TTL"
 	EnvUpstreamMaxConcurrent     = "UPSTREAM_MAX_CONCURRENT"
 	EnvUpstreamMaxQueue          = "UPSTREAM_MAX_QUEUE"
 	EnvUpstreamSubmitMinInterval = "UPSTREAM_SUBMIT_MIN_INTERVAL"
 	EnvPerKeyMaxConcurrent       = "PER_KEY_MAX_CONCURRENT"
 	EnvPerKeyMaxQueue            = "PER_KEY_MAX_QUEUE"
+	EnvUpstreamCoordination      = "UPSTREAM_COORDINATION"
+	EnvAPIKeyCacheTTL            = "API_KEY_CACHE_TTL"
+	EnvAPIKeyCacheSyncInterval   = "API_KEY_CACHE_SYNC_INTERVAL"
+	EnvAPIKeyActivityFlush       = "API_KEY_ACTIVITY_FLUSH_INTERVAL"
+	EnvReplayStore               = "SIGV4_REPLAY_STORE"
+	EnvReplayProtectGetResult    = "SIGV4_REPLAY_PROTECT_GET_RESULT"
+	EnvPresignMaxExpires         = "SIGV4_PRESIGN_MAX_EXPIRES"
+	EnvAuthTokenSigningKey       = "AUTH_TOKEN_SIGNING_KEY"
+	EnvAuthTokenMaxTTL           = "AUTH_TOKEN_MAX_TTL"
+	EnvAdminAPIToken             = "ADMIN_API_TOKEN"
+	EnvSubmitValidation          = "SUBMIT_VALIDATION"
+	EnvPolicyFile                = "POLICY_FILE"
+	EnvPolicyReloadInterval      = "POLICY_RELOAD_INTERVAL"
+	EnvShutdownTimeout           = "SHUTDOWN_TIMEOUT"
+	EnvReadyCheckTimeout         = "READY_CHECK_TIMEOUT"
+	EnvReadyCacheTTL             = "READY_CACHE_TTL"
+	EnvReadyUpstreamProbe        = "READY_UPSTREAM_PROBE"
+	EnvIdempotencyTTL            = "IDEMPOTENCY_TTL"
+	EnvLogLevel                  = "LOG_LEVEL"
+	EnvDebug                     = "DEBUG"
+	EnvTLSCertFile               = "TLS_CERT_FILE"
+	EnvTLSKeyFile                = "TLS_KEY_FILE"
+	EnvTLSMinVersion             = "TLS_MIN_VERSION"
+	EnvTLSClientCAFile           = "TLS_CLIENT_CA_FILE"
+	EnvTLSClientAuth             = "TLS_CLIENT_AUTH"
+	EnvTLSReloadInterval         = "TLS_RELOAD_INTERVAL"
+	EnvAuditBodySubmit           = "AUDIT_BODY_SUBMIT"
+	EnvAuditBodyGetResult        = "AUDIT_BODY_GET_RESULT"
+	EnvAuditBodyMaxBytes         = "AUDIT_BODY_MAX_BYTES"
+	EnvAuditCheckpointKey        = "AUDIT_CHECKPOINT_KEY"
+	EnvAuditCheckpointInterval   = "AUDIT_CHECKPOINT_INTERVAL"
+	EnvAuditSinkFilePath         = "AUDIT_SINK_FILE_PATH"
+	EnvAuditSinkFileMaxBytes     = "AUDIT_SINK_FILE_MAX_BYTES"
+	EnvAuditSinkFileMaxBackups   = "AUDIT_SINK_FILE_MAX_BACKUPS"
+	EnvAuditSinkFilePolicy       = "AUDIT_SINK_FILE_POLICY"
+	EnvAuditSinkSyslogAddr       = "AUDIT_SINK_SYSLOG_ADDR"
+	EnvAuditSinkSyslogAppName    = "AUDIT_SINK_SYSLOG_APP_NAME"
+	EnvAuditSinkSyslogPolicy     = "AUDIT_SINK_SYSLOG_POLICY"
+	EnvAuditSinkSyslogMaxBytes   = "AUDIT_SINK_SYSLOG_MAX_BYTES"
+	EnvAuditSinkWebhookURL       = "AUDIT_SINK_WEBHOOK_URL"
+	EnvAuditSinkWebhookToken     = "AUDIT_SINK_WEBHOOK_TOKEN"
+	EnvAuditSinkWebhookTimeout   = "AUDIT_SINK_WEBHOOK_TIMEOUT"
+	EnvAuditSinkWebhookPolicy    = "AUDIT_SINK_WEBHOOK_POLICY"
+	EnvAuditSinkWebhookMaxBytes  = "AUDIT_SINK_WEBHOOK_MAX_BYTES"
+	EnvAuditSinkQueueSize        = "AUDIT_SINK_QUEUE_SIZE"
+	EnvAuditSinkBatchSize        = "AUDIT_SINK_BATCH_SIZE"
+	EnvAuditSinkFlushInterval    = "AUDIT_SINK_FLUSH_INTERVAL"
+	EnvAuditSinkSpoolDir         = "AUDIT_SINK_SPOOL_DIR"
+	EnvAuditSinkSpoolMaxBytes    = "AUDIT_SINK_SPOOL_MAX_BYTES"
+	EnvUsagePriceFile            = "USAGE_PRICE_FILE"
+	EnvStatsRollupInterval       = "STATS_ROLLUP_INTERVAL"
 )
 
 const (
@@ -34,6 +94,7 @@ const (
 	DefaultServerPort                = "8080"
 	DefaultDatabaseType              = "sqlite"
 	DefaultDatabaseURL               = "./jimeng-relay.db"
+	DefaultDatabaseAutoMigrate       = true
 	DefaultUpstreamMaxConcurrent     = 1
 	DefaultUpstreamMaxQueue          = 100
 	DefaultUpstreamSubmitMinInterval = 0 * time.Second
@@ -43,6 +104,138 @@ const (
 	// Policy A: same-key concurrent submit must immediately return 429, with no per-key waiting queue.
 	// PER_KEY_MAX_QUEUE is kept as a reserved knob for future use; currently any non-zero value is rejected.
 	DefaultPerKeyMaxQueue = 0
+
+	// DefaultUpstreamCoordination keeps upstream limits in process memory (single replica).
+	DefaultUpstreamCoordination = UpstreamCoordinationMemory
+
+	// DefaultAPIKeyCacheTTL bounds how long an authenticated key is served from
+	// memory. 0 disables the cache.
+	DefaultAPIKeyCacheTTL = 30 * time.Second
+	// DefaultAPIKeyCacheSyncInterval is how often cached keys are compared
+	// with the database, so that keys revoked, rotated or edited from another
+	// process stop being served well before the cache TTL. 0 disables it.
+	DefaultAPIKeyCacheSyncInterval = 5 * time.Second
+	// DefaultAPIKeyActivityFlush is how often key last-used times and request
+	// counts are written. 0 disables tracking.
+	DefaultAPIKeyActivityFlush = 30 * time.Second
+
+	DefaultReplayStore = ReplayStoreMemory
+
+	// DefaultAPIKeyKeyProvider reads the master key from API_KEY_ENCRYPTION_KEY(S).
+	DefaultAPIKeyKeyProvider = KeyProviderEnv
+	// DefaultAPIKeyKMSCacheTTL is how long data keys unwrapped by the KMS are
+	// kept in memory before the KMS is asked again.
+	DefaultAPIKeyKMSCacheTTL = time.Hour
+	// Get-result is read-only and polled frequently, so it skips replay checks by default.
+	DefaultReplayProtectGetResult = false
+
+	// DefaultPresignMaxExpires caps the X-Expires a presigned URL may carry.
+	DefaultPresignMaxExpires = time.Hour
+
+	// DefaultAuthTokenMaxTTL caps the lifetime of bearer tokens from /v1/auth/token.
+	DefaultAuthTokenMaxTTL = time.Hour
+
+	// MinAdminAPITokenLength keeps ADMIN_API_TOKEN out of guessing range.
+	MinAdminAPITokenLength = 32
+
+	// DefaultSubmitValidation checks req_keys with a known schema and relays the rest.
+	DefaultSubmitValidation = SubmitValidationKnown
+
+	// DefaultPolicyReloadInterval is how often POLICY_FILE is checked for edits.
+	DefaultPolicyReloadInterval = 10 * time.Second
+
+	// DefaultShutdownTimeout bounds how long SIGTERM waits for in-flight
+	// requests (including queued upstream waiters) before closing connections.
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultReadyCheckTimeout bounds each /ready dependency probe.
+	DefaultReadyCheckTimeout = 2 * time.Second
+	// DefaultReadyCacheTTL is how long /ready reuses the last probe results.
+	DefaultReadyCacheTTL = 2 * time.Second
+
+	// DefaultIdempotencyTTL is how long a submit Idempotency-Key is remembered.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	DefaultLogLevel = slog.LevelInfo
+
+	DefaultTLSMinVersion = TLSVersion12
+	// DefaultTLSReloadInterval is how often the TLS files are checked for edits.
+	DefaultTLSReloadInterval = 10 * time.Second
+
+	// Upstream bodies are left out of the audit trail unless enabled per action.
+	DefaultAuditBodyMode = AuditBodyNone
+	// DefaultAuditBodyMaxBytes caps a fully recorded upstream body.
+	DefaultAuditBodyMaxBytes = 16 << 10
+	// DefaultAuditCheckpointInterval is how often the audit chain head is signed.
+	DefaultAuditCheckpointInterval = time.Hour
+
+	// Audit sinks are off unless a destination is set; each defaults to best effort.
+	DefaultAuditSinkPolicy         = AuditSinkBestEffort
+	DefaultAuditSinkFileMaxBytes   = 100 << 20
+	DefaultAuditSinkFileMaxBackups = 5
+	DefaultAuditSinkSyslogAppName  = "jimeng-relay"
+	DefaultAuditSinkWebhookTimeout = 5 * time.Second
+	// DefaultAuditSinkSyslogMaxBytes caps one syslog message; bigger entries
+	// have their largest fields, usually the request body, truncated.
+	DefaultAuditSinkSyslogMaxBytes = 8 << 10
+	// DefaultAuditSinkWebhookMaxBytes caps one entry in a webhook batch.
+	DefaultAuditSinkWebhookMaxBytes = 1 << 20
+	DefaultAuditSinkQueueSize       = 1024
+	DefaultAuditSinkBatchSize       = 100
+	DefaultAuditSinkFlushInterval   = time.Second
+	// DefaultAuditSinkSpoolDir holds batches a sink could not deliver, one
+	// subdirectory per sink.
+	DefaultAuditSinkSpoolDir      = "./audit-spool"
+	DefaultAuditSinkSpoolMaxBytes = 256 << 20
+
+	// DefaultStatsRollupInterval is how often the hourly stats rollups are
+	// refreshed. 0 disables the background job.
+	DefaultStatsRollupInterval = 5 * time.Minute
+)
+
+const (
+	UpstreamCoordinationMemory   = "memory"
+	UpstreamCoordinationPostgres = "postgres"
+)
+
+const (
+	ReplayStoreMemory   = "memory"
+	ReplayStoreDatabase = "database"
+	ReplayStoreOff      = "off"
+)
+
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+const (
+	TLSClientAuthOff      = "off"
+	TLSClientAuthOptional = "optional"
+	TLSClientAuthRequire  = "require"
+)
+
+const (
+	AuditBodyNone     = "none"
+	AuditBodyMetadata = "metadata"
+	AuditBodyFull     = "full"
+)
+
+const (
+	KeyProviderEnv  = "env"
+	KeyProviderFile = "file"
+	KeyProviderKMS  = "kms"
+)
+
+const (
+	AuditSinkBestEffort = "best_effort"
+	AuditSinkFailClosed = "fail_closed"
+)
+
+const (
+	SubmitValidationOff    = "off"
+	SubmitValidationKnown  = "known"
+	SubmitValidationStrict = "strict"
 )
 
 type Config struct {
@@ -53,12 +246,71 @@ type Config struct {
 	ServerPort                string
 	DatabaseType              string
 	DatabaseURL               string
+	DatabaseAutoMigrate       bool
 	APIKeyEncryptionKey       string
+	APIKeyEncryptionKeys      string
+	APIKeyKeyProvider         string
+	APIKeyKeyFile             string
+	APIKeyKMSEndpoint         string
+	APIKeyKMSKeyID            string
+	APIKeyKMSToken            string
+	APIKeyKMSWrappedKeys      string
+	APIKeyKMSCacheTTL         time.Duration
 	UpstreamMaxConcurrent     int
 	UpstreamMaxQueue          int
 	UpstreamSubmitMinInterval time.Duration
 	PerKeyMaxConcurrent       int
 	PerKeyMaxQueue            int
+	UpstreamCoordination      string
+	APIKeyCacheTTL            time.Duration
+	APIKeyCacheSyncInterval   time.Duration
+	APIKeyActivityFlush       time.Duration
+	ReplayStore               string
+	ReplayProtectGetResult    bool
+	PresignMaxExpires         time.Duration
+	AuthTokenSigningKey       string
+	AuthTokenMaxTTL           time.Duration
+	AdminAPIToken             string
+	SubmitValidation          string
+	PolicyFile                string
+	PolicyReloadInterval      time.Duration
+	ShutdownTimeout           time.Duration
+	ReadyCheckTimeout         time.Duration
+	ReadyCacheTTL             time.Duration
+	ReadyUpstreamProbe        bool
+	IdempotencyTTL            time.Duration
+	LogLevel                  slog.Level
+	TLSCertFile               string
+	TLSKeyFile                string
+	TLSMinVersion             string
+	TLSClientCAFile           string
+	TLSClientAuth             string
+	TLSReloadInterval         time.Duration
+	AuditBodySubmit           string
+	AuditBodyGetResult        string
+	AuditBodyMaxBytes         int
+	AuditCheckpointKey        string
+	AuditCheckpointInterval   time.Duration
+	AuditSinkFilePath         string
+	AuditSinkFileMaxBytes     int64
+	AuditSinkFileMaxBackups   int
+	AuditSinkFilePolicy       string
+	AuditSinkSyslogAddr       string
+	AuditSinkSyslogAppName    string
+	AuditSinkSyslogPolicy     string
+	AuditSinkSyslogMaxBytes   int
+	AuditSinkWebhookURL       string
+	AuditSinkWebhookToken     string
+	AuditSinkWebhookTimeout   time.Duration
+	AuditSinkWebhookPolicy    string
+	AuditSinkWebhookMaxBytes  int
+	AuditSinkQueueSize        int
+	AuditSinkBatchSize        int
+	AuditSinkFlushInterval    time.Duration
+	AuditSinkSpoolDir         string
+	AuditSinkSpoolMaxBytes    int64
+	UsagePriceFile            string
+	StatsRollupInterval       time.Duration
 }
 
 func (c Config) LogValue() slog.Value {
@@ -70,12 +322,61 @@ func (c Config) LogValue() slog.Value {
 		slog.String("server_port", c.ServerPort),
 		slog.String("database_type", c.DatabaseType),
 		slog.String("database_url", c.DatabaseURL),
+		slog.Bool("database_auto_migrate", c.DatabaseAutoMigrate),
 		slog.String("api_key_encryption_key", "***"),
+		slog.Bool("api_key_keyring_enabled", c.APIKeyEncryptionKeys != ""),
+		slog.String("api_key_key_provider", c.APIKeyKeyProvider),
+		slog.String("api_key_key_file", c.APIKeyKeyFile),
+		slog.String("api_key_kms_endpoint", c.APIKeyKMSEndpoint),
+		slog.String("api_key_kms_key_id", c.APIKeyKMSKeyID),
+		slog.String("api_key_kms_cache_ttl", c.APIKeyKMSCacheTTL.String()),
 		slog.Int("upstream_max_concurrent", c.UpstreamMaxConcurrent),
 		slog.Int("upstream_max_queue", c.UpstreamMaxQueue),
 		slog.String("upstream_submit_min_interval", c.UpstreamSubmitMinInterval.String()),
 		slog.Int("per_key_max_concurrent", c.PerKeyMaxConcurrent),
 		slog.Int("per_key_max_queue", c.PerKeyMaxQueue),
+		slog.String("upstream_coordination", c.UpstreamCoordination),
+		slog.String("api_key_cache_ttl", c.APIKeyCacheTTL.String()),
+		slog.String("api_key_cache_sync_interval", c.APIKeyCacheSyncInterval.String()),
+		slog.String("api_key_activity_flush_interval", c.APIKeyActivityFlush.String()),
+		slog.String("replay_store", c.ReplayStore),
+		slog.Bool("replay_protect_get_result", c.ReplayProtectGetResult),
+		slog.String("presign_max_expires", c.PresignMaxExpires.String()),
+		slog.Bool("auth_tokens_enabled", c.AuthTokenSigningKey != ""),
+		slog.String("auth_token_max_ttl", c.AuthTokenMaxTTL.String()),
+		slog.Bool("admin_api_enabled", c.AdminAPIToken != ""),
+		slog.String("submit_validation", c.SubmitValidation),
+		slog.String("policy_file", c.PolicyFile),
+		slog.String("policy_reload_interval", c.PolicyReloadInterval.String()),
+		slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+		slog.String("ready_check_timeout", c.ReadyCheckTimeout.String()),
+		slog.String("ready_cache_ttl", c.ReadyCacheTTL.String()),
+		slog.Bool("ready_upstream_probe", c.ReadyUpstreamProbe),
+		slog.String("idempotency_ttl", c.IdempotencyTTL.String()),
+		slog.String("log_level", c.LogLevel.String()),
+		slog.String("tls_cert_file", c.TLSCertFile),
+		slog.String("tls_min_version", c.TLSMinVersion),
+		slog.String("tls_client_ca_file", c.TLSClientCAFile),
+		slog.String("tls_client_auth", c.TLSClientAuth),
+		slog.String("tls_reload_interval", c.TLSReloadInterval.String()),
+		slog.String("audit_body_submit", c.AuditBodySubmit),
+		slog.String("audit_body_get_result", c.AuditBodyGetResult),
+		slog.Int("audit_body_max_bytes", c.AuditBodyMaxBytes),
+		slog.Bool("audit_checkpoints_enabled", c.AuditCheckpointKey != ""),
+		slog.String("audit_checkpoint_interval", c.AuditCheckpointInterval.String()),
+		slog.String("audit_sink_file_path", c.AuditSinkFilePath),
+		slog.String("audit_sink_file_policy", c.AuditSinkFilePolicy),
+		slog.String("audit_sink_syslog_addr", c.AuditSinkSyslogAddr),
+		slog.String("audit_sink_syslog_policy", c.AuditSinkSyslogPolicy),
+		slog.Bool("audit_sink_webhook_enabled", c.AuditSinkWebhookURL != ""),
+		slog.String("audit_sink_webhook_policy", c.AuditSinkWebhookPolicy),
+		slog.Int("audit_sink_queue_size", c.AuditSinkQueueSize),
+		slog.Int("audit_sink_batch_size", c.AuditSinkBatchSize),
+		slog.String("audit_sink_flush_interval", c.AuditSinkFlushInterval.String()),
+		slog.String("audit_sink_spool_dir", c.AuditSinkSpoolDir),
+		slog.Int64("audit_sink_spool_max_bytes", c.AuditSinkSpoolMaxBytes),
+		slog.String("usage_price_file", c.UsagePriceFile),
+		slog.String("stats_rollup_interval", c.StatsRollupInterval.String()),
 	)
 }
 
@@ -89,7 +390,13 @@ type Options struct {
 	DatabaseType        *string
 	DatabaseURL         *string
 	APIKeyEncryptionKey *string
-	ConfigFile          *string
+	// ConfigFile is a YAML config file (.yaml/.yml) or a dotenv file used in
+	// place of ./.env. Environment variables override values from any of them.
+	ConfigFile *string
+	// SkipCredentials leaves out the upstream credentials and the API key
+	// master key checks, for CLI commands that do not call the upstream.
+	// Master key settings are still read; see ValidateEncryptionKeys.
+	SkipCredentials bool
 }
 
 func Load(opts Options) (Config, error) {
@@ -100,64 +407,126 @@ func Load(opts Options) (Config, error) {
 		ServerPort:                DefaultServerPort,
 		DatabaseType:              DefaultDatabaseType,
 		DatabaseURL:               DefaultDatabaseURL,
+		DatabaseAutoMigrate:       DefaultDatabaseAutoMigrate,
 		UpstreamMaxConcurrent:     DefaultUpstreamMaxConcurrent,
 		UpstreamMaxQueue:          DefaultUpstreamMaxQueue,
 		UpstreamSubmitMinInterval: DefaultUpstreamSubmitMinInterval,
 		PerKeyMaxConcurrent:       DefaultPerKeyMaxConcurrent,
 		PerKeyMaxQueue:            DefaultPerKeyMaxQueue,
+		UpstreamCoordination:      DefaultUpstreamCoordination,
+		APIKeyCacheTTL:            DefaultAPIKeyCacheTTL,
+		APIKeyCacheSyncInterval:   DefaultAPIKeyCacheSyncInterval,
+		APIKeyActivityFlush:       DefaultAPIKeyActivityFlush,
+		APIKeyKeyProvider:         DefaultAPIKeyKeyProvider,
+		APIKeyKMSCacheTTL:         DefaultAPIKeyKMSCacheTTL,
+		ReplayStore:               DefaultReplayStore,
+		ReplayProtectGetResult:    DefaultReplayProtectGetResult,
+		PresignMaxExpires:         DefaultPresignMaxExpires,
+		AuthTokenMaxTTL:           DefaultAuthTokenMaxTTL,
+		SubmitValidation:          DefaultSubmitValidation,
+		PolicyReloadInterval:      DefaultPolicyReloadInterval,
+		ShutdownTimeout:           DefaultShutdownTimeout,
+		ReadyCheckTimeout:         DefaultReadyCheckTimeout,
+		ReadyCacheTTL:             DefaultReadyCacheTTL,
+		IdempotencyTTL:            DefaultIdempotencyTTL,
+		LogLevel:                  DefaultLogLevel,
+		TLSMinVersion:             DefaultTLSMinVersion,
+		TLSReloadInterval:         DefaultTLSReloadInterval,
+		AuditBodySubmit:           DefaultAuditBodyMode,
+		AuditBodyGetResult:        DefaultAuditBodyMode,
+		AuditBodyMaxBytes:         DefaultAuditBodyMaxBytes,
+		AuditCheckpointInterval:   DefaultAuditCheckpointInterval,
+		StatsRollupInterval:       DefaultStatsRollupInterval,
+		AuditSinkFileMaxBytes:     DefaultAuditSinkFileMaxBytes,
+		AuditSinkFileMaxBackups:   DefaultAuditSinkFileMaxBackups,
+		AuditSinkFilePolicy:       DefaultAuditSinkPolicy,
+		AuditSinkSyslogAppName:    DefaultAuditSinkSyslogAppName,
+		AuditSinkSyslogPolicy:     DefaultAuditSinkPolicy,
+		AuditSinkWebhookTimeout:   DefaultAuditSinkWebhookTimeout,
+		AuditSinkSyslogMaxBytes:   DefaultAuditSinkSyslogMaxBytes,
+		AuditSinkWebhookMaxBytes:  DefaultAuditSinkWebhookMaxBytes,
+		AuditSinkWebhookPolicy:    DefaultAuditSinkPolicy,
+		AuditSinkQueueSize:        DefaultAuditSinkQueueSize,
+		AuditSinkBatchSize:        DefaultAuditSinkBatchSize,
+		AuditSinkFlushInterval:    DefaultAuditSinkFlushInterval,
+		AuditSinkSpoolDir:         DefaultAuditSinkSpoolDir,
+		AuditSinkSpoolMaxBytes:    DefaultAuditSinkSpoolMaxBytes,
 	}
 
-	envFile := ".env"
-	if opts.ConfigFile != nil && *opts.ConfigFile != "" {
-		envFile = *opts.ConfigFile
+	// File values are read on every Load rather than copied into the process
+	// environment, so a reload sees edits to the file. ./.env is only read
+	// when no config file is given.
+	var fileValues map[string]string
+	var err error
+	switch {
+	case opts.ConfigFile == nil || *opts.ConfigFile == "":
+		fileValues, err = readEnvFile(".env")
+	case isYAMLFile(*opts.ConfigFile):
+		fileValues, err = loadYAMLFile(*opts.ConfigFile)
+	default:
+		fileValues, err = readEnvFile(*opts.ConfigFile)
 	}
-	if err := LoadEnvFile(envFile); err != nil {
+	if err != nil {
 		return Config{}, err
 	}
+	lookup := func(key string) (string, bool) {
+		if v, ok := lookupEnvNonEmpty(key); ok {
+			return v, true
+		}
+		v := strings.TrimSpace(fileValues[key])
+		return v, v != ""
+	}
 
-	if v, ok := lookupEnvNonEmpty(EnvRegion); ok {
+	if v, ok := lookup(EnvRegion); ok {
 		cfg.Region = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvHost); ok {
+	if v, ok := lookup(EnvHost); ok {
 		cfg.Host = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvTimeout); ok {
+	if v, ok := lookup(EnvTimeout); ok {
 		d, err := time.ParseDuration(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvTimeout, err)
 		}
 		cfg.Timeout = d
 	}
-	if v, ok := lookupEnvNonEmpty(EnvServerPort); ok {
+	if v, ok := lookup(EnvServerPort); ok {
 		cfg.ServerPort = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvPort); ok {
+	if v, ok := lookup(EnvPort); ok {
 		cfg.ServerPort = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvDatabaseType); ok {
+	if v, ok := lookup(EnvDatabaseType); ok {
 		cfg.DatabaseType = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvDatabaseURL); ok {
+	if v, ok := lookup(EnvDatabaseURL); ok {
 		cfg.DatabaseURL = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvAPIKeyEncryptionKey); ok {
-		cfg.APIKeyEncryptionKey = v
+	if v, ok := lookup(EnvDatabaseAutoMigrate); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvDatabaseAutoMigrate, err)
+		}
+		cfg.DatabaseAutoMigrate = b
 	}
-	if v, ok := lookupEnvNonEmpty(EnvUpstreamMaxConcurrent); ok {
+	if err := readEncryptionKeys(&cfg, lookup); err != nil {
+		return Config{}, err
+	}
+	if v, ok := lookup(EnvUpstreamMaxConcurrent); ok {
 		n, err := strconv.Atoi(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvUpstreamMaxConcurrent, err)
 		}
 		cfg.UpstreamMaxConcurrent = n
 	}
-	if v, ok := lookupEnvNonEmpty(EnvUpstreamMaxQueue); ok {
+	if v, ok := lookup(EnvUpstreamMaxQueue); ok {
 		n, err := strconv.Atoi(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvUpstreamMaxQueue, err)
 		}
 		cfg.UpstreamMaxQueue = n
 	}
-	if v, ok := lookupEnvNonEmpty(EnvUpstreamSubmitMinInterval); ok {
+	if v, ok := lookup(EnvUpstreamSubmitMinInterval); ok {
 		d, err := time.ParseDuration(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvUpstreamSubmitMinInterval, err)
@@ -167,20 +536,347 @@ func Load(opts Options) (Config, error) {
 		}
 		cfg.UpstreamSubmitMinInterval = d
 	}
-	if v, ok := lookupEnvNonEmpty(EnvPerKeyMaxConcurrent); ok {
+	if v, ok := lookup(EnvPerKeyMaxConcurrent); ok {
 		n, err := strconv.Atoi(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvPerKeyMaxConcurrent, err)
 		}
 		cfg.PerKeyMaxConcurrent = n
 	}
-	if v, ok := lookupEnvNonEmpty(EnvPerKeyMaxQueue); ok {
+	if v, ok := lookup(EnvPerKeyMaxQueue); ok {
 		n, err := strconv.Atoi(v)
 		if err != nil {
 			return Config{}, fmt.Errorf("invalid %s: %w", EnvPerKeyMaxQueue, err)
 		}
 		cfg.PerKeyMaxQueue = n
 	}
+	if v, ok := lookup(EnvUpstreamCoordination); ok {
+		cfg.UpstreamCoordination = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAPIKeyCacheTTL); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAPIKeyCacheTTL, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvAPIKeyCacheTTL)
+		}
+		cfg.APIKeyCacheTTL = d
+	}
+	if v, ok := lookup(EnvAPIKeyCacheSyncInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAPIKeyCacheSyncInterval, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvAPIKeyCacheSyncInterval)
+		}
+		cfg.APIKeyCacheSyncInterval = d
+	}
+	if v, ok := lookup(EnvAPIKeyActivityFlush); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAPIKeyActivityFlush, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvAPIKeyActivityFlush)
+		}
+		cfg.APIKeyActivityFlush = d
+	}
+	if v, ok := lookup(EnvReplayStore); ok {
+		cfg.ReplayStore = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvReplayProtectGetResult); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvReplayProtectGetResult, err)
+		}
+		cfg.ReplayProtectGetResult = b
+	}
+	if v, ok := lookup(EnvPresignMaxExpires); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvPresignMaxExpires, err)
+		}
+		if d < time.Second {
+			return Config{}, fmt.Errorf("%s must be >= 1s", EnvPresignMaxExpires)
+		}
+		cfg.PresignMaxExpires = d
+	}
+	if v, ok := lookup(EnvAuthTokenSigningKey); ok {
+		cfg.AuthTokenSigningKey = v
+	}
+	if v, ok := lookup(EnvAuthTokenMaxTTL); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuthTokenMaxTTL, err)
+		}
+		if d < time.Minute {
+			return Config{}, fmt.Errorf("%s must be >= 1m", EnvAuthTokenMaxTTL)
+		}
+		cfg.AuthTokenMaxTTL = d
+	}
+	if v, ok := lookup(EnvAdminAPIToken); ok {
+		if len(v) < MinAdminAPITokenLength {
+			return Config{}, fmt.Errorf("%s must be at least %d characters", EnvAdminAPIToken, MinAdminAPITokenLength)
+		}
+		cfg.AdminAPIToken = v
+	}
+	if v, ok := lookup(EnvSubmitValidation); ok {
+		cfg.SubmitValidation = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvPolicyFile); ok {
+		cfg.PolicyFile = v
+	}
+	if v, ok := lookup(EnvPolicyReloadInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvPolicyReloadInterval, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvPolicyReloadInterval)
+		}
+		cfg.PolicyReloadInterval = d
+	}
+	if v, ok := lookup(EnvShutdownTimeout); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvShutdownTimeout, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvShutdownTimeout)
+		}
+		cfg.ShutdownTimeout = d
+	}
+	if v, ok := lookup(EnvReadyCheckTimeout); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvReadyCheckTimeout, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvReadyCheckTimeout)
+		}
+		cfg.ReadyCheckTimeout = d
+	}
+	if v, ok := lookup(EnvReadyCacheTTL); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvReadyCacheTTL, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvReadyCacheTTL)
+		}
+		cfg.ReadyCacheTTL = d
+	}
+	if v, ok := lookup(EnvReadyUpstreamProbe); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvReadyUpstreamProbe, err)
+		}
+		cfg.ReadyUpstreamProbe = b
+	}
+	if v, ok := lookup(EnvIdempotencyTTL); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvIdempotencyTTL, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvIdempotencyTTL)
+		}
+		cfg.IdempotencyTTL = d
+	}
+	if v, ok := lookup(EnvAuditBodySubmit); ok {
+		cfg.AuditBodySubmit = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAuditBodyGetResult); ok {
+		cfg.AuditBodyGetResult = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAuditBodyMaxBytes); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditBodyMaxBytes, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditBodyMaxBytes)
+		}
+		cfg.AuditBodyMaxBytes = n
+	}
+	if v, ok := lookup(EnvAuditCheckpointKey); ok {
+		cfg.AuditCheckpointKey = v
+	}
+	if v, ok := lookup(EnvAuditCheckpointInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditCheckpointInterval, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditCheckpointInterval)
+		}
+		cfg.AuditCheckpointInterval = d
+	}
+	if v, ok := lookup(EnvAuditSinkFilePath); ok {
+		cfg.AuditSinkFilePath = v
+	}
+	if v, ok := lookup(EnvAuditSinkFileMaxBytes); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkFileMaxBytes, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkFileMaxBytes)
+		}
+		cfg.AuditSinkFileMaxBytes = n
+	}
+	if v, ok := lookup(EnvAuditSinkFileMaxBackups); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkFileMaxBackups, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkFileMaxBackups)
+		}
+		cfg.AuditSinkFileMaxBackups = n
+	}
+	if v, ok := lookup(EnvAuditSinkFilePolicy); ok {
+		cfg.AuditSinkFilePolicy = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAuditSinkSyslogAddr); ok {
+		cfg.AuditSinkSyslogAddr = v
+	}
+	if v, ok := lookup(EnvAuditSinkSyslogAppName); ok {
+		cfg.AuditSinkSyslogAppName = v
+	}
+	if v, ok := lookup(EnvAuditSinkSyslogPolicy); ok {
+		cfg.AuditSinkSyslogPolicy = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAuditSinkSyslogMaxBytes); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkSyslogMaxBytes, err)
+		}
+		if n < 480 || n > 65000 {
+			return Config{}, fmt.Errorf("%s must be between 480 and 65000", EnvAuditSinkSyslogMaxBytes)
+		}
+		cfg.AuditSinkSyslogMaxBytes = n
+	}
+	if v, ok := lookup(EnvAuditSinkWebhookURL); ok {
+		cfg.AuditSinkWebhookURL = v
+	}
+	if v, ok := lookup(EnvAuditSinkWebhookToken); ok {
+		cfg.AuditSinkWebhookToken = v
+	}
+	if v, ok := lookup(EnvAuditSinkWebhookTimeout); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkWebhookTimeout, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkWebhookTimeout)
+		}
+		cfg.AuditSinkWebhookTimeout = d
+	}
+	if v, ok := lookup(EnvAuditSinkWebhookPolicy); ok {
+		cfg.AuditSinkWebhookPolicy = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAuditSinkWebhookMaxBytes); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkWebhookMaxBytes, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkWebhookMaxBytes)
+		}
+		cfg.AuditSinkWebhookMaxBytes = n
+	}
+	if v, ok := lookup(EnvAuditSinkQueueSize); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkQueueSize, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkQueueSize)
+		}
+		cfg.AuditSinkQueueSize = n
+	}
+	if v, ok := lookup(EnvAuditSinkBatchSize); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkBatchSize, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkBatchSize)
+		}
+		cfg.AuditSinkBatchSize = n
+	}
+	if v, ok := lookup(EnvAuditSinkFlushInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkFlushInterval, err)
+		}
+		if d <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkFlushInterval)
+		}
+		cfg.AuditSinkFlushInterval = d
+	}
+	if v, ok := lookup(EnvAuditSinkSpoolDir); ok {
+		cfg.AuditSinkSpoolDir = v
+	}
+	if v, ok := lookup(EnvAuditSinkSpoolMaxBytes); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvAuditSinkSpoolMaxBytes, err)
+		}
+		if n <= 0 {
+			return Config{}, fmt.Errorf("%s must be > 0", EnvAuditSinkSpoolMaxBytes)
+		}
+		cfg.AuditSinkSpoolMaxBytes = n
+	}
+	if v, ok := lookup(EnvUsagePriceFile); ok {
+		cfg.UsagePriceFile = v
+	}
+	if v, ok := lookup(EnvStatsRollupInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvStatsRollupInterval, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvStatsRollupInterval)
+		}
+		cfg.StatsRollupInterval = d
+	}
+	if v, ok := lookup(EnvLogLevel); ok {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %q (expected debug, info, warn or error)", EnvLogLevel, v)
+		}
+	}
+	if v, ok := lookup(EnvDebug); ok && strings.EqualFold(v, "true") {
+		cfg.LogLevel = slog.LevelDebug
+	}
+	if v, ok := lookup(EnvTLSCertFile); ok {
+		cfg.TLSCertFile = v
+	}
+	if v, ok := lookup(EnvTLSKeyFile); ok {
+		cfg.TLSKeyFile = v
+	}
+	if v, ok := lookup(EnvTLSMinVersion); ok {
+		cfg.TLSMinVersion = v
+	}
+	if v, ok := lookup(EnvTLSClientCAFile); ok {
+		cfg.TLSClientCAFile = v
+	}
+	if v, ok := lookup(EnvTLSClientAuth); ok {
+		cfg.TLSClientAuth = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvTLSReloadInterval); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", EnvTLSReloadInterval, err)
+		}
+		if d < 0 {
+			return Config{}, fmt.Errorf("%s must be >= 0", EnvTLSReloadInterval)
+		}
+		cfg.TLSReloadInterval = d
+	}
 
 	// Per-key concurrency semantics are intentionally fixed to preserve Policy A.
 	// Keep these env vars for forward-compatibility, but reject unsupported values to avoid silent misconfiguration.
@@ -240,21 +936,193 @@ func Load(opts Options) (Config, error) {
 		cfg.APIKeyEncryptionKey = v
 	}
 
-	creds, err := LoadCredentials(CredentialsOptions{
-		AccessKey: opts.AccessKey,
-		SecretKey: opts.SecretKey,
-	})
-	if err != nil {
+	switch cfg.UpstreamCoordination {
+	case UpstreamCoordinationMemory:
+	case UpstreamCoordinationPostgres:
+		// Advisory locks only coordinate replicas that share the same Postgres database.
+		if dbType := strings.ToLower(cfg.DatabaseType); dbType != "postgres" && dbType != "postgresql" {
+			return Config{}, fmt.Errorf("%s=%s requires %s=postgres (got %s)", EnvUpstreamCoordination, UpstreamCoordinationPostgres, EnvDatabaseType, cfg.DatabaseType)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid %s: %q (expected %s or %s)", EnvUpstreamCoordination, cfg.UpstreamCoordination, UpstreamCoordinationMemory, UpstreamCoordinationPostgres)
+	}
+
+	switch cfg.ReplayStore {
+	case ReplayStoreMemory, ReplayStoreDatabase, ReplayStoreOff:
+	default:
+		return Config{}, fmt.Errorf("invalid %s: %q (expected %s, %s or %s)", EnvReplayStore, cfg.ReplayStore, ReplayStoreMemory, ReplayStoreDatabase, ReplayStoreOff)
+	}
+	switch cfg.SubmitValidation {
+	case SubmitValidationOff, SubmitValidationKnown, SubmitValidationStrict:
+	default:
+		return Config{}, fmt.Errorf("invalid %s: %q (expected %s, %s or %s)", EnvSubmitValidation, cfg.SubmitValidation, SubmitValidationOff, SubmitValidationKnown, SubmitValidationStrict)
+	}
+
+	for _, body := range []struct{ env, mode string }{
+		{EnvAuditBodySubmit, cfg.AuditBodySubmit},
+		{EnvAuditBodyGetResult, cfg.AuditBodyGetResult},
+	} {
+		switch body.mode {
+		case AuditBodyNone, AuditBodyMetadata, AuditBodyFull:
+		default:
+			return Config{}, fmt.Errorf("invalid %s: %q (expected %s, %s or %s)", body.env, body.mode, AuditBodyNone, AuditBodyMetadata, AuditBodyFull)
+		}
+	}
+
+	for _, sink := range []struct{ env, policy string }{
+		{EnvAuditSinkFilePolicy, cfg.AuditSinkFilePolicy},
+		{EnvAuditSinkSyslogPolicy, cfg.AuditSinkSyslogPolicy},
+		{EnvAuditSinkWebhookPolicy, cfg.AuditSinkWebhookPolicy},
+	} {
+		switch sink.policy {
+		case AuditSinkBestEffort, AuditSinkFailClosed:
+		default:
+			return Config{}, fmt.Errorf("invalid %s: %q (expected %s or %s)", sink.env, sink.policy, AuditSinkBestEffort, AuditSinkFailClosed)
+		}
+	}
+
+	if err := validateTLS(&cfg); err != nil {
 		return Config{}, err
 	}
-	cfg.Credentials = creds
-	if strings.TrimSpace(cfg.APIKeyEncryptionKey) == "" {
-		return Config{}, fmt.Errorf("%s is required", EnvAPIKeyEncryptionKey)
+
+	if !opts.SkipCredentials {
+		creds, err := loadCredentials(CredentialsOptions{
+			AccessKey: opts.AccessKey,
+			SecretKey: opts.SecretKey,
+		}, lookup)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Credentials = creds
+		if err := ValidateEncryptionKeys(cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	// Checkpoints must stay verifiable by someone who cannot decrypt API keys.
+	if checkpointKey := strings.TrimSpace(cfg.AuditCheckpointKey); checkpointKey != "" {
+		if checkpointKey == strings.TrimSpace(cfg.APIKeyEncryptionKey) {
+			return Config{}, fmt.Errorf("%s must differ from %s", EnvAuditCheckpointKey, EnvAPIKeyEncryptionKey)
+		}
+		for _, entry := range strings.Split(cfg.APIKeyEncryptionKeys, ",") {
+			if _, key, _ := strings.Cut(entry, ":"); strings.TrimSpace(key) == checkpointKey {
+				return Config{}, fmt.Errorf("%s must differ from every key in %s", EnvAuditCheckpointKey, EnvAPIKeyEncryptionKeys)
+			}
+		}
 	}
 
 	return cfg, nil
 }
 
+// validateTLS checks the TLS settings as a group and fills in the client auth
+// mode: a client CA alone means certificates are verified when presented.
+func validateTLS(cfg *Config) error {
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("%s and %s must be set together", EnvTLSCertFile, EnvTLSKeyFile)
+	}
+	switch cfg.TLSMinVersion {
+	case TLSVersion12, TLSVersion13:
+	default:
+		return fmt.Errorf("invalid %s: %q (expected %s or %s)", EnvTLSMinVersion, cfg.TLSMinVersion, TLSVersion12, TLSVersion13)
+	}
+	if cfg.TLSClientAuth == "" {
+		cfg.TLSClientAuth = TLSClientAuthOff
+		if cfg.TLSClientCAFile != "" {
+			cfg.TLSClientAuth = TLSClientAuthOptional
+		}
+	}
+	switch cfg.TLSClientAuth {
+	case TLSClientAuthOff:
+	case TLSClientAuthOptional, TLSClientAuthRequire:
+		if cfg.TLSClientCAFile == "" {
+			return fmt.Errorf("%s=%s requires %s", EnvTLSClientAuth, cfg.TLSClientAuth, EnvTLSClientCAFile)
+		}
+	default:
+		return fmt.Errorf("invalid %s: %q (expected %s, %s or %s)", EnvTLSClientAuth, cfg.TLSClientAuth, TLSClientAuthOff, TLSClientAuthOptional, TLSClientAuthRequire)
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("%s requires %s and %s", EnvTLSClientCAFile, EnvTLSCertFile, EnvTLSKeyFile)
+	}
+	return nil
+}
+
+func readEncryptionKeys(cfg *Config, lookup func(string) (string, bool)) error {
+	if v, ok := lookup(EnvAPIKeyEncryptionKey); ok {
+		cfg.APIKeyEncryptionKey = v
+	}
+	if v, ok := lookup(EnvAPIKeyEncryptionKeys); ok {
+		cfg.APIKeyEncryptionKeys = v
+	}
+	if v, ok := lookup(EnvAPIKeyKeyProvider); ok {
+		cfg.APIKeyKeyProvider = strings.ToLower(v)
+	}
+	if v, ok := lookup(EnvAPIKeyKeyFile); ok {
+		cfg.APIKeyKeyFile = v
+	}
+	if v, ok := lookup(EnvAPIKeyKMSEndpoint); ok {
+		cfg.APIKeyKMSEndpoint = v
+	}
+	if v, ok := lookup(EnvAPIKeyKMSKeyID); ok {
+		cfg.APIKeyKMSKeyID = v
+	}
+	if v, ok := lookup(EnvAPIKeyKMSToken); ok {
+		cfg.APIKeyKMSToken = v
+	}
+	if v, ok := lookup(EnvAPIKeyKMSWrappedKeys); ok {
+		cfg.APIKeyKMSWrappedKeys = v
+	}
+	if v, ok := lookup(EnvAPIKeyKMSCacheTTL); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvAPIKeyKMSCacheTTL, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", EnvAPIKeyKMSCacheTTL)
+		}
+		cfg.APIKeyKMSCacheTTL = d
+	}
+	return nil
+}
+
+// ValidateEncryptionKeys checks that the selected key provider has exactly
+// the settings it needs. Raw keys in the environment are rejected for the
+// file and kms providers so that a leftover variable is not silently ignored.
+func ValidateEncryptionKeys(cfg Config) error {
+	hasKey := strings.TrimSpace(cfg.APIKeyEncryptionKey) != ""
+	hasKeys := strings.TrimSpace(cfg.APIKeyEncryptionKeys) != ""
+	switch cfg.APIKeyKeyProvider {
+	case KeyProviderEnv:
+		switch {
+		case hasKey && hasKeys:
+			return fmt.Errorf("set only one of %s and %s", EnvAPIKeyEncryptionKey, EnvAPIKeyEncryptionKeys)
+		case !hasKey && !hasKeys:
+			return fmt.Errorf("%s or %s is required", EnvAPIKeyEncryptionKey, EnvAPIKeyEncryptionKeys)
+		}
+		return nil
+	case KeyProviderFile, KeyProviderKMS:
+		if hasKey || hasKeys {
+			return fmt.Errorf("%s and %s must not be set when %s=%s", EnvAPIKeyEncryptionKey, EnvAPIKeyEncryptionKeys, EnvAPIKeyKeyProvider, cfg.APIKeyKeyProvider)
+		}
+	default:
+		return fmt.Errorf("invalid %s: %q (expected %s, %s or %s)", EnvAPIKeyKeyProvider, cfg.APIKeyKeyProvider, KeyProviderEnv, KeyProviderFile, KeyProviderKMS)
+	}
+	if cfg.APIKeyKeyProvider == KeyProviderFile {
+		if strings.TrimSpace(cfg.APIKeyKeyFile) == "" {
+			return fmt.Errorf("%s is required when %s=%s", EnvAPIKeyKeyFile, EnvAPIKeyKeyProvider, KeyProviderFile)
+		}
+		return nil
+	}
+	for _, required := range [][2]string{
+		{EnvAPIKeyKMSEndpoint, cfg.APIKeyKMSEndpoint},
+		{EnvAPIKeyKMSKeyID, cfg.APIKeyKMSKeyID},
+		{EnvAPIKeyKMSWrappedKeys, cfg.APIKeyKMSWrappedKeys},
+	} {
+		if strings.TrimSpace(required[1]) == "" {
+			return fmt.Errorf("%s is required when %s=%s", required[0], EnvAPIKeyKeyProvider, KeyProviderKMS)
+		}
+	}
+	return nil
+}
+
 func lookupEnvNonEmpty(key string) (string, bool) {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -270,14 +1138,32 @@ func lookupEnvNonEmpty(key string) (string, bool) {
 // LoadEnvFile loads environment variables from a file into os.Environ.
 // It skips lines that are empty, comments, or already set in the environment.
 func LoadEnvFile(path string) error {
+	values, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); !ok {
+			if err := os.Setenv(key, value); err != nil {
+				return fmt.Errorf("failed to set env %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readEnvFile parses KEY=VALUE lines of a dotenv file; the first assignment
+// of a key wins. A missing file yields no values.
+func readEnvFile(path string) (map[string]string, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to read env file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to read env file %s: %w", path, err)
 	}
 
+	values := map[string]string{}
 	lines := strings.Split(string(content), "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -294,14 +1180,12 @@ func LoadEnvFile(path string) error {
 		value := strings.TrimSpace(parts[1])
 
 		if key != "" {
-			if _, ok := os.LookupEnv(key); !ok {
-				if err := os.Setenv(key, value); err != nil {
-					return fmt.Errorf("failed to set env %s: %w", key, err)
-				}
+			if _, ok := values[key]; !ok {
+				values[key] = value
 			}
 		}
 	}
-	return nil
+	return values, nil
 }
 
 type Credentials struct {
@@ -330,12 +1214,16 @@ func (e *MissingCredentialsError) Error() string {
 }
 
 func LoadCredentials(opts CredentialsOptions) (Credentials, error) {
+	return loadCredentials(opts, lookupEnvNonEmpty)
+}
+
+func loadCredentials(opts CredentialsOptions, lookup func(string) (string, bool)) (Credentials, error) {
 	var c Credentials
 
-	if v, ok := lookupEnvNonEmpty(EnvAccessKey); ok {
+	if v, ok := lookup(EnvAccessKey); ok {
 		c.AccessKey = v
 	}
-	if v, ok := lookupEnvNonEmpty(EnvSecretKey); ok {
+	if v, ok := lookup(EnvSecretKey); ok {
 		c.SecretKey = v
 	}
 
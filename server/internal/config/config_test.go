@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -16,12 +21,72 @@ func TestLoad(t *testing.T) {
 		os.Unsetenv(EnvServerPort)
 		os.Unsetenv(EnvDatabaseType)
 		os.Unsetenv(EnvDatabaseURL)
+		os.Unsetenv(EnvDatabaseAutoMigrate)
 		os.Unsetenv(EnvAPIKeyEncryptionKey)
+		os.Unsetenv(EnvAPIKeyEncryptionKeys)
+		os.Unsetenv(EnvAPIKeyKeyProvider)
+		os.Unsetenv(EnvAPIKeyKeyFile)
+		os.Unsetenv(EnvAPIKeyKMSEndpoint)
+		os.Unsetenv(EnvAPIKeyKMSKeyID)
+		os.Unsetenv(EnvAPIKeyKMSToken)
+		os.Unsetenv(EnvAPIKeyKMSWrappedKeys)
+		os.Unsetenv(EnvAPIKeyKMSCacheTTL)
 		os.Unsetenv(EnvUpstreamMaxConcurrent)
 		os.Unsetenv(EnvUpstreamMaxQueue)
 		os.Unsetenv(EnvUpstreamSubmitMinInterval)
 		os.Unsetenv(EnvPerKeyMaxConcurrent)
 		os.Unsetenv(EnvPerKeyMaxQueue)
+		os.Unsetenv(EnvUpstreamCoordination)
+		os.Unsetenv(EnvAPIKeyCacheTTL)
+		os.Unsetenv(EnvAPIKeyCacheSyncInterval)
+		os.Unsetenv(EnvReplayStore)
+		os.Unsetenv(EnvReplayProtectGetResult)
+		os.Unsetenv(EnvPresignMaxExpires)
+		os.Unsetenv(EnvAuthTokenSigningKey)
+		os.Unsetenv(EnvAuthTokenMaxTTL)
+		os.Unsetenv(EnvAdminAPIToken)
+		os.Unsetenv(EnvSubmitValidation)
+		os.Unsetenv(EnvPolicyFile)
+		os.Unsetenv(EnvPolicyReloadInterval)
+		os.Unsetenv(EnvShutdownTimeout)
+		os.Unsetenv(EnvReadyCheckTimeout)
+		os.Unsetenv(EnvReadyCacheTTL)
+		os.Unsetenv(EnvReadyUpstreamProbe)
+		os.Unsetenv(EnvIdempotencyTTL)
+		os.Unsetenv(EnvLogLevel)
+		os.Unsetenv(EnvDebug)
+		os.Unsetenv(EnvTLSCertFile)
+		os.Unsetenv(EnvTLSKeyFile)
+		os.Unsetenv(EnvTLSMinVersion)
+		os.Unsetenv(EnvTLSClientCAFile)
+		os.Unsetenv(EnvTLSClientAuth)
+		os.Unsetenv(EnvTLSReloadInterval)
+		os.Unsetenv(EnvAuditBodySubmit)
+		os.Unsetenv(EnvAuditBodyGetResult)
+		os.Unsetenv(EnvAuditBodyMaxBytes)
+		os.Unsetenv(EnvAuditCheckpointKey)
+		os.Unsetenv(EnvAuditCheckpointInterval)
+		os.Unsetenv(EnvAuditSinkFilePath)
+		os.Unsetenv(EnvAuditSinkFileMaxBytes)
+		os.Unsetenv(EnvAuditSinkFileMaxBackups)
+		os.Unsetenv(EnvAuditSinkFilePolicy)
+		os.Unsetenv(EnvAuditSinkSyslogAddr)
+		os.Unsetenv(EnvAuditSinkSyslogAppName)
+		os.Unsetenv(EnvAuditSinkSyslogPolicy)
+		os.Unsetenv(EnvAuditSinkSyslogMaxBytes)
+		os.Unsetenv(EnvAuditSinkWebhookURL)
+		os.Unsetenv(EnvAuditSinkWebhookToken)
+		os.Unsetenv(EnvAuditSinkWebhookTimeout)
+		os.Unsetenv(EnvAuditSinkWebhookPolicy)
+		os.Unsetenv(EnvAuditSinkWebhookMaxBytes)
+		os.Unsetenv(EnvAuditSinkQueueSize)
+		os.Unsetenv(EnvAuditSinkBatchSize)
+		os.Unsetenv(EnvAuditSinkFlushInterval)
+		os.Unsetenv(EnvAuditSinkSpoolDir)
+		os.Unsetenv(EnvAuditSinkSpoolMaxBytes)
+		os.Unsetenv(EnvUsagePriceFile)
+		os.Unsetenv(EnvStatsRollupInterval)
+		os.Unsetenv(EnvAPIKeyActivityFlush)
 	}
 
 	t.Run("DefaultValues", func(t *testing.T) {
@@ -60,6 +125,314 @@ func TestLoad(t *testing.T) {
 		if cfg.PerKeyMaxQueue != DefaultPerKeyMaxQueue {
 			t.Errorf("expected per key max queue %d, got %d", DefaultPerKeyMaxQueue, cfg.PerKeyMaxQueue)
 		}
+		if cfg.UpstreamCoordination != UpstreamCoordinationMemory {
+			t.Errorf("expected upstream coordination %s, got %s", UpstreamCoordinationMemory, cfg.UpstreamCoordination)
+		}
+		if cfg.APIKeyCacheTTL != DefaultAPIKeyCacheTTL {
+			t.Errorf("expected api key cache ttl %v, got %v", DefaultAPIKeyCacheTTL, cfg.APIKeyCacheTTL)
+		}
+	})
+
+	t.Run("ReplayProtection", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.ReplayStore != ReplayStoreMemory || cfg.ReplayProtectGetResult {
+			t.Errorf("unexpected replay defaults: store=%s protect_get_result=%v", cfg.ReplayStore, cfg.ReplayProtectGetResult)
+		}
+
+		os.Setenv(EnvReplayStore, "Database")
+		os.Setenv(EnvReplayProtectGetResult, "true")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.ReplayStore != ReplayStoreDatabase || !cfg.ReplayProtectGetResult {
+			t.Errorf("unexpected replay config: store=%s protect_get_result=%v", cfg.ReplayStore, cfg.ReplayProtectGetResult)
+		}
+
+		os.Setenv(EnvReplayStore, "redis")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for unknown replay store, got nil")
+		}
+	})
+
+	t.Run("PresignMaxExpires", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.PresignMaxExpires != DefaultPresignMaxExpires {
+			t.Errorf("expected default presign max expires, got %v", cfg.PresignMaxExpires)
+		}
+
+		os.Setenv(EnvPresignMaxExpires, "15m")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.PresignMaxExpires != 15*time.Minute {
+			t.Errorf("expected 15m, got %v", cfg.PresignMaxExpires)
+		}
+
+		os.Setenv(EnvPresignMaxExpires, "0s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for zero presign max expires, got nil")
+		}
+	})
+
+	t.Run("AuthTokens", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuthTokenSigningKey != "" || cfg.AuthTokenMaxTTL != DefaultAuthTokenMaxTTL {
+			t.Errorf("unexpected auth token defaults: key set=%v max_ttl=%v", cfg.AuthTokenSigningKey != "", cfg.AuthTokenMaxTTL)
+		}
+
+		os.Setenv(EnvAuthTokenSigningKey, "c2lnbmluZy1rZXktc2lnbmluZy1rZXktc2lnbmluZy1rZXk=")
+		os.Setenv(EnvAuthTokenMaxTTL, "30m")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuthTokenSigningKey == "" || cfg.AuthTokenMaxTTL != 30*time.Minute {
+			t.Errorf("unexpected auth token config: max_ttl=%v", cfg.AuthTokenMaxTTL)
+		}
+
+		os.Setenv(EnvAuthTokenMaxTTL, "10s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for auth token max ttl below 1m, got nil")
+		}
+	})
+
+	t.Run("AdminAPIToken", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AdminAPIToken != "" {
+			t.Errorf("expected the admin api to be disabled by default")
+		}
+
+		os.Setenv(EnvAdminAPIToken, strings.Repeat("a", MinAdminAPITokenLength))
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AdminAPIToken != strings.Repeat("a", MinAdminAPITokenLength) {
+			t.Errorf("unexpected admin api token")
+		}
+
+		os.Setenv(EnvAdminAPIToken, "short")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for a short admin api token, got nil")
+		}
+	})
+
+	t.Run("SubmitValidation", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.SubmitValidation != SubmitValidationKnown {
+			t.Errorf("expected default submit validation %q, got %q", SubmitValidationKnown, cfg.SubmitValidation)
+		}
+
+		os.Setenv(EnvSubmitValidation, "Strict")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.SubmitValidation != SubmitValidationStrict {
+			t.Errorf("expected submit validation %q, got %q", SubmitValidationStrict, cfg.SubmitValidation)
+		}
+
+		os.Setenv(EnvSubmitValidation, "lenient")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for unknown submit validation mode, got nil")
+		}
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.PolicyFile != "" || cfg.PolicyReloadInterval != DefaultPolicyReloadInterval {
+			t.Errorf("unexpected policy defaults: file=%q interval=%v", cfg.PolicyFile, cfg.PolicyReloadInterval)
+		}
+
+		os.Setenv(EnvPolicyFile, "/etc/jimeng/policy.yaml")
+		os.Setenv(EnvPolicyReloadInterval, "0s")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.PolicyFile != "/etc/jimeng/policy.yaml" || cfg.PolicyReloadInterval != 0 {
+			t.Errorf("unexpected policy config: file=%q interval=%v", cfg.PolicyFile, cfg.PolicyReloadInterval)
+		}
+
+		os.Setenv(EnvPolicyReloadInterval, "-1s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for negative policy reload interval, got nil")
+		}
+	})
+
+	t.Run("ShutdownTimeout", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.ShutdownTimeout != DefaultShutdownTimeout {
+			t.Errorf("expected default shutdown timeout %v, got %v", DefaultShutdownTimeout, cfg.ShutdownTimeout)
+		}
+
+		os.Setenv(EnvShutdownTimeout, "0s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for zero shutdown timeout, got nil")
+		}
+	})
+
+	t.Run("ReadinessChecks", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.ReadyCheckTimeout != DefaultReadyCheckTimeout || cfg.ReadyCacheTTL != DefaultReadyCacheTTL || cfg.ReadyUpstreamProbe {
+			t.Errorf("unexpected readiness defaults: timeout=%v ttl=%v probe=%v", cfg.ReadyCheckTimeout, cfg.ReadyCacheTTL, cfg.ReadyUpstreamProbe)
+		}
+
+		os.Setenv(EnvReadyCheckTimeout, "500ms")
+		os.Setenv(EnvReadyCacheTTL, "5s")
+		os.Setenv(EnvReadyUpstreamProbe, "true")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.ReadyCheckTimeout != 500*time.Millisecond || cfg.ReadyCacheTTL != 5*time.Second || !cfg.ReadyUpstreamProbe {
+			t.Errorf("unexpected readiness config: timeout=%v ttl=%v probe=%v", cfg.ReadyCheckTimeout, cfg.ReadyCacheTTL, cfg.ReadyUpstreamProbe)
+		}
+
+		os.Setenv(EnvReadyCheckTimeout, "0s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for zero readiness check timeout, got nil")
+		}
+	})
+
+	t.Run("APIKeyCacheTTL", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		os.Setenv(EnvAPIKeyCacheTTL, "0s")
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyCacheTTL != 0 {
+			t.Errorf("expected api key cache disabled, got %v", cfg.APIKeyCacheTTL)
+		}
+
+		os.Setenv(EnvAPIKeyCacheTTL, "-1s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for negative %s, got nil", EnvAPIKeyCacheTTL)
+		}
+		os.Setenv(EnvAPIKeyCacheTTL, "30s")
+
+		if cfg.APIKeyCacheSyncInterval != DefaultAPIKeyCacheSyncInterval {
+			t.Errorf("expected default sync interval %v, got %v", DefaultAPIKeyCacheSyncInterval, cfg.APIKeyCacheSyncInterval)
+		}
+		os.Setenv(EnvAPIKeyCacheSyncInterval, "1s")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyCacheSyncInterval != time.Second {
+			t.Errorf("expected sync interval 1s, got %v", cfg.APIKeyCacheSyncInterval)
+		}
+		os.Setenv(EnvAPIKeyCacheSyncInterval, "-1s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for negative %s, got nil", EnvAPIKeyCacheSyncInterval)
+		}
+	})
+
+	t.Run("UpstreamCoordination", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		os.Setenv(EnvUpstreamCoordination, "postgres")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for postgres coordination on sqlite, got nil")
+		}
+
+		os.Setenv(EnvDatabaseType, "postgres")
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.UpstreamCoordination != UpstreamCoordinationPostgres {
+			t.Errorf("expected upstream coordination %s, got %s", UpstreamCoordinationPostgres, cfg.UpstreamCoordination)
+		}
+
+		os.Setenv(EnvUpstreamCoordination, "redis")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for unknown coordination backend, got nil")
+		}
 	})
 
 	t.Run("ReservedPerKeyPolicy", func(t *testing.T) {
@@ -177,6 +550,488 @@ API_KEY_ENCRYPTION_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=
 		}
 	})
 
+	t.Run("YAMLConfigFile", func(t *testing.T) {
+		clearEnv()
+		defer clearEnv()
+
+		path := filepath.Join(t.TempDir(), "jimeng.yaml")
+		content := `
+volc:
+  access_key: file-ak
+  secret_key: file-sk
+server:
+  port: 7070
+security:
+  api_key_encryption_key: MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=
+limits:
+  upstream_max_concurrent: 3
+  upstream_max_queue: 20
+  upstream_submit_min_interval: 2s
+retention:
+  idempotency_ttl: 1h
+policy:
+  submit_validation: strict
+logging:
+  level: warn
+`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config file: %v", err)
+		}
+
+		os.Setenv(EnvUpstreamMaxQueue, "40")
+		cfg, err := Load(Options{ConfigFile: &path})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Credentials.AccessKey != "file-ak" || cfg.ServerPort != "7070" || cfg.UpstreamMaxConcurrent != 3 || cfg.UpstreamSubmitMinInterval != 2*time.Second {
+			t.Errorf("expected values from config file, got %+v", cfg)
+		}
+		if cfg.UpstreamMaxQueue != 40 {
+			t.Errorf("expected environment to override config file, got queue %d", cfg.UpstreamMaxQueue)
+		}
+		if cfg.IdempotencyTTL != time.Hour || cfg.SubmitValidation != SubmitValidationStrict || cfg.LogLevel != slog.LevelWarn {
+			t.Errorf("unexpected nested section values: ttl=%v validation=%s level=%v", cfg.IdempotencyTTL, cfg.SubmitValidation, cfg.LogLevel)
+		}
+
+		if err := os.WriteFile(path, []byte("limits:\n  upstream_max_concurent: 3\n"), 0o600); err != nil {
+			t.Fatalf("write config file: %v", err)
+		}
+		if _, err := Load(Options{ConfigFile: &path}); err == nil || !strings.Contains(err.Error(), "limits.upstream_max_concurent") {
+			t.Fatalf("expected unknown key error, got %v", err)
+		}
+
+		missing := filepath.Join(t.TempDir(), "missing.yaml")
+		if _, err := Load(Options{ConfigFile: &missing}); err == nil {
+			t.Fatalf("expected error for missing config file, got nil")
+		}
+	})
+
+	t.Run("ReloadSeesFileEdits", func(t *testing.T) {
+		clearEnv()
+		defer clearEnv()
+		t.Chdir(t.TempDir())
+
+		base := "VOLC_ACCESSKEY=ak\nVOLC_SECRETKEY=sk\nAPI_KEY_ENCRYPTION_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"
+		if err := os.WriteFile(".env", []byte(base+"UPSTREAM_MAX_CONCURRENT=2\n"), 0o600); err != nil {
+			t.Fatalf("write .env: %v", err)
+		}
+		cfg, err := Load(Options{})
+		if err != nil || cfg.UpstreamMaxConcurrent != 2 {
+			t.Fatalf("expected ./.env to be read, got %d, %v", cfg.UpstreamMaxConcurrent, err)
+		}
+		if _, ok := os.LookupEnv(EnvUpstreamMaxConcurrent); ok {
+			t.Fatalf("expected ./.env not to be copied into the environment")
+		}
+		if err := os.WriteFile(".env", []byte(base+"UPSTREAM_MAX_CONCURRENT=5\n"), 0o600); err != nil {
+			t.Fatalf("write .env: %v", err)
+		}
+		if cfg, err = Load(Options{}); err != nil || cfg.UpstreamMaxConcurrent != 5 {
+			t.Fatalf("expected the edited ./.env to apply, got %d, %v", cfg.UpstreamMaxConcurrent, err)
+		}
+
+		// A config file replaces ./.env, so its edits are not shadowed by it.
+		path := filepath.Join(t.TempDir(), "jimeng.yaml")
+		writeYAML := func(n int) {
+			t.Helper()
+			content := fmt.Sprintf("volc:\n  access_key: yaml-ak\n  secret_key: yaml-sk\nsecurity:\n  api_key_encryption_key: MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\nlimits:\n  upstream_max_concurrent: %d\n", n)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("write config file: %v", err)
+			}
+		}
+		writeYAML(3)
+		if cfg, err = Load(Options{ConfigFile: &path}); err != nil || cfg.UpstreamMaxConcurrent != 3 || cfg.Credentials.AccessKey != "yaml-ak" {
+			t.Fatalf("expected config file values, got %d %q, %v", cfg.UpstreamMaxConcurrent, cfg.Credentials.AccessKey, err)
+		}
+		writeYAML(4)
+		if cfg, err = Load(Options{ConfigFile: &path}); err != nil || cfg.UpstreamMaxConcurrent != 4 {
+			t.Fatalf("expected the edited config file to apply, got %d, %v", cfg.UpstreamMaxConcurrent, err)
+		}
+
+		os.Setenv(EnvUpstreamMaxConcurrent, "8")
+		if cfg, err = Load(Options{ConfigFile: &path}); err != nil || cfg.UpstreamMaxConcurrent != 8 {
+			t.Fatalf("expected the environment to win, got %d, %v", cfg.UpstreamMaxConcurrent, err)
+		}
+	})
+
+	t.Run("LogLevel", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.LogLevel != slog.LevelInfo || cfg.IdempotencyTTL != DefaultIdempotencyTTL {
+			t.Errorf("unexpected defaults: level=%v idempotency ttl=%v", cfg.LogLevel, cfg.IdempotencyTTL)
+		}
+
+		os.Setenv(EnvLogLevel, "ERROR")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.LogLevel != slog.LevelError {
+			t.Errorf("expected error level, got %v", cfg.LogLevel)
+		}
+
+		os.Setenv(EnvDebug, "true")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.LogLevel != slog.LevelDebug {
+			t.Errorf("expected DEBUG=true to force debug level, got %v", cfg.LogLevel)
+		}
+
+		os.Setenv(EnvLogLevel, "verbose")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for invalid log level, got nil")
+		}
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.TLSCertFile != "" || cfg.TLSMinVersion != TLSVersion12 || cfg.TLSClientAuth != TLSClientAuthOff || cfg.TLSReloadInterval != DefaultTLSReloadInterval {
+			t.Errorf("unexpected tls defaults: %+v", cfg)
+		}
+
+		os.Setenv(EnvTLSCertFile, "/etc/jimeng/tls.crt")
+		os.Setenv(EnvTLSKeyFile, "/etc/jimeng/tls.key")
+		os.Setenv(EnvTLSClientCAFile, "/etc/jimeng/ca.crt")
+		os.Setenv(EnvTLSMinVersion, "1.3")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.TLSClientAuth != TLSClientAuthOptional || cfg.TLSMinVersion != TLSVersion13 {
+			t.Errorf("expected client CA to enable optional verification, got auth=%s min=%s", cfg.TLSClientAuth, cfg.TLSMinVersion)
+		}
+
+		os.Unsetenv(EnvTLSClientCAFile)
+		os.Setenv(EnvTLSClientAuth, "require")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for client auth without a client CA, got nil")
+		}
+
+		os.Unsetenv(EnvTLSClientAuth)
+		os.Unsetenv(EnvTLSKeyFile)
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for cert without key, got nil")
+		}
+	})
+
+	t.Run("AuditBody", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditBodySubmit != AuditBodyNone || cfg.AuditBodyGetResult != AuditBodyNone || cfg.AuditBodyMaxBytes != DefaultAuditBodyMaxBytes {
+			t.Errorf("unexpected audit body defaults: submit=%s get_result=%s max=%d", cfg.AuditBodySubmit, cfg.AuditBodyGetResult, cfg.AuditBodyMaxBytes)
+		}
+
+		os.Setenv(EnvAuditBodySubmit, "Metadata")
+		os.Setenv(EnvAuditBodyGetResult, "full")
+		os.Setenv(EnvAuditBodyMaxBytes, "4096")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditBodySubmit != AuditBodyMetadata || cfg.AuditBodyGetResult != AuditBodyFull || cfg.AuditBodyMaxBytes != 4096 {
+			t.Errorf("unexpected audit body settings: submit=%s get_result=%s max=%d", cfg.AuditBodySubmit, cfg.AuditBodyGetResult, cfg.AuditBodyMaxBytes)
+		}
+
+		os.Setenv(EnvAuditBodyMaxBytes, "0")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for zero %s, got nil", EnvAuditBodyMaxBytes)
+		}
+
+		os.Setenv(EnvAuditBodyMaxBytes, "4096")
+		os.Setenv(EnvAuditBodySubmit, "everything")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for invalid %s, got nil", EnvAuditBodySubmit)
+		}
+	})
+
+	t.Run("AuditSinks", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditSinkFilePath != "" || cfg.AuditSinkSyslogAddr != "" || cfg.AuditSinkWebhookURL != "" {
+			t.Errorf("expected no audit sinks by default")
+		}
+		if cfg.AuditSinkFilePolicy != AuditSinkBestEffort || cfg.AuditSinkQueueSize != DefaultAuditSinkQueueSize || cfg.AuditSinkSpoolDir != DefaultAuditSinkSpoolDir {
+			t.Errorf("unexpected audit sink defaults: policy=%s queue=%d spool=%s", cfg.AuditSinkFilePolicy, cfg.AuditSinkQueueSize, cfg.AuditSinkSpoolDir)
+		}
+
+		os.Setenv(EnvAuditSinkWebhookURL, "https://siem.example.com/ingest")
+		os.Setenv(EnvAuditSinkWebhookPolicy, "FAIL_CLOSED")
+		os.Setenv(EnvAuditSinkSyslogAddr, "udp://127.0.0.1:514")
+		os.Setenv(EnvAuditSinkFileMaxBytes, "1048576")
+		os.Setenv(EnvAuditSinkFlushInterval, "250ms")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditSinkWebhookPolicy != AuditSinkFailClosed || cfg.AuditSinkSyslogPolicy != AuditSinkBestEffort || cfg.AuditSinkFileMaxBytes != 1<<20 || cfg.AuditSinkFlushInterval != 250*time.Millisecond {
+			t.Errorf("unexpected audit sink settings: webhook=%s syslog=%s max=%d flush=%s", cfg.AuditSinkWebhookPolicy, cfg.AuditSinkSyslogPolicy, cfg.AuditSinkFileMaxBytes, cfg.AuditSinkFlushInterval)
+		}
+
+		os.Setenv(EnvAuditSinkWebhookPolicy, "maybe")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for invalid %s, got nil", EnvAuditSinkWebhookPolicy)
+		}
+		os.Setenv(EnvAuditSinkWebhookPolicy, "best_effort")
+		os.Setenv(EnvAuditSinkQueueSize, "0")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for zero %s, got nil", EnvAuditSinkQueueSize)
+		}
+		os.Unsetenv(EnvAuditSinkQueueSize)
+		if cfg.AuditSinkSyslogMaxBytes != DefaultAuditSinkSyslogMaxBytes || cfg.AuditSinkWebhookMaxBytes != DefaultAuditSinkWebhookMaxBytes {
+			t.Errorf("unexpected sink size defaults: syslog=%d webhook=%d", cfg.AuditSinkSyslogMaxBytes, cfg.AuditSinkWebhookMaxBytes)
+		}
+		os.Setenv(EnvAuditSinkSyslogMaxBytes, "70000")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for a syslog message size above one datagram, got nil")
+		}
+	})
+
+	t.Run("DatabaseAutoMigrate", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !cfg.DatabaseAutoMigrate {
+			t.Errorf("expected migrations to run on start by default")
+		}
+		os.Setenv(EnvDatabaseAutoMigrate, "false")
+		if cfg, err = Load(Options{}); err != nil || cfg.DatabaseAutoMigrate {
+			t.Errorf("expected %s=false to disable auto migration, got %t, %v", EnvDatabaseAutoMigrate, cfg.DatabaseAutoMigrate, err)
+		}
+		os.Setenv(EnvDatabaseAutoMigrate, "sometimes")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for invalid %s, got nil", EnvDatabaseAutoMigrate)
+		}
+	})
+
+	t.Run("UsagePriceFile", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.UsagePriceFile != "" {
+			t.Errorf("expected no price file by default, got %q", cfg.UsagePriceFile)
+		}
+		os.Setenv(EnvUsagePriceFile, "/etc/jimeng/prices.yaml")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.UsagePriceFile != "/etc/jimeng/prices.yaml" {
+			t.Errorf("unexpected price file %q", cfg.UsagePriceFile)
+		}
+	})
+
+	t.Run("APIKeyActivityFlush", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyActivityFlush != DefaultAPIKeyActivityFlush {
+			t.Errorf("unexpected default activity flush interval %s", cfg.APIKeyActivityFlush)
+		}
+		os.Setenv(EnvAPIKeyActivityFlush, "0")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyActivityFlush != 0 {
+			t.Errorf("expected 0 to disable tracking, got %s", cfg.APIKeyActivityFlush)
+		}
+		os.Setenv(EnvAPIKeyActivityFlush, "soon")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for invalid %s, got nil", EnvAPIKeyActivityFlush)
+		}
+	})
+
+	t.Run("StatsRollupInterval", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.StatsRollupInterval != DefaultStatsRollupInterval {
+			t.Errorf("unexpected default rollup interval %s", cfg.StatsRollupInterval)
+		}
+		os.Setenv(EnvStatsRollupInterval, "0")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.StatsRollupInterval != 0 {
+			t.Errorf("expected 0 to disable rollups, got %s", cfg.StatsRollupInterval)
+		}
+		os.Setenv(EnvStatsRollupInterval, "-1m")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for negative %s, got nil", EnvStatsRollupInterval)
+		}
+	})
+
+	t.Run("APIKeyEncryptionKeys", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		defer clearEnv()
+
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error without an encryption key, got nil")
+		}
+		os.Setenv(EnvAPIKeyEncryptionKeys, " 2025:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=,2026:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA= ")
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !strings.HasPrefix(cfg.APIKeyEncryptionKeys, "2025:") || cfg.APIKeyEncryptionKey != "" {
+			t.Errorf("unexpected keyring %q", cfg.APIKeyEncryptionKeys)
+		}
+		os.Setenv(EnvAuditCheckpointKey, "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error when %s reuses a keyring key, got nil", EnvAuditCheckpointKey)
+		}
+		os.Unsetenv(EnvAuditCheckpointKey)
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error when both %s and %s are set, got nil", EnvAPIKeyEncryptionKey, EnvAPIKeyEncryptionKeys)
+		}
+	})
+
+	t.Run("APIKeyKeyProvider", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		defer clearEnv()
+
+		os.Setenv(EnvAPIKeyKeyProvider, "file")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error without %s, got nil", EnvAPIKeyKeyFile)
+		}
+		os.Setenv(EnvAPIKeyKeyFile, "/run/secrets/api-key")
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyKeyProvider != KeyProviderFile || cfg.APIKeyKeyFile != "/run/secrets/api-key" {
+			t.Errorf("unexpected file provider settings: %q %q", cfg.APIKeyKeyProvider, cfg.APIKeyKeyFile)
+		}
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error when a raw key is set alongside the file provider, got nil")
+		}
+		os.Unsetenv(EnvAPIKeyEncryptionKey)
+
+		os.Setenv(EnvAPIKeyKeyProvider, "KMS")
+		os.Setenv(EnvAPIKeyKMSEndpoint, "https://kms.internal")
+		os.Setenv(EnvAPIKeyKMSKeyID, "relay-master")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error without %s, got nil", EnvAPIKeyKMSWrappedKeys)
+		}
+		os.Setenv(EnvAPIKeyKMSWrappedKeys, "dk1:AQID")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.APIKeyKeyProvider != KeyProviderKMS || cfg.APIKeyKMSCacheTTL != DefaultAPIKeyKMSCacheTTL {
+			t.Errorf("unexpected kms settings: %q %s", cfg.APIKeyKeyProvider, cfg.APIKeyKMSCacheTTL)
+		}
+		os.Setenv(EnvAPIKeyKMSCacheTTL, "-1s")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for negative %s, got nil", EnvAPIKeyKMSCacheTTL)
+		}
+
+		os.Setenv(EnvAPIKeyKeyProvider, "vault")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error for unknown provider, got nil")
+		}
+	})
+
+	t.Run("AuditCheckpoints", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvAccessKey, "ak")
+		os.Setenv(EnvSecretKey, "sk")
+		os.Setenv(EnvAPIKeyEncryptionKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		defer clearEnv()
+
+		cfg, err := Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditCheckpointKey != "" || cfg.AuditCheckpointInterval != DefaultAuditCheckpointInterval {
+			t.Errorf("unexpected checkpoint defaults: key set=%t interval=%s", cfg.AuditCheckpointKey != "", cfg.AuditCheckpointInterval)
+		}
+
+		os.Setenv(EnvAuditCheckpointKey, "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+		os.Setenv(EnvAuditCheckpointInterval, "15m")
+		cfg, err = Load(Options{})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.AuditCheckpointKey == "" || cfg.AuditCheckpointInterval != 15*time.Minute {
+			t.Errorf("unexpected checkpoint settings: interval=%s", cfg.AuditCheckpointInterval)
+		}
+
+		os.Setenv(EnvAuditCheckpointKey, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+		if _, err := Load(Options{}); err == nil {
+			t.Fatalf("expected error when %s reuses %s, got nil", EnvAuditCheckpointKey, EnvAPIKeyEncryptionKey)
+		}
+	})
+
 	t.Run("InvalidTimeout", func(t *testing.T) {
 		clearEnv()
 		os.Setenv(EnvAccessKey, "ak")
@@ -200,6 +1055,23 @@ API_KEY_ENCRYPTION_KEY=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=
 			t.Error("expected error for missing credentials, got nil")
 		}
 	})
+
+	t.Run("SkipCredentials", func(t *testing.T) {
+		clearEnv()
+		os.Setenv(EnvDatabaseURL, "/data/relay.db")
+		defer clearEnv()
+
+		cfg, err := Load(Options{SkipCredentials: true})
+		if err != nil {
+			t.Fatalf("expected no error without credentials, got %v", err)
+		}
+		if cfg.DatabaseURL != "/data/relay.db" {
+			t.Errorf("expected database url from env, got %q", cfg.DatabaseURL)
+		}
+		if err := ValidateEncryptionKeys(cfg); err == nil {
+			t.Error("expected master key error, got nil")
+		}
+	})
 }
 
 func TestRedactAccessKey(t *testing.T) {
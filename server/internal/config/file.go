@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileKeys maps the sections and keys of a YAML config file to the
+// environment variable each one stands in for. Values go through the same
+// parsing and validation as the environment, which takes precedence.
+var fileKeys = map[string]map[string]string{
+	"volc": {
+		"access_key": EnvAccessKey,
+		"secret_key": EnvSecretKey,
+		"region":     EnvRegion,
+		"host":       EnvHost,
+		"timeout":    EnvTimeout,
+	},
+	"server": {
+		"port":             EnvServerPort,
+		"shutdown_timeout": EnvShutdownTimeout,
+	},
+	"tls": {
+		"cert_file":       EnvTLSCertFile,
+		"key_file":        EnvTLSKeyFile,
+		"min_version":     EnvTLSMinVersion,
+		"client_ca_file":  EnvTLSClientCAFile,
+		"client_auth":     EnvTLSClientAuth,
+		"reload_interval": EnvTLSReloadInterval,
+	},
+	"database": {
+		"type":         EnvDatabaseType,
+		"url":          EnvDatabaseURL,
+		"auto_migrate": EnvDatabaseAutoMigrate,
+	},
+	"security": {
+		"api_key_encryption_key":          EnvAPIKeyEncryptionKey,
+		"api_key_encryption_keys":         EnvAPIKeyEncryptionKeys,
+		"api_key_key_provider":            EnvAPIKeyKeyProvider,
+		"api_key_key_file":                EnvAPIKeyKeyFile,
+		"api_key_kms_endpoint":            EnvAPIKeyKMSEndpoint,
+		"api_key_kms_key_id":              EnvAPIKeyKMSKeyID,
+		"api_key_kms_token":               EnvAPIKeyKMSToken,
+		"api_key_kms_wrapped_keys":        EnvAPIKeyKMSWrappedKeys,
+		"api_key_kms_cache_ttl":           EnvAPIKeyKMSCacheTTL,
+		"api_key_cache_ttl":               EnvAPIKeyCacheTTL,
+		"api_key_cache_sync_interval":     EnvAPIKeyCacheSyncInterval,
+		"api_key_activity_flush_interval": EnvAPIKeyActivityFlush,
+		"sigv4_replay_store":              EnvReplayStore,
+		"sigv4_replay_protect_get_result": EnvReplayProtectGetResult,
+		"sigv4_presign_max_expires":       EnvPresignMaxExpires,
+		"auth_token_signing_key":          EnvAuthTokenSigningKey,
+		"auth_token_max_ttl":              EnvAuthTokenMaxTTL,
+		"admin_api_token":                 EnvAdminAPIToken,
+	},
+	"limits": {
+		"upstream_max_concurrent":      EnvUpstreamMaxConcurrent,
+		"upstream_max_queue":           EnvUpstreamMaxQueue,
+		"upstream_submit_min_interval": EnvUpstreamSubmitMinInterval,
+		"upstream_coordination":        EnvUpstreamCoordination,
+		"per_key_max_concurrent":       EnvPerKeyMaxConcurrent,
+		"per_key_max_queue":            EnvPerKeyMaxQueue,
+	},
+	"retention": {
+		"idempotency_ttl": EnvIdempotencyTTL,
+	},
+	"policy": {
+		"file":              EnvPolicyFile,
+		"reload_interval":   EnvPolicyReloadInterval,
+		"submit_validation": EnvSubmitValidation,
+	},
+	"readiness": {
+		"check_timeout":  EnvReadyCheckTimeout,
+		"cache_ttl":      EnvReadyCacheTTL,
+		"upstream_probe": EnvReadyUpstreamProbe,
+	},
+	"audit": {
+		"body_submit":         EnvAuditBodySubmit,
+		"body_get_result":     EnvAuditBodyGetResult,
+		"body_max_bytes":      EnvAuditBodyMaxBytes,
+		"checkpoint_key":      EnvAuditCheckpointKey,
+		"checkpoint_interval": EnvAuditCheckpointInterval,
+	},
+	"audit_sinks": {
+		"file_path":         EnvAuditSinkFilePath,
+		"file_max_bytes":    EnvAuditSinkFileMaxBytes,
+		"file_max_backups":  EnvAuditSinkFileMaxBackups,
+		"file_policy":       EnvAuditSinkFilePolicy,
+		"syslog_addr":       EnvAuditSinkSyslogAddr,
+		"syslog_app_name":   EnvAuditSinkSyslogAppName,
+		"syslog_policy":     EnvAuditSinkSyslogPolicy,
+		"syslog_max_bytes":  EnvAuditSinkSyslogMaxBytes,
+		"webhook_url":       EnvAuditSinkWebhookURL,
+		"webhook_token":     EnvAuditSinkWebhookToken,
+		"webhook_timeout":   EnvAuditSinkWebhookTimeout,
+		"webhook_policy":    EnvAuditSinkWebhookPolicy,
+		"webhook_max_bytes": EnvAuditSinkWebhookMaxBytes,
+		"queue_size":        EnvAuditSinkQueueSize,
+		"batch_size":        EnvAuditSinkBatchSize,
+		"flush_interval":    EnvAuditSinkFlushInterval,
+		"spool_dir":         EnvAuditSinkSpoolDir,
+		"spool_max_bytes":   EnvAuditSinkSpoolMaxBytes,
+	},
+	"usage": {
+		"price_file": EnvUsagePriceFile,
+	},
+	"stats": {
+		"rollup_interval": EnvStatsRollupInterval,
+	},
+	"logging": {
+		"level": EnvLogLevel,
+	},
+}
+
+// isYAMLFile reports whether a --config path names a structured config file
+// rather than a dotenv file.
+func isYAMLFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// loadYAMLFile reads a structured config file into environment-variable keyed
+// values. Unlike .env files, an explicitly configured file must exist.
+func loadYAMLFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var sections map[string]map[string]string
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	if err := dec.Decode(&sections); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	for section, entries := range sections {
+		keys, ok := fileKeys[section]
+		if !ok {
+			return nil, fmt.Errorf("config file %s: unknown section %q (expected one of %s)", path, section, strings.Join(sortedKeys(fileKeys), ", "))
+		}
+		for key, value := range entries {
+			env, ok := keys[key]
+			if !ok {
+				return nil, fmt.Errorf("config file %s: unknown key %s.%s", path, section, key)
+			}
+			values[env] = value
+		}
+	}
+	return values, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -16,6 +16,8 @@ const (
 	ErrDatabaseError    Code = "DATABASE_ERROR"
 	ErrValidationFailed Code = "VALIDATION_FAILED"
 	ErrRateLimited      Code = "RATE_LIMITED"
+	ErrPolicyDenied     Code = "POLICY_DENIED"
+	ErrUnavailable      Code = "SERVICE_UNAVAILABLE"
 	ErrInternalError    Code = "INTERNAL_ERROR"
 	ErrUnknown          Code = "UNKNOWN"
 )
@@ -0,0 +1,212 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/handler/apierror"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/service/auditquery"
+)
+
+type querier interface {
+	AuditEvents(ctx context.Context, query repository.AuditEventQuery, selector models.LabelSelector, cursor string) (auditquery.AuditEventPage, error)
+	Requests(ctx context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, cursor string) (auditquery.RequestPage, error)
+	AuditEventGroups(ctx context.Context, query repository.AuditEventQuery, selector models.LabelSelector, groupBy string) ([]auditquery.LabelGroup, error)
+	RequestGroups(ctx context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, groupBy string) ([]auditquery.LabelGroup, error)
+}
+
+// Handler serves the operator API under /admin/v1. It authenticates with a
+// single shared token rather than API keys, so it must not be mounted behind
+// the relay's SigV4 middleware.
+type Handler struct {
+	querier querier
+	token   []byte
+	logger  *slog.Logger
+}
+
+func NewHandler(querier querier, token string, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Handler{querier: querier, token: []byte(token), logger: logger}
+}
+
+func (h *Handler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/v1/audit-events", h.handleAuditEvents)
+	mux.HandleFunc("/admin/v1/requests", h.handleRequests)
+	return h.authenticate(mux)
+}
+
+// authenticate requires "Authorization: Bearer <ADMIN_API_TOKEN>".
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := strings.TrimSpace(r.Header.Get("Authorization"))
+		ok := len(h.token) > 0 && len(v) > len("Bearer ") && strings.EqualFold(v[:len("Bearer ")], "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(v[len("Bearer "):])), h.token) == 1
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jimeng-relay-admin"`)
+			apierror.Write(w, http.StatusUnauthorized, internalerrors.New(internalerrors.ErrAuthFailed, "invalid admin token", nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAuditEvents answers GET /admin/v1/audit-events with the filters of
+// parseCommon plus event_type. With group_by the page also carries the
+// per-label counts of all matching events.
+func (h *Handler) handleAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, internalerrors.New(internalerrors.ErrValidationFailed, "method not allowed", nil))
+		return
+	}
+	q := r.URL.Query()
+	c, err := parseCommon(q)
+	if err != nil {
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	query := repository.AuditEventQuery{
+		From:      c.from,
+		To:        c.to,
+		APIKeyID:  c.apiKeyID,
+		Action:    c.action,
+		EventType: models.EventType(strings.TrimSpace(q.Get("event_type"))),
+		MinStatus: c.minStatus,
+		MaxStatus: c.maxStatus,
+		HasError:  c.hasError,
+		Order:     c.order,
+		Limit:     c.limit,
+	}
+	page, err := h.querier.AuditEvents(r.Context(), query, c.selector, c.cursor)
+	if err == nil && c.groupBy != "" {
+		page.GroupBy = c.groupBy
+		page.Groups, err = h.querier.AuditEventGroups(r.Context(), query, c.selector, c.groupBy)
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "admin audit event query failed", "error", err.Error())
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+// handleRequests answers GET /admin/v1/requests. Status and has_error look at
+// each request's latest upstream attempt; group_by works as for audit events.
+func (h *Handler) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, internalerrors.New(internalerrors.ErrValidationFailed, "method not allowed", nil))
+		return
+	}
+	c, err := parseCommon(r.URL.Query())
+	if err != nil {
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	query := repository.DownstreamRequestQuery{
+		From:      c.from,
+		To:        c.to,
+		APIKeyID:  c.apiKeyID,
+		Action:    models.DownstreamAction(c.action),
+		MinStatus: c.minStatus,
+		MaxStatus: c.maxStatus,
+		HasError:  c.hasError,
+		Order:     c.order,
+		Limit:     c.limit,
+	}
+	page, err := h.querier.Requests(r.Context(), query, c.selector, c.cursor)
+	if err == nil && c.groupBy != "" {
+		page.GroupBy = c.groupBy
+		page.Groups, err = h.querier.RequestGroups(r.Context(), query, c.selector, c.groupBy)
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "admin request query failed", "error", err.Error())
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+type commonParams struct {
+	from, to             time.Time
+	apiKeyID, action     string
+	minStatus, maxStatus int
+	hasError             *bool
+	order                repository.SortOrder
+	limit                int
+	selector             models.LabelSelector
+	groupBy              string
+	cursor               string
+}
+
+// parseCommon reads the parameters both endpoints accept: from and to
+// (YYYY-MM-DD or RFC3339; a date for to includes that whole UTC day),
+// api_key_id, selector, group_by, action, min_status, max_status, has_error,
+// order, limit and cursor. Ranges of values are checked by the service.
+func parseCommon(q url.Values) (commonParams, error) {
+	var c commonParams
+	var err error
+	if c.from, _, err = parseBound(q.Get("from")); err != nil {
+		return c, internalerrors.New(internalerrors.ErrValidationFailed, "invalid from: expected YYYY-MM-DD or RFC3339", err)
+	}
+	var isDate bool
+	if c.to, isDate, err = parseBound(q.Get("to")); err != nil {
+		return c, internalerrors.New(internalerrors.ErrValidationFailed, "invalid to: expected YYYY-MM-DD or RFC3339", err)
+	}
+	if isDate {
+		c.to = c.to.AddDate(0, 0, 1)
+	}
+	for name, dst := range map[string]*int{"min_status": &c.minStatus, "max_status": &c.maxStatus, "limit": &c.limit} {
+		if v := strings.TrimSpace(q.Get(name)); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return c, internalerrors.New(internalerrors.ErrValidationFailed, "invalid "+name+": expected an integer", err)
+			}
+		}
+	}
+	if v := strings.TrimSpace(q.Get("has_error")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, internalerrors.New(internalerrors.ErrValidationFailed, "invalid has_error: expected true or false", err)
+		}
+		c.hasError = &b
+	}
+	if c.selector, err = models.ParseLabelSelector(q.Get("selector")); err != nil {
+		return c, internalerrors.New(internalerrors.ErrValidationFailed, "invalid selector", err)
+	}
+	c.groupBy = strings.TrimSpace(q.Get("group_by"))
+	c.apiKeyID = strings.TrimSpace(q.Get("api_key_id"))
+	c.action = strings.TrimSpace(q.Get("action"))
+	c.order = repository.SortOrder(strings.ToLower(strings.TrimSpace(q.Get("order"))))
+	c.cursor = strings.TrimSpace(q.Get("cursor"))
+	return c, nil
+}
+
+func parseBound(v string) (time.Time, bool, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t.UTC(), false, err
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/service/auditquery"
+)
+
+const testToken = "admin-token-admin-token-admin-token"
+
+type fakeQuerier struct {
+	auditQuery   repository.AuditEventQuery
+	requestQuery repository.DownstreamRequestQuery
+	selector     models.LabelSelector
+	cursor       string
+	groupBy      string
+	err          error
+}
+
+func (f *fakeQuerier) AuditEvents(_ context.Context, query repository.AuditEventQuery, selector models.LabelSelector, cursor string) (auditquery.AuditEventPage, error) {
+	f.auditQuery, f.selector, f.cursor = query, selector, cursor
+	if f.err != nil {
+		return auditquery.AuditEventPage{}, f.err
+	}
+	return auditquery.AuditEventPage{Items: []models.AuditEvent{{ID: "e1"}}, NextCursor: "next"}, nil
+}
+
+func (f *fakeQuerier) Requests(_ context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, cursor string) (auditquery.RequestPage, error) {
+	f.requestQuery, f.selector, f.cursor = query, selector, cursor
+	if f.err != nil {
+		return auditquery.RequestPage{}, f.err
+	}
+	return auditquery.RequestPage{Items: []models.DownstreamRequest{{ID: "d1"}}}, nil
+}
+
+func (f *fakeQuerier) AuditEventGroups(_ context.Context, query repository.AuditEventQuery, selector models.LabelSelector, groupBy string) ([]auditquery.LabelGroup, error) {
+	f.auditQuery, f.selector, f.groupBy = query, selector, groupBy
+	return []auditquery.LabelGroup{{Value: "growth", Count: 3}, {Value: "(none)", Count: 1}}, nil
+}
+
+func (f *fakeQuerier) RequestGroups(_ context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, groupBy string) ([]auditquery.LabelGroup, error) {
+	f.requestQuery, f.selector, f.groupBy = query, selector, groupBy
+	return []auditquery.LabelGroup{{Value: "search", Count: 2}}, nil
+}
+
+func serve(q *fakeQuerier, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	NewHandler(q, testToken, slog.New(slog.NewTextHandler(io.Discard, nil))).Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_RequiresToken(t *testing.T) {
+	for _, token := range []string{"", "wrong", testToken + "x"} {
+		rec := serve(&fakeQuerier{}, http.MethodGet, "/admin/v1/audit-events", token)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("token %q: expected 401 with a challenge, got %d", token, rec.Code)
+		}
+	}
+
+	// An empty configured token never matches, not even an empty header.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/requests", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	NewHandler(&fakeQuerier{}, "", nil).Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a configured token, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AuditEventsPassesFilters(t *testing.T) {
+	q := &fakeQuerier{}
+	rec := serve(q, http.MethodGet, "/admin/v1/audit-events?from=2026-03-01&to=2026-03-01&api_key_id=k1&action=relay_submit&event_type=error&min_status=400&max_status=599&has_error=true&order=ASC&limit=50&cursor=abc", testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	got := q.auditQuery
+	if !got.From.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !got.To.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected window %s..%s", got.From, got.To)
+	}
+	if got.APIKeyID != "k1" || got.Action != "relay_submit" || got.EventType != models.EventTypeError ||
+		got.MinStatus != 400 || got.MaxStatus != 599 || got.HasError == nil || !*got.HasError ||
+		got.Order != repository.SortAsc || got.Limit != 50 || q.cursor != "abc" {
+		t.Fatalf("unexpected query %+v cursor=%q", got, q.cursor)
+	}
+
+	var page auditquery.AuditEventPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(page.Items) != 1 || page.NextCursor != "next" || page.GroupBy != "" || page.Groups != nil || q.groupBy != "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected Cache-Control: no-store")
+	}
+}
+
+func TestHandler_RequestsPassesFilters(t *testing.T) {
+	q := &fakeQuerier{}
+	rec := serve(q, http.MethodGet, "/admin/v1/requests?from=2026-03-01T08:00:00%2B08:00&action=CVSync2AsyncGetResult&has_error=false&selector=team%3Dgrowth,!temporary", testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	got := q.requestQuery
+	if !got.From.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !got.To.IsZero() ||
+		got.Action != models.DownstreamActionCVSync2AsyncGetResult || got.HasError == nil || *got.HasError {
+		t.Fatalf("unexpected query %+v", got)
+	}
+	if q.selector.String() != "team=growth,!temporary" || !q.selector.Matches(map[string]string{"team": "growth"}) || q.selector.Matches(map[string]string{"team": "growth", "temporary": "yes"}) {
+		t.Fatalf("unexpected selector %q", q.selector)
+	}
+}
+
+func TestHandler_GroupByCountsPerLabel(t *testing.T) {
+	q := &fakeQuerier{}
+	rec := serve(q, http.MethodGet, "/admin/v1/audit-events?selector=environment%3Dprod&group_by=team&action=relay_submit", testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if q.groupBy != "team" || q.auditQuery.Action != "relay_submit" || q.selector.String() != "environment=prod" {
+		t.Fatalf("expected the groups to use the page filters, got group_by=%q query=%+v selector=%q", q.groupBy, q.auditQuery, q.selector)
+	}
+	var page auditquery.AuditEventPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(page.Items) != 1 || page.GroupBy != "team" || len(page.Groups) != 2 || page.Groups[0] != (auditquery.LabelGroup{Value: "growth", Count: 3}) {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	q = &fakeQuerier{}
+	rec = serve(q, http.MethodGet, "/admin/v1/requests?group_by=team", testToken)
+	var requests auditquery.RequestPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &requests); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("decode response: %d %v", rec.Code, err)
+	}
+	if q.groupBy != "team" || requests.GroupBy != "team" || len(requests.Groups) != 1 || requests.Groups[0].Count != 2 {
+		t.Fatalf("unexpected page %+v", requests)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, target string
+		err                  error
+		status               int
+	}{
+		{name: "method", method: http.MethodPost, target: "/admin/v1/audit-events", status: http.StatusMethodNotAllowed},
+		{name: "from", method: http.MethodGet, target: "/admin/v1/audit-events?from=yesterday", status: http.StatusBadRequest},
+		{name: "status", method: http.MethodGet, target: "/admin/v1/requests?min_status=4xx", status: http.StatusBadRequest},
+		{name: "has_error", method: http.MethodGet, target: "/admin/v1/requests?has_error=maybe", status: http.StatusBadRequest},
+		{name: "selector", method: http.MethodGet, target: "/admin/v1/audit-events?selector=Team%3D%3D", status: http.StatusBadRequest},
+		{name: "service validation", method: http.MethodGet, target: "/admin/v1/audit-events",
+			err: internalerrors.New(internalerrors.ErrValidationFailed, "invalid cursor", nil), status: http.StatusBadRequest},
+		{name: "database", method: http.MethodGet, target: "/admin/v1/requests",
+			err: internalerrors.New(internalerrors.ErrDatabaseError, "list downstream requests", nil), status: http.StatusInternalServerError},
+	} {
+		rec := serve(&fakeQuerier{err: tc.err}, tc.method, tc.target, testToken)
+		if rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d body=%s", tc.name, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package apierror writes the JSON error body shared by the relay's JSON
+// APIs: {"error":{"code":"...","message":"..."}}.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+// Status maps the code of err to an HTTP status. Errors without a code are
+// internal errors.
+func Status(err error) int {
+	var e *internalerrors.Error
+	if !errors.As(err, &e) {
+		return http.StatusInternalServerError
+	}
+	switch e.Code {
+	case internalerrors.ErrValidationFailed:
+		return http.StatusBadRequest
+	case internalerrors.ErrAuthFailed, internalerrors.ErrKeyRevoked, internalerrors.ErrKeyExpired:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Write sends err with status. Errors without a code are reported as
+// ErrUnknown.
+func Write(w http.ResponseWriter, status int, err error) {
+	code := internalerrors.GetCode(err)
+	if code == "" {
+		code = internalerrors.ErrUnknown
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": err.Error(),
+		},
+	})
+}
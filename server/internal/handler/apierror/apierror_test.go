@@ -0,0 +1,49 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+func TestStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{err: internalerrors.New(internalerrors.ErrValidationFailed, "bad", nil), want: http.StatusBadRequest},
+		{err: internalerrors.New(internalerrors.ErrAuthFailed, "denied", nil), want: http.StatusUnauthorized},
+		{err: internalerrors.New(internalerrors.ErrKeyRevoked, "revoked", nil), want: http.StatusUnauthorized},
+		{err: internalerrors.New(internalerrors.ErrKeyExpired, "expired", nil), want: http.StatusUnauthorized},
+		{err: internalerrors.New(internalerrors.ErrDatabaseError, "db", nil), want: http.StatusInternalServerError},
+		{err: errors.New("plain"), want: http.StatusInternalServerError},
+	} {
+		if got := Status(tc.err); got != tc.want {
+			t.Fatalf("%v: expected %d, got %d", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, http.StatusTeapot, errors.New("plain"))
+	if rec.Code != http.StatusTeapot || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Error.Code != string(internalerrors.ErrUnknown) || body.Error.Message != "plain" {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+}
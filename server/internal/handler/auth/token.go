@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/handler/apierror"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/service/authtoken"
+)
+
+const maxTokenRequestBytes = 4 << 10
+
+type tokenIssuer interface {
+	Issue(ctx context.Context, apiKeyID string, req authtoken.IssueRequest) (authtoken.Token, error)
+}
+
+// TokenHandler exchanges a SigV4-authenticated request for a short-lived
+// bearer token. It must sit behind the SigV4 middleware only: a bearer token
+// cannot be used to mint another one.
+type TokenHandler struct {
+	issuer tokenIssuer
+	now    func() time.Time
+	logger *slog.Logger
+}
+
+type tokenRequest struct {
+	Scopes     []string `json:"scopes"`
+	TTLSeconds int64    `json:"ttl_seconds"`
+}
+
+type tokenResponse struct {
+	authtoken.Token
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+func NewTokenHandler(issuer tokenIssuer, logger *slog.Logger) *TokenHandler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &TokenHandler{issuer: issuer, now: func() time.Time { return time.Now().UTC() }, logger: logger}
+}
+
+func (h *TokenHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/token", h.handleToken)
+	return mux
+}
+
+func (h *TokenHandler) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, internalerrors.New(internalerrors.ErrValidationFailed, "method not allowed", nil))
+		return
+	}
+	apiKeyID, _ := r.Context().Value(sigv4.ContextAPIKeyID).(string)
+	if strings.TrimSpace(apiKeyID) == "" {
+		apierror.Write(w, http.StatusUnauthorized, internalerrors.New(internalerrors.ErrAuthFailed, "missing api key", nil))
+		return
+	}
+
+	var req tokenRequest
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTokenRequestBytes+1))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "read request body", err))
+		return
+	}
+	if len(body) > maxTokenRequestBytes {
+		apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "request body too large", nil))
+		return
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "invalid json body", err))
+			return
+		}
+	}
+	if req.TTLSeconds < 0 {
+		apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "ttl_seconds must be positive", nil))
+		return
+	}
+
+	tok, err := h.issuer.Issue(r.Context(), apiKeyID, authtoken.IssueRequest{Scopes: req.Scopes, TTL: time.Duration(req.TTLSeconds) * time.Second})
+	if err != nil {
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "issued bearer token", "api_key_id", apiKeyID, "scopes", tok.Scopes, "expires_at", tok.ExpiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(tokenResponse{Token: tok, ExpiresIn: int64(tok.ExpiresAt.Sub(h.now()) / time.Second)})
+}
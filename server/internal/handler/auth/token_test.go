@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/service/authtoken"
+)
+
+type fakeIssuer struct {
+	apiKeyID string
+	req      authtoken.IssueRequest
+	err      error
+}
+
+func (f *fakeIssuer) Issue(_ context.Context, apiKeyID string, req authtoken.IssueRequest) (authtoken.Token, error) {
+	f.apiKeyID = apiKeyID
+	f.req = req
+	if f.err != nil {
+		return authtoken.Token{}, f.err
+	}
+	return authtoken.Token{Token: "tok", TokenType: "Bearer", APIKeyID: apiKeyID, Scopes: req.Scopes, ExpiresAt: time.Now().UTC().Add(req.TTL)}, nil
+}
+
+func newRequest(body string, apiKeyID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/token", bytes.NewBufferString(body))
+	if apiKeyID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, apiKeyID))
+	}
+	return req
+}
+
+func TestTokenHandler_IssuesForAuthenticatedKey(t *testing.T) {
+	issuer := &fakeIssuer{}
+	h := NewTokenHandler(issuer, slog.New(slog.NewTextHandler(io.Discard, nil))).Routes()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newRequest(`{"scopes":["get-result"],"ttl_seconds":600}`, "key_1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if issuer.apiKeyID != "key_1" || issuer.req.TTL != 10*time.Minute || len(issuer.req.Scopes) != 1 {
+		t.Fatalf("unexpected issue call: %q %+v", issuer.apiKeyID, issuer.req)
+	}
+	var resp struct {
+		Token     string `json:"token"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Token != "tok" || resp.ExpiresIn <= 0 || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("unexpected response: %s", rec.Body.String())
+	}
+}
+
+func TestTokenHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *http.Request
+		issueErr error
+		status   int
+	}{
+		{name: "unauthenticated", req: newRequest(`{}`, ""), status: http.StatusUnauthorized},
+		{name: "invalid json", req: newRequest(`{`, "key_1"), status: http.StatusBadRequest},
+		{name: "validation", req: newRequest(`{"scopes":["admin"]}`, "key_1"), issueErr: internalerrors.New(internalerrors.ErrValidationFailed, "unknown scope: admin", nil), status: http.StatusBadRequest},
+		{name: "revoked", req: newRequest(``, "key_1"), issueErr: internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil), status: http.StatusUnauthorized},
+		{name: "method", req: httptest.NewRequest(http.MethodGet, "/v1/auth/token", nil), status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewTokenHandler(&fakeIssuer{err: tt.issueErr}, slog.New(slog.NewTextHandler(io.Discard, nil))).Routes()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d body=%s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
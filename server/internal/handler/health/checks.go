@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCheckTimeout = 2 * time.Second
+	DefaultCacheTTL     = 2 * time.Second
+)
+
+// Check is one readiness probe. Run returns optional details to include in the
+// response; an error marks the check as failed.
+type Check struct {
+	Name string
+	// Optional checks are reported but never make /ready fail.
+	Optional bool
+	Run      func(ctx context.Context) (map[string]any, error)
+}
+
+type ChecksConfig struct {
+	Checks []Check
+	// Timeout bounds each check. Defaults to DefaultCheckTimeout.
+	Timeout time.Duration
+	// CacheTTL is how long a result is served before the checks run again, so
+	// a burst of probes costs one round of checks. Defaults to DefaultCacheTTL.
+	CacheTTL time.Duration
+	Now      func() time.Time
+}
+
+type checkResult struct {
+	Status    string         `json:"status"`
+	Optional  bool           `json:"optional,omitempty"`
+	LatencyMs int64          `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type report struct {
+	ready     bool
+	checks    map[string]checkResult
+	checkedAt time.Time
+}
+
+type checker struct {
+	cfg ChecksConfig
+
+	mu     sync.Mutex
+	cached *report
+}
+
+func newChecker(cfg ChecksConfig) *checker {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultCheckTimeout
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = DefaultCacheTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &checker{cfg: cfg}
+}
+
+// run returns the cached report while it is fresh. Concurrent callers wait for
+// a single in-progress round instead of each probing the dependencies.
+func (c *checker) run(ctx context.Context) report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != nil && c.cfg.Now().Sub(c.cached.checkedAt) < c.cfg.CacheTTL {
+		return *c.cached
+	}
+
+	results := make([]checkResult, len(c.cfg.Checks))
+	var wg sync.WaitGroup
+	for i, check := range c.cfg.Checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.runOne(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	r := report{ready: true, checks: make(map[string]checkResult, len(results)), checkedAt: c.cfg.Now()}
+	for i, check := range c.cfg.Checks {
+		r.checks[check.Name] = results[i]
+		if results[i].Status != "ok" && !check.Optional {
+			r.ready = false
+		}
+	}
+	c.cached = &r
+	return r
+}
+
+func (c *checker) runOne(ctx context.Context, check Check) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	details, err := check.Run(ctx)
+	res := checkResult{Status: "ok", Optional: check.Optional, LatencyMs: time.Since(start).Milliseconds(), Details: details}
+	if err != nil {
+		res.Status = "error"
+		res.Error = err.Error()
+	}
+	return res
+}
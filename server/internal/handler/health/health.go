@@ -1,13 +1,16 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 )
 
 // Handler provides health check endpoints
 type Handler struct {
-	dbReady func() bool
+	dbReady  func() bool
+	draining func() bool
+	checks   *checker
 }
 
 // NewHandler creates a new health check handler
@@ -15,6 +18,20 @@ func NewHandler(dbReady func() bool) *Handler {
 	return &Handler{dbReady: dbReady}
 }
 
+// WithDraining makes Ready report 503 once draining returns true, so load
+// balancers stop routing to an instance that is shutting down.
+func (h *Handler) WithDraining(draining func() bool) *Handler {
+	h.draining = draining
+	return h
+}
+
+// WithChecks makes Ready run the given probes (cached for cfg.CacheTTL) and
+// report each one; any failing non-optional check turns /ready into 503.
+func (h *Handler) WithChecks(cfg ChecksConfig) *Handler {
+	h.checks = newChecker(cfg)
+	return h
+}
+
 // Health returns liveness status (process is running)
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -26,6 +43,28 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if h.draining != nil && h.draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status":  "draining",
+			"message": "server is shutting down",
+		})
+		return
+	}
+
+	if h.checks != nil {
+		// A client hanging up must not cancel (and cache the failure of) a
+		// round other probes are waiting on.
+		rep := h.checks.run(context.WithoutCancel(r.Context()))
+		status, code := "ok", http.StatusOK
+		if !rep.ready {
+			status, code = "error", http.StatusServiceUnavailable
+		}
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": rep.checks})
+		return
+	}
+
 	if h.dbReady == nil || h.dbReady() {
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
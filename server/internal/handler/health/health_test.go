@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type readyBody struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func getReady(t *testing.T, h *Handler) (int, readyBody) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var body readyBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode /ready: %v (%s)", err, rec.Body.String())
+	}
+	return rec.Code, body
+}
+
+func TestReady_ReportsEachCheck(t *testing.T) {
+	dbErr := error(nil)
+	h := NewHandler(nil).WithChecks(ChecksConfig{
+		CacheTTL: time.Nanosecond,
+		Checks: []Check{
+			{Name: "database", Run: func(context.Context) (map[string]any, error) { return nil, dbErr }},
+			{Name: "upstream", Optional: true, Run: func(context.Context) (map[string]any, error) { return nil, errors.New("dial timeout") }},
+			{Name: "queue", Run: func(context.Context) (map[string]any, error) { return map[string]any{"saturated": false}, nil }},
+		},
+	})
+
+	code, body := getReady(t, h)
+	if code != http.StatusOK || body.Status != "ok" {
+		t.Fatalf("expected optional failure to keep instance ready, got %d %+v", code, body)
+	}
+	if up := body.Checks["upstream"]; up.Status != "error" || !up.Optional || up.Error != "dial timeout" {
+		t.Fatalf("unexpected upstream check: %+v", up)
+	}
+	if q := body.Checks["queue"]; q.Status != "ok" || q.Details["saturated"] != false {
+		t.Fatalf("unexpected queue check: %+v", q)
+	}
+
+	dbErr = errors.New("database is locked")
+	code, body = getReady(t, h)
+	if code != http.StatusServiceUnavailable || body.Status != "error" || body.Checks["database"].Error != "database is locked" {
+		t.Fatalf("expected failing database check to fail readiness, got %d %+v", code, body)
+	}
+}
+
+func TestReady_CachesResultsAcrossConcurrentProbes(t *testing.T) {
+	var runs atomic.Int32
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	h := NewHandler(nil).WithChecks(ChecksConfig{
+		CacheTTL: time.Second,
+		Now:      func() time.Time { return now },
+		Checks: []Check{{Name: "database", Run: func(context.Context) (map[string]any, error) {
+			runs.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return nil, nil
+		}}},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getReady(t, h)
+		}()
+	}
+	wg.Wait()
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("expected one probe round for a burst of requests, got %d", got)
+	}
+
+	now = now.Add(time.Second)
+	getReady(t, h)
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("expected checks to rerun after the cache ttl, got %d", got)
+	}
+}
+
+func TestReady_CheckTimeout(t *testing.T) {
+	h := NewHandler(nil).WithChecks(ChecksConfig{
+		Timeout: 20 * time.Millisecond,
+		Checks: []Check{{Name: "database", Run: func(ctx context.Context) (map[string]any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}}},
+	})
+	if code, body := getReady(t, h); code != http.StatusServiceUnavailable || body.Checks["database"].Status != "error" {
+		t.Fatalf("expected hung check to time out and fail readiness, got %d %+v", code, body)
+	}
+}
+
+func TestReady_DrainingOverridesChecks(t *testing.T) {
+	h := NewHandler(nil).
+		WithChecks(ChecksConfig{Checks: []Check{{Name: "database", Run: func(context.Context) (map[string]any, error) { return nil, nil }}}}).
+		WithDraining(func() bool { return true })
+	if code, body := getReady(t, h); code != http.StatusServiceUnavailable || body.Status != "draining" {
+		t.Fatalf("expected draining instance to be unready, got %d %+v", code, body)
+	}
+}
@@ -1,9 +1,13 @@
 package relay
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -50,13 +54,53 @@ func (h *GetResultHandler) handleCompatibleGetResult(w http.ResponseWriter, r *h
 }
 
 func (h *GetResultHandler) handleGetResult(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet:
+		// GET exists for presigned links: the task is identified by query
+		// parameters and turned into the JSON body upstream expects.
+		body, err := getResultBodyFromQuery(r.URL.Query())
+		if err != nil {
+			writeRelayError(w, err, http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	default:
 		writeRelayError(w, internalerrors.New(internalerrors.ErrValidationFailed, "method not allowed", nil), http.StatusMethodNotAllowed)
 		return
 	}
 	h.proxyGetResult(w, r)
 }
 
+func getResultBodyFromQuery(q url.Values) ([]byte, error) {
+	reqKey := strings.TrimSpace(q.Get("req_key"))
+	taskID := strings.TrimSpace(q.Get("task_id"))
+	if reqKey == "" || taskID == "" {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "req_key and task_id query parameters are required", nil)
+	}
+	payload := map[string]string{"req_key": reqKey, "task_id": taskID}
+	if reqJSON := strings.TrimSpace(q.Get("req_json")); reqJSON != "" {
+		payload["req_json"] = reqJSON
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "encode get-result body", err)
+	}
+	return body, nil
+}
+
+// auditQuery drops the presigned URL signature so audit records cannot be used
+// to rebuild a still-valid link.
+func auditQuery(u *url.URL) string {
+	q := u.Query()
+	if q.Get("X-Signature") == "" {
+		return u.RawQuery
+	}
+	q.Set("X-Signature", "***")
+	return q.Encode()
+}
+
 func (h *GetResultHandler) proxyGetResult(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	var upstreamStatus int
@@ -108,7 +152,7 @@ func (h *GetResultHandler) proxyGetResult(w http.ResponseWriter, r *http.Request
 		Action:            models.DownstreamActionCVSync2AsyncGetResult,
 		Method:            r.Method,
 		Path:              r.URL.Path,
-		Query:             r.URL.RawQuery,
+		Query:             auditQuery(r.URL),
 		ClientIP:          strings.TrimSpace(r.RemoteAddr),
 		DownstreamHeaders: headerToMapAny(r.Header),
 		DownstreamBody:    decodeJSONMap(body),
@@ -116,7 +160,7 @@ func (h *GetResultHandler) proxyGetResult(w http.ResponseWriter, r *http.Request
 			AttemptNumber:  1,
 			UpstreamAction: getResultAction,
 			RequestHeaders: headerToMapAny(headers),
-			RequestBody:    nil,
+			RawRequestBody: body,
 		},
 	}
 	if err := h.audit.RecordRelayDownstream(ctx, call); err != nil {
@@ -138,7 +182,7 @@ func (h *GetResultHandler) proxyGetResult(w http.ResponseWriter, r *http.Request
 		}
 		call.Upstream.ResponseStatus = resp.StatusCode
 		call.Upstream.ResponseHeaders = headerToMapAny(resp.Header)
-		call.Upstream.ResponseBody = nil
+		call.Upstream.RawResponseBody = resp.Body
 		call.Upstream.LatencyMs = latencyMs
 		call.Upstream.Error = upstreamErr
 		if err := h.audit.RecordRelayUpstreamAndEvents(ctx, call); err != nil {
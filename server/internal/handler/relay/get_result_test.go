@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/jimeng-relay/server/internal/config"
@@ -218,6 +219,41 @@ func TestGetResultHandler_CompatibleActionPath(t *testing.T) {
 	}
 }
 
+func TestGetResultHandler_PresignedGET(t *testing.T) {
+	upstreamBody := []byte(`{"code":10000,"message":"ok","data":{"status":"done"}}`)
+	fake := &fakeGetResultClient{resp: &upstream.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"application/json"}}, Body: upstreamBody}}
+	auditSvc, dsRepo, _, _ := newTestAuditService(t, nil, nil, nil)
+	h := NewGetResultHandler(fake, auditSvc, nil).Routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/get-result?req_key=jimeng_t2i_v40&task_id=task_123&X-Signature=deadbeef", nil)
+	req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, "k1"))
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	var forwarded map[string]string
+	if err := json.Unmarshal(fake.reqBody, &forwarded); err != nil {
+		t.Fatalf("decode forwarded body: %v", err)
+	}
+	if forwarded["req_key"] != "jimeng_t2i_v40" || forwarded["task_id"] != "task_123" {
+		t.Fatalf("unexpected forwarded body: %s", fake.reqBody)
+	}
+	if len(dsRepo.created) != 1 || strings.Contains(dsRepo.created[0].QueryString, "deadbeef") {
+		t.Fatalf("expected presign signature redacted from audit query, got %+v", dsRepo.created)
+	}
+
+	missing := httptest.NewRequest(http.MethodGet, "/v1/get-result?req_key=jimeng_t2i_v40", nil)
+	missing = missing.WithContext(context.WithValue(missing.Context(), sigv4.ContextAPIKeyID, "k1"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, missing)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without task_id, got %d", rec.Code)
+	}
+}
+
 func TestGetResultHandler_AuditFailure_FailClosed(t *testing.T) {
 	upstreamBody := []byte(`{"code":10000,"message":"ok","data":{"status":"done"}}`)
 	fake := &fakeGetResultClient{resp: &upstream.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"application/json"}}, Body: upstreamBody}}
@@ -17,22 +17,24 @@ import (
 	"testing"
 
 	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/relay/schema"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
 )
 
-var relayAcceptedVideoReqKeys = map[string]struct{}{
-	"jimeng_t2v_v30":            {},
-	"jimeng_t2v_v30_1080p":           {},
-	"jimeng_ti2v_v30_pro":            {},
-	"jimeng_i2v_first_v30_1080":      {},
-	"jimeng_i2v_first_tail_v30_1080": {},
-	"jimeng_i2v_recamera_v30":        {},
+// relayAcceptedVideoReqKeys is the set of req_keys the relay has a submit
+// schema for; a client preset without one would only be checked upstream.
+func relayAcceptedVideoReqKeys() map[string]struct{} {
+	out := map[string]struct{}{}
+	for _, reqKey := range schema.NewValidator(schema.Config{}).ReqKeys() {
+		out[reqKey] = struct{}{}
+	}
+	return out
 }
 
 func TestClientPresetMatrixParity_RelayContractCoverage(t *testing.T) {
 	clientPresetReqKeys := mustLoadClientPresetReqKeys(t)
 
-	if err := detectReqKeyParityMismatch(clientPresetReqKeys, relayAcceptedVideoReqKeys); err != nil {
+	if err := detectReqKeyParityMismatch(clientPresetReqKeys, relayAcceptedVideoReqKeys()); err != nil {
 		t.Fatalf("client/server req_key parity mismatch: %v", err)
 	}
 }
@@ -79,7 +81,7 @@ func TestClientPresetMatrixParity_DetectsMismatch(t *testing.T) {
 	clientPresetReqKeys := mustLoadClientPresetReqKeys(t)
 	clientPresetReqKeys["simulated-new-client-preset"] = "jimeng_simulated_not_supported"
 
-	err := detectReqKeyParityMismatch(clientPresetReqKeys, relayAcceptedVideoReqKeys)
+	err := detectReqKeyParityMismatch(clientPresetReqKeys, relayAcceptedVideoReqKeys())
 	if err == nil {
 		t.Fatalf("expected mismatch detection error when client adds unsupported preset")
 	}
@@ -15,6 +15,7 @@ import (
 	"github.com/jimeng-relay/server/internal/repository"
 	auditservice "github.com/jimeng-relay/server/internal/service/audit"
 	idempotencyservice "github.com/jimeng-relay/server/internal/service/idempotency"
+	"github.com/jimeng-relay/server/internal/service/policy"
 )
 
 const submitAction = "CVSync2AsyncSubmitTask"
@@ -23,11 +24,23 @@ type submitClient interface {
 	Submit(ctx context.Context, body []byte, headers http.Header) (*upstream.Response, error)
 }
 
+// requestValidator checks a submit body before it is relayed upstream.
+type requestValidator interface {
+	Validate(body []byte) error
+}
+
+// policyEvaluator decides whether an API key may relay a submit body.
+type policyEvaluator interface {
+	Evaluate(apiKeyID string, body []byte) policy.Decision
+}
+
 type SubmitHandler struct {
 	client      submitClient
 	audit       *auditservice.Service
 	idempotency *idempotencyservice.Service
 	idemRepo    repository.IdempotencyRecordRepository
+	validator   requestValidator
+	policy      policyEvaluator
 	logger      *slog.Logger
 }
 
@@ -38,6 +51,20 @@ func NewSubmitHandler(client submitClient, auditSvc *auditservice.Service, idemp
 	return &SubmitHandler{client: client, audit: auditSvc, idempotency: idempotencySvc, idemRepo: idemRepo, logger: logger}
 }
 
+// WithValidator rejects submit bodies that fail v with 400 before any
+// idempotency bookkeeping, audit write or upstream call.
+func (h *SubmitHandler) WithValidator(v requestValidator) *SubmitHandler {
+	h.validator = v
+	return h
+}
+
+// WithPolicy evaluates every submit against p after it is audited and before
+// it is relayed; each decision is recorded as an audit event.
+func (h *SubmitHandler) WithPolicy(p policyEvaluator) *SubmitHandler {
+	h.policy = p
+	return h
+}
+
 func (h *SubmitHandler) Routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/submit", h.handleSubmit)
@@ -104,6 +131,13 @@ func (h *SubmitHandler) proxySubmit(w http.ResponseWriter, r *http.Request, appl
 		writeRelayError(w, finalErr, http.StatusRequestEntityTooLarge)
 		return
 	}
+	if h.validator != nil {
+		if err := h.validator.Validate(body); err != nil {
+			finalErr = err
+			writeRelayError(w, finalErr, http.StatusBadRequest)
+			return
+		}
+	}
 
 	idempotencyKey := ""
 	requestHash := ""
@@ -154,7 +188,7 @@ func (h *SubmitHandler) proxySubmit(w http.ResponseWriter, r *http.Request, appl
 			AttemptNumber:  1,
 			UpstreamAction: submitAction,
 			RequestHeaders: headerToMapAny(headers),
-			RequestBody:    nil,
+			RawRequestBody: body,
 		},
 	}
 	if err := h.audit.RecordRelayDownstream(ctx, call); err != nil {
@@ -163,9 +197,27 @@ func (h *SubmitHandler) proxySubmit(w http.ResponseWriter, r *http.Request, appl
 		return
 	}
 
+	if h.policy != nil {
+		decision := h.policy.Evaluate(apiKeyID, body)
+		if err := h.audit.RecordEvents(ctx, reqID, policyAuditEvent(apiKeyID, decision)); err != nil {
+			finalErr = err
+			writeRelayError(w, finalErr, http.StatusInternalServerError)
+			return
+		}
+		if !decision.Allowed {
+			h.logger.WarnContext(ctx, "submit denied by policy", "api_key_id", apiKeyID, "rule_id", decision.RuleID, "rule_set", decision.RuleSet, "field", decision.Field, "policy_version", decision.Version)
+			finalErr = internalerrors.New(internalerrors.ErrPolicyDenied, decision.Message, nil)
+			writeRelayError(w, finalErr, http.StatusForbidden)
+			return
+		}
+	}
+
 	ctx = upstream.WithAPIKeyID(ctx, apiKeyID)
 	resp, callErr := h.client.Submit(ctx, body, headers)
 	if resp != nil {
+		// The upstream task may exist now. Record it even if the client went
+		// away or the server is shutting down.
+		ctx = context.WithoutCancel(ctx)
 		upstreamStatus = resp.StatusCode
 		latencyMs := time.Since(start).Milliseconds()
 		var upstreamErr *string
@@ -176,7 +228,7 @@ func (h *SubmitHandler) proxySubmit(w http.ResponseWriter, r *http.Request, appl
 		}
 		call.Upstream.ResponseStatus = resp.StatusCode
 		call.Upstream.ResponseHeaders = headerToMapAny(resp.Header)
-		call.Upstream.ResponseBody = nil
+		call.Upstream.RawResponseBody = resp.Body
 		call.Upstream.LatencyMs = latencyMs
 		call.Upstream.Error = upstreamErr
 		if err := h.audit.RecordRelayUpstreamAndEvents(ctx, call); err != nil {
@@ -219,6 +271,23 @@ func (h *SubmitHandler) proxySubmit(w http.ResponseWriter, r *http.Request, appl
 	writeRelayError(w, finalErr, http.StatusBadGateway)
 }
 
+func policyAuditEvent(apiKeyID string, d policy.Decision) auditservice.Event {
+	ev := auditservice.Event{
+		Type:     models.EventTypePolicyAllowed,
+		Actor:    apiKeyID,
+		Action:   "policy_check",
+		Resource: "relay.submit",
+		Metadata: map[string]any{"policy_version": d.Version},
+	}
+	if !d.Allowed {
+		ev.Type = models.EventTypePolicyDenied
+		ev.Metadata["rule_id"] = d.RuleID
+		ev.Metadata["rule_set"] = d.RuleSet
+		ev.Metadata["field"] = d.Field
+	}
+	return ev
+}
+
 func writeReplayResponse(w http.ResponseWriter, statusCode int, responseBody any) {
 	if contentType, ok := replayContentType(responseBody); ok {
 		w.Header().Set("Content-Type", contentType)
@@ -5,10 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,10 +18,12 @@ import (
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
 	"github.com/jimeng-relay/server/internal/middleware/sigv4"
 	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/relay/schema"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
 	"github.com/jimeng-relay/server/internal/repository"
 	auditservice "github.com/jimeng-relay/server/internal/service/audit"
 	idempotencyservice "github.com/jimeng-relay/server/internal/service/idempotency"
+	"github.com/jimeng-relay/server/internal/service/policy"
 )
 
 type recordingDownstreamRepo struct {
@@ -43,6 +47,10 @@ func (r *recordingDownstreamRepo) GetByRequestID(_ context.Context, _ string) (m
 	return models.DownstreamRequest{}, errors.New("not implemented")
 }
 
+func (r *recordingDownstreamRepo) List(_ context.Context, _ repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	return nil, errors.New("not implemented")
+}
+
 type recordingUpstreamRepo struct {
 	err     error
 	created []models.UpstreamAttempt
@@ -65,11 +73,11 @@ type recordingAuditRepo struct {
 	created []models.AuditEvent
 }
 
-func (r *recordingAuditRepo) Create(_ context.Context, event models.AuditEvent) error {
+func (r *recordingAuditRepo) Create(_ context.Context, event *models.AuditEvent) error {
 	if r.err != nil {
 		return r.err
 	}
-	r.created = append(r.created, event)
+	r.created = append(r.created, *event)
 	return nil
 }
 
@@ -81,6 +89,10 @@ func (r *recordingAuditRepo) ListByTimeRange(_ context.Context, _, _ time.Time)
 	return nil, errors.New("not implemented")
 }
 
+func (r *recordingAuditRepo) Query(_ context.Context, _ repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	return nil, errors.New("not implemented")
+}
+
 type recordingIdempotencyRepo struct {
 	errOnGet       error
 	errOnCreate    error
@@ -132,7 +144,7 @@ func newTestAuditService(t *testing.T, dsErr, usErr, aeErr error) (*auditservice
 	ds := &recordingDownstreamRepo{err: dsErr}
 	us := &recordingUpstreamRepo{err: usErr}
 	ae := &recordingAuditRepo{err: aeErr}
-	rnd := bytes.NewReader(bytes.Repeat([]byte{0x01}, 24))
+	rnd := bytes.NewReader(bytes.Repeat([]byte{0x01}, 64))
 	svc := auditservice.NewService(ds, us, ae, auditservice.Config{Now: func() time.Time { return base }, Random: rnd})
 	return svc, ds, us, ae
 }
@@ -813,3 +825,187 @@ func TestSubmitHandler_UpstreamErrorPassthrough(t *testing.T) {
 		t.Fatalf("expected x-request-id passthrough, got %q", got)
 	}
 }
+
+func TestSubmitHandler_SchemaViolationRejectedBeforeUpstream(t *testing.T) {
+	fake := &fakeSubmitClient{resp: &upstream.Response{StatusCode: http.StatusOK, Body: []byte(`{}`)}}
+	auditSvc, dsRepo, _, _ := newTestAuditService(t, nil, nil, nil)
+	idemRepo := newRecordingIdempotencyRepo()
+	idemSvc := idempotencyservice.NewService(idemRepo, idempotencyservice.Config{})
+	h := NewSubmitHandler(fake, auditSvc, idemSvc, idemRepo, nil).WithValidator(schema.NewValidator(schema.Config{})).Routes()
+
+	body := []byte(`{"req_key":"jimeng_t2v_v30","prompt":"cat","frames":100,"aspect_ratio":"2:1"}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", bytes.NewReader(body))
+	req.Header.Set("Idempotency-Key", "idem-schema")
+	req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, "k1"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	var payload struct {
+		Error struct {
+			Code    string             `json:"code"`
+			Details []schema.Violation `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if payload.Error.Code != string(internalerrors.ErrValidationFailed) {
+		t.Fatalf("expected validation error code, got %q", payload.Error.Code)
+	}
+	fields := map[string]bool{}
+	for _, v := range payload.Error.Details {
+		fields[v.Field] = true
+	}
+	if !fields["frames"] || !fields["aspect_ratio"] || len(fields) != 2 {
+		t.Fatalf("expected frames and aspect_ratio violations, got %+v", payload.Error.Details)
+	}
+	if fake.calls != 0 || len(dsRepo.created) != 0 || idemRepo.getByKeyCalls != 0 {
+		t.Fatalf("expected no upstream/audit/idempotency work, got calls=%d audit=%d idem=%d", fake.calls, len(dsRepo.created), idemRepo.getByKeyCalls)
+	}
+}
+
+type stubPolicy struct {
+	decision policy.Decision
+}
+
+func (s stubPolicy) Evaluate(string, []byte) policy.Decision { return s.decision }
+
+func TestSubmitHandler_PolicyDecisionsAreAudited(t *testing.T) {
+	upstreamBody := []byte(`{"code":10000,"message":"ok","data":{"task_id":"task_123"}}`)
+
+	t.Run("denied", func(t *testing.T) {
+		fake := &fakeSubmitClient{resp: &upstream.Response{StatusCode: http.StatusOK, Body: upstreamBody}}
+		auditSvc, dsRepo, usRepo, aeRepo := newTestAuditService(t, nil, nil, nil)
+		deny := stubPolicy{decision: policy.Decision{RuleID: "banned-terms", RuleSet: "global", Field: "prompt", Message: "nope", Version: "v1"}}
+		h := NewSubmitHandler(fake, auditSvc, nil, nil, nil).WithPolicy(deny).Routes()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/submit", bytes.NewReader([]byte(`{"prompt":"gore"}`)))
+		req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, "k1"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected status 403, got %d body=%s", rec.Code, rec.Body.String())
+		}
+		var payload map[string]map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		if payload["error"]["code"] != string(internalerrors.ErrPolicyDenied) || !strings.Contains(fmt.Sprint(payload["error"]["message"]), "nope") {
+			t.Fatalf("unexpected error payload: %s", rec.Body.String())
+		}
+		if fake.calls != 0 || len(usRepo.created) != 0 {
+			t.Fatalf("expected denied request to skip upstream, got calls=%d attempts=%d", fake.calls, len(usRepo.created))
+		}
+		if len(dsRepo.created) != 1 || len(aeRepo.created) != 1 {
+			t.Fatalf("expected downstream record and one audit event, got %d/%d", len(dsRepo.created), len(aeRepo.created))
+		}
+		ev := aeRepo.created[0]
+		if ev.EventType != models.EventTypePolicyDenied || ev.Metadata["rule_id"] != "banned-terms" || ev.Actor != "k1" {
+			t.Fatalf("unexpected audit event: %+v", ev)
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		fake := &fakeSubmitClient{resp: &upstream.Response{StatusCode: http.StatusOK, Body: upstreamBody}}
+		auditSvc, _, _, aeRepo := newTestAuditService(t, nil, nil, nil)
+		allow := stubPolicy{decision: policy.Decision{Allowed: true, Version: "v1"}}
+		h := NewSubmitHandler(fake, auditSvc, nil, nil, nil).WithPolicy(allow).Routes()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/submit", bytes.NewReader([]byte(`{"prompt":"cat"}`)))
+		req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, "k1"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK || fake.calls != 1 {
+			t.Fatalf("expected request to be relayed, got %d calls=%d", rec.Code, fake.calls)
+		}
+		if len(aeRepo.created) == 0 || aeRepo.created[0].EventType != models.EventTypePolicyAllowed {
+			t.Fatalf("expected policy_allowed event first, got %+v", aeRepo.created)
+		}
+	})
+}
+
+type cancelOnSubmitClient struct {
+	fakeSubmitClient
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnSubmitClient) Submit(ctx context.Context, body []byte, headers http.Header) (*upstream.Response, error) {
+	c.cancel()
+	return c.fakeSubmitClient.Submit(ctx, body, headers)
+}
+
+type ctxCheckingUpstreamRepo struct {
+	recordingUpstreamRepo
+}
+
+func (r *ctxCheckingUpstreamRepo) Create(ctx context.Context, attempt models.UpstreamAttempt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.recordingUpstreamRepo.Create(ctx, attempt)
+}
+
+func TestSubmitHandler_RecordsUpstreamResultAfterClientCancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), sigv4.ContextAPIKeyID, "k1"))
+	defer cancel()
+	fake := &cancelOnSubmitClient{
+		fakeSubmitClient: fakeSubmitClient{resp: &upstream.Response{StatusCode: http.StatusOK, Body: []byte(`{"code":10000}`)}},
+		cancel:           cancel,
+	}
+	us := &ctxCheckingUpstreamRepo{}
+	auditSvc := auditservice.NewService(&recordingDownstreamRepo{}, us, &recordingAuditRepo{}, auditservice.Config{})
+	h := NewSubmitHandler(fake, auditSvc, nil, nil, nil).Routes()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", bytes.NewReader([]byte(`{"prompt":"cat"}`))).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || len(us.created) != 1 {
+		t.Fatalf("expected upstream attempt to be recorded after cancellation, got %d attempts=%d body=%s", rec.Code, len(us.created), rec.Body.String())
+	}
+}
+
+func TestSubmitHandler_RecordsUpstreamBodyMetadata(t *testing.T) {
+	upstreamBody := []byte(`{"ResponseMetadata":{"RequestId":"up-req-1","Error":{"Code":"50400","Message":"Access Denied: Internal Error"}}}`)
+	fake := &fakeSubmitClient{
+		resp: &upstream.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       upstreamBody,
+		},
+		err: internalerrors.New(internalerrors.ErrUpstreamFailed, "upstream submit returned 400", nil),
+	}
+	ds, us, ae := &recordingDownstreamRepo{}, &recordingUpstreamRepo{}, &recordingAuditRepo{}
+	auditSvc := auditservice.NewService(ds, us, ae, auditservice.Config{
+		Random:    bytes.NewReader(bytes.Repeat([]byte{0x01}, 64)),
+		BodyModes: map[models.DownstreamAction]auditservice.BodyMode{models.DownstreamActionCVSync2AsyncSubmitTask: auditservice.BodyModeMetadata},
+	})
+	h := NewSubmitHandler(fake, auditSvc, nil, nil, nil).Routes()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", bytes.NewReader([]byte(`{"prompt":"cat","req_key":"jimeng_t2i_v40"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, "k1"))
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected upstream status passthrough, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if len(us.created) != 1 {
+		t.Fatalf("expected one upstream attempt, got %d", len(us.created))
+	}
+	ua := us.created[0]
+	if ua.RequestBody["req_key"] != "jimeng_t2i_v40" {
+		t.Fatalf("expected req_key in recorded request body, got %v", ua.RequestBody)
+	}
+	got, _ := ua.ResponseBody.(map[string]any)
+	if got["error_code"] != "50400" || got["request_id"] != "up-req-1" {
+		t.Fatalf("expected entitlement error in recorded response body, got %v", ua.ResponseBody)
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
 	"github.com/jimeng-relay/server/internal/logging"
+	"github.com/jimeng-relay/server/internal/relay/schema"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
 )
 
@@ -138,12 +139,14 @@ func writeRelayError(w http.ResponseWriter, err error, status int) {
 		status = ErrorToStatus(err)
 	}
 	w.WriteHeader(status)
-	if encErr := json.NewEncoder(w).Encode(map[string]any{
-		"error": map[string]any{
-			"code":    code,
-			"message": err.Error(),
-		},
-	}); encErr != nil {
+	payload := map[string]any{
+		"code":    code,
+		"message": err.Error(),
+	}
+	if violations, ok := schema.Details(err); ok {
+		payload["details"] = violations
+	}
+	if encErr := json.NewEncoder(w).Encode(map[string]any{"error": payload}); encErr != nil {
 		return
 	}
 }
@@ -175,6 +178,10 @@ func ErrorToStatus(err error) int {
 		return http.StatusUnauthorized
 	case internalerrors.ErrRateLimited:
 		return http.StatusTooManyRequests
+	case internalerrors.ErrPolicyDenied:
+		return http.StatusForbidden
+	case internalerrors.ErrUnavailable:
+		return http.StatusServiceUnavailable
 	case internalerrors.ErrValidationFailed:
 		return http.StatusBadRequest
 	case internalerrors.ErrUpstreamFailed:
@@ -42,6 +42,16 @@ func TestErrorToStatus(t *testing.T) {
 			err:    internalerrors.New(internalerrors.ErrRateLimited, "rate limited", nil),
 			expect: http.StatusTooManyRequests,
 		},
+		{
+			name:   "PolicyDenied",
+			err:    internalerrors.New(internalerrors.ErrPolicyDenied, "policy denied", nil),
+			expect: http.StatusForbidden,
+		},
+		{
+			name:   "Unavailable",
+			err:    internalerrors.New(internalerrors.ErrUnavailable, "shutting down", nil),
+			expect: http.StatusServiceUnavailable,
+		},
 		{
 			name:   "ValidationFailed",
 			err:    internalerrors.New(internalerrors.ErrValidationFailed, "validation failed", nil),
@@ -20,6 +20,7 @@ import (
 	"github.com/jimeng-relay/server/internal/middleware/sigv4"
 	"github.com/jimeng-relay/server/internal/models"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
+	"github.com/jimeng-relay/server/internal/repository"
 	auditservice "github.com/jimeng-relay/server/internal/service/audit"
 	"github.com/jimeng-relay/server/internal/service/keymanager"
 	"github.com/jimeng-relay/server/internal/testharness"
@@ -741,6 +742,10 @@ func (r *concurrentDownstreamRepo) GetByRequestID(_ context.Context, _ string) (
 	return models.DownstreamRequest{}, errors.New("not implemented")
 }
 
+func (r *concurrentDownstreamRepo) List(_ context.Context, _ repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	return nil, errors.New("not implemented")
+}
+
 type concurrentUpstreamRepo struct {
 }
 
@@ -755,7 +760,7 @@ func (r *concurrentUpstreamRepo) ListByRequestID(_ context.Context, _ string) ([
 type concurrentAuditRepo struct {
 }
 
-func (r *concurrentAuditRepo) Create(_ context.Context, _ models.AuditEvent) error {
+func (r *concurrentAuditRepo) Create(_ context.Context, _ *models.AuditEvent) error {
 	return nil
 }
 
@@ -767,6 +772,10 @@ func (r *concurrentAuditRepo) ListByTimeRange(_ context.Context, _, _ time.Time)
 	return nil, errors.New("not implemented")
 }
 
+func (r *concurrentAuditRepo) Query(_ context.Context, _ repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	return nil, errors.New("not implemented")
+}
+
 func newConcurrentTestAuditService(t *testing.T) *auditservice.Service {
 	t.Helper()
 	base := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
@@ -0,0 +1,92 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/handler/apierror"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	usageservice "github.com/jimeng-relay/server/internal/service/usage"
+)
+
+// MaxRange bounds a single /v1/usage query.
+const MaxRange = 366 * 24 * time.Hour
+
+type reporter interface {
+	Report(ctx context.Context, from, to time.Time, apiKeyID string) (usageservice.Report, error)
+}
+
+// Handler serves the calling API key's own usage. It must sit behind the
+// SigV4 middleware, which supplies the key; there is no way to ask for
+// another key's usage.
+type Handler struct {
+	reporter reporter
+	now      func() time.Time
+	logger   *slog.Logger
+}
+
+func NewHandler(reporter reporter, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Handler{reporter: reporter, now: func() time.Time { return time.Now().UTC() }, logger: logger}
+}
+
+func (h *Handler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/usage", h.handleUsage)
+	return mux
+}
+
+// handleUsage answers GET /v1/usage?from=&to=&format=json|csv. from and to
+// accept YYYY-MM-DD or RFC3339 and default to the current month.
+func (h *Handler) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, internalerrors.New(internalerrors.ErrValidationFailed, "method not allowed", nil))
+		return
+	}
+	apiKeyID, _ := r.Context().Value(sigv4.ContextAPIKeyID).(string)
+	if strings.TrimSpace(apiKeyID) == "" {
+		apierror.Write(w, http.StatusUnauthorized, internalerrors.New(internalerrors.ErrAuthFailed, "missing api key", nil))
+		return
+	}
+
+	q := r.URL.Query()
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format != "" && format != "json" && format != "csv" {
+		apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "format must be json or csv", nil))
+		return
+	}
+	from, to, err := usageservice.ParseRange(q.Get("from"), q.Get("to"), h.now())
+	if err != nil {
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+	if to.Sub(from) > MaxRange {
+		apierror.Write(w, http.StatusBadRequest, internalerrors.New(internalerrors.ErrValidationFailed, "range must not exceed 366 days", nil))
+		return
+	}
+
+	report, err := h.reporter.Report(r.Context(), from, to, apiKeyID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "usage report failed", "api_key_id", apiKeyID, "error", err.Error())
+		apierror.Write(w, apierror.Status(err), err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_ = usageservice.WriteCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,100 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	usageservice "github.com/jimeng-relay/server/internal/service/usage"
+)
+
+type fakeReporter struct {
+	from, to time.Time
+	apiKeyID string
+}
+
+func (f *fakeReporter) Report(_ context.Context, from, to time.Time, apiKeyID string) (usageservice.Report, error) {
+	f.from, f.to, f.apiKeyID = from, to, apiKeyID
+	return usageservice.Report{From: from, To: to, APIKeyID: apiKeyID, Currency: "CNY", Rows: []usageservice.Row{
+		{Day: "2026-03-01", APIKeyID: apiKeyID, ReqKey: "jimeng_t2i_v40", Requests: 2, Images: 2, Cost: 0.4},
+	}}, nil
+}
+
+func newHandler(rep *fakeReporter) http.Handler {
+	h := NewHandler(rep, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.now = func() time.Time { return time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC) }
+	return h.Routes()
+}
+
+func newRequest(method, target, apiKeyID string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if apiKeyID != "" {
+		req = req.WithContext(context.WithValue(req.Context(), sigv4.ContextAPIKeyID, apiKeyID))
+	}
+	return req
+}
+
+func TestHandler_ReportsCallerKeyOnly(t *testing.T) {
+	rep := &fakeReporter{}
+	rec := httptest.NewRecorder()
+	newHandler(rep).ServeHTTP(rec, newRequest(http.MethodGet, "/v1/usage?from=2026-03-01&to=2026-03-01&api_key_id=other", "key_1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rep.apiKeyID != "key_1" {
+		t.Fatalf("expected usage for the authenticated key, got %q", rep.apiKeyID)
+	}
+	if !rep.from.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !rep.to.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected range %s..%s", rep.from, rep.to)
+	}
+	var body usageservice.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Rows) != 1 || body.Rows[0].Cost != 0.4 || body.Currency != "CNY" {
+		t.Fatalf("unexpected report: %+v", body)
+	}
+}
+
+func TestHandler_CSV(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newHandler(&fakeReporter{}).ServeHTTP(rec, newRequest(http.MethodGet, "/v1/usage?format=csv", "key_1"))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected csv, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "2026-03-01,key_1,jimeng_t2i_v40,2,2,0,0,0.4,CNY,0") {
+		t.Fatalf("unexpected csv body:\n%s", rec.Body.String())
+	}
+}
+
+func TestHandler_Rejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		key    string
+		status int
+	}{
+		{"NoKey", http.MethodGet, "/v1/usage", "", http.StatusUnauthorized},
+		{"Method", http.MethodPost, "/v1/usage", "key_1", http.StatusMethodNotAllowed},
+		{"BadFormat", http.MethodGet, "/v1/usage?format=xml", "key_1", http.StatusBadRequest},
+		{"BadDate", http.MethodGet, "/v1/usage?from=last-week", "key_1", http.StatusBadRequest},
+		{"TooLong", http.MethodGet, "/v1/usage?from=2024-01-01&to=2026-03-01", "key_1", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newHandler(&fakeReporter{}).ServeHTTP(rec, newRequest(tc.method, tc.target, tc.key))
+			if rec.Code != tc.status {
+				t.Fatalf("expected %d, got %d body=%s", tc.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
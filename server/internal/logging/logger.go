@@ -20,7 +20,9 @@ type RedactingHandler struct {
 	slog.Handler
 }
 
-func NewLogger(level slog.Level) *slog.Logger {
+// NewLogger returns a redacting JSON logger. Pass a *slog.LevelVar to change
+// the level at runtime.
+func NewLogger(level slog.Leveler) *slog.Logger {
 	opts := &slog.HandlerOptions{
 		Level: level,
 	}
@@ -0,0 +1,108 @@
+package bearer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/service/authtoken"
+)
+
+// Verifier validates a bearer token. *authtoken.Service satisfies it.
+type Verifier interface {
+	Verify(ctx context.Context, token string) (authtoken.Claims, error)
+}
+
+type Config struct {
+	// Fallback authenticates requests that do not carry a bearer token,
+	// normally the SigV4 middleware. Without it such requests are rejected.
+	Fallback func(http.Handler) http.Handler
+	// ScopeFor maps a request to the token scope it needs. Requests mapping to
+	// "" cannot be made with a bearer token at all (e.g. the token endpoint).
+	ScopeFor func(r *http.Request) string
+}
+
+type Middleware struct {
+	verifier Verifier
+	fallback func(http.Handler) http.Handler
+	scopeFor func(r *http.Request) string
+}
+
+// New returns middleware accepting "Authorization: Bearer <token>" on the
+// routes selected by cfg.ScopeFor. Authenticated requests carry the parent
+// key's ID under sigv4.ContextAPIKeyID, exactly like SigV4 requests.
+func New(verifier Verifier, cfg Config) func(http.Handler) http.Handler {
+	scopeFor := cfg.ScopeFor
+	if scopeFor == nil {
+		scopeFor = func(*http.Request) string { return "" }
+	}
+	m := &Middleware{verifier: verifier, fallback: cfg.Fallback, scopeFor: scopeFor}
+	return m.wrap
+}
+
+func (m *Middleware) wrap(next http.Handler) http.Handler {
+	var fallback http.Handler
+	if m.fallback != nil {
+		fallback = m.fallback(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			if fallback == nil {
+				writeError(w, http.StatusUnauthorized, internalerrors.New(internalerrors.ErrAuthFailed, "missing bearer token", nil))
+				return
+			}
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		scope := m.scopeFor(r)
+		if scope == "" {
+			writeError(w, http.StatusUnauthorized, internalerrors.New(internalerrors.ErrAuthFailed, "bearer tokens are not accepted on this route", nil))
+			return
+		}
+		claims, err := m.verifier.Verify(r.Context(), token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if err := sigv4.CheckClientCert(r, claims.ClientCertSubject); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if !claims.HasScope(scope) {
+			writeError(w, http.StatusForbidden, internalerrors.New(internalerrors.ErrAuthFailed, "bearer token lacks scope "+scope, nil))
+			return
+		}
+		ctx := context.WithValue(r.Context(), sigv4.ContextAPIKeyID, claims.APIKeyID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	v := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(v) < len("Bearer ") || !strings.EqualFold(v[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(v[len("Bearer "):]), true
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	code := internalerrors.GetCode(err)
+	if code == "" {
+		code = internalerrors.ErrAuthFailed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="jimeng-relay"`)
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": err.Error(),
+		},
+	})
+}
@@ -0,0 +1,150 @@
+package bearer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/middleware/sigv4"
+	"github.com/jimeng-relay/server/internal/service/authtoken"
+)
+
+type stubVerifier struct {
+	claims authtoken.Claims
+	err    error
+	calls  int
+}
+
+func (s *stubVerifier) Verify(context.Context, string) (authtoken.Claims, error) {
+	s.calls++
+	return s.claims, s.err
+}
+
+func scopeByPath(r *http.Request) string {
+	switch r.URL.Path {
+	case "/v1/submit":
+		return authtoken.ScopeSubmit
+	case "/v1/get-result":
+		return authtoken.ScopeGetResult
+	default:
+		return ""
+	}
+}
+
+func serve(mw func(http.Handler) http.Handler, req *http.Request) (*httptest.ResponseRecorder, string) {
+	var apiKeyID string
+	rec := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKeyID, _ = r.Context().Value(sigv4.ContextAPIKeyID).(string)
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rec, req)
+	return rec, apiKeyID
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body: %v (%s)", err, rec.Body.String())
+	}
+	return body.Error.Code
+}
+
+func TestMiddleware_AcceptsScopedToken(t *testing.T) {
+	v := &stubVerifier{claims: authtoken.Claims{APIKeyID: "key_1", Scopes: []string{authtoken.ScopeGetResult}}}
+	mw := New(v, Config{ScopeFor: scopeByPath})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/get-result", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	rec, apiKeyID := serve(mw, req)
+	if rec.Code != http.StatusOK || apiKeyID != "key_1" {
+		t.Fatalf("expected request to pass as key_1, got %d %q", rec.Code, apiKeyID)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/submit", nil)
+	req.Header.Set("Authorization", "bearer tok")
+	rec, _ = serve(mw, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for missing scope, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_RejectsTokenOnUnscopedRoute(t *testing.T) {
+	v := &stubVerifier{claims: authtoken.Claims{APIKeyID: "key_1", Scopes: authtoken.AllScopes}}
+	mw := New(v, Config{ScopeFor: scopeByPath})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/token", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	rec, _ := serve(mw, req)
+	if rec.Code != http.StatusUnauthorized || v.calls != 0 {
+		t.Fatalf("expected token endpoint to refuse bearer auth, got %d (verify calls=%d)", rec.Code, v.calls)
+	}
+}
+
+func TestMiddleware_PropagatesVerifyError(t *testing.T) {
+	v := &stubVerifier{err: internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil)}
+	mw := New(v, Config{ScopeFor: scopeByPath})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	rec, _ := serve(mw, req)
+	if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "KEY_REVOKED" {
+		t.Fatalf("expected 401 KEY_REVOKED, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected WWW-Authenticate challenge")
+	}
+}
+
+func TestMiddleware_EnforcesClientCertBinding(t *testing.T) {
+	v := &stubVerifier{claims: authtoken.Claims{APIKeyID: "key_1", Scopes: authtoken.AllScopes, ClientCertSubject: "CN=worker-a"}}
+	mw := New(v, Config{ScopeFor: scopeByPath})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	rec, _ := serve(mw, req)
+	if rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "AUTH_FAILED" {
+		t.Fatalf("expected token of a cert-bound key to need the certificate, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/submit", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "worker-a"}}}}}
+	rec, apiKeyID := serve(mw, req)
+	if rec.Code != http.StatusOK || apiKeyID != "key_1" {
+		t.Fatalf("expected matching certificate to pass, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMiddleware_FallsBackWithoutBearer(t *testing.T) {
+	fallbackCalls := 0
+	fallback := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fallbackCalls++
+			next.ServeHTTP(w, r)
+		})
+	}
+	v := &stubVerifier{}
+	mw := New(v, Config{Fallback: fallback, ScopeFor: scopeByPath})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/submit", nil)
+	req.Header.Set("Authorization", "HMAC-SHA256 Credential=...")
+	if rec, _ := serve(mw, req); rec.Code != http.StatusOK || fallbackCalls != 1 || v.calls != 0 {
+		t.Fatalf("expected SigV4 request to use fallback, got %d fallback=%d verify=%d", rec.Code, fallbackCalls, v.calls)
+	}
+
+	noFallback := New(v, Config{ScopeFor: scopeByPath})
+	if rec, _ := serve(noFallback, httptest.NewRequest(http.MethodPost, "/v1/submit", nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without fallback, got %d", rec.Code)
+	}
+}
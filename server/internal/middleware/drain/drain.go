@@ -0,0 +1,109 @@
+// Package drain tracks in-flight relay requests so the server can stop taking
+// new work on shutdown and wait for accepted requests to finish.
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+// Drainer counts requests passing through Middleware. After Start, new
+// requests are refused with 503 while accepted ones run to completion.
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+func New() *Drainer {
+	return &Drainer{}
+}
+
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.enter() {
+			writeUnavailable(w)
+			return
+		}
+		defer d.leave()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start stops admitting new requests. It is safe to call more than once.
+func (d *Drainer) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draining = true
+}
+
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight
+}
+
+// Wait blocks until no admitted request is in flight or ctx is done.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	if d.inFlight == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *Drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.inFlight == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+func writeUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    internalerrors.ErrUnavailable,
+			"message": "server is shutting down",
+		},
+	})
+}
@@ -0,0 +1,54 @@
+package drain
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainer_RefusesNewRequestsAndWaitsForInFlight(t *testing.T) {
+	d := New()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	inFlight := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(inFlight, httptest.NewRequest(http.MethodPost, "/v1/submit", nil))
+		close(done)
+	}()
+	<-entered
+
+	d.Start()
+	if !d.Draining() || d.InFlight() != 1 {
+		t.Fatalf("expected draining with one request in flight, got draining=%v inFlight=%d", d.Draining(), d.InFlight())
+	}
+
+	refused := httptest.NewRecorder()
+	h.ServeHTTP(refused, httptest.NewRequest(http.MethodPost, "/v1/submit", nil))
+	if refused.Code != http.StatusServiceUnavailable || refused.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected new request to be refused with 503, got %d", refused.Code)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Wait(short); err == nil {
+		t.Fatalf("expected Wait to time out while a request is in flight")
+	}
+
+	close(release)
+	if err := d.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	<-done
+	if inFlight.Code != http.StatusOK || d.InFlight() != 0 {
+		t.Fatalf("expected in-flight request to complete, got %d (inFlight=%d)", inFlight.Code, d.InFlight())
+	}
+}
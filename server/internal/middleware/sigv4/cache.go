@@ -0,0 +1,239 @@
+package sigv4
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+)
+
+const (
+	defaultKeyCacheTTL         = 30 * time.Second
+	defaultKeyCacheNegativeTTL = 5 * time.Second
+	defaultKeyCacheMaxEntries  = 4096
+
+	// A key only signs with a handful of scopes at a time (today, and yesterday
+	// around midnight), so per-key derived keys are kept in a tiny map.
+	maxSigningKeysPerEntry = 4
+)
+
+// KeyCacheConfig configures a KeyCache. Zero values use defaults.
+type KeyCacheConfig struct {
+	Now func() time.Time
+	// TTL bounds how long an active key is served from memory. Without Sync,
+	// revocations made by another process (the key CLI or another replica) take
+	// effect within TTL.
+	TTL time.Duration
+	// NegativeTTL bounds how long an unknown access key is remembered.
+	NegativeTTL time.Duration
+	MaxEntries  int
+}
+
+// KeyCache is a bounded LRU cache of authenticated API key material keyed by
+// access key: the key row, its decrypted secret and the signing keys derived
+// from it. Unknown access keys are cached too, for a shorter time.
+//
+// Only active keys are cached. An entry never outlives the key's ExpiresAt, and
+// the middleware re-checks expiry on every hit. Keys are changed by the key CLI
+// or another replica, never by the serving process, so stale entries are
+// dropped by Sync rather than invalidated as keys change.
+type KeyCache struct {
+	now         func() time.Time
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type keyCacheEntry struct {
+	accessKey  string
+	missing    bool
+	validUntil time.Time
+
+	key         models.APIKey
+	secret      string
+	signingKeys map[string][]byte
+}
+
+// NewKeyCache builds a KeyCache.
+func NewKeyCache(cfg KeyCacheConfig) *KeyCache {
+	nowFn := cfg.Now
+	if nowFn == nil {
+		nowFn = func() time.Time { return time.Now().UTC() }
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultKeyCacheTTL
+	}
+	negativeTTL := cfg.NegativeTTL
+	if negativeTTL <= 0 {
+		negativeTTL = defaultKeyCacheNegativeTTL
+	}
+	if negativeTTL > ttl {
+		negativeTTL = ttl
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultKeyCacheMaxEntries
+	}
+	return &KeyCache{
+		now:         nowFn,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+// Invalidate drops the entry for accessKey, including a cached "not found".
+func (c *KeyCache) Invalidate(accessKey string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[strings.TrimSpace(accessKey)]; ok {
+		c.removeLocked(el)
+	}
+}
+
+// Purge drops every entry.
+func (c *KeyCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+// Len reports the number of cached entries, including negative ones.
+func (c *KeyCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Sync drops every entry that no longer matches keys, the current list of all
+// API keys: keys that were revoked, re-expired, edited or deleted since they
+// were cached, and cached "not found" results for access keys that now exist.
+// It returns the number of entries dropped. Calling it periodically lets
+// changes made by another process take effect well before the TTL.
+func (c *KeyCache) Sync(keys []models.APIKey) int {
+	if c == nil {
+		return 0
+	}
+	current := make(map[string]models.APIKey, len(keys))
+	for _, k := range keys {
+		current[k.AccessKey] = k
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*keyCacheEntry)
+		k, ok := current[e.accessKey]
+		if (e.missing && ok) || (!e.missing && (!ok || keyChanged(e.key, k))) {
+			c.removeLocked(el)
+			dropped++
+		}
+		el = next
+	}
+	return dropped
+}
+
+// keyChanged reports whether current differs from the cached key in anything
+// authentication depends on. UpdatedAt covers edits to the other fields.
+func keyChanged(cached, current models.APIKey) bool {
+	return cached.ID != current.ID ||
+		cached.Status != current.Status ||
+		cached.ClientCertSubject != current.ClientCertSubject ||
+		!cached.UpdatedAt.Equal(current.UpdatedAt) ||
+		!equalTimePtr(cached.RevokedAt, current.RevokedAt) ||
+		!equalTimePtr(cached.ExpiresAt, current.ExpiresAt)
+}
+
+func equalTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// get returns the live entry for accessKey, if any.
+func (c *KeyCache) get(accessKey string) (*keyCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[accessKey]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*keyCacheEntry)
+	if !c.now().Before(e.validUntil) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *KeyCache) putKey(key models.APIKey, secret string) *keyCacheEntry {
+	validUntil := c.now().Add(c.ttl)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(validUntil) {
+		validUntil = key.ExpiresAt.UTC()
+	}
+	key.SecretKeyCiphertext = ""
+	key.SecretKeyHash = ""
+	e := &keyCacheEntry{accessKey: key.AccessKey, validUntil: validUntil, key: key, secret: secret}
+	c.put(e)
+	return e
+}
+
+func (c *KeyCache) putMissing(accessKey string) {
+	c.put(&keyCacheEntry{accessKey: accessKey, missing: true, validUntil: c.now().Add(c.negativeTTL)})
+}
+
+func (c *KeyCache) put(e *keyCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.accessKey]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[e.accessKey] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// signingKey returns the derived signing key for the entry and scope, deriving
+// and remembering it on first use.
+func (c *KeyCache) signingKey(e *keyCacheEntry, date, region, service, suffix string) []byte {
+	scope := date + "/" + region + "/" + service + "/" + suffix
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if k, ok := e.signingKeys[scope]; ok {
+		return k
+	}
+	k := deriveSigningKey(e.secret, date, region, service, suffix)
+	if e.signingKeys == nil || len(e.signingKeys) >= maxSigningKeysPerEntry {
+		e.signingKeys = make(map[string][]byte, maxSigningKeysPerEntry)
+	}
+	e.signingKeys[scope] = k
+	return k
+}
+
+func (c *KeyCache) removeLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*keyCacheEntry)
+	delete(c.entries, e.accessKey)
+}
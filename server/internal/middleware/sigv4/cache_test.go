@@ -0,0 +1,257 @@
+package sigv4
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/secretcrypto"
+	apikeyservice "github.com/jimeng-relay/server/internal/service/apikey"
+)
+
+type countingAPIKeyRepo struct {
+	*memoryAPIKeyRepo
+	lookups int
+}
+
+func (c *countingAPIKeyRepo) GetByAccessKey(ctx context.Context, accessKey string) (models.APIKey, error) {
+	c.lookups++
+	return c.memoryAPIKeyRepo.GetByAccessKey(ctx, accessKey)
+}
+
+type countingCipher struct {
+	secretcrypto.Cipher
+	decrypts int
+}
+
+func (c *countingCipher) Decrypt(ciphertext string) (string, error) {
+	c.decrypts++
+	return c.Cipher.Decrypt(ciphertext)
+}
+
+func serveSigned(t *testing.T, mw func(http.Handler) http.Handler, accessKey, secret string, now time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", []byte(`{"prompt":"cat"}`), accessKey, secret, now)
+	rec := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestKeyCache_ServesRepeatRequestsFromMemory(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := &countingCipher{Cipher: mustTestCipher(t)}
+	repo := &countingAPIKeyRepo{memoryAPIKeyRepo: &memoryAPIKeyRepo{keys: map[string]models.APIKey{}}}
+	key := activeKey(t, c.Cipher, "key_1", "ak_test", "sk_test_secret")
+	repo.keys[key.ID] = key
+
+	cache := NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }})
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, KeyCache: cache})
+
+	for i := 0; i < 3; i++ {
+		if rec := serveSigned(t, mw, "ak_test", "sk_test_secret", now); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d body=%s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if repo.lookups != 1 || c.decrypts != 1 {
+		t.Fatalf("expected 1 lookup and 1 decrypt, got lookups=%d decrypts=%d", repo.lookups, c.decrypts)
+	}
+
+	now = now.Add(31 * time.Second)
+	if rec := serveSigned(t, mw, "ak_test", "sk_test_secret", now); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status after ttl: %d body=%s", rec.Code, rec.Body.String())
+	}
+	if repo.lookups != 2 {
+		t.Fatalf("expected entry to be reloaded after ttl, got lookups=%d", repo.lookups)
+	}
+}
+
+func TestKeyCache_WrongSecretStillRejectedOnCacheHit(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, KeyCache: NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }})})
+
+	if rec := serveSigned(t, mw, "ak_test", "sk_test_secret", now); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	assertErrorCode(t, serveSigned(t, mw, "ak_test", "sk_wrong", now), http.StatusUnauthorized, "INVALID_SIGNATURE")
+}
+
+func TestKeyCache_NegativeCachingUntilInvalidated(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &countingAPIKeyRepo{memoryAPIKeyRepo: &memoryAPIKeyRepo{keys: map[string]models.APIKey{}}}
+	cache := NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }})
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, KeyCache: cache})
+
+	assertErrorCode(t, serveSigned(t, mw, "ak_late", "sk_late_secret", now), http.StatusUnauthorized, "AUTH_FAILED")
+	assertErrorCode(t, serveSigned(t, mw, "ak_late", "sk_late_secret", now), http.StatusUnauthorized, "AUTH_FAILED")
+	if repo.lookups != 1 {
+		t.Fatalf("expected unknown key to be looked up once, got %d", repo.lookups)
+	}
+
+	key := activeKey(t, c, "key_late", "ak_late", "sk_late_secret")
+	repo.keys[key.ID] = key
+	assertErrorCode(t, serveSigned(t, mw, "ak_late", "sk_late_secret", now), http.StatusUnauthorized, "AUTH_FAILED")
+
+	cache.Invalidate("ak_late")
+	if rec := serveSigned(t, mw, "ak_late", "sk_late_secret", now); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status after invalidate: %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	now = now.Add(defaultKeyCacheNegativeTTL)
+	assertErrorCode(t, serveSigned(t, mw, "ak_other", "sk", now), http.StatusUnauthorized, "AUTH_FAILED")
+	now = now.Add(defaultKeyCacheNegativeTTL)
+	assertErrorCode(t, serveSigned(t, mw, "ak_other", "sk", now), http.StatusUnauthorized, "AUTH_FAILED")
+	if repo.lookups != 4 {
+		t.Fatalf("expected negative entry to expire after %v, got lookups=%d", defaultKeyCacheNegativeTTL, repo.lookups)
+	}
+}
+
+func TestKeyCache_RevokeAndRotateAfterSync(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &memoryAPIKeyRepo{keys: map[string]models.APIKey{}}
+	cache := NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }, TTL: time.Hour})
+	svc := apikeyservice.NewService(repo, apikeyservice.Config{
+		Now:          func() time.Time { return now },
+		BcryptCost:   4,
+		SecretCipher: c,
+	})
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, KeyCache: cache})
+	sync := func() {
+		keys, err := repo.List(context.Background())
+		if err != nil {
+			t.Fatalf("list keys: %v", err)
+		}
+		cache.Sync(keys)
+	}
+
+	revoked, err := svc.Create(context.Background(), apikeyservice.CreateRequest{})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	rotated, err := svc.Create(context.Background(), apikeyservice.CreateRequest{})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	for _, k := range []apikeyservice.KeyWithSecret{revoked, rotated} {
+		if rec := serveSigned(t, mw, k.AccessKey, k.SecretKey, now); rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if err := svc.Revoke(context.Background(), revoked.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	sync()
+	assertErrorCode(t, serveSigned(t, mw, revoked.AccessKey, revoked.SecretKey, now), http.StatusUnauthorized, "KEY_REVOKED")
+
+	if _, err := svc.Rotate(context.Background(), apikeyservice.RotateRequest{ID: rotated.ID, GracePeriod: time.Minute}); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	sync()
+	if rec := serveSigned(t, mw, rotated.AccessKey, rotated.SecretKey, now); rec.Code != http.StatusOK {
+		t.Fatalf("expected old key to work during grace period, got %d", rec.Code)
+	}
+	now = now.Add(time.Minute)
+	assertErrorCode(t, serveSigned(t, mw, rotated.AccessKey, rotated.SecretKey, now), http.StatusUnauthorized, "KEY_EXPIRED")
+}
+
+func TestKeyCache_SyncDropsKeysChangedByAnotherProcess(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &memoryAPIKeyRepo{keys: map[string]models.APIKey{}}
+	cache := NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }, TTL: time.Hour})
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, KeyCache: cache})
+	// The key CLI runs in its own process, so nothing invalidates the cache.
+	cli := apikeyservice.NewService(repo, apikeyservice.Config{Now: func() time.Time { return now }, BcryptCost: 4, SecretCipher: c})
+	sync := func() int {
+		keys, err := repo.List(context.Background())
+		if err != nil {
+			t.Fatalf("list keys: %v", err)
+		}
+		return cache.Sync(keys)
+	}
+
+	revoked, err := cli.Create(context.Background(), apikeyservice.CreateRequest{})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	kept, err := cli.Create(context.Background(), apikeyservice.CreateRequest{})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	for _, k := range []apikeyservice.KeyWithSecret{revoked, kept} {
+		if rec := serveSigned(t, mw, k.AccessKey, k.SecretKey, now); rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+		}
+	}
+	assertErrorCode(t, serveSigned(t, mw, "ak_unknown", "sk", now), http.StatusUnauthorized, "AUTH_FAILED")
+	if n := sync(); n != 0 {
+		t.Fatalf("expected unchanged keys to stay cached, dropped %d", n)
+	}
+
+	if err := cli.Revoke(context.Background(), revoked.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if rec := serveSigned(t, mw, revoked.AccessKey, revoked.SecretKey, now); rec.Code != http.StatusOK {
+		t.Fatalf("expected the stale entry to be served before a sync, got %d", rec.Code)
+	}
+	repo.keys["key_late"] = activeKey(t, c, "key_late", "ak_unknown", "sk_late_secret")
+	if n := sync(); n != 2 {
+		t.Fatalf("expected the revoked key and the negative entry to be dropped, dropped %d", n)
+	}
+	assertErrorCode(t, serveSigned(t, mw, revoked.AccessKey, revoked.SecretKey, now), http.StatusUnauthorized, "KEY_REVOKED")
+	if rec := serveSigned(t, mw, "ak_unknown", "sk_late_secret", now); rec.Code != http.StatusOK {
+		t.Fatalf("expected the new key to authenticate after a sync, got %d", rec.Code)
+	}
+	if rec := serveSigned(t, mw, kept.AccessKey, kept.SecretKey, now); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status for unchanged key: %d", rec.Code)
+	}
+}
+
+func TestKeyCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	cache := NewKeyCache(KeyCacheConfig{Now: func() time.Time { return now }, MaxEntries: 2})
+
+	cache.putKey(models.APIKey{ID: "key_a", AccessKey: "ak_a"}, "sk_a")
+	cache.putKey(models.APIKey{ID: "key_b", AccessKey: "ak_b"}, "sk_b")
+	if _, ok := cache.get("ak_a"); !ok {
+		t.Fatalf("expected ak_a cached")
+	}
+	cache.putMissing("ak_c")
+
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", cache.Len())
+	}
+	if _, ok := cache.get("ak_b"); ok {
+		t.Fatalf("expected least recently used ak_b to be evicted")
+	}
+	if _, ok := cache.get("ak_a"); !ok {
+		t.Fatalf("expected ak_a to survive eviction")
+	}
+}
+
+func TestKeyCache_SigningKeyDerivedPerScope(t *testing.T) {
+	cache := NewKeyCache(KeyCacheConfig{})
+	e := cache.putKey(models.APIKey{ID: "key_a", AccessKey: "ak_a"}, "sk_a")
+
+	first := cache.signingKey(e, "20260224", "cn-north-1", "cv", "request")
+	if !bytes.Equal(first, deriveSigningKey("sk_a", "20260224", "cn-north-1", "cv", "request")) {
+		t.Fatalf("cached signing key does not match derivation")
+	}
+	next := cache.signingKey(e, "20260225", "cn-north-1", "cv", "request")
+	if bytes.Equal(first, next) {
+		t.Fatalf("expected a different signing key for a different date")
+	}
+	if len(e.signingKeys) != 2 {
+		t.Fatalf("expected 2 cached scopes, got %d", len(e.signingKeys))
+	}
+}
@@ -0,0 +1,24 @@
+package sigv4
+
+import (
+	"net/http"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+// CheckClientCert enforces an API key's client certificate binding. When
+// subject is non-empty the request must have arrived over TLS with a client
+// certificate that the listener verified, and the leaf certificate's subject
+// (RFC 2253 form, as printed by pkix.Name.String) must equal subject.
+func CheckClientCert(r *http.Request, subject string) error {
+	if subject == "" {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "api key requires a verified client certificate", nil)
+	}
+	if r.TLS.VerifiedChains[0][0].Subject.String() != subject {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "client certificate subject does not match api key", nil)
+	}
+	return nil
+}
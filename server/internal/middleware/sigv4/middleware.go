@@ -16,6 +16,7 @@ import (
 	"time"
 
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
 	"github.com/jimeng-relay/server/internal/repository"
 	"github.com/jimeng-relay/server/internal/secretcrypto"
 )
@@ -36,6 +37,24 @@ type Config struct {
 	SecretCipher    secretcrypto.Cipher
 	ExpectedRegion  string
 	ExpectedService string
+	// KeyCache, when set, serves API keys and derived signing keys from memory
+	// instead of querying the repository and decrypting on every request.
+	KeyCache *KeyCache
+	// ReplayStore, when set, rejects a second request carrying an already
+	// accepted signature. ReplayExempt selects requests that skip the check.
+	ReplayStore  ReplayStore
+	ReplayExempt func(r *http.Request) bool
+	// MaxPresignExpires caps X-Expires on presigned URLs.
+	MaxPresignExpires time.Duration
+	// Activity, when set, is told about every request that passes
+	// verification.
+	Activity ActivityRecorder
+}
+
+// ActivityRecorder tracks API key usage. Record runs on the request path and
+// must not block on I/O.
+type ActivityRecorder interface {
+	Record(apiKeyID, remoteAddr string, at time.Time)
 }
 
 type Middleware struct {
@@ -45,6 +64,11 @@ type Middleware struct {
 	secretCipher    secretcrypto.Cipher
 	expectedRegion  string
 	expectedService string
+	cache           *KeyCache
+	replayStore     ReplayStore
+	replayExempt    func(r *http.Request) bool
+	maxPresign      time.Duration
+	activity        ActivityRecorder
 }
 
 func New(repo repository.APIKeyRepository, cfg Config) func(http.Handler) http.Handler {
@@ -64,7 +88,23 @@ func New(repo repository.APIKeyRepository, cfg Config) func(http.Handler) http.H
 	if expectedService == "" {
 		expectedService = "cv"
 	}
-	m := &Middleware{repo: repo, now: nowFn, clockSkew: skew, secretCipher: cfg.SecretCipher, expectedRegion: expectedRegion, expectedService: expectedService}
+	maxPresign := cfg.MaxPresignExpires
+	if maxPresign <= 0 {
+		maxPresign = DefaultMaxPresignExpires
+	}
+	m := &Middleware{
+		repo:            repo,
+		now:             nowFn,
+		clockSkew:       skew,
+		secretCipher:    cfg.SecretCipher,
+		expectedRegion:  expectedRegion,
+		expectedService: expectedService,
+		cache:           cfg.KeyCache,
+		replayStore:     cfg.ReplayStore,
+		replayExempt:    cfg.ReplayExempt,
+		maxPresign:      maxPresign,
+		activity:        cfg.Activity,
+	}
 	return m.wrap
 }
 
@@ -75,6 +115,11 @@ func (m *Middleware) wrap(next http.Handler) http.Handler {
 			writeUnauthorized(w, err)
 			return
 		}
+		if m.activity != nil {
+			if id, ok := r.Context().Value(ContextAPIKeyID).(string); ok {
+				m.activity.Record(id, r.RemoteAddr, m.now())
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -92,6 +137,9 @@ type authFields struct {
 func (m *Middleware) verify(r *http.Request) error {
 	authorization := strings.TrimSpace(r.Header.Get("Authorization"))
 	if authorization == "" {
+		if r.URL.Query().Get(presignSignatureParam) != "" {
+			return m.verifyPresigned(r)
+		}
 		return internalerrors.New(internalerrors.ErrAuthFailed, "missing authorization header", nil)
 	}
 	fields, err := parseAuthorization(authorization)
@@ -143,55 +191,118 @@ func (m *Middleware) verify(r *http.Request) error {
 		return internalerrors.New(internalerrors.ErrInvalidSignature, "payload hash mismatch", nil)
 	}
 
-	key, err := m.repo.GetByAccessKey(r.Context(), fields.accessKey)
+	key, secretKey, cached, err := m.resolveKey(r.Context(), fields.accessKey, now)
 	if err != nil {
-		if repository.IsNotFound(err) {
-			return internalerrors.New(internalerrors.ErrAuthFailed, "api key not found", err)
-		}
-		return internalerrors.New(internalerrors.ErrDatabaseError, "query api key", err)
-	}
-	if key.IsRevoked() {
-		return internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil)
+		return err
 	}
-	if key.Status == "expired" || (key.ExpiresAt != nil && !key.ExpiresAt.UTC().After(now)) {
-		return internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
+
+	canonicalRequest, err := buildCanonicalRequest(r, fields.signedHeaders, payloadHash)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "build canonical request", err)
 	}
-	if m.secretCipher == nil {
-		return internalerrors.New(internalerrors.ErrInternalError, "secret cipher is not configured", nil)
+	expectedSignature := m.computeSignature(fields, secretKey, cached, xDate, canonicalRequest)
+	if !constantTimeHexEqual(fields.signature, expectedSignature) {
+		return internalerrors.New(internalerrors.ErrInvalidSignature, "signature mismatch", nil)
 	}
-	if strings.TrimSpace(key.SecretKeyCiphertext) == "" {
-		return internalerrors.New(internalerrors.ErrAuthFailed, "api key secret is unavailable", nil)
+	if err := CheckClientCert(r, key.ClientCertSubject); err != nil {
+		return err
 	}
-	secretKey, err := m.secretCipher.Decrypt(key.SecretKeyCiphertext)
-	if err != nil {
-		return internalerrors.New(internalerrors.ErrAuthFailed, "decrypt api key secret", err)
+	if m.replayStore != nil && (m.replayExempt == nil || !m.replayExempt(r)) {
+		// The signature covers X-Date, so the same value can only be presented
+		// again until X-Date leaves the skew window.
+		fresh, err := m.replayStore.Remember(r.Context(), expectedSignature, t.UTC().Add(m.clockSkew))
+		if err != nil {
+			return internalerrors.New(internalerrors.ErrDatabaseError, "record request signature", err)
+		}
+		if !fresh {
+			return internalerrors.New(internalerrors.ErrInvalidSignature, "request signature has already been used", nil)
+		}
 	}
 
-	canonicalRequest, err := buildCanonicalRequest(r, fields.signedHeaders, payloadHash)
-	if err != nil {
-		return internalerrors.New(internalerrors.ErrAuthFailed, "build canonical request", err)
+	ctx := context.WithValue(r.Context(), ContextAPIKeyID, key.ID)
+	*r = *r.WithContext(ctx)
+	return nil
+}
+
+// computeSignature signs canonicalRequest with the key's signing key for the
+// credential scope in fields, using the key cache when the key came from it.
+func (m *Middleware) computeSignature(fields authFields, secretKey string, cached *keyCacheEntry, xDate, canonicalRequest string) string {
+	var signingKey []byte
+	if cached != nil {
+		signingKey = m.cache.signingKey(cached, fields.dateScope, fields.region, fields.service, fields.scopeSuffix)
+	} else {
+		signingKey = deriveSigningKey(secretKey, fields.dateScope, fields.region, fields.service, fields.scopeSuffix)
 	}
+	return signCanonicalRequest(signingKey, fields, xDate, canonicalRequest)
+}
+
+func signCanonicalRequest(signingKey []byte, fields authFields, xDate, canonicalRequest string) string {
 	scope := strings.Join([]string{fields.dateScope, fields.region, fields.service, fields.scopeSuffix}, "/")
-	// Use algorithm name based on scope suffix
-	algorithm := "AWS4-HMAC-SHA256"
-	if fields.scopeSuffix == "request" {
-		algorithm = "HMAC-SHA256"
-	}
 	stringToSign := strings.Join([]string{
-		algorithm,
+		algorithmForSuffix(fields.scopeSuffix),
 		xDate,
 		scope,
 		sha256Hex([]byte(canonicalRequest)),
 	}, "\n")
-	signingKey := deriveSigningKey(secretKey, fields.dateScope, fields.region, fields.service, fields.scopeSuffix)
-	expectedSignature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
-	if !constantTimeHexEqual(fields.signature, expectedSignature) {
-		return internalerrors.New(internalerrors.ErrInvalidSignature, "signature mismatch", nil)
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+// algorithmForSuffix maps the credential scope suffix to the algorithm name:
+// "request" is the Volc SDK flavour, "aws4_request" the AWS one.
+func algorithmForSuffix(suffix string) string {
+	if suffix == "request" {
+		return "HMAC-SHA256"
+	}
+	return "AWS4-HMAC-SHA256"
+}
+
+// resolveKey loads the API key for accessKey and decrypts its secret, serving
+// from the key cache when one is configured. The returned cache entry is nil
+// when the result did not come from (or go into) the cache.
+func (m *Middleware) resolveKey(ctx context.Context, accessKey string, now time.Time) (models.APIKey, string, *keyCacheEntry, error) {
+	if m.cache != nil {
+		if e, ok := m.cache.get(accessKey); ok {
+			if e.missing {
+				return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrAuthFailed, "api key not found", repository.ErrNotFound)
+			}
+			if e.key.ExpiresAt != nil && !e.key.ExpiresAt.UTC().After(now) {
+				m.cache.Invalidate(accessKey)
+				return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
+			}
+			return e.key, e.secret, e, nil
+		}
 	}
 
-	ctx := context.WithValue(r.Context(), ContextAPIKeyID, key.ID)
-	*r = *r.WithContext(ctx)
-	return nil
+	key, err := m.repo.GetByAccessKey(ctx, accessKey)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			if m.cache != nil {
+				m.cache.putMissing(accessKey)
+			}
+			return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrAuthFailed, "api key not found", err)
+		}
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrDatabaseError, "query api key", err)
+	}
+	if key.IsRevoked() {
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil)
+	}
+	if key.Status == "expired" || (key.ExpiresAt != nil && !key.ExpiresAt.UTC().After(now)) {
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
+	}
+	if m.secretCipher == nil {
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrInternalError, "secret cipher is not configured", nil)
+	}
+	if strings.TrimSpace(key.SecretKeyCiphertext) == "" {
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrAuthFailed, "api key secret is unavailable", nil)
+	}
+	secretKey, err := m.secretCipher.Decrypt(key.SecretKeyCiphertext)
+	if err != nil {
+		return models.APIKey{}, "", nil, internalerrors.New(internalerrors.ErrAuthFailed, "decrypt api key secret", err)
+	}
+	if m.cache == nil {
+		return key, secretKey, nil, nil
+	}
+	return key, secretKey, m.cache.putKey(key, secretKey), nil
 }
 
 func parseAuthorization(v string) (authFields, error) {
@@ -245,6 +356,10 @@ func parseAuthorization(v string) (authFields, error) {
 }
 
 func buildCanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) (string, error) {
+	return buildCanonicalRequestWithQuery(r, r.URL.Query(), signedHeaders, payloadHash)
+}
+
+func buildCanonicalRequestWithQuery(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) (string, error) {
 	headers := append([]string(nil), signedHeaders...)
 	sort.Strings(headers)
 	canonHeaders := strings.Builder{}
@@ -264,7 +379,7 @@ func buildCanonicalRequest(r *http.Request, signedHeaders []string, payloadHash
 	canon := strings.Join([]string{
 		r.Method,
 		canonicalURI(r.URL.Path),
-		canonicalQueryString(r.URL.Query()),
+		canonicalQueryString(query),
 		canonHeaders.String(),
 		strings.Join(headers, ";"),
 		payloadHash,
@@ -3,6 +3,9 @@ package sigv4
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
 	"io"
@@ -46,6 +49,44 @@ func TestMiddleware_ValidSignaturePasses(t *testing.T) {
 	}
 }
 
+type recordedUse struct {
+	apiKeyID, remoteAddr string
+	at                   time.Time
+}
+
+type fakeActivity struct{ uses []recordedUse }
+
+func (f *fakeActivity) Record(apiKeyID, remoteAddr string, at time.Time) {
+	f.uses = append(f.uses, recordedUse{apiKeyID, remoteAddr, at})
+}
+
+func TestMiddleware_RecordsActivityOnlyForVerifiedRequests(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	activity := &fakeActivity{}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, Activity: activity})
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+
+	req := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", []byte(`{}`), "ak_test", "sk_test_secret", now)
+	req.RemoteAddr = "203.0.113.7:52100"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	bad := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", []byte(`{}`), "ak_test", "wrong_secret", now)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, bad)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the bad signature to be rejected, got %d", rec.Code)
+	}
+
+	if len(activity.uses) != 1 {
+		t.Fatalf("expected one recorded use, got %+v", activity.uses)
+	}
+	if got := activity.uses[0]; got.apiKeyID != "key_1" || got.remoteAddr != "203.0.113.7:52100" || !got.at.Equal(now) {
+		t.Fatalf("unexpected recorded use: %+v", got)
+	}
+}
+
 func TestMiddleware_TamperedBodyRejected(t *testing.T) {
 	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
 	c := mustTestCipher(t)
@@ -168,6 +209,37 @@ func TestMiddleware_RevokedOrExpiredKeyRejected(t *testing.T) {
 	}
 }
 
+func TestMiddleware_ClientCertBinding(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	key := activeKey(t, c, "key_1", "ak_test", "sk_test_secret")
+	key.ClientCertSubject = "CN=worker-a,O=Example"
+	mw := New(&stubRepo{key: key}, Config{Now: func() time.Time { return now }, SecretCipher: c})
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	withCert := func(r *http.Request, cn string) *http.Request {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn, Organization: []string{"Example"}}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return r
+	}
+	body := []byte(`{"prompt":"cat"}`)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now))
+	assertErrorCode(t, rec, http.StatusUnauthorized, "AUTH_FAILED")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, withCert(newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now), "worker-b"))
+	assertErrorCode(t, rec, http.StatusUnauthorized, "AUTH_FAILED")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, withCert(newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now), "worker-a"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected matching client certificate to pass, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
 func TestMiddleware_LegacyManagementPathsNoLongerBypassVerification(t *testing.T) {
 	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
 	mw := New(&stubRepo{err: repository.ErrNotFound}, Config{Now: func() time.Time { return now }, SecretCipher: mustTestCipher(t)})
@@ -0,0 +1,220 @@
+package sigv4
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+const (
+	presignAlgorithmParam     = "X-Algorithm"
+	presignCredentialParam    = "X-Credential"
+	presignDateParam          = "X-Date"
+	presignExpiresParam       = "X-Expires"
+	presignSignedHeadersParam = "X-SignedHeaders"
+	presignSignatureParam     = "X-Signature"
+
+	// DefaultMaxPresignExpires is the longest lifetime a presigned URL may ask for.
+	DefaultMaxPresignExpires = time.Hour
+
+	presignScopeSuffix = "request"
+)
+
+// PresignRequest describes a URL to sign with query-string SigV4.
+type PresignRequest struct {
+	// Method defaults to GET. Presigned URLs carry no body.
+	Method    string
+	URL       string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Service   string
+	Expires   time.Duration
+	Now       time.Time
+}
+
+// PresignURL returns req.URL with X-Date, X-Credential, X-Expires,
+// X-SignedHeaders and X-Signature query parameters added. Only the host header
+// is signed, so the URL can be opened by any HTTP client until it expires.
+func PresignURL(req PresignRequest) (string, error) {
+	method := strings.ToUpper(strings.TrimSpace(req.Method))
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "presigned URLs only support GET and HEAD", nil)
+	}
+	if strings.TrimSpace(req.AccessKey) == "" || strings.TrimSpace(req.SecretKey) == "" {
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "access key and secret key are required", nil)
+	}
+	if req.Expires <= 0 {
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "expires must be positive", nil)
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil {
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "parse url", err)
+	}
+	if u.Host == "" {
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "url must be absolute", nil)
+	}
+	region := strings.TrimSpace(req.Region)
+	if region == "" {
+		region = "cn-north-1"
+	}
+	service := strings.TrimSpace(req.Service)
+	if service == "" {
+		service = "cv"
+	}
+	now := req.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+
+	xDate := now.Format(xDateLayout)
+	dateScope := now.Format("20060102")
+	q := u.Query()
+	for _, k := range []string{presignAlgorithmParam, presignCredentialParam, presignDateParam, presignExpiresParam, presignSignedHeadersParam, presignSignatureParam} {
+		q.Del(k)
+	}
+	q.Set(presignAlgorithmParam, algorithmForSuffix(presignScopeSuffix))
+	q.Set(presignCredentialParam, strings.Join([]string{req.AccessKey, dateScope, region, service, presignScopeSuffix}, "/"))
+	q.Set(presignDateParam, xDate)
+	q.Set(presignExpiresParam, strconv.FormatInt(int64(req.Expires/time.Second), 10))
+	q.Set(presignSignedHeadersParam, "host")
+
+	r := &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}}
+	canonicalRequest, err := buildCanonicalRequestWithQuery(r, q, []string{"host"}, sha256Hex(nil))
+	if err != nil {
+		return "", err
+	}
+	fields := authFields{dateScope: dateScope, region: region, service: service, scopeSuffix: presignScopeSuffix}
+	signingKey := deriveSigningKey(req.SecretKey, dateScope, region, service, presignScopeSuffix)
+	q.Set(presignSignatureParam, signCanonicalRequest(signingKey, fields, xDate, canonicalRequest))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// verifyPresigned authenticates a request signed in its query string. Presigned
+// URLs are meant to be reused until they expire (page reloads, media players
+// issuing several range requests), so they are not subject to replay checks.
+func (m *Middleware) verifyPresigned(r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "presigned URLs only support GET and HEAD", nil)
+	}
+	q := r.URL.Query()
+	fields, err := parsePresignQuery(q)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "invalid presigned url", err)
+	}
+
+	xDate := strings.TrimSpace(q.Get(presignDateParam))
+	t, err := time.Parse(xDateLayout, xDate)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "invalid x-date parameter", err)
+	}
+	expiresSeconds, err := strconv.ParseInt(strings.TrimSpace(q.Get(presignExpiresParam)), 10, 64)
+	if err != nil || expiresSeconds <= 0 {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "invalid x-expires parameter", err)
+	}
+	expires := time.Duration(expiresSeconds) * time.Second
+	if expires > m.maxPresign {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "x-expires exceeds the allowed maximum", nil)
+	}
+	now := m.now().UTC()
+	if t.UTC().After(now.Add(m.clockSkew)) {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "request time is outside allowed window", nil)
+	}
+	if now.After(t.UTC().Add(expires)) {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "presigned url has expired", nil)
+	}
+	if strings.TrimSpace(fields.dateScope) != t.UTC().Format("20060102") {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "credential scope date does not match x-date", nil)
+	}
+	if strings.ToLower(strings.TrimSpace(fields.region)) != m.expectedRegion || strings.ToLower(strings.TrimSpace(fields.service)) != m.expectedService {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "credential scope region/service is not allowed", nil)
+	}
+	if !containsHeader(fields.signedHeaders, "host") {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "signed headers must include host", nil)
+	}
+	if strings.TrimSpace(r.Host) == "" {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "missing host header", nil)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1))
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "read request body", err)
+	}
+	if len(body) != 0 {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "presigned requests must not have a body", nil)
+	}
+
+	key, secretKey, cached, err := m.resolveKey(r.Context(), fields.accessKey, now)
+	if err != nil {
+		return err
+	}
+
+	signed := url.Values{}
+	for k, v := range q {
+		if k != presignSignatureParam {
+			signed[k] = v
+		}
+	}
+	canonicalRequest, err := buildCanonicalRequestWithQuery(r, signed, fields.signedHeaders, sha256Hex(nil))
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrAuthFailed, "build canonical request", err)
+	}
+	expectedSignature := m.computeSignature(fields, secretKey, cached, xDate, canonicalRequest)
+	if !constantTimeHexEqual(fields.signature, expectedSignature) {
+		return internalerrors.New(internalerrors.ErrInvalidSignature, "signature mismatch", nil)
+	}
+
+	if err := CheckClientCert(r, key.ClientCertSubject); err != nil {
+		return err
+	}
+	ctx := context.WithValue(r.Context(), ContextAPIKeyID, key.ID)
+	*r = *r.WithContext(ctx)
+	return nil
+}
+
+func parsePresignQuery(q url.Values) (authFields, error) {
+	credential := strings.TrimSpace(q.Get(presignCredentialParam))
+	signedHeaders := strings.TrimSpace(q.Get(presignSignedHeadersParam))
+	signature := strings.TrimSpace(q.Get(presignSignatureParam))
+	if credential == "" || signedHeaders == "" || signature == "" {
+		return authFields{}, internalerrors.New(internalerrors.ErrAuthFailed, "presign parameters are incomplete", nil)
+	}
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 {
+		return authFields{}, internalerrors.New(internalerrors.ErrAuthFailed, "invalid credential scope", nil)
+	}
+	if scope[4] != "aws4_request" && scope[4] != "request" {
+		return authFields{}, internalerrors.New(internalerrors.ErrAuthFailed, "invalid credential scope suffix", nil)
+	}
+	for _, part := range scope[:4] {
+		if part == "" {
+			return authFields{}, internalerrors.New(internalerrors.ErrAuthFailed, "credential scope is incomplete", nil)
+		}
+	}
+	if algorithm := strings.TrimSpace(q.Get(presignAlgorithmParam)); algorithm != "" && algorithm != algorithmForSuffix(scope[4]) {
+		return authFields{}, internalerrors.New(internalerrors.ErrAuthFailed, "x-algorithm does not match credential scope", nil)
+	}
+	parsedHeaders := strings.Split(strings.ToLower(signedHeaders), ";")
+	for i := range parsedHeaders {
+		parsedHeaders[i] = strings.TrimSpace(parsedHeaders[i])
+	}
+	return authFields{
+		accessKey:     scope[0],
+		dateScope:     scope[1],
+		region:        scope[2],
+		service:       scope[3],
+		signedHeaders: parsedHeaders,
+		signature:     strings.ToLower(signature),
+		scopeSuffix:   scope[4],
+	}, nil
+}
@@ -0,0 +1,147 @@
+package sigv4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func presign(t *testing.T, target, accessKey, secret string, expires time.Duration, now time.Time) string {
+	t.Helper()
+	signed, err := PresignURL(PresignRequest{URL: target, AccessKey: accessKey, SecretKey: secret, Expires: expires, Now: now})
+	if err != nil {
+		t.Fatalf("presign: %v", err)
+	}
+	return signed
+}
+
+func TestPresignURL_RoundTrip(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, ReplayStore: NewMemoryReplayStore(func() time.Time { return now })})
+
+	signed := presign(t, "http://relay.local/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1", "ak_test", "sk_test_secret", 10*time.Minute, now)
+
+	var gotKeyID string
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKeyID, _ = r.Context().Value(ContextAPIKeyID).(string)
+		w.WriteHeader(http.StatusOK)
+	}))
+	// A presigned link is reusable until it expires.
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, signed, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d body=%s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if gotKeyID != "key_1" {
+		t.Fatalf("expected api key id in context, got %q", gotKeyID)
+	}
+
+	now = now.Add(9 * time.Minute)
+	if rec := serveSignedRequest(mw, httptest.NewRequest(http.MethodGet, signed, nil)); rec.Code != http.StatusOK {
+		t.Fatalf("expected url to be valid before expiry, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	now = now.Add(2 * time.Minute)
+	assertErrorCode(t, serveSignedRequest(mw, httptest.NewRequest(http.MethodGet, signed, nil)), http.StatusUnauthorized, "AUTH_FAILED")
+}
+
+func TestPresignURL_GoldenSignature(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	signed := presign(t, "https://relay.example.com/v1/get-result?task_id=t1&req_key=jimeng_t2i_v40", "ak_test", "sk_test_secret", 15*time.Minute, now)
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("parse signed url: %v", err)
+	}
+	q := u.Query()
+	if got := q.Get("X-Credential"); got != "ak_test/20260224/cn-north-1/cv/request" {
+		t.Fatalf("unexpected credential: %q", got)
+	}
+	if got := q.Get("X-Expires"); got != "900" {
+		t.Fatalf("unexpected expires: %q", got)
+	}
+	// Kept in sync with the client's presign test so both sides agree on the
+	// canonical form.
+	if got := q.Get("X-Signature"); got != goldenPresignSignature {
+		t.Fatalf("unexpected signature: %s", got)
+	}
+}
+
+const goldenPresignSignature = "a8e2bef9674883a9e565ad25d9edca45a720dbe0d21ff12f29f47564c76dad1f"
+
+func TestMiddleware_PresignedRejections(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, MaxPresignExpires: 30 * time.Minute})
+
+	const target = "http://relay.local/v1/get-result?req_key=jimeng_t2i_v40&task_id=t1"
+	valid := presign(t, target, "ak_test", "sk_test_secret", 10*time.Minute, now)
+
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		code string
+	}{
+		{
+			name: "expires above maximum",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, presign(t, target, "ak_test", "sk_test_secret", time.Hour, now), nil)
+			},
+			code: "AUTH_FAILED",
+		},
+		{
+			name: "tampered query parameter",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, strings.Replace(valid, "task_id=t1", "task_id=t2", 1), nil)
+			},
+			code: "INVALID_SIGNATURE",
+		},
+		{
+			name: "wrong secret",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, presign(t, target, "ak_test", "sk_wrong", 10*time.Minute, now), nil)
+			},
+			code: "INVALID_SIGNATURE",
+		},
+		{
+			name: "post not allowed",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, valid, nil)
+			},
+			code: "AUTH_FAILED",
+		},
+		{
+			name: "body not allowed",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, valid, strings.NewReader(`{"task_id":"t2"}`))
+			},
+			code: "AUTH_FAILED",
+		},
+		{
+			name: "signed in the future",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, presign(t, target, "ak_test", "sk_test_secret", 10*time.Minute, now.Add(10*time.Minute)), nil)
+			},
+			code: "AUTH_FAILED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertErrorCode(t, serveSignedRequest(mw, tt.req()), http.StatusUnauthorized, tt.code)
+		})
+	}
+}
+
+func TestPresignURL_RejectsUnsupportedMethod(t *testing.T) {
+	_, err := PresignURL(PresignRequest{Method: http.MethodPost, URL: "http://relay.local/v1/submit", AccessKey: "ak", SecretKey: "sk", Expires: time.Minute})
+	if err == nil {
+		t.Fatalf("expected error for POST")
+	}
+}
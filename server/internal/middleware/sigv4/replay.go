@@ -0,0 +1,68 @@
+package sigv4
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultReplaySweepInterval = time.Minute
+
+// ReplayStore records accepted request signatures. A signature only needs to be
+// remembered until its X-Date leaves the clock-skew window; after that the
+// request is rejected by the time check anyway.
+//
+// repository.SeenSignatureRepository satisfies this interface, which is how
+// replicas share a store.
+type ReplayStore interface {
+	// Remember stores signature until expiresAt and reports false if it was
+	// already stored.
+	Remember(ctx context.Context, signature string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryReplayStore is a process-local ReplayStore for single-replica deployments.
+type MemoryReplayStore struct {
+	now           func() time.Time
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryReplayStore builds a MemoryReplayStore. Expired signatures are swept
+// lazily, at most once per minute, so memory stays bounded by the request rate
+// over the skew window.
+func NewMemoryReplayStore(now func() time.Time) *MemoryReplayStore {
+	if now == nil {
+		now = func() time.Time { return time.Now().UTC() }
+	}
+	return &MemoryReplayStore{now: now, sweepInterval: defaultReplaySweepInterval, seen: map[string]time.Time{}}
+}
+
+func (s *MemoryReplayStore) Remember(_ context.Context, signature string, expiresAt time.Time) (bool, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !now.Before(s.nextSweep) {
+		for sig, exp := range s.seen {
+			if !exp.After(now) {
+				delete(s.seen, sig)
+			}
+		}
+		s.nextSweep = now.Add(s.sweepInterval)
+	}
+	if exp, ok := s.seen[signature]; ok && exp.After(now) {
+		return false, nil
+	}
+	s.seen[signature] = expiresAt
+	return true, nil
+}
+
+// Len reports the number of remembered signatures, including ones not yet swept.
+func (s *MemoryReplayStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.seen)
+}
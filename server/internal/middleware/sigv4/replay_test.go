@@ -0,0 +1,106 @@
+package sigv4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type failingReplayStore struct{}
+
+func (failingReplayStore) Remember(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("db down")
+}
+
+func serveSignedRequest(mw func(http.Handler) http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_ReplayedSignatureRejected(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, ReplayStore: NewMemoryReplayStore(func() time.Time { return now })})
+
+	body := []byte(`{"prompt":"cat"}`)
+	first := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now)
+	replay := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now)
+
+	if rec := serveSignedRequest(mw, first); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	assertErrorCode(t, serveSignedRequest(mw, replay), http.StatusUnauthorized, "INVALID_SIGNATURE")
+
+	next := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", body, "ak_test", "sk_test_secret", now.Add(time.Second))
+	if rec := serveSignedRequest(mw, next); rec.Code != http.StatusOK {
+		t.Fatalf("expected a freshly signed request to pass, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMiddleware_ReplayExemptRequestsSkipStore(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	store := NewMemoryReplayStore(func() time.Time { return now })
+	mw := New(repo, Config{
+		Now:          func() time.Time { return now },
+		SecretCipher: c,
+		ReplayStore:  store,
+		ReplayExempt: func(r *http.Request) bool { return r.URL.Path == "/v1/get-result" },
+	})
+
+	body := []byte(`{"task_id":"t1"}`)
+	for i := 0; i < 2; i++ {
+		req := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/get-result", body, "ak_test", "sk_test_secret", now)
+		if rec := serveSignedRequest(mw, req); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status %d body=%s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if store.Len() != 0 {
+		t.Fatalf("expected exempt requests not to be recorded, got %d", store.Len())
+	}
+}
+
+func TestMiddleware_ReplayStoreErrorFailsClosed(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	c := mustTestCipher(t)
+	repo := &stubRepo{key: activeKey(t, c, "key_1", "ak_test", "sk_test_secret")}
+	mw := New(repo, Config{Now: func() time.Time { return now }, SecretCipher: c, ReplayStore: failingReplayStore{}})
+
+	req := newSignedRequest(t, http.MethodPost, "http://relay.local/v1/submit", []byte(`{"prompt":"cat"}`), "ak_test", "sk_test_secret", now)
+	assertErrorCode(t, serveSignedRequest(mw, req), http.StatusUnauthorized, "DATABASE_ERROR")
+}
+
+func TestMemoryReplayStore_ForgetsExpiredSignatures(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	store := NewMemoryReplayStore(func() time.Time { return now })
+	ctx := context.Background()
+
+	if fresh, _ := store.Remember(ctx, "sig-a", now.Add(5*time.Minute)); !fresh {
+		t.Fatalf("expected sig-a to be fresh")
+	}
+	if fresh, _ := store.Remember(ctx, "sig-a", now.Add(5*time.Minute)); fresh {
+		t.Fatalf("expected sig-a to be a replay")
+	}
+	if fresh, _ := store.Remember(ctx, "sig-b", now.Add(30*time.Second)); !fresh {
+		t.Fatalf("expected sig-b to be fresh")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if fresh, _ := store.Remember(ctx, "sig-c", now.Add(5*time.Minute)); !fresh {
+		t.Fatalf("expected sig-c to be fresh")
+	}
+	if store.Len() != 2 {
+		t.Fatalf("expected expired sig-b to be swept, got %d entries", store.Len())
+	}
+	if fresh, _ := store.Remember(ctx, "sig-a", now.Add(5*time.Minute)); fresh {
+		t.Fatalf("expected sig-a to still be a replay inside its window")
+	}
+}
@@ -14,17 +14,41 @@ const (
 )
 
 type APIKey struct {
-	ID                  string       `json:"id"`
-	AccessKey           string       `json:"access_key"`
-	SecretKeyHash       string       `json:"secret_key_hash"`
-	SecretKeyCiphertext string       `json:"-"`
-	Description         string       `json:"description,omitempty"`
-	CreatedAt           time.Time    `json:"created_at"`
-	UpdatedAt           time.Time    `json:"updated_at"`
-	ExpiresAt           *time.Time   `json:"expires_at,omitempty"`
-	RevokedAt           *time.Time   `json:"revoked_at,omitempty"`
-	RotationOf          *string      `json:"rotation_of,omitempty"`
-	Status              APIKeyStatus `json:"status"`
+	ID                  string     `json:"id"`
+	AccessKey           string     `json:"access_key"`
+	SecretKeyHash       string     `json:"secret_key_hash"`
+	SecretKeyCiphertext string     `json:"-"`
+	Description         string     `json:"description,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	RevokedAt           *time.Time `json:"revoked_at,omitempty"`
+	RotationOf          *string    `json:"rotation_of,omitempty"`
+	// ClientCertSubject, when set, must match the subject of the verified TLS
+	// client certificate presented with requests signed by this key.
+	ClientCertSubject string       `json:"client_cert_subject,omitempty"`
+	Status            APIKeyStatus `json:"status"`
+	// Owner names who is responsible for the key, e.g. a person or a team
+	// alias.
+	Owner string `json:"owner,omitempty"`
+	// Labels are key/value tags such as team=growth or environment=prod that
+	// keys can be selected and reports grouped by.
+	Labels map[string]string `json:"labels,omitempty"`
+	// LastUsedAt and LastUsedIP describe the latest request that passed
+	// signature verification. They are written in batches, so they can lag
+	// behind by one flush interval.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	// RequestCount counts every verified request since the key was created.
+	RequestCount int64 `json:"request_count"`
+}
+
+// APIKeyMetadata is the part of an API key that operators can edit after it
+// is created.
+type APIKeyMetadata struct {
+	Description string
+	Owner       string
+	Labels      map[string]string
 }
 
 func (k APIKey) IsActive() bool {
@@ -72,5 +96,8 @@ func (k APIKey) Validate() error {
 	default:
 		return fmt.Errorf("invalid status: %q", k.Status)
 	}
-	return nil
+	if len(k.Owner) > MaxAPIKeyOwnerLen {
+		return fmt.Errorf("owner must be at most %d characters", MaxAPIKeyOwnerLen)
+	}
+	return ValidateLabels(k.Labels)
 }
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// APIKeyActivity sums the verified requests made with one API key on one UTC
+// day since the previous flush.
+type APIKeyActivity struct {
+	APIKeyID string
+	// Day is midnight UTC of the day the requests were made.
+	Day      time.Time
+	Requests int64
+	// LastUsedAt and LastUsedIP describe the latest of the requests.
+	LastUsedAt time.Time
+	LastUsedIP string
+}
+
+// APIKeyDailyRequests is the number of verified requests one API key made on
+// one UTC day.
+type APIKeyDailyRequests struct {
+	APIKeyID string
+	// Day is midnight UTC.
+	Day      time.Time
+	Requests int64
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Well-known label names. Any other valid name can be used as a free-form
+// tag.
+const (
+	LabelTeam        = "team"
+	LabelEnvironment = "environment"
+)
+
+const (
+	MaxAPIKeyLabels   = 32
+	MaxAPIKeyOwnerLen = 128
+	maxLabelLen       = 63
+)
+
+var (
+	labelNamePattern  = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+)
+
+// ValidateLabelName accepts lowercase names of up to 63 characters made of
+// letters, digits, '.', '_', '-' and '/', starting and ending with a letter
+// or digit.
+func ValidateLabelName(name string) error {
+	if len(name) > maxLabelLen || !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	return nil
+}
+
+// ValidateLabelValue accepts values of up to 63 characters made of letters,
+// digits, '.', '_' and '-', starting and ending with a letter or digit.
+func ValidateLabelValue(value string) error {
+	if len(value) > maxLabelLen || !labelValuePattern.MatchString(value) {
+		return fmt.Errorf("invalid label value %q", value)
+	}
+	return nil
+}
+
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxAPIKeyLabels {
+		return fmt.Errorf("at most %d labels are allowed, got %d", MaxAPIKeyLabels, len(labels))
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	// Sorted so the same labels always report the same error.
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ValidateLabelName(name); err != nil {
+			return err
+		}
+		if err := ValidateLabelValue(labels[name]); err != nil {
+			return fmt.Errorf("label %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ParseLabel parses name=value.
+func ParseLabel(s string) (string, string, error) {
+	name, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid label %q: expected name=value", s)
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if err := ValidateLabelName(name); err != nil {
+		return "", "", err
+	}
+	if err := ValidateLabelValue(value); err != nil {
+		return "", "", fmt.Errorf("label %s: %w", name, err)
+	}
+	return name, value, nil
+}
+
+type selectorOp int
+
+const (
+	selectorEquals selectorOp = iota
+	selectorNotEquals
+	selectorExists
+	selectorNotExists
+)
+
+type labelRequirement struct {
+	name  string
+	op    selectorOp
+	value string
+}
+
+// LabelSelector matches API keys by their labels. The zero value matches
+// every key.
+type LabelSelector struct {
+	source       string
+	requirements []labelRequirement
+}
+
+// ParseLabelSelector parses comma-separated requirements that must all hold:
+// name=value (name==value is accepted too), name!=value (also true when the
+// label is missing), name (the label is set) and !name (it is not).
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		req, err := parseRequirement(part)
+		if err != nil {
+			return LabelSelector{}, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		sel.requirements = append(sel.requirements, req)
+	}
+	sel.source = strings.TrimSpace(s)
+	return sel, nil
+}
+
+// String returns the selector as it was parsed.
+func (s LabelSelector) String() string {
+	return s.source
+}
+
+func parseRequirement(part string) (labelRequirement, error) {
+	if part == "" {
+		return labelRequirement{}, fmt.Errorf("empty requirement")
+	}
+	var req labelRequirement
+	switch {
+	case strings.Contains(part, "!="):
+		name, value, _ := strings.Cut(part, "!=")
+		req = labelRequirement{name: name, op: selectorNotEquals, value: value}
+	case strings.Contains(part, "="):
+		name, value, _ := strings.Cut(part, "=")
+		req = labelRequirement{name: name, op: selectorEquals, value: strings.TrimPrefix(value, "=")}
+	case strings.HasPrefix(part, "!"):
+		req = labelRequirement{name: part[1:], op: selectorNotExists}
+	default:
+		req = labelRequirement{name: part, op: selectorExists}
+	}
+	req.name, req.value = strings.TrimSpace(req.name), strings.TrimSpace(req.value)
+	if err := ValidateLabelName(req.name); err != nil {
+		return labelRequirement{}, err
+	}
+	if req.op == selectorEquals || req.op == selectorNotEquals {
+		if err := ValidateLabelValue(req.value); err != nil {
+			return labelRequirement{}, err
+		}
+	}
+	return req, nil
+}
+
+// Empty reports whether the selector matches every key.
+func (s LabelSelector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		value, ok := labels[req.name]
+		switch req.op {
+		case selectorEquals:
+			if !ok || value != req.value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && value == req.value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
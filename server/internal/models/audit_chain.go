@@ -0,0 +1,127 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AuditChainTimePrecision is the precision created_at is stored and hashed
+// at. Postgres keeps microseconds, so both backends truncate to that.
+const AuditChainTimePrecision = time.Microsecond
+
+// canonicalAuditEvent fixes the field order hashed for an event.
+type canonicalAuditEvent struct {
+	Sequence  int64           `json:"sequence"`
+	ID        string          `json:"id"`
+	RequestID string          `json:"request_id"`
+	EventType EventType       `json:"event_type"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Resource  string          `json:"resource"`
+	Metadata  json.RawMessage `json:"metadata"`
+	CreatedAt string          `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"`
+}
+
+// ChainHash returns the hex SHA-256 of the event's canonical content,
+// including Sequence and PrevHash. Metadata is normalised through a JSON
+// round trip so the hash does not depend on how a backend decodes numbers.
+func (e AuditEvent) ChainHash() (string, error) {
+	meta, err := canonicalJSON(e.Metadata)
+	if err != nil {
+		return "", fmt.Errorf("canonical audit metadata: %w", err)
+	}
+	b, err := json.Marshal(canonicalAuditEvent{
+		Sequence:  e.Sequence,
+		ID:        e.ID,
+		RequestID: e.RequestID,
+		EventType: e.EventType,
+		Actor:     e.Actor,
+		Action:    e.Action,
+		Resource:  e.Resource,
+		Metadata:  meta,
+		CreatedAt: e.CreatedAt.UTC().Truncate(AuditChainTimePrecision).Format(time.RFC3339Nano),
+		PrevHash:  e.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Chain assigns e's position after prev (the zero value for the first event)
+// and computes its hash.
+func (e *AuditEvent) Chain(prevSequence int64, prevHash string) error {
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(AuditChainTimePrecision)
+	e.Sequence = prevSequence + 1
+	e.PrevHash = prevHash
+	h, err := e.ChainHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = h
+	return nil
+}
+
+func canonicalJSON(v map[string]any) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var normalized any
+	if err := dec.Decode(&normalized); err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalized)
+}
+
+// AuditCheckpoint records the chain head at a point in time, signed with a key
+// that is not stored in the database. A checkpoint pins every event up to
+// Sequence: later edits or deletions no longer match it.
+type AuditCheckpoint struct {
+	ID        string    `json:"id"`
+	Sequence  int64     `json:"sequence"`
+	Hash      string    `json:"hash"`
+	MAC       string    `json:"mac"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c AuditCheckpoint) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if c.Sequence <= 0 {
+		return fmt.Errorf("sequence must be > 0")
+	}
+	if c.Hash == "" {
+		return fmt.Errorf("hash is required")
+	}
+	if c.MAC == "" {
+		return fmt.Errorf("mac is required")
+	}
+	if c.CreatedAt.IsZero() {
+		return fmt.Errorf("created_at is required")
+	}
+	return nil
+}
+
+// ComputeMAC returns the hex HMAC-SHA256 of the checkpoint's sequence, hash
+// and creation time under key.
+func (c AuditCheckpoint) ComputeMAC(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(c.Sequence, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(c.Hash))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(c.CreatedAt.UTC().Truncate(AuditChainTimePrecision).Format(time.RFC3339Nano)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
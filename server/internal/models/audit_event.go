@@ -15,6 +15,8 @@ const (
 	EventTypeUpstreamResponse EventType = "upstream_response"
 	EventTypeResponseSent     EventType = "response_sent"
 	EventTypeError            EventType = "error"
+	EventTypePolicyAllowed    EventType = "policy_allowed"
+	EventTypePolicyDenied     EventType = "policy_denied"
 )
 
 type AuditEvent struct {
@@ -26,6 +28,13 @@ type AuditEvent struct {
 	Resource  string         `json:"resource"`
 	Metadata  map[string]any `json:"metadata,omitempty"`
 	CreatedAt time.Time      `json:"created_at"`
+
+	// Sequence, PrevHash and Hash link events into a tamper-evident chain.
+	// They are assigned by the repository on insert; events recorded before
+	// chaining was introduced have Sequence 0.
+	Sequence int64  `json:"sequence,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
 func (e AuditEvent) Validate() error {
@@ -42,7 +51,9 @@ func (e AuditEvent) Validate() error {
 		EventTypeUpstreamCall,
 		EventTypeUpstreamResponse,
 		EventTypeResponseSent,
-		EventTypeError:
+		EventTypeError,
+		EventTypePolicyAllowed,
+		EventTypePolicyDenied:
 	default:
 		return fmt.Errorf("invalid event_type: %q", e.EventType)
 	}
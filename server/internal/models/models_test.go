@@ -79,6 +79,54 @@ func TestAPIKeyValidate(t *testing.T) {
 	if err := invalid.Validate(); err == nil {
 		t.Fatalf("expected error for invalid status")
 	}
+
+	labeled := valid
+	labeled.Owner = "alice@example.com"
+	labeled.Labels = map[string]string{LabelTeam: "growth", "cost-center": "cc.1042"}
+	if err := labeled.Validate(); err != nil {
+		t.Fatalf("expected labels to be valid, got %v", err)
+	}
+	for _, labels := range []map[string]string{{"Team": "growth"}, {"team": ""}, {"team": "growth team"}, {"-team": "growth"}} {
+		invalid = valid
+		invalid.Labels = labels
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("expected error for labels %v", labels)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{LabelTeam: "growth", LabelEnvironment: "prod"}
+	cases := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"team=growth", true},
+		{"team==growth, environment=prod", true},
+		{"team=growth,environment=staging", false},
+		{"team!=search", true},
+		{"owner!=search", true},
+		{"team!=growth", false},
+		{"environment", true},
+		{"cost-center", false},
+		{"!cost-center", true},
+		{"!team", false},
+	}
+	for _, tc := range cases {
+		sel, err := ParseLabelSelector(tc.selector)
+		if err != nil {
+			t.Fatalf("ParseLabelSelector(%q): %v", tc.selector, err)
+		}
+		if got := sel.Matches(labels); got != tc.want {
+			t.Fatalf("%q matches = %v, want %v", tc.selector, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"team=", "team=growth,", "=growth", "Team=growth", "team=a b"} {
+		if _, err := ParseLabelSelector(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
 }
 
 func TestDownstreamRequestValidate(t *testing.T) {
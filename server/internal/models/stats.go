@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// StatusClassNoResponse is the status class of upstream attempts that never
+// got an HTTP response, such as timeouts and connection errors.
+const StatusClassNoResponse = "no_response"
+
+// StatusClass buckets an upstream response status as "2xx", "4xx" and so on.
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return StatusClassNoResponse
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// StatsRollup aggregates the upstream attempts sent in one UTC hour for one
+// API key, downstream action and status class.
+type StatsRollup struct {
+	Hour        time.Time        `json:"hour"`
+	APIKeyID    string           `json:"api_key_id"`
+	Action      DownstreamAction `json:"action"`
+	StatusClass string           `json:"status_class"`
+	Attempts    int64            `json:"attempts"`
+	// Retries counts attempts after the first one of their request.
+	Retries int64 `json:"retries"`
+	// Errors counts attempts that recorded an error, whatever their status.
+	Errors       int64 `json:"errors"`
+	LatencySumMs int64 `json:"latency_sum_ms"`
+	LatencyP50Ms int64 `json:"latency_p50_ms"`
+	LatencyP95Ms int64 `json:"latency_p95_ms"`
+	LatencyMaxMs int64 `json:"latency_max_ms"`
+}
+
+// RollupSample is one upstream attempt with the API key and action of its
+// downstream request, as read by the rollup job.
+type RollupSample struct {
+	APIKeyID       string
+	Action         DownstreamAction
+	AttemptNumber  int
+	ResponseStatus int
+	LatencyMs      int64
+	Failed         bool
+	SentAt         time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+// SubmitUsage counts successful submits for one API key, req_key and UTC day.
+// Frames, Width and Height are taken from the submit body and are 0 when the
+// body did not set them; submits that differ only in those fields are
+// counted separately so each can be priced.
+type SubmitUsage struct {
+	APIKeyID string `json:"api_key_id"`
+	ReqKey   string `json:"req_key"`
+	// Day is the UTC date the submit was received, as YYYY-MM-DD.
+	Day    string `json:"day"`
+	Frames int    `json:"frames,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Count  int64  `json:"count"`
+}
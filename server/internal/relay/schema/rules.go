@@ -0,0 +1,286 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxPromptChars = 2000
+
+	minImageSide   = 256
+	maxImageSide   = 4096
+	minScale       = 0.0
+	maxScale       = 2.0
+	maxImageInputs = 10
+
+	// Per-image and combined decoded limits for inline video inputs.
+	maxVideoInlineImageBytes = 5 << 20
+	maxVideoInlineTotalBytes = 2 * maxVideoInlineImageBytes
+)
+
+var videoFrames = map[int]bool{121: true, 241: true}
+
+var videoAspectRatios = map[string]bool{
+	"16:9": true,
+	"4:3":  true,
+	"1:1":  true,
+	"3:4":  true,
+	"9:16": true,
+	"21:9": true,
+}
+
+var cameraStrengths = map[string]bool{"weak": true, "medium": true, "strong": true}
+
+// submitBody holds the fields the schemas look at. Pointers distinguish an
+// absent field from a zero value; unknown fields are ignored and relayed.
+type submitBody struct {
+	ReqKey           string   `json:"req_key"`
+	Prompt           *string  `json:"prompt"`
+	ImageURLs        []string `json:"image_urls"`
+	BinaryDataBase64 []string `json:"binary_data_base64"`
+
+	Width    *int     `json:"width"`
+	Height   *int     `json:"height"`
+	Scale    *float64 `json:"scale"`
+	MinRatio *float64 `json:"min_ratio"`
+	MaxRatio *float64 `json:"max_ratio"`
+
+	Frames         *int    `json:"frames"`
+	AspectRatio    *string `json:"aspect_ratio"`
+	TemplateID     *string `json:"template_id"`
+	CameraStrength *string `json:"camera_strength"`
+}
+
+func (b submitBody) imageCount() int {
+	return len(nonEmpty(b.ImageURLs)) + len(nonEmpty(b.BinaryDataBase64))
+}
+
+func checkT2I(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	if b.Width != nil && (*b.Width < minImageSide || *b.Width > maxImageSide) {
+		c.add("width", "must be in range [%d, %d]", minImageSide, maxImageSide)
+	}
+	if b.Height != nil && (*b.Height < minImageSide || *b.Height > maxImageSide) {
+		c.add("height", "must be in range [%d, %d]", minImageSide, maxImageSide)
+	}
+	if b.Scale != nil && (*b.Scale < minScale || *b.Scale > maxScale) {
+		c.add("scale", "must be in range [%.1f, %.1f]", minScale, maxScale)
+	}
+	if b.MinRatio != nil && b.MaxRatio != nil && *b.MinRatio > *b.MaxRatio {
+		c.add("min_ratio", "must not exceed max_ratio")
+	}
+	if len(b.ImageURLs) > maxImageInputs {
+		c.add("image_urls", "must contain at most %d images", maxImageInputs)
+	}
+	if len(b.BinaryDataBase64) > maxImageInputs {
+		c.add("binary_data_base64", "must contain at most %d images", maxImageInputs)
+	}
+	if len(b.ImageURLs) > 0 && len(b.BinaryDataBase64) > 0 {
+		c.add("image_urls", "image_urls and binary_data_base64 cannot be used together")
+	}
+	for i, raw := range nonEmpty(b.BinaryDataBase64) {
+		if !isStrictStdBase64(stripBase64Whitespace(raw)) {
+			c.add(fmt.Sprintf("binary_data_base64[%d]", i), "must be standard base64")
+		}
+	}
+}
+
+func checkT2V(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	checkT2VParams(c, b)
+	if b.imageCount() > 0 {
+		c.add("image_urls", "images are not allowed for text-to-video")
+	}
+	rejectTemplate(c, b, "text-to-video")
+}
+
+// checkTI2VPro covers the pro req_key, which serves both text-to-video and
+// first-frame image-to-video depending on whether an image is supplied.
+func checkTI2VPro(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	switch n := b.imageCount(); n {
+	case 0:
+		checkT2VParams(c, b)
+	case 1:
+		checkVideoImages(c, b)
+		rejectFramesAndAspect(c, b, "image-to-video")
+	default:
+		c.add("image_urls", "at most 1 image is allowed, got %d", n)
+	}
+	rejectTemplate(c, b, "the pro model")
+}
+
+func checkI2VFirst(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	checkImageCount(c, b, 1, "first-frame image-to-video")
+	checkVideoImages(c, b)
+	rejectTemplate(c, b, "first-frame image-to-video")
+	rejectFramesAndAspect(c, b, "first-frame image-to-video")
+}
+
+func checkI2VFirstTail(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	checkImageCount(c, b, 2, "first-tail image-to-video")
+	if total := checkVideoImages(c, b); total >= maxVideoInlineTotalBytes {
+		c.add("image_urls", "combined inline image payload must be under %d bytes after decode; upload the images to URLs instead", maxVideoInlineTotalBytes)
+	}
+	rejectTemplate(c, b, "first-tail image-to-video")
+	rejectFramesAndAspect(c, b, "first-tail image-to-video")
+}
+
+func checkRecamera(c *checker, b submitBody) {
+	checkPrompt(c, b)
+	checkImageCount(c, b, 1, "recamera")
+	checkVideoImages(c, b)
+	if b.TemplateID == nil || strings.TrimSpace(*b.TemplateID) == "" {
+		c.add("template_id", "is required for recamera")
+	}
+	if b.CameraStrength != nil && !cameraStrengths[strings.TrimSpace(*b.CameraStrength)] {
+		c.add("camera_strength", "must be one of weak, medium, strong")
+	}
+	rejectFramesAndAspect(c, b, "recamera")
+}
+
+func checkPrompt(c *checker, b submitBody) {
+	if b.Prompt == nil || strings.TrimSpace(*b.Prompt) == "" {
+		c.add("prompt", "is required")
+		return
+	}
+	if n := utf8.RuneCountInString(*b.Prompt); n > maxPromptChars {
+		c.add("prompt", "must be at most %d characters, got %d", maxPromptChars, n)
+	}
+}
+
+func checkT2VParams(c *checker, b submitBody) {
+	if b.Frames != nil && !videoFrames[*b.Frames] {
+		c.add("frames", "must be 121 or 241")
+	}
+	if b.AspectRatio != nil && !videoAspectRatios[strings.TrimSpace(*b.AspectRatio)] {
+		c.add("aspect_ratio", "must be one of 16:9, 4:3, 1:1, 3:4, 9:16, 21:9")
+	}
+}
+
+func checkImageCount(c *checker, b submitBody, want int, variant string) {
+	if n := b.imageCount(); n != want {
+		c.add("image_urls", "%s requires exactly %d image(s), got %d", variant, want, n)
+	}
+}
+
+// checkVideoImages validates inline image payloads (data URLs in image_urls and
+// raw binary_data_base64 entries) and returns their combined decoded size.
+func checkVideoImages(c *checker, b submitBody) int {
+	total := 0
+	for i, raw := range nonEmpty(b.ImageURLs) {
+		trimmed := strings.TrimSpace(raw)
+		if !strings.HasPrefix(strings.ToLower(trimmed), "data:image/") {
+			continue
+		}
+		field := fmt.Sprintf("image_urls[%d]", i)
+		comma := strings.Index(trimmed, ",")
+		if comma < 0 || !strings.Contains(strings.ToLower(trimmed[:comma]), ";base64") {
+			c.add(field, "inline images must be data:image/...;base64,...")
+			continue
+		}
+		total += checkInlinePayload(c, field, trimmed[comma+1:])
+	}
+	for i, raw := range nonEmpty(b.BinaryDataBase64) {
+		total += checkInlinePayload(c, fmt.Sprintf("binary_data_base64[%d]", i), raw)
+	}
+	return total
+}
+
+func checkInlinePayload(c *checker, field, raw string) int {
+	payload := stripBase64Whitespace(raw)
+	if !isStrictStdBase64(payload) {
+		c.add(field, "must be non-empty standard base64")
+		return 0
+	}
+	size := estimatedBase64DecodedLength(payload)
+	if size > maxVideoInlineImageBytes {
+		c.add(field, "image is %d bytes after decode, max %d; upload it to a URL or compress it", size, maxVideoInlineImageBytes)
+	}
+	return size
+}
+
+func rejectTemplate(c *checker, b submitBody, variant string) {
+	if b.TemplateID != nil && strings.TrimSpace(*b.TemplateID) != "" {
+		c.add("template_id", "is not allowed for %s", variant)
+	}
+	if b.CameraStrength != nil && strings.TrimSpace(*b.CameraStrength) != "" {
+		c.add("camera_strength", "is not allowed for %s", variant)
+	}
+}
+
+func rejectFramesAndAspect(c *checker, b submitBody, variant string) {
+	if b.Frames != nil && *b.Frames != 0 {
+		c.add("frames", "is not allowed for %s", variant)
+	}
+	if b.AspectRatio != nil && strings.TrimSpace(*b.AspectRatio) != "" {
+		c.add("aspect_ratio", "is not allowed for %s", variant)
+	}
+}
+
+func nonEmpty(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func stripBase64Whitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\n', '\r', '\t':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func isStrictStdBase64(s string) bool {
+	if s == "" {
+		return false
+	}
+	seenPad := false
+	padding := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '=':
+			seenPad = true
+			padding++
+			if padding > 2 {
+				return false
+			}
+		case seenPad:
+			return false
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9', ch == '+', ch == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func estimatedBase64DecodedLength(payload string) int {
+	n := len(payload)
+	if n == 0 {
+		return 0
+	}
+	padding := 0
+	if payload[n-1] == '=' {
+		padding++
+		if n > 1 && payload[n-2] == '=' {
+			padding++
+		}
+	}
+	if decoded := n*3/4 - padding; decoded > 0 {
+		return decoded
+	}
+	return 0
+}
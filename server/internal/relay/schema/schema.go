@@ -0,0 +1,163 @@
+// Package schema validates submit bodies against the rules of their req_key
+// before they are relayed, so malformed requests fail fast without spending an
+// upstream round trip or a queue slot.
+//
+// The rules mirror the client's ValidateSubmitRequest/ValidateVideoSubmitRequest
+// and preset capabilities (client/internal/api/matrix.go). They are kept no
+// stricter than the client: anything the CLI sends must pass here.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+const (
+	ReqKeyJimengT2IV40 = "jimeng_t2i_v40"
+
+	ReqKeyJimengT2VV30               = "jimeng_t2v_v30"
+	ReqKeyJimengT2VV30_1080p         = "jimeng_t2v_v30_1080p"
+	ReqKeyJimengTI2VV30Pro           = "jimeng_ti2v_v30_pro"
+	ReqKeyJimengI2VFirstV30          = "jimeng_i2v_first_v30"
+	ReqKeyJimengI2VFirstV30_1080     = "jimeng_i2v_first_v30_1080"
+	ReqKeyJimengI2VFirstTailV30      = "jimeng_i2v_first_tail_v30"
+	ReqKeyJimengI2VFirstTailV30_1080 = "jimeng_i2v_first_tail_v30_1080"
+	ReqKeyJimengI2VRecameraV30       = "jimeng_i2v_recamera_v30"
+)
+
+// Violation is one field that does not satisfy its req_key schema.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a submit body.
+type ValidationError struct {
+	ReqKey     string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		if v.Field == "" {
+			parts = append(parts, v.Message)
+			continue
+		}
+		parts = append(parts, v.Field+": "+v.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Details returns the violations of err, if it wraps a *ValidationError.
+func Details(err error) ([]Violation, bool) {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return nil, false
+	}
+	return verr.Violations, true
+}
+
+type Config struct {
+	// Strict rejects req_keys without a schema. Otherwise they are relayed
+	// unchecked and upstream has the final say.
+	Strict bool
+}
+
+// Validator checks submit bodies against the schema of their req_key.
+type Validator struct {
+	schemas map[string]func(*checker, submitBody)
+	strict  bool
+}
+
+func NewValidator(cfg Config) *Validator {
+	return &Validator{
+		strict: cfg.Strict,
+		schemas: map[string]func(*checker, submitBody){
+			ReqKeyJimengT2IV40:               checkT2I,
+			ReqKeyJimengT2VV30:               checkT2V,
+			ReqKeyJimengT2VV30_1080p:         checkT2V,
+			ReqKeyJimengTI2VV30Pro:           checkTI2VPro,
+			ReqKeyJimengI2VFirstV30:          checkI2VFirst,
+			ReqKeyJimengI2VFirstV30_1080:     checkI2VFirst,
+			ReqKeyJimengI2VFirstTailV30:      checkI2VFirstTail,
+			ReqKeyJimengI2VFirstTailV30_1080: checkI2VFirstTail,
+			ReqKeyJimengI2VRecameraV30:       checkRecamera,
+		},
+	}
+}
+
+// ReqKeys lists the req_keys that have a schema, sorted.
+func (v *Validator) ReqKeys() []string {
+	out := make([]string, 0, len(v.schemas))
+	for k := range v.schemas {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Validate returns a VALIDATION_FAILED error wrapping a *ValidationError when
+// body does not satisfy the schema of its req_key.
+func (v *Validator) Validate(body []byte) error {
+	var b submitBody
+	c := &checker{}
+	if err := json.Unmarshal(body, &b); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return invalid("", []Violation{{Message: "body must be a JSON object"}})
+		}
+		// The decoder keeps filling the remaining fields after a type error,
+		// so report the bad field and carry on with the rest.
+		if typeErr.Field == "" {
+			return invalid("", []Violation{{Message: "body must be a JSON object"}})
+		}
+		c.add(typeErr.Field, "must be of type %s", typeErr.Type.String())
+	}
+
+	reqKey := strings.TrimSpace(b.ReqKey)
+	if reqKey == "" {
+		c.add("req_key", "is required")
+		return invalid("", c.violations)
+	}
+	check, ok := v.schemas[reqKey]
+	if !ok {
+		if v.strict {
+			c.add("req_key", "unsupported req_key %q", reqKey)
+			return invalid(reqKey, c.violations)
+		}
+		return nil
+	}
+	check(c, b)
+	if len(c.violations) > 0 {
+		return invalid(reqKey, c.violations)
+	}
+	return nil
+}
+
+func invalid(reqKey string, violations []Violation) error {
+	verr := &ValidationError{ReqKey: reqKey, Violations: violations}
+	msg := "request does not match its req_key schema"
+	if reqKey != "" {
+		msg = fmt.Sprintf("request does not match the %s schema", reqKey)
+	}
+	return internalerrors.New(internalerrors.ErrValidationFailed, msg, verr)
+}
+
+type checker struct {
+	violations []Violation
+}
+
+func (c *checker) add(field, format string, args ...any) {
+	for _, v := range c.violations {
+		if v.Field == field {
+			return
+		}
+	}
+	c.violations = append(c.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
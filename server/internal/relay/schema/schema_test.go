@@ -0,0 +1,116 @@
+package schema
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+func violationFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	if internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected VALIDATION_FAILED, got %v", err)
+	}
+	violations, ok := Details(err)
+	if !ok {
+		t.Fatalf("expected violation details in %v", err)
+	}
+	fields := make([]string, 0, len(violations))
+	for _, v := range violations {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+func dataURL(decodedBytes int) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(make([]byte, decodedBytes))
+}
+
+func TestValidate_PerReqKey(t *testing.T) {
+	small := dataURL(16)
+	big := dataURL(maxVideoInlineImageBytes + 1)
+	nearLimit := dataURL(maxVideoInlineImageBytes)
+
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{name: "t2i minimal", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat"}`},
+		{name: "t2i full", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat","width":1024,"height":1024,"scale":0.5,"min_ratio":0.5,"max_ratio":2,"image_urls":["https://e.com/a.png"]}`},
+		{name: "t2i missing prompt", body: `{"req_key":"jimeng_t2i_v40"}`, fields: []string{"prompt"}},
+		{name: "t2i prompt too long", body: `{"req_key":"jimeng_t2i_v40","prompt":"` + strings.Repeat("猫", maxPromptChars+1) + `"}`, fields: []string{"prompt"}},
+		{name: "t2i bad size and scale", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat","width":100,"height":5000,"scale":3}`, fields: []string{"width", "height", "scale"}},
+		{name: "t2i ratio order", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat","min_ratio":2,"max_ratio":1}`, fields: []string{"min_ratio"}},
+		{name: "t2i mixed image sources", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat","image_urls":["https://e.com/a.png"],"binary_data_base64":["AAAA"]}`, fields: []string{"image_urls"}},
+		{name: "t2i invalid base64", body: `{"req_key":"jimeng_t2i_v40","prompt":"cat","binary_data_base64":["not base64!"]}`, fields: []string{"binary_data_base64[0]"}},
+
+		{name: "t2v ok", body: `{"req_key":"jimeng_t2v_v30","prompt":"cat","frames":121,"aspect_ratio":"16:9"}`},
+		{name: "t2v 1080p defaults", body: `{"req_key":"jimeng_t2v_v30_1080p","prompt":"cat"}`},
+		{name: "t2v bad frames and ratio", body: `{"req_key":"jimeng_t2v_v30","prompt":"cat","frames":100,"aspect_ratio":"2:1"}`, fields: []string{"frames", "aspect_ratio"}},
+		{name: "t2v rejects images and template", body: `{"req_key":"jimeng_t2v_v30","prompt":"cat","image_urls":["https://e.com/a.png"],"template_id":"hitchcock_dolly_in","camera_strength":"weak"}`, fields: []string{"image_urls", "template_id", "camera_strength"}},
+
+		{name: "pro text", body: `{"req_key":"jimeng_ti2v_v30_pro","prompt":"cat","frames":241,"aspect_ratio":"9:16"}`},
+		{name: "pro image", body: `{"req_key":"jimeng_ti2v_v30_pro","prompt":"cat","image_urls":["` + small + `"]}`},
+		{name: "pro image with frames", body: `{"req_key":"jimeng_ti2v_v30_pro","prompt":"cat","image_urls":["https://e.com/a.png"],"frames":121}`, fields: []string{"frames"}},
+		{name: "pro too many images", body: `{"req_key":"jimeng_ti2v_v30_pro","prompt":"cat","image_urls":["https://e.com/a.png","https://e.com/b.png"]}`, fields: []string{"image_urls"}},
+
+		{name: "i2v first ok", body: `{"req_key":"jimeng_i2v_first_v30_1080","prompt":"cat","binary_data_base64":["AAAA"]}`},
+		{name: "i2v first no image", body: `{"req_key":"jimeng_i2v_first_v30","prompt":"cat"}`, fields: []string{"image_urls"}},
+		{name: "i2v first oversized inline", body: `{"req_key":"jimeng_i2v_first_v30","prompt":"cat","image_urls":["` + big + `"]}`, fields: []string{"image_urls[0]"}},
+		{name: "i2v first malformed data url", body: `{"req_key":"jimeng_i2v_first_v30","prompt":"cat","image_urls":["data:image/png,abc"]}`, fields: []string{"image_urls[0]"}},
+		{name: "i2v first frames", body: `{"req_key":"jimeng_i2v_first_v30","prompt":"cat","image_urls":["https://e.com/a.png"],"aspect_ratio":"16:9"}`, fields: []string{"aspect_ratio"}},
+
+		{name: "first tail ok", body: `{"req_key":"jimeng_i2v_first_tail_v30","prompt":"cat","image_urls":["https://e.com/a.png","` + small + `"]}`},
+		{name: "first tail one image", body: `{"req_key":"jimeng_i2v_first_tail_v30_1080","prompt":"cat","image_urls":["https://e.com/a.png"]}`, fields: []string{"image_urls"}},
+		{name: "first tail aggregate too large", body: `{"req_key":"jimeng_i2v_first_tail_v30","prompt":"cat","image_urls":["` + nearLimit + `","` + nearLimit + `"]}`, fields: []string{"image_urls"}},
+
+		{name: "recamera ok", body: `{"req_key":"jimeng_i2v_recamera_v30","prompt":"cat","image_urls":["https://e.com/a.png"],"template_id":"hitchcock_dolly_in","camera_strength":"medium"}`},
+		{name: "recamera missing template", body: `{"req_key":"jimeng_i2v_recamera_v30","prompt":"cat","image_urls":["https://e.com/a.png"],"camera_strength":"max"}`, fields: []string{"template_id", "camera_strength"}},
+	}
+
+	v := NewValidator(Config{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violationFields(t, v.Validate([]byte(tt.body)))
+			if strings.Join(got, ",") != strings.Join(tt.fields, ",") {
+				t.Fatalf("expected violations on %v, got %v", tt.fields, got)
+			}
+		})
+	}
+}
+
+func TestValidate_ReportsEveryViolationAndTypeErrors(t *testing.T) {
+	v := NewValidator(Config{})
+	err := v.Validate([]byte(`{"req_key":"jimeng_t2v_v30","prompt":"cat","frames":"121","aspect_ratio":"2:1"}`))
+	got := violationFields(t, err)
+	if strings.Join(got, ",") != "frames,aspect_ratio" {
+		t.Fatalf("expected frames type error and aspect_ratio violation, got %v (%v)", got, err)
+	}
+	if !strings.Contains(err.Error(), "jimeng_t2v_v30") {
+		t.Fatalf("expected error message to name the req_key, got %v", err)
+	}
+
+	if got := violationFields(t, v.Validate([]byte(`[1,2]`))); len(got) != 1 || got[0] != "" {
+		t.Fatalf("expected a body-level violation for non-object JSON, got %v", got)
+	}
+	if got := violationFields(t, v.Validate([]byte(`{"prompt":"cat"}`))); len(got) != 1 || got[0] != "req_key" {
+		t.Fatalf("expected missing req_key violation, got %v", got)
+	}
+}
+
+func TestValidate_UnknownReqKey(t *testing.T) {
+	body := []byte(`{"req_key":"jimeng_future_model","prompt":""}`)
+	if err := NewValidator(Config{}).Validate(body); err != nil {
+		t.Fatalf("expected unknown req_key to pass through, got %v", err)
+	}
+	got := violationFields(t, NewValidator(Config{Strict: true}).Validate(body))
+	if len(got) != 1 || got[0] != "req_key" {
+		t.Fatalf("expected strict mode to reject unknown req_key, got %v", got)
+	}
+}
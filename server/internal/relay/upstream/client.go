@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"sort"
@@ -51,6 +52,7 @@ type Options struct {
 	MaxQueue          int
 	SubmitMinInterval time.Duration
 	KeyManager        *keymanager.Service
+	Coordinator       Coordinator
 }
 
 type queueWaiter struct {
@@ -69,17 +71,18 @@ type Client struct {
 	maxRetry int
 	hc       *http.Client
 
-	mu       sync.Mutex
-	sem      chan struct{}
-	waiters  []*queueWaiter
-	maxQueue int
+	mu            sync.Mutex
+	active        int
+	waiters       []*queueWaiter
+	maxQueue      int
+	maxConcurrent int
 
-	km *keymanager.Service
+	km    *keymanager.Service
+	coord Coordinator
 
+	submitMu          sync.Mutex
 	submitMinInterval time.Duration
-
-	submitMu     sync.Mutex
-	lastSubmitAt time.Time
+	lastSubmitAt      time.Time
 }
 
 type Response struct {
@@ -188,10 +191,11 @@ func NewClient(cfg config.Config, opts Options) (*Client, error) {
 		sleep:             sleepFn,
 		maxRetry:          maxRetry,
 		hc:                hc,
-		sem:               make(chan struct{}, maxConcurrent),
 		waiters:           make([]*queueWaiter, 0, maxQueue),
 		maxQueue:          maxQueue,
+		maxConcurrent:     maxConcurrent,
 		km:                opts.KeyManager,
+		coord:             opts.Coordinator,
 		submitMinInterval: submitMinInterval,
 	}, nil
 }
@@ -220,21 +224,36 @@ func (c *Client) do(ctx context.Context, action string, body []byte, headers htt
 	}
 
 	useRelayActionsGate := action == actionSubmit || action == actionGetResult
+	apiKeyID := strings.TrimSpace(GetAPIKeyID(ctx))
 	if useRelayActionsGate && c.km != nil {
-		apiKeyID := strings.TrimSpace(GetAPIKeyID(ctx))
 		keyHandle, err := c.km.AcquireKey(ctx, apiKeyID, "")
 		if err != nil {
 			return nil, err
 		}
 		defer keyHandle.Release()
 	}
-
 	if useRelayActionsGate {
 		if err := c.acquire(ctx); err != nil {
 			return nil, err
 		}
 		defer c.release()
 	}
+	// Cluster slots are taken only past the local gate so queued requests do
+	// not hold coordination connections, and always key first, then global.
+	if useRelayActionsGate && c.coord != nil && apiKeyID != "" {
+		releaseKey, err := c.coord.AcquireKeySlot(ctx, apiKeyID)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseKey()
+	}
+	if useRelayActionsGate && c.coord != nil {
+		releaseSlot, err := c.coord.AcquireGlobalSlot(ctx, c.concurrencyLimit())
+		if err != nil {
+			return nil, err
+		}
+		defer releaseSlot()
+	}
 
 	if action == actionSubmit {
 		if err := c.waitSubmitInterval(ctx); err != nil {
@@ -267,17 +286,12 @@ func (c *Client) do(ctx context.Context, action string, body []byte, headers htt
 }
 
 func (c *Client) acquire(ctx context.Context) error {
-	if c.sem == nil {
-		return nil
-	}
-
 	c.mu.Lock()
 
-	select {
-	case c.sem <- struct{}{}:
+	if c.active < c.maxConcurrent {
+		c.active++
 		c.mu.Unlock()
 		return nil
-	default:
 	}
 
 	if len(c.waiters) >= c.maxQueue {
@@ -313,42 +327,116 @@ func (c *Client) removeWaiter(w *queueWaiter) bool {
 	return false
 }
 
+// reassignCancelledWaiterSlot gives back a slot that was handed to a waiter
+// after its context was already cancelled.
 func (c *Client) reassignCancelledWaiterSlot() {
+	c.release()
+}
+
+// QueueStats is a snapshot of the in-process upstream gate.
+type QueueStats struct {
+	Active        int  `json:"active"`
+	MaxConcurrent int  `json:"max_concurrent"`
+	Queued        int  `json:"queued"`
+	MaxQueue      int  `json:"max_queue"`
+	Saturated     bool `json:"saturated"`
+}
+
+// QueueStats reports how many relay calls hold an upstream slot and how many
+// wait for one. Saturated means the next call would be rejected as queue full.
+func (c *Client) QueueStats() QueueStats {
 	c.mu.Lock()
-	if len(c.waiters) > 0 {
-		w := c.waiters[0]
-		c.waiters = c.waiters[1:]
-		close(w.ready)
-		c.mu.Unlock()
-		return
+	defer c.mu.Unlock()
+	st := QueueStats{Active: c.active, MaxConcurrent: c.maxConcurrent, Queued: len(c.waiters), MaxQueue: c.maxQueue}
+	st.Saturated = st.Active >= st.MaxConcurrent && st.Queued >= st.MaxQueue
+	return st
+}
+
+// Probe opens and closes a TCP connection to the upstream host. It checks
+// reachability only; no request is sent and no quota is spent.
+func (c *Client) Probe(ctx context.Context) error {
+	if c == nil || c.baseURL == nil {
+		return internalerrors.New(internalerrors.ErrInternalError, "upstream client is not initialized", nil)
+	}
+	addr := c.baseURL.Host
+	if c.baseURL.Port() == "" {
+		port := "443"
+		if c.baseURL.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(c.baseURL.Hostname(), port)
 	}
-	select {
-	case <-c.sem:
-	default:
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrUpstreamFailed, "dial upstream "+addr, err)
 	}
-	c.mu.Unlock()
+	return conn.Close()
 }
 
+// release hands the slot to the next waiter, unless the limit was lowered
+// and more slots are held than the new limit allows.
 func (c *Client) release() {
-	if c.sem == nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) > 0 && c.active <= c.maxConcurrent {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		close(w.ready)
 		return
 	}
+	c.active--
+}
+
+// SetLimits changes the global concurrency, queue size and submit interval of
+// a running client. Values <= 0 for maxConcurrent or maxQueue keep the current
+// setting. Raising the concurrency limit admits queued requests immediately;
+// lowering it lets in-flight requests finish and admits new ones as they drain.
+// Queued requests beyond a lowered queue size keep waiting.
+func (c *Client) SetLimits(maxConcurrent, maxQueue int, submitMinInterval time.Duration) {
 	c.mu.Lock()
-	if len(c.waiters) > 0 {
+	if maxConcurrent > 0 {
+		c.maxConcurrent = maxConcurrent
+	}
+	if maxQueue > 0 {
+		c.maxQueue = maxQueue
+	}
+	for len(c.waiters) > 0 && c.active < c.maxConcurrent {
 		w := c.waiters[0]
 		c.waiters = c.waiters[1:]
+		c.active++
 		close(w.ready)
-		c.mu.Unlock()
-		return
 	}
-	<-c.sem
 	c.mu.Unlock()
+
+	if submitMinInterval < 0 {
+		submitMinInterval = 0
+	}
+	c.submitMu.Lock()
+	c.submitMinInterval = submitMinInterval
+	c.submitMu.Unlock()
+}
+
+func (c *Client) concurrencyLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxConcurrent
+}
+
+func (c *Client) submitInterval() time.Duration {
+	c.submitMu.Lock()
+	defer c.submitMu.Unlock()
+	return c.submitMinInterval
 }
 
 func (c *Client) waitSubmitInterval(ctx context.Context) error {
-	if c.submitMinInterval <= 0 {
+	interval := c.submitInterval()
+	if interval <= 0 {
 		return nil
 	}
+	if c.coord != nil {
+		return c.waitClusterSubmitInterval(ctx, interval)
+	}
 
 	for {
 		now := c.now().UTC()
@@ -360,7 +448,7 @@ func (c *Client) waitSubmitInterval(ctx context.Context) error {
 			return nil
 		}
 
-		next := c.lastSubmitAt.Add(c.submitMinInterval)
+		next := c.lastSubmitAt.Add(interval)
 		if !now.Before(next) {
 			c.lastSubmitAt = now
 			c.submitMu.Unlock()
@@ -376,6 +464,23 @@ func (c *Client) waitSubmitInterval(ctx context.Context) error {
 	}
 }
 
+// waitClusterSubmitInterval is waitSubmitInterval for coordinated deployments:
+// the last submit time lives in the coordinator so every replica honours it.
+func (c *Client) waitClusterSubmitInterval(ctx context.Context, interval time.Duration) error {
+	for {
+		waitFor, err := c.coord.ReserveSubmit(ctx, interval)
+		if err != nil {
+			return err
+		}
+		if waitFor <= 0 {
+			return nil
+		}
+		if err := c.sleep(ctx, waitFor); err != nil {
+			return err
+		}
+	}
+}
+
 func (c *Client) doOnce(ctx context.Context, action string, body []byte, headers http.Header) (*Response, error) {
 
 	endpoint := *c.baseURL
@@ -620,6 +620,9 @@ func TestClient_Submit_GlobalQueueFull_ReturnsErrRateLimitedImmediately(t *testi
 	}()
 
 	waitForUpstreamWaitersLen(t, c, 1)
+	if st := c.QueueStats(); st.Active != 1 || st.Queued != 1 || !st.Saturated {
+		t.Fatalf("expected saturated gate with one active and one queued call, got %+v", st)
+	}
 
 	thirdDone := make(chan callResult, 1)
 	go func() {
@@ -1321,6 +1324,47 @@ func TestClient_GetResult_TimeoutWhileQueued_DoesNotLeakWaiters(t *testing.T) {
 	waitForUpstreamWaitersLen(t, c, baseline)
 }
 
+func TestClient_SetLimits_RaisingConcurrencyAdmitsQueuedRequests(t *testing.T) {
+	release := make(chan struct{})
+	var inFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		<-release
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"done"}`))
+	}))
+	t.Cleanup(srv.Close)
+	defer close(release)
+
+	c, err := upstream.NewClient(config.Config{
+		Credentials: config.Credentials{AccessKey: "ak", SecretKey: "sk"},
+		Region:      "cn-north-1",
+		Host:        srv.URL,
+		Timeout:     2 * time.Second,
+	}, upstream.Options{MaxConcurrent: 1, MaxQueue: 10})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		go func() { _, _ = c.GetResult(context.Background(), []byte(`{"task_id":"t"}`), nil) }()
+	}
+	waitForUpstreamWaitersLen(t, c, 2)
+
+	c.SetLimits(3, 5, 0)
+	waitForUpstreamWaitersLen(t, c, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for inFlight.Load() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := inFlight.Load(); got != 3 {
+		t.Fatalf("expected queued requests to reach upstream after raising the limit, got %d", got)
+	}
+	if st := c.QueueStats(); st.Active != 3 || st.MaxConcurrent != 3 || st.MaxQueue != 5 {
+		t.Fatalf("unexpected queue stats after SetLimits: %+v", st)
+	}
+}
+
 func upstreamWaitersLen(c *upstream.Client) int {
 	if c == nil {
 		return 0
@@ -1779,3 +1823,31 @@ func TestClient_UpstreamParity(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Probe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("probe must not send HTTP requests, got %s %s", r.Method, r.URL)
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := upstream.NewClient(config.Config{
+		Credentials: config.Credentials{AccessKey: "ak", SecretKey: "sk"},
+		Region:      "cn-north-1",
+		Host:        srv.URL,
+		Timeout:     2 * time.Second,
+	}, upstream.Options{})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if st := c.QueueStats(); st.Saturated || st.Active != 0 {
+		t.Fatalf("expected idle gate, got %+v", st)
+	}
+
+	srv.Close()
+	if err := c.Probe(context.Background()); internalerrors.GetCode(err) != internalerrors.ErrUpstreamFailed {
+		t.Fatalf("expected UPSTREAM_FAILED for unreachable upstream, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package upstream
+
+import (
+	"context"
+	"time"
+)
+
+// Coordinator shares upstream concurrency state between relay replicas.
+//
+// The in-process semaphore, FIFO queue and key manager stay in place as the
+// first gate. A Coordinator adds a second, cluster-wide gate so that running N
+// replicas does not multiply the configured limits. The client asks for cluster
+// slots only after passing the local gate, key slot first, so at most the local
+// concurrency limit of requests hold them at once. Single-node deployments
+// leave Options.Coordinator nil and keep the in-memory behavior.
+type Coordinator interface {
+	// AcquireGlobalSlot blocks until one of limit cluster-wide upstream slots is
+	// held or ctx is done. The returned release func must be called exactly once.
+	AcquireGlobalSlot(ctx context.Context, limit int) (release func(), err error)
+
+	// AcquireKeySlot claims cluster-wide exclusive use of apiKeyID. It must not
+	// wait: when another replica holds the key it returns ErrRateLimited (Policy A).
+	AcquireKeySlot(ctx context.Context, apiKeyID string) (release func(), err error)
+
+	// ReserveSubmit records a submit if at least minInterval has elapsed since the
+	// previous cluster-wide submit and returns 0. Otherwise nothing is recorded and
+	// the remaining wait is returned so the caller can sleep and try again.
+	ReserveSubmit(ctx context.Context, minInterval time.Duration) (wait time.Duration, err error)
+}
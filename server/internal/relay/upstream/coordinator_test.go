@@ -0,0 +1,214 @@
+package upstream_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/config"
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/relay/upstream"
+)
+
+// fakeCoordinator mimics another replica holding cluster state.
+type fakeCoordinator struct {
+	mu sync.Mutex
+
+	busyKeys     map[string]bool
+	heldKeys     map[string]int
+	globalLimits []int
+	globalHeld   int
+	submitWaits  []time.Duration
+	reserveCalls int
+}
+
+func (f *fakeCoordinator) AcquireGlobalSlot(_ context.Context, limit int) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.globalLimits = append(f.globalLimits, limit)
+	f.globalHeld++
+	return func() {
+		f.mu.Lock()
+		f.globalHeld--
+		f.mu.Unlock()
+	}, nil
+}
+
+func (f *fakeCoordinator) AcquireKeySlot(_ context.Context, apiKeyID string) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.busyKeys[apiKeyID] {
+		return nil, internalerrors.New(internalerrors.ErrRateLimited, "api key concurrent limit exceeded", nil)
+	}
+	if f.heldKeys == nil {
+		f.heldKeys = map[string]int{}
+	}
+	f.heldKeys[apiKeyID]++
+	return func() {
+		f.mu.Lock()
+		f.heldKeys[apiKeyID]--
+		f.mu.Unlock()
+	}, nil
+}
+
+func (f *fakeCoordinator) ReserveSubmit(_ context.Context, _ time.Duration) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reserveCalls++
+	if len(f.submitWaits) == 0 {
+		return 0, nil
+	}
+	wait := f.submitWaits[0]
+	f.submitWaits = f.submitWaits[1:]
+	return wait, nil
+}
+
+func newCoordinatedClient(t *testing.T, coord upstream.Coordinator, opts upstream.Options) (*upstream.Client, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(`{"ok":true}`)); err != nil {
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opts.Coordinator = coord
+	c, err := upstream.NewClient(config.Config{
+		Credentials: config.Credentials{AccessKey: "ak", SecretKey: "sk"},
+		Region:      "cn-north-1",
+		Host:        srv.URL,
+		Timeout:     2 * time.Second,
+	}, opts)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c, &calls
+}
+
+func TestClient_Coordinator_KeyBusyOnAnotherReplica_RateLimitedWithoutUpstreamCall(t *testing.T) {
+	coord := &fakeCoordinator{busyKeys: map[string]bool{"key_busy": true}}
+	c, calls := newCoordinatedClient(t, coord, upstream.Options{})
+
+	ctx := upstream.WithAPIKeyID(context.Background(), "key_busy")
+	_, err := c.Submit(ctx, []byte(`{"prompt":"cat"}`), nil)
+	if internalerrors.GetCode(err) != internalerrors.ErrRateLimited {
+		t.Fatalf("expected RATE_LIMITED, got code=%s err=%v", internalerrors.GetCode(err), err)
+	}
+	if *calls != 0 {
+		t.Fatalf("expected no upstream call, got %d", *calls)
+	}
+	if len(coord.globalLimits) != 0 {
+		t.Fatalf("expected no global slot acquisition, got %v", coord.globalLimits)
+	}
+}
+
+func TestClient_Coordinator_HoldsClusterSlotsForCallAndReleases(t *testing.T) {
+	coord := &fakeCoordinator{}
+	c, calls := newCoordinatedClient(t, coord, upstream.Options{MaxConcurrent: 3})
+
+	ctx := upstream.WithAPIKeyID(context.Background(), "key_a")
+	if _, err := c.GetResult(ctx, []byte(`{"task_id":"t1"}`), nil); err != nil {
+		t.Fatalf("GetResult unexpected error: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", *calls)
+	}
+	if len(coord.globalLimits) != 1 || coord.globalLimits[0] != 3 {
+		t.Fatalf("expected one global slot acquisition with limit 3, got %v", coord.globalLimits)
+	}
+	if coord.globalHeld != 0 || coord.heldKeys["key_a"] != 0 {
+		t.Fatalf("expected cluster slots released, global=%d key=%d", coord.globalHeld, coord.heldKeys["key_a"])
+	}
+	if coord.reserveCalls != 0 {
+		t.Fatalf("get-result must not reserve the submit interval, got %d calls", coord.reserveCalls)
+	}
+}
+
+func TestClient_Coordinator_SubmitIntervalWaitsForClusterClock(t *testing.T) {
+	coord := &fakeCoordinator{submitWaits: []time.Duration{700 * time.Millisecond, 300 * time.Millisecond}}
+	var sleeps []time.Duration
+	c, calls := newCoordinatedClient(t, coord, upstream.Options{
+		Sleep: func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		},
+		SubmitMinInterval: time.Second,
+	})
+
+	if _, err := c.Submit(context.Background(), []byte(`{"prompt":"cat"}`), nil); err != nil {
+		t.Fatalf("Submit unexpected error: %v", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", *calls)
+	}
+	if coord.reserveCalls != 3 {
+		t.Fatalf("expected 3 reserve attempts, got %d", coord.reserveCalls)
+	}
+	if len(sleeps) != 2 || sleeps[0] != 700*time.Millisecond || sleeps[1] != 300*time.Millisecond {
+		t.Fatalf("expected sleeps [700ms 300ms], got %v", sleeps)
+	}
+}
+
+func TestClient_Coordinator_QueuedRequestsHoldNoClusterSlots(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	coord := &fakeCoordinator{}
+	c, err := upstream.NewClient(config.Config{
+		Credentials: config.Credentials{AccessKey: "ak", SecretKey: "sk"},
+		Region:      "cn-north-1",
+		Host:        srv.URL,
+		Timeout:     2 * time.Second,
+	}, upstream.Options{Coordinator: coord, MaxConcurrent: 1, MaxQueue: 1})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := c.GetResult(upstream.WithAPIKeyID(context.Background(), "key_a"), []byte(`{}`), nil)
+		done <- err
+	}()
+	<-started
+	go func() {
+		_, err := c.GetResult(upstream.WithAPIKeyID(context.Background(), "key_b"), []byte(`{}`), nil)
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for c.QueueStats().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second request to queue, got %+v", c.QueueStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The full queue rejects before any cluster lock is requested.
+	if _, err := c.GetResult(upstream.WithAPIKeyID(context.Background(), "key_c"), []byte(`{}`), nil); internalerrors.GetCode(err) != internalerrors.ErrRateLimited {
+		t.Fatalf("expected RATE_LIMITED from the full queue, got %v", err)
+	}
+	coord.mu.Lock()
+	keys, global := len(coord.heldKeys), len(coord.globalLimits)
+	coord.mu.Unlock()
+	if keys != 1 || global != 1 {
+		t.Fatalf("expected only the running request to hold cluster slots, got keys=%d global=%d", keys, global)
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("GetResult: %v", err)
+		}
+	}
+}
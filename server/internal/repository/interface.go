@@ -17,10 +17,42 @@ type APIKeyRepository interface {
 	SetExpiresAt(ctx context.Context, id string, expiresAt time.Time) error
 }
 
+// APIKeySecretRepository rewrites stored secret ciphertexts, e.g. after the
+// encryption key is rotated.
+type APIKeySecretRepository interface {
+	// RewriteSecretCiphertexts passes every non-empty secret_key_ciphertext to
+	// rewrite and stores the result where it reports a change. All rows are
+	// updated in one transaction; any error leaves every row untouched. It
+	// returns how many rows were scanned and rewritten.
+	RewriteSecretCiphertexts(ctx context.Context, rewrite func(ciphertext string) (string, bool, error)) (scanned, rewritten int64, err error)
+}
+
+// APIKeyMetadataRepository edits the descriptive fields of API keys.
+type APIKeyMetadataRepository interface {
+	// UpdateMetadata replaces the description, owner and labels of the key
+	// and sets its updated_at. It returns ErrNotFound for an unknown id.
+	UpdateMetadata(ctx context.Context, id string, metadata models.APIKeyMetadata, updatedAt time.Time) error
+}
+
+// APIKeyActivityRepository records when and how often API keys are used.
+type APIKeyActivityRepository interface {
+	// RecordActivity adds each batch's requests to its key's request_count
+	// and to the key's count for that day, and moves last_used_at and
+	// last_used_ip forward when the batch is newer. Batches for unknown keys
+	// are skipped.
+	RecordActivity(ctx context.Context, batches []models.APIKeyActivity) error
+	// RequestCountsSince sums the daily counts of every day from since's UTC
+	// day onwards, per API key id. Keys without requests are left out.
+	RequestCountsSince(ctx context.Context, since time.Time) (map[string]int64, error)
+}
+
 type DownstreamRequestRepository interface {
 	Create(ctx context.Context, request models.DownstreamRequest) error
 	GetByID(ctx context.Context, id string) (models.DownstreamRequest, error)
 	GetByRequestID(ctx context.Context, requestID string) (models.DownstreamRequest, error)
+	// List returns one page of requests matching query, ordered by
+	// received_at and then id.
+	List(ctx context.Context, query DownstreamRequestQuery) ([]models.DownstreamRequest, error)
 }
 
 type UpstreamAttemptRepository interface {
@@ -29,9 +61,237 @@ type UpstreamAttemptRepository interface {
 }
 
 type AuditEventRepository interface {
-	Create(ctx context.Context, event models.AuditEvent) error
+	// Create appends event to the hash chain and fills in the chain fields
+	// (Sequence, PrevHash, Hash and the truncated CreatedAt) it was stored with.
+	Create(ctx context.Context, event *models.AuditEvent) error
 	ListByRequestID(ctx context.Context, requestID string) ([]models.AuditEvent, error)
 	ListByTimeRange(ctx context.Context, start, end time.Time) ([]models.AuditEvent, error)
+	// Query returns one page of events matching query, ordered by created_at
+	// and then id.
+	Query(ctx context.Context, query AuditEventQuery) ([]models.AuditEvent, error)
+}
+
+// AuditEventCounter counts audit events per API key.
+type AuditEventCounter interface {
+	// CountByAPIKey counts the events matching query per API key of the
+	// request they belong to; events without a downstream request count under
+	// "". Order, After and Limit are ignored.
+	CountByAPIKey(ctx context.Context, query AuditEventQuery) (map[string]int64, error)
+}
+
+// DownstreamRequestCounter counts downstream requests per API key.
+type DownstreamRequestCounter interface {
+	// CountByAPIKey counts the requests matching query per API key. Order,
+	// After and Limit are ignored.
+	CountByAPIKey(ctx context.Context, query DownstreamRequestQuery) (map[string]int64, error)
+}
+
+// SortOrder is the direction a query walks its time column in.
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// Cursor is the time and id of the last row of a page. The next page starts
+// strictly after it in the query's order, so rows written meanwhile neither
+// repeat nor shift the page.
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// AuditEventQuery filters audit events. Zero fields match everything.
+type AuditEventQuery struct {
+	// From is inclusive and To exclusive.
+	From, To time.Time
+	// APIKeyID matches events of requests made with that key.
+	APIKeyID string
+	// APIKeyIDs, when not empty, matches events of requests made with any of
+	// these keys.
+	APIKeyIDs []string
+	Action    string
+	EventType models.EventType
+	// MinStatus and MaxStatus bound metadata.response_status inclusively;
+	// setting either excludes events without a status.
+	MinStatus, MaxStatus int
+	// HasError selects events of type error or with metadata.error set
+	// (true), or every other event (false).
+	HasError *bool
+	Order    SortOrder
+	After    *Cursor
+	// Limit caps the page size; it must be positive.
+	Limit int
+}
+
+// DownstreamRequestQuery filters downstream requests. Zero fields match
+// everything. Status and error filters look at the request's latest upstream
+// attempt.
+type DownstreamRequestQuery struct {
+	// From is inclusive and To exclusive.
+	From, To time.Time
+	APIKeyID string
+	// APIKeyIDs, when not empty, matches requests made with any of these
+	// keys.
+	APIKeyIDs []string
+	Action    models.DownstreamAction
+	// MinStatus and MaxStatus bound the latest attempt's response_status
+	// inclusively; setting either excludes requests without attempts.
+	MinStatus, MaxStatus int
+	// HasError selects requests whose latest attempt recorded an error
+	// (true), or every other request (false).
+	HasError *bool
+	Order    SortOrder
+	After    *Cursor
+	// Limit caps the page size; it must be positive.
+	Limit int
+}
+
+// AuditChainRepository reads the hash chain that AuditEventRepository.Create
+// appends to and stores signed checkpoints of its head.
+type AuditChainRepository interface {
+	// ListChain returns up to limit chained events with Sequence greater than
+	// afterSequence, in sequence order.
+	ListChain(ctx context.Context, afterSequence int64, limit int) ([]models.AuditEvent, error)
+	// ChainHead returns the chained event with the highest Sequence, or
+	// ErrNotFound when nothing has been chained yet.
+	ChainHead(ctx context.Context) (models.AuditEvent, error)
+	// CountUnchained counts events recorded before chaining was enabled.
+	CountUnchained(ctx context.Context) (int64, error)
+	CreateCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) error
+	// LatestCheckpoint returns ErrNotFound when no checkpoint exists.
+	LatestCheckpoint(ctx context.Context) (models.AuditCheckpoint, error)
+	ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error)
+}
+
+// MigrationStatus is one numbered schema migration and whether the database
+// has it applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator applies and reverts numbered schema migrations, recording them in
+// schema_migrations. Up and down hold a database-wide lock so concurrent
+// replicas never run the same migration twice.
+type Migrator interface {
+	// MigrateUp applies pending migrations up to and including version, or all
+	// of them when version is 0, and returns the versions it applied.
+	MigrateUp(ctx context.Context, version int) ([]int, error)
+	// MigrateDown reverts the newest steps applied migrations and returns the
+	// versions it reverted, newest first.
+	MigrateDown(ctx context.Context, steps int) ([]int, error)
+	// MigrationStatus lists every known migration in version order, followed
+	// by any applied version this build does not know about.
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+}
+
+// Tables that BulkRepository copies, listed parents first so foreign keys
+// hold when they are imported in this order.
+const (
+	TableAPIKeys              = "api_keys"
+	TableAPIKeyDailyRequests  = "api_key_daily_requests"
+	TableDownstreamRequests   = "downstream_requests"
+	TableUpstreamAttempts     = "upstream_attempts"
+	TableAuditEvents          = "audit_events"
+	TableAuditCheckpoints     = "audit_checkpoints"
+	TableIdempotencyRecords   = "idempotency_records"
+	TableStatsRollups         = "stats_rollups"
+	TableStatsRollupWatermark = "stats_rollup_state"
+)
+
+var BulkTables = []string{
+	TableAPIKeys,
+	TableAPIKeyDailyRequests,
+	TableDownstreamRequests,
+	TableUpstreamAttempts,
+	TableAuditEvents,
+	TableAuditCheckpoints,
+	TableIdempotencyRecords,
+	TableStatsRollups,
+	TableStatsRollupWatermark,
+}
+
+// APIKeyDayKey is the primary key of api_key_daily_requests. The zero value
+// sorts before every row.
+type APIKeyDayKey struct {
+	APIKeyID string
+	Day      time.Time
+}
+
+// StatsRollupKey is the primary key of stats_rollups. The zero value sorts
+// before every row.
+type StatsRollupKey struct {
+	Hour        time.Time
+	APIKeyID    string
+	Action      string
+	StatusClass string
+}
+
+// BulkRepository moves rows between databases exactly as stored: IDs,
+// timestamps and audit chain fields are kept and models are not validated.
+// Export methods return up to limit rows whose id (or primary key, for tables
+// without one) sorts after afterID, in that order. Import methods insert a
+// batch in one transaction, skip rows whose id or other unique key already
+// exists, and return how many were inserted.
+type BulkRepository interface {
+	// CountRows counts the rows of one of BulkTables.
+	CountRows(ctx context.Context, table string) (int64, error)
+
+	ExportAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error)
+	ImportAPIKeys(ctx context.Context, keys []models.APIKey) (int64, error)
+	ExportDownstreamRequests(ctx context.Context, afterID string, limit int) ([]models.DownstreamRequest, error)
+	ImportDownstreamRequests(ctx context.Context, requests []models.DownstreamRequest) (int64, error)
+	ExportUpstreamAttempts(ctx context.Context, afterID string, limit int) ([]models.UpstreamAttempt, error)
+	ImportUpstreamAttempts(ctx context.Context, attempts []models.UpstreamAttempt) (int64, error)
+	ExportAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error)
+	ImportAuditEvents(ctx context.Context, events []models.AuditEvent) (int64, error)
+	ExportAuditCheckpoints(ctx context.Context, afterID string, limit int) ([]models.AuditCheckpoint, error)
+	ImportAuditCheckpoints(ctx context.Context, checkpoints []models.AuditCheckpoint) (int64, error)
+	ExportIdempotencyRecords(ctx context.Context, afterID string, limit int) ([]models.IdempotencyRecord, error)
+	ImportIdempotencyRecords(ctx context.Context, records []models.IdempotencyRecord) (int64, error)
+	ExportAPIKeyDailyRequests(ctx context.Context, after APIKeyDayKey, limit int) ([]models.APIKeyDailyRequests, error)
+	ImportAPIKeyDailyRequests(ctx context.Context, counts []models.APIKeyDailyRequests) (int64, error)
+	ExportStatsRollups(ctx context.Context, after StatsRollupKey, limit int) ([]models.StatsRollup, error)
+	ImportStatsRollups(ctx context.Context, rollups []models.StatsRollup) (int64, error)
+	// ExportStatsRollupWatermark returns the rollup watermark, or the zero
+	// time when rollups have never run. ImportStatsRollupWatermark stores a
+	// non-zero watermark unless the destination already has one.
+	ExportStatsRollupWatermark(ctx context.Context) (time.Time, error)
+	ImportStatsRollupWatermark(ctx context.Context, watermark time.Time) (int64, error)
+}
+
+// UsageRepository aggregates relay data for usage reporting.
+type UsageRepository interface {
+	// SummarizeSubmits counts submits received in [from, to) whose upstream
+	// call succeeded, grouped by API key, req_key, UTC day and the frames,
+	// width and height of the body. An empty apiKeyID summarizes all keys.
+	SummarizeSubmits(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.SubmitUsage, error)
+}
+
+// StatsRollupRepository stores hourly rollups of upstream attempts. Rollups
+// live in their own table, so they outlast purges of the raw rows they were
+// computed from.
+type StatsRollupRepository interface {
+	// RollupSamples returns every upstream attempt sent in [from, to), with
+	// the API key and action of its downstream request.
+	RollupSamples(ctx context.Context, from, to time.Time) ([]models.RollupSample, error)
+	// FirstSampleTime returns the earliest sent_at of any upstream attempt,
+	// or ErrNotFound when there are none.
+	FirstSampleTime(ctx context.Context) (time.Time, error)
+	// Watermark returns the latest end of a range written by ReplaceRollups,
+	// or the zero time before the first one.
+	Watermark(ctx context.Context) (time.Time, error)
+	// ReplaceRollups atomically replaces the rollups of the hours in
+	// [from, to) with rollups and advances the watermark to to.
+	ReplaceRollups(ctx context.Context, from, to time.Time, rollups []models.StatsRollup) error
+	// ListRollups returns the rollups of the hours in [from, to), ordered by
+	// hour, API key, action and status class. An empty apiKeyID lists all
+	// keys.
+	ListRollups(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error)
 }
 
 type IdempotencyRecordRepository interface {
@@ -39,3 +299,12 @@ type IdempotencyRecordRepository interface {
 	Create(ctx context.Context, record models.IdempotencyRecord) error
 	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
 }
+
+// SeenSignatureRepository remembers accepted request signatures until they fall
+// out of the SigV4 clock-skew window, so a captured request cannot be replayed.
+type SeenSignatureRepository interface {
+	// Remember stores signature until expiresAt. It reports false when the
+	// signature is already stored.
+	Remember(ctx context.Context, signature string, expiresAt time.Time) (bool, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
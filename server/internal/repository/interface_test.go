@@ -61,6 +61,10 @@ func (m *mockDownstreamRequestRepository) GetByRequestID(_ context.Context, requ
 	return models.DownstreamRequest{ID: "d1", RequestID: requestID, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", ReceivedAt: time.Now().UTC()}, nil
 }
 
+func (m *mockDownstreamRequestRepository) List(_ context.Context, _ DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	return []models.DownstreamRequest{}, nil
+}
+
 type mockUpstreamAttemptRepository struct{}
 
 func (m *mockUpstreamAttemptRepository) Create(_ context.Context, attempt models.UpstreamAttempt) error {
@@ -73,7 +77,7 @@ func (m *mockUpstreamAttemptRepository) ListByRequestID(_ context.Context, _ str
 
 type mockAuditEventRepository struct{}
 
-func (m *mockAuditEventRepository) Create(_ context.Context, event models.AuditEvent) error {
+func (m *mockAuditEventRepository) Create(_ context.Context, event *models.AuditEvent) error {
 	return event.Validate()
 }
 
@@ -85,6 +89,10 @@ func (m *mockAuditEventRepository) ListByTimeRange(_ context.Context, _, _ time.
 	return []models.AuditEvent{}, nil
 }
 
+func (m *mockAuditEventRepository) Query(_ context.Context, _ AuditEventQuery) ([]models.AuditEvent, error) {
+	return []models.AuditEvent{}, nil
+}
+
 type mockIdempotencyRecordRepository struct{}
 
 func (m *mockIdempotencyRecordRepository) GetByKey(_ context.Context, key string) (models.IdempotencyRecord, error) {
@@ -130,6 +138,9 @@ func TestRepositoryContracts(t *testing.T) {
 	if _, err := downstreamRepo.GetByRequestID(ctx, "req-1"); err != nil {
 		t.Fatalf("unexpected error getting downstream request by request id: %v", err)
 	}
+	if _, err := downstreamRepo.List(ctx, DownstreamRequestQuery{Limit: 10}); err != nil {
+		t.Fatalf("unexpected error listing downstream requests: %v", err)
+	}
 
 	var upstreamRepo UpstreamAttemptRepository = &mockUpstreamAttemptRepository{}
 	if err := upstreamRepo.Create(ctx, models.UpstreamAttempt{ID: "u1", RequestID: "req-1", AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: 200, SentAt: now}); err != nil {
@@ -140,7 +151,7 @@ func TestRepositoryContracts(t *testing.T) {
 	}
 
 	var auditRepo AuditEventRepository = &mockAuditEventRepository{}
-	if err := auditRepo.Create(ctx, models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeRequestReceived, Actor: "system", Action: "received", Resource: "relay.request", CreatedAt: now}); err != nil {
+	if err := auditRepo.Create(ctx, &models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeRequestReceived, Actor: "system", Action: "received", Resource: "relay.request", CreatedAt: now}); err != nil {
 		t.Fatalf("unexpected error creating audit event: %v", err)
 	}
 	if _, err := auditRepo.ListByRequestID(ctx, "req-1"); err != nil {
@@ -149,6 +160,9 @@ func TestRepositoryContracts(t *testing.T) {
 	if _, err := auditRepo.ListByTimeRange(ctx, now.Add(-time.Hour), now); err != nil {
 		t.Fatalf("unexpected error listing audit events by time range: %v", err)
 	}
+	if _, err := auditRepo.Query(ctx, AuditEventQuery{EventType: models.EventTypeError, Limit: 10}); err != nil {
+		t.Fatalf("unexpected error querying audit events: %v", err)
+	}
 
 	var idempotencyRepo IdempotencyRecordRepository = &mockIdempotencyRecordRepository{}
 	if _, err := idempotencyRepo.GetByKey(ctx, "idem-1"); err != nil {
@@ -12,6 +12,7 @@ import (
 
 	"github.com/jimeng-relay/server/internal/models"
 	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/repository/memory"
 	"github.com/jimeng-relay/server/internal/repository/postgres"
 	"github.com/jimeng-relay/server/internal/repository/sqlite"
 )
@@ -47,6 +48,17 @@ func TestRepositoryMatrix_SQLiteAndPostgres_Semantics(t *testing.T) {
 				}, func() { _ = repos.Close() }
 			},
 		},
+		{
+			Name: "memory",
+			Open: func(t *testing.T) (matrixRepos, func()) {
+				repos := memory.New()
+				return matrixRepos{
+					APIKeys:            repos.APIKeys,
+					AuditEvents:        repos.AuditEvents,
+					IdempotencyRecords: repos.IdempotencyRecords,
+				}, func() {}
+			},
+		},
 		{
 			Name: "postgres",
 			Open: func(t *testing.T) (matrixRepos, func()) {
@@ -238,7 +250,7 @@ func testAuditEventSemantics(t *testing.T, repos matrixRepos) {
 		Metadata:  map[string]any{"m": "1"},
 		CreatedAt: base,
 	}
-	if err := repos.AuditEvents.Create(ctx, e1); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e1); err != nil {
 		t.Fatalf("Create(a1): %v", err)
 	}
 	e2 := models.AuditEvent{
@@ -250,7 +262,7 @@ func testAuditEventSemantics(t *testing.T, repos matrixRepos) {
 		Resource:  "relay.response",
 		CreatedAt: base.Add(2 * time.Second),
 	}
-	if err := repos.AuditEvents.Create(ctx, e2); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e2); err != nil {
 		t.Fatalf("Create(a2): %v", err)
 	}
 	e3 := models.AuditEvent{
@@ -262,7 +274,7 @@ func testAuditEventSemantics(t *testing.T, repos matrixRepos) {
 		Resource:  "relay.error",
 		CreatedAt: base.Add(3 * time.Second),
 	}
-	if err := repos.AuditEvents.Create(ctx, e3); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e3); err != nil {
 		t.Fatalf("Create(a3): %v", err)
 	}
 
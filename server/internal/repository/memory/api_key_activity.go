@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+var _ repository.APIKeyActivityRepository = (*APIKeyRepo)(nil)
+
+// dayLayout is how daily request keys store their UTC day.
+const dayLayout = "2006-01-02"
+
+type dailyRequestKey struct {
+	apiKeyID string
+	day      string
+}
+
+func formatDay(t time.Time) string {
+	return t.UTC().Format(dayLayout)
+}
+
+func (r *APIKeyRepo) RecordActivity(_ context.Context, batches []models.APIKeyActivity) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, b := range batches {
+		key, ok := r.s.apiKeys[b.APIKeyID]
+		if !ok {
+			continue
+		}
+		key.RequestCount += b.Requests
+		if key.LastUsedAt == nil || key.LastUsedAt.Before(b.LastUsedAt) {
+			key.LastUsedAt = timePtr(b.LastUsedAt)
+			key.LastUsedIP = b.LastUsedIP
+		}
+		r.s.apiKeys[b.APIKeyID] = key
+		r.s.dailyRequests[dailyRequestKey{apiKeyID: b.APIKeyID, day: formatDay(b.Day)}] += b.Requests
+	}
+	return nil
+}
+
+func (r *APIKeyRepo) RequestCountsSince(_ context.Context, since time.Time) (map[string]int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	from := formatDay(since)
+	out := map[string]int64{}
+	for key, n := range r.s.dailyRequests {
+		if key.day >= from {
+			out[key.apiKeyID] += n
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type AuditEventRepo struct{ s *store }
+
+var (
+	_ repository.AuditEventRepository = (*AuditEventRepo)(nil)
+	_ repository.AuditChainRepository = (*AuditEventRepo)(nil)
+)
+
+// Create appends event to the hash chain; the store lock serialises appends.
+func (r *AuditEventRepo) Create(_ context.Context, event *models.AuditEvent) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	stored, err := normalizeAuditEvent(*event)
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.auditEvents[stored.ID]; ok {
+		return uniqueErr("audit_events.id")
+	}
+	var prevSequence int64
+	var prevHash string
+	if head, ok := r.s.chainHead(); ok {
+		prevSequence, prevHash = head.Sequence, head.Hash
+	}
+	if err := stored.Chain(prevSequence, prevHash); err != nil {
+		return err
+	}
+	if err := r.s.insertAuditEvent(stored); err != nil {
+		return err
+	}
+	event.CreatedAt, event.Sequence, event.PrevHash, event.Hash = stored.CreatedAt, stored.Sequence, stored.PrevHash, stored.Hash
+	return nil
+}
+
+func (s *store) insertAuditEvent(event models.AuditEvent) error {
+	if _, ok := s.auditEvents[event.ID]; ok {
+		return uniqueErr("audit_events.id")
+	}
+	if event.Sequence > 0 {
+		if _, ok := s.auditSequences[event.Sequence]; ok {
+			return uniqueErr("audit_events.sequence")
+		}
+		s.auditSequences[event.Sequence] = event.ID
+	}
+	s.auditEvents[event.ID] = event
+	s.auditOrder = append(s.auditOrder, event.ID)
+	return nil
+}
+
+func (s *store) chainHead() (models.AuditEvent, bool) {
+	var head models.AuditEvent
+	for seq, id := range s.auditSequences {
+		if seq > head.Sequence {
+			head = s.auditEvents[id]
+		}
+	}
+	return head, head.Sequence > 0
+}
+
+func (r *AuditEventRepo) ListByRequestID(_ context.Context, requestID string) ([]models.AuditEvent, error) {
+	return r.list(func(e models.AuditEvent) bool { return e.RequestID == requestID }), nil
+}
+
+func (r *AuditEventRepo) ListByTimeRange(_ context.Context, start, end time.Time) ([]models.AuditEvent, error) {
+	return r.list(func(e models.AuditEvent) bool {
+		return !e.CreatedAt.Before(start) && !e.CreatedAt.After(end)
+	}), nil
+}
+
+// list returns the matching events ordered by created_at.
+func (r *AuditEventRepo) list(match func(models.AuditEvent) bool) []models.AuditEvent {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	var out []models.AuditEvent
+	for _, id := range r.s.auditOrder {
+		if e := r.s.auditEvents[id]; match(e) {
+			out = append(out, cloneAuditEvent(e))
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (r *AuditEventRepo) ListChain(_ context.Context, afterSequence int64, limit int) ([]models.AuditEvent, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	sequences := make([]int64, 0, len(r.s.auditSequences))
+	for seq := range r.s.auditSequences {
+		if seq > afterSequence {
+			sequences = append(sequences, seq)
+		}
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	if limit >= 0 && len(sequences) > limit {
+		sequences = sequences[:limit]
+	}
+	out := make([]models.AuditEvent, 0, len(sequences))
+	for _, seq := range sequences {
+		out = append(out, cloneAuditEvent(r.s.auditEvents[r.s.auditSequences[seq]]))
+	}
+	return out, nil
+}
+
+func (r *AuditEventRepo) ChainHead(context.Context) (models.AuditEvent, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	head, ok := r.s.chainHead()
+	if !ok {
+		return models.AuditEvent{}, repository.ErrNotFound
+	}
+	return cloneAuditEvent(head), nil
+}
+
+func (r *AuditEventRepo) CountUnchained(context.Context) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return int64(len(r.s.auditEvents) - len(r.s.auditSequences)), nil
+}
+
+func (r *AuditEventRepo) CreateCheckpoint(_ context.Context, checkpoint models.AuditCheckpoint) error {
+	if err := checkpoint.Validate(); err != nil {
+		return err
+	}
+	checkpoint.CreatedAt = checkpoint.CreatedAt.UTC().Truncate(models.AuditChainTimePrecision)
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.insertCheckpoint(checkpoint)
+}
+
+func (s *store) insertCheckpoint(checkpoint models.AuditCheckpoint) error {
+	if _, ok := s.checkpoints[checkpoint.ID]; ok {
+		return uniqueErr("audit_checkpoints.id")
+	}
+	s.checkpoints[checkpoint.ID] = checkpoint
+	return nil
+}
+
+func (r *AuditEventRepo) LatestCheckpoint(ctx context.Context) (models.AuditCheckpoint, error) {
+	checkpoints, err := r.ListCheckpoints(ctx)
+	if err != nil {
+		return models.AuditCheckpoint{}, err
+	}
+	if len(checkpoints) == 0 {
+		return models.AuditCheckpoint{}, repository.ErrNotFound
+	}
+	return checkpoints[len(checkpoints)-1], nil
+}
+
+// ListCheckpoints orders checkpoints by sequence, then created_at.
+func (r *AuditEventRepo) ListCheckpoints(context.Context) ([]models.AuditCheckpoint, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	out := make([]models.AuditCheckpoint, 0, len(r.s.checkpoints))
+	for _, c := range r.s.checkpoints {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sequence != out[j].Sequence {
+			return out[i].Sequence < out[j].Sequence
+		}
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
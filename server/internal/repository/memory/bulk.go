@@ -0,0 +1,272 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type BulkRepo struct{ s *store }
+
+var _ repository.BulkRepository = (*BulkRepo)(nil)
+
+func (r *BulkRepo) CountRows(_ context.Context, table string) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	switch table {
+	case repository.TableAPIKeys:
+		return int64(len(r.s.apiKeys)), nil
+	case repository.TableDownstreamRequests:
+		return int64(len(r.s.downstream)), nil
+	case repository.TableUpstreamAttempts:
+		return int64(len(r.s.upstream)), nil
+	case repository.TableAuditEvents:
+		return int64(len(r.s.auditEvents)), nil
+	case repository.TableAuditCheckpoints:
+		return int64(len(r.s.checkpoints)), nil
+	case repository.TableIdempotencyRecords:
+		return int64(len(r.s.idempotency)), nil
+	case repository.TableAPIKeyDailyRequests:
+		return int64(len(r.s.dailyRequests)), nil
+	case repository.TableStatsRollups:
+		return int64(len(r.s.rollups)), nil
+	case repository.TableStatsRollupWatermark:
+		if r.s.rollupWatermark.IsZero() {
+			return 0, nil
+		}
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("unknown table %q", table)
+	}
+}
+
+func (r *BulkRepo) ExportAPIKeys(_ context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.apiKeys, afterID, limit, cloneAPIKey), nil
+}
+
+func (r *BulkRepo) ImportAPIKeys(_ context.Context, keys []models.APIKey) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(keys, r.s.insertAPIKey), nil
+}
+
+func (r *BulkRepo) ExportDownstreamRequests(_ context.Context, afterID string, limit int) ([]models.DownstreamRequest, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.downstream, afterID, limit, cloneDownstreamRequest), nil
+}
+
+func (r *BulkRepo) ImportDownstreamRequests(_ context.Context, requests []models.DownstreamRequest) (int64, error) {
+	normalized, err := normalizeAll(requests, normalizeDownstreamRequest)
+	if err != nil {
+		return 0, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(normalized, r.s.insertDownstreamRequest), nil
+}
+
+func (r *BulkRepo) ExportUpstreamAttempts(_ context.Context, afterID string, limit int) ([]models.UpstreamAttempt, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.upstream, afterID, limit, cloneUpstreamAttempt), nil
+}
+
+func (r *BulkRepo) ImportUpstreamAttempts(_ context.Context, attempts []models.UpstreamAttempt) (int64, error) {
+	normalized, err := normalizeAll(attempts, normalizeUpstreamAttempt)
+	if err != nil {
+		return 0, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(normalized, r.s.insertUpstreamAttempt), nil
+}
+
+func (r *BulkRepo) ExportAuditEvents(_ context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.auditEvents, afterID, limit, cloneAuditEvent), nil
+}
+
+func (r *BulkRepo) ImportAuditEvents(_ context.Context, events []models.AuditEvent) (int64, error) {
+	normalized, err := normalizeAll(events, normalizeAuditEvent)
+	if err != nil {
+		return 0, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(normalized, r.s.insertAuditEvent), nil
+}
+
+func (r *BulkRepo) ExportAuditCheckpoints(_ context.Context, afterID string, limit int) ([]models.AuditCheckpoint, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.checkpoints, afterID, limit, func(c models.AuditCheckpoint) models.AuditCheckpoint { return c }), nil
+}
+
+func (r *BulkRepo) ImportAuditCheckpoints(_ context.Context, checkpoints []models.AuditCheckpoint) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(checkpoints, r.s.insertCheckpoint), nil
+}
+
+func (r *BulkRepo) ExportIdempotencyRecords(_ context.Context, afterID string, limit int) ([]models.IdempotencyRecord, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return exportPage(r.s.idempotency, afterID, limit, cloneIdempotencyRecord), nil
+}
+
+func (r *BulkRepo) ImportIdempotencyRecords(_ context.Context, records []models.IdempotencyRecord) (int64, error) {
+	normalized, err := normalizeAll(records, normalizeIdempotencyRecord)
+	if err != nil {
+		return 0, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(normalized, r.s.insertIdempotencyRecord), nil
+}
+
+func (r *BulkRepo) ExportAPIKeyDailyRequests(_ context.Context, after repository.APIKeyDayKey, limit int) ([]models.APIKeyDailyRequests, error) {
+	from := dailyRequestKey{apiKeyID: after.APIKeyID, day: formatDay(after.Day)}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	keys := make([]dailyRequestKey, 0, len(r.s.dailyRequests))
+	for key := range r.s.dailyRequests {
+		if dailyRequestKeyLess(from, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return dailyRequestKeyLess(keys[i], keys[j]) })
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	out := make([]models.APIKeyDailyRequests, 0, len(keys))
+	for _, key := range keys {
+		day, err := time.Parse(dayLayout, key.day)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, models.APIKeyDailyRequests{APIKeyID: key.apiKeyID, Day: day, Requests: r.s.dailyRequests[key]})
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportAPIKeyDailyRequests(_ context.Context, counts []models.APIKeyDailyRequests) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return importAll(counts, func(c models.APIKeyDailyRequests) error {
+		key := dailyRequestKey{apiKeyID: c.APIKeyID, day: formatDay(c.Day)}
+		if _, ok := r.s.dailyRequests[key]; ok {
+			return uniqueErr("api_key_daily_requests.api_key_id, api_key_daily_requests.day")
+		}
+		r.s.dailyRequests[key] = c.Requests
+		return nil
+	}), nil
+}
+
+func (r *BulkRepo) ExportStatsRollups(_ context.Context, after repository.StatsRollupKey, limit int) ([]models.StatsRollup, error) {
+	from := models.StatsRollup{Hour: after.Hour.UTC(), APIKeyID: after.APIKeyID, Action: models.DownstreamAction(after.Action), StatusClass: after.StatusClass}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	out := make([]models.StatsRollup, 0)
+	for _, rollup := range r.s.rollups {
+		if rollupKeyLess(from, rollup) {
+			out = append(out, rollup)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return rollupKeyLess(out[i], out[j]) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportStatsRollups(_ context.Context, rollups []models.StatsRollup) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	seen := make(map[models.StatsRollup]bool, len(r.s.rollups))
+	for _, rollup := range r.s.rollups {
+		seen[rollupKey(rollup)] = true
+	}
+	return importAll(rollups, func(rollup models.StatsRollup) error {
+		rollup.Hour = rollup.Hour.UTC()
+		if seen[rollupKey(rollup)] {
+			return uniqueErr("stats_rollups.hour, stats_rollups.api_key_id, stats_rollups.action, stats_rollups.status_class")
+		}
+		seen[rollupKey(rollup)] = true
+		r.s.rollups = append(r.s.rollups, rollup)
+		return nil
+	}), nil
+}
+
+func (r *BulkRepo) ExportStatsRollupWatermark(context.Context) (time.Time, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return r.s.rollupWatermark, nil
+}
+
+func (r *BulkRepo) ImportStatsRollupWatermark(_ context.Context, watermark time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if watermark.IsZero() || !r.s.rollupWatermark.IsZero() {
+		return 0, nil
+	}
+	r.s.rollupWatermark = watermark.UTC()
+	return 1, nil
+}
+
+func dailyRequestKeyLess(a, b dailyRequestKey) bool {
+	if a.apiKeyID != b.apiKeyID {
+		return a.apiKeyID < b.apiKeyID
+	}
+	return a.day < b.day
+}
+
+// exportPage returns up to limit rows with an id after afterID, in id order.
+func exportPage[T any](rows map[string]T, afterID string, limit int, clone func(T) T) []T {
+	ids := make([]string, 0, len(rows))
+	for id := range rows {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]T, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, clone(rows[id]))
+	}
+	return out
+}
+
+// importAll inserts rows under the caller's lock, skipping those that break
+// a unique constraint, and returns how many were inserted.
+func importAll[T any](rows []T, insert func(T) error) int64 {
+	var n int64
+	for _, row := range rows {
+		if insert(row) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func normalizeAll[T any](rows []T, normalize func(T) (T, error)) ([]T, error) {
+	out := slices.Clone(rows)
+	for i := range out {
+		var err error
+		if out[i], err = normalize(out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
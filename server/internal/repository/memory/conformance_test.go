@@ -0,0 +1,24 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/jimeng-relay/server/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		repos := New()
+		return repotest.Repositories{
+			APIKeys:            repos.APIKeys,
+			DownstreamRequests: repos.DownstreamRequests,
+			UpstreamAttempts:   repos.UpstreamAttempts,
+			AuditEvents:        repos.AuditEvents,
+			IdempotencyRecords: repos.IdempotencyRecords,
+			SeenSignatures:     repos.SeenSignatures,
+			StatsRollups:       repos.StatsRollups,
+			APIKeyActivity:     repos.APIKeys,
+			APIKeyMetadata:     repos.APIKeys,
+		}
+	})
+}
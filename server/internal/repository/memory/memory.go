@@ -0,0 +1,719 @@
+// Package memory keeps every repository in process memory. Nothing survives a
+// restart, which suits dev servers, benchmarks and tests; the behaviour
+// otherwise follows the sqlite and postgres backends, including ErrNotFound
+// and unique constraints.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// ErrConstraint is wrapped by errors for writes that would break a unique
+// constraint.
+var ErrConstraint = errors.New("memory: constraint violation")
+
+type Repositories struct {
+	APIKeys            *APIKeyRepo
+	DownstreamRequests *DownstreamRequestRepo
+	UpstreamAttempts   *UpstreamAttemptRepo
+	AuditEvents        *AuditEventRepo
+	IdempotencyRecords *IdempotencyRecordRepo
+	SeenSignatures     *SeenSignatureRepo
+	Usage              *UsageRepo
+	StatsRollups       *StatsRollupRepo
+	Migrations         *Migrator
+	Bulk               *BulkRepo
+}
+
+// store holds every table behind one lock, so reads that span tables (usage,
+// bulk export) see a consistent snapshot. Maps are keyed by id; the index
+// maps enforce the unique columns.
+type store struct {
+	mu sync.RWMutex
+
+	apiKeys    map[string]models.APIKey
+	accessKeys map[string]string
+
+	downstream           map[string]models.DownstreamRequest
+	downstreamRequestIDs map[string]string
+
+	upstream        map[string]models.UpstreamAttempt
+	upstreamNumbers map[string]string
+
+	// auditOrder keeps insertion order so equal created_at values list
+	// stably.
+	auditEvents    map[string]models.AuditEvent
+	auditOrder     []string
+	auditSequences map[int64]string
+	checkpoints    map[string]models.AuditCheckpoint
+
+	idempotency     map[string]models.IdempotencyRecord
+	idempotencyKeys map[string]string
+
+	seenSignatures map[string]time.Time
+
+	rollups         []models.StatsRollup
+	rollupWatermark time.Time
+
+	dailyRequests map[dailyRequestKey]int64
+}
+
+func New() *Repositories {
+	s := &store{
+		apiKeys:              map[string]models.APIKey{},
+		accessKeys:           map[string]string{},
+		downstream:           map[string]models.DownstreamRequest{},
+		downstreamRequestIDs: map[string]string{},
+		upstream:             map[string]models.UpstreamAttempt{},
+		upstreamNumbers:      map[string]string{},
+		auditEvents:          map[string]models.AuditEvent{},
+		auditSequences:       map[int64]string{},
+		checkpoints:          map[string]models.AuditCheckpoint{},
+		idempotency:          map[string]models.IdempotencyRecord{},
+		idempotencyKeys:      map[string]string{},
+		seenSignatures:       map[string]time.Time{},
+		dailyRequests:        map[dailyRequestKey]int64{},
+	}
+	return &Repositories{
+		APIKeys:            &APIKeyRepo{s: s},
+		DownstreamRequests: &DownstreamRequestRepo{s: s},
+		UpstreamAttempts:   &UpstreamAttemptRepo{s: s},
+		AuditEvents:        &AuditEventRepo{s: s},
+		IdempotencyRecords: &IdempotencyRecordRepo{s: s},
+		SeenSignatures:     &SeenSignatureRepo{s: s},
+		Usage:              &UsageRepo{s: s},
+		StatsRollups:       &StatsRollupRepo{s: s},
+		Migrations:         &Migrator{},
+		Bulk:               &BulkRepo{s: s},
+	}
+}
+
+// Ping always succeeds; there is nothing to reach.
+func (r *Repositories) Ping(context.Context) error {
+	return nil
+}
+
+type APIKeyRepo struct{ s *store }
+
+var (
+	_ repository.APIKeyRepository       = (*APIKeyRepo)(nil)
+	_ repository.APIKeySecretRepository = (*APIKeyRepo)(nil)
+)
+
+func (r *APIKeyRepo) Create(_ context.Context, key models.APIKey) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.insertAPIKey(key)
+}
+
+func (s *store) insertAPIKey(key models.APIKey) error {
+	if _, ok := s.apiKeys[key.ID]; ok {
+		return uniqueErr("api_keys.id")
+	}
+	if _, ok := s.accessKeys[key.AccessKey]; ok {
+		return uniqueErr("api_keys.access_key")
+	}
+	s.apiKeys[key.ID] = cloneAPIKey(key)
+	s.accessKeys[key.AccessKey] = key.ID
+	return nil
+}
+
+func (r *APIKeyRepo) GetByID(_ context.Context, id string) (models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	key, ok := r.s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, repository.ErrNotFound
+	}
+	return cloneAPIKey(key), nil
+}
+
+func (r *APIKeyRepo) GetByAccessKey(_ context.Context, accessKey string) (models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	id, ok := r.s.accessKeys[accessKey]
+	if !ok {
+		return models.APIKey{}, repository.ErrNotFound
+	}
+	return cloneAPIKey(r.s.apiKeys[id]), nil
+}
+
+func (r *APIKeyRepo) List(context.Context) ([]models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	out := make([]models.APIKey, 0, len(r.s.apiKeys))
+	for _, key := range r.s.apiKeys {
+		out = append(out, cloneAPIKey(key))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+func (r *APIKeyRepo) Revoke(_ context.Context, id string, revokedAt time.Time) error {
+	if revokedAt.IsZero() {
+		return fmt.Errorf("revokedAt is required")
+	}
+	return r.update(id, func(key *models.APIKey) {
+		key.RevokedAt = timePtr(revokedAt)
+		key.Status = models.APIKeyStatusRevoked
+	})
+}
+
+// SetExpired marks the key expired unless it is already revoked.
+func (r *APIKeyRepo) SetExpired(_ context.Context, id string, expiredAt time.Time) error {
+	if expiredAt.IsZero() {
+		return fmt.Errorf("expiredAt is required")
+	}
+	return r.update(id, func(key *models.APIKey) {
+		key.ExpiresAt = timePtr(expiredAt)
+		if key.Status != models.APIKeyStatusRevoked {
+			key.Status = models.APIKeyStatusExpired
+		}
+	})
+}
+
+func (r *APIKeyRepo) SetExpiresAt(_ context.Context, id string, expiresAt time.Time) error {
+	if expiresAt.IsZero() {
+		return fmt.Errorf("expiresAt is required")
+	}
+	return r.update(id, func(key *models.APIKey) {
+		key.ExpiresAt = timePtr(expiresAt)
+	})
+}
+
+func (r *APIKeyRepo) update(id string, apply func(key *models.APIKey)) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("id is required")
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key, ok := r.s.apiKeys[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	apply(&key)
+	key.UpdatedAt = time.Now().UTC()
+	r.s.apiKeys[id] = key
+	return nil
+}
+
+var _ repository.APIKeyMetadataRepository = (*APIKeyRepo)(nil)
+
+func (r *APIKeyRepo) UpdateMetadata(_ context.Context, id string, metadata models.APIKeyMetadata, updatedAt time.Time) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("id is required")
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key, ok := r.s.apiKeys[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	key.Description = metadata.Description
+	key.Owner = metadata.Owner
+	key.Labels = cloneLabels(metadata.Labels)
+	key.UpdatedAt = updatedAt.UTC()
+	r.s.apiKeys[id] = key
+	return nil
+}
+
+// RewriteSecretCiphertexts computes every rewrite before storing any, so an
+// error leaves all keys untouched.
+func (r *APIKeyRepo) RewriteSecretCiphertexts(_ context.Context, rewrite func(ciphertext string) (string, bool, error)) (int64, int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	ids := make([]string, 0, len(r.s.apiKeys))
+	for id, key := range r.s.apiKeys {
+		if key.SecretKeyCiphertext != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	updates := map[string]string{}
+	for _, id := range ids {
+		out, changed, err := rewrite(r.s.apiKeys[id].SecretKeyCiphertext)
+		if err != nil {
+			return 0, 0, fmt.Errorf("api key %s: %w", id, err)
+		}
+		if changed {
+			updates[id] = out
+		}
+	}
+	for id, ciphertext := range updates {
+		key := r.s.apiKeys[id]
+		key.SecretKeyCiphertext = ciphertext
+		r.s.apiKeys[id] = key
+	}
+	return int64(len(ids)), int64(len(updates)), nil
+}
+
+type DownstreamRequestRepo struct{ s *store }
+
+var _ repository.DownstreamRequestRepository = (*DownstreamRequestRepo)(nil)
+
+func (r *DownstreamRequestRepo) Create(_ context.Context, request models.DownstreamRequest) error {
+	if err := request.Validate(); err != nil {
+		return err
+	}
+	stored, err := normalizeDownstreamRequest(request)
+	if err != nil {
+		return err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.insertDownstreamRequest(stored)
+}
+
+func (s *store) insertDownstreamRequest(request models.DownstreamRequest) error {
+	if _, ok := s.downstream[request.ID]; ok {
+		return uniqueErr("downstream_requests.id")
+	}
+	if _, ok := s.downstreamRequestIDs[request.RequestID]; ok {
+		return uniqueErr("downstream_requests.request_id")
+	}
+	s.downstream[request.ID] = request
+	s.downstreamRequestIDs[request.RequestID] = request.ID
+	return nil
+}
+
+func (r *DownstreamRequestRepo) GetByID(_ context.Context, id string) (models.DownstreamRequest, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	request, ok := r.s.downstream[id]
+	if !ok {
+		return models.DownstreamRequest{}, repository.ErrNotFound
+	}
+	return cloneDownstreamRequest(request), nil
+}
+
+func (r *DownstreamRequestRepo) GetByRequestID(_ context.Context, requestID string) (models.DownstreamRequest, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	id, ok := r.s.downstreamRequestIDs[requestID]
+	if !ok {
+		return models.DownstreamRequest{}, repository.ErrNotFound
+	}
+	return cloneDownstreamRequest(r.s.downstream[id]), nil
+}
+
+type UpstreamAttemptRepo struct{ s *store }
+
+var _ repository.UpstreamAttemptRepository = (*UpstreamAttemptRepo)(nil)
+
+func (r *UpstreamAttemptRepo) Create(_ context.Context, attempt models.UpstreamAttempt) error {
+	if err := attempt.Validate(); err != nil {
+		return err
+	}
+	stored, err := normalizeUpstreamAttempt(attempt)
+	if err != nil {
+		return err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.insertUpstreamAttempt(stored)
+}
+
+func (s *store) insertUpstreamAttempt(attempt models.UpstreamAttempt) error {
+	if _, ok := s.upstream[attempt.ID]; ok {
+		return uniqueErr("upstream_attempts.id")
+	}
+	number := attempt.RequestID + "\x00" + strconv.Itoa(attempt.AttemptNumber)
+	if _, ok := s.upstreamNumbers[number]; ok {
+		return uniqueErr("upstream_attempts.request_id, upstream_attempts.attempt_number")
+	}
+	s.upstream[attempt.ID] = attempt
+	s.upstreamNumbers[number] = attempt.ID
+	return nil
+}
+
+func (r *UpstreamAttemptRepo) ListByRequestID(_ context.Context, requestID string) ([]models.UpstreamAttempt, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	var out []models.UpstreamAttempt
+	for _, attempt := range r.s.upstream {
+		if attempt.RequestID == requestID {
+			out = append(out, cloneUpstreamAttempt(attempt))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AttemptNumber < out[j].AttemptNumber })
+	return out, nil
+}
+
+type IdempotencyRecordRepo struct{ s *store }
+
+var _ repository.IdempotencyRecordRepository = (*IdempotencyRecordRepo)(nil)
+
+func (r *IdempotencyRecordRepo) GetByKey(_ context.Context, idempotencyKey string) (models.IdempotencyRecord, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	id, ok := r.s.idempotencyKeys[idempotencyKey]
+	if !ok {
+		return models.IdempotencyRecord{}, repository.ErrNotFound
+	}
+	return cloneIdempotencyRecord(r.s.idempotency[id]), nil
+}
+
+func (r *IdempotencyRecordRepo) Create(_ context.Context, record models.IdempotencyRecord) error {
+	if err := record.Validate(); err != nil {
+		return err
+	}
+	stored, err := normalizeIdempotencyRecord(record)
+	if err != nil {
+		return err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.insertIdempotencyRecord(stored)
+}
+
+func (s *store) insertIdempotencyRecord(record models.IdempotencyRecord) error {
+	if _, ok := s.idempotency[record.ID]; ok {
+		return uniqueErr("idempotency_records.id")
+	}
+	if _, ok := s.idempotencyKeys[record.IdempotencyKey]; ok {
+		return uniqueErr("idempotency_records.idempotency_key")
+	}
+	s.idempotency[record.ID] = record
+	s.idempotencyKeys[record.IdempotencyKey] = record.ID
+	return nil
+}
+
+func (r *IdempotencyRecordRepo) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var n int64
+	for id, record := range r.s.idempotency {
+		if !record.ExpiresAt.After(now) {
+			delete(r.s.idempotency, id)
+			delete(r.s.idempotencyKeys, record.IdempotencyKey)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type SeenSignatureRepo struct{ s *store }
+
+var _ repository.SeenSignatureRepository = (*SeenSignatureRepo)(nil)
+
+func (r *SeenSignatureRepo) Remember(_ context.Context, signature string, expiresAt time.Time) (bool, error) {
+	if strings.TrimSpace(signature) == "" {
+		return false, fmt.Errorf("signature is required")
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.seenSignatures[signature]; ok {
+		return false, nil
+	}
+	r.s.seenSignatures[signature] = expiresAt.UTC()
+	return true, nil
+}
+
+func (r *SeenSignatureRepo) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var n int64
+	for signature, expiresAt := range r.s.seenSignatures {
+		if !expiresAt.After(now) {
+			delete(r.s.seenSignatures, signature)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type UsageRepo struct{ s *store }
+
+var _ repository.UsageRepository = (*UsageRepo)(nil)
+
+func (r *UsageRepo) SummarizeSubmits(_ context.Context, from, to time.Time, apiKeyID string) ([]models.SubmitUsage, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	succeeded := map[string]bool{}
+	for _, attempt := range r.s.upstream {
+		if attempt.ResponseStatus >= 200 && attempt.ResponseStatus <= 299 && attempt.Error == nil {
+			succeeded[attempt.RequestID] = true
+		}
+	}
+
+	counts := map[models.SubmitUsage]int64{}
+	for _, request := range r.s.downstream {
+		if request.Action != models.DownstreamActionCVSync2AsyncSubmitTask {
+			continue
+		}
+		if request.ReceivedAt.Before(from) || !request.ReceivedAt.Before(to) {
+			continue
+		}
+		if apiKeyID != "" && request.APIKeyID != apiKeyID {
+			continue
+		}
+		if !succeeded[request.RequestID] {
+			continue
+		}
+		reqKey, _ := request.Body["req_key"].(string)
+		group := models.SubmitUsage{
+			APIKeyID: request.APIKeyID,
+			ReqKey:   reqKey,
+			Day:      request.ReceivedAt.UTC().Format("2006-01-02"),
+			Frames:   usageIntField(request.Body, "frames"),
+			Width:    usageIntField(request.Body, "width"),
+			Height:   usageIntField(request.Body, "height"),
+		}
+		counts[group]++
+	}
+
+	out := make([]models.SubmitUsage, 0, len(counts))
+	for group, n := range counts {
+		group.Count = n
+		out = append(out, group)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.Day != b.Day:
+			return a.Day < b.Day
+		case a.APIKeyID != b.APIKeyID:
+			return a.APIKeyID < b.APIKeyID
+		case a.ReqKey != b.ReqKey:
+			return a.ReqKey < b.ReqKey
+		case a.Frames != b.Frames:
+			return a.Frames < b.Frames
+		case a.Width != b.Width:
+			return a.Width < b.Width
+		default:
+			return a.Height < b.Height
+		}
+	})
+	return out, nil
+}
+
+// usageIntField reads a numeric body field, treating anything else as 0.
+// Bodies went through JSON, so numbers are float64; like sqlite's CAST, the
+// fraction is dropped.
+func usageIntField(body map[string]any, field string) int {
+	if v, ok := body[field].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// Migrator satisfies repository.Migrator for a backend without a schema:
+// there is never anything to apply or revert.
+type Migrator struct{}
+
+var _ repository.Migrator = (*Migrator)(nil)
+
+func (m *Migrator) MigrateUp(context.Context, int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *Migrator) MigrateDown(context.Context, int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *Migrator) MigrationStatus(context.Context) ([]repository.MigrationStatus, error) {
+	return []repository.MigrationStatus{}, nil
+}
+
+func uniqueErr(columns string) error {
+	return fmt.Errorf("%w: UNIQUE constraint failed: %s", ErrConstraint, columns)
+}
+
+// The normalize functions store what a database would hand back: UTC times
+// and JSON fields that went through encoding/json (numbers become float64).
+
+func normalizeDownstreamRequest(request models.DownstreamRequest) (models.DownstreamRequest, error) {
+	var err error
+	if request.Headers, err = jsonMap(request.Headers); err != nil {
+		return models.DownstreamRequest{}, err
+	}
+	if request.Body, err = jsonMap(request.Body); err != nil {
+		return models.DownstreamRequest{}, err
+	}
+	request.ReceivedAt = request.ReceivedAt.UTC()
+	return request, nil
+}
+
+func normalizeUpstreamAttempt(attempt models.UpstreamAttempt) (models.UpstreamAttempt, error) {
+	var err error
+	if attempt.RequestHeaders, err = jsonMap(attempt.RequestHeaders); err != nil {
+		return models.UpstreamAttempt{}, err
+	}
+	if attempt.RequestBody, err = jsonMap(attempt.RequestBody); err != nil {
+		return models.UpstreamAttempt{}, err
+	}
+	if attempt.ResponseHeaders, err = jsonMap(attempt.ResponseHeaders); err != nil {
+		return models.UpstreamAttempt{}, err
+	}
+	if attempt.ResponseBody, err = jsonValue(attempt.ResponseBody); err != nil {
+		return models.UpstreamAttempt{}, err
+	}
+	attempt.Error = stringPtr(attempt.Error)
+	attempt.SentAt = attempt.SentAt.UTC()
+	return attempt, nil
+}
+
+func normalizeAuditEvent(event models.AuditEvent) (models.AuditEvent, error) {
+	var err error
+	if event.Metadata, err = jsonMap(event.Metadata); err != nil {
+		return models.AuditEvent{}, err
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	return event, nil
+}
+
+func normalizeIdempotencyRecord(record models.IdempotencyRecord) (models.IdempotencyRecord, error) {
+	var err error
+	if record.ResponseBody, err = jsonValue(record.ResponseBody); err != nil {
+		return models.IdempotencyRecord{}, err
+	}
+	record.CreatedAt = record.CreatedAt.UTC()
+	record.ExpiresAt = record.ExpiresAt.UTC()
+	return record, nil
+}
+
+// The clone functions copy everything a caller could mutate through a
+// returned model.
+
+func cloneAPIKey(key models.APIKey) models.APIKey {
+	key.CreatedAt = key.CreatedAt.UTC()
+	key.UpdatedAt = key.UpdatedAt.UTC()
+	if key.ExpiresAt != nil {
+		key.ExpiresAt = timePtr(*key.ExpiresAt)
+	}
+	if key.RevokedAt != nil {
+		key.RevokedAt = timePtr(*key.RevokedAt)
+	}
+	key.RotationOf = stringPtr(key.RotationOf)
+	if key.LastUsedAt != nil {
+		key.LastUsedAt = timePtr(*key.LastUsedAt)
+	}
+	key.Labels = cloneLabels(key.Labels)
+	return key
+}
+
+// cloneLabels drops empty label sets, which the SQL backends store as NULL.
+func cloneLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	return maps.Clone(labels)
+}
+
+func cloneDownstreamRequest(request models.DownstreamRequest) models.DownstreamRequest {
+	request.Headers = cloneMap(request.Headers)
+	request.Body = cloneMap(request.Body)
+	return request
+}
+
+func cloneUpstreamAttempt(attempt models.UpstreamAttempt) models.UpstreamAttempt {
+	attempt.RequestHeaders = cloneMap(attempt.RequestHeaders)
+	attempt.RequestBody = cloneMap(attempt.RequestBody)
+	attempt.ResponseHeaders = cloneMap(attempt.ResponseHeaders)
+	attempt.ResponseBody = cloneValue(attempt.ResponseBody)
+	attempt.Error = stringPtr(attempt.Error)
+	return attempt
+}
+
+func cloneAuditEvent(event models.AuditEvent) models.AuditEvent {
+	event.Metadata = cloneMap(event.Metadata)
+	return event
+}
+
+func cloneIdempotencyRecord(record models.IdempotencyRecord) models.IdempotencyRecord {
+	record.ResponseBody = cloneValue(record.ResponseBody)
+	return record
+}
+
+func jsonMap(m map[string]any) (map[string]any, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func jsonValue(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+// cloneValue deep-copies a value decoded by encoding/json.
+func cloneValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		return cloneMap(t)
+	case []any:
+		out := make([]any, len(t))
+		for i := range t {
+			out[i] = cloneValue(t[i])
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
+
+func stringPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
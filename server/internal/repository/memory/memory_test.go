@@ -0,0 +1,312 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+func requireConstraintErr(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrConstraint) {
+		t.Fatalf("expected constraint error, got: %v", err)
+	}
+}
+
+func TestRepositories_NotFoundAndConstraints(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+
+	if _, err := repos.APIKeys.GetByAccessKey(ctx, "missing"); !repository.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := repos.APIKeys.Revoke(ctx, "missing", now); !repository.IsNotFound(err) {
+		t.Fatalf("expected not found on revoke, got %v", err)
+	}
+	if _, err := repos.DownstreamRequests.GetByRequestID(ctx, "missing"); !repository.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := repos.IdempotencyRecords.GetByKey(ctx, "missing"); !repository.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	key := models.APIKey{ID: "k1", AccessKey: "ak_1", SecretKeyHash: "hash", SecretKeyCiphertext: "v1:test", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now}
+	if err := repos.APIKeys.Create(ctx, key); err != nil {
+		t.Fatalf("Create key: %v", err)
+	}
+	dup := key
+	dup.ID = "k2"
+	requireConstraintErr(t, repos.APIKeys.Create(ctx, dup))
+
+	req := models.DownstreamRequest{ID: "d1", RequestID: "r1", APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", ReceivedAt: now}
+	if err := repos.DownstreamRequests.Create(ctx, req); err != nil {
+		t.Fatalf("Create downstream: %v", err)
+	}
+	req.ID = "d2"
+	requireConstraintErr(t, repos.DownstreamRequests.Create(ctx, req))
+
+	attempt := models.UpstreamAttempt{ID: "u1", RequestID: "r1", AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", SentAt: now}
+	if err := repos.UpstreamAttempts.Create(ctx, attempt); err != nil {
+		t.Fatalf("Create attempt: %v", err)
+	}
+	attempt.ID = "u2"
+	requireConstraintErr(t, repos.UpstreamAttempts.Create(ctx, attempt))
+
+	record := models.IdempotencyRecord{ID: "i1", IdempotencyKey: "idem", RequestHash: "h", ResponseStatus: 200, ResponseBody: []byte(`{}`), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := repos.IdempotencyRecords.Create(ctx, record); err != nil {
+		t.Fatalf("Create record: %v", err)
+	}
+	record.ID = "i2"
+	requireConstraintErr(t, repos.IdempotencyRecords.Create(ctx, record))
+
+	// Expiring the record frees its key.
+	if n, err := repos.IdempotencyRecords.DeleteExpired(ctx, now.Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected 1 expired record, got %d (%v)", n, err)
+	}
+	if err := repos.IdempotencyRecords.Create(ctx, record); err != nil {
+		t.Fatalf("Create after expiry: %v", err)
+	}
+}
+
+func TestRepositories_ReturnCopies(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.FixedZone("CST", 8*3600))
+
+	req := models.DownstreamRequest{ID: "d1", RequestID: "r1", APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", Body: map[string]any{"frames": 241, "tags": []any{"a"}}, ReceivedAt: now}
+	if err := repos.DownstreamRequests.Create(ctx, req); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	req.Body["frames"] = 1
+
+	got, err := repos.DownstreamRequests.GetByRequestID(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetByRequestID: %v", err)
+	}
+	// Like a database, numbers come back as float64 and times as UTC.
+	if got.Body["frames"] != float64(241) || got.ReceivedAt.Location() != time.UTC || !got.ReceivedAt.Equal(now) {
+		t.Fatalf("unexpected stored request: %+v", got)
+	}
+	got.Body["tags"].([]any)[0] = "changed"
+	again, err := repos.DownstreamRequests.GetByRequestID(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetByRequestID: %v", err)
+	}
+	if again.Body["tags"].([]any)[0] != "a" {
+		t.Fatalf("expected the stored body to be unaffected by callers, got %+v", again.Body)
+	}
+}
+
+func TestAuditEventRepo_HashChainAndCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+
+	if _, err := repos.AuditEvents.ChainHead(ctx); !repository.IsNotFound(err) {
+		t.Fatalf("expected empty chain to be not found, got %v", err)
+	}
+
+	base := time.Date(2026, 2, 24, 3, 4, 5, 123456789, time.UTC)
+	for i := 1; i <= 3; i++ {
+		e := models.AuditEvent{
+			ID:        fmt.Sprintf("a%d", i),
+			RequestID: "req-1",
+			EventType: models.EventTypeUpstreamResponse,
+			Action:    "relay_call",
+			Resource:  "relay.call",
+			Metadata:  map[string]any{"response_status": 200, "latency_ms": int64(i), "ratio": 0.5},
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+		}
+		if err := repos.AuditEvents.Create(ctx, &e); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	requireConstraintErr(t, repos.AuditEvents.Create(ctx, &models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeUpstreamResponse, Action: "relay_call", Resource: "relay.call", CreatedAt: base}))
+
+	chain, err := repos.AuditEvents.ListChain(ctx, 0, 10)
+	if err != nil || len(chain) != 3 {
+		t.Fatalf("expected 3 chained events, got %d (%v)", len(chain), err)
+	}
+	prevHash := ""
+	for i, e := range chain {
+		if e.Sequence != int64(i+1) || e.PrevHash != prevHash {
+			t.Fatalf("unexpected chain position for %s: seq=%d prev=%q", e.ID, e.Sequence, e.PrevHash)
+		}
+		if h, err := e.ChainHash(); err != nil || h != e.Hash {
+			t.Fatalf("hash for %s does not survive a round trip: %q vs %q (%v)", e.ID, h, e.Hash, err)
+		}
+		prevHash = e.Hash
+	}
+	if page, err := repos.AuditEvents.ListChain(ctx, 2, 10); err != nil || len(page) != 1 || page[0].ID != "a3" {
+		t.Fatalf("expected ListChain to page after sequence 2, got %v (%v)", page, err)
+	}
+	head, err := repos.AuditEvents.ChainHead(ctx)
+	if err != nil || head.ID != "a3" {
+		t.Fatalf("expected head a3, got %v (%v)", head.ID, err)
+	}
+
+	cp := models.AuditCheckpoint{ID: "acp_1", Sequence: head.Sequence, Hash: head.Hash, CreatedAt: base.Add(time.Minute)}
+	cp.MAC = cp.ComputeMAC([]byte("0123456789abcdef0123456789abcdef"))
+	if err := repos.AuditEvents.CreateCheckpoint(ctx, cp); err != nil {
+		t.Fatalf("CreateCheckpoint: %v", err)
+	}
+	latest, err := repos.AuditEvents.LatestCheckpoint(ctx)
+	if err != nil || latest.ID != "acp_1" || latest.MAC != latest.ComputeMAC([]byte("0123456789abcdef0123456789abcdef")) {
+		t.Fatalf("unexpected latest checkpoint %+v (%v)", latest, err)
+	}
+}
+
+func TestRepositories_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+
+	const workers = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every worker races for the same access key; only one may win.
+			key := models.APIKey{ID: fmt.Sprintf("k%d", i), AccessKey: "ak_shared", SecretKeyHash: "hash", SecretKeyCiphertext: "v1:test", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now}
+			if err := repos.APIKeys.Create(ctx, key); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrConstraint) {
+				t.Errorf("Create key %d: %v", i, err)
+			}
+			event := models.AuditEvent{ID: fmt.Sprintf("a%d", i), RequestID: "req-1", EventType: models.EventTypeUpstreamResponse, Action: "relay_call", Resource: "relay.call", CreatedAt: now}
+			if err := repos.AuditEvents.Create(ctx, &event); err != nil {
+				t.Errorf("Create event %d: %v", i, err)
+			}
+			if _, err := repos.AuditEvents.ListChain(ctx, 0, workers); err != nil {
+				t.Errorf("ListChain: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("expected exactly one key to win the access key, got %d", created)
+	}
+	chain, err := repos.AuditEvents.ListChain(ctx, 0, workers)
+	if err != nil || len(chain) != workers {
+		t.Fatalf("expected %d chained events, got %d (%v)", workers, len(chain), err)
+	}
+	for i, e := range chain {
+		if e.Sequence != int64(i+1) || (i > 0 && e.PrevHash != chain[i-1].Hash) {
+			t.Fatalf("chain broken at %s: seq=%d", e.ID, e.Sequence)
+		}
+	}
+}
+
+func TestUsageRepo_SummarizeSubmits(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	errStr := "upstream 500"
+	submit := func(id, apiKeyID string, at time.Time, body map[string]any, status int, upstreamErr *string) {
+		t.Helper()
+		req := models.DownstreamRequest{ID: "d-" + id, RequestID: id, APIKeyID: apiKeyID, Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", Body: body, ReceivedAt: at}
+		if err := repos.DownstreamRequests.Create(ctx, req); err != nil {
+			t.Fatalf("Create downstream %s: %v", id, err)
+		}
+		if status == 0 {
+			return
+		}
+		attempt := models.UpstreamAttempt{ID: "u-" + id, RequestID: id, AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: status, Error: upstreamErr, SentAt: at}
+		if err := repos.UpstreamAttempts.Create(ctx, attempt); err != nil {
+			t.Fatalf("Create attempt %s: %v", id, err)
+		}
+	}
+	video := map[string]any{"req_key": "jimeng_t2v_v30", "frames": 241}
+	image := map[string]any{"req_key": "jimeng_t2i_v40", "width": 2048, "height": 2048}
+
+	submit("r1", "k1", day.Add(time.Hour), video, 200, nil)
+	submit("r2", "k1", day.Add(2*time.Hour), video, 200, nil)
+	submit("r3", "k1", day.Add(3*time.Hour), map[string]any{"req_key": "jimeng_t2v_v30"}, 200, nil)
+	submit("r4", "k2", day.Add(25*time.Hour), image, 200, nil)
+	submit("r5", "k1", day.Add(4*time.Hour), video, 500, &errStr)
+	submit("r6", "k1", day.Add(5*time.Hour), video, 0, nil)
+	submit("r7", "k1", day.Add(49*time.Hour), video, 200, nil)
+
+	got, err := repos.Usage.SummarizeSubmits(ctx, day, day.Add(48*time.Hour), "")
+	if err != nil {
+		t.Fatalf("SummarizeSubmits: %v", err)
+	}
+	want := []models.SubmitUsage{
+		{APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Day: "2026-03-01", Count: 1},
+		{APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Day: "2026-03-01", Frames: 241, Count: 2},
+		{APIKeyID: "k2", ReqKey: "jimeng_t2i_v40", Day: "2026-03-02", Width: 2048, Height: 2048, Count: 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected usage:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBulkRepo_ImportSkipsExistingRows(t *testing.T) {
+	ctx := context.Background()
+	repos := New()
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+
+	keys := []models.APIKey{
+		{ID: "k1", AccessKey: "ak_1", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now},
+		{ID: "k2", AccessKey: "ak_2", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now},
+	}
+	if n, err := repos.Bulk.ImportAPIKeys(ctx, keys); err != nil || n != 2 {
+		t.Fatalf("expected 2 imported keys, got %d (%v)", n, err)
+	}
+	keys = append(keys, models.APIKey{ID: "k3", AccessKey: "ak_3", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now})
+	if n, err := repos.Bulk.ImportAPIKeys(ctx, keys); err != nil || n != 1 {
+		t.Fatalf("expected only the new key to be imported, got %d (%v)", n, err)
+	}
+
+	page, err := repos.Bulk.ExportAPIKeys(ctx, "k1", 1)
+	if err != nil || len(page) != 1 || page[0].ID != "k2" {
+		t.Fatalf("expected the page after k1 to be k2, got %+v (%v)", page, err)
+	}
+	if n, err := repos.Bulk.CountRows(ctx, repository.TableAPIKeys); err != nil || n != 3 {
+		t.Fatalf("expected 3 rows, got %d (%v)", n, err)
+	}
+
+	day := time.Date(2026, 2, 24, 0, 0, 0, 0, time.UTC)
+	counts := []models.APIKeyDailyRequests{{APIKeyID: "k1", Day: day, Requests: 2}, {APIKeyID: "k2", Day: day, Requests: 3}}
+	if n, err := repos.Bulk.ImportAPIKeyDailyRequests(ctx, counts); err != nil || n != 2 {
+		t.Fatalf("expected 2 imported daily counts, got %d (%v)", n, err)
+	}
+	if n, err := repos.Bulk.ImportAPIKeyDailyRequests(ctx, counts); err != nil || n != 0 {
+		t.Fatalf("expected existing daily counts to be skipped, got %d (%v)", n, err)
+	}
+	daily, err := repos.Bulk.ExportAPIKeyDailyRequests(ctx, repository.APIKeyDayKey{APIKeyID: "k1", Day: day}, 10)
+	if err != nil || len(daily) != 1 || daily[0] != counts[1] {
+		t.Fatalf("expected the page after k1 to be k2's count, got %+v (%v)", daily, err)
+	}
+
+	rollups := []models.StatsRollup{
+		{Hour: now.Truncate(time.Hour), APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "5xx"},
+		{Hour: now.Truncate(time.Hour), APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "2xx"},
+	}
+	if n, err := repos.Bulk.ImportStatsRollups(ctx, append(rollups, rollups[0])); err != nil || n != 2 {
+		t.Fatalf("expected 2 imported rollups, got %d (%v)", n, err)
+	}
+	exported, err := repos.Bulk.ExportStatsRollups(ctx, repository.StatsRollupKey{}, 1)
+	if err != nil || len(exported) != 1 || exported[0].StatusClass != "2xx" {
+		t.Fatalf("expected rollups in key order, got %+v (%v)", exported, err)
+	}
+	if n, err := repos.Bulk.ImportStatsRollupWatermark(ctx, now); err != nil || n != 1 {
+		t.Fatalf("expected the watermark to be imported, got %d (%v)", n, err)
+	}
+	if n, err := repos.Bulk.CountRows(ctx, repository.TableStatsRollupWatermark); err != nil || n != 1 {
+		t.Fatalf("expected 1 watermark row, got %d (%v)", n, err)
+	}
+}
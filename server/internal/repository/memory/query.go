@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+func (r *AuditEventRepo) Query(_ context.Context, query repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	out := r.s.matchAuditEvents(query)
+	sortPage(out, query.Order, func(e models.AuditEvent) (time.Time, string) { return e.CreatedAt, e.ID })
+	out = truncatePage(out, query.Limit)
+	for i := range out {
+		out[i] = cloneAuditEvent(out[i])
+	}
+	return out, nil
+}
+
+var _ repository.AuditEventCounter = (*AuditEventRepo)(nil)
+
+func (r *AuditEventRepo) CountByAPIKey(_ context.Context, query repository.AuditEventQuery) (map[string]int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	query.After = nil
+	out := map[string]int64{}
+	for _, e := range r.s.matchAuditEvents(query) {
+		var keyID string
+		if id, ok := r.s.downstreamRequestIDs[e.RequestID]; ok {
+			keyID = r.s.downstream[id].APIKeyID
+		}
+		out[keyID]++
+	}
+	return out, nil
+}
+
+// matchAuditEvents returns the events matching query in insertion order,
+// without cloning them. The caller holds the read lock.
+func (s *store) matchAuditEvents(query repository.AuditEventQuery) []models.AuditEvent {
+	var keyRequests map[string]bool
+	if query.APIKeyID != "" || len(query.APIKeyIDs) > 0 {
+		keys := keySet(query.APIKeyIDs)
+		keyRequests = make(map[string]bool)
+		for _, request := range s.downstream {
+			if query.APIKeyID != "" && request.APIKeyID != query.APIKeyID {
+				continue
+			}
+			if keys != nil && !keys[request.APIKeyID] {
+				continue
+			}
+			keyRequests[request.RequestID] = true
+		}
+	}
+
+	var out []models.AuditEvent
+	for _, id := range s.auditOrder {
+		e := s.auditEvents[id]
+		switch {
+		case !inWindow(e.CreatedAt, query.From, query.To),
+			!afterCursor(e.CreatedAt, e.ID, query.Order, query.After),
+			keyRequests != nil && !keyRequests[e.RequestID],
+			query.Action != "" && e.Action != query.Action,
+			query.EventType != "" && e.EventType != query.EventType,
+			!statusInRange(e.Metadata["response_status"], query.MinStatus, query.MaxStatus):
+			continue
+		}
+		if query.HasError != nil {
+			hasError := e.EventType == models.EventTypeError || e.Metadata["error"] != nil
+			if hasError != *query.HasError {
+				continue
+			}
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func (r *DownstreamRequestRepo) List(_ context.Context, query repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	out := r.s.matchDownstreamRequests(query)
+	sortPage(out, query.Order, func(r models.DownstreamRequest) (time.Time, string) { return r.ReceivedAt, r.ID })
+	out = truncatePage(out, query.Limit)
+	for i := range out {
+		out[i] = cloneDownstreamRequest(out[i])
+	}
+	return out, nil
+}
+
+var _ repository.DownstreamRequestCounter = (*DownstreamRequestRepo)(nil)
+
+func (r *DownstreamRequestRepo) CountByAPIKey(_ context.Context, query repository.DownstreamRequestQuery) (map[string]int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	query.After = nil
+	out := map[string]int64{}
+	for _, request := range r.s.matchDownstreamRequests(query) {
+		out[request.APIKeyID]++
+	}
+	return out, nil
+}
+
+// matchDownstreamRequests returns the requests matching query, unordered and
+// without cloning them. The caller holds the read lock.
+func (s *store) matchDownstreamRequests(query repository.DownstreamRequestQuery) []models.DownstreamRequest {
+	var latest map[string]models.UpstreamAttempt
+	if query.MinStatus != 0 || query.MaxStatus != 0 || query.HasError != nil {
+		latest = make(map[string]models.UpstreamAttempt)
+		for _, attempt := range s.upstream {
+			if prev, ok := latest[attempt.RequestID]; !ok || attempt.AttemptNumber > prev.AttemptNumber {
+				latest[attempt.RequestID] = attempt
+			}
+		}
+	}
+
+	keys := keySet(query.APIKeyIDs)
+	var out []models.DownstreamRequest
+	for _, request := range s.downstream {
+		switch {
+		case !inWindow(request.ReceivedAt, query.From, query.To),
+			!afterCursor(request.ReceivedAt, request.ID, query.Order, query.After),
+			query.APIKeyID != "" && request.APIKeyID != query.APIKeyID,
+			keys != nil && !keys[request.APIKeyID],
+			query.Action != "" && request.Action != query.Action:
+			continue
+		}
+		if query.MinStatus != 0 || query.MaxStatus != 0 {
+			attempt, ok := latest[request.RequestID]
+			if !ok || !statusInRange(float64(attempt.ResponseStatus), query.MinStatus, query.MaxStatus) {
+				continue
+			}
+		}
+		if query.HasError != nil {
+			if hasError := latest[request.RequestID].Error != nil; hasError != *query.HasError {
+				continue
+			}
+		}
+		out = append(out, request)
+	}
+	return out
+}
+
+// keySet returns nil for an empty list, which matches every key.
+func keySet(ids []string) map[string]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func inWindow(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// afterCursor reports whether (t, id) comes strictly after the cursor in
+// order.
+func afterCursor(t time.Time, id string, order repository.SortOrder, after *repository.Cursor) bool {
+	if after == nil {
+		return true
+	}
+	cmp := t.Compare(after.Time)
+	if cmp == 0 {
+		cmp = strings.Compare(id, after.ID)
+	}
+	if order == repository.SortDesc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// statusInRange treats v as a JSON number; with either bound set, anything
+// else is out of range.
+func statusInRange(v any, min, max int) bool {
+	if min == 0 && max == 0 {
+		return true
+	}
+	status, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	return (min == 0 || status >= float64(min)) && (max == 0 || status <= float64(max))
+}
+
+func sortPage[T any](items []T, order repository.SortOrder, key func(T) (time.Time, string)) {
+	sort.Slice(items, func(i, j int) bool {
+		ti, idi := key(items[i])
+		tj, idj := key(items[j])
+		cmp := ti.Compare(tj)
+		if cmp == 0 {
+			cmp = strings.Compare(idi, idj)
+		}
+		if order == repository.SortDesc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
+func truncatePage[T any](items []T, limit int) []T {
+	if len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type StatsRollupRepo struct{ s *store }
+
+var _ repository.StatsRollupRepository = (*StatsRollupRepo)(nil)
+
+func (r *StatsRollupRepo) RollupSamples(_ context.Context, from, to time.Time) ([]models.RollupSample, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var out []models.RollupSample
+	for _, attempt := range r.s.upstream {
+		if attempt.SentAt.Before(from) || !attempt.SentAt.Before(to) {
+			continue
+		}
+		request, ok := r.s.downstream[r.s.downstreamRequestIDs[attempt.RequestID]]
+		if !ok {
+			continue
+		}
+		out = append(out, models.RollupSample{
+			APIKeyID:       request.APIKeyID,
+			Action:         request.Action,
+			AttemptNumber:  attempt.AttemptNumber,
+			ResponseStatus: attempt.ResponseStatus,
+			LatencyMs:      attempt.LatencyMs,
+			Failed:         attempt.Error != nil,
+			SentAt:         attempt.SentAt,
+		})
+	}
+	return out, nil
+}
+
+func (r *StatsRollupRepo) FirstSampleTime(context.Context) (time.Time, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var first time.Time
+	for _, attempt := range r.s.upstream {
+		if first.IsZero() || attempt.SentAt.Before(first) {
+			first = attempt.SentAt
+		}
+	}
+	if first.IsZero() {
+		return time.Time{}, repository.ErrNotFound
+	}
+	return first, nil
+}
+
+func (r *StatsRollupRepo) Watermark(context.Context) (time.Time, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return r.s.rollupWatermark, nil
+}
+
+func (r *StatsRollupRepo) ReplaceRollups(_ context.Context, from, to time.Time, rollups []models.StatsRollup) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	kept := make([]models.StatsRollup, 0, len(r.s.rollups)+len(rollups))
+	for _, rollup := range r.s.rollups {
+		if rollup.Hour.Before(from) || !rollup.Hour.Before(to) {
+			kept = append(kept, rollup)
+		}
+	}
+	seen := map[models.StatsRollup]bool{}
+	for _, rollup := range kept {
+		seen[rollupKey(rollup)] = true
+	}
+	for _, rollup := range rollups {
+		rollup.Hour = rollup.Hour.UTC()
+		if seen[rollupKey(rollup)] {
+			return uniqueErr("stats_rollups.hour, stats_rollups.api_key_id, stats_rollups.action, stats_rollups.status_class")
+		}
+		seen[rollupKey(rollup)] = true
+		kept = append(kept, rollup)
+	}
+	r.s.rollups = kept
+	if to.After(r.s.rollupWatermark) {
+		r.s.rollupWatermark = to.UTC()
+	}
+	return nil
+}
+
+// rollupKey reduces a rollup to its primary key columns.
+func rollupKey(rollup models.StatsRollup) models.StatsRollup {
+	return models.StatsRollup{
+		Hour:        rollup.Hour.UTC(),
+		APIKeyID:    rollup.APIKeyID,
+		Action:      rollup.Action,
+		StatusClass: rollup.StatusClass,
+	}
+}
+
+// rollupKeyLess orders rollups by their primary key columns.
+func rollupKeyLess(a, b models.StatsRollup) bool {
+	if !a.Hour.Equal(b.Hour) {
+		return a.Hour.Before(b.Hour)
+	}
+	if a.APIKeyID != b.APIKeyID {
+		return a.APIKeyID < b.APIKeyID
+	}
+	if a.Action != b.Action {
+		return a.Action < b.Action
+	}
+	return a.StatusClass < b.StatusClass
+}
+
+func (r *StatsRollupRepo) ListRollups(_ context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var out []models.StatsRollup
+	for _, rollup := range r.s.rollups {
+		if rollup.Hour.Before(from) || !rollup.Hour.Before(to) {
+			continue
+		}
+		if apiKeyID != "" && rollup.APIKeyID != apiKeyID {
+			continue
+		}
+		out = append(out, rollup)
+	}
+	sort.Slice(out, func(i, j int) bool { return rollupKeyLess(out[i], out[j]) })
+	return out, nil
+}
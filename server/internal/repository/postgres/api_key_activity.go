@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+var _ repository.APIKeyActivityRepository = (*apiKeyRepository)(nil)
+
+func (db *DB) APIKeyActivity() repository.APIKeyActivityRepository {
+	return &apiKeyRepository{pool: db.pool}
+}
+
+// RecordActivity locks key rows in batch order; callers that flush from
+// several replicas should sort batches by key id so they cannot deadlock.
+func (r *apiKeyRepository) RecordActivity(ctx context.Context, batches []models.APIKeyActivity) error {
+	if len(batches) == 0 {
+		return nil
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "begin api key activity transaction", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, b := range batches {
+		// Every SET expression reads the row as it was before the update, so
+		// last_used_ip is compared against the old last_used_at.
+		tag, err := tx.Exec(ctx, `UPDATE api_keys SET
+				request_count = request_count + $1,
+				last_used_ip = CASE WHEN last_used_at IS NULL OR last_used_at < $2 THEN $3 ELSE last_used_ip END,
+				last_used_at = GREATEST(last_used_at, $2)
+			WHERE id = $4`,
+			b.Requests, b.LastUsedAt.UTC(), b.LastUsedIP, b.APIKeyID)
+		if err != nil {
+			return internalerrors.New(internalerrors.ErrDatabaseError, "update api key activity", err)
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO api_key_daily_requests (api_key_id, day, requests) VALUES ($1, $2, $3)
+			ON CONFLICT (api_key_id, day) DO UPDATE SET requests = api_key_daily_requests.requests + EXCLUDED.requests`,
+			b.APIKeyID, b.Day.UTC().Truncate(24*time.Hour), b.Requests); err != nil {
+			return internalerrors.New(internalerrors.ErrDatabaseError, "add api key daily requests", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "commit api key activity", err)
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RequestCountsSince(ctx context.Context, since time.Time) (map[string]int64, error) {
+	rows, err := r.pool.Query(ctx, `SELECT api_key_id, SUM(requests)::BIGINT
+		FROM api_key_daily_requests
+		WHERE day >= $1
+		GROUP BY api_key_id`, since.UTC().Truncate(24*time.Hour))
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "sum api key daily requests", err)
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan api key daily requests", err)
+		}
+		out[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate api key daily requests", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,526 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type bulkRepository struct {
+	pool *pgxpool.Pool
+}
+
+func (r *bulkRepository) CountRows(ctx context.Context, table string) (int64, error) {
+	if !isBulkTable(table) {
+		return 0, internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("unknown table %q", table), nil)
+	}
+	var n int64
+	if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM `+table).Scan(&n); err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "count "+table, err)
+	}
+	return n, nil
+}
+
+func (r *bulkRepository) ExportAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
+		FROM api_keys WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export api keys", err)
+	}
+	defer rows.Close()
+
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		var key models.APIKey
+		var status string
+		var labels []byte
+		if err := rows.Scan(
+			&key.ID,
+			&key.AccessKey,
+			&key.SecretKeyHash,
+			&key.SecretKeyCiphertext,
+			&key.Description,
+			&key.CreatedAt,
+			&key.UpdatedAt,
+			&key.ExpiresAt,
+			&key.RevokedAt,
+			&key.RotationOf,
+			&key.ClientCertSubject,
+			&status,
+			&key.LastUsedAt,
+			&key.LastUsedIP,
+			&key.RequestCount,
+			&key.Owner,
+			&labels,
+		); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan api key", err)
+		}
+		key.Status = models.APIKeyStatus(status)
+		if key.Labels, err = decodeLabels(labels); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode api key labels", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate api keys", err)
+	}
+	return keys, nil
+}
+
+func (r *bulkRepository) ImportAPIKeys(ctx context.Context, keys []models.APIKey) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, key := range keys {
+		labels, err := labelsOrNull(key.Labels)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal api key labels", err)
+		}
+		batch.Queue(`INSERT INTO api_keys (
+			id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status,
+			last_used_at, last_used_ip, request_count, owner, labels
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
+		ON CONFLICT DO NOTHING`,
+			key.ID,
+			key.AccessKey,
+			key.SecretKeyHash,
+			key.SecretKeyCiphertext,
+			key.Description,
+			key.CreatedAt.UTC(),
+			key.UpdatedAt.UTC(),
+			utcOrNil(key.ExpiresAt),
+			utcOrNil(key.RevokedAt),
+			key.RotationOf,
+			key.ClientCertSubject,
+			string(key.Status),
+			utcOrNil(key.LastUsedAt),
+			key.LastUsedIP,
+			key.RequestCount,
+			key.Owner,
+			labels,
+		)
+	}
+	return r.importBatch(ctx, "import api keys", batch)
+}
+
+func (r *bulkRepository) ExportDownstreamRequests(ctx context.Context, afterID string, limit int) ([]models.DownstreamRequest, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at
+		FROM downstream_requests WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export downstream requests", err)
+	}
+	defer rows.Close()
+
+	requests := make([]models.DownstreamRequest, 0)
+	for rows.Next() {
+		var req models.DownstreamRequest
+		var action string
+		var headersBytes []byte
+		var bodyBytes []byte
+		if err := rows.Scan(
+			&req.ID,
+			&req.RequestID,
+			&req.APIKeyID,
+			&action,
+			&req.Method,
+			&req.Path,
+			&req.QueryString,
+			&headersBytes,
+			&bodyBytes,
+			&req.ClientIP,
+			&req.ReceivedAt,
+		); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan downstream request", err)
+		}
+		if req.Headers, err = decodeMap(headersBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode downstream headers", err)
+		}
+		if req.Body, err = decodeMap(bodyBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode downstream body", err)
+		}
+		req.Action = models.DownstreamAction(action)
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate downstream requests", err)
+	}
+	return requests, nil
+}
+
+func (r *bulkRepository) ImportDownstreamRequests(ctx context.Context, requests []models.DownstreamRequest) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, req := range requests {
+		headers, err := jsonbOrNull(req.Headers)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal downstream headers", err)
+		}
+		body, err := jsonbOrNull(req.Body)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal downstream body", err)
+		}
+		batch.Queue(`INSERT INTO downstream_requests (
+			id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT DO NOTHING`,
+			req.ID,
+			req.RequestID,
+			req.APIKeyID,
+			string(req.Action),
+			req.Method,
+			req.Path,
+			req.QueryString,
+			headers,
+			body,
+			req.ClientIP,
+			req.ReceivedAt.UTC(),
+		)
+	}
+	return r.importBatch(ctx, "import downstream requests", batch)
+}
+
+func (r *bulkRepository) ExportUpstreamAttempts(ctx context.Context, afterID string, limit int) ([]models.UpstreamAttempt, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, request_id, attempt_number, upstream_action,
+		request_headers, request_body, response_status, response_headers, response_body,
+		latency_ms, error, sent_at
+		FROM upstream_attempts WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export upstream attempts", err)
+	}
+	defer rows.Close()
+
+	attempts := make([]models.UpstreamAttempt, 0)
+	for rows.Next() {
+		var a models.UpstreamAttempt
+		var reqHeadersBytes []byte
+		var reqBodyBytes []byte
+		var respHeadersBytes []byte
+		var respBodyBytes []byte
+		if err := rows.Scan(
+			&a.ID,
+			&a.RequestID,
+			&a.AttemptNumber,
+			&a.UpstreamAction,
+			&reqHeadersBytes,
+			&reqBodyBytes,
+			&a.ResponseStatus,
+			&respHeadersBytes,
+			&respBodyBytes,
+			&a.LatencyMs,
+			&a.Error,
+			&a.SentAt,
+		); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan upstream attempt", err)
+		}
+		if a.RequestHeaders, err = decodeMap(reqHeadersBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode upstream request headers", err)
+		}
+		if a.RequestBody, err = decodeMap(reqBodyBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode upstream request body", err)
+		}
+		if a.ResponseHeaders, err = decodeMap(respHeadersBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode upstream response headers", err)
+		}
+		if a.ResponseBody, err = decodeAny(respBodyBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode upstream response body", err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate upstream attempts", err)
+	}
+	return attempts, nil
+}
+
+func (r *bulkRepository) ImportUpstreamAttempts(ctx context.Context, attempts []models.UpstreamAttempt) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, a := range attempts {
+		reqHeaders, err := jsonbOrNull(a.RequestHeaders)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal upstream request headers", err)
+		}
+		reqBody, err := jsonbOrNull(a.RequestBody)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal upstream request body", err)
+		}
+		respHeaders, err := jsonbOrNull(a.ResponseHeaders)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal upstream response headers", err)
+		}
+		respBody, err := jsonbOrNull(a.ResponseBody)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal upstream response body", err)
+		}
+		batch.Queue(`INSERT INTO upstream_attempts (
+			id, request_id, attempt_number, upstream_action, request_headers, request_body,
+			response_status, response_headers, response_body, latency_ms, error, sent_at
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		ON CONFLICT DO NOTHING`,
+			a.ID,
+			a.RequestID,
+			a.AttemptNumber,
+			a.UpstreamAction,
+			reqHeaders,
+			reqBody,
+			a.ResponseStatus,
+			respHeaders,
+			respBody,
+			a.LatencyMs,
+			a.Error,
+			a.SentAt.UTC(),
+		)
+	}
+	return r.importBatch(ctx, "import upstream attempts", batch)
+}
+
+func (r *bulkRepository) ExportAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
+		FROM audit_events WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export audit events", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+func (r *bulkRepository) ImportAuditEvents(ctx context.Context, events []models.AuditEvent) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, e := range events {
+		meta, err := jsonbOrNull(e.Metadata)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal audit metadata", err)
+		}
+		batch.Queue(`INSERT INTO audit_events (`+auditEventColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT DO NOTHING`,
+			e.ID,
+			e.RequestID,
+			string(e.EventType),
+			e.Actor,
+			e.Action,
+			e.Resource,
+			meta,
+			e.CreatedAt.UTC(),
+			e.Sequence,
+			e.PrevHash,
+			e.Hash,
+		)
+	}
+	return r.importBatch(ctx, "import audit events", batch)
+}
+
+func (r *bulkRepository) ExportAuditCheckpoints(ctx context.Context, afterID string, limit int) ([]models.AuditCheckpoint, error) {
+	return (&auditEventRepository{pool: r.pool}).listCheckpoints(ctx,
+		`SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+}
+
+func (r *bulkRepository) ImportAuditCheckpoints(ctx context.Context, checkpoints []models.AuditCheckpoint) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, c := range checkpoints {
+		batch.Queue(`INSERT INTO audit_checkpoints (id, sequence, hash, mac, created_at) VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT DO NOTHING`, c.ID, c.Sequence, c.Hash, c.MAC, c.CreatedAt.UTC())
+	}
+	return r.importBatch(ctx, "import audit checkpoints", batch)
+}
+
+func (r *bulkRepository) ExportIdempotencyRecords(ctx context.Context, afterID string, limit int) ([]models.IdempotencyRecord, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, idempotency_key, request_hash, response_status, response_body, created_at, expires_at
+		FROM idempotency_records WHERE id > $1 ORDER BY id ASC LIMIT $2`, afterID, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export idempotency records", err)
+	}
+	defer rows.Close()
+
+	records := make([]models.IdempotencyRecord, 0)
+	for rows.Next() {
+		var rec models.IdempotencyRecord
+		var bodyBytes []byte
+		if err := rows.Scan(
+			&rec.ID,
+			&rec.IdempotencyKey,
+			&rec.RequestHash,
+			&rec.ResponseStatus,
+			&bodyBytes,
+			&rec.CreatedAt,
+			&rec.ExpiresAt,
+		); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan idempotency record", err)
+		}
+		if rec.ResponseBody, err = decodeAny(bodyBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode idempotency response body", err)
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate idempotency records", err)
+	}
+	return records, nil
+}
+
+func (r *bulkRepository) ImportIdempotencyRecords(ctx context.Context, records []models.IdempotencyRecord) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, rec := range records {
+		body, err := jsonbOrNull(rec.ResponseBody)
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrValidationFailed, "marshal idempotency response body", err)
+		}
+		batch.Queue(`INSERT INTO idempotency_records (
+			id, idempotency_key, request_hash, response_status, response_body, created_at, expires_at
+		) VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT DO NOTHING`,
+			rec.ID,
+			rec.IdempotencyKey,
+			rec.RequestHash,
+			rec.ResponseStatus,
+			body,
+			rec.CreatedAt.UTC(),
+			rec.ExpiresAt.UTC(),
+		)
+	}
+	return r.importBatch(ctx, "import idempotency records", batch)
+}
+
+func (r *bulkRepository) ExportAPIKeyDailyRequests(ctx context.Context, after repository.APIKeyDayKey, limit int) ([]models.APIKeyDailyRequests, error) {
+	rows, err := r.pool.Query(ctx, `SELECT api_key_id, day, requests
+		FROM api_key_daily_requests
+		WHERE (api_key_id, day) > ($1, $2)
+		ORDER BY api_key_id ASC, day ASC
+		LIMIT $3`, after.APIKeyID, after.Day.UTC().Truncate(24*time.Hour), limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export api key daily requests", err)
+	}
+	defer rows.Close()
+
+	counts := make([]models.APIKeyDailyRequests, 0)
+	for rows.Next() {
+		var c models.APIKeyDailyRequests
+		if err := rows.Scan(&c.APIKeyID, &c.Day, &c.Requests); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan api key daily requests", err)
+		}
+		c.Day = c.Day.UTC()
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate api key daily requests", err)
+	}
+	return counts, nil
+}
+
+func (r *bulkRepository) ImportAPIKeyDailyRequests(ctx context.Context, counts []models.APIKeyDailyRequests) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, c := range counts {
+		batch.Queue(`INSERT INTO api_key_daily_requests (api_key_id, day, requests) VALUES ($1,$2,$3)
+		ON CONFLICT DO NOTHING`, c.APIKeyID, c.Day.UTC().Truncate(24*time.Hour), c.Requests)
+	}
+	return r.importBatch(ctx, "import api key daily requests", batch)
+}
+
+func (r *bulkRepository) ExportStatsRollups(ctx context.Context, after repository.StatsRollupKey, limit int) ([]models.StatsRollup, error) {
+	rows, err := r.pool.Query(ctx, `SELECT hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		FROM stats_rollups
+		WHERE (hour, api_key_id, action, status_class) > ($1, $2, $3, $4)
+		ORDER BY hour ASC, api_key_id ASC, action ASC, status_class ASC
+		LIMIT $5`, after.Hour.UTC(), after.APIKeyID, after.Action, after.StatusClass, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "export stats rollups", err)
+	}
+	defer rows.Close()
+
+	rollups := make([]models.StatsRollup, 0)
+	for rows.Next() {
+		var s models.StatsRollup
+		var action string
+		if err := rows.Scan(&s.Hour, &s.APIKeyID, &action, &s.StatusClass, &s.Attempts, &s.Retries, &s.Errors,
+			&s.LatencySumMs, &s.LatencyP50Ms, &s.LatencyP95Ms, &s.LatencyMaxMs); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan stats rollup", err)
+		}
+		s.Action = models.DownstreamAction(action)
+		s.Hour = s.Hour.UTC()
+		rollups = append(rollups, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate stats rollups", err)
+	}
+	return rollups, nil
+}
+
+func (r *bulkRepository) ImportStatsRollups(ctx context.Context, rollups []models.StatsRollup) (int64, error) {
+	batch := &pgx.Batch{}
+	for _, s := range rollups {
+		batch.Queue(`INSERT INTO stats_rollups (
+			hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT DO NOTHING`,
+			s.Hour.UTC(), s.APIKeyID, string(s.Action), s.StatusClass, s.Attempts, s.Retries, s.Errors,
+			s.LatencySumMs, s.LatencyP50Ms, s.LatencyP95Ms, s.LatencyMaxMs,
+		)
+	}
+	return r.importBatch(ctx, "import stats rollups", batch)
+}
+
+func (r *bulkRepository) ExportStatsRollupWatermark(ctx context.Context) (time.Time, error) {
+	return (&statsRollupRepository{pool: r.pool}).Watermark(ctx)
+}
+
+func (r *bulkRepository) ImportStatsRollupWatermark(ctx context.Context, watermark time.Time) (int64, error) {
+	batch := &pgx.Batch{}
+	if !watermark.IsZero() {
+		batch.Queue(`INSERT INTO stats_rollup_state (id, watermark) VALUES (1, $1)
+		ON CONFLICT DO NOTHING`, watermark.UTC())
+	}
+	return r.importBatch(ctx, "import stats rollup watermark", batch)
+}
+
+// importBatch sends batch in one transaction and counts the rows that were
+// actually inserted.
+func (r *bulkRepository) importBatch(ctx context.Context, op string, batch *pgx.Batch) (int64, error) {
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, op+": begin transaction", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			return
+		}
+	}()
+
+	results := tx.SendBatch(ctx, batch)
+	var inserted int64
+	for i := 0; i < batch.Len(); i++ {
+		tag, err := results.Exec()
+		if err != nil {
+			_ = results.Close()
+			return 0, internalerrors.New(internalerrors.ErrDatabaseError, op, err)
+		}
+		inserted += tag.RowsAffected()
+	}
+	if err := results.Close(); err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, op, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, op+": commit", err)
+	}
+	return inserted, nil
+}
+
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func isBulkTable(table string) bool {
+	for _, t := range repository.BulkTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+//go:build integration
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/jimeng-relay/server/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		db := openIntegrationDB(t)
+		return repotest.Repositories{
+			APIKeys:            db.APIKeys(),
+			DownstreamRequests: db.DownstreamRequests(),
+			UpstreamAttempts:   db.UpstreamAttempts(),
+			AuditEvents:        db.AuditEvents(),
+			IdempotencyRecords: db.IdempotencyRecords(),
+			SeenSignatures:     db.SeenSignatures(),
+			StatsRollups:       db.StatsRollups(),
+			APIKeyActivity:     db.APIKeyActivity(),
+			APIKeyMetadata:     db.APIKeyMetadata(),
+		}
+	})
+}
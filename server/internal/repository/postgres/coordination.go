@@ -0,0 +1,245 @@
+package postgres
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+const (
+	defaultCoordinationPollInterval = 100 * time.Millisecond
+	defaultCoordinationMaxConns     = 4
+	coordinationReleaseTimeout      = 5 * time.Second
+
+	coordinationLockPrefix = "jimeng-relay:"
+	submitClockName        = "upstream_submit"
+)
+
+// CoordinatorOptions tunes the advisory-lock coordinator. Zero values use defaults.
+type CoordinatorOptions struct {
+	// PollInterval is how long AcquireGlobalSlot waits between lock attempts
+	// when every cluster-wide slot is taken.
+	PollInterval time.Duration
+	// MaxConns sizes each of the two lock pools. Set it to the local upstream
+	// concurrency limit so slot holders never wait for a connection.
+	MaxConns int32
+	Sleep    func(context.Context, time.Duration) error
+}
+
+// Coordinator enforces upstream limits across relay replicas sharing one Postgres.
+//
+// Global and per-key slots are session-level advisory locks: each held slot pins
+// one connection until released, and a crashed replica's slots are freed by
+// Postgres as soon as its connections drop. The locks live on two pools of their
+// own, one for key slots and one for global slots, so holding them never starves
+// the repositories. Callers take the key slot before the global slot; with a
+// pool per kind a key holder only ever waits on the global pool, whose holders
+// wait on nothing, so a small MaxConns delays requests but cannot deadlock them.
+//
+// The submit interval is a row in relay_coordination stamped with the database
+// clock, so replica clock skew does not matter.
+type Coordinator struct {
+	pool         *pgxpool.Pool
+	keyPool      *pgxpool.Pool
+	slotPool     *pgxpool.Pool
+	pollInterval time.Duration
+	sleep        func(context.Context, time.Duration) error
+}
+
+// Coordinator returns a cluster coordinator backed by this database. The lock
+// pools connect lazily; Close releases them.
+func (db *DB) Coordinator(opts CoordinatorOptions) (*Coordinator, error) {
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultCoordinationPollInterval
+	}
+	sleep := opts.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	maxConns := opts.MaxConns
+	if maxConns <= 0 {
+		maxConns = defaultCoordinationMaxConns
+	}
+	keyPool, err := db.lockPool(maxConns)
+	if err != nil {
+		return nil, err
+	}
+	slotPool, err := db.lockPool(maxConns)
+	if err != nil {
+		keyPool.Close()
+		return nil, err
+	}
+	return &Coordinator{pool: db.pool, keyPool: keyPool, slotPool: slotPool, pollInterval: pollInterval, sleep: sleep}, nil
+}
+
+func (db *DB) lockPool(maxConns int32) (*pgxpool.Pool, error) {
+	cfg := db.pool.Config()
+	cfg.MaxConns = maxConns
+	cfg.MinConns = 0
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "create coordination pool", err)
+	}
+	return pool, nil
+}
+
+// Close closes the lock pools, which drops any slot still held.
+func (c *Coordinator) Close() {
+	if c == nil {
+		return
+	}
+	c.keyPool.Close()
+	c.slotPool.Close()
+}
+
+// AcquireGlobalSlot polls the limit global slot locks until one is free.
+// The connection is returned to the pool between rounds so waiting requests do
+// not starve the pool.
+func (c *Coordinator) AcquireGlobalSlot(ctx context.Context, limit int) (func(), error) {
+	if limit <= 0 {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "global slot limit must be positive", nil)
+	}
+	for {
+		release, ok, err := c.tryGlobalSlots(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return release, nil
+		}
+		if err := c.sleep(ctx, c.pollInterval); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrUpstreamFailed, "context cancelled while waiting for cluster slot", err)
+		}
+	}
+}
+
+func (c *Coordinator) tryGlobalSlots(ctx context.Context, limit int) (func(), bool, error) {
+	conn, err := c.slotPool.Acquire(ctx)
+	if err != nil {
+		return nil, false, internalerrors.New(internalerrors.ErrDatabaseError, "acquire coordination connection", err)
+	}
+	for slot := 0; slot < limit; slot++ {
+		name := globalSlotLockName(slot)
+		ok, err := tryAdvisoryLock(ctx, conn, name)
+		if err != nil {
+			conn.Release()
+			return nil, false, err
+		}
+		if ok {
+			return advisoryUnlocker(conn, name), true, nil
+		}
+	}
+	conn.Release()
+	return nil, false, nil
+}
+
+// AcquireKeySlot takes the key's advisory lock without waiting.
+func (c *Coordinator) AcquireKeySlot(ctx context.Context, apiKeyID string) (func(), error) {
+	apiKeyID = strings.TrimSpace(apiKeyID)
+	if apiKeyID == "" {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "apiKeyID is required", nil)
+	}
+	conn, err := c.keyPool.Acquire(ctx)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "acquire coordination connection", err)
+	}
+	name := keySlotLockName(apiKeyID)
+	ok, err := tryAdvisoryLock(ctx, conn, name)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !ok {
+		conn.Release()
+		return nil, internalerrors.New(internalerrors.ErrRateLimited, "api key concurrent limit exceeded", nil)
+	}
+	return advisoryUnlocker(conn, name), nil
+}
+
+// ReserveSubmit stamps the shared submit clock if minInterval has elapsed and
+// otherwise reports how long the caller should wait before trying again.
+func (c *Coordinator) ReserveSubmit(ctx context.Context, minInterval time.Duration) (time.Duration, error) {
+	if minInterval <= 0 {
+		return 0, nil
+	}
+	micros := minInterval.Microseconds()
+
+	var reserved bool
+	err := c.pool.QueryRow(ctx, `INSERT INTO relay_coordination (name, last_at) VALUES ($1, clock_timestamp())
+		ON CONFLICT (name) DO UPDATE SET last_at = EXCLUDED.last_at
+		WHERE relay_coordination.last_at + ($2::bigint * INTERVAL '1 microsecond') <= EXCLUDED.last_at
+		RETURNING true`, submitClockName, micros).Scan(&reserved)
+	if err == nil {
+		return 0, nil
+	}
+	if err != pgx.ErrNoRows {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "reserve submit interval", err)
+	}
+
+	var waitMicros int64
+	err = c.pool.QueryRow(ctx, `SELECT GREATEST(0, CEIL(EXTRACT(EPOCH FROM (last_at + ($2::bigint * INTERVAL '1 microsecond') - clock_timestamp())) * 1000000))::bigint
+		FROM relay_coordination WHERE name = $1`, submitClockName, micros).Scan(&waitMicros)
+	if err != nil && err != pgx.ErrNoRows {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "read submit interval", err)
+	}
+	wait := time.Duration(waitMicros) * time.Microsecond
+	if wait <= 0 {
+		// Another replica moved the clock between the two statements; retry soon.
+		wait = time.Millisecond
+	}
+	return wait, nil
+}
+
+func tryAdvisoryLock(ctx context.Context, conn *pgxpool.Conn, name string) (bool, error) {
+	var ok bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, name).Scan(&ok); err != nil {
+		return false, internalerrors.New(internalerrors.ErrDatabaseError, "try advisory lock", err)
+	}
+	return ok, nil
+}
+
+// advisoryUnlocker returns an idempotent release func. If the unlock fails the
+// connection is closed instead of returned to the pool, which drops the lock.
+func advisoryUnlocker(conn *pgxpool.Conn, name string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), coordinationReleaseTimeout)
+			defer cancel()
+			var unlocked bool
+			if err := conn.QueryRow(ctx, `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, name).Scan(&unlocked); err != nil || !unlocked {
+				_ = conn.Conn().Close(ctx)
+			}
+			conn.Release()
+		})
+	}
+}
+
+func globalSlotLockName(slot int) string {
+	return coordinationLockPrefix + "global:" + strconv.Itoa(slot)
+}
+
+func keySlotLockName(apiKeyID string) string {
+	return coordinationLockPrefix + "key:" + apiKeyID
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
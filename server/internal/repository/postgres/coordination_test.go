@@ -0,0 +1,138 @@
+//go:build integration
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+)
+
+func openCoordinator(t *testing.T, db *DB, opts CoordinatorOptions) *Coordinator {
+	t.Helper()
+	coord, err := db.Coordinator(opts)
+	if err != nil {
+		t.Fatalf("Coordinator: %v", err)
+	}
+	t.Cleanup(coord.Close)
+	return coord
+}
+
+func TestCoordinator_KeySlotIsExclusiveAcrossConnections(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+	coord := openCoordinator(t, db, CoordinatorOptions{})
+
+	release, err := coord.AcquireKeySlot(ctx, "key_coord")
+	if err != nil {
+		t.Fatalf("AcquireKeySlot: %v", err)
+	}
+	if _, err := coord.AcquireKeySlot(ctx, "key_coord"); internalerrors.GetCode(err) != internalerrors.ErrRateLimited {
+		t.Fatalf("expected RATE_LIMITED for held key, got %v", err)
+	}
+	release()
+	release()
+
+	again, err := coord.AcquireKeySlot(ctx, "key_coord")
+	if err != nil {
+		t.Fatalf("AcquireKeySlot after release: %v", err)
+	}
+	again()
+}
+
+func TestCoordinator_GlobalSlotsBlockUntilReleased(t *testing.T) {
+	db := openIntegrationDB(t)
+	coord := openCoordinator(t, db, CoordinatorOptions{PollInterval: 10 * time.Millisecond})
+
+	first, err := coord.AcquireGlobalSlot(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("AcquireGlobalSlot: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := coord.AcquireGlobalSlot(waitCtx, 1); err == nil {
+		t.Fatalf("expected second slot acquisition to time out")
+	}
+
+	first()
+	second, err := coord.AcquireGlobalSlot(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("AcquireGlobalSlot after release: %v", err)
+	}
+	second()
+}
+
+func TestCoordinator_ReserveSubmitEnforcesInterval(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+	if _, err := db.pool.Exec(ctx, `DELETE FROM relay_coordination`); err != nil {
+		t.Fatalf("reset relay_coordination: %v", err)
+	}
+	coord := openCoordinator(t, db, CoordinatorOptions{})
+
+	wait, err := coord.ReserveSubmit(ctx, time.Minute)
+	if err != nil || wait != 0 {
+		t.Fatalf("first ReserveSubmit: wait=%v err=%v", wait, err)
+	}
+	wait, err = coord.ReserveSubmit(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("second ReserveSubmit: %v", err)
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Fatalf("expected wait within (0, 1m], got %v", wait)
+	}
+}
+
+// Requests past the local gate take a key slot and then a global slot. With
+// one connection per lock pool and more requests than that, every request
+// must still finish instead of holding a key connection while waiting for
+// one another key holder has.
+func TestCoordinator_SmallPoolDoesNotDeadlock(t *testing.T) {
+	db := openIntegrationDB(t)
+	coord := openCoordinator(t, db, CoordinatorOptions{MaxConns: 1, PollInterval: 5 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const requests = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			releaseKey, err := coord.AcquireKeySlot(ctx, fmt.Sprintf("key_%d", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer releaseKey()
+			releaseSlot, err := coord.AcquireGlobalSlot(ctx, 2)
+			if err != nil {
+				errs <- err
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			releaseSlot()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected every request to get its slots, got %v", err)
+	}
+
+	// The repository pool is untouched while the lock pools are busy.
+	releaseKey, err := coord.AcquireKeySlot(ctx, "key_hold")
+	if err != nil {
+		t.Fatalf("AcquireKeySlot: %v", err)
+	}
+	defer releaseKey()
+	if err := db.Ping(ctx); err != nil {
+		t.Fatalf("Ping while a key slot is held: %v", err)
+	}
+}
@@ -3,22 +3,29 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/repository"
 )
 
 type migration struct {
-	version    int
-	name       string
-	statements []string
+	version int
+	name    string
+	up      []string
+	down    []string
 }
 
+// migrations are applied in version order. Never edit one that has shipped;
+// add a new version instead.
 var migrations = []migration{
 	{
 		version: 1,
 		name:    "init",
-		statements: []string{
+		up: []string{
 			`CREATE TABLE IF NOT EXISTS schema_migrations (
 				version INTEGER PRIMARY KEY,
 				name TEXT NOT NULL,
@@ -100,31 +107,340 @@ var migrations = []migration{
 			)`,
 			`CREATE INDEX IF NOT EXISTS idx_idempotency_records_expires_at ON idempotency_records(expires_at)`,
 		},
+		down: []string{
+			`DROP TABLE IF EXISTS idempotency_records`,
+			`DROP TABLE IF EXISTS audit_events`,
+			`DROP TABLE IF EXISTS upstream_attempts`,
+			`DROP TABLE IF EXISTS downstream_requests`,
+			`DROP TABLE IF EXISTS api_keys`,
+		},
 	},
 	{
 		version: 2,
 		name:    "api_key_secret_ciphertext",
-		statements: []string{
+		up: []string{
 			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS secret_key_ciphertext TEXT NOT NULL DEFAULT ''`,
 		},
+		down: []string{
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS secret_key_ciphertext`,
+		},
+	},
+	{
+		version: 3,
+		name:    "relay_coordination",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS relay_coordination (
+				name TEXT PRIMARY KEY,
+				last_at TIMESTAMPTZ NOT NULL
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS relay_coordination`,
+		},
+	},
+	{
+		version: 4,
+		name:    "seen_signatures",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS seen_signatures (
+				signature TEXT PRIMARY KEY,
+				expires_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_seen_signatures_expires_at ON seen_signatures(expires_at)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS seen_signatures`,
+		},
+	},
+	{
+		version: 5,
+		name:    "api_key_client_cert_subject",
+		up: []string{
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS client_cert_subject TEXT NOT NULL DEFAULT ''`,
+		},
+		down: []string{
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS client_cert_subject`,
+		},
+	},
+	{
+		version: 6,
+		name:    "audit_hash_chain",
+		up: []string{
+			`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS sequence BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS prev_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT ''`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_sequence ON audit_events(sequence) WHERE sequence > 0`,
+			`CREATE TABLE IF NOT EXISTS audit_checkpoints (
+				id TEXT PRIMARY KEY,
+				sequence BIGINT NOT NULL,
+				hash TEXT NOT NULL,
+				mac TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_sequence ON audit_checkpoints(sequence)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS audit_checkpoints`,
+			`DROP INDEX IF EXISTS idx_audit_events_sequence`,
+			`ALTER TABLE audit_events DROP COLUMN IF EXISTS hash`,
+			`ALTER TABLE audit_events DROP COLUMN IF EXISTS prev_hash`,
+			`ALTER TABLE audit_events DROP COLUMN IF EXISTS sequence`,
+		},
+	},
+	{
+		version: 7,
+		name:    "query_indexes",
+		up: []string{
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at_id ON audit_events(created_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_action_created_at ON audit_events(action, created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_event_type_created_at ON audit_events(event_type, created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_downstream_requests_received_at_id ON downstream_requests(received_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_downstream_requests_api_key_id_received_at ON downstream_requests(api_key_id, received_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_upstream_attempts_request_id_attempt_number ON upstream_attempts(request_id, attempt_number)`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS idx_upstream_attempts_request_id_attempt_number`,
+			`DROP INDEX IF EXISTS idx_downstream_requests_api_key_id_received_at`,
+			`DROP INDEX IF EXISTS idx_downstream_requests_received_at_id`,
+			`DROP INDEX IF EXISTS idx_audit_events_event_type_created_at`,
+			`DROP INDEX IF EXISTS idx_audit_events_action_created_at`,
+			`DROP INDEX IF EXISTS idx_audit_events_created_at_id`,
+		},
+	},
+	{
+		version: 8,
+		name:    "stats_rollups",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS stats_rollups (
+				hour TIMESTAMPTZ NOT NULL,
+				api_key_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				status_class TEXT NOT NULL,
+				attempts BIGINT NOT NULL,
+				retries BIGINT NOT NULL,
+				errors BIGINT NOT NULL,
+				latency_sum_ms BIGINT NOT NULL,
+				latency_p50_ms BIGINT NOT NULL,
+				latency_p95_ms BIGINT NOT NULL,
+				latency_max_ms BIGINT NOT NULL,
+				PRIMARY KEY (hour, api_key_id, action, status_class)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_stats_rollups_api_key_id_hour ON stats_rollups(api_key_id, hour)`,
+			`CREATE TABLE IF NOT EXISTS stats_rollup_state (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				watermark TIMESTAMPTZ NOT NULL
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS stats_rollup_state`,
+			`DROP TABLE IF EXISTS stats_rollups`,
+		},
+	},
+	{
+		version: 9,
+		name:    "api_key_activity",
+		up: []string{
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ`,
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_ip TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS request_count BIGINT NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS api_key_daily_requests (
+				api_key_id TEXT NOT NULL REFERENCES api_keys(id),
+				day DATE NOT NULL,
+				requests BIGINT NOT NULL,
+				PRIMARY KEY (api_key_id, day)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_api_key_daily_requests_day ON api_key_daily_requests(day)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS api_key_daily_requests`,
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS request_count`,
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS last_used_ip`,
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS last_used_at`,
+		},
+	},
+	{
+		version: 10,
+		name:    "api_key_labels",
+		up: []string{
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS labels JSONB`,
+		},
+		down: []string{
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS labels`,
+			`ALTER TABLE api_keys DROP COLUMN IF EXISTS owner`,
+		},
 	},
 }
 
+// migrationLockKey is the pg_advisory_xact_lock key that serializes
+// migrations across replicas ("jimeng" in ASCII).
+const migrationLockKey int64 = 0x6a696d656e67
+
+// Migrate applies every pending migration.
 func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
 	if pool == nil {
 		return internalerrors.New(internalerrors.ErrDatabaseError, "postgres pool is nil", nil)
 	}
+	_, err := (&migrator{pool: pool}).MigrateUp(ctx, 0)
+	return err
+}
+
+func (db *DB) Migrations() repository.Migrator {
+	return &migrator{pool: db.pool}
+}
+
+type migrator struct {
+	pool *pgxpool.Pool
+}
+
+type appliedMigration struct {
+	name      string
+	appliedAt time.Time
+}
+
+func (m *migrator) MigrateUp(ctx context.Context, version int) ([]int, error) {
+	if version != 0 && findMigration(version) == nil {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("unknown migration version %d", version), nil)
+	}
+	var done []int
+	err := m.withLock(ctx, func(tx pgx.Tx) error {
+		applied, err := listApplied(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if version != 0 && mig.version > version {
+				break
+			}
+			if _, ok := applied[mig.version]; ok {
+				continue
+			}
+			for i, stmt := range mig.up {
+				if _, err := tx.Exec(ctx, stmt); err != nil {
+					return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("apply migration %d (%s) statement %d", mig.version, mig.name, i+1), err)
+				}
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.version, mig.name); err != nil {
+				return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("record migration %d (%s)", mig.version, mig.name), err)
+			}
+			done = append(done, mig.version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
 
-	tx, err := pool.Begin(ctx)
+func (m *migrator) MigrateDown(ctx context.Context, steps int) ([]int, error) {
+	if steps < 1 {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "steps must be at least 1", nil)
+	}
+	var done []int
+	err := m.withLock(ctx, func(tx pgx.Tx) error {
+		applied, err := listApplied(ctx, tx)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if steps > len(versions) {
+			return internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("cannot revert %d migrations: only %d applied", steps, len(versions)), nil)
+		}
+		for _, v := range versions[:steps] {
+			mig := findMigration(v)
+			if mig == nil {
+				return internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("migration %d is not known to this build; revert it with the build that applied it", v), nil)
+			}
+			for i, stmt := range mig.down {
+				if _, err := tx.Exec(ctx, stmt); err != nil {
+					return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("revert migration %d (%s) statement %d", mig.version, mig.name, i+1), err)
+				}
+			}
+			if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, v); err != nil {
+				return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("unrecord migration %d (%s)", mig.version, mig.name), err)
+			}
+			done = append(done, v)
+		}
+		return nil
+	})
 	if err != nil {
-		return internalerrors.New(internalerrors.ErrDatabaseError, "begin migration transaction", err)
+		return nil, err
+	}
+	return done, nil
+}
+
+func (m *migrator) MigrationStatus(ctx context.Context) ([]repository.MigrationStatus, error) {
+	var applied map[int]appliedMigration
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "begin migration status transaction", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if err := createSchemaMigrations(ctx, tx); err != nil {
+		return nil, err
 	}
-	defer func() {
-		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
-			return
+	if applied, err = listApplied(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "commit migration status transaction", err)
+	}
+
+	out := make([]repository.MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		st := repository.MigrationStatus{Version: mig.version, Name: mig.name}
+		if a, ok := applied[mig.version]; ok {
+			at := a.appliedAt.UTC()
+			st.Applied, st.AppliedAt = true, &at
+			delete(applied, mig.version)
 		}
-	}()
+		out = append(out, st)
+	}
+	unknown := make([]int, 0, len(applied))
+	for v := range applied {
+		unknown = append(unknown, v)
+	}
+	sort.Ints(unknown)
+	for _, v := range unknown {
+		at := applied[v].appliedAt.UTC()
+		out = append(out, repository.MigrationStatus{Version: v, Name: applied[v].name, Applied: true, AppliedAt: &at})
+	}
+	return out, nil
+}
+
+// withLock runs fn in a transaction holding migrationLockKey, so a replica
+// that starts while another is migrating waits and then sees its work.
+func (m *migrator) withLock(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	if m == nil || m.pool == nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "postgres pool is nil", nil)
+	}
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "begin migration transaction", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "acquire migration lock", err)
+	}
+	if err := createSchemaMigrations(ctx, tx); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "commit migrations", err)
+	}
+	return nil
+}
 
+func createSchemaMigrations(ctx context.Context, tx pgx.Tx) error {
 	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
 		version INTEGER PRIMARY KEY,
 		name TEXT NOT NULL,
@@ -132,44 +448,35 @@ func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
 	)`); err != nil {
 		return internalerrors.New(internalerrors.ErrDatabaseError, "create schema_migrations", err)
 	}
+	return nil
+}
 
-	applied := map[int]bool{}
-	rows, err := tx.Query(ctx, `SELECT version FROM schema_migrations`)
+func listApplied(ctx context.Context, tx pgx.Tx) (map[int]appliedMigration, error) {
+	rows, err := tx.Query(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
 	if err != nil {
-		return internalerrors.New(internalerrors.ErrDatabaseError, "list applied migrations", err)
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list applied migrations", err)
 	}
+	defer rows.Close()
+	applied := map[int]appliedMigration{}
 	for rows.Next() {
 		var v int
-		if err := rows.Scan(&v); err != nil {
-			rows.Close()
-			return internalerrors.New(internalerrors.ErrDatabaseError, "scan applied migrations", err)
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.name, &a.appliedAt); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan applied migrations", err)
 		}
-		applied[v] = true
+		applied[v] = a
 	}
-	rows.Close()
 	if err := rows.Err(); err != nil {
-		return internalerrors.New(internalerrors.ErrDatabaseError, "iterate applied migrations", err)
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate applied migrations", err)
 	}
+	return applied, nil
+}
 
-	for _, m := range migrations {
-		if applied[m.version] {
-			continue
-		}
-		for i, stmt := range m.statements {
-			if stmt == "" {
-				continue
-			}
-			if _, err := tx.Exec(ctx, stmt); err != nil {
-				return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("apply migration %d (%s) statement %d", m.version, m.name, i+1), err)
-			}
+func findMigration(version int) *migration {
+	for i := range migrations {
+		if migrations[i].version == version {
+			return &migrations[i]
 		}
-		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
-			return internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("record migration %d (%s)", m.version, m.name), err)
-		}
-	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return internalerrors.New(internalerrors.ErrDatabaseError, "commit migrations", err)
 	}
 	return nil
 }
@@ -19,7 +19,19 @@ type DB struct {
 	pool *pgxpool.Pool
 }
 
+// OpenOptions tune OpenWithOptions.
+type OpenOptions struct {
+	// SkipMigrations opens the database as it is, for callers that apply
+	// migrations themselves through DB.Migrations.
+	SkipMigrations bool
+}
+
+// Open connects to databaseURL and applies any pending migrations.
 func Open(ctx context.Context, databaseURL string) (*DB, error) {
+	return OpenWithOptions(ctx, databaseURL, OpenOptions{})
+}
+
+func OpenWithOptions(ctx context.Context, databaseURL string, opts OpenOptions) (*DB, error) {
 	databaseURL = strings.TrimSpace(databaseURL)
 	if databaseURL == "" {
 		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "databaseURL is required", nil)
@@ -42,9 +54,11 @@ func Open(ctx context.Context, databaseURL string) (*DB, error) {
 		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "ping postgres", err)
 	}
 
-	if err := Migrate(ctx, pool); err != nil {
-		pool.Close()
-		return nil, err
+	if !opts.SkipMigrations {
+		if err := Migrate(ctx, pool); err != nil {
+			pool.Close()
+			return nil, err
+		}
 	}
 
 	return &DB{pool: pool}, nil
@@ -65,10 +79,29 @@ func (db *DB) Close() {
 	db.pool.Close()
 }
 
+// Ping checks that a pooled connection can reach the server.
+func (db *DB) Ping(ctx context.Context) error {
+	if db == nil || db.pool == nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "postgres pool is not initialized", nil)
+	}
+	if err := db.pool.Ping(ctx); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "ping postgres", err)
+	}
+	return nil
+}
+
 func (db *DB) APIKeys() repository.APIKeyRepository {
 	return &apiKeyRepository{pool: db.pool}
 }
 
+func (db *DB) APIKeySecrets() repository.APIKeySecretRepository {
+	return &apiKeyRepository{pool: db.pool}
+}
+
+func (db *DB) APIKeyMetadata() repository.APIKeyMetadataRepository {
+	return &apiKeyRepository{pool: db.pool}
+}
+
 func (db *DB) DownstreamRequests() repository.DownstreamRequestRepository {
 	return &downstreamRequestRepository{pool: db.pool}
 }
@@ -81,10 +114,30 @@ func (db *DB) AuditEvents() repository.AuditEventRepository {
 	return &auditEventRepository{pool: db.pool}
 }
 
+func (db *DB) AuditChain() repository.AuditChainRepository {
+	return &auditEventRepository{pool: db.pool}
+}
+
 func (db *DB) IdempotencyRecords() repository.IdempotencyRecordRepository {
 	return &idempotencyRecordRepository{pool: db.pool}
 }
 
+func (db *DB) SeenSignatures() repository.SeenSignatureRepository {
+	return &seenSignatureRepository{pool: db.pool}
+}
+
+func (db *DB) Usage() repository.UsageRepository {
+	return &usageRepository{pool: db.pool}
+}
+
+func (db *DB) StatsRollups() repository.StatsRollupRepository {
+	return &statsRollupRepository{pool: db.pool}
+}
+
+func (db *DB) Bulk() repository.BulkRepository {
+	return &bulkRepository{pool: db.pool}
+}
+
 type apiKeyRepository struct {
 	pool *pgxpool.Pool
 }
@@ -103,9 +156,15 @@ func (r *apiKeyRepository) Create(ctx context.Context, key models.APIKey) error
 		revokedAt = key.RevokedAt.UTC()
 	}
 
-	_, err := r.pool.Exec(ctx, `INSERT INTO api_keys (
-		id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
-	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
+	labels, err := labelsOrNull(key.Labels)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "marshal api key labels", err)
+	}
+
+	_, err = r.pool.Exec(ctx, `INSERT INTO api_keys (
+		id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status,
+		owner, labels
+	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)`,
 		key.ID,
 		key.AccessKey,
 		key.SecretKeyHash,
@@ -116,7 +175,10 @@ func (r *apiKeyRepository) Create(ctx context.Context, key models.APIKey) error
 		expiresAt,
 		revokedAt,
 		key.RotationOf,
+		key.ClientCertSubject,
 		string(key.Status),
+		key.Owner,
+		labels,
 	)
 	if err != nil {
 		return internalerrors.New(internalerrors.ErrDatabaseError, "insert api key", err)
@@ -129,7 +191,7 @@ func (r *apiKeyRepository) GetByAccessKey(ctx context.Context, accessKey string)
 	if accessKey == "" {
 		return models.APIKey{}, internalerrors.New(internalerrors.ErrValidationFailed, "accessKey is required", nil)
 	}
-	return r.getOne(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+	return r.getOne(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		FROM api_keys WHERE access_key = $1`, accessKey)
 }
 
@@ -138,7 +200,7 @@ func (r *apiKeyRepository) GetByID(ctx context.Context, id string) (models.APIKe
 	if id == "" {
 		return models.APIKey{}, internalerrors.New(internalerrors.ErrValidationFailed, "id is required", nil)
 	}
-	return r.getOne(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+	return r.getOne(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		FROM api_keys WHERE id = $1`, id)
 }
 
@@ -148,8 +210,9 @@ func (r *apiKeyRepository) getOne(ctx context.Context, query string, arg any) (m
 	var revokedAt *time.Time
 	var rotationOf *string
 	var status string
+	var labels []byte
 	row := r.pool.QueryRow(ctx, query, arg)
-	if err := row.Scan(
+	err := row.Scan(
 		&key.ID,
 		&key.AccessKey,
 		&key.SecretKeyHash,
@@ -160,8 +223,15 @@ func (r *apiKeyRepository) getOne(ctx context.Context, query string, arg any) (m
 		&expiresAt,
 		&revokedAt,
 		&rotationOf,
+		&key.ClientCertSubject,
 		&status,
-	); err != nil {
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.RequestCount,
+		&key.Owner,
+		&labels,
+	)
+	if err != nil {
 		if err == pgx.ErrNoRows {
 			return models.APIKey{}, repository.ErrNotFound
 		}
@@ -171,11 +241,14 @@ func (r *apiKeyRepository) getOne(ctx context.Context, query string, arg any) (m
 	key.RevokedAt = revokedAt
 	key.RotationOf = rotationOf
 	key.Status = models.APIKeyStatus(status)
+	if key.Labels, err = decodeLabels(labels); err != nil {
+		return models.APIKey{}, internalerrors.New(internalerrors.ErrDatabaseError, "decode api key labels", err)
+	}
 	return key, nil
 }
 
 func (r *apiKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
-	rows, err := r.pool.Query(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+	rows, err := r.pool.Query(ctx, `SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		FROM api_keys ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list api keys", err)
@@ -189,6 +262,7 @@ func (r *apiKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
 		var revokedAt *time.Time
 		var rotationOf *string
 		var status string
+		var labels []byte
 		if err := rows.Scan(
 			&key.ID,
 			&key.AccessKey,
@@ -200,7 +274,13 @@ func (r *apiKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
 			&expiresAt,
 			&revokedAt,
 			&rotationOf,
+			&key.ClientCertSubject,
 			&status,
+			&key.LastUsedAt,
+			&key.LastUsedIP,
+			&key.RequestCount,
+			&key.Owner,
+			&labels,
 		); err != nil {
 			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan api key", err)
 		}
@@ -208,6 +288,9 @@ func (r *apiKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
 		key.RevokedAt = revokedAt
 		key.RotationOf = rotationOf
 		key.Status = models.APIKeyStatus(status)
+		if key.Labels, err = decodeLabels(labels); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode api key labels", err)
+		}
 		keys = append(keys, key)
 	}
 	if err := rows.Err(); err != nil {
@@ -287,6 +370,78 @@ func (r *apiKeyRepository) SetExpiresAt(ctx context.Context, id string, expiresA
 	return nil
 }
 
+func (r *apiKeyRepository) UpdateMetadata(ctx context.Context, id string, metadata models.APIKeyMetadata, updatedAt time.Time) error {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "id is required", nil)
+	}
+	labels, err := labelsOrNull(metadata.Labels)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "marshal api key labels", err)
+	}
+
+	var returnedID string
+	row := r.pool.QueryRow(ctx, `UPDATE api_keys
+		SET description = $2, owner = $3, labels = $4, updated_at = $5
+		WHERE id = $1
+		RETURNING id`, id, metadata.Description, metadata.Owner, labels, updatedAt.UTC())
+	if err := row.Scan(&returnedID); err != nil {
+		if err == pgx.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return internalerrors.New(internalerrors.ErrDatabaseError, "update api key metadata", err)
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RewriteSecretCiphertexts(ctx context.Context, rewrite func(ciphertext string) (string, bool, error)) (int64, int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "begin rekey transaction", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// FOR UPDATE keeps concurrent rotations from writing a ciphertext that
+	// this transaction would then overwrite with a stale secret.
+	rows, err := tx.Query(ctx, `SELECT id, secret_key_ciphertext FROM api_keys WHERE secret_key_ciphertext <> '' ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "list api key secrets", err)
+	}
+	type secretRow struct{ id, ciphertext string }
+	var all []secretRow
+	for rows.Next() {
+		var row secretRow
+		if err := rows.Scan(&row.id, &row.ciphertext); err != nil {
+			rows.Close()
+			return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "scan api key secret", err)
+		}
+		all = append(all, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "iterate api key secrets", err)
+	}
+
+	var rewritten int64
+	for _, row := range all {
+		out, changed, err := rewrite(row.ciphertext)
+		if err != nil {
+			return 0, 0, fmt.Errorf("api key %s: %w", row.id, err)
+		}
+		if !changed {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE api_keys SET secret_key_ciphertext = $2 WHERE id = $1`, row.id, out); err != nil {
+			return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "update api key secret", err)
+		}
+		rewritten++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, internalerrors.New(internalerrors.ErrDatabaseError, "commit rekey transaction", err)
+	}
+	return int64(len(all)), rewritten, nil
+}
+
 type downstreamRequestRepository struct {
 	pool *pgxpool.Pool
 }
@@ -533,7 +688,13 @@ type auditEventRepository struct {
 	pool *pgxpool.Pool
 }
 
-func (r *auditEventRepository) Create(ctx context.Context, event models.AuditEvent) error {
+const auditEventColumns = `id, request_id, event_type, actor, action, resource, metadata, created_at, sequence, prev_hash, hash`
+
+// auditChainLock serialises chain appends across replicas for the duration of
+// the inserting transaction.
+const auditChainLock = "jimeng-relay:audit-chain"
+
+func (r *auditEventRepository) Create(ctx context.Context, event *models.AuditEvent) error {
 	if err := event.Validate(); err != nil {
 		return internalerrors.New(internalerrors.ErrValidationFailed, "validate audit event", err)
 	}
@@ -543,9 +704,30 @@ func (r *auditEventRepository) Create(ctx context.Context, event models.AuditEve
 		return internalerrors.New(internalerrors.ErrValidationFailed, "marshal audit metadata", err)
 	}
 
-	_, err = r.pool.Exec(ctx, `INSERT INTO audit_events (
-		id, request_id, event_type, actor, action, resource, metadata, created_at
-	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "begin audit event transaction", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			return
+		}
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, auditChainLock); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "lock audit chain", err)
+	}
+	var prevSequence int64
+	var prevHash string
+	err = tx.QueryRow(ctx, `SELECT sequence, hash FROM audit_events WHERE sequence > 0 ORDER BY sequence DESC LIMIT 1`).Scan(&prevSequence, &prevHash)
+	if err != nil && err != pgx.ErrNoRows {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "read audit chain head", err)
+	}
+	if err := event.Chain(prevSequence, prevHash); err != nil {
+		return internalerrors.New(internalerrors.ErrInternalError, "hash audit event", err)
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO audit_events (`+auditEventColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
 		event.ID,
 		event.RequestID,
 		string(event.EventType),
@@ -553,11 +735,17 @@ func (r *auditEventRepository) Create(ctx context.Context, event models.AuditEve
 		event.Action,
 		event.Resource,
 		meta,
-		event.CreatedAt.UTC(),
+		event.CreatedAt,
+		event.Sequence,
+		event.PrevHash,
+		event.Hash,
 	)
 	if err != nil {
 		return internalerrors.New(internalerrors.ErrDatabaseError, "insert audit event", err)
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "commit audit event", err)
+	}
 	return nil
 }
 
@@ -567,42 +755,12 @@ func (r *auditEventRepository) ListByRequestID(ctx context.Context, requestID st
 		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "requestID is required", nil)
 	}
 
-	rows, err := r.pool.Query(ctx, `SELECT id, request_id, event_type, actor, action, resource, metadata, created_at
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
 		FROM audit_events WHERE request_id = $1 ORDER BY created_at ASC`, requestID)
 	if err != nil {
 		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list audit events by request_id", err)
 	}
-	defer rows.Close()
-
-	events := make([]models.AuditEvent, 0)
-	for rows.Next() {
-		var e models.AuditEvent
-		var metaBytes []byte
-		var eventType string
-		if err := rows.Scan(
-			&e.ID,
-			&e.RequestID,
-			&eventType,
-			&e.Actor,
-			&e.Action,
-			&e.Resource,
-			&metaBytes,
-			&e.CreatedAt,
-		); err != nil {
-			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan audit event", err)
-		}
-		m, err := decodeMap(metaBytes)
-		if err != nil {
-			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode audit metadata", err)
-		}
-		e.Metadata = m
-		e.EventType = models.EventType(eventType)
-		events = append(events, e)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate audit events", err)
-	}
-	return events, nil
+	return scanAuditEvents(rows)
 }
 
 func (r *auditEventRepository) ListByTimeRange(ctx context.Context, start, end time.Time) ([]models.AuditEvent, error) {
@@ -610,11 +768,102 @@ func (r *auditEventRepository) ListByTimeRange(ctx context.Context, start, end t
 		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "start and end are required", nil)
 	}
 
-	rows, err := r.pool.Query(ctx, `SELECT id, request_id, event_type, actor, action, resource, metadata, created_at
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
 		FROM audit_events WHERE created_at >= $1 AND created_at <= $2 ORDER BY created_at ASC`, start.UTC(), end.UTC())
 	if err != nil {
 		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list audit events by time range", err)
 	}
+	return scanAuditEvents(rows)
+}
+
+func (r *auditEventRepository) ListChain(ctx context.Context, afterSequence int64, limit int) ([]models.AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
+		FROM audit_events WHERE sequence > $1 ORDER BY sequence ASC LIMIT $2`, afterSequence, limit)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list audit chain", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+func (r *auditEventRepository) ChainHead(ctx context.Context) (models.AuditEvent, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
+		FROM audit_events WHERE sequence > 0 ORDER BY sequence DESC LIMIT 1`)
+	if err != nil {
+		return models.AuditEvent{}, internalerrors.New(internalerrors.ErrDatabaseError, "get audit chain head", err)
+	}
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return models.AuditEvent{}, err
+	}
+	if len(events) == 0 {
+		return models.AuditEvent{}, repository.ErrNotFound
+	}
+	return events[0], nil
+}
+
+func (r *auditEventRepository) CountUnchained(ctx context.Context) (int64, error) {
+	var n int64
+	if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM audit_events WHERE sequence = 0`).Scan(&n); err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "count unchained audit events", err)
+	}
+	return n, nil
+}
+
+func (r *auditEventRepository) CreateCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) error {
+	if err := checkpoint.Validate(); err != nil {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "validate audit checkpoint", err)
+	}
+	_, err := r.pool.Exec(ctx, `INSERT INTO audit_checkpoints (id, sequence, hash, mac, created_at) VALUES ($1,$2,$3,$4,$5)`,
+		checkpoint.ID,
+		checkpoint.Sequence,
+		checkpoint.Hash,
+		checkpoint.MAC,
+		checkpoint.CreatedAt.UTC().Truncate(models.AuditChainTimePrecision),
+	)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "insert audit checkpoint", err)
+	}
+	return nil
+}
+
+func (r *auditEventRepository) LatestCheckpoint(ctx context.Context) (models.AuditCheckpoint, error) {
+	checkpoints, err := r.listCheckpoints(ctx, `SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints ORDER BY sequence DESC, created_at DESC LIMIT 1`)
+	if err != nil {
+		return models.AuditCheckpoint{}, err
+	}
+	if len(checkpoints) == 0 {
+		return models.AuditCheckpoint{}, repository.ErrNotFound
+	}
+	return checkpoints[0], nil
+}
+
+func (r *auditEventRepository) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	return r.listCheckpoints(ctx, `SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints ORDER BY sequence ASC, created_at ASC`)
+}
+
+func (r *auditEventRepository) listCheckpoints(ctx context.Context, query string, args ...any) ([]models.AuditCheckpoint, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list audit checkpoints", err)
+	}
+	defer rows.Close()
+
+	checkpoints := make([]models.AuditCheckpoint, 0)
+	for rows.Next() {
+		var c models.AuditCheckpoint
+		if err := rows.Scan(&c.ID, &c.Sequence, &c.Hash, &c.MAC, &c.CreatedAt); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan audit checkpoint", err)
+		}
+		c.CreatedAt = c.CreatedAt.UTC()
+		checkpoints = append(checkpoints, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate audit checkpoints", err)
+	}
+	return checkpoints, nil
+}
+
+func scanAuditEvents(rows pgx.Rows) ([]models.AuditEvent, error) {
 	defer rows.Close()
 
 	events := make([]models.AuditEvent, 0)
@@ -631,6 +880,9 @@ func (r *auditEventRepository) ListByTimeRange(ctx context.Context, start, end t
 			&e.Resource,
 			&metaBytes,
 			&e.CreatedAt,
+			&e.Sequence,
+			&e.PrevHash,
+			&e.Hash,
 		); err != nil {
 			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan audit event", err)
 		}
@@ -723,6 +975,84 @@ func (r *idempotencyRecordRepository) DeleteExpired(ctx context.Context, now tim
 	return tag.RowsAffected(), nil
 }
 
+type seenSignatureRepository struct {
+	pool *pgxpool.Pool
+}
+
+func (r *seenSignatureRepository) Remember(ctx context.Context, signature string, expiresAt time.Time) (bool, error) {
+	if strings.TrimSpace(signature) == "" {
+		return false, internalerrors.New(internalerrors.ErrValidationFailed, "signature is required", nil)
+	}
+
+	tag, err := r.pool.Exec(ctx, `INSERT INTO seen_signatures (signature, expires_at) VALUES ($1, $2)
+		ON CONFLICT (signature) DO NOTHING`, signature, expiresAt.UTC())
+	if err != nil {
+		return false, internalerrors.New(internalerrors.ErrDatabaseError, "remember signature", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *seenSignatureRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	if now.IsZero() {
+		return 0, internalerrors.New(internalerrors.ErrValidationFailed, "now is required", nil)
+	}
+
+	tag, err := r.pool.Exec(ctx, `DELETE FROM seen_signatures WHERE expires_at <= $1`, now.UTC())
+	if err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "delete expired signatures", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+type usageRepository struct {
+	pool *pgxpool.Pool
+}
+
+// usageIntField reads a numeric body field, treating anything else as 0.
+func usageIntField(field string) string {
+	return `CASE WHEN jsonb_typeof(dr.body->'` + field + `') = 'number' THEN (dr.body->>'` + field + `')::numeric::int ELSE 0 END`
+}
+
+func (r *usageRepository) SummarizeSubmits(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.SubmitUsage, error) {
+	query := `SELECT dr.api_key_id,
+			COALESCE(dr.body->>'req_key', '') AS req_key,
+			to_char(dr.received_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+			` + usageIntField("frames") + ` AS frames,
+			` + usageIntField("width") + ` AS width,
+			` + usageIntField("height") + ` AS height,
+			COUNT(*)
+		FROM downstream_requests dr
+		WHERE dr.action = $1
+		  AND dr.received_at >= $2 AND dr.received_at < $3
+		  AND ($4 = '' OR dr.api_key_id = $4)
+		  AND EXISTS (
+			SELECT 1 FROM upstream_attempts ua
+			WHERE ua.request_id = dr.request_id
+			  AND ua.response_status BETWEEN 200 AND 299
+			  AND ua.error IS NULL
+		  )
+		GROUP BY 1, 2, 3, 4, 5, 6
+		ORDER BY 3, 1, 2, 4, 5, 6`
+	rows, err := r.pool.Query(ctx, query, string(models.DownstreamActionCVSync2AsyncSubmitTask), from.UTC(), to.UTC(), apiKeyID)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "summarize submits", err)
+	}
+	defer rows.Close()
+
+	var out []models.SubmitUsage
+	for rows.Next() {
+		var u models.SubmitUsage
+		if err := rows.Scan(&u.APIKeyID, &u.ReqKey, &u.Day, &u.Frames, &u.Width, &u.Height, &u.Count); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan submit usage", err)
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate submit usage", err)
+	}
+	return out, nil
+}
+
 func jsonbOrNull(v any) (any, error) {
 	if v == nil {
 		return nil, nil
@@ -737,6 +1067,25 @@ func jsonbOrNull(v any) (any, error) {
 	return json.RawMessage(b), nil
 }
 
+// labelsOrNull stores a key without labels as NULL.
+func labelsOrNull(labels map[string]string) (any, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return jsonbOrNull(labels)
+}
+
+func decodeLabels(b []byte) (map[string]string, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
 func decodeMap(b []byte) (map[string]any, error) {
 	if len(b) == 0 {
 		return nil, nil
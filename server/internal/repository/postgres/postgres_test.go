@@ -5,7 +5,9 @@ package postgres
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,7 +35,7 @@ func openIntegrationDB(t *testing.T) *DB {
 	cleanup := func() {
 		cctx, ccancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer ccancel()
-		_, _ = db.pool.Exec(cctx, `TRUNCATE TABLE audit_events, upstream_attempts, downstream_requests, idempotency_records, api_keys CASCADE`)
+		_, _ = db.pool.Exec(cctx, `TRUNCATE TABLE audit_checkpoints, audit_events, upstream_attempts, downstream_requests, idempotency_records, seen_signatures, stats_rollups, stats_rollup_state, api_key_daily_requests, api_keys CASCADE`)
 	}
 	cleanup()
 	t.Cleanup(cleanup)
@@ -74,6 +76,34 @@ func TestMigrate_Idempotent(t *testing.T) {
 	}
 }
 
+func TestMigrations_DownAndUp(t *testing.T) {
+	db := openIntegrationDB(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	m := db.Migrations()
+	latest := migrations[len(migrations)-1].version
+	reverted, err := m.MigrateDown(ctx, 1)
+	if err != nil || len(reverted) != 1 || reverted[0] != latest {
+		t.Fatalf("MigrateDown = %v, %v", reverted, err)
+	}
+	statuses, err := m.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != latest || last.Applied {
+		t.Fatalf("expected %d to be pending, got %+v", latest, last)
+	}
+	applied, err := m.MigrateUp(ctx, 0)
+	if err != nil || len(applied) != 1 || applied[0] != latest {
+		t.Fatalf("MigrateUp = %v, %v", applied, err)
+	}
+	if _, err := m.MigrateDown(ctx, 0); err == nil {
+		t.Fatalf("expected zero steps to be rejected")
+	}
+}
+
 func TestAPIKeyRepository_CRUD(t *testing.T) {
 	db := openIntegrationDB(t)
 	repo := db.APIKeys()
@@ -213,6 +243,58 @@ func TestAPIKeyRepository_ConstraintViolation(t *testing.T) {
 	}
 }
 
+func TestAPIKeyRepository_RewriteSecretCiphertexts(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+	for _, id := range []string{"k1", "k2", "k3"} {
+		ct := "v1:" + id
+		if id == "k3" {
+			ct = "v2:new:" + id
+		}
+		key := models.APIKey{ID: id, AccessKey: "ak_" + id, SecretKeyHash: "hash", SecretKeyCiphertext: ct, Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now}
+		if err := db.APIKeys().Create(ctx, key); err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+	}
+	rekey := func(ct string) (string, bool, error) {
+		if strings.HasPrefix(ct, "v2:new:") {
+			return ct, false, nil
+		}
+		return "v2:new:" + strings.TrimPrefix(ct, "v1:"), true, nil
+	}
+
+	scanned, rewritten, err := db.APIKeySecrets().RewriteSecretCiphertexts(ctx, rekey)
+	if err != nil {
+		t.Fatalf("RewriteSecretCiphertexts: %v", err)
+	}
+	if scanned != 3 || rewritten != 2 {
+		t.Fatalf("expected 3 scanned and 2 rewritten, got %d and %d", scanned, rewritten)
+	}
+	got, err := db.APIKeys().GetByID(ctx, "k1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.SecretKeyCiphertext != "v2:new:k1" || !got.UpdatedAt.Equal(now) {
+		t.Fatalf("unexpected rewritten key: %+v", got)
+	}
+
+	// A failure part way through leaves every row as it was.
+	_, _, err = db.APIKeySecrets().RewriteSecretCiphertexts(ctx, func(ct string) (string, bool, error) {
+		if ct == "v2:new:k2" {
+			return "", false, fmt.Errorf("boom")
+		}
+		return "v3:" + ct, true, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "k2") {
+		t.Fatalf("expected error naming k2, got %v", err)
+	}
+	if got, _ := db.APIKeys().GetByID(ctx, "k1"); got.SecretKeyCiphertext != "v2:new:k1" {
+		t.Fatalf("expected rollback, got %q", got.SecretKeyCiphertext)
+	}
+}
+
 func TestDownstreamRequestRepository_CRUD(t *testing.T) {
 	db := openIntegrationDB(t)
 	keys := db.APIKeys()
@@ -375,10 +457,10 @@ func TestAuditEventRepository_CreateAndList(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	t.Cleanup(cancel)
 
-	if err := repo.Create(ctx, e1); err != nil {
+	if err := repo.Create(ctx, &e1); err != nil {
 		t.Fatalf("Create 1: %v", err)
 	}
-	if err := repo.Create(ctx, e2); err != nil {
+	if err := repo.Create(ctx, &e2); err != nil {
 		t.Fatalf("Create 2: %v", err)
 	}
 
@@ -399,6 +481,63 @@ func TestAuditEventRepository_CreateAndList(t *testing.T) {
 	}
 }
 
+func TestAuditEventRepository_HashChain(t *testing.T) {
+	db := openIntegrationDB(t)
+	repo := db.AuditChain()
+	events := db.AuditEvents()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	t.Cleanup(cancel)
+
+	base := time.Date(2026, 2, 24, 3, 4, 5, 123456789, time.UTC)
+	for _, id := range []string{"a1", "a2", "a3"} {
+		e := models.AuditEvent{
+			ID:        id,
+			RequestID: "req-chain",
+			EventType: models.EventTypeUpstreamResponse,
+			Actor:     "system",
+			Action:    "relay_call",
+			Resource:  "relay.call",
+			Metadata:  map[string]any{"response_status": 200, "latency_ms": int64(12), "ratio": 0.5, "note": "<ok>"},
+			CreatedAt: base,
+		}
+		if err := events.Create(ctx, &e); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	chain, err := repo.ListChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListChain: %v", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("expected 3 chained events, got %d", len(chain))
+	}
+	prevHash := ""
+	for i, e := range chain {
+		if e.Sequence != int64(i+1) || e.PrevHash != prevHash {
+			t.Fatalf("unexpected chain position for %s: seq=%d prev=%q", e.ID, e.Sequence, e.PrevHash)
+		}
+		if h, err := e.ChainHash(); err != nil || h != e.Hash {
+			t.Fatalf("hash for %s does not survive jsonb and timestamptz: %q vs %q (%v)", e.ID, h, e.Hash, err)
+		}
+		prevHash = e.Hash
+	}
+
+	cp := models.AuditCheckpoint{ID: "acp_1", Sequence: 3, Hash: prevHash, CreatedAt: base.Add(time.Minute)}
+	cp.MAC = cp.ComputeMAC([]byte("0123456789abcdef0123456789abcdef"))
+	if err := repo.CreateCheckpoint(ctx, cp); err != nil {
+		t.Fatalf("CreateCheckpoint: %v", err)
+	}
+	latest, err := repo.LatestCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("LatestCheckpoint: %v", err)
+	}
+	if latest.MAC != latest.ComputeMAC([]byte("0123456789abcdef0123456789abcdef")) {
+		t.Fatalf("checkpoint MAC does not survive a round trip: %+v", latest)
+	}
+}
+
 func TestIdempotencyRecordRepository_CRUDAndDeleteExpired(t *testing.T) {
 	db := openIntegrationDB(t)
 	repo := db.IdempotencyRecords()
@@ -457,3 +596,163 @@ func TestRepositoryErrors_IsNotFound(t *testing.T) {
 		t.Fatalf("expected false")
 	}
 }
+
+func TestSeenSignatureRepository_RememberAndDeleteExpired(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+	repo := db.SeenSignatures()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := db.pool.Exec(ctx, `DELETE FROM seen_signatures`); err != nil {
+		t.Fatalf("reset seen_signatures: %v", err)
+	}
+
+	fresh, err := repo.Remember(ctx, "sig-1", now.Add(time.Minute))
+	if err != nil || !fresh {
+		t.Fatalf("first Remember: fresh=%v err=%v", fresh, err)
+	}
+	fresh, err = repo.Remember(ctx, "sig-1", now.Add(time.Minute))
+	if err != nil || fresh {
+		t.Fatalf("duplicate Remember: fresh=%v err=%v", fresh, err)
+	}
+	if _, err := repo.Remember(ctx, "sig-old", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("Remember sig-old: %v", err)
+	}
+
+	deleted, err := repo.DeleteExpired(ctx, now)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d", deleted)
+	}
+}
+
+func TestUsageRepository_SummarizeSubmits(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	submit := func(id, apiKeyID string, at time.Time, body map[string]any, status int) {
+		t.Helper()
+		req := models.DownstreamRequest{ID: "d-" + id, RequestID: id, APIKeyID: apiKeyID, Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", Body: body, ReceivedAt: at}
+		if err := db.DownstreamRequests().Create(ctx, req); err != nil {
+			t.Fatalf("Create downstream %s: %v", id, err)
+		}
+		attempt := models.UpstreamAttempt{ID: "u-" + id, RequestID: id, AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: status, SentAt: at}
+		if err := db.UpstreamAttempts().Create(ctx, attempt); err != nil {
+			t.Fatalf("Create attempt %s: %v", id, err)
+		}
+	}
+	video := map[string]any{"req_key": "jimeng_t2v_v30", "frames": 241}
+	submit("r1", "k1", day.Add(time.Hour), video, 200)
+	submit("r2", "k1", day.Add(2*time.Hour), video, 200)
+	submit("r3", "k1", day.Add(3*time.Hour), video, 500)
+	submit("r4", "k2", day.Add(25*time.Hour), map[string]any{"req_key": "jimeng_t2i_v40", "width": 1024, "height": 1024}, 200)
+
+	got, err := db.Usage().SummarizeSubmits(ctx, day, day.Add(48*time.Hour), "")
+	if err != nil {
+		t.Fatalf("SummarizeSubmits: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 usage rows, got %+v", got)
+	}
+	if got[0] != (models.SubmitUsage{APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Day: "2026-03-01", Frames: 241, Count: 2}) {
+		t.Fatalf("unexpected video usage: %+v", got[0])
+	}
+	if got[1] != (models.SubmitUsage{APIKeyID: "k2", ReqKey: "jimeng_t2i_v40", Day: "2026-03-02", Width: 1024, Height: 1024, Count: 1}) {
+		t.Fatalf("unexpected image usage: %+v", got[1])
+	}
+
+	got, err = db.Usage().SummarizeSubmits(ctx, day, day.Add(48*time.Hour), "k2")
+	if err != nil {
+		t.Fatalf("SummarizeSubmits k2: %v", err)
+	}
+	if len(got) != 1 || got[0].APIKeyID != "k2" {
+		t.Fatalf("expected only k2 usage, got %+v", got)
+	}
+}
+
+func TestDB_Ping(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestBulkRepository_ExportImport(t *testing.T) {
+	db := openIntegrationDB(t)
+	ctx := context.Background()
+	bulk := db.Bulk()
+
+	now := time.Date(2026, 2, 24, 3, 4, 5, 123456000, time.UTC)
+	var keys []models.APIKey
+	for i := 1; i <= 3; i++ {
+		keys = append(keys, models.APIKey{ID: fmt.Sprintf("k%d", i), AccessKey: fmt.Sprintf("ak_%d", i), SecretKeyHash: "hash", SecretKeyCiphertext: "v1:test", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now})
+	}
+	if n, err := bulk.ImportAPIKeys(ctx, keys[:2]); err != nil || n != 2 {
+		t.Fatalf("ImportAPIKeys: %d, %v", n, err)
+	}
+	if n, err := bulk.ImportAPIKeys(ctx, keys); err != nil || n != 1 {
+		t.Fatalf("expected existing keys to be skipped, got %d, %v", n, err)
+	}
+	page, err := bulk.ExportAPIKeys(ctx, "k1", 10)
+	if err != nil {
+		t.Fatalf("ExportAPIKeys: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != "k2" || !page[0].CreatedAt.Equal(now) {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	event := models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeUpstreamResponse, Action: "relay_call", Resource: "relay.call", CreatedAt: now}
+	if err := event.Chain(0, ""); err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if n, err := bulk.ImportAuditEvents(ctx, []models.AuditEvent{event}); err != nil || n != 1 {
+		t.Fatalf("ImportAuditEvents: %d, %v", n, err)
+	}
+	head, err := db.AuditChain().ChainHead(ctx)
+	if err != nil || head.Sequence != 1 || head.Hash != event.Hash {
+		t.Fatalf("expected the imported event to head the chain, got %+v, %v", head, err)
+	}
+
+	day := time.Date(2026, 2, 24, 0, 0, 0, 0, time.UTC)
+	counts := []models.APIKeyDailyRequests{{APIKeyID: "k1", Day: day, Requests: 2}, {APIKeyID: "k2", Day: day, Requests: 3}}
+	if n, err := bulk.ImportAPIKeyDailyRequests(ctx, counts); err != nil || n != 2 {
+		t.Fatalf("ImportAPIKeyDailyRequests: %d, %v", n, err)
+	}
+	daily, err := bulk.ExportAPIKeyDailyRequests(ctx, repository.APIKeyDayKey{APIKeyID: "k1", Day: day}, 10)
+	if err != nil || len(daily) != 1 || daily[0].APIKeyID != "k2" || !daily[0].Day.Equal(day) {
+		t.Fatalf("unexpected daily requests page: %+v, %v", daily, err)
+	}
+
+	hour := day.Add(3 * time.Hour)
+	rollups := []models.StatsRollup{
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "2xx", Attempts: 4},
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "5xx", Attempts: 1},
+	}
+	if n, err := bulk.ImportStatsRollups(ctx, rollups); err != nil || n != 2 {
+		t.Fatalf("ImportStatsRollups: %d, %v", n, err)
+	}
+	after := repository.StatsRollupKey{Hour: hour, APIKeyID: "k1", Action: string(models.DownstreamActionCVSync2AsyncSubmitTask), StatusClass: "2xx"}
+	if page, err := bulk.ExportStatsRollups(ctx, after, 10); err != nil || len(page) != 1 || page[0].StatusClass != "5xx" {
+		t.Fatalf("unexpected rollup page: %+v, %v", page, err)
+	}
+	if n, err := bulk.ImportStatsRollupWatermark(ctx, hour.Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("ImportStatsRollupWatermark: %d, %v", n, err)
+	}
+	if watermark, err := bulk.ExportStatsRollupWatermark(ctx); err != nil || !watermark.Equal(hour.Add(time.Hour)) {
+		t.Fatalf("ExportStatsRollupWatermark: %v, %v", watermark, err)
+	}
+
+	if n, err := bulk.CountRows(ctx, repository.TableAPIKeys); err != nil || n != 3 {
+		t.Fatalf("CountRows: %d, %v", n, err)
+	}
+	if n, err := bulk.CountRows(ctx, repository.TableStatsRollupWatermark); err != nil || n != 1 {
+		t.Fatalf("CountRows(%s): %d, %v", repository.TableStatsRollupWatermark, n, err)
+	}
+	if _, err := bulk.CountRows(ctx, "relay_coordination"); err == nil {
+		t.Fatalf("expected a table outside BulkTables to be rejected")
+	}
+}
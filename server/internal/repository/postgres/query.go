@@ -0,0 +1,259 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// latestAttempt selects column from the request's highest-numbered upstream
+// attempt, or NULL when it has none.
+func latestAttempt(column string) string {
+	return `(SELECT ua.` + column + ` FROM upstream_attempts ua WHERE ua.request_id = dr.request_id ORDER BY ua.attempt_number DESC LIMIT 1)`
+}
+
+const auditStatus = `(CASE WHEN jsonb_typeof(metadata->'response_status') = 'number' THEN (metadata->>'response_status')::numeric END)`
+
+func (r *auditEventRepository) Query(ctx context.Context, query repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	if query.Limit <= 0 {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "limit must be positive", nil)
+	}
+
+	w := auditEventFilter(query)
+	w.cursor("created_at", "id", query.Order, query.After)
+
+	rows, err := r.pool.Query(ctx, `SELECT `+auditEventColumns+`
+		FROM audit_events`+w.String()+`
+		ORDER BY `+orderBy("created_at", "id", query.Order)+`
+		LIMIT `+w.arg(query.Limit), w.args...)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "query audit events", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+var _ repository.AuditEventCounter = (*auditEventRepository)(nil)
+
+func (r *auditEventRepository) CountByAPIKey(ctx context.Context, query repository.AuditEventQuery) (map[string]int64, error) {
+	w := auditEventFilter(query)
+	rows, err := r.pool.Query(ctx, `SELECT COALESCE((SELECT dr.api_key_id FROM downstream_requests dr WHERE dr.request_id = audit_events.request_id), ''), COUNT(*)
+		FROM audit_events`+w.String()+`
+		GROUP BY 1`, w.args...)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count audit events", err)
+	}
+	return scanKeyCounts(rows)
+}
+
+// auditEventFilter turns the filters of query, except the cursor, into a
+// WHERE clause over audit_events.
+func auditEventFilter(query repository.AuditEventQuery) whereClause {
+	var w whereClause
+	w.window("created_at", query.From, query.To)
+	if query.APIKeyID != "" {
+		w.add(`request_id IN (SELECT request_id FROM downstream_requests WHERE api_key_id = %s)`, query.APIKeyID)
+	}
+	if len(query.APIKeyIDs) > 0 {
+		w.add(`request_id IN (SELECT request_id FROM downstream_requests WHERE api_key_id = ANY(%s))`, query.APIKeyIDs)
+	}
+	if query.Action != "" {
+		w.add(`action = %s`, query.Action)
+	}
+	if query.EventType != "" {
+		w.add(`event_type = %s`, string(query.EventType))
+	}
+	w.statusRange(auditStatus, query.MinStatus, query.MaxStatus)
+	if query.HasError != nil {
+		hasError := `(event_type = '` + string(models.EventTypeError) + `' OR metadata->>'error' IS NOT NULL)`
+		if !*query.HasError {
+			hasError = `NOT ` + hasError
+		}
+		w.add(hasError)
+	}
+	return w
+}
+
+func (r *downstreamRequestRepository) List(ctx context.Context, query repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	if query.Limit <= 0 {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "limit must be positive", nil)
+	}
+
+	w := downstreamRequestFilter(query)
+	w.cursor("dr.received_at", "dr.id", query.Order, query.After)
+
+	rows, err := r.pool.Query(ctx, `SELECT dr.id, dr.request_id, dr.api_key_id, dr.action, dr.method, dr.path, dr.query_string, dr.headers, dr.body, dr.client_ip, dr.received_at
+		FROM downstream_requests dr`+w.String()+`
+		ORDER BY `+orderBy("dr.received_at", "dr.id", query.Order)+`
+		LIMIT `+w.arg(query.Limit), w.args...)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list downstream requests", err)
+	}
+	defer rows.Close()
+
+	var out []models.DownstreamRequest
+	for rows.Next() {
+		var req models.DownstreamRequest
+		var headersBytes []byte
+		var bodyBytes []byte
+		var action string
+		if err := rows.Scan(
+			&req.ID,
+			&req.RequestID,
+			&req.APIKeyID,
+			&action,
+			&req.Method,
+			&req.Path,
+			&req.QueryString,
+			&headersBytes,
+			&bodyBytes,
+			&req.ClientIP,
+			&req.ReceivedAt,
+		); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan downstream request", err)
+		}
+		if req.Headers, err = decodeMap(headersBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode downstream headers", err)
+		}
+		if req.Body, err = decodeMap(bodyBytes); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "decode downstream body", err)
+		}
+		req.Action = models.DownstreamAction(action)
+		out = append(out, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate downstream requests", err)
+	}
+	return out, nil
+}
+
+var _ repository.DownstreamRequestCounter = (*downstreamRequestRepository)(nil)
+
+func (r *downstreamRequestRepository) CountByAPIKey(ctx context.Context, query repository.DownstreamRequestQuery) (map[string]int64, error) {
+	w := downstreamRequestFilter(query)
+	rows, err := r.pool.Query(ctx, `SELECT dr.api_key_id, COUNT(*)
+		FROM downstream_requests dr`+w.String()+`
+		GROUP BY dr.api_key_id`, w.args...)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count downstream requests", err)
+	}
+	return scanKeyCounts(rows)
+}
+
+// downstreamRequestFilter turns the filters of query, except the cursor,
+// into a WHERE clause over downstream_requests aliased dr.
+func downstreamRequestFilter(query repository.DownstreamRequestQuery) whereClause {
+	var w whereClause
+	w.window("dr.received_at", query.From, query.To)
+	if query.APIKeyID != "" {
+		w.add(`dr.api_key_id = %s`, query.APIKeyID)
+	}
+	if len(query.APIKeyIDs) > 0 {
+		w.add(`dr.api_key_id = ANY(%s)`, query.APIKeyIDs)
+	}
+	if query.Action != "" {
+		w.add(`dr.action = %s`, string(query.Action))
+	}
+	w.statusRange(latestAttempt("response_status"), query.MinStatus, query.MaxStatus)
+	if query.HasError != nil {
+		if *query.HasError {
+			w.add(latestAttempt("error") + ` IS NOT NULL`)
+		} else {
+			w.add(latestAttempt("error") + ` IS NULL`)
+		}
+	}
+	return w
+}
+
+// scanKeyCounts reads (api_key_id, count) rows into a map.
+func scanKeyCounts(rows pgx.Rows) (map[string]int64, error) {
+	defer rows.Close()
+	out := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan key count", err)
+		}
+		out[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate key counts", err)
+	}
+	return out, nil
+}
+
+// whereClause collects AND-ed conditions and numbers their placeholders.
+// Conditions mark each argument with %s.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+// arg appends v and returns its placeholder.
+func (w *whereClause) arg(v any) string {
+	w.args = append(w.args, v)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func (w *whereClause) add(cond string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, v := range args {
+		placeholders[i] = w.arg(v)
+	}
+	if len(placeholders) > 0 {
+		cond = fmt.Sprintf(cond, placeholders...)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+func (w *whereClause) window(column string, from, to time.Time) {
+	if !from.IsZero() {
+		w.add(column+` >= %s`, from.UTC())
+	}
+	if !to.IsZero() {
+		w.add(column+` < %s`, to.UTC())
+	}
+}
+
+func (w *whereClause) statusRange(expr string, min, max int) {
+	if min != 0 {
+		w.add(expr+` >= %s`, min)
+	}
+	if max != 0 {
+		w.add(expr+` <= %s`, max)
+	}
+}
+
+// cursor keeps rows strictly after the cursor in order; the id breaks ties
+// between equal timestamps.
+func (w *whereClause) cursor(timeColumn, idColumn string, order repository.SortOrder, after *repository.Cursor) {
+	if after == nil {
+		return
+	}
+	op := `>`
+	if order == repository.SortDesc {
+		op = `<`
+	}
+	w.add(`(`+timeColumn+`, `+idColumn+`) `+op+` (%s, %s)`, after.Time.UTC(), after.ID)
+}
+
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return "\n\t\tWHERE " + strings.Join(w.conds, "\n\t\t  AND ")
+}
+
+func orderBy(timeColumn, idColumn string, order repository.SortOrder) string {
+	dir := `ASC`
+	if order == repository.SortDesc {
+		dir = `DESC`
+	}
+	return timeColumn + ` ` + dir + `, ` + idColumn + ` ` + dir
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// statsRollupLock keeps replicas that run the rollup job at the same time
+// from interleaving their delete and insert of the same hours.
+const statsRollupLock = "jimeng-relay:stats-rollup"
+
+type statsRollupRepository struct {
+	pool *pgxpool.Pool
+}
+
+func (r *statsRollupRepository) RollupSamples(ctx context.Context, from, to time.Time) ([]models.RollupSample, error) {
+	rows, err := r.pool.Query(ctx, `SELECT dr.api_key_id, dr.action, ua.attempt_number, ua.response_status, ua.latency_ms, ua.error IS NOT NULL, ua.sent_at
+		FROM upstream_attempts ua
+		JOIN downstream_requests dr ON dr.request_id = ua.request_id
+		WHERE ua.sent_at >= $1 AND ua.sent_at < $2`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "select rollup samples", err)
+	}
+	defer rows.Close()
+
+	var out []models.RollupSample
+	for rows.Next() {
+		var s models.RollupSample
+		var action string
+		if err := rows.Scan(&s.APIKeyID, &action, &s.AttemptNumber, &s.ResponseStatus, &s.LatencyMs, &s.Failed, &s.SentAt); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan rollup sample", err)
+		}
+		s.Action = models.DownstreamAction(action)
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate rollup samples", err)
+	}
+	return out, nil
+}
+
+func (r *statsRollupRepository) FirstSampleTime(ctx context.Context) (time.Time, error) {
+	var first *time.Time
+	if err := r.pool.QueryRow(ctx, `SELECT MIN(sent_at) FROM upstream_attempts`).Scan(&first); err != nil {
+		return time.Time{}, internalerrors.New(internalerrors.ErrDatabaseError, "select first sample time", err)
+	}
+	if first == nil {
+		return time.Time{}, repository.ErrNotFound
+	}
+	return *first, nil
+}
+
+func (r *statsRollupRepository) Watermark(ctx context.Context) (time.Time, error) {
+	var watermark time.Time
+	err := r.pool.QueryRow(ctx, `SELECT watermark FROM stats_rollup_state WHERE id = 1`).Scan(&watermark)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, internalerrors.New(internalerrors.ErrDatabaseError, "select rollup watermark", err)
+	}
+	return watermark, nil
+}
+
+func (r *statsRollupRepository) ReplaceRollups(ctx context.Context, from, to time.Time, rollups []models.StatsRollup) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "begin rollup transaction", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			return
+		}
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, statsRollupLock); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "lock stats rollups", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM stats_rollups WHERE hour >= $1 AND hour < $2`, from.UTC(), to.UTC()); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "delete stats rollups", err)
+	}
+	for _, s := range rollups {
+		if _, err := tx.Exec(ctx, `INSERT INTO stats_rollups (
+			hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
+			s.Hour.UTC(), s.APIKeyID, string(s.Action), s.StatusClass, s.Attempts, s.Retries, s.Errors,
+			s.LatencySumMs, s.LatencyP50Ms, s.LatencyP95Ms, s.LatencyMaxMs,
+		); err != nil {
+			return internalerrors.New(internalerrors.ErrDatabaseError, "insert stats rollup", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO stats_rollup_state (id, watermark) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET watermark = GREATEST(stats_rollup_state.watermark, EXCLUDED.watermark)`, to.UTC()); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "advance rollup watermark", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return internalerrors.New(internalerrors.ErrDatabaseError, "commit stats rollups", err)
+	}
+	return nil
+}
+
+func (r *statsRollupRepository) ListRollups(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error) {
+	rows, err := r.pool.Query(ctx, `SELECT hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		FROM stats_rollups
+		WHERE hour >= $1 AND hour < $2
+		  AND ($3 = '' OR api_key_id = $3)
+		ORDER BY hour ASC, api_key_id ASC, action ASC, status_class ASC`, from.UTC(), to.UTC(), apiKeyID)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list stats rollups", err)
+	}
+	defer rows.Close()
+
+	var out []models.StatsRollup
+	for rows.Next() {
+		var s models.StatsRollup
+		var action string
+		if err := rows.Scan(&s.Hour, &s.APIKeyID, &action, &s.StatusClass, &s.Attempts, &s.Retries, &s.Errors,
+			&s.LatencySumMs, &s.LatencyP50Ms, &s.LatencyP95Ms, &s.LatencyMaxMs); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "scan stats rollup", err)
+		}
+		s.Action = models.DownstreamAction(action)
+		s.Hour = s.Hour.UTC()
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "iterate stats rollups", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,1084 @@
+// Package repotest is a conformance suite for repository implementations.
+// Each backend runs it against itself from its own tests, so the sqlite,
+// postgres and memory repositories are held to the same behaviour instead of
+// drifting apart in separate test files.
+//
+// Times in the suite are whole microseconds, the finest precision every
+// backend keeps. JSON fields are compared by their encoding, since backends
+// may decode numbers as float64 or json.Number.
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// Repositories is the backend under test.
+type Repositories struct {
+	APIKeys            repository.APIKeyRepository
+	DownstreamRequests repository.DownstreamRequestRepository
+	UpstreamAttempts   repository.UpstreamAttemptRepository
+	AuditEvents        repository.AuditEventRepository
+	IdempotencyRecords repository.IdempotencyRecordRepository
+	// SeenSignatures is optional; its checks are skipped when nil.
+	SeenSignatures repository.SeenSignatureRepository
+	// StatsRollups is optional; its checks are skipped when nil.
+	StatsRollups repository.StatsRollupRepository
+	// APIKeyActivity is optional; its checks are skipped when nil.
+	APIKeyActivity repository.APIKeyActivityRepository
+	// APIKeyMetadata is optional; its checks are skipped when nil.
+	APIKeyMetadata repository.APIKeyMetadataRepository
+}
+
+// Open returns repositories over an empty database. It is called once per
+// subtest and should register any cleanup with t.
+type Open func(t *testing.T) Repositories
+
+// base is deliberately a whole second: backends that store text timestamps
+// must still order it correctly against times with a fractional part.
+var base = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// Run runs every conformance check against the backend returned by open.
+func Run(t *testing.T, open Open) {
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, open(t)) })
+	t.Run("APIKeyUniqueness", func(t *testing.T) { testAPIKeyUniqueness(t, open(t)) })
+	t.Run("DownstreamRequests", func(t *testing.T) { testDownstreamRequests(t, open(t)) })
+	t.Run("UpstreamAttempts", func(t *testing.T) { testUpstreamAttempts(t, open(t)) })
+	t.Run("AuditEventsByRequestID", func(t *testing.T) { testAuditEventsByRequestID(t, open(t)) })
+	t.Run("AuditEventsByTimeRange", func(t *testing.T) { testAuditEventsByTimeRange(t, open(t)) })
+	t.Run("AuditEventQuery", func(t *testing.T) { testAuditEventQuery(t, open(t)) })
+	t.Run("DownstreamRequestList", func(t *testing.T) { testDownstreamRequestList(t, open(t)) })
+	t.Run("IdempotencyRecords", func(t *testing.T) { testIdempotencyRecords(t, open(t)) })
+	t.Run("IdempotencyDeleteExpired", func(t *testing.T) { testIdempotencyDeleteExpired(t, open(t)) })
+	t.Run("SeenSignatures", func(t *testing.T) {
+		repos := open(t)
+		if repos.SeenSignatures == nil {
+			t.Skip("backend has no seen signature repository")
+		}
+		testSeenSignatures(t, repos)
+	})
+	t.Run("StatsRollups", func(t *testing.T) {
+		repos := open(t)
+		if repos.StatsRollups == nil {
+			t.Skip("backend has no stats rollup repository")
+		}
+		testStatsRollups(t, repos)
+	})
+	t.Run("APIKeyActivity", func(t *testing.T) {
+		repos := open(t)
+		if repos.APIKeyActivity == nil {
+			t.Skip("backend has no api key activity repository")
+		}
+		testAPIKeyActivity(t, repos)
+	})
+	t.Run("APIKeyMetadata", func(t *testing.T) {
+		repos := open(t)
+		if repos.APIKeyMetadata == nil {
+			t.Skip("backend has no api key metadata repository")
+		}
+		testAPIKeyMetadata(t, repos)
+	})
+}
+
+func testAPIKeys(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	for _, id := range []string{"k1", "k2", "k3"} {
+		if _, err := repos.APIKeys.GetByID(ctx, id); !repository.IsNotFound(err) {
+			t.Fatalf("GetByID(%s) on an empty store: expected not found, got %v", id, err)
+		}
+	}
+	if _, err := repos.APIKeys.GetByAccessKey(ctx, "ak_missing"); !repository.IsNotFound(err) {
+		t.Fatalf("GetByAccessKey: expected not found, got %v", err)
+	}
+	if err := repos.APIKeys.Revoke(ctx, "missing", base); !repository.IsNotFound(err) {
+		t.Fatalf("Revoke: expected not found, got %v", err)
+	}
+	if err := repos.APIKeys.SetExpired(ctx, "missing", base); !repository.IsNotFound(err) {
+		t.Fatalf("SetExpired: expected not found, got %v", err)
+	}
+	if err := repos.APIKeys.SetExpiresAt(ctx, "missing", base); !repository.IsNotFound(err) {
+		t.Fatalf("SetExpiresAt: expected not found, got %v", err)
+	}
+
+	expiresAt := base.Add(30 * 24 * time.Hour)
+	rotationOf := "k1"
+	keys := []models.APIKey{
+		apiKey("k1", base),
+		apiKey("k2", base.Add(500*time.Millisecond)),
+		apiKey("k3", base.Add(500*time.Millisecond+time.Microsecond)),
+	}
+	keys[1].Description = "second"
+	keys[1].ExpiresAt = &expiresAt
+	keys[1].RotationOf = &rotationOf
+	keys[1].ClientCertSubject = "CN=client"
+	keys[1].Owner = "alice@example.com"
+	keys[1].Labels = map[string]string{models.LabelTeam: "growth", models.LabelEnvironment: "prod"}
+	for _, k := range keys {
+		if err := repos.APIKeys.Create(ctx, k); err != nil {
+			t.Fatalf("Create %s: %v", k.ID, err)
+		}
+	}
+
+	got, err := repos.APIKeys.GetByAccessKey(ctx, "ak_k2")
+	if err != nil {
+		t.Fatalf("GetByAccessKey: %v", err)
+	}
+	if got.ID != "k2" || got.SecretKeyHash != "hash_k2" || got.SecretKeyCiphertext != "v1:k2" || got.Description != "second" ||
+		got.ClientCertSubject != "CN=client" || got.Status != models.APIKeyStatusActive {
+		t.Fatalf("unexpected key: %+v", got)
+	}
+	if got.Owner != "alice@example.com" || len(got.Labels) != 2 || got.Labels[models.LabelTeam] != "growth" || got.Labels[models.LabelEnvironment] != "prod" {
+		t.Fatalf("unexpected owner or labels: %q, %v", got.Owner, got.Labels)
+	}
+	requireTime(t, "created_at", got.CreatedAt, keys[1].CreatedAt)
+	requireTimePtr(t, "expires_at", got.ExpiresAt, &expiresAt)
+	requireTimePtr(t, "revoked_at", got.RevokedAt, nil)
+	if got.RotationOf == nil || *got.RotationOf != "k1" {
+		t.Fatalf("expected rotation_of k1, got %v", got.RotationOf)
+	}
+	if got, err := repos.APIKeys.GetByID(ctx, "k1"); err != nil || got.AccessKey != "ak_k1" || got.ExpiresAt != nil || got.RotationOf != nil || got.Owner != "" || got.Labels != nil {
+		t.Fatalf("GetByID k1 = %+v, %v", got, err)
+	}
+
+	list, err := repos.APIKeys.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if ids := apiKeyIDs(list); ids != "k3,k2,k1" {
+		t.Fatalf("expected keys newest first, got %s", ids)
+	}
+
+	revokedAt := base.Add(time.Hour)
+	if err := repos.APIKeys.Revoke(ctx, "k1", revokedAt); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	got, err = repos.APIKeys.GetByID(ctx, "k1")
+	if err != nil || got.Status != models.APIKeyStatusRevoked {
+		t.Fatalf("expected k1 revoked, got %+v, %v", got, err)
+	}
+	requireTimePtr(t, "revoked_at", got.RevokedAt, &revokedAt)
+
+	// Expiring a revoked key keeps it revoked.
+	if err := repos.APIKeys.SetExpired(ctx, "k1", revokedAt); err != nil {
+		t.Fatalf("SetExpired k1: %v", err)
+	}
+	if got, err := repos.APIKeys.GetByID(ctx, "k1"); err != nil || got.Status != models.APIKeyStatusRevoked {
+		t.Fatalf("expected k1 to stay revoked, got %+v, %v", got, err)
+	}
+	if err := repos.APIKeys.SetExpired(ctx, "k3", revokedAt); err != nil {
+		t.Fatalf("SetExpired k3: %v", err)
+	}
+	got, err = repos.APIKeys.GetByID(ctx, "k3")
+	if err != nil || got.Status != models.APIKeyStatusExpired {
+		t.Fatalf("expected k3 expired, got %+v, %v", got, err)
+	}
+	requireTimePtr(t, "expires_at", got.ExpiresAt, &revokedAt)
+
+	extended := expiresAt.Add(24 * time.Hour)
+	if err := repos.APIKeys.SetExpiresAt(ctx, "k2", extended); err != nil {
+		t.Fatalf("SetExpiresAt: %v", err)
+	}
+	got, err = repos.APIKeys.GetByID(ctx, "k2")
+	if err != nil || got.Status != models.APIKeyStatusActive {
+		t.Fatalf("expected k2 to stay active, got %+v, %v", got, err)
+	}
+	requireTimePtr(t, "expires_at", got.ExpiresAt, &extended)
+}
+
+func testAPIKeyUniqueness(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if err := repos.APIKeys.Create(ctx, apiKey("k1", base)); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	sameAccessKey := apiKey("k2", base)
+	sameAccessKey.AccessKey = "ak_k1"
+	if err := repos.APIKeys.Create(ctx, sameAccessKey); err == nil {
+		t.Fatalf("expected a duplicate access_key to be rejected")
+	}
+	sameID := apiKey("k1", base)
+	sameID.AccessKey = "ak_other"
+	if err := repos.APIKeys.Create(ctx, sameID); err == nil {
+		t.Fatalf("expected a duplicate id to be rejected")
+	}
+	if _, err := repos.APIKeys.GetByAccessKey(ctx, "ak_other"); !repository.IsNotFound(err) {
+		t.Fatalf("expected the rejected key not to be stored, got %v", err)
+	}
+	list, err := repos.APIKeys.List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected only the original key, got %+v, %v", list, err)
+	}
+}
+
+func testDownstreamRequests(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if _, err := repos.DownstreamRequests.GetByID(ctx, "d1"); !repository.IsNotFound(err) {
+		t.Fatalf("GetByID: expected not found, got %v", err)
+	}
+	if _, err := repos.DownstreamRequests.GetByRequestID(ctx, "r1"); !repository.IsNotFound(err) {
+		t.Fatalf("GetByRequestID: expected not found, got %v", err)
+	}
+
+	// Postgres requires the api key the requests reference to exist.
+	if err := repos.APIKeys.Create(ctx, apiKey("k1", base)); err != nil {
+		t.Fatalf("Create key: %v", err)
+	}
+	full := downstreamRequest("d1", "r1", base.Add(250*time.Millisecond))
+	full.QueryString = "Action=CVSync2AsyncSubmitTask&Version=2022-08-31"
+	full.Headers = map[string]any{"Content-Type": "application/json"}
+	full.Body = map[string]any{"req_key": "jimeng_t2v_v30", "frames": 241, "seed": -1, "ratio": 0.5, "tags": []any{"a", nil}, "extra": nil}
+	full.ClientIP = "10.0.0.1"
+	bare := downstreamRequest("d2", "r2", base)
+	for _, r := range []models.DownstreamRequest{full, bare} {
+		if err := repos.DownstreamRequests.Create(ctx, r); err != nil {
+			t.Fatalf("Create %s: %v", r.ID, err)
+		}
+	}
+
+	got, err := repos.DownstreamRequests.GetByRequestID(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetByRequestID: %v", err)
+	}
+	if got.ID != "d1" || got.APIKeyID != "k1" || got.Action != models.DownstreamActionCVSync2AsyncSubmitTask || got.Method != "POST" ||
+		got.Path != "/" || got.QueryString != full.QueryString || got.ClientIP != "10.0.0.1" {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	requireTime(t, "received_at", got.ReceivedAt, full.ReceivedAt)
+	requireJSON(t, "headers", got.Headers, full.Headers)
+	requireJSON(t, "body", got.Body, full.Body)
+
+	got, err = repos.DownstreamRequests.GetByID(ctx, "d2")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.RequestID != "r2" || got.QueryString != "" || got.ClientIP != "" || len(got.Headers) != 0 || len(got.Body) != 0 {
+		t.Fatalf("expected empty optional fields to read back empty, got %+v", got)
+	}
+
+	dup := downstreamRequest("d3", "r1", base)
+	if err := repos.DownstreamRequests.Create(ctx, dup); err == nil {
+		t.Fatalf("expected a duplicate request_id to be rejected")
+	}
+	if _, err := repos.DownstreamRequests.GetByID(ctx, "d3"); !repository.IsNotFound(err) {
+		t.Fatalf("expected the rejected request not to be stored, got %v", err)
+	}
+}
+
+func testUpstreamAttempts(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if list, err := repos.UpstreamAttempts.ListByRequestID(ctx, "r1"); err != nil || len(list) != 0 {
+		t.Fatalf("expected no attempts for an unknown request, got %+v, %v", list, err)
+	}
+
+	errStr := "upstream 500"
+	third := upstreamAttempt("u3", "r1", 3, base.Add(2*time.Second))
+	third.RequestHeaders = map[string]any{"X-Date": "20260301T120002Z"}
+	third.RequestBody = map[string]any{"req_key": "jimeng_t2v_v30"}
+	third.ResponseStatus = 500
+	third.ResponseHeaders = map[string]any{"Content-Type": "application/json"}
+	third.ResponseBody = map[string]any{"code": 50500, "message": "internal"}
+	third.LatencyMs = 1234
+	third.Error = &errStr
+	// Inserted out of order, and with a later attempt sent earlier, so the
+	// list must be ordered by attempt number rather than insertion or time.
+	attempts := []models.UpstreamAttempt{
+		third,
+		upstreamAttempt("u1", "r1", 1, base.Add(3*time.Second)),
+		upstreamAttempt("u2", "r1", 2, base.Add(time.Second)),
+		upstreamAttempt("u9", "r2", 1, base),
+	}
+	for _, a := range attempts {
+		if err := repos.UpstreamAttempts.Create(ctx, a); err != nil {
+			t.Fatalf("Create %s: %v", a.ID, err)
+		}
+	}
+
+	list, err := repos.UpstreamAttempts.ListByRequestID(ctx, "r1")
+	if err != nil {
+		t.Fatalf("ListByRequestID: %v", err)
+	}
+	if len(list) != 3 || list[0].ID != "u1" || list[1].ID != "u2" || list[2].ID != "u3" {
+		t.Fatalf("expected attempts in attempt_number order, got %+v", list)
+	}
+	got := list[2]
+	if got.AttemptNumber != 3 || got.UpstreamAction != "CVSync2AsyncSubmitTask" || got.ResponseStatus != 500 || got.LatencyMs != 1234 ||
+		got.Error == nil || *got.Error != errStr {
+		t.Fatalf("unexpected attempt: %+v", got)
+	}
+	requireTime(t, "sent_at", got.SentAt, third.SentAt)
+	requireJSON(t, "request_headers", got.RequestHeaders, third.RequestHeaders)
+	requireJSON(t, "request_body", got.RequestBody, third.RequestBody)
+	requireJSON(t, "response_headers", got.ResponseHeaders, third.ResponseHeaders)
+	requireJSON(t, "response_body", got.ResponseBody, third.ResponseBody)
+	if first := list[0]; first.Error != nil || len(first.RequestBody) != 0 || first.ResponseBody != nil {
+		t.Fatalf("expected empty optional fields to read back empty, got %+v", first)
+	}
+}
+
+func testAuditEventsByRequestID(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if list, err := repos.AuditEvents.ListByRequestID(ctx, "r1"); err != nil || len(list) != 0 {
+		t.Fatalf("expected no events for an unknown request, got %+v, %v", list, err)
+	}
+
+	// The fractional parts have different lengths on purpose: the list must
+	// follow time order, not the order of the stored text.
+	events := []models.AuditEvent{
+		auditEvent("e3", "r1", base.Add(time.Second)),
+		auditEvent("e1", "r1", base),
+		auditEvent("e2", "r1", base.Add(123456*time.Microsecond)),
+		auditEvent("e4", "r2", base.Add(500*time.Millisecond)),
+	}
+	events[1].Actor = "k1"
+	events[1].Metadata = map[string]any{"response_status": 200, "latency_ms": int64(12), "ratio": 0.5, "note": nil}
+	for _, e := range events {
+		if err := repos.AuditEvents.Create(ctx, &e); err != nil {
+			t.Fatalf("Create %s: %v", e.ID, err)
+		}
+		if e.Sequence == 0 || e.Hash == "" {
+			t.Fatalf("expected Create to fill in the chain fields of %s, got %+v", e.ID, e)
+		}
+	}
+	dup := auditEvent("e1", "r3", base)
+	if err := repos.AuditEvents.Create(ctx, &dup); err == nil {
+		t.Fatalf("expected a duplicate id to be rejected")
+	}
+
+	list, err := repos.AuditEvents.ListByRequestID(ctx, "r1")
+	if err != nil {
+		t.Fatalf("ListByRequestID: %v", err)
+	}
+	if ids := auditEventIDs(list); ids != "e1,e2,e3" {
+		t.Fatalf("expected events in created_at order, got %s", ids)
+	}
+	got := list[0]
+	if got.RequestID != "r1" || got.EventType != models.EventTypeUpstreamResponse || got.Actor != "k1" || got.Action != "relay_call" || got.Resource != "relay.call" {
+		t.Fatalf("unexpected event: %+v", got)
+	}
+	requireTime(t, "created_at", got.CreatedAt, base)
+	requireJSON(t, "metadata", got.Metadata, events[1].Metadata)
+	if len(list[1].Metadata) != 0 {
+		t.Fatalf("expected empty metadata to read back empty, got %+v", list[1].Metadata)
+	}
+}
+
+func testAuditEventsByTimeRange(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	start := base.Add(time.Second)
+	end := start.Add(time.Second)
+	events := []models.AuditEvent{
+		auditEvent("before", "r1", start.Add(-time.Microsecond)),
+		auditEvent("start", "r1", start),
+		auditEvent("inside", "r1", start.Add(500*time.Millisecond)),
+		auditEvent("end", "r1", end),
+		auditEvent("after", "r1", end.Add(time.Microsecond)),
+	}
+	for _, e := range events {
+		if err := repos.AuditEvents.Create(ctx, &e); err != nil {
+			t.Fatalf("Create %s: %v", e.ID, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		start, end time.Time
+		want       string
+	}{
+		{name: "inclusive bounds", start: start, end: end, want: "start,inside,end"},
+		{name: "single instant", start: start, end: start, want: "start"},
+		{name: "sub-second bounds", start: start.Add(time.Microsecond), end: end.Add(-time.Microsecond), want: "inside"},
+		{name: "wide", start: base, end: end.Add(time.Hour), want: "before,start,inside,end,after"},
+		{name: "empty", start: end.Add(time.Hour), end: end.Add(2 * time.Hour), want: ""},
+	} {
+		list, err := repos.AuditEvents.ListByTimeRange(ctx, tc.start, tc.end)
+		if err != nil {
+			t.Fatalf("%s: ListByTimeRange: %v", tc.name, err)
+		}
+		if ids := auditEventIDs(list); ids != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.want, ids)
+		}
+	}
+}
+
+func testAuditEventQuery(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	for _, id := range []string{"k1", "k2"} {
+		if err := repos.APIKeys.Create(ctx, apiKey(id, base)); err != nil {
+			t.Fatalf("Create key %s: %v", id, err)
+		}
+	}
+	r2 := downstreamRequest("d2", "r2", base)
+	r2.APIKeyID = "k2"
+	for _, r := range []models.DownstreamRequest{downstreamRequest("d1", "r1", base), r2} {
+		if err := repos.DownstreamRequests.Create(ctx, r); err != nil {
+			t.Fatalf("Create request %s: %v", r.ID, err)
+		}
+	}
+
+	t1 := base.Add(time.Second)
+	received := auditEvent("a1", "r1", base)
+	received.EventType = models.EventTypeRequestReceived
+	received.Action = "relay_submit"
+	ok := auditEvent("a2", "r1", t1)
+	ok.Metadata = map[string]any{"response_status": 200, "latency_ms": 5}
+	// a3 shares a2's timestamp, so ordering and cursors fall back to the id.
+	failed := auditEvent("a3", "r2", t1)
+	failed.Metadata = map[string]any{"response_status": 502, "error": "bad gateway"}
+	internal := auditEvent("a4", "r2", base.Add(2*time.Second))
+	internal.EventType = models.EventTypeError
+	sent := auditEvent("a5", "r1", base.Add(3*time.Second))
+	sent.EventType = models.EventTypeResponseSent
+	sent.Metadata = map[string]any{"response_status": 404, "error": nil}
+	for _, e := range []models.AuditEvent{sent, internal, failed, ok, received} {
+		if err := repos.AuditEvents.Create(ctx, &e); err != nil {
+			t.Fatalf("Create %s: %v", e.ID, err)
+		}
+	}
+
+	yes, no := true, false
+	for _, tc := range []struct {
+		name  string
+		query repository.AuditEventQuery
+		want  string
+	}{
+		{name: "all", query: repository.AuditEventQuery{}, want: "a1,a2,a3,a4,a5"},
+		{name: "desc", query: repository.AuditEventQuery{Order: repository.SortDesc}, want: "a5,a4,a3,a2,a1"},
+		{name: "window", query: repository.AuditEventQuery{From: t1, To: base.Add(3 * time.Second)}, want: "a2,a3,a4"},
+		{name: "api key", query: repository.AuditEventQuery{APIKeyID: "k2"}, want: "a3,a4"},
+		{name: "api key set", query: repository.AuditEventQuery{APIKeyIDs: []string{"k2", "k9"}}, want: "a3,a4"},
+		{name: "api key outside set", query: repository.AuditEventQuery{APIKeyID: "k1", APIKeyIDs: []string{"k2"}}, want: ""},
+		{name: "action", query: repository.AuditEventQuery{Action: "relay_submit"}, want: "a1"},
+		{name: "event type", query: repository.AuditEventQuery{EventType: models.EventTypeUpstreamResponse}, want: "a2,a3"},
+		{name: "min status", query: repository.AuditEventQuery{MinStatus: 400}, want: "a3,a5"},
+		{name: "max status", query: repository.AuditEventQuery{MaxStatus: 299}, want: "a2"},
+		{name: "status range", query: repository.AuditEventQuery{MinStatus: 400, MaxStatus: 499}, want: "a5"},
+		{name: "has error", query: repository.AuditEventQuery{HasError: &yes}, want: "a3,a4"},
+		{name: "no error", query: repository.AuditEventQuery{HasError: &no}, want: "a1,a2,a5"},
+		{name: "limit", query: repository.AuditEventQuery{Limit: 2}, want: "a1,a2"},
+		{name: "after", query: repository.AuditEventQuery{After: &repository.Cursor{Time: t1, ID: "a2"}}, want: "a3,a4,a5"},
+		{name: "after desc", query: repository.AuditEventQuery{Order: repository.SortDesc, After: &repository.Cursor{Time: t1, ID: "a3"}}, want: "a2,a1"},
+		{name: "combined", query: repository.AuditEventQuery{APIKeyID: "k1", HasError: &no, MinStatus: 200, Order: repository.SortDesc}, want: "a5,a2"},
+	} {
+		if tc.query.Limit == 0 {
+			tc.query.Limit = 100
+		}
+		list, err := repos.AuditEvents.Query(ctx, tc.query)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", tc.name, err)
+		}
+		if ids := auditEventIDs(list); ids != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.want, ids)
+		}
+	}
+
+	got, err := repos.AuditEvents.Query(ctx, repository.AuditEventQuery{APIKeyID: "k2", HasError: &yes, MinStatus: 500, Limit: 1})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Query: expected a3, got %v, %v", got, err)
+	}
+	requireTime(t, "created_at", got[0].CreatedAt, t1)
+	requireJSON(t, "metadata", got[0].Metadata, failed.Metadata)
+
+	for _, order := range []repository.SortOrder{repository.SortAsc, repository.SortDesc} {
+		var pages []string
+		var after *repository.Cursor
+		for {
+			page, err := repos.AuditEvents.Query(ctx, repository.AuditEventQuery{Order: order, After: after, Limit: 2})
+			if err != nil {
+				t.Fatalf("%s page: %v", order, err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			pages = append(pages, auditEventIDs(page))
+			last := page[len(page)-1]
+			after = &repository.Cursor{Time: last.CreatedAt, ID: last.ID}
+		}
+		want := "a1,a2|a3,a4|a5"
+		if order == repository.SortDesc {
+			want = "a5,a4|a3,a2|a1"
+		}
+		if got := strings.Join(pages, "|"); got != want {
+			t.Fatalf("%s pages: expected %q, got %q", order, want, got)
+		}
+	}
+
+	counter, isCounter := repos.AuditEvents.(repository.AuditEventCounter)
+	if !isCounter {
+		t.Fatalf("audit event repository does not count by api key")
+	}
+	// a6 belongs to no stored request and counts under the empty key.
+	orphan := auditEvent("a6", "r9", base.Add(4*time.Second))
+	if err := repos.AuditEvents.Create(ctx, &orphan); err != nil {
+		t.Fatalf("Create a6: %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		query repository.AuditEventQuery
+		want  map[string]int64
+	}{
+		{name: "all", query: repository.AuditEventQuery{Limit: 1, After: &repository.Cursor{Time: base.Add(time.Hour), ID: "z"}}, want: map[string]int64{"k1": 3, "k2": 2, "": 1}},
+		{name: "has error", query: repository.AuditEventQuery{HasError: &yes}, want: map[string]int64{"k2": 2}},
+		{name: "api key set", query: repository.AuditEventQuery{APIKeyIDs: []string{"k1"}, MinStatus: 200}, want: map[string]int64{"k1": 2}},
+		{name: "none", query: repository.AuditEventQuery{Action: "missing"}, want: map[string]int64{}},
+	} {
+		got, err := counter.CountByAPIKey(ctx, tc.query)
+		if err != nil {
+			t.Fatalf("%s: CountByAPIKey: %v", tc.name, err)
+		}
+		requireJSON(t, tc.name+" counts", got, tc.want)
+	}
+}
+
+func testDownstreamRequestList(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	for _, id := range []string{"k1", "k2"} {
+		if err := repos.APIKeys.Create(ctx, apiKey(id, base)); err != nil {
+			t.Fatalf("Create key %s: %v", id, err)
+		}
+	}
+	t1 := base.Add(time.Second)
+	retried := downstreamRequest("d1", "r1", base)
+	limited := downstreamRequest("d2", "r2", t1)
+	limited.APIKeyID = "k2"
+	limited.Action = models.DownstreamActionCVSync2AsyncGetResult
+	// d3 shares d2's timestamp and never reached upstream.
+	pending := downstreamRequest("d3", "r3", t1)
+	polled := downstreamRequest("d4", "r4", base.Add(2*time.Second))
+	polled.Action = models.DownstreamActionCVSync2AsyncGetResult
+	for _, r := range []models.DownstreamRequest{polled, pending, limited, retried} {
+		if err := repos.DownstreamRequests.Create(ctx, r); err != nil {
+			t.Fatalf("Create %s: %v", r.ID, err)
+		}
+	}
+
+	upstreamErr := func(msg string) *string { return &msg }
+	// d1 failed once and then succeeded; filters look at its latest attempt.
+	first := upstreamAttempt("u1", "r1", 1, base)
+	first.ResponseStatus = 500
+	first.Error = upstreamErr("internal error")
+	throttled := upstreamAttempt("u3", "r2", 1, t1)
+	throttled.ResponseStatus = 429
+	throttled.Error = upstreamErr("rate limited")
+	for _, a := range []models.UpstreamAttempt{
+		upstreamAttempt("u2", "r1", 2, base),
+		first,
+		throttled,
+		upstreamAttempt("u4", "r4", 1, base.Add(2*time.Second)),
+	} {
+		if err := repos.UpstreamAttempts.Create(ctx, a); err != nil {
+			t.Fatalf("Create attempt %s: %v", a.ID, err)
+		}
+	}
+
+	yes, no := true, false
+	for _, tc := range []struct {
+		name  string
+		query repository.DownstreamRequestQuery
+		want  string
+	}{
+		{name: "all", query: repository.DownstreamRequestQuery{}, want: "d1,d2,d3,d4"},
+		{name: "desc", query: repository.DownstreamRequestQuery{Order: repository.SortDesc}, want: "d4,d3,d2,d1"},
+		{name: "window", query: repository.DownstreamRequestQuery{From: t1, To: base.Add(2 * time.Second)}, want: "d2,d3"},
+		{name: "api key", query: repository.DownstreamRequestQuery{APIKeyID: "k1"}, want: "d1,d3,d4"},
+		{name: "api key set", query: repository.DownstreamRequestQuery{APIKeyIDs: []string{"k1", "k9"}}, want: "d1,d3,d4"},
+		{name: "api key outside set", query: repository.DownstreamRequestQuery{APIKeyID: "k2", APIKeyIDs: []string{"k1"}}, want: ""},
+		{name: "action", query: repository.DownstreamRequestQuery{Action: models.DownstreamActionCVSync2AsyncGetResult}, want: "d2,d4"},
+		{name: "min status", query: repository.DownstreamRequestQuery{MinStatus: 400}, want: "d2"},
+		{name: "max status", query: repository.DownstreamRequestQuery{MaxStatus: 299}, want: "d1,d4"},
+		{name: "has error", query: repository.DownstreamRequestQuery{HasError: &yes}, want: "d2"},
+		{name: "no error", query: repository.DownstreamRequestQuery{HasError: &no}, want: "d1,d3,d4"},
+		{name: "limit", query: repository.DownstreamRequestQuery{Limit: 3}, want: "d1,d2,d3"},
+		{name: "after", query: repository.DownstreamRequestQuery{After: &repository.Cursor{Time: t1, ID: "d2"}}, want: "d3,d4"},
+		{name: "after desc", query: repository.DownstreamRequestQuery{Order: repository.SortDesc, After: &repository.Cursor{Time: t1, ID: "d3"}}, want: "d2,d1"},
+	} {
+		if tc.query.Limit == 0 {
+			tc.query.Limit = 100
+		}
+		list, err := repos.DownstreamRequests.List(ctx, tc.query)
+		if err != nil {
+			t.Fatalf("%s: List: %v", tc.name, err)
+		}
+		ids := make([]string, 0, len(list))
+		for _, r := range list {
+			ids = append(ids, r.ID)
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+
+	got, err := repos.DownstreamRequests.List(ctx, repository.DownstreamRequestQuery{APIKeyID: "k2", Limit: 1})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("List: expected d2, got %v, %v", got, err)
+	}
+	if got[0].RequestID != "r2" || got[0].Action != models.DownstreamActionCVSync2AsyncGetResult {
+		t.Fatalf("unexpected request: %+v", got[0])
+	}
+	requireTime(t, "received_at", got[0].ReceivedAt, t1)
+
+	counter, isCounter := repos.DownstreamRequests.(repository.DownstreamRequestCounter)
+	if !isCounter {
+		t.Fatalf("downstream request repository does not count by api key")
+	}
+	for _, tc := range []struct {
+		name  string
+		query repository.DownstreamRequestQuery
+		want  map[string]int64
+	}{
+		{name: "all", query: repository.DownstreamRequestQuery{Limit: 1, After: &repository.Cursor{Time: base.Add(time.Hour), ID: "z"}}, want: map[string]int64{"k1": 3, "k2": 1}},
+		{name: "action", query: repository.DownstreamRequestQuery{Action: models.DownstreamActionCVSync2AsyncGetResult}, want: map[string]int64{"k1": 1, "k2": 1}},
+		{name: "no error", query: repository.DownstreamRequestQuery{HasError: &no, APIKeyIDs: []string{"k1", "k2"}}, want: map[string]int64{"k1": 3}},
+		{name: "none", query: repository.DownstreamRequestQuery{MinStatus: 500}, want: map[string]int64{}},
+	} {
+		got, err := counter.CountByAPIKey(ctx, tc.query)
+		if err != nil {
+			t.Fatalf("%s: CountByAPIKey: %v", tc.name, err)
+		}
+		requireJSON(t, tc.name+" counts", got, tc.want)
+	}
+}
+
+func testIdempotencyRecords(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if _, err := repos.IdempotencyRecords.GetByKey(ctx, "idem-1"); !repository.IsNotFound(err) {
+		t.Fatalf("GetByKey: expected not found, got %v", err)
+	}
+
+	record := idempotencyRecord("i1", "idem-1", base, base.Add(time.Hour))
+	record.ResponseBody = map[string]any{"code": 10000, "data": map[string]any{"task_id": "t1"}}
+	if err := repos.IdempotencyRecords.Create(ctx, record); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	bare := idempotencyRecord("i2", "idem-2", base, base.Add(time.Hour))
+	if err := repos.IdempotencyRecords.Create(ctx, bare); err != nil {
+		t.Fatalf("Create bare: %v", err)
+	}
+
+	got, err := repos.IdempotencyRecords.GetByKey(ctx, "idem-1")
+	if err != nil {
+		t.Fatalf("GetByKey: %v", err)
+	}
+	if got.ID != "i1" || got.RequestHash != "hash_i1" || got.ResponseStatus != 200 {
+		t.Fatalf("unexpected record: %+v", got)
+	}
+	requireTime(t, "created_at", got.CreatedAt, record.CreatedAt)
+	requireTime(t, "expires_at", got.ExpiresAt, record.ExpiresAt)
+	requireJSON(t, "response_body", got.ResponseBody, record.ResponseBody)
+	if got, err := repos.IdempotencyRecords.GetByKey(ctx, "idem-2"); err != nil || got.ResponseBody != nil {
+		t.Fatalf("expected a nil response body to read back nil, got %+v, %v", got, err)
+	}
+
+	dupKey := idempotencyRecord("i3", "idem-1", base, base.Add(time.Hour))
+	if err := repos.IdempotencyRecords.Create(ctx, dupKey); err == nil {
+		t.Fatalf("expected a duplicate idempotency_key to be rejected")
+	}
+	dupID := idempotencyRecord("i1", "idem-3", base, base.Add(time.Hour))
+	if err := repos.IdempotencyRecords.Create(ctx, dupID); err == nil {
+		t.Fatalf("expected a duplicate id to be rejected")
+	}
+	if _, err := repos.IdempotencyRecords.GetByKey(ctx, "idem-3"); !repository.IsNotFound(err) {
+		t.Fatalf("expected the rejected record not to be stored, got %v", err)
+	}
+	if got, err := repos.IdempotencyRecords.GetByKey(ctx, "idem-1"); err != nil || got.ID != "i1" {
+		t.Fatalf("expected the original record to be kept, got %+v, %v", got, err)
+	}
+}
+
+func testIdempotencyDeleteExpired(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	now := base.Add(time.Hour)
+	for _, r := range []models.IdempotencyRecord{
+		idempotencyRecord("i1", "expired", base, now.Add(-time.Second)),
+		idempotencyRecord("i2", "just-expired", base, now.Add(-time.Microsecond)),
+		idempotencyRecord("i3", "expires-now", base, now),
+		idempotencyRecord("i4", "live", base, now.Add(time.Microsecond)),
+		idempotencyRecord("i5", "long-lived", base, now.Add(time.Hour)),
+	} {
+		if err := repos.IdempotencyRecords.Create(ctx, r); err != nil {
+			t.Fatalf("Create %s: %v", r.ID, err)
+		}
+	}
+
+	n, err := repos.IdempotencyRecords.DeleteExpired(ctx, now)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 records expiring at or before now, got %d, %v", n, err)
+	}
+	if n, err := repos.IdempotencyRecords.DeleteExpired(ctx, now); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to delete, got %d, %v", n, err)
+	}
+	for _, key := range []string{"expired", "just-expired", "expires-now"} {
+		if _, err := repos.IdempotencyRecords.GetByKey(ctx, key); !repository.IsNotFound(err) {
+			t.Fatalf("expected %s to be deleted, got %v", key, err)
+		}
+	}
+	for _, key := range []string{"live", "long-lived"} {
+		if _, err := repos.IdempotencyRecords.GetByKey(ctx, key); err != nil {
+			t.Fatalf("expected %s to be kept: %v", key, err)
+		}
+	}
+	// A deleted record's key can be used again.
+	if err := repos.IdempotencyRecords.Create(ctx, idempotencyRecord("i6", "expired", now, now.Add(time.Hour))); err != nil {
+		t.Fatalf("Create after expiry: %v", err)
+	}
+}
+
+func testSeenSignatures(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	now := base.Add(time.Hour)
+	for _, tc := range []struct {
+		signature string
+		expiresAt time.Time
+	}{
+		{"sig-expired", now.Add(-time.Microsecond)},
+		{"sig-now", now},
+		{"sig-live", now.Add(time.Microsecond)},
+	} {
+		fresh, err := repos.SeenSignatures.Remember(ctx, tc.signature, tc.expiresAt)
+		if err != nil || !fresh {
+			t.Fatalf("Remember %s = %v, %v; expected a new signature", tc.signature, fresh, err)
+		}
+	}
+	if fresh, err := repos.SeenSignatures.Remember(ctx, "sig-live", now.Add(time.Hour)); err != nil || fresh {
+		t.Fatalf("expected a replayed signature to be reported, got %v, %v", fresh, err)
+	}
+
+	n, err := repos.SeenSignatures.DeleteExpired(ctx, now)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 signatures expiring at or before now, got %d, %v", n, err)
+	}
+	if n, err := repos.SeenSignatures.DeleteExpired(ctx, now); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to delete, got %d, %v", n, err)
+	}
+	if fresh, err := repos.SeenSignatures.Remember(ctx, "sig-now", now.Add(time.Hour)); err != nil || !fresh {
+		t.Fatalf("expected a deleted signature to be new again, got %v, %v", fresh, err)
+	}
+	if fresh, err := repos.SeenSignatures.Remember(ctx, "sig-live", now.Add(time.Hour)); err != nil || fresh {
+		t.Fatalf("expected the live signature to be kept, got %v, %v", fresh, err)
+	}
+}
+
+func testStatsRollups(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	if _, err := repos.StatsRollups.FirstSampleTime(ctx); !repository.IsNotFound(err) {
+		t.Fatalf("FirstSampleTime on an empty store: expected not found, got %v", err)
+	}
+	if watermark, err := repos.StatsRollups.Watermark(ctx); err != nil || !watermark.IsZero() {
+		t.Fatalf("expected a zero watermark before the first rollup, got %s, %v", watermark, err)
+	}
+
+	if err := repos.APIKeys.Create(ctx, apiKey("k1", base)); err != nil {
+		t.Fatalf("Create key: %v", err)
+	}
+	if err := repos.DownstreamRequests.Create(ctx, downstreamRequest("d1", "r1", base)); err != nil {
+		t.Fatalf("Create request: %v", err)
+	}
+	errStr := "timeout"
+	failed := upstreamAttempt("u1", "r1", 1, base.Add(1500*time.Millisecond))
+	failed.ResponseStatus = 0
+	failed.LatencyMs = 30000
+	failed.Error = &errStr
+	retried := upstreamAttempt("u2", "r1", 2, base.Add(time.Hour))
+	retried.LatencyMs = 120
+	for _, a := range []models.UpstreamAttempt{retried, failed} {
+		if err := repos.UpstreamAttempts.Create(ctx, a); err != nil {
+			t.Fatalf("Create %s: %v", a.ID, err)
+		}
+	}
+
+	first, err := repos.StatsRollups.FirstSampleTime(ctx)
+	if err != nil {
+		t.Fatalf("FirstSampleTime: %v", err)
+	}
+	requireTime(t, "first sample", first, failed.SentAt)
+
+	// The range is half-open: the attempt sent exactly at base+1h is left out.
+	samples, err := repos.StatsRollups.RollupSamples(ctx, base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RollupSamples: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("expected 1 sample, got %+v", samples)
+	}
+	got := samples[0]
+	if got.APIKeyID != "k1" || got.Action != models.DownstreamActionCVSync2AsyncSubmitTask || got.AttemptNumber != 1 ||
+		got.ResponseStatus != 0 || got.LatencyMs != 30000 || !got.Failed {
+		t.Fatalf("unexpected sample: %+v", got)
+	}
+	requireTime(t, "sent_at", got.SentAt, failed.SentAt)
+
+	rollup := func(hour time.Time, key, class string, attempts int64) models.StatsRollup {
+		return models.StatsRollup{
+			Hour: hour, APIKeyID: key, Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: class,
+			Attempts: attempts, Retries: 1, Errors: 1, LatencySumMs: 300, LatencyP50Ms: 100, LatencyP95Ms: 150, LatencyMaxMs: 200,
+		}
+	}
+	day := []models.StatsRollup{
+		rollup(base.Add(time.Hour), "k1", "2xx", 1),
+		rollup(base, "k2", "2xx", 2),
+		rollup(base, "k1", models.StatusClassNoResponse, 3),
+		rollup(base, "k1", "2xx", 4),
+	}
+	if err := repos.StatsRollups.ReplaceRollups(ctx, base, base.Add(2*time.Hour), day); err != nil {
+		t.Fatalf("ReplaceRollups: %v", err)
+	}
+	if watermark, err := repos.StatsRollups.Watermark(ctx); err != nil {
+		t.Fatalf("Watermark: %v", err)
+	} else {
+		requireTime(t, "watermark", watermark, base.Add(2*time.Hour))
+	}
+
+	// Replacing the second hour drops its old rollup and leaves the first
+	// hour alone; an earlier range does not move the watermark back.
+	if err := repos.StatsRollups.ReplaceRollups(ctx, base.Add(time.Hour), base.Add(2*time.Hour), []models.StatsRollup{rollup(base.Add(time.Hour), "k1", "5xx", 5)}); err != nil {
+		t.Fatalf("ReplaceRollups second hour: %v", err)
+	}
+	if err := repos.StatsRollups.ReplaceRollups(ctx, base.Add(-time.Hour), base, nil); err != nil {
+		t.Fatalf("ReplaceRollups earlier hour: %v", err)
+	}
+	if watermark, err := repos.StatsRollups.Watermark(ctx); err != nil {
+		t.Fatalf("Watermark: %v", err)
+	} else {
+		requireTime(t, "watermark", watermark, base.Add(2*time.Hour))
+	}
+
+	list, err := repos.StatsRollups.ListRollups(ctx, base, base.Add(2*time.Hour), "")
+	if err != nil {
+		t.Fatalf("ListRollups: %v", err)
+	}
+	var order []int64
+	for _, r := range list {
+		order = append(order, r.Attempts)
+	}
+	if len(order) != 4 || order[0] != 4 || order[1] != 3 || order[2] != 2 || order[3] != 5 {
+		t.Fatalf("expected rollups ordered by hour, key, action and class, got attempts %v", order)
+	}
+	if r := list[0]; r.StatusClass != "2xx" || r.Retries != 1 || r.Errors != 1 || r.LatencySumMs != 300 ||
+		r.LatencyP50Ms != 100 || r.LatencyP95Ms != 150 || r.LatencyMaxMs != 200 {
+		t.Fatalf("unexpected rollup: %+v", r)
+	}
+	requireTime(t, "hour", list[0].Hour, base)
+
+	list, err = repos.StatsRollups.ListRollups(ctx, base, base.Add(time.Hour), "k2")
+	if err != nil || len(list) != 1 || list[0].APIKeyID != "k2" {
+		t.Fatalf("expected only k2's rollup in the first hour, got %+v, %v", list, err)
+	}
+}
+
+func testAPIKeyActivity(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	for _, id := range []string{"k1", "k2"} {
+		if err := repos.APIKeys.Create(ctx, apiKey(id, base)); err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+	}
+	if got, err := repos.APIKeys.GetByID(ctx, "k1"); err != nil || got.LastUsedAt != nil || got.LastUsedIP != "" || got.RequestCount != 0 {
+		t.Fatalf("expected an unused key, got %+v, %v", got, err)
+	}
+
+	day := base.Truncate(24 * time.Hour)
+	late := base.Add(2*time.Hour + 500*time.Millisecond)
+	if err := repos.APIKeyActivity.RecordActivity(ctx, []models.APIKeyActivity{
+		{APIKeyID: "k1", Day: day, Requests: 3, LastUsedAt: late, LastUsedIP: "10.0.0.1"},
+		{APIKeyID: "k1", Day: day.AddDate(0, 0, -1), Requests: 2, LastUsedAt: base.AddDate(0, 0, -1), LastUsedIP: "10.0.0.9"},
+		{APIKeyID: "missing", Day: day, Requests: 7, LastUsedAt: base, LastUsedIP: "10.0.0.2"},
+	}); err != nil {
+		t.Fatalf("RecordActivity: %v", err)
+	}
+	// A later flush adds to the same day; its older last use is ignored.
+	if err := repos.APIKeyActivity.RecordActivity(ctx, []models.APIKeyActivity{
+		{APIKeyID: "k1", Day: day, Requests: 1, LastUsedAt: base, LastUsedIP: "10.0.0.3"},
+	}); err != nil {
+		t.Fatalf("RecordActivity again: %v", err)
+	}
+
+	got, err := repos.APIKeys.GetByID(ctx, "k1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.RequestCount != 6 || got.LastUsedIP != "10.0.0.1" {
+		t.Fatalf("unexpected usage: count %d, ip %q", got.RequestCount, got.LastUsedIP)
+	}
+	requireTimePtr(t, "last_used_at", got.LastUsedAt, &late)
+	if got, err := repos.APIKeys.GetByID(ctx, "k2"); err != nil || got.LastUsedAt != nil || got.RequestCount != 0 {
+		t.Fatalf("expected k2 untouched, got %+v, %v", got, err)
+	}
+
+	counts, err := repos.APIKeyActivity.RequestCountsSince(ctx, day)
+	if err != nil {
+		t.Fatalf("RequestCountsSince: %v", err)
+	}
+	if len(counts) != 1 || counts["k1"] != 4 {
+		t.Fatalf("expected 4 requests for k1 today, got %v", counts)
+	}
+	counts, err = repos.APIKeyActivity.RequestCountsSince(ctx, day.AddDate(0, 0, -1))
+	if err != nil || counts["k1"] != 6 || counts["missing"] != 0 {
+		t.Fatalf("expected 6 requests for k1 since yesterday, got %v, %v", counts, err)
+	}
+}
+
+func testAPIKeyMetadata(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	updatedAt := base.Add(time.Hour + 250*time.Millisecond)
+	if err := repos.APIKeyMetadata.UpdateMetadata(ctx, "missing", models.APIKeyMetadata{Owner: "bob"}, updatedAt); !repository.IsNotFound(err) {
+		t.Fatalf("UpdateMetadata: expected not found, got %v", err)
+	}
+	key := apiKey("k1", base)
+	key.Description = "before"
+	key.Labels = map[string]string{models.LabelTeam: "search"}
+	if err := repos.APIKeys.Create(ctx, key); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	labels := map[string]string{models.LabelTeam: "growth", "cost-center": "cc-1042"}
+	if err := repos.APIKeyMetadata.UpdateMetadata(ctx, "k1", models.APIKeyMetadata{Description: "after", Owner: "bob", Labels: labels}, updatedAt); err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	// The repository must not keep a reference to the caller's map.
+	labels["cost-center"] = "changed"
+	got, err := repos.APIKeys.GetByAccessKey(ctx, "ak_k1")
+	if err != nil {
+		t.Fatalf("GetByAccessKey: %v", err)
+	}
+	if got.Description != "after" || got.Owner != "bob" || len(got.Labels) != 2 || got.Labels[models.LabelTeam] != "growth" || got.Labels["cost-center"] != "cc-1042" {
+		t.Fatalf("unexpected metadata: %q, %q, %v", got.Description, got.Owner, got.Labels)
+	}
+	requireTime(t, "updated_at", got.UpdatedAt, updatedAt)
+	requireTime(t, "created_at", got.CreatedAt, base)
+
+	if err := repos.APIKeyMetadata.UpdateMetadata(ctx, "k1", models.APIKeyMetadata{}, updatedAt); err != nil {
+		t.Fatalf("UpdateMetadata clearing: %v", err)
+	}
+	list, err := repos.APIKeys.List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List: %v, %v", list, err)
+	}
+	if got := list[0]; got.Description != "" || got.Owner != "" || got.Labels != nil {
+		t.Fatalf("expected cleared metadata, got %q, %q, %v", got.Description, got.Owner, got.Labels)
+	}
+}
+
+func apiKey(id string, createdAt time.Time) models.APIKey {
+	return models.APIKey{
+		ID:                  id,
+		AccessKey:           "ak_" + id,
+		SecretKeyHash:       "hash_" + id,
+		SecretKeyCiphertext: "v1:" + id,
+		Status:              models.APIKeyStatusActive,
+		CreatedAt:           createdAt,
+		UpdatedAt:           createdAt,
+	}
+}
+
+func downstreamRequest(id, requestID string, receivedAt time.Time) models.DownstreamRequest {
+	return models.DownstreamRequest{
+		ID:         id,
+		RequestID:  requestID,
+		APIKeyID:   "k1",
+		Action:     models.DownstreamActionCVSync2AsyncSubmitTask,
+		Method:     "POST",
+		Path:       "/",
+		ReceivedAt: receivedAt,
+	}
+}
+
+func upstreamAttempt(id, requestID string, number int, sentAt time.Time) models.UpstreamAttempt {
+	return models.UpstreamAttempt{
+		ID:             id,
+		RequestID:      requestID,
+		AttemptNumber:  number,
+		UpstreamAction: "CVSync2AsyncSubmitTask",
+		ResponseStatus: 200,
+		SentAt:         sentAt,
+	}
+}
+
+func auditEvent(id, requestID string, createdAt time.Time) models.AuditEvent {
+	return models.AuditEvent{
+		ID:        id,
+		RequestID: requestID,
+		EventType: models.EventTypeUpstreamResponse,
+		Action:    "relay_call",
+		Resource:  "relay.call",
+		CreatedAt: createdAt,
+	}
+}
+
+func idempotencyRecord(id, key string, createdAt, expiresAt time.Time) models.IdempotencyRecord {
+	return models.IdempotencyRecord{
+		ID:             id,
+		IdempotencyKey: key,
+		RequestHash:    "hash_" + id,
+		ResponseStatus: 200,
+		CreatedAt:      createdAt,
+		ExpiresAt:      expiresAt,
+	}
+}
+
+func requireTime(t *testing.T, field string, got, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Fatalf("%s: expected %s, got %s", field, want.Format(time.RFC3339Nano), got.Format(time.RFC3339Nano))
+	}
+}
+
+func requireTimePtr(t *testing.T, field string, got, want *time.Time) {
+	t.Helper()
+	switch {
+	case got == nil && want == nil:
+	case got == nil || want == nil:
+		t.Fatalf("%s: expected %v, got %v", field, want, got)
+	default:
+		requireTime(t, field, *got, *want)
+	}
+}
+
+// requireJSON compares values by their JSON encoding.
+func requireJSON(t *testing.T, field string, got, want any) {
+	t.Helper()
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("%s: marshal got: %v", field, err)
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("%s: marshal want: %v", field, err)
+	}
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("%s: expected %s, got %s", field, wantJSON, gotJSON)
+	}
+}
+
+func apiKeyIDs(keys []models.APIKey) string {
+	ids := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func auditEventIDs(events []models.AuditEvent) string {
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return strings.Join(ids, ",")
+}
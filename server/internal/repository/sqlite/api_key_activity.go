@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const dayLayout = "2006-01-02"
+
+var _ repository.APIKeyActivityRepository = (*APIKeyRepo)(nil)
+
+func (r *APIKeyRepo) RecordActivity(ctx context.Context, batches []models.APIKeyActivity) error {
+	if len(batches) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, b := range batches {
+		lastUsedAt := formatTime(b.LastUsedAt)
+		// Every SET expression reads the row as it was before the update, so
+		// last_used_ip is compared against the old last_used_at.
+		res, err := tx.ExecContext(ctx,
+			`UPDATE api_keys SET
+				request_count = request_count + ?,
+				last_used_ip = CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ? ELSE last_used_ip END,
+				last_used_at = CASE WHEN last_used_at IS NULL OR last_used_at < ? THEN ? ELSE last_used_at END
+			 WHERE id = ?;`,
+			b.Requests,
+			lastUsedAt, b.LastUsedIP,
+			lastUsedAt, lastUsedAt,
+			b.APIKeyID,
+		)
+		if err != nil {
+			return fmt.Errorf("update api key %s activity: %w", b.APIKeyID, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("update api key %s activity: %w", b.APIKeyID, err)
+		} else if n == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO api_key_daily_requests (api_key_id, day, requests) VALUES (?, ?, ?)
+			 ON CONFLICT(api_key_id, day) DO UPDATE SET requests = requests + excluded.requests;`,
+			b.APIKeyID,
+			b.Day.UTC().Format(dayLayout),
+			b.Requests,
+		); err != nil {
+			return fmt.Errorf("add api key %s daily requests: %w", b.APIKeyID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *APIKeyRepo) RequestCountsSince(ctx context.Context, since time.Time) (map[string]int64, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT api_key_id, SUM(requests)
+		 FROM api_key_daily_requests
+		 WHERE day >= ?
+		 GROUP BY api_key_id;`,
+		since.UTC().Format(dayLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,641 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type BulkRepo struct{ db *sql.DB }
+
+var _ repository.BulkRepository = (*BulkRepo)(nil)
+
+func (r *BulkRepo) CountRows(ctx context.Context, table string) (int64, error) {
+	if !isBulkTable(table) {
+		return 0, fmt.Errorf("unknown table %q", table)
+	}
+	var n int64
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+`;`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count %s: %w", table, err)
+	}
+	return n, nil
+}
+
+func (r *BulkRepo) ExportAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
+		 FROM api_keys
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?;`,
+		afterID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.APIKey
+	for rows.Next() {
+		var k models.APIKey
+		var description sql.NullString
+		var createdAt string
+		var updatedAt string
+		var expiresAt sql.NullString
+		var revokedAt sql.NullString
+		var rotationOf sql.NullString
+		var status string
+		var lastUsedAt sql.NullString
+		var labels sql.NullString
+		if err := rows.Scan(
+			&k.ID,
+			&k.AccessKey,
+			&k.SecretKeyHash,
+			&k.SecretKeyCiphertext,
+			&description,
+			&createdAt,
+			&updatedAt,
+			&expiresAt,
+			&revokedAt,
+			&rotationOf,
+			&k.ClientCertSubject,
+			&status,
+			&lastUsedAt,
+			&k.LastUsedIP,
+			&k.RequestCount,
+			&k.Owner,
+			&labels,
+		); err != nil {
+			return nil, err
+		}
+		k.Description = description.String
+		if k.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		if k.UpdatedAt, err = parseTime(updatedAt); err != nil {
+			return nil, err
+		}
+		k.ExpiresAt = parseNullableTime(expiresAt)
+		k.RevokedAt = parseNullableTime(revokedAt)
+		k.RotationOf = parseNullableStringPtr(rotationOf)
+		k.Status = models.APIKeyStatus(status)
+		k.LastUsedAt = parseNullableTime(lastUsedAt)
+		if err := unmarshalJSONNullable(labels, &k.Labels); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportAPIKeys(ctx context.Context, keys []models.APIKey) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO api_keys (
+			id, access_key, secret_key_hash, secret_key_ciphertext, description,
+			created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status,
+			last_used_at, last_used_ip, request_count, owner, labels
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(keys),
+		func(i int) ([]any, error) {
+			key := keys[i]
+			labels, err := nullableLabels(key.Labels)
+			if err != nil {
+				return nil, err
+			}
+			return []any{
+				key.ID,
+				key.AccessKey,
+				key.SecretKeyHash,
+				key.SecretKeyCiphertext,
+				nullableString(key.Description),
+				formatTime(key.CreatedAt),
+				formatTime(key.UpdatedAt),
+				nullableTime(key.ExpiresAt),
+				nullableTime(key.RevokedAt),
+				nullableStringPtr(key.RotationOf),
+				key.ClientCertSubject,
+				string(key.Status),
+				nullableTime(key.LastUsedAt),
+				key.LastUsedIP,
+				key.RequestCount,
+				key.Owner,
+				labels,
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportDownstreamRequests(ctx context.Context, afterID string, limit int) ([]models.DownstreamRequest, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at
+		 FROM downstream_requests
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?;`,
+		afterID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.DownstreamRequest
+	for rows.Next() {
+		var req models.DownstreamRequest
+		var action string
+		var queryString sql.NullString
+		var headers sql.NullString
+		var body sql.NullString
+		var clientIP sql.NullString
+		var receivedAt string
+		if err := rows.Scan(
+			&req.ID,
+			&req.RequestID,
+			&req.APIKeyID,
+			&action,
+			&req.Method,
+			&req.Path,
+			&queryString,
+			&headers,
+			&body,
+			&clientIP,
+			&receivedAt,
+		); err != nil {
+			return nil, err
+		}
+		req.Action = models.DownstreamAction(action)
+		req.QueryString = queryString.String
+		req.ClientIP = clientIP.String
+		if req.ReceivedAt, err = parseTime(receivedAt); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(headers, &req.Headers); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(body, &req.Body); err != nil {
+			return nil, err
+		}
+		out = append(out, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportDownstreamRequests(ctx context.Context, requests []models.DownstreamRequest) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO downstream_requests (
+			id, request_id, api_key_id, action,
+			method, path, query_string, headers, body, client_ip, received_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(requests),
+		func(i int) ([]any, error) {
+			req := requests[i]
+			headersJSON, err := marshalJSONNullable(req.Headers)
+			if err != nil {
+				return nil, err
+			}
+			bodyJSON, err := marshalJSONNullable(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			return []any{
+				req.ID,
+				req.RequestID,
+				req.APIKeyID,
+				string(req.Action),
+				req.Method,
+				req.Path,
+				nullableString(req.QueryString),
+				headersJSON,
+				bodyJSON,
+				nullableString(req.ClientIP),
+				formatTime(req.ReceivedAt),
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportUpstreamAttempts(ctx context.Context, afterID string, limit int) ([]models.UpstreamAttempt, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, request_id, attempt_number, upstream_action, request_headers, request_body, response_status, response_headers, response_body, latency_ms, error, sent_at
+		 FROM upstream_attempts
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?;`,
+		afterID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.UpstreamAttempt
+	for rows.Next() {
+		var a models.UpstreamAttempt
+		var reqHeaders sql.NullString
+		var reqBody sql.NullString
+		var respHeaders sql.NullString
+		var respBody sql.NullString
+		var errStr sql.NullString
+		var sentAt string
+		if err := rows.Scan(
+			&a.ID,
+			&a.RequestID,
+			&a.AttemptNumber,
+			&a.UpstreamAction,
+			&reqHeaders,
+			&reqBody,
+			&a.ResponseStatus,
+			&respHeaders,
+			&respBody,
+			&a.LatencyMs,
+			&errStr,
+			&sentAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(reqHeaders, &a.RequestHeaders); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(reqBody, &a.RequestBody); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(respHeaders, &a.ResponseHeaders); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(respBody, &a.ResponseBody); err != nil {
+			return nil, err
+		}
+		if errStr.Valid {
+			v := errStr.String
+			a.Error = &v
+		}
+		if a.SentAt, err = parseTime(sentAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportUpstreamAttempts(ctx context.Context, attempts []models.UpstreamAttempt) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO upstream_attempts (
+			id, request_id, attempt_number, upstream_action,
+			request_headers, request_body,
+			response_status, response_headers, response_body,
+			latency_ms, error, sent_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(attempts),
+		func(i int) ([]any, error) {
+			a := attempts[i]
+			values := make([]any, 0, 4)
+			for _, v := range []any{a.RequestHeaders, a.RequestBody, a.ResponseHeaders, a.ResponseBody} {
+				j, err := marshalJSONNullable(v)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, j)
+			}
+			return []any{
+				a.ID,
+				a.RequestID,
+				a.AttemptNumber,
+				a.UpstreamAction,
+				values[0],
+				values[1],
+				a.ResponseStatus,
+				values[2],
+				values[3],
+				a.LatencyMs,
+				nullableStringPtr(a.Error),
+				formatTime(a.SentAt),
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	return (&AuditEventRepo{db: r.db}).list(ctx,
+		`SELECT `+auditEventColumns+`
+		 FROM audit_events
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?;`,
+		afterID,
+		limit,
+	)
+}
+
+func (r *BulkRepo) ImportAuditEvents(ctx context.Context, events []models.AuditEvent) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO audit_events (`+auditEventColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(events),
+		func(i int) ([]any, error) {
+			e := events[i]
+			metadataJSON, err := marshalJSONNullable(e.Metadata)
+			if err != nil {
+				return nil, err
+			}
+			return []any{
+				e.ID,
+				e.RequestID,
+				string(e.EventType),
+				nullableString(e.Actor),
+				e.Action,
+				e.Resource,
+				metadataJSON,
+				formatTime(e.CreatedAt),
+				e.Sequence,
+				e.PrevHash,
+				e.Hash,
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportAuditCheckpoints(ctx context.Context, afterID string, limit int) ([]models.AuditCheckpoint, error) {
+	return (&AuditEventRepo{db: r.db}).listCheckpoints(ctx,
+		`SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints WHERE id > ? ORDER BY id ASC LIMIT ?;`,
+		afterID,
+		limit,
+	)
+}
+
+func (r *BulkRepo) ImportAuditCheckpoints(ctx context.Context, checkpoints []models.AuditCheckpoint) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO audit_checkpoints (id, sequence, hash, mac, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(checkpoints),
+		func(i int) ([]any, error) {
+			c := checkpoints[i]
+			return []any{c.ID, c.Sequence, c.Hash, c.MAC, formatTime(c.CreatedAt)}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportIdempotencyRecords(ctx context.Context, afterID string, limit int) ([]models.IdempotencyRecord, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, idempotency_key, request_hash, response_status, response_body, created_at, expires_at
+		 FROM idempotency_records
+		 WHERE id > ?
+		 ORDER BY id ASC
+		 LIMIT ?;`,
+		afterID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.IdempotencyRecord
+	for rows.Next() {
+		var rec models.IdempotencyRecord
+		var respBody sql.NullString
+		var createdAt string
+		var expiresAt string
+		if err := rows.Scan(
+			&rec.ID,
+			&rec.IdempotencyKey,
+			&rec.RequestHash,
+			&rec.ResponseStatus,
+			&respBody,
+			&createdAt,
+			&expiresAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONNullable(respBody, &rec.ResponseBody); err != nil {
+			return nil, err
+		}
+		if rec.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		if rec.ExpiresAt, err = parseTime(expiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportIdempotencyRecords(ctx context.Context, records []models.IdempotencyRecord) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO idempotency_records (
+			id, idempotency_key, request_hash, response_status, response_body, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(records),
+		func(i int) ([]any, error) {
+			rec := records[i]
+			respBodyJSON, err := marshalJSONNullable(rec.ResponseBody)
+			if err != nil {
+				return nil, err
+			}
+			return []any{
+				rec.ID,
+				rec.IdempotencyKey,
+				rec.RequestHash,
+				rec.ResponseStatus,
+				respBodyJSON,
+				formatTime(rec.CreatedAt),
+				formatTime(rec.ExpiresAt),
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportAPIKeyDailyRequests(ctx context.Context, after repository.APIKeyDayKey, limit int) ([]models.APIKeyDailyRequests, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT api_key_id, day, requests
+		 FROM api_key_daily_requests
+		 WHERE (api_key_id, day) > (?, ?)
+		 ORDER BY api_key_id ASC, day ASC
+		 LIMIT ?;`,
+		after.APIKeyID,
+		after.Day.UTC().Format(dayLayout),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.APIKeyDailyRequests
+	for rows.Next() {
+		var c models.APIKeyDailyRequests
+		var day string
+		if err := rows.Scan(&c.APIKeyID, &day, &c.Requests); err != nil {
+			return nil, err
+		}
+		if c.Day, err = time.Parse(dayLayout, day); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportAPIKeyDailyRequests(ctx context.Context, counts []models.APIKeyDailyRequests) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO api_key_daily_requests (api_key_id, day, requests) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(counts),
+		func(i int) ([]any, error) {
+			c := counts[i]
+			return []any{c.APIKeyID, c.Day.UTC().Format(dayLayout), c.Requests}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportStatsRollups(ctx context.Context, after repository.StatsRollupKey, limit int) ([]models.StatsRollup, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		 FROM stats_rollups
+		 WHERE (hour, api_key_id, action, status_class) > (?, ?, ?, ?)
+		 ORDER BY hour ASC, api_key_id ASC, action ASC, status_class ASC
+		 LIMIT ?;`,
+		formatTime(after.Hour),
+		after.APIKeyID,
+		after.Action,
+		after.StatusClass,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.StatsRollup
+	for rows.Next() {
+		var s models.StatsRollup
+		var hour, action string
+		if err := rows.Scan(&hour, &s.APIKeyID, &action, &s.StatusClass, &s.Attempts, &s.Retries, &s.Errors,
+			&s.LatencySumMs, &s.LatencyP50Ms, &s.LatencyP95Ms, &s.LatencyMaxMs); err != nil {
+			return nil, err
+		}
+		s.Action = models.DownstreamAction(action)
+		if s.Hour, err = parseTime(hour); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *BulkRepo) ImportStatsRollups(ctx context.Context, rollups []models.StatsRollup) (int64, error) {
+	return r.importRows(ctx,
+		`INSERT INTO stats_rollups (
+			hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;`,
+		len(rollups),
+		func(i int) ([]any, error) {
+			s := rollups[i]
+			return []any{
+				formatTime(s.Hour), s.APIKeyID, string(s.Action), s.StatusClass, s.Attempts, s.Retries, s.Errors,
+				s.LatencySumMs, s.LatencyP50Ms, s.LatencyP95Ms, s.LatencyMaxMs,
+			}, nil
+		},
+	)
+}
+
+func (r *BulkRepo) ExportStatsRollupWatermark(ctx context.Context) (time.Time, error) {
+	return (&StatsRollupRepo{db: r.db}).Watermark(ctx)
+}
+
+func (r *BulkRepo) ImportStatsRollupWatermark(ctx context.Context, watermark time.Time) (int64, error) {
+	if watermark.IsZero() {
+		return 0, nil
+	}
+	return r.importRows(ctx,
+		`INSERT INTO stats_rollup_state (id, watermark) VALUES (1, ?)
+		ON CONFLICT DO NOTHING;`,
+		1,
+		func(int) ([]any, error) { return []any{formatTime(watermark)}, nil },
+	)
+}
+
+// importRows runs query once per row in a single transaction and counts the
+// rows that were actually inserted.
+func (r *BulkRepo) importRows(ctx context.Context, query string, n int, args func(i int) ([]any, error)) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var inserted int64
+	for i := 0; i < n; i++ {
+		values, err := args(i)
+		if err != nil {
+			return 0, err
+		}
+		res, err := stmt.ExecContext(ctx, values...)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		inserted += affected
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+func isBulkTable(table string) bool {
+	for _, t := range repository.BulkTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
@@ -4,111 +4,474 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/repository"
 )
 
-var migrationStatements = []string{
-	`CREATE TABLE IF NOT EXISTS api_keys (
-		id TEXT PRIMARY KEY,
-		access_key TEXT NOT NULL,
-		secret_key_hash TEXT NOT NULL,
-		secret_key_ciphertext TEXT NOT NULL,
-		description TEXT,
-		created_at TEXT NOT NULL,
-		updated_at TEXT NOT NULL,
-		expires_at TEXT,
-		revoked_at TEXT,
-		rotation_of TEXT,
-		status TEXT NOT NULL
-	);`,
-	`ALTER TABLE api_keys ADD COLUMN secret_key_ciphertext TEXT NOT NULL DEFAULT '';`,
-	`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_access_key ON api_keys(access_key);`,
-
-	`CREATE TABLE IF NOT EXISTS downstream_requests (
-		id TEXT PRIMARY KEY,
-		request_id TEXT NOT NULL,
-		api_key_id TEXT NOT NULL,
-		action TEXT NOT NULL,
-		method TEXT NOT NULL,
-		path TEXT NOT NULL,
-		query_string TEXT,
-		headers TEXT,
-		body TEXT,
-		client_ip TEXT,
-		received_at TEXT NOT NULL
-	);`,
-	`CREATE UNIQUE INDEX IF NOT EXISTS idx_downstream_requests_request_id ON downstream_requests(request_id);`,
-
-	`CREATE TABLE IF NOT EXISTS upstream_attempts (
-		id TEXT PRIMARY KEY,
-		request_id TEXT NOT NULL,
-		attempt_number INTEGER NOT NULL,
-		upstream_action TEXT NOT NULL,
-		request_headers TEXT,
-		request_body TEXT,
-		response_status INTEGER NOT NULL,
-		response_headers TEXT,
-		response_body TEXT,
-		latency_ms INTEGER NOT NULL,
-		error TEXT,
-		sent_at TEXT NOT NULL
-	);`,
-	`CREATE INDEX IF NOT EXISTS idx_upstream_attempts_request_id ON upstream_attempts(request_id);`,
-
-	`CREATE TABLE IF NOT EXISTS audit_events (
-		id TEXT PRIMARY KEY,
-		request_id TEXT NOT NULL,
-		event_type TEXT NOT NULL,
-		actor TEXT,
-		action TEXT NOT NULL,
-		resource TEXT NOT NULL,
-		metadata TEXT,
-		created_at TEXT NOT NULL
-	);`,
-	`CREATE INDEX IF NOT EXISTS idx_audit_events_request_id_created_at ON audit_events(request_id, created_at);`,
-
-	`CREATE TABLE IF NOT EXISTS idempotency_records (
-		id TEXT PRIMARY KEY,
-		idempotency_key TEXT NOT NULL,
-		request_hash TEXT NOT NULL,
-		response_status INTEGER NOT NULL,
-		response_body TEXT,
-		created_at TEXT NOT NULL,
-		expires_at TEXT NOT NULL
-	);`,
-	`CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_records_idempotency_key ON idempotency_records(idempotency_key);`,
+type migration struct {
+	version int
+	name    string
+	up      []string
+	down    []string
+	// baseline migrations predate schema_migrations and must also adopt a
+	// database created by any earlier release, so duplicate ADD COLUMN errors
+	// are ignored. Every later migration runs strictly.
+	baseline bool
 }
 
+// migrations are applied in version order. Never edit one that has shipped;
+// add a new version instead.
+var migrations = []migration{
+	{
+		version:  1,
+		name:     "baseline",
+		baseline: true,
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				access_key TEXT NOT NULL,
+				secret_key_hash TEXT NOT NULL,
+				secret_key_ciphertext TEXT NOT NULL,
+				description TEXT,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				expires_at TEXT,
+				revoked_at TEXT,
+				rotation_of TEXT,
+				client_cert_subject TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL
+			);`,
+			`ALTER TABLE api_keys ADD COLUMN secret_key_ciphertext TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE api_keys ADD COLUMN client_cert_subject TEXT NOT NULL DEFAULT '';`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_access_key ON api_keys(access_key);`,
+
+			`CREATE TABLE IF NOT EXISTS downstream_requests (
+				id TEXT PRIMARY KEY,
+				request_id TEXT NOT NULL,
+				api_key_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				method TEXT NOT NULL,
+				path TEXT NOT NULL,
+				query_string TEXT,
+				headers TEXT,
+				body TEXT,
+				client_ip TEXT,
+				received_at TEXT NOT NULL
+			);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_downstream_requests_request_id ON downstream_requests(request_id);`,
+			`CREATE INDEX IF NOT EXISTS idx_downstream_requests_received_at ON downstream_requests(received_at);`,
+
+			`CREATE TABLE IF NOT EXISTS upstream_attempts (
+				id TEXT PRIMARY KEY,
+				request_id TEXT NOT NULL,
+				attempt_number INTEGER NOT NULL,
+				upstream_action TEXT NOT NULL,
+				request_headers TEXT,
+				request_body TEXT,
+				response_status INTEGER NOT NULL,
+				response_headers TEXT,
+				response_body TEXT,
+				latency_ms INTEGER NOT NULL,
+				error TEXT,
+				sent_at TEXT NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_upstream_attempts_request_id ON upstream_attempts(request_id);`,
+
+			`CREATE TABLE IF NOT EXISTS audit_events (
+				id TEXT PRIMARY KEY,
+				request_id TEXT NOT NULL,
+				event_type TEXT NOT NULL,
+				actor TEXT,
+				action TEXT NOT NULL,
+				resource TEXT NOT NULL,
+				metadata TEXT,
+				created_at TEXT NOT NULL,
+				sequence INTEGER NOT NULL DEFAULT 0,
+				prev_hash TEXT NOT NULL DEFAULT '',
+				hash TEXT NOT NULL DEFAULT ''
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_request_id_created_at ON audit_events(request_id, created_at);`,
+			`ALTER TABLE audit_events ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE audit_events ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE audit_events ADD COLUMN hash TEXT NOT NULL DEFAULT '';`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_sequence ON audit_events(sequence) WHERE sequence > 0;`,
+
+			`CREATE TABLE IF NOT EXISTS audit_checkpoints (
+				id TEXT PRIMARY KEY,
+				sequence INTEGER NOT NULL,
+				hash TEXT NOT NULL,
+				mac TEXT NOT NULL,
+				created_at TEXT NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_sequence ON audit_checkpoints(sequence);`,
+
+			`CREATE TABLE IF NOT EXISTS idempotency_records (
+				id TEXT PRIMARY KEY,
+				idempotency_key TEXT NOT NULL,
+				request_hash TEXT NOT NULL,
+				response_status INTEGER NOT NULL,
+				response_body TEXT,
+				created_at TEXT NOT NULL,
+				expires_at TEXT NOT NULL
+			);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_records_idempotency_key ON idempotency_records(idempotency_key);`,
+
+			`CREATE TABLE IF NOT EXISTS seen_signatures (
+				signature TEXT PRIMARY KEY,
+				expires_at TEXT NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_seen_signatures_expires_at ON seen_signatures(expires_at);`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS seen_signatures;`,
+			`DROP TABLE IF EXISTS idempotency_records;`,
+			`DROP TABLE IF EXISTS audit_checkpoints;`,
+			`DROP TABLE IF EXISTS audit_events;`,
+			`DROP TABLE IF EXISTS upstream_attempts;`,
+			`DROP TABLE IF EXISTS downstream_requests;`,
+			`DROP TABLE IF EXISTS api_keys;`,
+		},
+	},
+	{
+		version: 2,
+		name:    "fixed_width_timestamps",
+		up: slices.Concat(
+			padTimestamps("api_keys", "created_at", "updated_at", "expires_at", "revoked_at"),
+			padTimestamps("downstream_requests", "received_at"),
+			padTimestamps("upstream_attempts", "sent_at"),
+			padTimestamps("audit_events", "created_at"),
+			padTimestamps("audit_checkpoints", "created_at"),
+			padTimestamps("idempotency_records", "created_at", "expires_at"),
+			padTimestamps("seen_signatures", "expires_at"),
+		),
+		// Padded values parse the same as trimmed ones, so older builds read
+		// them unchanged and there is nothing to undo.
+		down: nil,
+	},
+	{
+		version: 3,
+		name:    "query_indexes",
+		up: []string{
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at_id ON audit_events(created_at, id);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_action_created_at ON audit_events(action, created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_events_event_type_created_at ON audit_events(event_type, created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_downstream_requests_received_at_id ON downstream_requests(received_at, id);`,
+			`CREATE INDEX IF NOT EXISTS idx_downstream_requests_api_key_id_received_at ON downstream_requests(api_key_id, received_at, id);`,
+			`CREATE INDEX IF NOT EXISTS idx_upstream_attempts_request_id_attempt_number ON upstream_attempts(request_id, attempt_number);`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS idx_upstream_attempts_request_id_attempt_number;`,
+			`DROP INDEX IF EXISTS idx_downstream_requests_api_key_id_received_at;`,
+			`DROP INDEX IF EXISTS idx_downstream_requests_received_at_id;`,
+			`DROP INDEX IF EXISTS idx_audit_events_event_type_created_at;`,
+			`DROP INDEX IF EXISTS idx_audit_events_action_created_at;`,
+			`DROP INDEX IF EXISTS idx_audit_events_created_at_id;`,
+		},
+	},
+	{
+		version: 4,
+		name:    "stats_rollups",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS stats_rollups (
+				hour TEXT NOT NULL,
+				api_key_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				status_class TEXT NOT NULL,
+				attempts INTEGER NOT NULL,
+				retries INTEGER NOT NULL,
+				errors INTEGER NOT NULL,
+				latency_sum_ms INTEGER NOT NULL,
+				latency_p50_ms INTEGER NOT NULL,
+				latency_p95_ms INTEGER NOT NULL,
+				latency_max_ms INTEGER NOT NULL,
+				PRIMARY KEY (hour, api_key_id, action, status_class)
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_stats_rollups_api_key_id_hour ON stats_rollups(api_key_id, hour);`,
+			`CREATE TABLE IF NOT EXISTS stats_rollup_state (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				watermark TEXT NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_upstream_attempts_sent_at ON upstream_attempts(sent_at);`,
+		},
+		down: []string{
+			`DROP INDEX IF EXISTS idx_upstream_attempts_sent_at;`,
+			`DROP TABLE IF EXISTS stats_rollup_state;`,
+			`DROP TABLE IF EXISTS stats_rollups;`,
+		},
+	},
+	{
+		version: 5,
+		name:    "api_key_activity",
+		up: []string{
+			`ALTER TABLE api_keys ADD COLUMN last_used_at TEXT;`,
+			`ALTER TABLE api_keys ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE api_keys ADD COLUMN request_count INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE TABLE IF NOT EXISTS api_key_daily_requests (
+				api_key_id TEXT NOT NULL,
+				day TEXT NOT NULL,
+				requests INTEGER NOT NULL,
+				PRIMARY KEY (api_key_id, day)
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_api_key_daily_requests_day ON api_key_daily_requests(day);`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS api_key_daily_requests;`,
+			`ALTER TABLE api_keys DROP COLUMN request_count;`,
+			`ALTER TABLE api_keys DROP COLUMN last_used_ip;`,
+			`ALTER TABLE api_keys DROP COLUMN last_used_at;`,
+		},
+	},
+	{
+		version: 6,
+		name:    "api_key_labels",
+		up: []string{
+			`ALTER TABLE api_keys ADD COLUMN owner TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE api_keys ADD COLUMN labels TEXT;`,
+		},
+		down: []string{
+			`ALTER TABLE api_keys DROP COLUMN labels;`,
+			`ALTER TABLE api_keys DROP COLUMN owner;`,
+		},
+	},
+}
+
+// padTimestamps rewrites RFC3339Nano values written before timeLayout, which
+// trimmed trailing zeros, to nine fractional digits so they sort as text.
+// Values that are already padded or not UTC timestamps are left alone.
+func padTimestamps(table string, columns ...string) []string {
+	stmts := make([]string, 0, len(columns))
+	for _, c := range columns {
+		stmts = append(stmts, fmt.Sprintf(`UPDATE %[1]s SET %[2]s = CASE
+			WHEN length(%[2]s) = 20 THEN substr(%[2]s, 1, 19) || '.000000000Z'
+			ELSE substr(%[2]s, 1, 20) || substr(substr(%[2]s, 21, length(%[2]s) - 21) || '000000000', 1, 9) || 'Z'
+		END
+		WHERE %[2]s GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]*Z' AND length(%[2]s) < 30;`, table, c))
+	}
+	return stmts
+}
+
+// ApplyMigrations applies every pending migration.
 func ApplyMigrations(ctx context.Context, db *sql.DB) error {
 	if db == nil {
 		return fmt.Errorf("db is nil")
 	}
+	_, err := NewMigrator(db).MigrateUp(ctx, 0)
+	return err
+}
 
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+var _ repository.Migrator = (*Migrator)(nil)
+
+type Migrator struct{ db *sql.DB }
+
+func NewMigrator(db *sql.DB) *Migrator {
+	return &Migrator{db: db}
+}
+
+type appliedMigration struct {
+	name      string
+	appliedAt string
+}
+
+func (m *Migrator) MigrateUp(ctx context.Context, version int) ([]int, error) {
+	if version != 0 && findMigration(version) == nil {
+		return nil, fmt.Errorf("unknown migration version %d", version)
 	}
-	defer func() {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			return
+	var done []int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := listApplied(ctx, conn)
+		if err != nil {
+			return err
 		}
-	}()
-
-	for i, stmt := range migrationStatements {
-		if _, err := tx.ExecContext(ctx, stmt); err != nil {
-			if isSQLiteDuplicateColumn(err) {
+		for _, mig := range migrations {
+			if version != 0 && mig.version > version {
+				break
+			}
+			if _, ok := applied[mig.version]; ok {
 				continue
 			}
-			return fmt.Errorf("migration %d: %w", i+1, err)
+			for i, stmt := range mig.up {
+				if _, err := conn.ExecContext(ctx, stmt); err != nil {
+					if mig.baseline && isSQLiteDuplicateColumn(err) {
+						continue
+					}
+					return fmt.Errorf("migration %d (%s) statement %d: %w", mig.version, mig.name, i+1, err)
+				}
+			}
+			if _, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);`, mig.version, mig.name, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+				return fmt.Errorf("record migration %d (%s): %w", mig.version, mig.name, err)
+			}
+			done = append(done, mig.version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+func (m *Migrator) MigrateDown(ctx context.Context, steps int) ([]int, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1")
+	}
+	var done []int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := listApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if steps > len(versions) {
+			return fmt.Errorf("cannot revert %d migrations: only %d applied", steps, len(versions))
+		}
+		for _, v := range versions[:steps] {
+			mig := findMigration(v)
+			if mig == nil {
+				return fmt.Errorf("migration %d is not known to this build; revert it with the build that applied it", v)
+			}
+			for i, stmt := range mig.down {
+				if _, err := conn.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("revert migration %d (%s) statement %d: %w", mig.version, mig.name, i+1, err)
+				}
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?;`, v); err != nil {
+				return fmt.Errorf("unrecord migration %d (%s): %w", mig.version, mig.name, err)
+			}
+			done = append(done, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+func (m *Migrator) MigrationStatus(ctx context.Context) ([]repository.MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+	if err := createSchemaMigrations(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := listApplied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]repository.MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		st := repository.MigrationStatus{Version: mig.version, Name: mig.name}
+		if a, ok := applied[mig.version]; ok {
+			st.Applied = true
+			st.AppliedAt = parseAppliedAt(a.appliedAt)
+			delete(applied, mig.version)
 		}
+		out = append(out, st)
 	}
+	unknown := make([]int, 0, len(applied))
+	for v := range applied {
+		unknown = append(unknown, v)
+	}
+	sort.Ints(unknown)
+	for _, v := range unknown {
+		out = append(out, repository.MigrationStatus{Version: v, Name: applied[v].name, Applied: true, AppliedAt: parseAppliedAt(applied[v].appliedAt)})
+	}
+	return out, nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
+// withLock runs fn inside BEGIN IMMEDIATE, which takes the database write
+// lock up front so a second process migrating the same file waits (up to
+// busy_timeout) instead of interleaving.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	if m == nil || m.db == nil {
+		return fmt.Errorf("db is nil")
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE;`); err != nil {
+		return fmt.Errorf("lock database for migration: %w", err)
+	}
+	err = createSchemaMigrations(ctx, conn)
+	if err == nil {
+		err = fn(conn)
+	}
+	if err == nil {
+		if _, err = conn.ExecContext(ctx, `COMMIT;`); err != nil {
+			err = fmt.Errorf("commit migrations: %w", err)
+		}
+	}
+	if err != nil {
+		// A cancelled ctx must not keep the lock held on this connection.
+		_, _ = conn.ExecContext(context.Background(), `ROLLBACK;`)
+		return err
 	}
 	return nil
 }
 
+func createSchemaMigrations(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func listApplied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var v int
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.name, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("scan applied migration: %w", err)
+		}
+		applied[v] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func findMigration(version int) *migration {
+	for i := range migrations {
+		if migrations[i].version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+func parseAppliedAt(v string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
 func isSQLiteDuplicateColumn(err error) bool {
 	if err == nil {
 		return false
@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -53,10 +56,182 @@ func TestApplyMigrations_Idempotent(t *testing.T) {
 	requireSQLiteObjectExists(t, db, "table", "upstream_attempts")
 	requireSQLiteObjectExists(t, db, "table", "audit_events")
 	requireSQLiteObjectExists(t, db, "table", "idempotency_records")
+	requireSQLiteObjectExists(t, db, "table", "seen_signatures")
 
 	requireSQLiteObjectExists(t, db, "index", "idx_api_keys_access_key")
 	requireSQLiteObjectExists(t, db, "index", "idx_downstream_requests_request_id")
 	requireSQLiteObjectExists(t, db, "index", "idx_upstream_attempts_request_id")
 	requireSQLiteObjectExists(t, db, "index", "idx_audit_events_request_id_created_at")
 	requireSQLiteObjectExists(t, db, "index", "idx_idempotency_records_idempotency_key")
+	requireSQLiteObjectExists(t, db, "index", "idx_seen_signatures_expires_at")
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := NewMigrator(db)
+
+	statuses, err := m.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if len(statuses) != len(migrations) || statuses[0].Applied {
+		t.Fatalf("expected every migration pending on a new database, got %+v", statuses)
+	}
+
+	applied, err := m.MigrateUp(ctx, 0)
+	if err != nil || len(applied) != len(migrations) || applied[0] != 1 {
+		t.Fatalf("MigrateUp = %v, %v", applied, err)
+	}
+	if again, err := m.MigrateUp(ctx, 0); err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing left to apply, got %v, %v", again, err)
+	}
+	statuses, err = m.MigrationStatus(ctx)
+	if err != nil || !statuses[0].Applied || statuses[0].AppliedAt == nil || statuses[0].Name != "baseline" {
+		t.Fatalf("unexpected status after up: %+v, %v", statuses, err)
+	}
+
+	if _, err := m.MigrateDown(ctx, len(migrations)+1); err == nil {
+		t.Fatalf("expected reverting more migrations than applied to fail")
+	}
+	reverted, err := m.MigrateDown(ctx, len(migrations))
+	if err != nil || len(reverted) != len(migrations) {
+		t.Fatalf("MigrateDown = %v, %v", reverted, err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'api_keys'`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("expected api_keys to be dropped, got %d, %v", n, err)
+	}
+	if _, err := m.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp after down: %v", err)
+	}
+	if _, err := m.MigrateUp(ctx, 999); err == nil {
+		t.Fatalf("expected an unknown target version to be rejected")
+	}
+
+	// A version applied by a newer build is reported but cannot be reverted here.
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'from_the_future', '2026-03-01T00:00:00Z')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	statuses, err = m.MigrationStatus(ctx)
+	if err != nil || statuses[len(statuses)-1].Version != 999 || !statuses[len(statuses)-1].Applied {
+		t.Fatalf("expected the unknown version to be listed, got %+v, %v", statuses, err)
+	}
+	if _, err := m.MigrateDown(ctx, 1); err == nil || !strings.Contains(err.Error(), "999") {
+		t.Fatalf("expected reverting an unknown version to fail, got %v", err)
+	}
+}
+
+func TestMigrator_AdoptsPreVersioningDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// The api_keys table as the first releases created it.
+	if _, err := db.Exec(`CREATE TABLE api_keys (
+		id TEXT PRIMARY KEY,
+		access_key TEXT NOT NULL,
+		secret_key_hash TEXT NOT NULL,
+		description TEXT,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		expires_at TEXT,
+		revoked_at TEXT,
+		rotation_of TEXT,
+		status TEXT NOT NULL
+	)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO api_keys (id, access_key, secret_key_hash, created_at, updated_at, status) VALUES ('k1', 'ak', 'h', 'x', 'x', 'active')`); err != nil {
+		t.Fatalf("insert legacy row: %v", err)
+	}
+
+	if err := ApplyMigrations(ctx, db); err != nil {
+		t.Fatalf("ApplyMigrations: %v", err)
+	}
+	var ciphertext, subject string
+	if err := db.QueryRow(`SELECT secret_key_ciphertext, client_cert_subject FROM api_keys WHERE id = 'k1'`).Scan(&ciphertext, &subject); err != nil {
+		t.Fatalf("expected the missing columns to be added: %v", err)
+	}
+	statuses, err := NewMigrator(db).MigrationStatus(ctx)
+	if err != nil || !statuses[0].Applied {
+		t.Fatalf("expected the baseline to be recorded, got %+v, %v", statuses, err)
+	}
+}
+
+func TestMigrator_ConcurrentUpAppliesOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "relay.db")
+
+	var wg sync.WaitGroup
+	results := make([][]int, 4)
+	errs := make([]error, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			if _, err := db.Exec("PRAGMA busy_timeout = 5000;"); err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], errs[i] = NewMigrator(db).MigrateUp(ctx, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("MigrateUp %d: %v", i, errs[i])
+		}
+		total += len(results[i])
+	}
+	if total != len(migrations) {
+		t.Fatalf("expected each migration to be applied exactly once, got %v", results)
+	}
+}
+
+func TestMigrator_PadsTimestamps(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := NewMigrator(db)
+
+	if _, err := m.MigrateUp(ctx, 1); err != nil {
+		t.Fatalf("MigrateUp to 1: %v", err)
+	}
+	// Rows as earlier builds wrote them, with trailing zeros trimmed.
+	for _, stmt := range []string{
+		`INSERT INTO idempotency_records (id, idempotency_key, request_hash, response_status, created_at, expires_at) VALUES ('i1', 'k1', 'h', 200, '2026-03-01T11:00:00.5Z', '2026-03-01T12:00:00Z')`,
+		`INSERT INTO idempotency_records (id, idempotency_key, request_hash, response_status, created_at, expires_at) VALUES ('i2', 'k2', 'h', 200, 'not a time', '2026-03-01T12:00:00.123456789Z')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if _, err := m.MigrateUp(ctx, 0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	want := map[string][2]string{
+		"i1": {"2026-03-01T11:00:00.500000000Z", "2026-03-01T12:00:00.000000000Z"},
+		"i2": {"not a time", "2026-03-01T12:00:00.123456789Z"},
+	}
+	for id, w := range want {
+		var createdAt, expiresAt string
+		if err := db.QueryRow(`SELECT created_at, expires_at FROM idempotency_records WHERE id = ?`, id).Scan(&createdAt, &expiresAt); err != nil {
+			t.Fatalf("select %s: %v", id, err)
+		}
+		if createdAt != w[0] || expiresAt != w[1] {
+			t.Fatalf("%s: expected %v, got [%s %s]", id, w, createdAt, expiresAt)
+		}
+	}
+	if n, err := New(db).IdempotencyRecords.DeleteExpired(ctx, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)); err != nil || n != 1 {
+		t.Fatalf("expected only i1 to expire once padded, deleted %d, %v", n, err)
+	}
 }
@@ -0,0 +1,238 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// latestAttempt selects column from the request's highest-numbered upstream
+// attempt, or NULL when it has none.
+func latestAttempt(column string) string {
+	return `(SELECT ua.` + column + ` FROM upstream_attempts ua WHERE ua.request_id = dr.request_id ORDER BY ua.attempt_number DESC LIMIT 1)`
+}
+
+const auditStatus = `CASE WHEN json_type(metadata, '$.response_status') IN ('integer', 'real') THEN json_extract(metadata, '$.response_status') END`
+
+func (r *AuditEventRepo) Query(ctx context.Context, query repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	w := auditEventFilter(query)
+	w.cursor("created_at", "id", query.Order, query.After)
+	return r.list(ctx,
+		`SELECT `+auditEventColumns+`
+		 FROM audit_events`+w.String()+`
+		 ORDER BY `+orderBy("created_at", "id", query.Order)+`
+		 LIMIT ?;`,
+		append(w.args, query.Limit)...,
+	)
+}
+
+var _ repository.AuditEventCounter = (*AuditEventRepo)(nil)
+
+func (r *AuditEventRepo) CountByAPIKey(ctx context.Context, query repository.AuditEventQuery) (map[string]int64, error) {
+	w := auditEventFilter(query)
+	return countByKey(ctx, r.db,
+		`SELECT COALESCE((SELECT dr.api_key_id FROM downstream_requests dr WHERE dr.request_id = audit_events.request_id), ''), COUNT(*)
+		 FROM audit_events`+w.String()+`
+		 GROUP BY 1;`,
+		w.args...,
+	)
+}
+
+// auditEventFilter turns the filters of query, except the cursor, into a
+// WHERE clause over audit_events.
+func auditEventFilter(query repository.AuditEventQuery) whereClause {
+	var w whereClause
+	w.window("created_at", query.From, query.To)
+	if query.APIKeyID != "" {
+		w.add(`request_id IN (SELECT request_id FROM downstream_requests WHERE api_key_id = ?)`, query.APIKeyID)
+	}
+	if len(query.APIKeyIDs) > 0 {
+		w.add(`request_id IN (SELECT request_id FROM downstream_requests WHERE api_key_id IN (`+placeholders(len(query.APIKeyIDs))+`))`, stringArgs(query.APIKeyIDs)...)
+	}
+	if query.Action != "" {
+		w.add(`action = ?`, query.Action)
+	}
+	if query.EventType != "" {
+		w.add(`event_type = ?`, string(query.EventType))
+	}
+	w.statusRange(auditStatus, query.MinStatus, query.MaxStatus)
+	if query.HasError != nil {
+		hasError := `(event_type = '` + string(models.EventTypeError) + `' OR json_extract(metadata, '$.error') IS NOT NULL)`
+		if !*query.HasError {
+			hasError = `NOT ` + hasError
+		}
+		w.add(hasError)
+	}
+	return w
+}
+
+func (r *DownstreamRequestRepo) List(ctx context.Context, query repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	w := downstreamRequestFilter(query)
+	w.cursor("dr.received_at", "dr.id", query.Order, query.After)
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+downstreamRequestColumns+`
+		 FROM downstream_requests dr`+w.String()+`
+		 ORDER BY `+orderBy("dr.received_at", "dr.id", query.Order)+`
+		 LIMIT ?;`,
+		append(w.args, query.Limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.DownstreamRequest
+	for rows.Next() {
+		request, err := scanDownstreamRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+var _ repository.DownstreamRequestCounter = (*DownstreamRequestRepo)(nil)
+
+func (r *DownstreamRequestRepo) CountByAPIKey(ctx context.Context, query repository.DownstreamRequestQuery) (map[string]int64, error) {
+	w := downstreamRequestFilter(query)
+	return countByKey(ctx, r.db,
+		`SELECT dr.api_key_id, COUNT(*)
+		 FROM downstream_requests dr`+w.String()+`
+		 GROUP BY dr.api_key_id;`,
+		w.args...,
+	)
+}
+
+// downstreamRequestFilter turns the filters of query, except the cursor,
+// into a WHERE clause over downstream_requests aliased dr.
+func downstreamRequestFilter(query repository.DownstreamRequestQuery) whereClause {
+	var w whereClause
+	w.window("dr.received_at", query.From, query.To)
+	if query.APIKeyID != "" {
+		w.add(`dr.api_key_id = ?`, query.APIKeyID)
+	}
+	if len(query.APIKeyIDs) > 0 {
+		w.add(`dr.api_key_id IN (`+placeholders(len(query.APIKeyIDs))+`)`, stringArgs(query.APIKeyIDs)...)
+	}
+	if query.Action != "" {
+		w.add(`dr.action = ?`, string(query.Action))
+	}
+	w.statusRange(latestAttempt("response_status"), query.MinStatus, query.MaxStatus)
+	if query.HasError != nil {
+		if *query.HasError {
+			w.add(latestAttempt("error") + ` IS NOT NULL`)
+		} else {
+			w.add(latestAttempt("error") + ` IS NULL`)
+		}
+	}
+	return w
+}
+
+// countByKey runs a query selecting (api_key_id, count) rows into a map.
+func countByKey(ctx context.Context, db *sql.DB, query string, args ...any) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// whereClause collects AND-ed conditions and their ? arguments.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+func (w *whereClause) add(cond string, args ...any) {
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *whereClause) window(column string, from, to time.Time) {
+	if !from.IsZero() {
+		w.add(column+` >= ?`, formatTime(from))
+	}
+	if !to.IsZero() {
+		w.add(column+` < ?`, formatTime(to))
+	}
+}
+
+func (w *whereClause) statusRange(expr string, min, max int) {
+	if min != 0 {
+		w.add(expr+` >= ?`, min)
+	}
+	if max != 0 {
+		w.add(expr+` <= ?`, max)
+	}
+}
+
+// cursor keeps rows strictly after the cursor in order; the id breaks ties
+// between equal timestamps.
+func (w *whereClause) cursor(timeColumn, idColumn string, order repository.SortOrder, after *repository.Cursor) {
+	if after == nil {
+		return
+	}
+	op := `>`
+	if order == repository.SortDesc {
+		op = `<`
+	}
+	w.add(`(`+timeColumn+`, `+idColumn+`) `+op+` (?, ?)`, formatTime(after.Time), after.ID)
+}
+
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return "\n\t\t WHERE " + strings.Join(w.conds, "\n\t\t   AND ")
+}
+
+// placeholders returns n comma-separated ? markers.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func orderBy(timeColumn, idColumn string, order repository.SortOrder) string {
+	dir := `ASC`
+	if order == repository.SortDesc {
+		dir = `DESC`
+	}
+	return timeColumn + ` ` + dir + `, ` + idColumn + ` ` + dir
+}
@@ -23,9 +23,26 @@ type Repositories struct {
 	UpstreamAttempts   *UpstreamAttemptRepo
 	AuditEvents        *AuditEventRepo
 	IdempotencyRecords *IdempotencyRecordRepo
+	SeenSignatures     *SeenSignatureRepo
+	Usage              *UsageRepo
+	StatsRollups       *StatsRollupRepo
+	Migrations         *Migrator
+	Bulk               *BulkRepo
 }
 
+// OpenOptions tune OpenWithOptions.
+type OpenOptions struct {
+	// SkipMigrations opens the database as it is, for callers that apply
+	// migrations themselves through Repositories.Migrations.
+	SkipMigrations bool
+}
+
+// Open opens dsn and applies any pending migrations.
 func Open(ctx context.Context, dsn string) (*Repositories, error) {
+	return OpenWithOptions(ctx, dsn, OpenOptions{})
+}
+
+func OpenWithOptions(ctx context.Context, dsn string, opts OpenOptions) (*Repositories, error) {
 	if strings.TrimSpace(dsn) == "" {
 		return nil, fmt.Errorf("dsn is required")
 	}
@@ -48,9 +65,11 @@ func Open(ctx context.Context, dsn string) (*Repositories, error) {
 		return nil, fmt.Errorf("set busy_timeout: %w", err)
 	}
 
-	if err := ApplyMigrations(ctx, db); err != nil {
-		_ = db.Close()
-		return nil, err
+	if !opts.SkipMigrations {
+		if err := ApplyMigrations(ctx, db); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
 	}
 
 	return New(db), nil
@@ -63,6 +82,11 @@ func New(db *sql.DB) *Repositories {
 	r.UpstreamAttempts = &UpstreamAttemptRepo{db: db}
 	r.AuditEvents = &AuditEventRepo{db: db}
 	r.IdempotencyRecords = &IdempotencyRecordRepo{db: db}
+	r.SeenSignatures = &SeenSignatureRepo{db: db}
+	r.Usage = &UsageRepo{db: db}
+	r.StatsRollups = &StatsRollupRepo{db: db}
+	r.Migrations = NewMigrator(db)
+	r.Bulk = &BulkRepo{db: db}
 	return r
 }
 
@@ -73,6 +97,19 @@ func (r *Repositories) Close() error {
 	return r.DB.Close()
 }
 
+// Ping runs a trivial query. The pool holds a single connection, so this also
+// fails when that connection is stuck behind a long-running statement.
+func (r *Repositories) Ping(ctx context.Context) error {
+	if r == nil || r.DB == nil {
+		return fmt.Errorf("sqlite db is not initialized")
+	}
+	var one int
+	if err := r.DB.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("ping sqlite: %w", err)
+	}
+	return nil
+}
+
 type APIKeyRepo struct{ db *sql.DB }
 
 var _ repository.APIKeyRepository = (*APIKeyRepo)(nil)
@@ -81,12 +118,17 @@ func (r *APIKeyRepo) Create(ctx context.Context, key models.APIKey) error {
 	if err := key.Validate(); err != nil {
 		return err
 	}
+	labels, err := nullableLabels(key.Labels)
+	if err != nil {
+		return err
+	}
 
-	_, err := r.db.ExecContext(ctx,
+	_, err = r.db.ExecContext(ctx,
 		`INSERT INTO api_keys (
 			id, access_key, secret_key_hash, secret_key_ciphertext, description,
-			created_at, updated_at, expires_at, revoked_at, rotation_of, status
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status,
+			owner, labels
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
 		key.ID,
 		key.AccessKey,
 		key.SecretKeyHash,
@@ -97,7 +139,10 @@ func (r *APIKeyRepo) Create(ctx context.Context, key models.APIKey) error {
 		nullableTime(key.ExpiresAt),
 		nullableTime(key.RevokedAt),
 		nullableStringPtr(key.RotationOf),
+		key.ClientCertSubject,
 		string(key.Status),
+		key.Owner,
+		labels,
 	)
 	if err != nil {
 		return err
@@ -107,7 +152,7 @@ func (r *APIKeyRepo) Create(ctx context.Context, key models.APIKey) error {
 
 func (r *APIKeyRepo) GetByAccessKey(ctx context.Context, accessKey string) (models.APIKey, error) {
 	return r.getOne(ctx,
-		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		 FROM api_keys
 		 WHERE access_key = ?
 		 LIMIT 1;`,
@@ -117,7 +162,7 @@ func (r *APIKeyRepo) GetByAccessKey(ctx context.Context, accessKey string) (mode
 
 func (r *APIKeyRepo) GetByID(ctx context.Context, id string) (models.APIKey, error) {
 	return r.getOne(ctx,
-		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		 FROM api_keys
 		 WHERE id = ?
 		 LIMIT 1;`,
@@ -135,6 +180,8 @@ func (r *APIKeyRepo) getOne(ctx context.Context, query string, arg any) (models.
 	var revokedAt sql.NullString
 	var rotationOf sql.NullString
 	var status string
+	var lastUsedAt sql.NullString
+	var labels sql.NullString
 
 	err := r.db.QueryRowContext(ctx, query, arg).Scan(
 		&out.ID,
@@ -147,7 +194,13 @@ func (r *APIKeyRepo) getOne(ctx context.Context, query string, arg any) (models.
 		&expiresAt,
 		&revokedAt,
 		&rotationOf,
+		&out.ClientCertSubject,
 		&status,
+		&lastUsedAt,
+		&out.LastUsedIP,
+		&out.RequestCount,
+		&out.Owner,
+		&labels,
 	)
 	if err != nil {
 		return models.APIKey{}, mapNotFound(err)
@@ -168,13 +221,17 @@ func (r *APIKeyRepo) getOne(ctx context.Context, query string, arg any) (models.
 	out.RevokedAt = parseNullableTime(revokedAt)
 	out.RotationOf = parseNullableStringPtr(rotationOf)
 	out.Status = models.APIKeyStatus(status)
+	out.LastUsedAt = parseNullableTime(lastUsedAt)
+	if err := unmarshalJSONNullable(labels, &out.Labels); err != nil {
+		return models.APIKey{}, err
+	}
 
 	return out, nil
 }
 
 func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
 	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, status
+		`SELECT id, access_key, secret_key_hash, secret_key_ciphertext, description, created_at, updated_at, expires_at, revoked_at, rotation_of, client_cert_subject, status, last_used_at, last_used_ip, request_count, owner, labels
 		 FROM api_keys
 		 ORDER BY created_at DESC;`,
 	)
@@ -193,6 +250,8 @@ func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
 		var revokedAt sql.NullString
 		var rotationOf sql.NullString
 		var status string
+		var lastUsedAt sql.NullString
+		var labels sql.NullString
 
 		if err := rows.Scan(
 			&k.ID,
@@ -205,7 +264,13 @@ func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
 			&expiresAt,
 			&revokedAt,
 			&rotationOf,
+			&k.ClientCertSubject,
 			&status,
+			&lastUsedAt,
+			&k.LastUsedIP,
+			&k.RequestCount,
+			&k.Owner,
+			&labels,
 		); err != nil {
 			return nil, err
 		}
@@ -225,6 +290,10 @@ func (r *APIKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
 		k.RevokedAt = parseNullableTime(revokedAt)
 		k.RotationOf = parseNullableStringPtr(rotationOf)
 		k.Status = models.APIKeyStatus(status)
+		k.LastUsedAt = parseNullableTime(lastUsedAt)
+		if err := unmarshalJSONNullable(labels, &k.Labels); err != nil {
+			return nil, err
+		}
 		out = append(out, k)
 	}
 	if err := rows.Err(); err != nil {
@@ -324,6 +393,93 @@ func (r *APIKeyRepo) SetExpiresAt(ctx context.Context, id string, expiresAt time
 	return nil
 }
 
+var _ repository.APIKeyMetadataRepository = (*APIKeyRepo)(nil)
+
+func (r *APIKeyRepo) UpdateMetadata(ctx context.Context, id string, metadata models.APIKeyMetadata, updatedAt time.Time) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("id is required")
+	}
+	labels, err := nullableLabels(metadata.Labels)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys
+		 SET description = ?,
+		     owner = ?,
+		     labels = ?,
+		     updated_at = ?
+		 WHERE id = ?;`,
+		nullableString(metadata.Description),
+		metadata.Owner,
+		labels,
+		formatTime(updatedAt),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+var _ repository.APIKeySecretRepository = (*APIKeyRepo)(nil)
+
+func (r *APIKeyRepo) RewriteSecretCiphertexts(ctx context.Context, rewrite func(ciphertext string) (string, bool, error)) (int64, int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type secretRow struct{ id, ciphertext string }
+	// Read everything first: the pool has a single connection, so rows must be
+	// closed before the updates run on the same transaction.
+	rows, err := tx.QueryContext(ctx, `SELECT id, secret_key_ciphertext FROM api_keys WHERE secret_key_ciphertext <> '' ORDER BY id;`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var all []secretRow
+	for rows.Next() {
+		var row secretRow
+		if err := rows.Scan(&row.id, &row.ciphertext); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		all = append(all, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	var rewritten int64
+	for _, row := range all {
+		out, changed, err := rewrite(row.ciphertext)
+		if err != nil {
+			return 0, 0, fmt.Errorf("api key %s: %w", row.id, err)
+		}
+		if !changed {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE api_keys SET secret_key_ciphertext = ? WHERE id = ?;`, out, row.id); err != nil {
+			return 0, 0, fmt.Errorf("api key %s: %w", row.id, err)
+		}
+		rewritten++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return int64(len(all)), rewritten, nil
+}
+
 type DownstreamRequestRepo struct{ db *sql.DB }
 
 var _ repository.DownstreamRequestRepository = (*DownstreamRequestRepo)(nil)
@@ -365,15 +521,25 @@ func (r *DownstreamRequestRepo) Create(ctx context.Context, request models.Downs
 	return nil
 }
 
+const downstreamRequestColumns = `id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at`
+
 func (r *DownstreamRequestRepo) GetByID(ctx context.Context, id string) (models.DownstreamRequest, error) {
-	return r.getOne(ctx, `SELECT id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at FROM downstream_requests WHERE id = ? LIMIT 1;`, id)
+	return r.getOne(ctx, `SELECT `+downstreamRequestColumns+` FROM downstream_requests WHERE id = ? LIMIT 1;`, id)
 }
 
 func (r *DownstreamRequestRepo) GetByRequestID(ctx context.Context, requestID string) (models.DownstreamRequest, error) {
-	return r.getOne(ctx, `SELECT id, request_id, api_key_id, action, method, path, query_string, headers, body, client_ip, received_at FROM downstream_requests WHERE request_id = ? LIMIT 1;`, requestID)
+	return r.getOne(ctx, `SELECT `+downstreamRequestColumns+` FROM downstream_requests WHERE request_id = ? LIMIT 1;`, requestID)
 }
 
 func (r *DownstreamRequestRepo) getOne(ctx context.Context, query string, arg any) (models.DownstreamRequest, error) {
+	out, err := scanDownstreamRequest(r.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		return models.DownstreamRequest{}, mapNotFound(err)
+	}
+	return out, nil
+}
+
+func scanDownstreamRequest(row interface{ Scan(...any) error }) (models.DownstreamRequest, error) {
 	var out models.DownstreamRequest
 	var action string
 	var queryString sql.NullString
@@ -382,7 +548,7 @@ func (r *DownstreamRequestRepo) getOne(ctx context.Context, query string, arg an
 	var clientIP sql.NullString
 	var receivedAt string
 
-	if err := r.db.QueryRowContext(ctx, query, arg).Scan(
+	if err := row.Scan(
 		&out.ID,
 		&out.RequestID,
 		&out.APIKeyID,
@@ -395,7 +561,7 @@ func (r *DownstreamRequestRepo) getOne(ctx context.Context, query string, arg an
 		&clientIP,
 		&receivedAt,
 	); err != nil {
-		return models.DownstreamRequest{}, mapNotFound(err)
+		return models.DownstreamRequest{}, err
 	}
 
 	out.Action = models.DownstreamAction(action)
@@ -540,22 +706,47 @@ func (r *UpstreamAttemptRepo) ListByRequestID(ctx context.Context, requestID str
 
 type AuditEventRepo struct{ db *sql.DB }
 
-var _ repository.AuditEventRepository = (*AuditEventRepo)(nil)
+var (
+	_ repository.AuditEventRepository = (*AuditEventRepo)(nil)
+	_ repository.AuditChainRepository = (*AuditEventRepo)(nil)
+)
+
+const auditEventColumns = `id, request_id, event_type, actor, action, resource, metadata, created_at, sequence, prev_hash, hash`
 
-func (r *AuditEventRepo) Create(ctx context.Context, event models.AuditEvent) error {
+// Create appends event to the hash chain. The pool holds a single connection,
+// so the transaction also serialises concurrent appends within the process.
+func (r *AuditEventRepo) Create(ctx context.Context, event *models.AuditEvent) error {
 	if err := event.Validate(); err != nil {
 		return err
 	}
 
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return
+		}
+	}()
+
+	var prevSequence int64
+	var prevHash string
+	err = tx.QueryRowContext(ctx, `SELECT sequence, hash FROM audit_events WHERE sequence > 0 ORDER BY sequence DESC LIMIT 1;`).Scan(&prevSequence, &prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read audit chain head: %w", err)
+	}
+	if err := event.Chain(prevSequence, prevHash); err != nil {
+		return err
+	}
+
 	metadataJSON, err := marshalJSONNullable(event.Metadata)
 	if err != nil {
 		return err
 	}
 
-	_, err = r.db.ExecContext(ctx,
-		`INSERT INTO audit_events (
-			id, request_id, event_type, actor, action, resource, metadata, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO audit_events (`+auditEventColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
 		event.ID,
 		event.RequestID,
 		string(event.EventType),
@@ -564,16 +755,19 @@ func (r *AuditEventRepo) Create(ctx context.Context, event models.AuditEvent) er
 		event.Resource,
 		metadataJSON,
 		formatTime(event.CreatedAt),
+		event.Sequence,
+		event.PrevHash,
+		event.Hash,
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
 func (r *AuditEventRepo) ListByRequestID(ctx context.Context, requestID string) ([]models.AuditEvent, error) {
 	return r.list(ctx,
-		`SELECT id, request_id, event_type, actor, action, resource, metadata, created_at
+		`SELECT `+auditEventColumns+`
 		 FROM audit_events
 		 WHERE request_id = ?
 		 ORDER BY created_at ASC;`,
@@ -583,7 +777,7 @@ func (r *AuditEventRepo) ListByRequestID(ctx context.Context, requestID string)
 
 func (r *AuditEventRepo) ListByTimeRange(ctx context.Context, start, end time.Time) ([]models.AuditEvent, error) {
 	return r.list(ctx,
-		`SELECT id, request_id, event_type, actor, action, resource, metadata, created_at
+		`SELECT `+auditEventColumns+`
 		 FROM audit_events
 		 WHERE created_at >= ? AND created_at <= ?
 		 ORDER BY created_at ASC;`,
@@ -592,6 +786,100 @@ func (r *AuditEventRepo) ListByTimeRange(ctx context.Context, start, end time.Ti
 	)
 }
 
+func (r *AuditEventRepo) ListChain(ctx context.Context, afterSequence int64, limit int) ([]models.AuditEvent, error) {
+	return r.list(ctx,
+		`SELECT `+auditEventColumns+`
+		 FROM audit_events
+		 WHERE sequence > ?
+		 ORDER BY sequence ASC
+		 LIMIT ?;`,
+		afterSequence,
+		limit,
+	)
+}
+
+func (r *AuditEventRepo) ChainHead(ctx context.Context) (models.AuditEvent, error) {
+	events, err := r.list(ctx,
+		`SELECT `+auditEventColumns+`
+		 FROM audit_events
+		 WHERE sequence > 0
+		 ORDER BY sequence DESC
+		 LIMIT 1;`,
+	)
+	if err != nil {
+		return models.AuditEvent{}, err
+	}
+	if len(events) == 0 {
+		return models.AuditEvent{}, repository.ErrNotFound
+	}
+	return events[0], nil
+}
+
+func (r *AuditEventRepo) CountUnchained(ctx context.Context) (int64, error) {
+	var n int64
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_events WHERE sequence = 0;`).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *AuditEventRepo) CreateCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) error {
+	if err := checkpoint.Validate(); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO audit_checkpoints (id, sequence, hash, mac, created_at) VALUES (?, ?, ?, ?, ?);`,
+		checkpoint.ID,
+		checkpoint.Sequence,
+		checkpoint.Hash,
+		checkpoint.MAC,
+		formatTime(checkpoint.CreatedAt.Truncate(models.AuditChainTimePrecision)),
+	)
+	return err
+}
+
+func (r *AuditEventRepo) LatestCheckpoint(ctx context.Context) (models.AuditCheckpoint, error) {
+	checkpoints, err := r.listCheckpoints(ctx, `SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints ORDER BY sequence DESC, created_at DESC LIMIT 1;`)
+	if err != nil {
+		return models.AuditCheckpoint{}, err
+	}
+	if len(checkpoints) == 0 {
+		return models.AuditCheckpoint{}, repository.ErrNotFound
+	}
+	return checkpoints[0], nil
+}
+
+func (r *AuditEventRepo) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	return r.listCheckpoints(ctx, `SELECT id, sequence, hash, mac, created_at FROM audit_checkpoints ORDER BY sequence ASC, created_at ASC;`)
+}
+
+func (r *AuditEventRepo) listCheckpoints(ctx context.Context, query string, args ...any) ([]models.AuditCheckpoint, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.AuditCheckpoint
+	for rows.Next() {
+		var c models.AuditCheckpoint
+		var createdAt string
+		if err := rows.Scan(&c.ID, &c.Sequence, &c.Hash, &c.MAC, &createdAt); err != nil {
+			return nil, err
+		}
+		parsed, err := parseTime(createdAt)
+		if err != nil {
+			return nil, err
+		}
+		c.CreatedAt = parsed
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (r *AuditEventRepo) list(ctx context.Context, query string, args ...any) ([]models.AuditEvent, error) {
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -615,6 +903,9 @@ func (r *AuditEventRepo) list(ctx context.Context, query string, args ...any) ([
 			&e.Resource,
 			&metadata,
 			&createdAt,
+			&e.Sequence,
+			&e.PrevHash,
+			&e.Hash,
 		); err != nil {
 			return nil, err
 		}
@@ -721,8 +1012,100 @@ func (r *IdempotencyRecordRepo) DeleteExpired(ctx context.Context, now time.Time
 	return rows, nil
 }
 
+type SeenSignatureRepo struct{ db *sql.DB }
+
+var _ repository.SeenSignatureRepository = (*SeenSignatureRepo)(nil)
+
+func (r *SeenSignatureRepo) Remember(ctx context.Context, signature string, expiresAt time.Time) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO seen_signatures (signature, expires_at) VALUES (?, ?) ON CONFLICT(signature) DO NOTHING;`,
+		signature,
+		formatTime(expiresAt),
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (r *SeenSignatureRepo) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM seen_signatures WHERE expires_at <= ?;`, formatTime(now))
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+type UsageRepo struct{ db *sql.DB }
+
+var _ repository.UsageRepository = (*UsageRepo)(nil)
+
+// usageIntField reads a numeric body field, treating anything else as 0.
+func usageIntField(field string) string {
+	return `CASE WHEN json_type(dr.body, '$.` + field + `') IN ('integer', 'real') THEN CAST(json_extract(dr.body, '$.` + field + `') AS INTEGER) ELSE 0 END`
+}
+
+func (r *UsageRepo) SummarizeSubmits(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.SubmitUsage, error) {
+	query := `SELECT dr.api_key_id,
+			COALESCE(json_extract(dr.body, '$.req_key'), '') AS req_key,
+			substr(dr.received_at, 1, 10) AS day,
+			` + usageIntField("frames") + ` AS frames,
+			` + usageIntField("width") + ` AS width,
+			` + usageIntField("height") + ` AS height,
+			COUNT(*)
+		 FROM downstream_requests dr
+		 WHERE dr.action = ?
+		   AND dr.received_at >= ? AND dr.received_at < ?
+		   AND (? = '' OR dr.api_key_id = ?)
+		   AND EXISTS (
+			SELECT 1 FROM upstream_attempts ua
+			WHERE ua.request_id = dr.request_id
+			  AND ua.response_status BETWEEN 200 AND 299
+			  AND ua.error IS NULL
+		   )
+		 GROUP BY dr.api_key_id, req_key, day, frames, width, height
+		 ORDER BY day ASC, dr.api_key_id ASC, req_key ASC, frames ASC, width ASC, height ASC;`
+	rows, err := r.db.QueryContext(ctx, query,
+		string(models.DownstreamActionCVSync2AsyncSubmitTask),
+		formatTime(from),
+		formatTime(to),
+		apiKeyID,
+		apiKeyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.SubmitUsage
+	for rows.Next() {
+		var u models.SubmitUsage
+		if err := rows.Scan(&u.APIKeyID, &u.ReqKey, &u.Day, &u.Frames, &u.Width, &u.Height, &u.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// timeLayout keeps nine fractional digits so stored timestamps sort as text
+// in time order; RFC3339Nano trims trailing zeros and would put "05Z" after
+// "05.5Z". Every time is stored in UTC, so the zone is always "Z".
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
 func formatTime(t time.Time) string {
-	return t.UTC().Format(time.RFC3339Nano)
+	return t.UTC().Format(timeLayout)
 }
 
 func parseTime(v string) (time.Time, error) {
@@ -788,6 +1171,14 @@ func marshalJSONNullable(v any) (any, error) {
 	return string(b), nil
 }
 
+// nullableLabels stores a key without labels as NULL.
+func nullableLabels(labels map[string]string) (any, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return marshalJSONNullable(labels)
+}
+
 func unmarshalJSONNullable[T any](v sql.NullString, dst *T) error {
 	if dst == nil {
 		return fmt.Errorf("dst is nil")
@@ -3,6 +3,8 @@ package sqlite
 import (
 	"context"
 	"errors"
+	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -56,6 +58,7 @@ func TestAPIKeyRepo_CRUDAndQueries(t *testing.T) {
 		CreatedAt:           now,
 		UpdatedAt:           now,
 		ExpiresAt:           &expiresAt,
+		ClientCertSubject:   "CN=worker",
 		Status:              models.APIKeyStatusActive,
 	}
 	if err := repos.APIKeys.Create(ctx, key); err != nil {
@@ -66,7 +69,7 @@ func TestAPIKeyRepo_CRUDAndQueries(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetByAccessKey: %v", err)
 	}
-	if got.ID != key.ID || got.AccessKey != key.AccessKey || got.SecretKeyHash != key.SecretKeyHash || got.SecretKeyCiphertext != key.SecretKeyCiphertext {
+	if got.ID != key.ID || got.AccessKey != key.AccessKey || got.SecretKeyHash != key.SecretKeyHash || got.SecretKeyCiphertext != key.SecretKeyCiphertext || got.ClientCertSubject != key.ClientCertSubject {
 		t.Fatalf("unexpected key: %#v", got)
 	}
 	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
@@ -158,6 +161,58 @@ func TestAPIKeyRepo_NotFoundAndConstraints(t *testing.T) {
 	requireConstraintErr(t, err)
 }
 
+func TestAPIKeyRepo_RewriteSecretCiphertexts(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos(t)
+
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+	for _, id := range []string{"k1", "k2", "k3"} {
+		ct := "v1:" + id
+		if id == "k3" {
+			ct = "v2:new:" + id
+		}
+		key := models.APIKey{ID: id, AccessKey: "ak_" + id, SecretKeyHash: "hash", SecretKeyCiphertext: ct, Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now}
+		if err := repos.APIKeys.Create(ctx, key); err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+	}
+	rekey := func(ct string) (string, bool, error) {
+		if strings.HasPrefix(ct, "v2:new:") {
+			return ct, false, nil
+		}
+		return "v2:new:" + strings.TrimPrefix(ct, "v1:"), true, nil
+	}
+
+	scanned, rewritten, err := repos.APIKeys.RewriteSecretCiphertexts(ctx, rekey)
+	if err != nil {
+		t.Fatalf("RewriteSecretCiphertexts: %v", err)
+	}
+	if scanned != 3 || rewritten != 2 {
+		t.Fatalf("expected 3 scanned and 2 rewritten, got %d and %d", scanned, rewritten)
+	}
+	got, err := repos.APIKeys.GetByID(ctx, "k1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got.SecretKeyCiphertext != "v2:new:k1" || !got.UpdatedAt.Equal(now) {
+		t.Fatalf("unexpected rewritten key: %+v", got)
+	}
+
+	// A failure part way through leaves every row as it was.
+	_, _, err = repos.APIKeys.RewriteSecretCiphertexts(ctx, func(ct string) (string, bool, error) {
+		if ct == "v2:new:k2" {
+			return "", false, fmt.Errorf("boom")
+		}
+		return "v3:" + ct, true, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "k2") {
+		t.Fatalf("expected error naming k2, got %v", err)
+	}
+	if got, _ := repos.APIKeys.GetByID(ctx, "k1"); got.SecretKeyCiphertext != "v2:new:k1" {
+		t.Fatalf("expected rollback, got %q", got.SecretKeyCiphertext)
+	}
+}
+
 func TestDownstreamRequestRepo_CRUD(t *testing.T) {
 	ctx := context.Background()
 	repos := newTestRepos(t)
@@ -278,15 +333,15 @@ func TestAuditEventRepo_ListQueries(t *testing.T) {
 	base := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
 
 	e1 := models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeRequestReceived, Actor: "system", Action: "received", Resource: "relay.request", Metadata: map[string]any{"m": "1"}, CreatedAt: base}
-	if err := repos.AuditEvents.Create(ctx, e1); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e1); err != nil {
 		t.Fatalf("Create e1: %v", err)
 	}
 	e2 := models.AuditEvent{ID: "a2", RequestID: "req-1", EventType: models.EventTypeResponseSent, Actor: "system", Action: "sent", Resource: "relay.response", CreatedAt: base.Add(2 * time.Second)}
-	if err := repos.AuditEvents.Create(ctx, e2); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e2); err != nil {
 		t.Fatalf("Create e2: %v", err)
 	}
 	e3 := models.AuditEvent{ID: "a3", RequestID: "req-2", EventType: models.EventTypeError, Actor: "system", Action: "error", Resource: "relay.error", CreatedAt: base.Add(3 * time.Second)}
-	if err := repos.AuditEvents.Create(ctx, e3); err != nil {
+	if err := repos.AuditEvents.Create(ctx, &e3); err != nil {
 		t.Fatalf("Create e3: %v", err)
 	}
 
@@ -316,6 +371,82 @@ func TestAuditEventRepo_ListQueries(t *testing.T) {
 	}
 }
 
+func TestAuditEventRepo_HashChainAndCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos(t)
+
+	if _, err := repos.AuditEvents.ChainHead(ctx); !repository.IsNotFound(err) {
+		t.Fatalf("expected empty chain to be not found, got %v", err)
+	}
+	// Events written before chaining existed stay out of the chain.
+	if _, err := repos.DB.ExecContext(ctx, `INSERT INTO audit_events (id, request_id, event_type, action, resource, created_at) VALUES ('legacy', 'req-0', 'error', 'error', 'relay.error', '2026-01-01T00:00:00Z');`); err != nil {
+		t.Fatalf("insert legacy event: %v", err)
+	}
+
+	base := time.Date(2026, 2, 24, 3, 4, 5, 123456789, time.UTC)
+	for i := 1; i <= 3; i++ {
+		e := models.AuditEvent{
+			ID:        fmt.Sprintf("a%d", i),
+			RequestID: "req-1",
+			EventType: models.EventTypeUpstreamResponse,
+			Action:    "relay_call",
+			Resource:  "relay.call",
+			Metadata:  map[string]any{"response_status": 200, "latency_ms": int64(i), "ratio": 0.5},
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+		}
+		if err := repos.AuditEvents.Create(ctx, &e); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	chain, err := repos.AuditEvents.ListChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListChain: %v", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("expected 3 chained events, got %d", len(chain))
+	}
+	prevHash := ""
+	for i, e := range chain {
+		if e.Sequence != int64(i+1) || e.PrevHash != prevHash {
+			t.Fatalf("unexpected chain position for %s: seq=%d prev=%q", e.ID, e.Sequence, e.PrevHash)
+		}
+		// The hash must still match after the metadata and timestamp went
+		// through storage.
+		if h, err := e.ChainHash(); err != nil || h != e.Hash {
+			t.Fatalf("hash for %s does not survive a round trip: %q vs %q (%v)", e.ID, h, e.Hash, err)
+		}
+		prevHash = e.Hash
+	}
+	page, err := repos.AuditEvents.ListChain(ctx, 2, 10)
+	if err != nil || len(page) != 1 || page[0].ID != "a3" {
+		t.Fatalf("expected ListChain to page after sequence 2, got %v (%v)", page, err)
+	}
+	head, err := repos.AuditEvents.ChainHead(ctx)
+	if err != nil || head.ID != "a3" {
+		t.Fatalf("expected head a3, got %v (%v)", head.ID, err)
+	}
+	if n, err := repos.AuditEvents.CountUnchained(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 unchained event, got %d (%v)", n, err)
+	}
+
+	if _, err := repos.AuditEvents.LatestCheckpoint(ctx); !repository.IsNotFound(err) {
+		t.Fatalf("expected no checkpoint, got %v", err)
+	}
+	cp := models.AuditCheckpoint{ID: "acp_1", Sequence: head.Sequence, Hash: head.Hash, CreatedAt: base.Add(time.Minute)}
+	cp.MAC = cp.ComputeMAC([]byte("0123456789abcdef0123456789abcdef"))
+	if err := repos.AuditEvents.CreateCheckpoint(ctx, cp); err != nil {
+		t.Fatalf("CreateCheckpoint: %v", err)
+	}
+	latest, err := repos.AuditEvents.LatestCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("LatestCheckpoint: %v", err)
+	}
+	if latest.Sequence != 3 || latest.MAC != latest.ComputeMAC([]byte("0123456789abcdef0123456789abcdef")) {
+		t.Fatalf("checkpoint MAC does not survive a round trip: %+v", latest)
+	}
+}
+
 func TestIdempotencyRecordRepo_CRUDAndDeleteExpired(t *testing.T) {
 	ctx := context.Background()
 	repos := newTestRepos(t)
@@ -375,3 +506,242 @@ func TestIdempotencyRecordRepo_Constraints(t *testing.T) {
 	err := repos.IdempotencyRecords.Create(ctx, r2)
 	requireConstraintErr(t, err)
 }
+
+func TestSeenSignatureRepo_RememberAndDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos(t)
+
+	now := time.Date(2026, 2, 24, 3, 4, 5, 0, time.UTC)
+
+	fresh, err := repos.SeenSignatures.Remember(ctx, "sig-1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Remember sig-1: %v", err)
+	}
+	if !fresh {
+		t.Fatalf("expected first signature to be fresh")
+	}
+	fresh, err = repos.SeenSignatures.Remember(ctx, "sig-1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Remember sig-1 again: %v", err)
+	}
+	if fresh {
+		t.Fatalf("expected duplicate signature to be reported")
+	}
+	if _, err := repos.SeenSignatures.Remember(ctx, "sig-old", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("Remember sig-old: %v", err)
+	}
+
+	deleted, err := repos.SeenSignatures.DeleteExpired(ctx, now)
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d", deleted)
+	}
+	fresh, err = repos.SeenSignatures.Remember(ctx, "sig-old", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Remember sig-old after purge: %v", err)
+	}
+	if !fresh {
+		t.Fatalf("expected purged signature to be fresh again")
+	}
+}
+
+func TestUsageRepo_SummarizeSubmits(t *testing.T) {
+	ctx := context.Background()
+	repos := newTestRepos(t)
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	errStr := "upstream 500"
+	submit := func(id, apiKeyID string, at time.Time, body map[string]any, status int, upstreamErr *string) {
+		t.Helper()
+		req := models.DownstreamRequest{ID: "d-" + id, RequestID: id, APIKeyID: apiKeyID, Action: models.DownstreamActionCVSync2AsyncSubmitTask, Method: "POST", Path: "/", Body: body, ReceivedAt: at}
+		if err := repos.DownstreamRequests.Create(ctx, req); err != nil {
+			t.Fatalf("Create downstream %s: %v", id, err)
+		}
+		if status == 0 {
+			return
+		}
+		attempt := models.UpstreamAttempt{ID: "u-" + id, RequestID: id, AttemptNumber: 1, UpstreamAction: "CVSync2AsyncSubmitTask", ResponseStatus: status, Error: upstreamErr, SentAt: at}
+		if err := repos.UpstreamAttempts.Create(ctx, attempt); err != nil {
+			t.Fatalf("Create attempt %s: %v", id, err)
+		}
+	}
+	video := map[string]any{"req_key": "jimeng_t2v_v30", "frames": 241}
+	image := map[string]any{"req_key": "jimeng_t2i_v40", "width": 2048, "height": 2048}
+
+	submit("r1", "k1", day.Add(time.Hour), video, 200, nil)
+	submit("r2", "k1", day.Add(2*time.Hour+500*time.Millisecond), video, 200, nil)
+	submit("r3", "k1", day.Add(3*time.Hour), map[string]any{"req_key": "jimeng_t2v_v30"}, 200, nil)
+	submit("r4", "k2", day.Add(25*time.Hour), image, 200, nil)
+	submit("r5", "k1", day.Add(4*time.Hour), video, 500, &errStr)
+	submit("r6", "k1", day.Add(5*time.Hour), video, 0, nil)
+	submit("r7", "k1", day.Add(49*time.Hour), video, 200, nil)
+	getResult := models.DownstreamRequest{ID: "d-r8", RequestID: "r8", APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncGetResult, Method: "POST", Path: "/", Body: video, ReceivedAt: day}
+	if err := repos.DownstreamRequests.Create(ctx, getResult); err != nil {
+		t.Fatalf("Create get_result: %v", err)
+	}
+
+	got, err := repos.Usage.SummarizeSubmits(ctx, day, day.Add(48*time.Hour), "")
+	if err != nil {
+		t.Fatalf("SummarizeSubmits: %v", err)
+	}
+	want := []models.SubmitUsage{
+		{APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Day: "2026-03-01", Count: 1},
+		{APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Day: "2026-03-01", Frames: 241, Count: 2},
+		{APIKeyID: "k2", ReqKey: "jimeng_t2i_v40", Day: "2026-03-02", Width: 2048, Height: 2048, Count: 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected usage:\n got %+v\nwant %+v", got, want)
+	}
+
+	got, err = repos.Usage.SummarizeSubmits(ctx, day, day.Add(72*time.Hour), "k2")
+	if err != nil {
+		t.Fatalf("SummarizeSubmits k2: %v", err)
+	}
+	if len(got) != 1 || got[0].APIKeyID != "k2" {
+		t.Fatalf("expected only k2 usage, got %+v", got)
+	}
+}
+
+func TestRepositories_Ping(t *testing.T) {
+	repos := newTestRepos(t)
+	if err := repos.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := repos.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := repos.Ping(context.Background()); err == nil {
+		t.Fatalf("expected ping on a closed db to fail")
+	}
+}
+
+func TestBulkRepo_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepos(t)
+	// newTestRepos names the in-memory database after the test, so the
+	// destination needs a database of its own.
+	dst, err := Open(ctx, filepath.Join(t.TempDir(), "dst.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = dst.Close() })
+
+	now := time.Date(2026, 2, 24, 3, 4, 5, 123456789, time.UTC)
+	for i := 1; i <= 3; i++ {
+		key := models.APIKey{ID: fmt.Sprintf("k%d", i), AccessKey: fmt.Sprintf("ak_%d", i), SecretKeyHash: "hash", SecretKeyCiphertext: "v1:test", Status: models.APIKeyStatusActive, CreatedAt: now, UpdatedAt: now}
+		if err := src.APIKeys.Create(ctx, key); err != nil {
+			t.Fatalf("Create api key: %v", err)
+		}
+	}
+	if err := src.AuditEvents.Create(ctx, &models.AuditEvent{ID: "a1", RequestID: "req-1", EventType: models.EventTypeUpstreamResponse, Action: "relay_call", Resource: "relay.call", Metadata: map[string]any{"n": 1}, CreatedAt: now}); err != nil {
+		t.Fatalf("Create audit event: %v", err)
+	}
+
+	page, err := src.Bulk.ExportAPIKeys(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("ExportAPIKeys: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != "k1" || page[1].ID != "k2" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	if n, err := dst.Bulk.ImportAPIKeys(ctx, page); err != nil || n != 2 {
+		t.Fatalf("ImportAPIKeys: %d, %v", n, err)
+	}
+	rest, err := src.Bulk.ExportAPIKeys(ctx, page[1].ID, 2)
+	if err != nil || len(rest) != 1 || rest[0].ID != "k3" {
+		t.Fatalf("unexpected second page: %+v, %v", rest, err)
+	}
+	// Re-importing the first page alongside new rows only inserts the new ones.
+	if n, err := dst.Bulk.ImportAPIKeys(ctx, append(page, rest...)); err != nil || n != 1 {
+		t.Fatalf("expected only k3 to be inserted, got %d, %v", n, err)
+	}
+	got, err := dst.APIKeys.GetByID(ctx, "k1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if !got.CreatedAt.Equal(now) || got.SecretKeyCiphertext != "v1:test" {
+		t.Fatalf("expected the key to be copied verbatim, got %+v", got)
+	}
+
+	events, err := src.Bulk.ExportAuditEvents(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("ExportAuditEvents: %v", err)
+	}
+	if n, err := dst.Bulk.ImportAuditEvents(ctx, events); err != nil || n != 1 {
+		t.Fatalf("ImportAuditEvents: %d, %v", n, err)
+	}
+	head, err := dst.AuditEvents.ChainHead(ctx)
+	if err != nil {
+		t.Fatalf("ChainHead: %v", err)
+	}
+	if h, err := head.ChainHash(); err != nil || head.Sequence != 1 || h != head.Hash {
+		t.Fatalf("expected the copied event to keep its chain position and hash, got %+v (%v)", head, err)
+	}
+
+	day := time.Date(2026, 2, 24, 0, 0, 0, 0, time.UTC)
+	if err := src.APIKeys.RecordActivity(ctx, []models.APIKeyActivity{
+		{APIKeyID: "k1", Day: day, Requests: 2, LastUsedAt: now},
+		{APIKeyID: "k2", Day: day, Requests: 3, LastUsedAt: now},
+	}); err != nil {
+		t.Fatalf("RecordActivity: %v", err)
+	}
+	counts, err := src.Bulk.ExportAPIKeyDailyRequests(ctx, repository.APIKeyDayKey{}, 1)
+	if err != nil || len(counts) != 1 || counts[0].APIKeyID != "k1" || !counts[0].Day.Equal(day) || counts[0].Requests != 2 {
+		t.Fatalf("unexpected first daily requests page: %+v, %v", counts, err)
+	}
+	more, err := src.Bulk.ExportAPIKeyDailyRequests(ctx, repository.APIKeyDayKey{APIKeyID: "k1", Day: day}, 10)
+	if err != nil || len(more) != 1 || more[0].APIKeyID != "k2" {
+		t.Fatalf("unexpected second daily requests page: %+v, %v", more, err)
+	}
+	if n, err := dst.Bulk.ImportAPIKeyDailyRequests(ctx, append(counts, more...)); err != nil || n != 2 {
+		t.Fatalf("ImportAPIKeyDailyRequests: %d, %v", n, err)
+	}
+
+	hour := day.Add(3 * time.Hour)
+	rollups := []models.StatsRollup{
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "2xx", Attempts: 4},
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "5xx", Attempts: 1, Errors: 1},
+	}
+	if err := src.StatsRollups.ReplaceRollups(ctx, hour, hour.Add(time.Hour), rollups); err != nil {
+		t.Fatalf("ReplaceRollups: %v", err)
+	}
+	first, err := src.Bulk.ExportStatsRollups(ctx, repository.StatsRollupKey{}, 1)
+	if err != nil || len(first) != 1 || first[0].StatusClass != "2xx" || !first[0].Hour.Equal(hour) {
+		t.Fatalf("unexpected first rollup page: %+v, %v", first, err)
+	}
+	next, err := src.Bulk.ExportStatsRollups(ctx, repository.StatsRollupKey{Hour: first[0].Hour, APIKeyID: first[0].APIKeyID, Action: string(first[0].Action), StatusClass: first[0].StatusClass}, 10)
+	if err != nil || len(next) != 1 || next[0].StatusClass != "5xx" {
+		t.Fatalf("unexpected second rollup page: %+v, %v", next, err)
+	}
+	if n, err := dst.Bulk.ImportStatsRollups(ctx, append(first, next...)); err != nil || n != 2 {
+		t.Fatalf("ImportStatsRollups: %d, %v", n, err)
+	}
+	watermark, err := src.Bulk.ExportStatsRollupWatermark(ctx)
+	if err != nil || !watermark.Equal(hour.Add(time.Hour)) {
+		t.Fatalf("ExportStatsRollupWatermark: %v, %v", watermark, err)
+	}
+	if n, err := dst.Bulk.ImportStatsRollupWatermark(ctx, watermark); err != nil || n != 1 {
+		t.Fatalf("ImportStatsRollupWatermark: %d, %v", n, err)
+	}
+	if n, err := dst.Bulk.ImportStatsRollupWatermark(ctx, watermark.Add(time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected an existing watermark to be kept, got %d, %v", n, err)
+	}
+
+	for table, want := range map[string]int64{
+		repository.TableAPIKeys:              3,
+		repository.TableAPIKeyDailyRequests:  2,
+		repository.TableAuditEvents:          1,
+		repository.TableIdempotencyRecords:   0,
+		repository.TableStatsRollups:         2,
+		repository.TableStatsRollupWatermark: 1,
+	} {
+		if n, err := dst.Bulk.CountRows(ctx, table); err != nil || n != want {
+			t.Fatalf("CountRows(%s) = %d, %v; want %d", table, n, err, want)
+		}
+	}
+	if _, err := dst.Bulk.CountRows(ctx, "seen_signatures; DROP TABLE api_keys"); err == nil {
+		t.Fatalf("expected an unknown table to be rejected")
+	}
+}
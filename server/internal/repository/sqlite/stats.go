@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type StatsRollupRepo struct{ db *sql.DB }
+
+var _ repository.StatsRollupRepository = (*StatsRollupRepo)(nil)
+
+func (r *StatsRollupRepo) RollupSamples(ctx context.Context, from, to time.Time) ([]models.RollupSample, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT dr.api_key_id, dr.action, ua.attempt_number, ua.response_status, ua.latency_ms, ua.error IS NOT NULL, ua.sent_at
+		 FROM upstream_attempts ua
+		 JOIN downstream_requests dr ON dr.request_id = ua.request_id
+		 WHERE ua.sent_at >= ? AND ua.sent_at < ?;`,
+		formatTime(from),
+		formatTime(to),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.RollupSample
+	for rows.Next() {
+		var s models.RollupSample
+		var action string
+		var sentAt string
+		if err := rows.Scan(&s.APIKeyID, &action, &s.AttemptNumber, &s.ResponseStatus, &s.LatencyMs, &s.Failed, &sentAt); err != nil {
+			return nil, err
+		}
+		s.Action = models.DownstreamAction(action)
+		if s.SentAt, err = parseTime(sentAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *StatsRollupRepo) FirstSampleTime(ctx context.Context) (time.Time, error) {
+	var first sql.NullString
+	if err := r.db.QueryRowContext(ctx, `SELECT MIN(sent_at) FROM upstream_attempts;`).Scan(&first); err != nil {
+		return time.Time{}, err
+	}
+	if !first.Valid {
+		return time.Time{}, repository.ErrNotFound
+	}
+	return parseTime(first.String)
+}
+
+func (r *StatsRollupRepo) Watermark(ctx context.Context) (time.Time, error) {
+	var watermark string
+	err := r.db.QueryRowContext(ctx, `SELECT watermark FROM stats_rollup_state WHERE id = 1;`).Scan(&watermark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(watermark)
+}
+
+func (r *StatsRollupRepo) ReplaceRollups(ctx context.Context, from, to time.Time, rollups []models.StatsRollup) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM stats_rollups WHERE hour >= ? AND hour < ?;`, formatTime(from), formatTime(to)); err != nil {
+		return fmt.Errorf("delete rollups: %w", err)
+	}
+	for _, s := range rollups {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO stats_rollups (
+				hour, api_key_id, action, status_class, attempts, retries, errors,
+				latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			formatTime(s.Hour), s.APIKeyID, string(s.Action), s.StatusClass, s.Attempts, s.Retries, s.Errors,
+			s.LatencySumMs, s.LatencyP50Ms, s.LatencyP95Ms, s.LatencyMaxMs,
+		); err != nil {
+			return fmt.Errorf("insert rollup: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO stats_rollup_state (id, watermark) VALUES (1, ?)
+		 ON CONFLICT(id) DO UPDATE SET watermark = MAX(watermark, excluded.watermark);`,
+		formatTime(to),
+	); err != nil {
+		return fmt.Errorf("advance watermark: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *StatsRollupRepo) ListRollups(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT hour, api_key_id, action, status_class, attempts, retries, errors,
+			latency_sum_ms, latency_p50_ms, latency_p95_ms, latency_max_ms
+		 FROM stats_rollups
+		 WHERE hour >= ? AND hour < ?
+		   AND (? = '' OR api_key_id = ?)
+		 ORDER BY hour ASC, api_key_id ASC, action ASC, status_class ASC;`,
+		formatTime(from),
+		formatTime(to),
+		apiKeyID,
+		apiKeyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.StatsRollup
+	for rows.Next() {
+		var s models.StatsRollup
+		var hour, action string
+		if err := rows.Scan(&hour, &s.APIKeyID, &action, &s.StatusClass, &s.Attempts, &s.Retries, &s.Errors,
+			&s.LatencySumMs, &s.LatencyP50Ms, &s.LatencyP95Ms, &s.LatencyMaxMs); err != nil {
+			return nil, err
+		}
+		s.Action = models.DownstreamAction(action)
+		if s.Hour, err = parseTime(hour); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -45,13 +45,11 @@ func NewAESCipherWithRandom(key []byte, rnd io.Reader) (*AESCipher, error) {
 }
 
 func (c *AESCipher) Encrypt(plaintext string) (string, error) {
-	nonce := make([]byte, c.aead.NonceSize())
-	if _, err := io.ReadFull(c.rnd, nonce); err != nil {
-		return "", fmt.Errorf("generate nonce: %w", err)
+	enc, err := c.seal(plaintext)
+	if err != nil {
+		return "", err
 	}
-	sealed := c.aead.Seal(nil, nonce, []byte(plaintext), nil)
-	combined := append(nonce, sealed...)
-	return ciphertextPrefix + base64.StdEncoding.EncodeToString(combined), nil
+	return ciphertextPrefix + enc, nil
 }
 
 func (c *AESCipher) Decrypt(ciphertext string) (string, error) {
@@ -59,7 +57,21 @@ func (c *AESCipher) Decrypt(ciphertext string) (string, error) {
 	if !strings.HasPrefix(v, ciphertextPrefix) {
 		return "", fmt.Errorf("ciphertext prefix is invalid")
 	}
-	enc := strings.TrimPrefix(v, ciphertextPrefix)
+	return c.open(strings.TrimPrefix(v, ciphertextPrefix))
+}
+
+// seal returns base64(nonce || ciphertext) without a version prefix.
+func (c *AESCipher) seal(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(c.rnd, nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nil, nonce, []byte(plaintext), nil)
+	combined := append(nonce, sealed...)
+	return base64.StdEncoding.EncodeToString(combined), nil
+}
+
+func (c *AESCipher) open(enc string) (string, error) {
 	raw, err := base64.StdEncoding.DecodeString(enc)
 	if err != nil {
 		return "", fmt.Errorf("decode ciphertext: %w", err)
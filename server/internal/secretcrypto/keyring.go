@@ -0,0 +1,141 @@
+package secretcrypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// keyringPrefix marks ciphertexts written by a Keyring: v2:<key id>:<base64>.
+const keyringPrefix = "v2:"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// Key is one AES-256 key in a Keyring.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring encrypts with its newest key and decrypts with whichever key a
+// ciphertext names, so the encryption key can be rotated without making
+// stored secrets unreadable. Legacy v1 ciphertexts, which carry no key id,
+// are tried against every key.
+type Keyring struct {
+	ciphers map[string]*AESCipher
+	// order lists key ids oldest first; the last one encrypts.
+	order []string
+}
+
+// NewKeyring builds a keyring from keys ordered oldest to newest.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	return NewKeyringWithRandom(keys, rand.Reader)
+}
+
+func NewKeyringWithRandom(keys []Key, rnd io.Reader) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring needs at least one key")
+	}
+	k := &Keyring{ciphers: make(map[string]*AESCipher, len(keys))}
+	for _, key := range keys {
+		if !keyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("invalid key id %q (use 1-32 letters, digits, '.', '_' or '-')", key.ID)
+		}
+		if _, dup := k.ciphers[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		c, err := NewAESCipherWithRandom(key.Secret, rnd)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		k.ciphers[key.ID] = c
+		k.order = append(k.order, key.ID)
+	}
+	return k, nil
+}
+
+// ParseKeys parses "id:base64key,id:base64key", oldest first.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q must be <id>:<base64>", item)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("decode key %q: %w", strings.TrimSpace(id), err)
+		}
+		keys = append(keys, Key{ID: strings.TrimSpace(id), Secret: raw})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	return keys, nil
+}
+
+// PrimaryID is the id of the key new ciphertexts are written with.
+func (k *Keyring) PrimaryID() string {
+	return k.order[len(k.order)-1]
+}
+
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	id := k.PrimaryID()
+	enc, err := k.ciphers[id].seal(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return keyringPrefix + id + ":" + enc, nil
+}
+
+func (k *Keyring) Decrypt(ciphertext string) (string, error) {
+	v := strings.TrimSpace(ciphertext)
+	if strings.HasPrefix(v, keyringPrefix) {
+		id, enc, ok := strings.Cut(strings.TrimPrefix(v, keyringPrefix), ":")
+		if !ok {
+			return "", fmt.Errorf("ciphertext key id is missing")
+		}
+		c, found := k.ciphers[id]
+		if !found {
+			return "", fmt.Errorf("ciphertext key id %q is not in the keyring", id)
+		}
+		return c.open(enc)
+	}
+	if strings.HasPrefix(v, ciphertextPrefix) {
+		// GCM authentication rejects the wrong key, so trying each is safe.
+		var lastErr error
+		for i := len(k.order) - 1; i >= 0; i-- {
+			plain, err := k.ciphers[k.order[i]].Decrypt(v)
+			if err == nil {
+				return plain, nil
+			}
+			lastErr = err
+		}
+		return "", lastErr
+	}
+	return "", fmt.Errorf("ciphertext prefix is invalid")
+}
+
+// Rekey re-encrypts ciphertext under the primary key. It reports false and
+// returns ciphertext unchanged when it already uses the primary key.
+func (k *Keyring) Rekey(ciphertext string) (string, bool, error) {
+	if strings.HasPrefix(strings.TrimSpace(ciphertext), keyringPrefix+k.PrimaryID()+":") {
+		return ciphertext, false, nil
+	}
+	plain, err := k.Decrypt(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	out, err := k.Encrypt(plain)
+	if err != nil {
+		return "", false, err
+	}
+	return out, true, nil
+}
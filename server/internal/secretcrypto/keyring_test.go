@@ -0,0 +1,126 @@
+package secretcrypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = []byte("0123456789abcdef0123456789abcdef")
+	newKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestKeyring_EncryptsWithNewestAndDecryptsAll(t *testing.T) {
+	legacy, err := NewAESCipher(oldKey)
+	if err != nil {
+		t.Fatalf("NewAESCipher: %v", err)
+	}
+	v1, err := legacy.Encrypt("legacy-secret")
+	if err != nil {
+		t.Fatalf("Encrypt v1: %v", err)
+	}
+
+	before, err := NewKeyring([]Key{{ID: "2025", Secret: oldKey}})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	v2Old, err := before.Encrypt("old-secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(v2Old, "v2:2025:") {
+		t.Fatalf("expected key id in ciphertext, got %q", v2Old)
+	}
+
+	ring, err := NewKeyring([]Key{{ID: "2025", Secret: oldKey}, {ID: "2026", Secret: newKey}})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if ring.PrimaryID() != "2026" {
+		t.Fatalf("expected newest key to be primary, got %q", ring.PrimaryID())
+	}
+	for ct, want := range map[string]string{v1: "legacy-secret", v2Old: "old-secret"} {
+		got, err := ring.Decrypt(ct)
+		if err != nil || got != want {
+			t.Fatalf("Decrypt(%q) = %q, %v; want %q", ct, got, err, want)
+		}
+	}
+	ct, err := ring.Encrypt("new-secret")
+	if err != nil || !strings.HasPrefix(ct, "v2:2026:") {
+		t.Fatalf("expected primary key id in ciphertext, got %q, %v", ct, err)
+	}
+
+	// Once the old key is dropped its ciphertexts are rejected by id.
+	newOnly, err := NewKeyring([]Key{{ID: "2026", Secret: newKey}})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err := newOnly.Decrypt(v2Old); err == nil || !strings.Contains(err.Error(), `"2025"`) {
+		t.Fatalf("expected unknown key id error, got %v", err)
+	}
+	if _, err := newOnly.Decrypt(v1); err == nil {
+		t.Fatalf("expected v1 ciphertext under a dropped key to fail")
+	}
+}
+
+func TestKeyring_Rekey(t *testing.T) {
+	rnd := bytes.NewReader(bytes.Repeat([]byte{0x02}, 256))
+	ring, err := NewKeyringWithRandom([]Key{{ID: "a", Secret: oldKey}, {ID: "b", Secret: newKey}}, rnd)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	old, err := NewKeyring([]Key{{ID: "a", Secret: oldKey}})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	ct, err := old.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	out, changed, err := ring.Rekey(ct)
+	if err != nil || !changed || !strings.HasPrefix(out, "v2:b:") {
+		t.Fatalf("Rekey = %q, %v, %v", out, changed, err)
+	}
+	if pt, err := ring.Decrypt(out); err != nil || pt != "secret" {
+		t.Fatalf("Decrypt rekeyed = %q, %v", pt, err)
+	}
+	again, changed, err := ring.Rekey(out)
+	if err != nil || changed || again != out {
+		t.Fatalf("expected primary ciphertext to be left alone, got %q, %v, %v", again, changed, err)
+	}
+	if _, _, err := ring.Rekey("v2:zzz:AAAA"); err == nil {
+		t.Fatalf("expected rekey of an unknown key id to fail")
+	}
+}
+
+func TestNewKeyring_Rejects(t *testing.T) {
+	for name, keys := range map[string][]Key{
+		"empty":     nil,
+		"bad id":    {{ID: "has:colon", Secret: oldKey}},
+		"duplicate": {{ID: "a", Secret: oldKey}, {ID: "a", Secret: newKey}},
+		"short key": {{ID: "a", Secret: []byte("short")}},
+	} {
+		if _, err := NewKeyring(keys); err == nil {
+			t.Fatalf("%s: expected NewKeyring to fail", name)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	spec := "2025:" + base64.StdEncoding.EncodeToString(oldKey) + ", 2026:" + base64.StdEncoding.EncodeToString(newKey)
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "2025" || keys[1].ID != "2026" || !bytes.Equal(keys[1].Secret, newKey) {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+	for _, bad := range []string{"", "nokey", "a:not-base64!"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package secretcrypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider supplies the master keys that protect stored API key secrets,
+// ordered oldest to newest.
+type KeyProvider interface {
+	Keys(ctx context.Context) ([]Key, error)
+}
+
+// NewCipher builds the cipher for keys: a single key without an id keeps the
+// legacy v1 format, anything else becomes a Keyring.
+func NewCipher(keys []Key) (Cipher, error) {
+	if len(keys) == 1 && keys[0].ID == "" {
+		return NewAESCipher(keys[0].Secret)
+	}
+	return NewKeyring(keys)
+}
+
+// EnvProvider holds keys given inline, as API_KEY_ENCRYPTION_KEY (Single, a
+// base64 key) or API_KEY_ENCRYPTION_KEYS (Keyring, see ParseKeys) carry them.
+type EnvProvider struct {
+	Single  string
+	Keyring string
+}
+
+func (p EnvProvider) Keys(context.Context) ([]Key, error) {
+	if spec := strings.TrimSpace(p.Keyring); spec != "" {
+		return ParseKeys(spec)
+	}
+	return parseSingleKey(p.Single)
+}
+
+// FileProvider reads keys from a file holding either one base64 key or
+// keyring entries ("id:base64", one per line or comma separated). The file
+// must not be readable by group or others.
+type FileProvider struct {
+	Path string
+}
+
+func (p FileProvider) Keys(context.Context) ([]Key, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("stat key file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("key file %s is not a regular file", p.Path)
+	}
+	// Windows has no POSIX permission bits to check.
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s has mode %04o; it must not be accessible by group or others (chmod 600)", p.Path, perm)
+	}
+	content, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	spec := strings.Join(entries, ",")
+	// Base64 never contains ':', so its presence marks keyring entries.
+	if strings.Contains(spec, ":") {
+		return ParseKeys(spec)
+	}
+	return parseSingleKey(spec)
+}
+
+func parseSingleKey(encoded string) ([]Key, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("no key given")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	return []Key{{Secret: raw}}, nil
+}
+
+const (
+	DefaultKMSCacheTTL = time.Hour
+	defaultKMSTimeout  = 10 * time.Second
+	maxKMSResponseSize = 64 << 10
+)
+
+type KMSConfig struct {
+	// Endpoint is the base URL of the KMS; data keys are unwrapped with
+	// POST {Endpoint}/v1/decrypt.
+	Endpoint string
+	// KeyID names the KMS master key that wrapped the data keys.
+	KeyID string
+	// Token is sent as a bearer token when set.
+	Token string
+	// WrappedKeys are the data keys encrypted by the KMS, oldest first; each
+	// Secret holds the wrapped blob.
+	WrappedKeys []Key
+	// CacheTTL is how long unwrapped keys are kept in memory before the KMS
+	// is asked again. Zero means DefaultKMSCacheTTL; negative disables the
+	// cache.
+	CacheTTL time.Duration
+	Client   *http.Client
+}
+
+// KMSProvider implements envelope encryption: only wrapped data keys are
+// configured, and the KMS unwraps them on demand. Unwrapped keys stay in
+// memory only.
+type KMSProvider struct {
+	endpoint string
+	keyID    string
+	token    string
+	wrapped  []Key
+	ttl      time.Duration
+	client   *http.Client
+	now      func() time.Time
+
+	mu        sync.Mutex
+	cached    []Key
+	fetchedAt time.Time
+}
+
+func NewKMSProvider(cfg KMSConfig) (*KMSProvider, error) {
+	u, err := url.Parse(strings.TrimSpace(cfg.Endpoint))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("kms endpoint must be an http(s) URL")
+	}
+	if strings.TrimSpace(cfg.KeyID) == "" {
+		return nil, fmt.Errorf("kms key id is required")
+	}
+	if len(cfg.WrappedKeys) == 0 {
+		return nil, fmt.Errorf("at least one wrapped data key is required")
+	}
+	for _, k := range cfg.WrappedKeys {
+		if !keyIDPattern.MatchString(k.ID) {
+			return nil, fmt.Errorf("invalid key id %q (use 1-32 letters, digits, '.', '_' or '-')", k.ID)
+		}
+	}
+	ttl := cfg.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultKMSCacheTTL
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultKMSTimeout}
+	}
+	return &KMSProvider{
+		endpoint: strings.TrimRight(u.String(), "/"),
+		keyID:    strings.TrimSpace(cfg.KeyID),
+		token:    cfg.Token,
+		wrapped:  append([]Key(nil), cfg.WrappedKeys...),
+		ttl:      ttl,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Keys returns the unwrapped data keys, asking the KMS only when the cache
+// is empty or older than the TTL.
+func (p *KMSProvider) Keys(ctx context.Context) ([]Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cached != nil && p.ttl > 0 && p.now().Sub(p.fetchedAt) < p.ttl {
+		return cloneKeys(p.cached), nil
+	}
+
+	keys := make([]Key, 0, len(p.wrapped))
+	for _, w := range p.wrapped {
+		var resp struct {
+			Plaintext string `json:"plaintext"`
+		}
+		if err := p.call(ctx, "/v1/decrypt", map[string]string{
+			"key_id":          p.keyID,
+			"ciphertext_blob": base64.StdEncoding.EncodeToString(w.Secret),
+		}, &resp); err != nil {
+			return nil, fmt.Errorf("unwrap data key %q: %w", w.ID, err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key %q: decode plaintext: %w", w.ID, err)
+		}
+		keys = append(keys, Key{ID: w.ID, Secret: raw})
+	}
+	p.cached, p.fetchedAt = keys, p.now()
+	return cloneKeys(keys), nil
+}
+
+// GenerateDataKey asks the KMS for a new 32-byte data key and returns only
+// its wrapped form, ready to be added to the wrapped key list.
+func (p *KMSProvider) GenerateDataKey(ctx context.Context) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob string `json:"ciphertext_blob"`
+	}
+	if err := p.call(ctx, "/v1/generate-data-key", map[string]any{
+		"key_id":          p.keyID,
+		"number_of_bytes": 32,
+	}, &resp); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	blob, err := base64.StdEncoding.DecodeString(resp.CiphertextBlob)
+	if err != nil || len(blob) == 0 {
+		return nil, fmt.Errorf("generate data key: invalid ciphertext_blob")
+	}
+	return blob, nil
+}
+
+func (p *KMSProvider) call(ctx context.Context, path string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKMSResponseSize))
+	if err != nil {
+		return fmt.Errorf("read kms response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("kms returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode kms response: %w", err)
+	}
+	return nil
+}
+
+func cloneKeys(keys []Key) []Key {
+	out := make([]Key, len(keys))
+	for i, k := range keys {
+		out[i] = Key{ID: k.ID, Secret: append([]byte(nil), k.Secret...)}
+	}
+	return out
+}
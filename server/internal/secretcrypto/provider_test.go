@@ -0,0 +1,201 @@
+package secretcrypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnvProvider(t *testing.T) {
+	keys, err := EnvProvider{Single: base64.StdEncoding.EncodeToString(oldKey)}.Keys(context.Background())
+	if err != nil || len(keys) != 1 || keys[0].ID != "" {
+		t.Fatalf("unexpected single key: %+v, %v", keys, err)
+	}
+	c, err := NewCipher(keys)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	if ct, _ := c.Encrypt("x"); !strings.HasPrefix(ct, "v1:") {
+		t.Fatalf("expected a single key to keep writing v1, got %q", ct)
+	}
+
+	keys, err = EnvProvider{Keyring: "a:" + base64.StdEncoding.EncodeToString(newKey)}.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if c, err = NewCipher(keys); err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	if ct, _ := c.Encrypt("x"); !strings.HasPrefix(ct, "v2:a:") {
+		t.Fatalf("expected a keyring, got %q", ct)
+	}
+	if _, err := (EnvProvider{}).Keys(context.Background()); err == nil {
+		t.Fatalf("expected an empty provider to fail")
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys")
+	content := "# rotated 2026-03\nold:" + base64.StdEncoding.EncodeToString(oldKey) + "\nnew:" + base64.StdEncoding.EncodeToString(newKey) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	keys, err := FileProvider{Path: path}.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 2 || keys[1].ID != "new" || !bytes.Equal(keys[1].Secret, newKey) {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	single := filepath.Join(dir, "single")
+	if err := os.WriteFile(single, []byte(base64.StdEncoding.EncodeToString(oldKey)+"\n"), 0o400); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if keys, err := (FileProvider{Path: single}).Keys(context.Background()); err != nil || len(keys) != 1 || keys[0].ID != "" {
+		t.Fatalf("unexpected single key: %+v, %v", keys, err)
+	}
+
+	if _, err := (FileProvider{Path: dir}).Keys(context.Background()); err == nil {
+		t.Fatalf("expected a directory to be rejected")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if _, err := (FileProvider{Path: path}).Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "0640") {
+		t.Fatalf("expected a group-readable key file to be rejected, got %v", err)
+	}
+}
+
+// fakeKMS wraps data keys by XOR with a fixed byte, which is enough to prove
+// the provider sends the wrapped blob and uses what comes back.
+func fakeKMS(t *testing.T, calls *int32) *httptest.Server {
+	t.Helper()
+	xor := func(b []byte) []byte {
+		out := make([]byte, len(b))
+		for i := range b {
+			out[i] = b[i] ^ 0x5a
+		}
+		return out
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer kms-token" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			KeyID          string `json:"key_id"`
+			CiphertextBlob string `json:"ciphertext_blob"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID != "master-1" {
+			http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/decrypt":
+			atomic.AddInt32(calls, 1)
+			blob, _ := base64.StdEncoding.DecodeString(req.CiphertextBlob)
+			_ = json.NewEncoder(w).Encode(map[string]string{"plaintext": base64.StdEncoding.EncodeToString(xor(blob))})
+		case "/v1/generate-data-key":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"plaintext":       base64.StdEncoding.EncodeToString(newKey),
+				"ciphertext_blob": base64.StdEncoding.EncodeToString(xor(newKey)),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestKMSProvider_UnwrapsAndCaches(t *testing.T) {
+	var calls int32
+	srv := fakeKMS(t, &calls)
+	defer srv.Close()
+
+	p, err := NewKMSProvider(KMSConfig{Endpoint: srv.URL + "/", KeyID: "master-1", Token: "kms-token", WrappedKeys: []Key{{ID: "dk1", Secret: []byte("placeholder")}}, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewKMSProvider: %v", err)
+	}
+	wrapped, err := p.GenerateDataKey(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+	if bytes.Equal(wrapped, newKey) {
+		t.Fatalf("expected only the wrapped data key to be returned")
+	}
+
+	p, err = NewKMSProvider(KMSConfig{Endpoint: srv.URL, KeyID: "master-1", Token: "kms-token", WrappedKeys: []Key{{ID: "dk1", Secret: wrapped}}, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewKMSProvider: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	keys, err := p.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != "dk1" || !bytes.Equal(keys[0].Secret, newKey) {
+		t.Fatalf("unexpected unwrapped keys: %+v", keys)
+	}
+	if _, err := NewCipher(keys); err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+
+	keys[0].Secret[0] ^= 0xff
+	now = now.Add(30 * time.Second)
+	again, err := p.Keys(context.Background())
+	if err != nil || !bytes.Equal(again[0].Secret, newKey) {
+		t.Fatalf("expected cached keys to be unaffected by callers, got %+v, %v", again, err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected one unwrap within the TTL, got %d", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := p.Keys(context.Background()); err != nil {
+		t.Fatalf("Keys after TTL: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected the KMS to be asked again after the TTL, got %d calls", got)
+	}
+}
+
+func TestKMSProvider_Errors(t *testing.T) {
+	var calls int32
+	srv := fakeKMS(t, &calls)
+	defer srv.Close()
+
+	p, err := NewKMSProvider(KMSConfig{Endpoint: srv.URL, KeyID: "master-1", Token: "wrong", WrappedKeys: []Key{{ID: "dk1", Secret: []byte("x")}}})
+	if err != nil {
+		t.Fatalf("NewKMSProvider: %v", err)
+	}
+	if _, err := p.Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the KMS status in the error, got %v", err)
+	}
+
+	for name, cfg := range map[string]KMSConfig{
+		"endpoint": {Endpoint: "kms.local", KeyID: "m", WrappedKeys: []Key{{ID: "a", Secret: []byte("x")}}},
+		"key id":   {Endpoint: srv.URL, WrappedKeys: []Key{{ID: "a", Secret: []byte("x")}}},
+		"wrapped":  {Endpoint: srv.URL, KeyID: "m"},
+		"data id":  {Endpoint: srv.URL, KeyID: "m", WrappedKeys: []Key{{ID: "", Secret: []byte("x")}}},
+	} {
+		if _, err := NewKMSProvider(cfg); err == nil {
+			t.Fatalf("%s: expected NewKMSProvider to fail", name)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"maps"
 	"strings"
 	"time"
 
@@ -26,6 +27,10 @@ type Config struct {
 	Random       io.Reader
 	BcryptCost   int
 	SecretCipher secretcrypto.Cipher
+	// Activity, when set, fills in the rolling request counts of List.
+	Activity repository.APIKeyActivityRepository
+	// Metadata, when set, enables Update.
+	Metadata repository.APIKeyMetadataRepository
 }
 
 type Service struct {
@@ -34,11 +39,28 @@ type Service struct {
 	random       io.Reader
 	bcryptCost   int
 	secretCipher secretcrypto.Cipher
+	activity     repository.APIKeyActivityRepository
+	metadata     repository.APIKeyMetadataRepository
 }
 
 type CreateRequest struct {
 	Description string
 	ExpiresAt   *time.Time
+	// ClientCertSubject binds the key to a TLS client certificate subject,
+	// e.g. "CN=batch-worker,O=Example".
+	ClientCertSubject string
+	Owner             string
+	Labels            map[string]string
+}
+
+// UpdateRequest edits a key's metadata. Nil fields are left unchanged;
+// SetLabels are applied after RemoveLabels.
+type UpdateRequest struct {
+	ID           string
+	Description  *string
+	Owner        *string
+	SetLabels    map[string]string
+	RemoveLabels []string
 }
 
 type RotateRequest struct {
@@ -57,6 +79,10 @@ type KeyWithSecret struct {
 	ExpiresAt   *time.Time          `json:"expires_at,omitempty"`
 	RotationOf  *string             `json:"rotation_of,omitempty"`
 	Status      models.APIKeyStatus `json:"status"`
+
+	ClientCertSubject string            `json:"client_cert_subject,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
 }
 
 type KeyView struct {
@@ -69,6 +95,27 @@ type KeyView struct {
 	RevokedAt   *time.Time          `json:"revoked_at,omitempty"`
 	RotationOf  *string             `json:"rotation_of,omitempty"`
 	Status      models.APIKeyStatus `json:"status"`
+
+	ClientCertSubject string            `json:"client_cert_subject,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP   string     `json:"last_used_ip,omitempty"`
+	RequestCount int64      `json:"request_count"`
+	// Requests7d and Requests30d count verified requests on the last 7 and
+	// 30 UTC days, today included. They stay zero without Config.Activity.
+	Requests7d  int64 `json:"requests_7d"`
+	Requests30d int64 `json:"requests_30d"`
+}
+
+// ListFilter narrows List. The zero value lists every key.
+type ListFilter struct {
+	// UnusedSince keeps active keys whose latest verified request, or
+	// creation if they were never used, is before it.
+	UnusedSince time.Time
+	// Selector keeps keys whose labels match it.
+	Selector models.LabelSelector
 }
 
 func NewService(repo repository.APIKeyRepository, cfg Config) *Service {
@@ -84,7 +131,7 @@ func NewService(repo repository.APIKeyRepository, cfg Config) *Service {
 	if cost <= 0 {
 		cost = defaultBcryptCost
 	}
-	return &Service{repo: repo, now: nowFn, random: rnd, bcryptCost: cost, secretCipher: cfg.SecretCipher}
+	return &Service{repo: repo, now: nowFn, random: rnd, bcryptCost: cost, secretCipher: cfg.SecretCipher, activity: cfg.Activity, metadata: cfg.Metadata}
 }
 
 func (s *Service) Create(ctx context.Context, req CreateRequest) (KeyWithSecret, error) {
@@ -93,10 +140,15 @@ func (s *Service) Create(ctx context.Context, req CreateRequest) (KeyWithSecret,
 	if err != nil {
 		return KeyWithSecret{}, err
 	}
-	return s.createKey(ctx, strings.TrimSpace(req.Description), expiresAt, nil)
+	metadata := models.APIKeyMetadata{
+		Description: strings.TrimSpace(req.Description),
+		Owner:       strings.TrimSpace(req.Owner),
+		Labels:      maps.Clone(req.Labels),
+	}
+	return s.createKey(ctx, metadata, expiresAt, nil, strings.TrimSpace(req.ClientCertSubject))
 }
 
-func (s *Service) createKey(ctx context.Context, description string, expiresAt *time.Time, rotationOf *string) (KeyWithSecret, error) {
+func (s *Service) createKey(ctx context.Context, metadata models.APIKeyMetadata, expiresAt *time.Time, rotationOf *string, clientCertSubject string) (KeyWithSecret, error) {
 	now := s.now().UTC()
 	id, err := generateID(s.random)
 	if err != nil {
@@ -127,12 +179,15 @@ func (s *Service) createKey(ctx context.Context, description string, expiresAt *
 		AccessKey:           accessKey,
 		SecretKeyHash:       string(secretHash),
 		SecretKeyCiphertext: secretCiphertext,
-		Description:         description,
+		Description:         metadata.Description,
 		CreatedAt:           now,
 		UpdatedAt:           now,
 		ExpiresAt:           expiresAt,
 		RotationOf:          rotationOf,
+		ClientCertSubject:   clientCertSubject,
 		Status:              models.APIKeyStatusActive,
+		Owner:               metadata.Owner,
+		Labels:              metadata.Labels,
 	}
 	if err := key.Validate(); err != nil {
 		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrValidationFailed, "validate api key", err)
@@ -150,31 +205,134 @@ func (s *Service) createKey(ctx context.Context, description string, expiresAt *
 		ExpiresAt:   key.ExpiresAt,
 		RotationOf:  key.RotationOf,
 		Status:      effectiveStatus(key),
+
+		ClientCertSubject: key.ClientCertSubject,
+		Owner:             key.Owner,
+		Labels:            key.Labels,
 	}, nil
 }
 
-func (s *Service) List(ctx context.Context) ([]KeyView, error) {
+func (s *Service) List(ctx context.Context, filter ListFilter) ([]KeyView, error) {
 	keys, err := s.repo.List(ctx)
 	if err != nil {
 		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list api keys", err)
 	}
+	var last7d, last30d map[string]int64
+	if s.activity != nil {
+		today := s.now().UTC().Truncate(24 * time.Hour)
+		if last7d, err = s.activity.RequestCountsSince(ctx, today.AddDate(0, 0, -6)); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count api key requests", err)
+		}
+		if last30d, err = s.activity.RequestCountsSince(ctx, today.AddDate(0, 0, -29)); err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count api key requests", err)
+		}
+	}
 	out := make([]KeyView, 0, len(keys))
 	for _, key := range keys {
-		out = append(out, KeyView{
-			ID:          key.ID,
-			AccessKey:   key.AccessKey,
-			Description: key.Description,
-			CreatedAt:   key.CreatedAt,
-			UpdatedAt:   key.UpdatedAt,
-			ExpiresAt:   key.ExpiresAt,
-			RevokedAt:   key.RevokedAt,
-			RotationOf:  key.RotationOf,
-			Status:      effectiveStatus(key),
-		})
+		if !filter.UnusedSince.IsZero() && !unusedSince(key, filter.UnusedSince) {
+			continue
+		}
+		if !filter.Selector.Matches(key.Labels) {
+			continue
+		}
+		view := newKeyView(key)
+		view.Requests7d = last7d[key.ID]
+		view.Requests30d = last30d[key.ID]
+		out = append(out, view)
 	}
 	return out, nil
 }
 
+func newKeyView(key models.APIKey) KeyView {
+	return KeyView{
+		ID:          key.ID,
+		AccessKey:   key.AccessKey,
+		Description: key.Description,
+		CreatedAt:   key.CreatedAt,
+		UpdatedAt:   key.UpdatedAt,
+		ExpiresAt:   key.ExpiresAt,
+		RevokedAt:   key.RevokedAt,
+		RotationOf:  key.RotationOf,
+		Status:      effectiveStatus(key),
+
+		ClientCertSubject: key.ClientCertSubject,
+		Owner:             key.Owner,
+		Labels:            key.Labels,
+
+		LastUsedAt:   key.LastUsedAt,
+		LastUsedIP:   key.LastUsedIP,
+		RequestCount: key.RequestCount,
+	}
+}
+
+// unusedSince reports whether key is active and has not been used, or
+// created, at or after cutoff.
+func unusedSince(key models.APIKey, cutoff time.Time) bool {
+	if effectiveStatus(key) != models.APIKeyStatusActive {
+		return false
+	}
+	last := key.CreatedAt
+	if key.LastUsedAt != nil && key.LastUsedAt.After(last) {
+		last = *key.LastUsedAt
+	}
+	return last.Before(cutoff)
+}
+
+// Update edits the description, owner and labels of a key, revoked or not,
+// and returns the key as stored. The rolling request counts of the result
+// are left zero.
+func (s *Service) Update(ctx context.Context, req UpdateRequest) (KeyView, error) {
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" {
+		return KeyView{}, internalerrors.New(internalerrors.ErrValidationFailed, "id is required", nil)
+	}
+	if req.Description == nil && req.Owner == nil && len(req.SetLabels) == 0 && len(req.RemoveLabels) == 0 {
+		return KeyView{}, internalerrors.New(internalerrors.ErrValidationFailed, "nothing to update", nil)
+	}
+	if s.metadata == nil {
+		return KeyView{}, internalerrors.New(internalerrors.ErrInternalError, "api key metadata repository is not configured", nil)
+	}
+
+	key, err := s.repo.GetByID(ctx, req.ID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return KeyView{}, internalerrors.New(internalerrors.ErrValidationFailed, "api key not found", err)
+		}
+		return KeyView{}, internalerrors.New(internalerrors.ErrDatabaseError, "get api key", err)
+	}
+	if req.Description != nil {
+		key.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Owner != nil {
+		key.Owner = strings.TrimSpace(*req.Owner)
+	}
+	labels := maps.Clone(key.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for _, name := range req.RemoveLabels {
+		delete(labels, name)
+	}
+	maps.Copy(labels, req.SetLabels)
+	key.Labels = labels
+	if len(labels) == 0 {
+		key.Labels = nil
+	}
+	if err := key.Validate(); err != nil {
+		return KeyView{}, internalerrors.New(internalerrors.ErrValidationFailed, "validate api key", err)
+	}
+
+	key.UpdatedAt = s.now().UTC()
+	metadata := models.APIKeyMetadata{Description: key.Description, Owner: key.Owner, Labels: key.Labels}
+	if err := s.metadata.UpdateMetadata(ctx, key.ID, metadata, key.UpdatedAt); err != nil {
+		if repository.IsNotFound(err) {
+			return KeyView{}, internalerrors.New(internalerrors.ErrValidationFailed, "api key not found", err)
+		}
+		return KeyView{}, internalerrors.New(internalerrors.ErrDatabaseError, "update api key", err)
+	}
+	return newKeyView(key), nil
+}
+
 func (s *Service) Revoke(ctx context.Context, id string) error {
 	id = strings.TrimSpace(id)
 	if id == "" {
@@ -189,6 +347,45 @@ func (s *Service) Revoke(ctx context.Context, id string) error {
 	return nil
 }
 
+// Credentials returns the decrypted credentials of an active key, for tools
+// that sign on the key's behalf such as presigned URL generation.
+func (s *Service) Credentials(ctx context.Context, id string) (KeyWithSecret, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrValidationFailed, "id is required", nil)
+	}
+	if s.secretCipher == nil {
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrInternalError, "secret cipher is not configured", nil)
+	}
+	key, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return KeyWithSecret{}, internalerrors.New(internalerrors.ErrValidationFailed, "api key not found", err)
+		}
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrDatabaseError, "get api key", err)
+	}
+	if key.IsRevoked() {
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil)
+	}
+	if key.IsExpired() {
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
+	}
+	secret, err := s.secretCipher.Decrypt(key.SecretKeyCiphertext)
+	if err != nil {
+		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrInternalError, "decrypt api key secret", err)
+	}
+	return KeyWithSecret{
+		ID:          key.ID,
+		AccessKey:   key.AccessKey,
+		SecretKey:   secret,
+		Description: key.Description,
+		CreatedAt:   key.CreatedAt,
+		ExpiresAt:   key.ExpiresAt,
+		RotationOf:  key.RotationOf,
+		Status:      models.APIKeyStatusActive,
+	}, nil
+}
+
 func (s *Service) Rotate(ctx context.Context, req RotateRequest) (KeyWithSecret, error) {
 	req.ID = strings.TrimSpace(req.ID)
 	if req.ID == "" {
@@ -212,9 +409,9 @@ func (s *Service) Rotate(ctx context.Context, req RotateRequest) (KeyWithSecret,
 		return KeyWithSecret{}, internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
 	}
 
-	description := oldKey.Description
+	metadata := models.APIKeyMetadata{Description: oldKey.Description, Owner: oldKey.Owner, Labels: oldKey.Labels}
 	if req.Description != nil {
-		description = strings.TrimSpace(*req.Description)
+		metadata.Description = strings.TrimSpace(*req.Description)
 	}
 	now := s.now().UTC()
 	expiresAt, err := validateExpiresAt(req.ExpiresAt, now)
@@ -222,7 +419,9 @@ func (s *Service) Rotate(ctx context.Context, req RotateRequest) (KeyWithSecret,
 		return KeyWithSecret{}, err
 	}
 
-	created, err := s.createKey(ctx, description, expiresAt, &oldKey.ID)
+	// The replacement keeps the owner and labels and stays bound to the same
+	// client certificate.
+	created, err := s.createKey(ctx, metadata, expiresAt, &oldKey.ID, oldKey.ClientCertSubject)
 	if err != nil {
 		return KeyWithSecret{}, err
 	}
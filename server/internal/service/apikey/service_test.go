@@ -92,6 +92,19 @@ func (m *memoryRepo) SetExpiresAt(_ context.Context, id string, expiresAt time.T
 	return nil
 }
 
+func (m *memoryRepo) UpdateMetadata(_ context.Context, id string, metadata models.APIKeyMetadata, updatedAt time.Time) error {
+	key, ok := m.keys[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	key.Description = metadata.Description
+	key.Owner = metadata.Owner
+	key.Labels = metadata.Labels
+	key.UpdatedAt = updatedAt
+	m.keys[id] = key
+	return nil
+}
+
 func TestServiceLifecycle_CreateListRevokeRotate(t *testing.T) {
 	ctx := context.Background()
 	repo := newMemoryRepo()
@@ -125,7 +138,7 @@ func TestServiceLifecycle_CreateListRevokeRotate(t *testing.T) {
 		t.Fatalf("expected bcrypt hash, got %q", stored.SecretKeyHash)
 	}
 
-	list, err := svc.List(ctx)
+	list, err := svc.List(ctx, ListFilter{})
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
@@ -190,6 +203,104 @@ func TestCreate_WithPastExpirationFails(t *testing.T) {
 	}
 }
 
+func TestCredentials_ReturnsSecretOfActiveKeyOnly(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepo()
+	svc := NewService(repo, Config{BcryptCost: 4, SecretCipher: mustTestCipher(t)})
+
+	created, err := svc.Create(ctx, CreateRequest{Description: "presign"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	creds, err := svc.Credentials(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("credentials: %v", err)
+	}
+	if creds.AccessKey != created.AccessKey || creds.SecretKey != created.SecretKey {
+		t.Fatalf("credentials do not match created key")
+	}
+
+	if err := svc.Revoke(ctx, created.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := svc.Credentials(ctx, created.ID); err == nil {
+		t.Fatalf("expected revoked key to be refused")
+	}
+	if _, err := svc.Credentials(ctx, "key_missing"); err == nil {
+		t.Fatalf("expected missing key to be refused")
+	}
+}
+
+// fakeActivity answers RequestCountsSince from per-day counts.
+type fakeActivity struct {
+	daily map[string]map[time.Time]int64
+}
+
+func (f *fakeActivity) RecordActivity(context.Context, []models.APIKeyActivity) error {
+	return nil
+}
+
+func (f *fakeActivity) RequestCountsSince(_ context.Context, since time.Time) (map[string]int64, error) {
+	out := map[string]int64{}
+	for id, days := range f.daily {
+		for day, n := range days {
+			if !day.Before(since) {
+				out[id] += n
+			}
+		}
+	}
+	return out, nil
+}
+
+func TestList_UsageAndUnusedSince(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 31, 15, 0, 0, 0, time.UTC)
+	today := now.Truncate(24 * time.Hour)
+	repo := newMemoryRepo()
+	used := func(at time.Time) *time.Time { return &at }
+	for _, key := range []models.APIKey{
+		{ID: "key_recent", AccessKey: "ak_1", CreatedAt: now.AddDate(0, -6, 0), LastUsedAt: used(now.Add(-time.Hour)), LastUsedIP: "10.0.0.1", RequestCount: 42, Status: models.APIKeyStatusActive},
+		{ID: "key_dormant", AccessKey: "ak_2", CreatedAt: now.AddDate(0, -6, 0), LastUsedAt: used(now.AddDate(0, 0, -45)), RequestCount: 7, Status: models.APIKeyStatusActive},
+		{ID: "key_never", AccessKey: "ak_3", CreatedAt: now.AddDate(0, -2, 0), Status: models.APIKeyStatusActive},
+		{ID: "key_new", AccessKey: "ak_4", CreatedAt: now.AddDate(0, 0, -3), Status: models.APIKeyStatusActive},
+		{ID: "key_revoked", AccessKey: "ak_5", CreatedAt: now.AddDate(0, -6, 0), Status: models.APIKeyStatusRevoked},
+	} {
+		repo.keys[key.ID] = key
+	}
+	activity := &fakeActivity{daily: map[string]map[time.Time]int64{
+		"key_recent": {today: 5, today.AddDate(0, 0, -6): 3, today.AddDate(0, 0, -7): 11, today.AddDate(0, 0, -29): 2, today.AddDate(0, 0, -30): 100},
+	}}
+	svc := NewService(repo, Config{Now: func() time.Time { return now }, SecretCipher: mustTestCipher(t), Activity: activity})
+
+	all, err := svc.List(ctx, ListFilter{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("expected every key, got %d", len(all))
+	}
+	for _, v := range all {
+		if v.ID != "key_recent" {
+			continue
+		}
+		if v.RequestCount != 42 || v.LastUsedIP != "10.0.0.1" || v.LastUsedAt == nil || v.Requests7d != 8 || v.Requests30d != 21 {
+			t.Fatalf("unexpected usage for key_recent: %+v", v)
+		}
+	}
+
+	unused, err := svc.List(ctx, ListFilter{UnusedSince: now.AddDate(0, 0, -30)})
+	if err != nil {
+		t.Fatalf("List unused: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, v := range unused {
+		ids[v.ID] = true
+	}
+	if len(ids) != 2 || !ids["key_dormant"] || !ids["key_never"] {
+		t.Fatalf("expected the dormant and never used keys, got %v", ids)
+	}
+}
+
 func mustTestCipher(t *testing.T) secretcrypto.Cipher {
 	t.Helper()
 	c, err := secretcrypto.NewAESCipher([]byte("0123456789abcdef0123456789abcdef"))
@@ -198,3 +309,76 @@ func mustTestCipher(t *testing.T) secretcrypto.Cipher {
 	}
 	return c
 }
+
+func TestUpdate_MetadataAndSelector(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepo()
+	base := time.Date(2026, 2, 24, 9, 30, 0, 0, time.UTC)
+	now := base
+	svc := NewService(repo, Config{
+		Now:          func() time.Time { return now },
+		BcryptCost:   4,
+		SecretCipher: mustTestCipher(t),
+		Metadata:     repo,
+	})
+
+	growth, err := svc.Create(ctx, CreateRequest{Description: "batch", Owner: "alice", Labels: map[string]string{models.LabelTeam: "growth", "tier": "gold"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if growth.Owner != "alice" || growth.Labels[models.LabelTeam] != "growth" {
+		t.Fatalf("unexpected created key: %+v", growth)
+	}
+	if _, err := svc.Create(ctx, CreateRequest{Labels: map[string]string{models.LabelTeam: "search"}}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := svc.Create(ctx, CreateRequest{Labels: map[string]string{"Team": "x"}}); err == nil {
+		t.Fatalf("expected an invalid label name to be rejected")
+	}
+
+	now = base.Add(time.Hour)
+	owner := "bob"
+	updated, err := svc.Update(ctx, UpdateRequest{
+		ID:           growth.ID,
+		Owner:        &owner,
+		SetLabels:    map[string]string{models.LabelEnvironment: "prod"},
+		RemoveLabels: []string{"tier"},
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.Owner != "bob" || updated.Description != "batch" || len(updated.Labels) != 2 || updated.Labels[models.LabelEnvironment] != "prod" || !updated.UpdatedAt.Equal(now) {
+		t.Fatalf("unexpected updated key: %+v", updated)
+	}
+
+	sel, err := models.ParseLabelSelector("team=growth,environment=prod")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector: %v", err)
+	}
+	list, err := svc.List(ctx, ListFilter{Selector: sel})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != growth.ID || list[0].Owner != "bob" {
+		t.Fatalf("expected only the growth key, got %+v", list)
+	}
+
+	// The replacement of a rotated key keeps its owner and labels.
+	rotated, err := svc.Rotate(ctx, RotateRequest{ID: growth.ID})
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated.Owner != "bob" || rotated.Labels[models.LabelEnvironment] != "prod" {
+		t.Fatalf("expected metadata to carry over, got %+v", rotated)
+	}
+
+	if _, err := svc.Update(ctx, UpdateRequest{ID: growth.ID}); err == nil {
+		t.Fatalf("expected an empty update to fail")
+	}
+	if _, err := svc.Update(ctx, UpdateRequest{ID: "key_missing", Owner: &owner}); err == nil {
+		t.Fatalf("expected an unknown key to fail")
+	}
+	if _, err := svc.Update(ctx, UpdateRequest{ID: growth.ID, SetLabels: map[string]string{"team": "has space"}}); err == nil {
+		t.Fatalf("expected an invalid label value to be rejected")
+	}
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jimeng-relay/server/internal/models"
+)
+
+// BodyMode controls how much of the upstream request and response bodies is
+// kept on an upstream attempt.
+type BodyMode string
+
+const (
+	BodyModeNone     BodyMode = "none"
+	BodyModeMetadata BodyMode = "metadata"
+	BodyModeFull     BodyMode = "full"
+)
+
+// DefaultBodyMaxBytes caps a fully recorded body after redaction.
+const DefaultBodyMaxBytes = 16 << 10
+
+// metadataFields are the top-level fields kept in metadata mode. Requests
+// contribute req_key; responses contribute the rest.
+var metadataFields = []string{"code", "status", "message", "request_id", "req_key", "task_id"}
+
+// recordBody reduces a raw upstream body according to the mode configured for
+// action. Non-JSON bodies are kept as a truncated string in full mode only.
+func (s *Service) recordBody(action models.DownstreamAction, raw []byte) any {
+	mode := s.bodyModes[action]
+	if len(raw) == 0 || mode == "" || mode == BodyModeNone {
+		return nil
+	}
+
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		if mode != BodyModeFull {
+			return nil
+		}
+		return truncatedBody(string(raw), len(raw), s.bodyMaxBytes)
+	}
+	obj, isObject := decoded.(map[string]any)
+
+	if mode == BodyModeMetadata {
+		if !isObject {
+			return nil
+		}
+		return bodyMetadata(obj)
+	}
+
+	redacted := redactBinary(decoded)
+	out, err := json.Marshal(redacted)
+	if err != nil {
+		return nil
+	}
+	if len(out) > s.bodyMaxBytes {
+		return truncatedBody(string(out), len(out), s.bodyMaxBytes)
+	}
+	return redacted
+}
+
+func bodyMetadata(obj map[string]any) map[string]any {
+	out := map[string]any{}
+	for _, k := range metadataFields {
+		if v, ok := obj[k]; ok {
+			out[k] = v
+		}
+	}
+	if data, ok := obj["data"].(map[string]any); ok {
+		if v, ok := data["task_id"]; ok {
+			out["task_id"] = v
+		}
+		if v, ok := data["status"]; ok {
+			out["task_status"] = v
+		}
+	}
+	// Gateway errors (e.g. 50400 entitlement failures) use the OpenAPI
+	// ResponseMetadata envelope instead of code/message.
+	if meta, ok := obj["ResponseMetadata"].(map[string]any); ok {
+		if v, ok := meta["RequestId"]; ok {
+			out["request_id"] = v
+		}
+		if e, ok := meta["Error"].(map[string]any); ok {
+			if v, ok := e["Code"]; ok {
+				out["error_code"] = v
+			}
+			if v, ok := e["Message"]; ok {
+				out["error_message"] = v
+			}
+		}
+	}
+	return out
+}
+
+// redactBinary replaces inline base64 payloads, in binary_data_base64 or as
+// data: URLs such as image_urls entries, with their lengths; result URLs and
+// everything else are kept.
+func redactBinary(v any) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(vv))
+		for k, val := range vv {
+			if k == "binary_data_base64" {
+				out[k] = redactedBinary(val)
+				continue
+			}
+			out[k] = redactBinary(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(vv))
+		for i := range vv {
+			out[i] = redactBinary(vv[i])
+		}
+		return out
+	case string:
+		return redactDataURL(vv)
+	default:
+		return v
+	}
+}
+
+// redactDataURL keeps the media type of a base64 data: URL and replaces its
+// payload with the payload's length. Other strings are returned unchanged.
+func redactDataURL(s string) string {
+	if len(s) < len("data:") || !strings.EqualFold(s[:len("data:")], "data:") {
+		return s
+	}
+	i := strings.Index(s, ",")
+	if i < 0 || !strings.HasSuffix(strings.ToLower(s[:i]), ";base64") {
+		return s
+	}
+	return fmt.Sprintf("%s,[redacted %d bytes]", s[:i], len(s)-i-1)
+}
+
+func redactedBinary(v any) any {
+	switch vv := v.(type) {
+	case []any:
+		out := make([]any, len(vv))
+		for i := range vv {
+			out[i] = redactedBinary(vv[i])
+		}
+		return out
+	case string:
+		return fmt.Sprintf("[redacted %d bytes]", len(vv))
+	case nil:
+		return nil
+	default:
+		return "[redacted]"
+	}
+}
+
+func truncatedBody(body string, size, maxBytes int) map[string]any {
+	if len(body) > maxBytes {
+		body = body[:maxBytes]
+		for len(body) > 0 && !utf8.ValidString(body) {
+			body = body[:len(body)-1]
+		}
+	}
+	return map[string]any{
+		"truncated": size > maxBytes,
+		"size":      size,
+		"body":      body,
+	}
+}
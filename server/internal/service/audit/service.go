@@ -11,11 +11,21 @@ import (
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
 	"github.com/jimeng-relay/server/internal/models"
 	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/service/auditsink"
 )
 
 type Config struct {
 	Now    func() time.Time
 	Random io.Reader
+	// BodyModes selects how upstream bodies are recorded per action; actions
+	// without an entry record none.
+	BodyModes map[models.DownstreamAction]BodyMode
+	// BodyMaxBytes caps fully recorded bodies. Defaults to DefaultBodyMaxBytes.
+	BodyMaxBytes int
+	// Sinks receive a copy of every record once it is stored. The database
+	// write comes first and stays authoritative; a sink error fails the call
+	// only when that sink is fail-closed.
+	Sinks []auditsink.Enqueuer
 }
 
 type Service struct {
@@ -23,8 +33,11 @@ type Service struct {
 	upstreamRepo   repository.UpstreamAttemptRepository
 	auditRepo      repository.AuditEventRepository
 
-	now    func() time.Time
-	random io.Reader
+	now          func() time.Time
+	random       io.Reader
+	bodyModes    map[models.DownstreamAction]BodyMode
+	bodyMaxBytes int
+	sinks        []auditsink.Enqueuer
 }
 
 func NewService(
@@ -41,7 +54,20 @@ func NewService(
 	if rnd == nil {
 		rnd = rand.Reader
 	}
-	return &Service{downstreamRepo: downstreamRepo, upstreamRepo: upstreamRepo, auditRepo: auditRepo, now: nowFn, random: rnd}
+	maxBytes := cfg.BodyMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultBodyMaxBytes
+	}
+	return &Service{
+		downstreamRepo: downstreamRepo,
+		upstreamRepo:   upstreamRepo,
+		auditRepo:      auditRepo,
+		now:            nowFn,
+		random:         rnd,
+		bodyModes:      cfg.BodyModes,
+		bodyMaxBytes:   maxBytes,
+		sinks:          cfg.Sinks,
+	}
 }
 
 type RelayCall struct {
@@ -72,6 +98,12 @@ type UpstreamAttempt struct {
 	ResponseHeaders map[string]any
 	ResponseBody    any
 
+	// RawRequestBody and RawResponseBody are the bytes exchanged with the
+	// upstream. When RequestBody or ResponseBody is nil they are recorded
+	// according to the body mode configured for the call's action.
+	RawRequestBody  []byte
+	RawResponseBody []byte
+
 	LatencyMs int64
 	Error     *string
 }
@@ -140,7 +172,7 @@ func (s *Service) RecordRelayDownstream(ctx context.Context, call RelayCall) err
 		return internalerrors.New(internalerrors.ErrAuditFailed, "create downstream request", err)
 	}
 
-	return nil
+	return s.forward(ctx, auditsink.KindDownstreamRequest, ds.RequestID, ds.ReceivedAt, ds)
 }
 
 func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCall) error {
@@ -171,6 +203,17 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 
 	now := s.now().UTC()
 
+	reqBody := call.Upstream.RequestBody
+	if reqBody == nil {
+		if m, ok := s.recordBody(call.Action, call.Upstream.RawRequestBody).(map[string]any); ok {
+			reqBody = m
+		}
+	}
+	respBody := call.Upstream.ResponseBody
+	if respBody == nil {
+		respBody = s.recordBody(call.Action, call.Upstream.RawResponseBody)
+	}
+
 	usID, err := generateID(s.random, "uattempt_")
 	if err != nil {
 		return internalerrors.New(internalerrors.ErrInternalError, "generate upstream attempt id", err)
@@ -181,10 +224,10 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 		AttemptNumber:   call.Upstream.AttemptNumber,
 		UpstreamAction:  call.Upstream.UpstreamAction,
 		RequestHeaders:  sanitizeMap(call.Upstream.RequestHeaders),
-		RequestBody:     call.Upstream.RequestBody,
+		RequestBody:     reqBody,
 		ResponseStatus:  call.Upstream.ResponseStatus,
 		ResponseHeaders: sanitizeMap(call.Upstream.ResponseHeaders),
-		ResponseBody:    call.Upstream.ResponseBody,
+		ResponseBody:    respBody,
 		LatencyMs:       call.Upstream.LatencyMs,
 		Error:           call.Upstream.Error,
 		SentAt:          now,
@@ -195,6 +238,9 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 	if err := s.upstreamRepo.Create(ctx, ua); err != nil {
 		return internalerrors.New(internalerrors.ErrAuditFailed, "create upstream attempt", err)
 	}
+	if err := s.forward(ctx, auditsink.KindUpstreamAttempt, ua.RequestID, ua.SentAt, ua); err != nil {
+		return err
+	}
 
 	events := call.Events
 	if len(events) == 0 {
@@ -210,6 +256,23 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 		}
 	}
 
+	return s.createEvents(ctx, call.RequestID, now, events)
+}
+
+// RecordEvents appends events to an already recorded downstream request, for
+// decisions made between the downstream write and the upstream call.
+func (s *Service) RecordEvents(ctx context.Context, requestID string, events ...Event) error {
+	if s.auditRepo == nil {
+		return internalerrors.New(internalerrors.ErrInternalError, "audit repositories are required", nil)
+	}
+	requestID = strings.TrimSpace(requestID)
+	if requestID == "" {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "request_id is required", nil)
+	}
+	return s.createEvents(ctx, requestID, s.now().UTC(), events)
+}
+
+func (s *Service) createEvents(ctx context.Context, requestID string, now time.Time, events []Event) error {
 	for _, ev := range events {
 		id, err := generateID(s.random, "aevt_")
 		if err != nil {
@@ -221,7 +284,7 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 		}
 		e := models.AuditEvent{
 			ID:        id,
-			RequestID: call.RequestID,
+			RequestID: requestID,
 			EventType: ev.Type,
 			Actor:     actor,
 			Action:    strings.TrimSpace(ev.Action),
@@ -232,14 +295,34 @@ func (s *Service) RecordRelayUpstreamAndEvents(ctx context.Context, call RelayCa
 		if err := e.Validate(); err != nil {
 			return internalerrors.New(internalerrors.ErrValidationFailed, "validate audit event", err)
 		}
-		if err := s.auditRepo.Create(ctx, e); err != nil {
+		if err := s.auditRepo.Create(ctx, &e); err != nil {
 			return internalerrors.New(internalerrors.ErrAuditFailed, "create audit event", err)
 		}
+		if err := s.forward(ctx, auditsink.KindAuditEvent, e.RequestID, e.CreatedAt, e); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// forward hands a stored record to every configured sink.
+func (s *Service) forward(ctx context.Context, kind, requestID string, at time.Time, record any) error {
+	if len(s.sinks) == 0 {
+		return nil
+	}
+	entry, err := auditsink.NewEntry(kind, requestID, at, record)
+	if err != nil {
+		return internalerrors.New(internalerrors.ErrInternalError, "encode audit sink entry", err)
+	}
+	for _, sink := range s.sinks {
+		if err := sink.Enqueue(ctx, entry); err != nil {
+			return internalerrors.New(internalerrors.ErrAuditFailed, "forward audit record", err)
+		}
+	}
+	return nil
+}
+
 func normalizeRelayCall(call *RelayCall) {
 	call.RequestID = strings.TrimSpace(call.RequestID)
 	call.APIKeyID = strings.TrimSpace(call.APIKeyID)
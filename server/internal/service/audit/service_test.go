@@ -3,12 +3,15 @@ package audit
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 	"time"
 
 	internalerrors "github.com/jimeng-relay/server/internal/errors"
 	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/service/auditsink"
 )
 
 type fakeDownstreamRepo struct {
@@ -34,6 +37,10 @@ func (f *fakeDownstreamRepo) GetByRequestID(_ context.Context, _ string) (models
 	return models.DownstreamRequest{}, errors.New("not implemented")
 }
 
+func (f *fakeDownstreamRepo) List(_ context.Context, _ repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	return nil, errors.New("not implemented")
+}
+
 type fakeUpstreamRepo struct {
 	called  int
 	created []models.UpstreamAttempt
@@ -59,12 +66,19 @@ type fakeAuditRepo struct {
 	err     error
 }
 
-func (f *fakeAuditRepo) Create(_ context.Context, event models.AuditEvent) error {
+func (f *fakeAuditRepo) Create(_ context.Context, event *models.AuditEvent) error {
 	f.called++
 	if f.err != nil {
 		return f.err
 	}
-	f.created = append(f.created, event)
+	prevHash := ""
+	if n := len(f.created); n > 0 {
+		prevHash = f.created[n-1].Hash
+	}
+	if err := event.Chain(int64(len(f.created)), prevHash); err != nil {
+		return err
+	}
+	f.created = append(f.created, *event)
 	return nil
 }
 
@@ -76,6 +90,23 @@ func (f *fakeAuditRepo) ListByTimeRange(_ context.Context, _, _ time.Time) ([]mo
 	return nil, errors.New("not implemented")
 }
 
+func (f *fakeAuditRepo) Query(_ context.Context, _ repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	return nil, errors.New("not implemented")
+}
+
+type fakeSink struct {
+	entries []auditsink.Entry
+	err     error
+}
+
+func (f *fakeSink) Enqueue(_ context.Context, e auditsink.Entry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.entries = append(f.entries, e)
+	return nil
+}
+
 func TestService_RecordRelayCall_Success_WritesChainAndRedacts(t *testing.T) {
 	ctx := context.Background()
 	base := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
@@ -267,3 +298,191 @@ func TestService_RecordRelayCall_FailClosed_OnAuditWriteFailure(t *testing.T) {
 		t.Fatalf("expected error code %s, got %s", internalerrors.ErrAuditFailed, internalerrors.GetCode(err))
 	}
 }
+
+func TestService_RecordRelayCall_ForwardsStoredRecordsToSinks(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	call := RelayCall{
+		RequestID:         "req-1",
+		APIKeyID:          "k1",
+		Action:            models.DownstreamActionCVSync2AsyncSubmitTask,
+		Method:            "POST",
+		Path:              "/",
+		DownstreamHeaders: map[string]any{"Authorization": "secret"},
+		Upstream:          UpstreamAttempt{AttemptNumber: 1, UpstreamAction: "x", ResponseStatus: 200},
+	}
+
+	ds := &fakeDownstreamRepo{}
+	sink := &fakeSink{}
+	svc := NewService(ds, &fakeUpstreamRepo{}, &fakeAuditRepo{}, Config{Now: func() time.Time { return base }, Random: bytes.NewReader(bytes.Repeat([]byte{0x01}, 24)), Sinks: []auditsink.Enqueuer{sink}})
+	if err := svc.RecordRelayCall(ctx, call); err != nil {
+		t.Fatalf("RecordRelayCall: %v", err)
+	}
+	kinds := make([]string, 0, len(sink.entries))
+	for _, e := range sink.entries {
+		kinds = append(kinds, e.Kind)
+		if e.RequestID != "req-1" || !e.Time.Equal(base) {
+			t.Fatalf("unexpected entry: %+v", e)
+		}
+	}
+	if len(kinds) != 3 || kinds[0] != auditsink.KindDownstreamRequest || kinds[1] != auditsink.KindUpstreamAttempt || kinds[2] != auditsink.KindAuditEvent {
+		t.Fatalf("unexpected entry kinds: %v", kinds)
+	}
+	if bytes.Contains(sink.entries[0].Record, []byte("secret")) {
+		t.Fatalf("expected forwarded record to be redacted: %s", sink.entries[0].Record)
+	}
+	var forwarded models.AuditEvent
+	if err := json.Unmarshal(sink.entries[2].Record, &forwarded); err != nil {
+		t.Fatalf("decode forwarded audit event: %v", err)
+	}
+	if forwarded.Sequence != 1 || forwarded.Hash == "" {
+		t.Fatalf("expected the forwarded audit event to carry its chain position, got %+v", forwarded)
+	}
+
+	// A fail-closed sink error fails the call after the database write.
+	ds = &fakeDownstreamRepo{}
+	svc = NewService(ds, &fakeUpstreamRepo{}, &fakeAuditRepo{}, Config{Now: func() time.Time { return base }, Random: bytes.NewReader(bytes.Repeat([]byte{0x01}, 24)), Sinks: []auditsink.Enqueuer{&fakeSink{err: errors.New("queue full")}}})
+	err := svc.RecordRelayCall(ctx, call)
+	if internalerrors.GetCode(err) != internalerrors.ErrAuditFailed {
+		t.Fatalf("expected error code %s, got %v", internalerrors.ErrAuditFailed, err)
+	}
+	if len(ds.created) != 1 {
+		t.Fatalf("expected the database write to happen before the sink, got %d", len(ds.created))
+	}
+}
+
+func TestService_RecordEvents_WritesOnlyEvents(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+
+	ds := &fakeDownstreamRepo{}
+	us := &fakeUpstreamRepo{}
+	ar := &fakeAuditRepo{}
+	rnd := bytes.NewReader(bytes.Repeat([]byte{0x02}, 8))
+	svc := NewService(ds, us, ar, Config{Now: func() time.Time { return base }, Random: rnd})
+
+	err := svc.RecordEvents(ctx, " req-1 ", Event{
+		Type:     models.EventTypePolicyDenied,
+		Action:   "policy_check",
+		Resource: "relay.submit",
+		Metadata: map[string]any{"rule_id": "banned-terms"},
+	})
+	if err != nil {
+		t.Fatalf("RecordEvents: %v", err)
+	}
+	if ds.called != 0 || us.called != 0 || len(ar.created) != 1 {
+		t.Fatalf("expected a single audit event write, got ds=%d us=%d events=%d", ds.called, us.called, len(ar.created))
+	}
+	ev := ar.created[0]
+	if ev.RequestID != "req-1" || ev.EventType != models.EventTypePolicyDenied || ev.Actor != "system" || !ev.CreatedAt.Equal(base) {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if err := svc.RecordEvents(ctx, "", Event{Type: models.EventTypePolicyAllowed}); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected validation error for missing request_id, got %v", err)
+	}
+}
+
+func TestService_RecordRelayUpstream_BodyModes(t *testing.T) {
+	ctx := context.Background()
+	reqBody := []byte(`{"req_key":"jimeng_t2i_v40","prompt":"x","binary_data_base64":["aGVsbG8="]}`)
+	respBody := []byte(`{"code":10000,"status":10000,"message":"Success","request_id":"up-1","data":{"task_id":"t-1","status":"done","binary_data_base64":["aGVsbG8=","d29ybGQ="],"image_urls":["https://example.com/a.png"]}}`)
+	entitlement := []byte(`{"ResponseMetadata":{"RequestId":"up-2","Action":"CVSync2AsyncSubmitTask","Error":{"Code":"50400","Message":"Access Denied"}}}`)
+
+	record := func(t *testing.T, cfg Config, raw []byte) models.UpstreamAttempt {
+		t.Helper()
+		us := &fakeUpstreamRepo{}
+		cfg.Random = bytes.NewReader(bytes.Repeat([]byte{0x03}, 16))
+		svc := NewService(&fakeDownstreamRepo{}, us, &fakeAuditRepo{}, cfg)
+		err := svc.RecordRelayUpstreamAndEvents(ctx, RelayCall{
+			RequestID: "req-1",
+			APIKeyID:  "k1",
+			Action:    models.DownstreamActionCVSync2AsyncSubmitTask,
+			Method:    "POST",
+			Path:      "/v1/submit",
+			Upstream: UpstreamAttempt{
+				AttemptNumber:   1,
+				UpstreamAction:  "CVSync2AsyncSubmitTask",
+				RawRequestBody:  reqBody,
+				RawResponseBody: raw,
+				ResponseStatus:  200,
+			},
+		})
+		if err != nil {
+			t.Fatalf("RecordRelayUpstreamAndEvents: %v", err)
+		}
+		return us.created[0]
+	}
+	submitMode := func(mode BodyMode) map[models.DownstreamAction]BodyMode {
+		return map[models.DownstreamAction]BodyMode{models.DownstreamActionCVSync2AsyncSubmitTask: mode}
+	}
+
+	t.Run("NoneByDefault", func(t *testing.T) {
+		ua := record(t, Config{}, respBody)
+		if ua.RequestBody != nil || ua.ResponseBody != nil {
+			t.Fatalf("expected no bodies, got req=%v resp=%v", ua.RequestBody, ua.ResponseBody)
+		}
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		ua := record(t, Config{BodyModes: submitMode(BodyModeMetadata)}, respBody)
+		if ua.RequestBody["req_key"] != "jimeng_t2i_v40" || ua.RequestBody["prompt"] != nil {
+			t.Fatalf("unexpected request metadata: %v", ua.RequestBody)
+		}
+		got, _ := ua.ResponseBody.(map[string]any)
+		if got["code"] != float64(10000) || got["message"] != "Success" || got["request_id"] != "up-1" || got["task_id"] != "t-1" || got["task_status"] != "done" {
+			t.Fatalf("unexpected response metadata: %v", got)
+		}
+		if _, ok := got["data"]; ok {
+			t.Fatalf("expected data to be dropped in metadata mode: %v", got)
+		}
+
+		ua = record(t, Config{BodyModes: submitMode(BodyModeMetadata)}, entitlement)
+		got, _ = ua.ResponseBody.(map[string]any)
+		if got["error_code"] != "50400" || got["error_message"] != "Access Denied" || got["request_id"] != "up-2" {
+			t.Fatalf("unexpected gateway error metadata: %v", got)
+		}
+	})
+
+	t.Run("FullRedactsBinary", func(t *testing.T) {
+		ua := record(t, Config{BodyModes: submitMode(BodyModeFull)}, respBody)
+		got, _ := ua.ResponseBody.(map[string]any)
+		data, _ := got["data"].(map[string]any)
+		bins, _ := data["binary_data_base64"].([]any)
+		if len(bins) != 2 || bins[0] != "[redacted 8 bytes]" {
+			t.Fatalf("expected binary data to be redacted, got %v", data["binary_data_base64"])
+		}
+		urls, _ := data["image_urls"].([]any)
+		if len(urls) != 1 || urls[0] != "https://example.com/a.png" {
+			t.Fatalf("expected result urls to be kept, got %v", data["image_urls"])
+		}
+		if ua.RequestBody["prompt"] != "x" {
+			t.Fatalf("expected full request body, got %v", ua.RequestBody)
+		}
+	})
+
+	t.Run("FullRedactsDataURLs", func(t *testing.T) {
+		raw := []byte(`{"req_key":"jimeng_i2i_v30","image_urls":["data:image/png;base64,aGVsbG8=","https://example.com/in.png","data:text/plain,hello"]}`)
+		ua := record(t, Config{BodyModes: submitMode(BodyModeFull)}, raw)
+		got, _ := ua.ResponseBody.(map[string]any)
+		urls, _ := got["image_urls"].([]any)
+		if len(urls) != 3 || urls[0] != "data:image/png;base64,[redacted 8 bytes]" || urls[1] != "https://example.com/in.png" || urls[2] != "data:text/plain,hello" {
+			t.Fatalf("expected only the base64 data URL to be redacted, got %v", got["image_urls"])
+		}
+	})
+
+	t.Run("FullTruncates", func(t *testing.T) {
+		ua := record(t, Config{BodyModes: submitMode(BodyModeFull), BodyMaxBytes: 32}, respBody)
+		got, _ := ua.ResponseBody.(map[string]any)
+		body, _ := got["body"].(string)
+		if got["truncated"] != true || len(body) != 32 {
+			t.Fatalf("expected truncated body, got %v", got)
+		}
+
+		ua = record(t, Config{BodyModes: submitMode(BodyModeFull), BodyMaxBytes: 32}, []byte("upstream gateway timeout"))
+		got, _ = ua.ResponseBody.(map[string]any)
+		if got["truncated"] != false || got["body"] != "upstream gateway timeout" {
+			t.Fatalf("expected non-JSON body to be kept as text, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+// Package auditchain signs checkpoints of the hash-chained audit log and
+// verifies the chain against them.
+package auditchain
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const (
+	DefaultCheckpointInterval = time.Hour
+	// MinKeyBytes is the shortest accepted checkpoint HMAC key.
+	MinKeyBytes    = 32
+	verifyPageSize = 1000
+)
+
+// Problem kinds reported by Verify.
+const (
+	ProblemGap                 = "gap"
+	ProblemModified            = "modified"
+	ProblemBrokenLink          = "broken_link"
+	ProblemCheckpointSignature = "checkpoint_signature"
+	ProblemCheckpointMismatch  = "checkpoint_mismatch"
+	ProblemCheckpointMissing   = "checkpoint_missing_event"
+)
+
+type Config struct {
+	// Key signs and verifies checkpoints. Without it no checkpoints are
+	// written and Verify skips signature checks.
+	Key []byte
+	// Interval is how often Run writes a checkpoint. Defaults to
+	// DefaultCheckpointInterval.
+	Interval time.Duration
+	Logger   *slog.Logger
+	Now      func() time.Time
+	Random   io.Reader
+}
+
+type Service struct {
+	repo     repository.AuditChainRepository
+	key      []byte
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+	random   io.Reader
+}
+
+func NewService(repo repository.AuditChainRepository, cfg Config) (*Service, error) {
+	if repo == nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "audit chain repository is required", nil)
+	}
+	if len(cfg.Key) > 0 && len(cfg.Key) < MinKeyBytes {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("audit checkpoint key must be at least %d bytes", MinKeyBytes), nil)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	nowFn := cfg.Now
+	if nowFn == nil {
+		nowFn = func() time.Time { return time.Now().UTC() }
+	}
+	rnd := cfg.Random
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	return &Service{repo: repo, key: cfg.Key, interval: interval, logger: logger, now: nowFn, random: rnd}, nil
+}
+
+// Checkpoint signs the current chain head. It returns false when the head is
+// already covered by the latest checkpoint or nothing has been chained yet.
+func (s *Service) Checkpoint(ctx context.Context) (models.AuditCheckpoint, bool, error) {
+	if len(s.key) == 0 {
+		return models.AuditCheckpoint{}, false, internalerrors.New(internalerrors.ErrInternalError, "audit checkpoint key is not configured", nil)
+	}
+	head, err := s.repo.ChainHead(ctx)
+	if repository.IsNotFound(err) {
+		return models.AuditCheckpoint{}, false, nil
+	}
+	if err != nil {
+		return models.AuditCheckpoint{}, false, internalerrors.New(internalerrors.ErrDatabaseError, "get audit chain head", err)
+	}
+	latest, err := s.repo.LatestCheckpoint(ctx)
+	if err != nil && !repository.IsNotFound(err) {
+		return models.AuditCheckpoint{}, false, internalerrors.New(internalerrors.ErrDatabaseError, "get latest audit checkpoint", err)
+	}
+	if err == nil && latest.Sequence >= head.Sequence {
+		return latest, false, nil
+	}
+
+	id, err := generateID(s.random, "acp_")
+	if err != nil {
+		return models.AuditCheckpoint{}, false, internalerrors.New(internalerrors.ErrInternalError, "generate audit checkpoint id", err)
+	}
+	cp := models.AuditCheckpoint{
+		ID:        id,
+		Sequence:  head.Sequence,
+		Hash:      head.Hash,
+		CreatedAt: s.now().UTC().Truncate(models.AuditChainTimePrecision),
+	}
+	cp.MAC = cp.ComputeMAC(s.key)
+	if err := s.repo.CreateCheckpoint(ctx, cp); err != nil {
+		return models.AuditCheckpoint{}, false, internalerrors.New(internalerrors.ErrAuditFailed, "create audit checkpoint", err)
+	}
+	return cp, true, nil
+}
+
+// Run writes a checkpoint every interval until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cp, created, err := s.Checkpoint(ctx)
+			if err != nil {
+				s.logger.ErrorContext(ctx, "audit checkpoint failed", "error", err.Error())
+				continue
+			}
+			if created {
+				s.logger.InfoContext(ctx, "audit checkpoint written", "sequence", cp.Sequence)
+			}
+		}
+	}
+}
+
+type Problem struct {
+	Kind     string `json:"kind"`
+	Sequence int64  `json:"sequence"`
+	EventID  string `json:"event_id,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+type Report struct {
+	OK bool `json:"ok"`
+	// Events is the number of chained events checked; Unchained counts events
+	// written before chaining was enabled, which cannot be verified.
+	Events       int64  `json:"events"`
+	Unchained    int64  `json:"unchained"`
+	HeadSequence int64  `json:"head_sequence"`
+	HeadHash     string `json:"head_hash,omitempty"`
+	Checkpoints  int    `json:"checkpoints"`
+	// SignaturesChecked is false when no key was configured, in which case a
+	// checkpoint could have been forged along with the events.
+	SignaturesChecked bool      `json:"signatures_checked"`
+	Problems          []Problem `json:"problems"`
+}
+
+// Verify walks the whole chain, recomputing each hash and its link to the
+// previous event, then checks every checkpoint against the events it pins.
+func (s *Service) Verify(ctx context.Context) (Report, error) {
+	report := Report{SignaturesChecked: len(s.key) > 0, Problems: []Problem{}}
+
+	checkpoints, err := s.repo.ListCheckpoints(ctx)
+	if err != nil {
+		return Report{}, internalerrors.New(internalerrors.ErrDatabaseError, "list audit checkpoints", err)
+	}
+	report.Checkpoints = len(checkpoints)
+	pinned := make(map[int64]string, len(checkpoints))
+	for _, cp := range checkpoints {
+		pinned[cp.Sequence] = ""
+	}
+
+	unchained, err := s.repo.CountUnchained(ctx)
+	if err != nil {
+		return Report{}, internalerrors.New(internalerrors.ErrDatabaseError, "count unchained audit events", err)
+	}
+	report.Unchained = unchained
+
+	var prevSequence int64
+	var prevHash string
+	for {
+		events, err := s.repo.ListChain(ctx, prevSequence, verifyPageSize)
+		if err != nil {
+			return Report{}, internalerrors.New(internalerrors.ErrDatabaseError, "list audit chain", err)
+		}
+		for _, e := range events {
+			report.Events++
+			linked := true
+			if e.Sequence != prevSequence+1 {
+				linked = false
+				report.Problems = append(report.Problems, Problem{
+					Kind:     ProblemGap,
+					Sequence: e.Sequence,
+					EventID:  e.ID,
+					Detail:   fmt.Sprintf("sequences %d-%d are missing", prevSequence+1, e.Sequence-1),
+				})
+			}
+			if linked && e.PrevHash != prevHash {
+				report.Problems = append(report.Problems, Problem{
+					Kind:     ProblemBrokenLink,
+					Sequence: e.Sequence,
+					EventID:  e.ID,
+					Detail:   "prev_hash does not match the previous event's hash",
+				})
+			}
+			computed, err := e.ChainHash()
+			if err != nil {
+				return Report{}, internalerrors.New(internalerrors.ErrInternalError, "hash audit event", err)
+			}
+			if computed != e.Hash {
+				report.Problems = append(report.Problems, Problem{
+					Kind:     ProblemModified,
+					Sequence: e.Sequence,
+					EventID:  e.ID,
+					Detail:   "stored hash does not match the event content",
+				})
+			}
+			if _, ok := pinned[e.Sequence]; ok {
+				pinned[e.Sequence] = e.Hash
+			}
+			prevSequence, prevHash = e.Sequence, e.Hash
+		}
+		if len(events) < verifyPageSize {
+			break
+		}
+	}
+	report.HeadSequence, report.HeadHash = prevSequence, prevHash
+
+	for _, cp := range checkpoints {
+		if report.SignaturesChecked && !hmac.Equal([]byte(cp.ComputeMAC(s.key)), []byte(cp.MAC)) {
+			report.Problems = append(report.Problems, Problem{
+				Kind:     ProblemCheckpointSignature,
+				Sequence: cp.Sequence,
+				Detail:   fmt.Sprintf("checkpoint %s has an invalid signature", cp.ID),
+			})
+			continue
+		}
+		hash := pinned[cp.Sequence]
+		switch {
+		case hash == "":
+			report.Problems = append(report.Problems, Problem{
+				Kind:     ProblemCheckpointMissing,
+				Sequence: cp.Sequence,
+				Detail:   fmt.Sprintf("checkpoint %s pins an event that no longer exists", cp.ID),
+			})
+		case hash != cp.Hash:
+			report.Problems = append(report.Problems, Problem{
+				Kind:     ProblemCheckpointMismatch,
+				Sequence: cp.Sequence,
+				Detail:   fmt.Sprintf("checkpoint %s does not match the stored event hash", cp.ID),
+			})
+		}
+	}
+
+	report.OK = len(report.Problems) == 0
+	return report, nil
+}
+
+func generateID(r io.Reader, prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
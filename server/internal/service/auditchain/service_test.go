@@ -0,0 +1,260 @@
+package auditchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// fakeChainRepo keeps events and checkpoints in memory and appends the way the
+// SQL repositories do.
+type fakeChainRepo struct {
+	events      []models.AuditEvent
+	unchained   int64
+	checkpoints []models.AuditCheckpoint
+}
+
+func (f *fakeChainRepo) append(t *testing.T, e models.AuditEvent) {
+	t.Helper()
+	var prevSeq int64
+	var prevHash string
+	if n := len(f.events); n > 0 {
+		prevSeq, prevHash = f.events[n-1].Sequence, f.events[n-1].Hash
+	}
+	if err := e.Chain(prevSeq, prevHash); err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	f.events = append(f.events, e)
+}
+
+func (f *fakeChainRepo) ListChain(_ context.Context, afterSequence int64, limit int) ([]models.AuditEvent, error) {
+	sorted := append([]models.AuditEvent(nil), f.events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Sequence < sorted[j].Sequence })
+	var out []models.AuditEvent
+	for _, e := range sorted {
+		if e.Sequence > afterSequence && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeChainRepo) ChainHead(_ context.Context) (models.AuditEvent, error) {
+	if len(f.events) == 0 {
+		return models.AuditEvent{}, repository.ErrNotFound
+	}
+	return f.events[len(f.events)-1], nil
+}
+
+func (f *fakeChainRepo) CountUnchained(_ context.Context) (int64, error) {
+	return f.unchained, nil
+}
+
+func (f *fakeChainRepo) CreateCheckpoint(_ context.Context, c models.AuditCheckpoint) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	f.checkpoints = append(f.checkpoints, c)
+	return nil
+}
+
+func (f *fakeChainRepo) LatestCheckpoint(_ context.Context) (models.AuditCheckpoint, error) {
+	if len(f.checkpoints) == 0 {
+		return models.AuditCheckpoint{}, repository.ErrNotFound
+	}
+	return f.checkpoints[len(f.checkpoints)-1], nil
+}
+
+func (f *fakeChainRepo) ListCheckpoints(_ context.Context) ([]models.AuditCheckpoint, error) {
+	return f.checkpoints, nil
+}
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+func newChain(t *testing.T, n int) *fakeChainRepo {
+	t.Helper()
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	repo := &fakeChainRepo{}
+	for i := 0; i < n; i++ {
+		repo.append(t, models.AuditEvent{
+			ID:        fmt.Sprintf("aevt_%d", i+1),
+			RequestID: fmt.Sprintf("req-%d", i+1),
+			EventType: models.EventTypeUpstreamResponse,
+			Actor:     "system",
+			Action:    "relay_call",
+			Resource:  "relay.call",
+			Metadata:  map[string]any{"response_status": 200, "latency_ms": int64(10 * i)},
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return repo
+}
+
+func newTestService(t *testing.T, repo repository.AuditChainRepository, key []byte) *Service {
+	t.Helper()
+	now := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	svc, err := NewService(repo, Config{
+		Key:    key,
+		Now:    func() time.Time { return now },
+		Random: bytes.NewReader(bytes.Repeat([]byte{0x07}, 64)),
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return svc
+}
+
+func problemKinds(r Report) []string {
+	kinds := make([]string, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		kinds = append(kinds, p.Kind)
+	}
+	return kinds
+}
+
+func TestService_CheckpointOnlyWhenHeadMoves(t *testing.T) {
+	ctx := context.Background()
+	repo := newChain(t, 3)
+	svc := newTestService(t, repo, testKey)
+
+	cp, created, err := svc.Checkpoint(ctx)
+	if err != nil || !created {
+		t.Fatalf("expected first checkpoint, got created=%v err=%v", created, err)
+	}
+	if cp.Sequence != 3 || cp.Hash != repo.events[2].Hash || cp.MAC != cp.ComputeMAC(testKey) {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+	if _, created, err := svc.Checkpoint(ctx); err != nil || created {
+		t.Fatalf("expected unchanged head to be skipped, got created=%v err=%v", created, err)
+	}
+
+	if _, _, err := newTestService(t, repo, nil).Checkpoint(ctx); err == nil {
+		t.Fatalf("expected checkpoint without a key to fail")
+	}
+	if _, err := NewService(repo, Config{Key: []byte("short")}); err == nil {
+		t.Fatalf("expected a short key to be rejected")
+	}
+}
+
+func TestService_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Intact", func(t *testing.T) {
+		repo := newChain(t, 5)
+		repo.unchained = 2
+		svc := newTestService(t, repo, testKey)
+		if _, _, err := svc.Checkpoint(ctx); err != nil {
+			t.Fatalf("Checkpoint: %v", err)
+		}
+		report, err := svc.Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if !report.OK || report.Events != 5 || report.Unchained != 2 || report.HeadSequence != 5 || report.Checkpoints != 1 || !report.SignaturesChecked {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+	})
+
+	t.Run("ModifiedEvent", func(t *testing.T) {
+		repo := newChain(t, 5)
+		repo.events[2].Metadata["response_status"] = 500
+		report, err := newTestService(t, repo, testKey).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if report.OK || len(report.Problems) != 1 || report.Problems[0].Kind != ProblemModified || report.Problems[0].Sequence != 3 {
+			t.Fatalf("expected a single modification at sequence 3, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("RehashedEventBreaksLink", func(t *testing.T) {
+		repo := newChain(t, 5)
+		e := &repo.events[2]
+		e.Actor = "someone-else"
+		h, err := e.ChainHash()
+		if err != nil {
+			t.Fatalf("ChainHash: %v", err)
+		}
+		e.Hash = h
+		report, err := newTestService(t, repo, testKey).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ProblemBrokenLink || report.Problems[0].Sequence != 4 {
+			t.Fatalf("expected broken link at sequence 4, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("DeletedEvent", func(t *testing.T) {
+		repo := newChain(t, 5)
+		repo.events = append(repo.events[:1], repo.events[2:]...)
+		report, err := newTestService(t, repo, testKey).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ProblemGap || report.Problems[0].Sequence != 3 {
+			t.Fatalf("expected gap before sequence 3, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("TruncatedTailAndRewrittenChain", func(t *testing.T) {
+		repo := newChain(t, 5)
+		svc := newTestService(t, repo, testKey)
+		if _, _, err := svc.Checkpoint(ctx); err != nil {
+			t.Fatalf("Checkpoint: %v", err)
+		}
+		repo.events = repo.events[:4]
+		report, err := svc.Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ProblemCheckpointMissing {
+			t.Fatalf("expected checkpoint to catch the truncated tail, got %+v", report.Problems)
+		}
+
+		// Rebuilding the whole chain from scratch passes the link checks but
+		// not the checkpoint.
+		rebuilt := newChain(t, 5)
+		rebuilt.events[4].Actor = "forged"
+		forged := &fakeChainRepo{checkpoints: repo.checkpoints}
+		for _, e := range rebuilt.events {
+			forged.append(t, e)
+		}
+		report, err = newTestService(t, forged, testKey).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ProblemCheckpointMismatch {
+			t.Fatalf("expected checkpoint mismatch for a rebuilt chain, got %+v", report.Problems)
+		}
+	})
+
+	t.Run("ForgedCheckpoint", func(t *testing.T) {
+		repo := newChain(t, 3)
+		forger := newTestService(t, repo, bytes.Repeat([]byte{0x01}, 32))
+		if _, _, err := forger.Checkpoint(ctx); err != nil {
+			t.Fatalf("Checkpoint: %v", err)
+		}
+		report, err := newTestService(t, repo, testKey).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ProblemCheckpointSignature {
+			t.Fatalf("expected invalid checkpoint signature, got %+v", report.Problems)
+		}
+
+		report, err = newTestService(t, repo, nil).Verify(ctx)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if !report.OK || report.SignaturesChecked {
+			t.Fatalf("expected signatures to be skipped without a key, got %+v", report)
+		}
+	})
+}
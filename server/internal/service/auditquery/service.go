@@ -0,0 +1,336 @@
+// Package auditquery pages through audit events and downstream requests for
+// operators. It owns the limits and the opaque cursor format; filtering and
+// ordering happen in the repositories.
+package auditquery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/service/usage"
+)
+
+const (
+	// DefaultLimit is the page size when a query does not set one.
+	DefaultLimit = 100
+	// MaxLimit caps the page size.
+	MaxLimit = 1000
+)
+
+// AuditEventPage is one page of audit events. NextCursor is empty on the
+// last page. Groups is only set when the caller asked for label counts.
+type AuditEventPage struct {
+	Items      []models.AuditEvent `json:"items"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	GroupBy    string              `json:"group_by,omitempty"`
+	Groups     []LabelGroup        `json:"groups,omitempty"`
+}
+
+// RequestPage is one page of downstream requests. NextCursor is empty on the
+// last page. Groups is only set when the caller asked for label counts.
+type RequestPage struct {
+	Items      []models.DownstreamRequest `json:"items"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+	GroupBy    string                     `json:"group_by,omitempty"`
+	Groups     []LabelGroup               `json:"groups,omitempty"`
+}
+
+// LabelGroup counts the matching rows whose API key has one value of the
+// grouping label. Keys without the label, rows of deleted keys and audit
+// events without a request fall under usage.NoLabel.
+type LabelGroup struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type Service struct {
+	audit    repository.AuditEventRepository
+	requests repository.DownstreamRequestRepository
+	keys     repository.APIKeyRepository
+}
+
+// NewService wires the repositories. keys resolves label selectors and may be
+// nil, in which case queries with a selector fail.
+func NewService(audit repository.AuditEventRepository, requests repository.DownstreamRequestRepository, keys repository.APIKeyRepository) *Service {
+	return &Service{audit: audit, requests: requests, keys: keys}
+}
+
+// AuditEvents returns the page of events matching query and selector that
+// follows cursor, or the first page when cursor is empty. query.After and
+// query.APIKeyIDs are ignored; an unset order lists newest first.
+func (s *Service) AuditEvents(ctx context.Context, query repository.AuditEventQuery, selector models.LabelSelector, cursor string) (AuditEventPage, error) {
+	if s.audit == nil {
+		return AuditEventPage{}, internalerrors.New(internalerrors.ErrInternalError, "audit event repository is required", nil)
+	}
+	if err := validateFilters(query.From, query.To, query.MinStatus, query.MaxStatus); err != nil {
+		return AuditEventPage{}, err
+	}
+	order, err := sortOrder(query.Order)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	limit, after, err := pageBounds(query.Limit, cursor)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	keyIDs, ok, err := s.matchingKeys(ctx, selector)
+	if err != nil {
+		return AuditEventPage{}, err
+	}
+	if !ok {
+		return AuditEventPage{Items: []models.AuditEvent{}}, nil
+	}
+	// One row past the page tells whether another page follows.
+	query.Order, query.After, query.Limit, query.APIKeyIDs = order, after, limit+1, keyIDs
+	items, err := s.audit.Query(ctx, query)
+	if err != nil {
+		return AuditEventPage{}, internalerrors.New(internalerrors.ErrDatabaseError, "query audit events", err)
+	}
+	page := AuditEventPage{Items: items}
+	if len(items) > limit {
+		last := items[limit-1]
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if page.Items == nil {
+		page.Items = []models.AuditEvent{}
+	}
+	return page, nil
+}
+
+// Requests returns the page of downstream requests matching query and
+// selector that follows cursor, or the first page when cursor is empty.
+// query.After and query.APIKeyIDs are ignored; an unset order lists newest
+// first.
+func (s *Service) Requests(ctx context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, cursor string) (RequestPage, error) {
+	if s.requests == nil {
+		return RequestPage{}, internalerrors.New(internalerrors.ErrInternalError, "downstream request repository is required", nil)
+	}
+	if err := validateFilters(query.From, query.To, query.MinStatus, query.MaxStatus); err != nil {
+		return RequestPage{}, err
+	}
+	order, err := sortOrder(query.Order)
+	if err != nil {
+		return RequestPage{}, err
+	}
+	limit, after, err := pageBounds(query.Limit, cursor)
+	if err != nil {
+		return RequestPage{}, err
+	}
+	keyIDs, ok, err := s.matchingKeys(ctx, selector)
+	if err != nil {
+		return RequestPage{}, err
+	}
+	if !ok {
+		return RequestPage{Items: []models.DownstreamRequest{}}, nil
+	}
+	// One row past the page tells whether another page follows.
+	query.Order, query.After, query.Limit, query.APIKeyIDs = order, after, limit+1, keyIDs
+	items, err := s.requests.List(ctx, query)
+	if err != nil {
+		return RequestPage{}, internalerrors.New(internalerrors.ErrDatabaseError, "list downstream requests", err)
+	}
+	page := RequestPage{Items: items}
+	if len(items) > limit {
+		last := items[limit-1]
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(last.ReceivedAt, last.ID)
+	}
+	if page.Items == nil {
+		page.Items = []models.DownstreamRequest{}
+	}
+	return page, nil
+}
+
+// AuditEventGroups counts the events matching query and selector per value
+// of the groupBy label of their request's API key, across all pages. Order,
+// After, Limit and APIKeyIDs of query are ignored.
+func (s *Service) AuditEventGroups(ctx context.Context, query repository.AuditEventQuery, selector models.LabelSelector, groupBy string) ([]LabelGroup, error) {
+	counter, ok := s.audit.(repository.AuditEventCounter)
+	if !ok {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "audit event repository cannot count by api key", nil)
+	}
+	if err := validateFilters(query.From, query.To, query.MinStatus, query.MaxStatus); err != nil {
+		return nil, err
+	}
+	return s.labelGroups(ctx, selector, groupBy, func(keyIDs []string) (map[string]int64, error) {
+		query.APIKeyIDs = keyIDs
+		counts, err := counter.CountByAPIKey(ctx, query)
+		if err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count audit events", err)
+		}
+		return counts, nil
+	})
+}
+
+// RequestGroups counts the downstream requests matching query and selector
+// per value of the groupBy label of their API key, across all pages. Order,
+// After, Limit and APIKeyIDs of query are ignored.
+func (s *Service) RequestGroups(ctx context.Context, query repository.DownstreamRequestQuery, selector models.LabelSelector, groupBy string) ([]LabelGroup, error) {
+	counter, ok := s.requests.(repository.DownstreamRequestCounter)
+	if !ok {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "downstream request repository cannot count by api key", nil)
+	}
+	if err := validateFilters(query.From, query.To, query.MinStatus, query.MaxStatus); err != nil {
+		return nil, err
+	}
+	return s.labelGroups(ctx, selector, groupBy, func(keyIDs []string) (map[string]int64, error) {
+		query.APIKeyIDs = keyIDs
+		counts, err := counter.CountByAPIKey(ctx, query)
+		if err != nil {
+			return nil, internalerrors.New(internalerrors.ErrDatabaseError, "count downstream requests", err)
+		}
+		return counts, nil
+	})
+}
+
+// labelGroups sums the per-key counts of count into groups of the groupBy
+// label, largest first. count receives the ids of the keys matching
+// selector, or nil when selector is empty.
+func (s *Service) labelGroups(ctx context.Context, selector models.LabelSelector, groupBy string, count func(keyIDs []string) (map[string]int64, error)) ([]LabelGroup, error) {
+	if err := models.ValidateLabelName(groupBy); err != nil {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "invalid group_by", err)
+	}
+	if s.keys == nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "api key repository is required for grouping", nil)
+	}
+	keys, err := s.keys.List(ctx)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list api keys", err)
+	}
+	groupOf := make(map[string]string, len(keys))
+	var keyIDs []string
+	for _, key := range keys {
+		if !selector.Matches(key.Labels) {
+			continue
+		}
+		keyIDs = append(keyIDs, key.ID)
+		if value, ok := key.Labels[groupBy]; ok {
+			groupOf[key.ID] = value
+		}
+	}
+	if selector.Empty() {
+		keyIDs = nil
+	} else if len(keyIDs) == 0 {
+		return []LabelGroup{}, nil
+	}
+	counts, err := count(keyIDs)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[string]int64{}
+	for keyID, n := range counts {
+		group, ok := groupOf[keyID]
+		if !ok {
+			group = usage.NoLabel
+		}
+		totals[group] += n
+	}
+	out := make([]LabelGroup, 0, len(totals))
+	for value, n := range totals {
+		out = append(out, LabelGroup{Value: value, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out, nil
+}
+
+// matchingKeys resolves selector to the ids of the keys it matches. An empty
+// selector yields no ids and true, meaning no key filter; false means no key
+// matches and the query can be skipped.
+func (s *Service) matchingKeys(ctx context.Context, selector models.LabelSelector) ([]string, bool, error) {
+	if selector.Empty() {
+		return nil, true, nil
+	}
+	if s.keys == nil {
+		return nil, false, internalerrors.New(internalerrors.ErrInternalError, "api key repository is required for selectors", nil)
+	}
+	keys, err := s.keys.List(ctx)
+	if err != nil {
+		return nil, false, internalerrors.New(internalerrors.ErrDatabaseError, "list api keys", err)
+	}
+	var ids []string
+	for _, key := range keys {
+		if selector.Matches(key.Labels) {
+			ids = append(ids, key.ID)
+		}
+	}
+	return ids, len(ids) > 0, nil
+}
+
+func validateFilters(from, to time.Time, minStatus, maxStatus int) error {
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "from must be before to", nil)
+	}
+	if minStatus < 0 || maxStatus < 0 {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "status bounds must not be negative", nil)
+	}
+	if minStatus != 0 && maxStatus != 0 && minStatus > maxStatus {
+		return internalerrors.New(internalerrors.ErrValidationFailed, "min_status must not exceed max_status", nil)
+	}
+	return nil
+}
+
+func sortOrder(order repository.SortOrder) (repository.SortOrder, error) {
+	switch order {
+	case "":
+		return repository.SortDesc, nil
+	case repository.SortAsc, repository.SortDesc:
+		return order, nil
+	default:
+		return "", internalerrors.New(internalerrors.ErrValidationFailed, "order must be asc or desc", nil)
+	}
+}
+
+// pageBounds resolves the page size and the decoded cursor, if any.
+func pageBounds(limit int, cursor string) (int, *repository.Cursor, error) {
+	switch {
+	case limit == 0:
+		limit = DefaultLimit
+	case limit < 0 || limit > MaxLimit:
+		return 0, nil, internalerrors.New(internalerrors.ErrValidationFailed, fmt.Sprintf("limit must be between 1 and %d", MaxLimit), nil)
+	}
+	if cursor == "" {
+		return limit, nil, nil
+	}
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &after, nil
+}
+
+// cursorJSON is the payload of an encoded cursor. Clients treat the encoding
+// as opaque.
+type cursorJSON struct {
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+func encodeCursor(t time.Time, id string) string {
+	b, _ := json.Marshal(cursorJSON{Time: t.UTC(), ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (repository.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return repository.Cursor{}, internalerrors.New(internalerrors.ErrValidationFailed, "invalid cursor", err)
+	}
+	var c cursorJSON
+	if err := json.Unmarshal(b, &c); err != nil || c.Time.IsZero() || c.ID == "" {
+		return repository.Cursor{}, internalerrors.New(internalerrors.ErrValidationFailed, "invalid cursor", err)
+	}
+	return repository.Cursor{Time: c.Time, ID: c.ID}, nil
+}
@@ -0,0 +1,258 @@
+package auditquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+var base = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeAuditRepo struct {
+	repository.AuditEventRepository
+	events []models.AuditEvent
+	counts map[string]int64
+	query  repository.AuditEventQuery
+	err    error
+}
+
+func (f *fakeAuditRepo) CountByAPIKey(_ context.Context, query repository.AuditEventQuery) (map[string]int64, error) {
+	f.query = query
+	return f.counts, f.err
+}
+
+func (f *fakeAuditRepo) Query(_ context.Context, query repository.AuditEventQuery) ([]models.AuditEvent, error) {
+	f.query = query
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.events) > query.Limit {
+		return f.events[:query.Limit], nil
+	}
+	return f.events, nil
+}
+
+type fakeRequestRepo struct {
+	repository.DownstreamRequestRepository
+	requests []models.DownstreamRequest
+	counts   map[string]int64
+	query    repository.DownstreamRequestQuery
+}
+
+func (f *fakeRequestRepo) CountByAPIKey(_ context.Context, query repository.DownstreamRequestQuery) (map[string]int64, error) {
+	f.query = query
+	return f.counts, nil
+}
+
+func (f *fakeRequestRepo) List(_ context.Context, query repository.DownstreamRequestQuery) ([]models.DownstreamRequest, error) {
+	f.query = query
+	if len(f.requests) > query.Limit {
+		return f.requests[:query.Limit], nil
+	}
+	return f.requests, nil
+}
+
+type fakeKeyRepo struct {
+	repository.APIKeyRepository
+	keys []models.APIKey
+}
+
+func (f *fakeKeyRepo) List(context.Context) ([]models.APIKey, error) {
+	return f.keys, nil
+}
+
+func events(n int) []models.AuditEvent {
+	out := make([]models.AuditEvent, n)
+	for i := range out {
+		out[i] = models.AuditEvent{ID: fmt.Sprintf("e%03d", i), CreatedAt: base.Add(-time.Duration(i) * time.Second)}
+	}
+	return out
+}
+
+func TestAuditEvents_PagesWithCursor(t *testing.T) {
+	repo := &fakeAuditRepo{events: events(DefaultLimit + 5)}
+	svc := NewService(repo, nil, nil)
+
+	page, err := svc.AuditEvents(context.Background(), repository.AuditEventQuery{Action: "relay_submit"}, models.LabelSelector{}, "")
+	if err != nil {
+		t.Fatalf("AuditEvents: %v", err)
+	}
+	if repo.query.Order != repository.SortDesc || repo.query.Limit != DefaultLimit+1 || repo.query.After != nil || repo.query.Action != "relay_submit" {
+		t.Fatalf("unexpected repository query: %+v", repo.query)
+	}
+	if len(page.Items) != DefaultLimit || page.NextCursor == "" {
+		t.Fatalf("expected a full page and a cursor, got %d items cursor=%q", len(page.Items), page.NextCursor)
+	}
+
+	// Whatever the client passes as After is replaced by the cursor.
+	stale := &repository.Cursor{Time: base, ID: "stale"}
+	if _, err := svc.AuditEvents(context.Background(), repository.AuditEventQuery{After: stale, Limit: 10}, models.LabelSelector{}, page.NextCursor); err != nil {
+		t.Fatalf("AuditEvents with cursor: %v", err)
+	}
+	last := page.Items[len(page.Items)-1]
+	if repo.query.After == nil || repo.query.After.ID != last.ID || !repo.query.After.Time.Equal(last.CreatedAt) || repo.query.Limit != 11 {
+		t.Fatalf("expected the cursor to resume after %s, got %+v", last.ID, repo.query)
+	}
+
+	repo.events = events(3)
+	page, err = svc.AuditEvents(context.Background(), repository.AuditEventQuery{Order: repository.SortAsc, Limit: 3}, models.LabelSelector{}, "")
+	if err != nil {
+		t.Fatalf("AuditEvents: %v", err)
+	}
+	if len(page.Items) != 3 || page.NextCursor != "" || repo.query.Order != repository.SortAsc {
+		t.Fatalf("expected the last page without a cursor, got %d items cursor=%q", len(page.Items), page.NextCursor)
+	}
+
+	repo.events = nil
+	page, err = svc.AuditEvents(context.Background(), repository.AuditEventQuery{}, models.LabelSelector{}, "")
+	if err != nil || page.Items == nil || len(page.Items) != 0 {
+		t.Fatalf("expected an empty, non-nil page, got %+v, %v", page, err)
+	}
+}
+
+func TestAuditEvents_Validation(t *testing.T) {
+	svc := NewService(&fakeAuditRepo{}, nil, nil)
+	for _, tc := range []struct {
+		name   string
+		query  repository.AuditEventQuery
+		cursor string
+	}{
+		{name: "order", query: repository.AuditEventQuery{Order: "sideways"}},
+		{name: "limit too large", query: repository.AuditEventQuery{Limit: MaxLimit + 1}},
+		{name: "negative limit", query: repository.AuditEventQuery{Limit: -1}},
+		{name: "empty window", query: repository.AuditEventQuery{From: base, To: base}},
+		{name: "status range", query: repository.AuditEventQuery{MinStatus: 500, MaxStatus: 400}},
+		{name: "negative status", query: repository.AuditEventQuery{MinStatus: -1}},
+		{name: "cursor encoding", cursor: "not base64!"},
+		{name: "cursor payload", cursor: "e30"},
+	} {
+		_, err := svc.AuditEvents(context.Background(), tc.query, models.LabelSelector{}, tc.cursor)
+		if internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+			t.Fatalf("%s: expected a validation error, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestAuditEvents_RepositoryError(t *testing.T) {
+	svc := NewService(&fakeAuditRepo{err: errors.New("disk full")}, nil, nil)
+	if _, err := svc.AuditEvents(context.Background(), repository.AuditEventQuery{}, models.LabelSelector{}, ""); internalerrors.GetCode(err) != internalerrors.ErrDatabaseError {
+		t.Fatalf("expected a database error, got %v", err)
+	}
+}
+
+func TestRequests_PagesWithCursor(t *testing.T) {
+	repo := &fakeRequestRepo{requests: []models.DownstreamRequest{
+		{ID: "d3", ReceivedAt: base.Add(2 * time.Second)},
+		{ID: "d2", ReceivedAt: base.Add(time.Second)},
+		{ID: "d1", ReceivedAt: base},
+	}}
+	svc := NewService(nil, repo, nil)
+
+	page, err := svc.Requests(context.Background(), repository.DownstreamRequestQuery{APIKeyID: "k1", Limit: 2}, models.LabelSelector{}, "")
+	if err != nil {
+		t.Fatalf("Requests: %v", err)
+	}
+	if len(page.Items) != 2 || page.NextCursor == "" || repo.query.APIKeyID != "k1" || repo.query.Limit != 3 {
+		t.Fatalf("unexpected page %+v for query %+v", page, repo.query)
+	}
+
+	after, err := decodeCursor(page.NextCursor)
+	if err != nil || after.ID != "d2" || !after.Time.Equal(base.Add(time.Second)) {
+		t.Fatalf("expected the cursor to point at d2, got %+v, %v", after, err)
+	}
+}
+
+func TestSelector_ResolvesToKeyIDs(t *testing.T) {
+	audit := &fakeAuditRepo{events: events(2)}
+	requests := &fakeRequestRepo{}
+	keys := &fakeKeyRepo{keys: []models.APIKey{
+		{ID: "k1", Labels: map[string]string{"team": "growth"}},
+		{ID: "k2", Labels: map[string]string{"team": "search"}},
+		{ID: "k3", Labels: map[string]string{"team": "growth", "environment": "prod"}},
+	}}
+	svc := NewService(audit, requests, keys)
+	growth, err := models.ParseLabelSelector("team=growth")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector: %v", err)
+	}
+
+	page, err := svc.AuditEvents(context.Background(), repository.AuditEventQuery{APIKeyIDs: []string{"ignored"}}, growth, "")
+	if err != nil || len(page.Items) != 2 {
+		t.Fatalf("AuditEvents: %+v, %v", page, err)
+	}
+	if got := audit.query.APIKeyIDs; len(got) != 2 || got[0] != "k1" || got[1] != "k3" {
+		t.Fatalf("expected the selector to resolve to k1 and k3, got %v", got)
+	}
+	if _, err := svc.Requests(context.Background(), repository.DownstreamRequestQuery{}, growth, ""); err != nil || len(requests.query.APIKeyIDs) != 2 {
+		t.Fatalf("expected the request query to carry the key ids, got %+v, %v", requests.query, err)
+	}
+
+	// Without a selector any caller-supplied ids are dropped too.
+	if _, err := svc.AuditEvents(context.Background(), repository.AuditEventQuery{APIKeyIDs: []string{"k2"}}, models.LabelSelector{}, ""); err != nil || audit.query.APIKeyIDs != nil {
+		t.Fatalf("expected no key filter, got %+v, %v", audit.query, err)
+	}
+
+	audit.query = repository.AuditEventQuery{}
+	none, _ := models.ParseLabelSelector("team=billing")
+	page, err = svc.AuditEvents(context.Background(), repository.AuditEventQuery{}, none, "")
+	if err != nil || len(page.Items) != 0 || page.Items == nil || audit.query.Limit != 0 {
+		t.Fatalf("expected an empty page without a query when no key matches, got %+v, %+v, %v", page, audit.query, err)
+	}
+
+	if _, err := NewService(audit, nil, nil).AuditEvents(context.Background(), repository.AuditEventQuery{}, growth, ""); internalerrors.GetCode(err) != internalerrors.ErrInternalError {
+		t.Fatalf("expected a selector without a key repository to fail, got %v", err)
+	}
+}
+
+func TestGroups_CountPerLabelValue(t *testing.T) {
+	audit := &fakeAuditRepo{counts: map[string]int64{"k1": 2, "k2": 5, "k3": 4, "k4": 1, "": 3, "deleted": 1}}
+	requests := &fakeRequestRepo{counts: map[string]int64{"k3": 2, "k4": 1}}
+	keys := &fakeKeyRepo{keys: []models.APIKey{
+		{ID: "k1", Labels: map[string]string{"team": "growth"}},
+		{ID: "k2", Labels: map[string]string{"team": "search"}},
+		{ID: "k3", Labels: map[string]string{"team": "growth", "environment": "prod"}},
+		{ID: "k4", Labels: map[string]string{"environment": "prod"}},
+	}}
+	svc := NewService(audit, requests, keys)
+
+	groups, err := svc.AuditEventGroups(context.Background(), repository.AuditEventQuery{Action: "relay_submit", APIKeyIDs: []string{"ignored"}}, models.LabelSelector{}, "team")
+	if err != nil {
+		t.Fatalf("AuditEventGroups: %v", err)
+	}
+	// Unlabeled keys, deleted keys and events without a request share a group.
+	want := []LabelGroup{{Value: "growth", Count: 6}, {Value: "(none)", Count: 5}, {Value: "search", Count: 5}}
+	if fmt.Sprint(groups) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, groups)
+	}
+	if audit.query.Action != "relay_submit" || audit.query.APIKeyIDs != nil {
+		t.Fatalf("unexpected repository query: %+v", audit.query)
+	}
+
+	prod, _ := models.ParseLabelSelector("environment=prod")
+	groups, err = svc.RequestGroups(context.Background(), repository.DownstreamRequestQuery{}, prod, "team")
+	if err != nil || fmt.Sprint(groups) != fmt.Sprint([]LabelGroup{{Value: "growth", Count: 2}, {Value: "(none)", Count: 1}}) {
+		t.Fatalf("RequestGroups: %v, %v", groups, err)
+	}
+	if got := requests.query.APIKeyIDs; len(got) != 2 || got[0] != "k3" || got[1] != "k4" {
+		t.Fatalf("expected the selector to resolve to k3 and k4, got %v", got)
+	}
+
+	requests.query = repository.DownstreamRequestQuery{}
+	none, _ := models.ParseLabelSelector("team=billing")
+	if groups, err := svc.RequestGroups(context.Background(), repository.DownstreamRequestQuery{}, none, "team"); err != nil || groups == nil || len(groups) != 0 || requests.query.APIKeyIDs != nil {
+		t.Fatalf("expected no groups without a count when no key matches, got %v, %+v, %v", groups, requests.query, err)
+	}
+
+	if _, err := svc.AuditEventGroups(context.Background(), repository.AuditEventQuery{}, models.LabelSelector{}, "Team!"); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected an invalid label name to fail validation, got %v", err)
+	}
+	if _, err := NewService(audit, nil, nil).AuditEventGroups(context.Background(), repository.AuditEventQuery{}, models.LabelSelector{}, "team"); internalerrors.GetCode(err) != internalerrors.ErrInternalError {
+		t.Fatalf("expected grouping without a key repository to fail, got %v", err)
+	}
+}
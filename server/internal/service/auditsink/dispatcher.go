@@ -0,0 +1,437 @@
+package auditsink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultQueueSize      = 1024
+	DefaultBatchSize      = 100
+	DefaultFlushInterval  = time.Second
+	DefaultEnqueueTimeout = time.Second
+	DefaultRetryInterval  = 5 * time.Second
+	DefaultWriteTimeout   = 10 * time.Second
+	DefaultSpoolMaxBytes  = 256 << 20
+)
+
+type DispatcherConfig struct {
+	Policy Policy
+	// QueueSize bounds the entries waiting for the next batch.
+	QueueSize int
+	// BatchSize is the most entries handed to the sink in one Write.
+	BatchSize int
+	// FlushInterval is how long a partial batch waits before it is written.
+	FlushInterval time.Duration
+	// EnqueueTimeout is how long a fail-closed Enqueue waits for room in a
+	// full queue before failing the request.
+	EnqueueTimeout time.Duration
+	// RetryInterval is how often spooled batches are retried.
+	RetryInterval time.Duration
+	WriteTimeout  time.Duration
+	// SpoolDir holds batches the sink failed to accept. Required for
+	// PolicyFailClosed; without it failed batches are dropped.
+	SpoolDir string
+	// SpoolMaxBytes caps the spool. Once reached, best-effort sinks drop new
+	// batches and fail-closed sinks refuse new entries until it drains.
+	SpoolMaxBytes int64
+	Logger        *slog.Logger
+	Now           func() time.Time
+}
+
+type Stats struct {
+	Delivered uint64 `json:"delivered"`
+	Spooled   uint64 `json:"spooled"`
+	Dropped   uint64 `json:"dropped"`
+	// Rejected counts entries the sink refused permanently; they are logged
+	// and not retried.
+	Rejected uint64 `json:"rejected"`
+}
+
+// Dispatcher queues entries for one sink and writes them in batches from the
+// goroutine running Run. Entries reach the sink in the order they were
+// enqueued, including across spooling.
+type Dispatcher struct {
+	sink           Sink
+	policy         Policy
+	batchSize      int
+	flushInterval  time.Duration
+	enqueueTimeout time.Duration
+	retryInterval  time.Duration
+	writeTimeout   time.Duration
+	logger         *slog.Logger
+	now            func() time.Time
+
+	queue chan Entry
+	// spool, lastRetry and held are only used by the Run goroutine. held
+	// keeps, in order, the entries of a fail-closed sink that the spool
+	// failed to store.
+	spool     *spool
+	lastRetry time.Time
+	held      []Entry
+
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	closing   chan struct{}
+	stopped   chan struct{}
+
+	// degraded is set while a fail-closed sink's spool is over its limit or
+	// entries are held because the spool could not store them.
+	degraded      atomic.Bool
+	delivered     atomic.Uint64
+	spooled       atomic.Uint64
+	dropped       atomic.Uint64
+	rejected      atomic.Uint64
+	reportedDrops uint64
+}
+
+func NewDispatcher(sink Sink, cfg DispatcherConfig) (*Dispatcher, error) {
+	if sink == nil {
+		return nil, fmt.Errorf("audit sink is required")
+	}
+	policy := cfg.Policy
+	if policy == "" {
+		policy = PolicyBestEffort
+	}
+	if policy != PolicyBestEffort && policy != PolicyFailClosed {
+		return nil, fmt.Errorf("invalid audit sink policy %q", policy)
+	}
+	if policy == PolicyFailClosed && cfg.SpoolDir == "" {
+		return nil, fmt.Errorf("audit sink %s: %s requires a spool dir", sink.Name(), PolicyFailClosed)
+	}
+	d := &Dispatcher{
+		sink:           sink,
+		policy:         policy,
+		batchSize:      positiveOr(cfg.BatchSize, DefaultBatchSize),
+		flushInterval:  durationOr(cfg.FlushInterval, DefaultFlushInterval),
+		enqueueTimeout: durationOr(cfg.EnqueueTimeout, DefaultEnqueueTimeout),
+		retryInterval:  durationOr(cfg.RetryInterval, DefaultRetryInterval),
+		writeTimeout:   durationOr(cfg.WriteTimeout, DefaultWriteTimeout),
+		logger:         cfg.Logger,
+		now:            cfg.Now,
+		queue:          make(chan Entry, positiveOr(cfg.QueueSize, DefaultQueueSize)),
+		closing:        make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	if d.logger == nil {
+		d.logger = slog.Default()
+	}
+	if d.now == nil {
+		d.now = time.Now
+	}
+	if cfg.SpoolDir != "" {
+		maxBytes := cfg.SpoolMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = DefaultSpoolMaxBytes
+		}
+		s, err := openSpool(cfg.SpoolDir, maxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("audit sink %s: %w", sink.Name(), err)
+		}
+		d.spool = s
+		d.degraded.Store(policy == PolicyFailClosed && s.full())
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) Name() string { return d.sink.Name() }
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{Delivered: d.delivered.Load(), Spooled: d.spooled.Load(), Dropped: d.dropped.Load(), Rejected: d.rejected.Load()}
+}
+
+// Enqueue hands e to the dispatcher without waiting for delivery. Best-effort
+// sinks never return an error; a full queue drops the entry. Fail-closed sinks
+// wait up to EnqueueTimeout for room and fail when the queue stays full, the
+// spool is over its limit or the dispatcher is closed.
+func (d *Dispatcher) Enqueue(ctx context.Context, e Entry) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return d.reject(fmt.Errorf("audit sink %s is closed", d.sink.Name()))
+	}
+	if d.policy == PolicyFailClosed && d.degraded.Load() {
+		return fmt.Errorf("audit sink %s is unavailable and its spool cannot take entries", d.sink.Name())
+	}
+	select {
+	case d.queue <- e:
+		return nil
+	default:
+	}
+	if d.policy == PolicyBestEffort {
+		d.dropped.Add(1)
+		return nil
+	}
+	timer := time.NewTimer(d.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case d.queue <- e:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("audit sink %s queue is full", d.sink.Name())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) reject(err error) error {
+	if d.policy == PolicyBestEffort {
+		d.dropped.Add(1)
+		return nil
+	}
+	return err
+}
+
+// Run delivers queued entries until ctx is done or Close is called, then
+// writes whatever is still queued. Entries the sink cannot take at that point
+// stay in the spool for the next start.
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer close(d.stopped)
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+	d.replay(ctx, false)
+
+	batch := make([]Entry, 0, d.batchSize)
+	for {
+		select {
+		case e := <-d.queue:
+			batch = append(batch, e)
+			if len(batch) >= d.batchSize {
+				d.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				d.flush(ctx, batch)
+				batch = batch[:0]
+			}
+			d.retryHeld(ctx)
+			d.replay(ctx, false)
+			d.reportDrops(ctx)
+		case <-ctx.Done():
+			d.drain(context.WithoutCancel(ctx), batch)
+			return
+		case <-d.closing:
+			d.drain(ctx, batch)
+			return
+		}
+	}
+}
+
+// Close stops accepting entries, waits for Run to deliver or spool what is
+// queued and closes the sink. Run must have been started.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.closed = true
+		d.mu.Unlock()
+		close(d.closing)
+	})
+	select {
+	case <-d.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("audit sink %s: close: %w", d.sink.Name(), ctx.Err())
+	}
+	return d.sink.Close()
+}
+
+func (d *Dispatcher) drain(ctx context.Context, batch []Entry) {
+	for {
+		select {
+		case e := <-d.queue:
+			batch = append(batch, e)
+			if len(batch) >= d.batchSize {
+				d.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		default:
+			if len(batch) > 0 {
+				d.flush(ctx, batch)
+			}
+			d.retryHeld(ctx)
+			d.replay(ctx, true)
+			if len(d.held) > 0 {
+				d.logger.ErrorContext(ctx, "audit sink spool unavailable at shutdown; entries lost", "sink", d.sink.Name(), "entries", len(d.held))
+				d.dropped.Add(uint64(len(d.held)))
+				d.held = nil
+			}
+			d.reportDrops(ctx)
+			return
+		}
+	}
+}
+
+// flush writes a batch, or spools it when the sink fails. While older batches
+// are spooled or held new ones go behind them to keep delivery in order.
+func (d *Dispatcher) flush(ctx context.Context, batch []Entry) {
+	if len(d.held) > 0 || (d.spool != nil && d.spool.pending() > 0) {
+		d.toSpool(ctx, batch)
+		return
+	}
+	if rest, err := d.deliver(ctx, batch); err != nil {
+		d.logger.WarnContext(ctx, "audit sink write failed", "sink", d.sink.Name(), "entries", len(rest), "error", err.Error())
+		d.toSpool(ctx, rest)
+	}
+}
+
+// deliver writes batch. When the sink refuses it permanently the entries are
+// written one at a time so only those it still refuses are rejected. On a
+// retryable failure it returns the entries not yet delivered, in order.
+func (d *Dispatcher) deliver(ctx context.Context, batch []Entry) ([]Entry, error) {
+	err := d.write(ctx, batch)
+	if err == nil {
+		d.delivered.Add(uint64(len(batch)))
+		return nil, nil
+	}
+	if !IsPermanent(err) {
+		return batch, err
+	}
+	if len(batch) == 1 {
+		d.rejectEntry(ctx, batch[0], err)
+		return nil, nil
+	}
+	for i, e := range batch {
+		err := d.write(ctx, []Entry{e})
+		switch {
+		case err == nil:
+			d.delivered.Add(1)
+		case IsPermanent(err):
+			d.rejectEntry(ctx, e, err)
+		default:
+			return batch[i:], err
+		}
+	}
+	return nil, nil
+}
+
+func (d *Dispatcher) rejectEntry(ctx context.Context, e Entry, err error) {
+	d.rejected.Add(1)
+	d.logger.ErrorContext(ctx, "audit sink rejected entry; not retrying", "sink", d.sink.Name(), "kind", e.Kind, "request_id", e.RequestID, "error", err.Error())
+}
+
+func (d *Dispatcher) toSpool(ctx context.Context, batch []Entry) {
+	if d.spool == nil || (d.spool.full() && d.policy == PolicyBestEffort) {
+		d.dropped.Add(uint64(len(batch)))
+		return
+	}
+	if len(d.held) > 0 {
+		d.held = append(d.held, batch...)
+		d.retryHeld(ctx)
+		return
+	}
+	d.spoolBatch(ctx, batch)
+}
+
+// spoolBatch appends batch to the spool and reports whether it was stored.
+// A fail-closed sink holds a batch the spool cannot store in memory, and
+// refuses new entries until retryHeld stores it; a best-effort sink drops it.
+func (d *Dispatcher) spoolBatch(ctx context.Context, batch []Entry) bool {
+	if err := d.spool.append(batch); err != nil {
+		d.logger.ErrorContext(ctx, "audit sink spool failed", "sink", d.sink.Name(), "entries", len(batch), "error", err.Error())
+		if d.policy == PolicyBestEffort {
+			d.dropped.Add(uint64(len(batch)))
+			return false
+		}
+		d.held = append(d.held, batch...)
+		d.degraded.Store(true)
+		return false
+	}
+	d.spooled.Add(uint64(len(batch)))
+	if d.spool.full() && d.policy == PolicyFailClosed && !d.degraded.Load() {
+		d.logger.ErrorContext(ctx, "audit sink spool is full; refusing new entries", "sink", d.sink.Name())
+		d.degraded.Store(true)
+	}
+	return true
+}
+
+// retryHeld spools the held entries again, and accepts new entries once
+// nothing is held and the spool is under its limit.
+func (d *Dispatcher) retryHeld(ctx context.Context) {
+	if len(d.held) > 0 {
+		held := d.held
+		d.held = nil
+		if !d.spoolBatch(ctx, held) {
+			return
+		}
+	}
+	if d.degraded.Load() && d.spool != nil && !d.spool.full() {
+		d.logger.InfoContext(ctx, "audit sink spool available; accepting entries again", "sink", d.sink.Name())
+		d.degraded.Store(false)
+	}
+}
+
+// replay retries spooled segments oldest first, at most once per
+// RetryInterval unless force is set, and stops at the first retryable
+// failure. Entries the sink refuses permanently are rejected rather than
+// left to block the segments behind them.
+func (d *Dispatcher) replay(ctx context.Context, force bool) {
+	if d.spool == nil || d.spool.pending() == 0 {
+		return
+	}
+	now := d.now()
+	if !force && now.Sub(d.lastRetry) < d.retryInterval {
+		return
+	}
+	d.lastRetry = now
+	for d.spool.pending() > 0 {
+		name, entries, err := d.spool.oldest()
+		if err != nil {
+			d.logger.ErrorContext(ctx, "audit sink spool segment unreadable; moved aside", "sink", d.sink.Name(), "segment", name, "error", err.Error())
+			if qerr := d.spool.quarantine(name); qerr != nil {
+				d.logger.ErrorContext(ctx, "audit sink spool quarantine failed", "sink", d.sink.Name(), "error", qerr.Error())
+				return
+			}
+			continue
+		}
+		rest, err := d.deliver(ctx, entries)
+		if err != nil {
+			d.logger.DebugContext(ctx, "audit sink still unavailable", "sink", d.sink.Name(), "pending_segments", d.spool.pending(), "error", err.Error())
+			if len(rest) < len(entries) {
+				if rerr := d.spool.rewrite(name, rest); rerr != nil {
+					d.logger.ErrorContext(ctx, "audit sink spool rewrite failed", "sink", d.sink.Name(), "error", rerr.Error())
+				}
+			}
+			return
+		}
+		if err := d.spool.remove(name); err != nil {
+			d.logger.ErrorContext(ctx, "audit sink spool cleanup failed", "sink", d.sink.Name(), "error", err.Error())
+			return
+		}
+		if !d.spool.full() && len(d.held) == 0 {
+			d.degraded.Store(false)
+		}
+	}
+	d.logger.InfoContext(ctx, "audit sink spool replayed", "sink", d.sink.Name())
+}
+
+func (d *Dispatcher) write(ctx context.Context, batch []Entry) error {
+	wctx, cancel := context.WithTimeout(ctx, d.writeTimeout)
+	defer cancel()
+	return d.sink.Write(wctx, batch)
+}
+
+func (d *Dispatcher) reportDrops(ctx context.Context) {
+	if dropped := d.dropped.Load(); dropped > d.reportedDrops {
+		d.logger.WarnContext(ctx, "audit sink dropped entries", "sink", d.sink.Name(), "dropped", dropped-d.reportedDrops, "total_dropped", dropped)
+		d.reportedDrops = dropped
+	}
+}
+
+func positiveOr(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func durationOr(v, def time.Duration) time.Duration {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,348 @@
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	mu      sync.Mutex
+	fail    bool
+	reject  map[string]bool
+	batches [][]Entry
+	closed  bool
+}
+
+func (f *fakeSink) Name() string { return "fake" }
+
+func (f *fakeSink) Write(_ context.Context, entries []Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("destination unavailable")
+	}
+	for _, e := range entries {
+		if f.reject[e.RequestID] {
+			return permanent(fmt.Errorf("%s refused", e.RequestID))
+		}
+	}
+	f.batches = append(f.batches, append([]Entry(nil), entries...))
+	return nil
+}
+
+func (f *fakeSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSink) setFail(v bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = v
+}
+
+func (f *fakeSink) requestIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, b := range f.batches {
+		for _, e := range b {
+			ids = append(ids, e.RequestID)
+		}
+	}
+	return ids
+}
+
+func testEntry(t *testing.T, i int) Entry {
+	t.Helper()
+	e, err := NewEntry(KindAuditEvent, fmt.Sprintf("req-%d", i), time.Date(2026, 3, 1, 0, 0, i, 0, time.UTC), map[string]any{"id": fmt.Sprintf("aevt_%d", i)})
+	if err != nil {
+		t.Fatalf("NewEntry: %v", err)
+	}
+	return e
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_DeliversInOrderAndDrainsOnClose(t *testing.T) {
+	sink := &fakeSink{}
+	d, err := NewDispatcher(sink, DispatcherConfig{BatchSize: 3, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+
+	for i := 1; i <= 7; i++ {
+		if err := d.Enqueue(context.Background(), testEntry(t, i)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	closeDispatcher(t, d)
+
+	ids := sink.requestIDs()
+	if len(ids) != 7 || ids[0] != "req-1" || ids[6] != "req-7" {
+		t.Fatalf("unexpected delivery: %v", ids)
+	}
+	if len(sink.batches) != 3 || !sink.closed {
+		t.Fatalf("expected 3 batches and a closed sink, got %d batches closed=%v", len(sink.batches), sink.closed)
+	}
+	if st := d.Stats(); st.Delivered != 7 || st.Dropped != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestDispatcher_SpoolsDuringOutageAndReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fakeSink{fail: true}
+	d, err := NewDispatcher(sink, DispatcherConfig{BatchSize: 2, FlushInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond, SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+
+	for i := 1; i <= 4; i++ {
+		if err := d.Enqueue(context.Background(), testEntry(t, i)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return d.Stats().Spooled == 4 })
+
+	sink.setFail(false)
+	if err := d.Enqueue(context.Background(), testEntry(t, 5)); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, func() bool { return len(sink.requestIDs()) == 5 })
+	closeDispatcher(t, d)
+
+	ids := sink.requestIDs()
+	for i, id := range ids {
+		if want := fmt.Sprintf("req-%d", i+1); id != want {
+			t.Fatalf("delivery out of order: %v", ids)
+		}
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt)); len(segments) != 0 {
+		t.Fatalf("expected spool to be empty, got %v", segments)
+	}
+}
+
+func TestDispatcher_PermanentRejectionDoesNotBlockSpool(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fakeSink{fail: true, reject: map[string]bool{"req-2": true, "req-5": true}}
+	d, err := NewDispatcher(sink, DispatcherConfig{Policy: PolicyFailClosed, BatchSize: 3, FlushInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond, SpoolDir: dir, SpoolMaxBytes: 1})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+
+	for i := 1; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), testEntry(t, i)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return d.Stats().Spooled == 3 })
+	if err := d.Enqueue(context.Background(), testEntry(t, 4)); err == nil {
+		t.Fatalf("expected a fail-closed sink with a full spool to refuse entries")
+	}
+
+	// The spooled batch holds an entry the sink will never take; the rest of
+	// it is delivered and the spool drains instead of blocking forever.
+	sink.setFail(false)
+	waitFor(t, func() bool { return d.Stats().Delivered == 2 })
+	for i := 4; i <= 6; i++ {
+		if err := d.Enqueue(context.Background(), testEntry(t, i)); err != nil {
+			t.Fatalf("Enqueue after replay: %v", err)
+		}
+	}
+	closeDispatcher(t, d)
+
+	ids := sink.requestIDs()
+	if want := []string{"req-1", "req-3", "req-4", "req-6"}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("expected %v delivered, got %v", want, ids)
+	}
+	if st := d.Stats(); st.Rejected != 2 || st.Delivered != 4 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*"+spoolExt)); len(segments) != 0 {
+		t.Fatalf("expected spool to be empty, got %v", segments)
+	}
+}
+
+func TestDispatcher_SpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	down := &fakeSink{fail: true}
+	d, err := NewDispatcher(down, DispatcherConfig{FlushInterval: time.Hour, SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+	for i := 1; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), testEntry(t, i)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	closeDispatcher(t, d)
+	if st := d.Stats(); st.Spooled != 3 || st.Delivered != 0 {
+		t.Fatalf("expected entries to be spooled on close, got %+v", st)
+	}
+
+	up := &fakeSink{}
+	d, err = NewDispatcher(up, DispatcherConfig{FlushInterval: time.Hour, SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+	waitFor(t, func() bool { return len(up.requestIDs()) == 3 })
+	closeDispatcher(t, d)
+}
+
+func TestDispatcher_CorruptSpoolSegmentIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 1, spoolExt)), []byte("{not json\n"), 0o600); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+	good, err := json.Marshal(testEntry(t, 2))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d%s", 2, spoolExt)), append(good, '\n'), 0o600); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	sink := &fakeSink{}
+	d, err := NewDispatcher(sink, DispatcherConfig{FlushInterval: time.Hour, SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	go d.Run(context.Background())
+	waitFor(t, func() bool { return len(sink.requestIDs()) == 1 })
+	closeDispatcher(t, d)
+
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%020d%s.corrupt", 1, spoolExt))); err != nil {
+		t.Fatalf("expected corrupt segment to be kept aside: %v", err)
+	}
+}
+
+func TestDispatcher_Backpressure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("BestEffortDrops", func(t *testing.T) {
+		d, err := NewDispatcher(&fakeSink{}, DispatcherConfig{QueueSize: 1})
+		if err != nil {
+			t.Fatalf("NewDispatcher: %v", err)
+		}
+		// Run is not started, so the queue never drains.
+		for i := 1; i <= 3; i++ {
+			if err := d.Enqueue(ctx, testEntry(t, i)); err != nil {
+				t.Fatalf("best-effort Enqueue returned %v", err)
+			}
+		}
+		if st := d.Stats(); st.Dropped != 2 {
+			t.Fatalf("expected 2 dropped entries, got %+v", st)
+		}
+	})
+
+	t.Run("FailClosedRejectsWhenQueueStaysFull", func(t *testing.T) {
+		d, err := NewDispatcher(&fakeSink{}, DispatcherConfig{Policy: PolicyFailClosed, QueueSize: 1, EnqueueTimeout: 10 * time.Millisecond, SpoolDir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("NewDispatcher: %v", err)
+		}
+		if err := d.Enqueue(ctx, testEntry(t, 1)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		if err := d.Enqueue(ctx, testEntry(t, 2)); err == nil {
+			t.Fatalf("expected fail-closed Enqueue to fail on a full queue")
+		}
+	})
+
+	t.Run("FailClosedRejectsWhenSpoolOverflows", func(t *testing.T) {
+		sink := &fakeSink{fail: true}
+		d, err := NewDispatcher(sink, DispatcherConfig{Policy: PolicyFailClosed, BatchSize: 1, FlushInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond, SpoolDir: t.TempDir(), SpoolMaxBytes: 1})
+		if err != nil {
+			t.Fatalf("NewDispatcher: %v", err)
+		}
+		go d.Run(ctx)
+		if err := d.Enqueue(ctx, testEntry(t, 1)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		waitFor(t, func() bool { return d.Stats().Spooled == 1 })
+		if err := d.Enqueue(ctx, testEntry(t, 2)); err == nil {
+			t.Fatalf("expected fail-closed Enqueue to fail once the spool is full")
+		}
+
+		// Once the destination recovers the spool drains and entries are accepted again.
+		sink.setFail(false)
+		waitFor(t, func() bool { return d.Enqueue(ctx, testEntry(t, 3)) == nil })
+		closeDispatcher(t, d)
+		if ids := sink.requestIDs(); len(ids) != 2 || ids[0] != "req-1" || ids[1] != "req-3" {
+			t.Fatalf("unexpected delivery: %v", ids)
+		}
+	})
+
+	t.Run("FailClosedRecoversFromSpoolWriteError", func(t *testing.T) {
+		sink := &fakeSink{fail: true}
+		dir := filepath.Join(t.TempDir(), "spool")
+		d, err := NewDispatcher(sink, DispatcherConfig{Policy: PolicyFailClosed, BatchSize: 1, FlushInterval: 5 * time.Millisecond, RetryInterval: 5 * time.Millisecond, SpoolDir: dir})
+		if err != nil {
+			t.Fatalf("NewDispatcher: %v", err)
+		}
+		// Without its directory the spool cannot write the next segment.
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("remove spool dir: %v", err)
+		}
+		go d.Run(ctx)
+		if err := d.Enqueue(ctx, testEntry(t, 1)); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		waitFor(t, func() bool { return d.degraded.Load() })
+		if err := d.Enqueue(ctx, testEntry(t, 2)); err == nil {
+			t.Fatalf("expected Enqueue to fail while the spool cannot store entries")
+		}
+
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatalf("recreate spool dir: %v", err)
+		}
+		waitFor(t, func() bool { return d.Enqueue(ctx, testEntry(t, 3)) == nil })
+		sink.setFail(false)
+		waitFor(t, func() bool { return len(sink.requestIDs()) == 2 })
+		closeDispatcher(t, d)
+		if ids := sink.requestIDs(); len(ids) != 2 || ids[0] != "req-1" || ids[1] != "req-3" {
+			t.Fatalf("expected the accepted entries in order, got %v", ids)
+		}
+		if st := d.Stats(); st.Dropped != 0 {
+			t.Fatalf("expected no dropped entries, got %+v", st)
+		}
+	})
+
+	t.Run("FailClosedNeedsSpool", func(t *testing.T) {
+		if _, err := NewDispatcher(&fakeSink{}, DispatcherConfig{Policy: PolicyFailClosed}); err == nil {
+			t.Fatalf("expected fail-closed dispatcher without a spool dir to be rejected")
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultFileMaxBytes   = 100 << 20
+	DefaultFileMaxBackups = 5
+)
+
+type FileConfig struct {
+	Path string
+	// MaxBytes rotates the file before a write would take it past this size.
+	MaxBytes int64
+	// MaxBackups is how many rotated files (Path.1 … Path.N) are kept.
+	MaxBackups int
+}
+
+// FileSink appends entries as JSON lines and rotates the file by size.
+type FileSink struct {
+	cfg  FileConfig
+	f    *os.File
+	size int64
+}
+
+func NewFileSink(cfg FileConfig) (*FileSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit file sink path is required")
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultFileMaxBytes
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = DefaultFileMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("create audit file dir: %w", err)
+	}
+	return &FileSink{cfg: cfg}, nil
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Write(_ context.Context, entries []Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode audit entry: %w", err)
+		}
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.size > 0 && s.size+int64(buf.Len()) > s.cfg.MaxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write audit file: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("sync audit file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	if s.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat audit file: %w", err)
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts Path.N-1 … Path.1 up by one, moves Path to Path.1 and reopens
+// an empty Path. The oldest backup is overwritten.
+func (s *FileSink) rotate() error {
+	if err := s.Close(); err != nil {
+		return fmt.Errorf("close audit file: %w", err)
+	}
+	for i := s.cfg.MaxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", s.cfg.Path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", s.cfg.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate audit file: %w", err)
+		}
+	}
+	if err := os.Rename(s.cfg.Path, s.cfg.Path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotate audit file: %w", err)
+	}
+	return s.open()
+}
@@ -0,0 +1,184 @@
+// Package auditsink forwards audit records to destinations outside the
+// database: a rotating JSONL file, syslog and an HTTP webhook. Each sink sits
+// behind a Dispatcher that batches entries asynchronously and spools them to
+// disk while the destination is unavailable. The database stays the
+// authoritative copy; sinks only ever receive records after they are stored.
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry kinds, one per audit table.
+const (
+	KindDownstreamRequest = "downstream_request"
+	KindUpstreamAttempt   = "upstream_attempt"
+	KindAuditEvent        = "audit_event"
+)
+
+// Entry is one audit record as delivered to a sink. Record holds the JSON
+// encoding of the stored model.
+type Entry struct {
+	Kind      string          `json:"kind"`
+	RequestID string          `json:"request_id"`
+	Time      time.Time       `json:"time"`
+	Record    json.RawMessage `json:"record"`
+}
+
+// NewEntry encodes record into an Entry.
+func NewEntry(kind, requestID string, at time.Time, record any) (Entry, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return Entry{}, fmt.Errorf("encode %s record: %w", kind, err)
+	}
+	return Entry{Kind: kind, RequestID: requestID, Time: at.UTC(), Record: raw}, nil
+}
+
+// Sink delivers batches of entries to one destination. Write is only called
+// from the dispatcher's goroutine and should return an error when any entry in
+// the batch may not have been delivered, so the batch is spooled and retried.
+// Errors that retrying cannot fix are wrapped in a PermanentError.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// PermanentError reports a batch the destination will never accept as sent,
+// such as a 4xx webhook response or an entry too large to deliver. The
+// dispatcher does not spool it; it retries the entries one at a time and
+// counts the ones still refused as rejected.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func permanent(err error) error { return &PermanentError{Err: err} }
+
+// IsPermanent reports whether err wraps a PermanentError.
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// truncatedFieldsKey lists the record fields fitEntry replaced.
+const truncatedFieldsKey = "truncated_fields"
+
+// fitEntry returns e with a JSON encoding of at most max bytes, replacing the
+// largest top-level fields of the record, such as a request body, with a
+// "[truncated N bytes]" placeholder and listing them under truncated_fields.
+// It returns a PermanentError when the entry cannot be made small enough.
+// max <= 0 disables the limit.
+func fitEntry(e Entry, max int) (Entry, error) {
+	if max <= 0 {
+		return e, nil
+	}
+	size, err := entrySize(e)
+	if err != nil || size <= max {
+		return e, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Record, &fields); err != nil {
+		return e, permanent(fmt.Errorf("audit entry of %d bytes exceeds %d bytes", size, max))
+	}
+	var truncated []string
+	for size > max {
+		name := largestField(fields, truncated)
+		if name == "" {
+			break
+		}
+		placeholder, _ := json.Marshal(fmt.Sprintf("[truncated %d bytes]", len(fields[name])))
+		if len(placeholder) >= len(fields[name]) {
+			break
+		}
+		fields[name] = placeholder
+		truncated = append(truncated, name)
+		fields[truncatedFieldsKey], _ = json.Marshal(truncated)
+		if e.Record, err = json.Marshal(fields); err != nil {
+			return e, permanent(fmt.Errorf("encode truncated audit record: %w", err))
+		}
+		if size, err = entrySize(e); err != nil {
+			return e, err
+		}
+	}
+	if size > max {
+		return e, permanent(fmt.Errorf("audit entry of %d bytes exceeds %d bytes after truncation", size, max))
+	}
+	return e, nil
+}
+
+// largestField returns the largest field not already truncated, preferring
+// the first name in sorted order on ties so results are stable.
+func largestField(fields map[string]json.RawMessage, skip []string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best := ""
+	for _, name := range names {
+		if name == truncatedFieldsKey || containsString(skip, name) {
+			continue
+		}
+		if best == "" || len(fields[name]) > len(fields[best]) {
+			best = name
+		}
+	}
+	return best
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func entrySize(e Entry) (int, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, permanent(fmt.Errorf("encode audit entry: %w", err))
+	}
+	return len(b), nil
+}
+
+// Enqueuer accepts entries for asynchronous delivery. Dispatcher implements it.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, e Entry) error
+}
+
+// Policy decides what happens to a request when its audit records cannot be
+// handed to a sink.
+type Policy string
+
+const (
+	// PolicyBestEffort drops entries the sink cannot keep up with and never
+	// fails the request.
+	PolicyBestEffort Policy = "best_effort"
+	// PolicyFailClosed makes Enqueue return an error when the queue stays full
+	// or the spool has overflowed, so the request is rejected like a failed
+	// database write.
+	PolicyFailClosed Policy = "fail_closed"
+)
+
+// ParsePolicy accepts best_effort or fail_closed; empty means best_effort.
+func ParsePolicy(v string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(v))); p {
+	case "", PolicyBestEffort:
+		return PolicyBestEffort, nil
+	case PolicyFailClosed:
+		return PolicyFailClosed, nil
+	default:
+		return "", fmt.Errorf("invalid audit sink policy %q (expected %s or %s)", v, PolicyBestEffort, PolicyFailClosed)
+	}
+}
@@ -0,0 +1,274 @@
+package auditsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for in, want := range map[string]Policy{"": PolicyBestEffort, "best_effort": PolicyBestEffort, "FAIL_CLOSED": PolicyFailClosed} {
+		got, err := ParsePolicy(in)
+		if err != nil || got != want {
+			t.Fatalf("ParsePolicy(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Fatalf("expected invalid policy to be rejected")
+	}
+}
+
+func TestFileSink_WritesJSONLinesAndRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	sink, err := NewFileSink(FileConfig{Path: path, MaxBytes: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close()
+
+	for i := 1; i <= 6; i++ {
+		if err := sink.Write(context.Background(), []Entry{testEntry(t, i)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	var lines []Entry
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("open %s: %v", p, err)
+		}
+		info, _ := f.Stat()
+		if info.Size() > 200 {
+			t.Fatalf("%s is %d bytes, over the rotation limit", p, info.Size())
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e Entry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Fatalf("decode %s: %v", p, err)
+			}
+			lines = append(lines, e)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 backups, stat .3: %v", err)
+	}
+	if n := len(lines); n == 0 || lines[n-1].RequestID != "req-6" {
+		t.Fatalf("expected the newest entry last, got %+v", lines)
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i].Time.Before(lines[i-1].Time) {
+			t.Fatalf("entries out of order across rotated files")
+		}
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(SyslogConfig{Address: "udp://" + pc.LocalAddr().String(), Hostname: "relay-1"})
+	if err != nil {
+		t.Fatalf("NewSyslogSink: %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), []Entry{testEntry(t, 1)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	msg := string(buf[:n])
+	want := "<134>1 2026-03-01T00:00:01.000000Z relay-1 jimeng-relay " + strconv.Itoa(os.Getpid()) + " audit_event - "
+	if !strings.HasPrefix(msg, want) {
+		t.Fatalf("unexpected syslog header:\n got %q\nwant %q", msg, want)
+	}
+	var e Entry
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msg, want)), &e); err != nil || e.RequestID != "req-1" {
+		t.Fatalf("unexpected syslog body %q: %v", msg, err)
+	}
+}
+
+func TestSyslogSink_TruncatesOversizedEntries(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(SyslogConfig{Address: "udp://" + pc.LocalAddr().String(), MaxMessageBytes: 1024})
+	if err != nil {
+		t.Fatalf("NewSyslogSink: %v", err)
+	}
+	defer sink.Close()
+	big, err := NewEntry(KindDownstreamRequest, "req-big", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), map[string]any{
+		"id":   "dreq_1",
+		"body": map[string]any{"binary_data_base64": []string{strings.Repeat("A", 100<<10)}},
+	})
+	if err != nil {
+		t.Fatalf("NewEntry: %v", err)
+	}
+	if err := sink.Write(context.Background(), []Entry{big}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 64<<10)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if n > 1024 {
+		t.Fatalf("expected at most 1024 bytes, got %d", n)
+	}
+	var e Entry
+	if err := json.Unmarshal(buf[strings.Index(string(buf[:n]), "{"):n], &e); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var record struct {
+		ID              string   `json:"id"`
+		Body            string   `json:"body"`
+		TruncatedFields []string `json:"truncated_fields"`
+	}
+	if err := json.Unmarshal(e.Record, &record); err != nil {
+		t.Fatalf("decode record: %v", err)
+	}
+	if record.ID != "dreq_1" || !strings.HasPrefix(record.Body, "[truncated ") || len(record.TruncatedFields) != 1 || record.TruncatedFields[0] != "body" {
+		t.Fatalf("unexpected truncated record: %+v", record)
+	}
+
+	// An entry that cannot be made to fit is refused for good.
+	tiny, err := NewSyslogSink(SyslogConfig{Address: "udp://" + pc.LocalAddr().String(), MaxMessageBytes: 100})
+	if err != nil {
+		t.Fatalf("NewSyslogSink: %v", err)
+	}
+	defer tiny.Close()
+	if err := tiny.Write(context.Background(), []Entry{testEntry(t, 1)}); !IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestSyslogSink_TCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for len(msgs) < 2 {
+			lenStr, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(lenStr))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+
+	sink, err := NewSyslogSink(SyslogConfig{Address: "tcp://" + ln.Addr().String(), AppName: "audit"})
+	if err != nil {
+		t.Fatalf("NewSyslogSink: %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), []Entry{testEntry(t, 1), testEntry(t, 2)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	select {
+	case msgs := <-received:
+		if len(msgs) != 2 || !strings.Contains(msgs[0], " audit ") || !strings.Contains(msgs[1], `"request_id":"req-2"`) {
+			t.Fatalf("unexpected framed messages: %q", msgs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for syslog messages")
+	}
+}
+
+func TestNewSyslogSink_RejectsBadAddress(t *testing.T) {
+	for _, addr := range []string{"", "localhost:514", "http://localhost", "udp://", "unix://"} {
+		if _, err := NewSyslogSink(SyslogConfig{Address: addr}); err == nil {
+			t.Fatalf("expected %q to be rejected", addr)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got []Entry
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := NewWebhookSink(WebhookConfig{URL: srv.URL, Token: "s3cret"})
+	if err != nil {
+		t.Fatalf("NewWebhookSink: %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), []Entry{testEntry(t, 1), testEntry(t, 2)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(got) != 2 || got[1].RequestID != "req-2" || got[0].Kind != KindAuditEvent {
+		t.Fatalf("unexpected webhook payload: %+v", got)
+	}
+
+	for _, tc := range []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusUnprocessableEntity, true},
+	} {
+		status = tc.status
+		err := sink.Write(context.Background(), []Entry{testEntry(t, 3)})
+		if err == nil || IsPermanent(err) != tc.permanent {
+			t.Fatalf("status %d: expected failure with permanent=%v, got %v", tc.status, tc.permanent, err)
+		}
+	}
+
+	if _, err := NewWebhookSink(WebhookConfig{URL: "ftp://example.com"}); err == nil {
+		t.Fatalf("expected a non-http url to be rejected")
+	}
+}
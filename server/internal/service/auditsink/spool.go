@@ -0,0 +1,176 @@
+package auditsink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const spoolExt = ".jsonl"
+
+// spool keeps batches a sink failed to accept as numbered JSONL segment files,
+// replayed oldest first. Segments survive restarts.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	segments []string
+	sizes    map[string]int64
+	size     int64
+	next     uint64
+}
+
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes, sizes: map[string]int64{}, next: 1}
+	for _, n := range names {
+		name := n.Name()
+		if n.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := n.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat spool segment: %w", err)
+		}
+		s.segments = append(s.segments, name)
+		s.sizes[name] = info.Size()
+		s.size += info.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Strings(s.segments)
+	return s, nil
+}
+
+func (s *spool) pending() int { return len(s.segments) }
+
+// full reports whether the spool has reached its size limit.
+func (s *spool) full() bool { return s.maxBytes > 0 && s.size >= s.maxBytes }
+
+// append writes entries as a new segment. The file is written under a
+// temporary name and renamed so a crash never leaves a partial segment.
+func (s *spool) append(entries []Entry) error {
+	data, err := encodeSegment(entries)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d%s", s.next, spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("commit spool segment: %w", err)
+	}
+	s.next++
+	s.segments = append(s.segments, name)
+	s.sizes[name] = int64(len(data))
+	s.size += int64(len(data))
+	return nil
+}
+
+// rewrite replaces a segment's entries with the ones still to be delivered,
+// keeping its place in the order.
+func (s *spool) rewrite(name string, entries []Entry) error {
+	data, err := encodeSegment(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return fmt.Errorf("commit spool segment: %w", err)
+	}
+	s.size += int64(len(data)) - s.sizes[name]
+	s.sizes[name] = int64(len(data))
+	return nil
+}
+
+func encodeSegment(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, fmt.Errorf("encode spool entry: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// oldest returns the name and entries of the oldest segment.
+func (s *spool) oldest() (string, []Entry, error) {
+	name := s.segments[0]
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return name, nil, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 64<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return name, nil, fmt.Errorf("decode spool segment %s: %w", name, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return name, nil, fmt.Errorf("read spool segment %s: %w", name, err)
+	}
+	return name, entries, nil
+}
+
+// remove deletes a delivered segment.
+func (s *spool) remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove spool segment: %w", err)
+	}
+	s.drop(name)
+	return nil
+}
+
+// quarantine moves an unreadable segment aside so replay can continue.
+func (s *spool) quarantine(name string) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.Rename(path, path+".corrupt"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("quarantine spool segment: %w", err)
+	}
+	s.drop(name)
+	return nil
+}
+
+func (s *spool) drop(name string) {
+	for i, n := range s.segments {
+		if n == name {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+}
@@ -0,0 +1,203 @@
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSyslogAppName = "jimeng-relay"
+	// DefaultSyslogFacility is local0.
+	DefaultSyslogFacility = 16
+	// DefaultSyslogMaxMessageBytes matches the default message size limit of
+	// common syslog daemons and fits in one UDP datagram.
+	DefaultSyslogMaxMessageBytes = 8 << 10
+	syslogSeverityInfo           = 6
+	syslogTimeFormat             = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+type SyslogConfig struct {
+	// Address is udp://host:port, tcp://host:port or unix:///path/to/socket.
+	Address  string
+	AppName  string
+	Hostname string
+	Facility int
+	// MaxMessageBytes caps each message, header included. Larger entries have
+	// their biggest record fields truncated. Zero means
+	// DefaultSyslogMaxMessageBytes; datagram transports allow at most 65000.
+	MaxMessageBytes int
+}
+
+// SyslogSink sends each entry as an RFC 5424 message whose MSG is the entry's
+// JSON. TCP uses octet-counting framing (RFC 6587); UDP and unix datagram
+// sockets send one message per datagram.
+type SyslogSink struct {
+	network  string
+	addr     string
+	appName  string
+	hostname string
+	pri      int
+	procID   string
+	maxBytes int
+	conn     net.Conn
+}
+
+const maxSyslogDatagramBytes = 65000
+
+func NewSyslogSink(cfg SyslogConfig) (*SyslogSink, error) {
+	network, addr, err := parseSyslogAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	s := &SyslogSink{
+		network:  network,
+		addr:     addr,
+		appName:  syslogField(cfg.AppName, DefaultSyslogAppName, 48),
+		hostname: cfg.Hostname,
+		pri:      DefaultSyslogFacility*8 + syslogSeverityInfo,
+		procID:   strconv.Itoa(os.Getpid()),
+		maxBytes: cfg.MaxMessageBytes,
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = DefaultSyslogMaxMessageBytes
+	}
+	if s.network != "tcp" && s.maxBytes > maxSyslogDatagramBytes {
+		return nil, fmt.Errorf("syslog max message size %d exceeds %d bytes for %s", s.maxBytes, maxSyslogDatagramBytes, s.network)
+	}
+	if cfg.Facility > 0 {
+		if cfg.Facility > 23 {
+			return nil, fmt.Errorf("invalid syslog facility %d", cfg.Facility)
+		}
+		s.pri = cfg.Facility*8 + syslogSeverityInfo
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	s.hostname = syslogField(s.hostname, "-", 255)
+	return s, nil
+}
+
+func parseSyslogAddress(raw string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("invalid syslog address %q: missing host", raw)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog address %q: missing socket path", raw)
+		}
+		// Local syslog daemons listen on datagram sockets.
+		return "unixgram", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("invalid syslog address %q (expected udp://, tcp:// or unix://)", raw)
+	}
+}
+
+func (s *SyslogSink) Name() string { return "syslog" }
+
+func (s *SyslogSink) Write(ctx context.Context, entries []Entry) error {
+	// Format everything first so an entry that cannot fit fails the batch
+	// before any of it is sent.
+	msgs := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		msg, err := s.format(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := s.dial(ctx); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+	for _, msg := range msgs {
+		if s.network == "tcp" {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if _, err := s.conn.Write(msg); err != nil {
+			// Reconnect on the next batch; a stream may be half written.
+			_ = s.Close()
+			return fmt.Errorf("write syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogSink) dial(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("dial syslog: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// format renders <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG,
+// truncating the entry to keep the message within the size limit.
+func (s *SyslogSink) format(e Entry) ([]byte, error) {
+	header := s.header(e)
+	fitted, err := fitEntry(e, s.maxBytes-len(header))
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(fitted)
+	if err != nil {
+		return nil, permanent(fmt.Errorf("encode audit entry: %w", err))
+	}
+	return append(header, body...), nil
+}
+
+func (s *SyslogSink) header(e Entry) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s - ",
+		s.pri,
+		e.Time.UTC().Format(syslogTimeFormat),
+		s.hostname,
+		s.appName,
+		s.procID,
+		syslogField(e.Kind, "-", 32),
+	)
+	return b.Bytes()
+}
+
+// syslogField keeps printable US-ASCII as RFC 5424 requires for header fields.
+func syslogField(v, fallback string, max int) string {
+	out := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(out) < max; i++ {
+		if c := v[i]; c > 32 && c < 127 {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return fallback
+	}
+	return string(out)
+}
@@ -0,0 +1,103 @@
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultWebhookTimeout = 5 * time.Second
+	// DefaultWebhookMaxEntryBytes caps one entry in a webhook batch.
+	DefaultWebhookMaxEntryBytes = 1 << 20
+)
+
+type WebhookConfig struct {
+	URL string
+	// Token is sent as "Authorization: Bearer <token>" when set.
+	Token   string
+	Timeout time.Duration
+	// MaxEntryBytes caps each entry; larger ones have their biggest record
+	// fields truncated. Zero means DefaultWebhookMaxEntryBytes.
+	MaxEntryBytes int
+	Client        *http.Client
+}
+
+// WebhookSink POSTs each batch as a JSON array of entries. A 5xx, 408 or 429
+// response or a network error fails the batch so it is spooled and retried;
+// any other non-2xx response is permanent. Receivers should de-duplicate on
+// the record's id.
+type WebhookSink struct {
+	url      string
+	token    string
+	maxBytes int
+	client   *http.Client
+}
+
+func NewWebhookSink(cfg WebhookConfig) (*WebhookSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid audit webhook url %q", cfg.URL)
+	}
+	client := cfg.Client
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = DefaultWebhookTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	maxBytes := cfg.MaxEntryBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultWebhookMaxEntryBytes
+	}
+	return &WebhookSink{url: u.String(), token: cfg.Token, maxBytes: maxBytes, client: client}, nil
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Write(ctx context.Context, entries []Entry) error {
+	fitted := make([]Entry, len(entries))
+	for i, e := range entries {
+		var err error
+		if fitted[i], err = fitEntry(e, s.maxBytes); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(fitted)
+	if err != nil {
+		return permanent(fmt.Errorf("encode audit entries: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build audit webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post audit webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	default:
+		return permanent(fmt.Errorf("audit webhook returned %s", resp.Status))
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,286 @@
+package authtoken
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const (
+	ScopeSubmit    = "submit"
+	ScopeGetResult = "get-result"
+
+	// MinSigningKeyBytes is the shortest HMAC key accepted for signing tokens.
+	MinSigningKeyBytes = 32
+
+	defaultTTL    = 15 * time.Minute
+	defaultMaxTTL = time.Hour
+	issuer        = "jimeng-relay"
+)
+
+// AllScopes lists every scope a token may carry, in canonical order.
+var AllScopes = []string{ScopeSubmit, ScopeGetResult}
+
+type Config struct {
+	Now    func() time.Time
+	Random io.Reader
+	// SigningKey is the HMAC-SHA256 key for issued tokens. Every replica must
+	// share it for tokens to be accepted cluster-wide.
+	SigningKey []byte
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+// Service issues and verifies short-lived bearer tokens (HS256 JWTs) derived
+// from an API key. A token is only as good as its parent key: verification
+// re-reads the key, so revoking or expiring it invalidates outstanding tokens.
+type Service struct {
+	keys       repository.APIKeyRepository
+	now        func() time.Time
+	random     io.Reader
+	signingKey []byte
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+type IssueRequest struct {
+	Scopes []string
+	TTL    time.Duration
+}
+
+type Token struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	APIKeyID  string    `json:"api_key_id"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Claims struct {
+	ID        string
+	APIKeyID  string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// ClientCertSubject is the parent key's client certificate binding at
+	// verification time; it is not part of the token itself.
+	ClientCertSubject string
+}
+
+// HasScope reports whether the token grants scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func NewService(keys repository.APIKeyRepository, cfg Config) (*Service, error) {
+	if len(cfg.SigningKey) < MinSigningKeyBytes {
+		return nil, internalerrors.New(internalerrors.ErrValidationFailed, "token signing key must be at least 32 bytes", nil)
+	}
+	nowFn := cfg.Now
+	if nowFn == nil {
+		nowFn = func() time.Time { return time.Now().UTC() }
+	}
+	rnd := cfg.Random
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	maxTTL := cfg.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+	ttl := cfg.DefaultTTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return &Service{
+		keys:       keys,
+		now:        nowFn,
+		random:     rnd,
+		signingKey: append([]byte(nil), cfg.SigningKey...),
+		defaultTTL: ttl,
+		maxTTL:     maxTTL,
+	}, nil
+}
+
+// Issue mints a token for apiKeyID. An empty scope list grants every scope; the
+// expiry never extends past the parent key's own expiry.
+func (s *Service) Issue(ctx context.Context, apiKeyID string, req IssueRequest) (Token, error) {
+	apiKeyID = strings.TrimSpace(apiKeyID)
+	if apiKeyID == "" {
+		return Token{}, internalerrors.New(internalerrors.ErrAuthFailed, "missing api key", nil)
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return Token{}, err
+	}
+	ttl := req.TTL
+	if ttl < 0 {
+		return Token{}, internalerrors.New(internalerrors.ErrValidationFailed, "ttl must be positive", nil)
+	}
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl > s.maxTTL {
+		return Token{}, internalerrors.New(internalerrors.ErrValidationFailed, "ttl exceeds the allowed maximum of "+s.maxTTL.String(), nil)
+	}
+
+	now := s.now().UTC().Truncate(time.Second)
+	key, err := s.activeKey(ctx, apiKeyID, now)
+	if err != nil {
+		return Token{}, err
+	}
+	expiresAt := now.Add(ttl)
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(expiresAt) {
+		expiresAt = key.ExpiresAt.UTC().Truncate(time.Second)
+	}
+	jti := make([]byte, 16)
+	if _, err := io.ReadFull(s.random, jti); err != nil {
+		return Token{}, internalerrors.New(internalerrors.ErrInternalError, "generate token id", err)
+	}
+	payload, err := json.Marshal(jwtClaims{
+		Issuer:    issuer,
+		Subject:   key.ID,
+		Scope:     strings.Join(scopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		ID:        hex.EncodeToString(jti),
+	})
+	if err != nil {
+		return Token{}, internalerrors.New(internalerrors.ErrInternalError, "encode token claims", err)
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return Token{
+		Token:     signingInput + "." + s.sign(signingInput),
+		TokenType: "Bearer",
+		APIKeyID:  key.ID,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Verify checks the token signature and expiry, then confirms the parent key
+// is still active.
+func (s *Service) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return Claims{}, internalerrors.New(internalerrors.ErrAuthFailed, "malformed bearer token", nil)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0]+"."+parts[1]))) {
+		return Claims{}, internalerrors.New(internalerrors.ErrInvalidSignature, "bearer token signature mismatch", nil)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, internalerrors.New(internalerrors.ErrAuthFailed, "malformed bearer token", err)
+	}
+	var jc jwtClaims
+	if err := json.Unmarshal(payload, &jc); err != nil {
+		return Claims{}, internalerrors.New(internalerrors.ErrAuthFailed, "malformed bearer token", err)
+	}
+	if jc.Issuer != issuer || strings.TrimSpace(jc.Subject) == "" {
+		return Claims{}, internalerrors.New(internalerrors.ErrAuthFailed, "bearer token was not issued by this relay", nil)
+	}
+	claims := Claims{
+		ID:        jc.ID,
+		APIKeyID:  jc.Subject,
+		Scopes:    strings.Fields(jc.Scope),
+		IssuedAt:  time.Unix(jc.IssuedAt, 0).UTC(),
+		ExpiresAt: time.Unix(jc.ExpiresAt, 0).UTC(),
+	}
+	now := s.now().UTC()
+	if !now.Before(claims.ExpiresAt) {
+		return Claims{}, internalerrors.New(internalerrors.ErrAuthFailed, "bearer token has expired", nil)
+	}
+	key, err := s.activeKey(ctx, claims.APIKeyID, now)
+	if err != nil {
+		return Claims{}, err
+	}
+	claims.ClientCertSubject = key.ClientCertSubject
+	return claims, nil
+}
+
+func (s *Service) activeKey(ctx context.Context, id string, now time.Time) (models.APIKey, error) {
+	key, err := s.keys.GetByID(ctx, id)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return models.APIKey{}, internalerrors.New(internalerrors.ErrAuthFailed, "api key not found", err)
+		}
+		return models.APIKey{}, internalerrors.New(internalerrors.ErrDatabaseError, "get api key", err)
+	}
+	if key.IsRevoked() {
+		return models.APIKey{}, internalerrors.New(internalerrors.ErrKeyRevoked, "api key is revoked", nil)
+	}
+	if key.Status == models.APIKeyStatusExpired || (key.ExpiresAt != nil && !key.ExpiresAt.UTC().After(now)) {
+		return models.APIKey{}, internalerrors.New(internalerrors.ErrKeyExpired, "api key is expired", nil)
+	}
+	return key, nil
+}
+
+func (s *Service) sign(signingInput string) string {
+	h := hmac.New(sha256.New, s.signingKey)
+	_, _ = h.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return append([]string(nil), AllScopes...), nil
+	}
+	want := map[string]bool{}
+	for _, s := range requested {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		known := false
+		for _, k := range AllScopes {
+			if s == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, internalerrors.New(internalerrors.ErrValidationFailed, "unknown scope: "+s, nil)
+		}
+		want[s] = true
+	}
+	if len(want) == 0 {
+		return append([]string(nil), AllScopes...), nil
+	}
+	out := make([]string, 0, len(want))
+	for _, k := range AllScopes {
+		if want[k] {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package authtoken
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type memoryKeys struct {
+	keys map[string]models.APIKey
+}
+
+func (m *memoryKeys) Create(_ context.Context, key models.APIKey) error {
+	m.keys[key.ID] = key
+	return nil
+}
+
+func (m *memoryKeys) GetByID(_ context.Context, id string) (models.APIKey, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return models.APIKey{}, repository.ErrNotFound
+	}
+	return key, nil
+}
+
+func (m *memoryKeys) GetByAccessKey(context.Context, string) (models.APIKey, error) {
+	return models.APIKey{}, repository.ErrNotFound
+}
+
+func (m *memoryKeys) List(context.Context) ([]models.APIKey, error) { return nil, nil }
+
+func (m *memoryKeys) Revoke(_ context.Context, id string, revokedAt time.Time) error {
+	key := m.keys[id]
+	key.RevokedAt = &revokedAt
+	key.Status = models.APIKeyStatusRevoked
+	m.keys[id] = key
+	return nil
+}
+
+func (m *memoryKeys) SetExpired(context.Context, string, time.Time) error { return nil }
+
+func (m *memoryKeys) SetExpiresAt(_ context.Context, id string, expiresAt time.Time) error {
+	key := m.keys[id]
+	key.ExpiresAt = &expiresAt
+	m.keys[id] = key
+	return nil
+}
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestService(t *testing.T, now *time.Time) (*Service, *memoryKeys) {
+	t.Helper()
+	keys := &memoryKeys{keys: map[string]models.APIKey{
+		"key_1": {ID: "key_1", AccessKey: "ak_1", Status: models.APIKeyStatusActive},
+	}}
+	svc, err := NewService(keys, Config{Now: func() time.Time { return *now }, SigningKey: testSigningKey})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return svc, keys
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	svc, _ := newTestService(t, &now)
+
+	tok, err := svc.Issue(context.Background(), "key_1", IssueRequest{Scopes: []string{"Get-Result"}})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if tok.TokenType != "Bearer" || !tok.ExpiresAt.Equal(now.Add(defaultTTL)) {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+	claims, err := svc.Verify(context.Background(), tok.Token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.APIKeyID != "key_1" || !claims.HasScope(ScopeGetResult) || claims.HasScope(ScopeSubmit) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	now = now.Add(defaultTTL)
+	if _, err := svc.Verify(context.Background(), tok.Token); internalerrors.GetCode(err) != internalerrors.ErrAuthFailed {
+		t.Fatalf("expected expired token to fail with AUTH_FAILED, got %v", err)
+	}
+}
+
+func TestVerify_RejectsTamperedAndForeignTokens(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	svc, _ := newTestService(t, &now)
+	tok, err := svc.Issue(context.Background(), "key_1", IssueRequest{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	parts := strings.Split(tok.Token, ".")
+	forged := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err := svc.Verify(context.Background(), forged); err == nil {
+		t.Fatalf("expected tampered payload to be rejected")
+	}
+
+	other, err := NewService(&memoryKeys{keys: map[string]models.APIKey{}}, Config{Now: func() time.Time { return now }, SigningKey: []byte("another-signing-key-another-key!")})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	if _, err := other.Verify(context.Background(), tok.Token); internalerrors.GetCode(err) != internalerrors.ErrInvalidSignature {
+		t.Fatalf("expected INVALID_SIGNATURE for a token signed with another key, got %v", err)
+	}
+}
+
+func TestVerify_FailsOnceParentKeyIsRevoked(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	svc, keys := newTestService(t, &now)
+	tok, err := svc.Issue(context.Background(), "key_1", IssueRequest{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if err := keys.Revoke(context.Background(), "key_1", now); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := svc.Verify(context.Background(), tok.Token); internalerrors.GetCode(err) != internalerrors.ErrKeyRevoked {
+		t.Fatalf("expected KEY_REVOKED, got %v", err)
+	}
+	if _, err := svc.Issue(context.Background(), "key_1", IssueRequest{}); internalerrors.GetCode(err) != internalerrors.ErrKeyRevoked {
+		t.Fatalf("expected issuing for a revoked key to fail, got %v", err)
+	}
+}
+
+func TestIssue_ExpiryCappedByParentKey(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	svc, keys := newTestService(t, &now)
+	if err := keys.SetExpiresAt(context.Background(), "key_1", now.Add(5*time.Minute)); err != nil {
+		t.Fatalf("SetExpiresAt: %v", err)
+	}
+
+	tok, err := svc.Issue(context.Background(), "key_1", IssueRequest{TTL: 30 * time.Minute})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !tok.ExpiresAt.Equal(now.Add(5 * time.Minute)) {
+		t.Fatalf("expected token to expire with its key, got %v", tok.ExpiresAt)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if _, err := svc.Verify(context.Background(), tok.Token); err == nil {
+		t.Fatalf("expected token to die with its key")
+	}
+}
+
+func TestIssue_ValidatesRequest(t *testing.T) {
+	now := time.Date(2026, 2, 24, 10, 0, 0, 0, time.UTC)
+	svc, _ := newTestService(t, &now)
+
+	if _, err := svc.Issue(context.Background(), "key_1", IssueRequest{Scopes: []string{"admin"}}); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected unknown scope to fail validation, got %v", err)
+	}
+	if _, err := svc.Issue(context.Background(), "key_1", IssueRequest{TTL: 2 * time.Hour}); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected ttl above max to fail validation, got %v", err)
+	}
+	if _, err := NewService(&memoryKeys{}, Config{SigningKey: []byte("short")}); err == nil {
+		t.Fatalf("expected short signing key to be rejected")
+	}
+}
@@ -0,0 +1,157 @@
+// Package dbcopy copies relay data from one database to another, e.g. when a
+// deployment moves from sqlite to Postgres.
+package dbcopy
+
+import (
+	"context"
+	"fmt"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const DefaultBatchSize = 500
+
+type Config struct {
+	// BatchSize is how many rows are read and inserted at a time. Defaults
+	// to DefaultBatchSize.
+	BatchSize int
+}
+
+// TableReport is the outcome of copying one table.
+type TableReport struct {
+	Table string `json:"table"`
+	// SourceRows and DestinationRows are counted after the copy.
+	SourceRows      int64 `json:"source_rows"`
+	DestinationRows int64 `json:"destination_rows"`
+	// Copied rows were inserted by this run; Skipped rows already existed in
+	// the destination, e.g. from an interrupted earlier run.
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+}
+
+type Report struct {
+	Tables []TableReport `json:"tables"`
+}
+
+type Service struct {
+	src       repository.BulkRepository
+	dst       repository.BulkRepository
+	batchSize int
+}
+
+func NewService(src, dst repository.BulkRepository, cfg Config) (*Service, error) {
+	if src == nil || dst == nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "source and destination repositories are required", nil)
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Service{src: src, dst: dst, batchSize: batchSize}, nil
+}
+
+// Copy streams every table in repository.BulkTables order, keeping IDs and
+// timestamps. Rows already in the destination are skipped, so an interrupted
+// copy is resumed by running it again. After each table the row counts of
+// both sides must match; a mismatch stops the copy with an error alongside
+// the report so far.
+func (s *Service) Copy(ctx context.Context) (Report, error) {
+	report := Report{Tables: make([]TableReport, 0, len(repository.BulkTables))}
+	for _, table := range repository.BulkTables {
+		var read, inserted int64
+		var err error
+		switch table {
+		case repository.TableAPIKeys:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportAPIKeys, s.dst.ImportAPIKeys, func(k models.APIKey) string { return k.ID })
+		case repository.TableDownstreamRequests:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportDownstreamRequests, s.dst.ImportDownstreamRequests, func(r models.DownstreamRequest) string { return r.ID })
+		case repository.TableUpstreamAttempts:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportUpstreamAttempts, s.dst.ImportUpstreamAttempts, func(a models.UpstreamAttempt) string { return a.ID })
+		case repository.TableAuditEvents:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportAuditEvents, s.dst.ImportAuditEvents, func(e models.AuditEvent) string { return e.ID })
+		case repository.TableAuditCheckpoints:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportAuditCheckpoints, s.dst.ImportAuditCheckpoints, func(c models.AuditCheckpoint) string { return c.ID })
+		case repository.TableIdempotencyRecords:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportIdempotencyRecords, s.dst.ImportIdempotencyRecords, func(r models.IdempotencyRecord) string { return r.ID })
+		case repository.TableAPIKeyDailyRequests:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportAPIKeyDailyRequests, s.dst.ImportAPIKeyDailyRequests, func(c models.APIKeyDailyRequests) repository.APIKeyDayKey {
+				return repository.APIKeyDayKey{APIKeyID: c.APIKeyID, Day: c.Day}
+			})
+		case repository.TableStatsRollups:
+			read, inserted, err = copyTable(ctx, s.batchSize, s.src.ExportStatsRollups, s.dst.ImportStatsRollups, func(r models.StatsRollup) repository.StatsRollupKey {
+				return repository.StatsRollupKey{Hour: r.Hour, APIKeyID: r.APIKeyID, Action: string(r.Action), StatusClass: r.StatusClass}
+			})
+		case repository.TableStatsRollupWatermark:
+			read, inserted, err = s.copyStatsRollupWatermark(ctx)
+		default:
+			err = fmt.Errorf("no copier for table %s", table)
+		}
+		if err != nil {
+			return report, internalerrors.New(internalerrors.ErrDatabaseError, "copy "+table, err)
+		}
+
+		tr := TableReport{Table: table, Copied: inserted, Skipped: read - inserted}
+		if tr.SourceRows, err = s.src.CountRows(ctx, table); err != nil {
+			return report, err
+		}
+		if tr.DestinationRows, err = s.dst.CountRows(ctx, table); err != nil {
+			return report, err
+		}
+		report.Tables = append(report.Tables, tr)
+		if tr.SourceRows != tr.DestinationRows {
+			return report, internalerrors.New(internalerrors.ErrDatabaseError, fmt.Sprintf("row count mismatch for %s: source has %d rows, destination has %d", table, tr.SourceRows, tr.DestinationRows), nil)
+		}
+	}
+	return report, nil
+}
+
+// copyStatsRollupWatermark copies the single row of stats_rollup_state, if
+// the source has one.
+func (s *Service) copyStatsRollupWatermark(ctx context.Context) (read, inserted int64, err error) {
+	watermark, err := s.src.ExportStatsRollupWatermark(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read: %w", err)
+	}
+	if watermark.IsZero() {
+		return 0, 0, nil
+	}
+	inserted, err = s.dst.ImportStatsRollupWatermark(ctx, watermark)
+	if err != nil {
+		return 1, 0, fmt.Errorf("write: %w", err)
+	}
+	return 1, inserted, nil
+}
+
+// copyTable pages through the source by key and imports each page, returning
+// how many rows were read and how many the destination inserted. The first
+// page starts after the zero key.
+func copyTable[T any, K any](
+	ctx context.Context,
+	batchSize int,
+	export func(ctx context.Context, after K, limit int) ([]T, error),
+	insert func(ctx context.Context, rows []T) (int64, error),
+	key func(T) K,
+) (read, inserted int64, err error) {
+	var after K
+	for {
+		rows, err := export(ctx, after, batchSize)
+		if err != nil {
+			return read, inserted, fmt.Errorf("read after %v: %w", after, err)
+		}
+		if len(rows) == 0 {
+			return read, inserted, nil
+		}
+		n, err := insert(ctx, rows)
+		if err != nil {
+			return read, inserted, fmt.Errorf("write after %v: %w", after, err)
+		}
+		read += int64(len(rows))
+		inserted += n
+		after = key(rows[len(rows)-1])
+		if len(rows) < batchSize {
+			return read, inserted, nil
+		}
+	}
+}
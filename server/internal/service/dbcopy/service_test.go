@@ -0,0 +1,294 @@
+package dbcopy
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+// fakeBulk keeps rows per table keyed by id; Import skips ids it already has.
+type fakeBulk struct {
+	rows      map[string]map[string]any
+	failTable string
+	exports   map[string]int
+}
+
+func newFakeBulk() *fakeBulk {
+	return &fakeBulk{rows: map[string]map[string]any{}, exports: map[string]int{}}
+}
+
+func (f *fakeBulk) put(table, id string, row any) {
+	if f.rows[table] == nil {
+		f.rows[table] = map[string]any{}
+	}
+	f.rows[table][id] = row
+}
+
+func exportRows[T any](f *fakeBulk, table, afterID string, limit int) ([]T, error) {
+	f.exports[table]++
+	ids := make([]string, 0, len(f.rows[table]))
+	for id := range f.rows[table] {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]T, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, f.rows[table][id].(T))
+	}
+	return out, nil
+}
+
+func importRows[T any](f *fakeBulk, table string, rows []T, id func(T) string) (int64, error) {
+	if table == f.failTable {
+		return 0, errors.New("disk full")
+	}
+	var n int64
+	for _, row := range rows {
+		if _, ok := f.rows[table][id(row)]; ok {
+			continue
+		}
+		f.put(table, id(row), row)
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeBulk) CountRows(_ context.Context, table string) (int64, error) {
+	return int64(len(f.rows[table])), nil
+}
+
+func (f *fakeBulk) ExportAPIKeys(_ context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	return exportRows[models.APIKey](f, repository.TableAPIKeys, afterID, limit)
+}
+
+func (f *fakeBulk) ImportAPIKeys(_ context.Context, rows []models.APIKey) (int64, error) {
+	return importRows(f, repository.TableAPIKeys, rows, func(r models.APIKey) string { return r.ID })
+}
+
+func (f *fakeBulk) ExportDownstreamRequests(_ context.Context, afterID string, limit int) ([]models.DownstreamRequest, error) {
+	return exportRows[models.DownstreamRequest](f, repository.TableDownstreamRequests, afterID, limit)
+}
+
+func (f *fakeBulk) ImportDownstreamRequests(_ context.Context, rows []models.DownstreamRequest) (int64, error) {
+	return importRows(f, repository.TableDownstreamRequests, rows, func(r models.DownstreamRequest) string { return r.ID })
+}
+
+func (f *fakeBulk) ExportUpstreamAttempts(_ context.Context, afterID string, limit int) ([]models.UpstreamAttempt, error) {
+	return exportRows[models.UpstreamAttempt](f, repository.TableUpstreamAttempts, afterID, limit)
+}
+
+func (f *fakeBulk) ImportUpstreamAttempts(_ context.Context, rows []models.UpstreamAttempt) (int64, error) {
+	return importRows(f, repository.TableUpstreamAttempts, rows, func(r models.UpstreamAttempt) string { return r.ID })
+}
+
+func (f *fakeBulk) ExportAuditEvents(_ context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	return exportRows[models.AuditEvent](f, repository.TableAuditEvents, afterID, limit)
+}
+
+func (f *fakeBulk) ImportAuditEvents(_ context.Context, rows []models.AuditEvent) (int64, error) {
+	return importRows(f, repository.TableAuditEvents, rows, func(r models.AuditEvent) string { return r.ID })
+}
+
+func (f *fakeBulk) ExportAuditCheckpoints(_ context.Context, afterID string, limit int) ([]models.AuditCheckpoint, error) {
+	return exportRows[models.AuditCheckpoint](f, repository.TableAuditCheckpoints, afterID, limit)
+}
+
+func (f *fakeBulk) ImportAuditCheckpoints(_ context.Context, rows []models.AuditCheckpoint) (int64, error) {
+	return importRows(f, repository.TableAuditCheckpoints, rows, func(r models.AuditCheckpoint) string { return r.ID })
+}
+
+func (f *fakeBulk) ExportIdempotencyRecords(_ context.Context, afterID string, limit int) ([]models.IdempotencyRecord, error) {
+	return exportRows[models.IdempotencyRecord](f, repository.TableIdempotencyRecords, afterID, limit)
+}
+
+func (f *fakeBulk) ImportIdempotencyRecords(_ context.Context, rows []models.IdempotencyRecord) (int64, error) {
+	return importRows(f, repository.TableIdempotencyRecords, rows, func(r models.IdempotencyRecord) string { return r.ID })
+}
+
+func dayKey(k repository.APIKeyDayKey) string {
+	if k == (repository.APIKeyDayKey{}) {
+		return ""
+	}
+	return k.APIKeyID + "/" + k.Day.Format(time.DateOnly)
+}
+
+func (f *fakeBulk) ExportAPIKeyDailyRequests(_ context.Context, after repository.APIKeyDayKey, limit int) ([]models.APIKeyDailyRequests, error) {
+	return exportRows[models.APIKeyDailyRequests](f, repository.TableAPIKeyDailyRequests, dayKey(after), limit)
+}
+
+func (f *fakeBulk) ImportAPIKeyDailyRequests(_ context.Context, rows []models.APIKeyDailyRequests) (int64, error) {
+	return importRows(f, repository.TableAPIKeyDailyRequests, rows, func(r models.APIKeyDailyRequests) string {
+		return dayKey(repository.APIKeyDayKey{APIKeyID: r.APIKeyID, Day: r.Day})
+	})
+}
+
+func rollupKey(k repository.StatsRollupKey) string {
+	if k == (repository.StatsRollupKey{}) {
+		return ""
+	}
+	return strings.Join([]string{k.Hour.Format(time.RFC3339), k.APIKeyID, k.Action, k.StatusClass}, "/")
+}
+
+func (f *fakeBulk) ExportStatsRollups(_ context.Context, after repository.StatsRollupKey, limit int) ([]models.StatsRollup, error) {
+	return exportRows[models.StatsRollup](f, repository.TableStatsRollups, rollupKey(after), limit)
+}
+
+func (f *fakeBulk) ImportStatsRollups(_ context.Context, rows []models.StatsRollup) (int64, error) {
+	return importRows(f, repository.TableStatsRollups, rows, func(r models.StatsRollup) string {
+		return rollupKey(repository.StatsRollupKey{Hour: r.Hour, APIKeyID: r.APIKeyID, Action: string(r.Action), StatusClass: r.StatusClass})
+	})
+}
+
+func (f *fakeBulk) ExportStatsRollupWatermark(context.Context) (time.Time, error) {
+	f.exports[repository.TableStatsRollupWatermark]++
+	watermark, _ := f.rows[repository.TableStatsRollupWatermark]["1"].(time.Time)
+	return watermark, nil
+}
+
+func (f *fakeBulk) ImportStatsRollupWatermark(_ context.Context, watermark time.Time) (int64, error) {
+	return importRows(f, repository.TableStatsRollupWatermark, []time.Time{watermark}, func(time.Time) string { return "1" })
+}
+
+func seededSource() *fakeBulk {
+	src := newFakeBulk()
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	for _, id := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		src.put(repository.TableAPIKeys, id, models.APIKey{ID: id, AccessKey: "ak-" + id, CreatedAt: created})
+	}
+	src.put(repository.TableDownstreamRequests, "d1", models.DownstreamRequest{ID: "d1", APIKeyID: "k1"})
+	src.put(repository.TableUpstreamAttempts, "u1", models.UpstreamAttempt{ID: "u1", RequestID: "r1"})
+	src.put(repository.TableAuditEvents, "e1", models.AuditEvent{ID: "e1", Sequence: 1, Hash: "h1"})
+	src.put(repository.TableAuditEvents, "e2", models.AuditEvent{ID: "e2", Sequence: 2, PrevHash: "h1", Hash: "h2"})
+	src.put(repository.TableAuditCheckpoints, "c1", models.AuditCheckpoint{ID: "c1", Sequence: 2, Hash: "h2"})
+	src.put(repository.TableIdempotencyRecords, "i1", models.IdempotencyRecord{ID: "i1", IdempotencyKey: "idem"})
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"k1", "k2"} {
+		c := models.APIKeyDailyRequests{APIKeyID: id, Day: day, Requests: 7}
+		src.put(repository.TableAPIKeyDailyRequests, dayKey(repository.APIKeyDayKey{APIKeyID: c.APIKeyID, Day: c.Day}), c)
+	}
+	for _, class := range []string{"2xx", "4xx", "5xx"} {
+		r := models.StatsRollup{Hour: day.Add(12 * time.Hour), APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: class, Attempts: 3}
+		src.put(repository.TableStatsRollups, rollupKey(repository.StatsRollupKey{Hour: r.Hour, APIKeyID: r.APIKeyID, Action: string(r.Action), StatusClass: r.StatusClass}), r)
+	}
+	src.put(repository.TableStatsRollupWatermark, "1", day.Add(13*time.Hour))
+	return src
+}
+
+func TestCopy_CopiesEveryTableInBatches(t *testing.T) {
+	src, dst := seededSource(), newFakeBulk()
+	svc, err := NewService(src, dst, Config{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	report, err := svc.Copy(context.Background())
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if len(report.Tables) != len(repository.BulkTables) {
+		t.Fatalf("expected a report per table, got %+v", report.Tables)
+	}
+	for i, tr := range report.Tables {
+		if tr.Table != repository.BulkTables[i] || tr.SourceRows != tr.DestinationRows || tr.Copied != tr.SourceRows || tr.Skipped != 0 {
+			t.Fatalf("unexpected table report: %+v", tr)
+		}
+	}
+	// Five keys in batches of two: 2, 2, then a short page of 1 ends it.
+	if got := src.exports[repository.TableAPIKeys]; got != 3 {
+		t.Fatalf("expected 3 api key pages, got %d", got)
+	}
+	got := dst.rows[repository.TableAPIKeys]["k5"].(models.APIKey)
+	if got.AccessKey != "ak-k5" || !got.CreatedAt.Equal(time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)) {
+		t.Fatalf("expected the row to be copied verbatim, got %+v", got)
+	}
+	if e := dst.rows[repository.TableAuditEvents]["e2"].(models.AuditEvent); e.Sequence != 2 || e.PrevHash != "h1" {
+		t.Fatalf("expected chain fields to be preserved, got %+v", e)
+	}
+	// Three rollups in batches of two, paged by their composite key.
+	if got := src.exports[repository.TableStatsRollups]; got != 2 {
+		t.Fatalf("expected 2 stats rollup pages, got %d", got)
+	}
+	if got, _ := dst.ExportStatsRollupWatermark(context.Background()); !got.Equal(time.Date(2026, 3, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the rollup watermark to be copied, got %v", got)
+	}
+}
+
+func TestCopy_SkipsEmptyRollupWatermark(t *testing.T) {
+	src, dst := seededSource(), newFakeBulk()
+	delete(src.rows, repository.TableStatsRollupWatermark)
+	svc, err := NewService(src, dst, Config{})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	report, err := svc.Copy(context.Background())
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if last := report.Tables[len(report.Tables)-1]; last.Table != repository.TableStatsRollupWatermark || last.Copied != 0 || last.DestinationRows != 0 {
+		t.Fatalf("expected no watermark to be written, got %+v", last)
+	}
+}
+
+func TestCopy_ResumesAfterFailure(t *testing.T) {
+	src, dst := seededSource(), newFakeBulk()
+	dst.failTable = repository.TableAuditEvents
+	svc, err := NewService(src, dst, Config{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	report, err := svc.Copy(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "audit_events") {
+		t.Fatalf("expected the failing table in the error, got %v", err)
+	}
+	if len(report.Tables) != 4 {
+		t.Fatalf("expected the tables before the failure to be reported, got %+v", report.Tables)
+	}
+
+	dst.failTable = ""
+	report, err = svc.Copy(context.Background())
+	if err != nil {
+		t.Fatalf("resumed Copy: %v", err)
+	}
+	if keys := report.Tables[0]; keys.Copied != 0 || keys.Skipped != 5 {
+		t.Fatalf("expected copied keys to be skipped on resume, got %+v", keys)
+	}
+	if events := report.Tables[4]; events.Copied != 2 || events.DestinationRows != 2 {
+		t.Fatalf("expected audit events to be copied on resume, got %+v", events)
+	}
+}
+
+func TestCopy_RowCountMismatch(t *testing.T) {
+	src, dst := seededSource(), newFakeBulk()
+	dst.put(repository.TableAPIKeys, "k9", models.APIKey{ID: "k9"})
+	svc, err := NewService(src, dst, Config{})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	report, err := svc.Copy(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "source has 5 rows, destination has 6") {
+		t.Fatalf("expected a row count mismatch, got %v", err)
+	}
+	if len(report.Tables) != 1 || report.Tables[0].DestinationRows != 6 {
+		t.Fatalf("expected the mismatching table in the report, got %+v", report.Tables)
+	}
+}
+
+func TestNewService_RequiresRepositories(t *testing.T) {
+	if _, err := NewService(nil, newFakeBulk(), Config{}); err == nil {
+		t.Fatalf("expected a nil source to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+// Package keyactivity tracks when and how often API keys are used. Requests
+// are counted in memory on the authentication path and written to the
+// database in batches, so verifying a signature never waits on a write.
+package keyactivity
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const DefaultFlushInterval = 30 * time.Second
+
+type Config struct {
+	// FlushInterval is how often Run writes the pending counts. Defaults to
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+	Logger        *slog.Logger
+}
+
+type Recorder struct {
+	repo     repository.APIKeyActivityRepository
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	pending map[pendingKey]*models.APIKeyActivity
+}
+
+type pendingKey struct {
+	apiKeyID string
+	day      time.Time
+}
+
+func NewRecorder(repo repository.APIKeyActivityRepository, cfg Config) (*Recorder, error) {
+	if repo == nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "api key activity repository is required", nil)
+	}
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Recorder{repo: repo, interval: interval, logger: logger, pending: map[pendingKey]*models.APIKeyActivity{}}, nil
+}
+
+// Record counts one verified request. remoteAddr may carry a port, which is
+// dropped.
+func (r *Recorder) Record(apiKeyID, remoteAddr string, at time.Time) {
+	if apiKeyID == "" {
+		return
+	}
+	at = at.UTC()
+	ip := strings.TrimSpace(remoteAddr)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	key := pendingKey{apiKeyID: apiKeyID, day: at.Truncate(24 * time.Hour)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	batch, ok := r.pending[key]
+	if !ok {
+		batch = &models.APIKeyActivity{APIKeyID: apiKeyID, Day: key.day}
+		r.pending[key] = batch
+	}
+	batch.Requests++
+	if !at.Before(batch.LastUsedAt) {
+		batch.LastUsedAt = at
+		batch.LastUsedIP = ip
+	}
+}
+
+// Flush writes the pending counts. On failure they are kept and retried by
+// the next flush.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = map[pendingKey]*models.APIKeyActivity{}
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	batches := make([]models.APIKeyActivity, 0, len(pending))
+	for _, b := range pending {
+		batches = append(batches, *b)
+	}
+	// A stable order keeps replicas flushing at once from deadlocking on
+	// each other's key rows.
+	sort.Slice(batches, func(i, j int) bool {
+		if batches[i].APIKeyID != batches[j].APIKeyID {
+			return batches[i].APIKeyID < batches[j].APIKeyID
+		}
+		return batches[i].Day.Before(batches[j].Day)
+	})
+	if err := r.repo.RecordActivity(ctx, batches); err != nil {
+		r.restore(pending)
+		return internalerrors.New(internalerrors.ErrDatabaseError, "record api key activity", err)
+	}
+	return nil
+}
+
+// restore merges batches that failed to flush back into the pending counts.
+func (r *Recorder) restore(failed map[pendingKey]*models.APIKeyActivity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, b := range failed {
+		cur, ok := r.pending[key]
+		if !ok {
+			r.pending[key] = b
+			continue
+		}
+		cur.Requests += b.Requests
+		if b.LastUsedAt.After(cur.LastUsedAt) {
+			cur.LastUsedAt = b.LastUsedAt
+			cur.LastUsedIP = b.LastUsedIP
+		}
+	}
+}
+
+// Run flushes every interval until ctx is done. The caller should Flush once
+// more after Run returns so the last interval is not lost.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				r.logger.ErrorContext(ctx, "api key activity flush failed", "error", err.Error())
+			}
+		}
+	}
+}
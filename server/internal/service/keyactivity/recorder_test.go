@@ -0,0 +1,89 @@
+package keyactivity
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+)
+
+type fakeActivityRepo struct {
+	calls [][]models.APIKeyActivity
+	err   error
+}
+
+func (f *fakeActivityRepo) RecordActivity(_ context.Context, batches []models.APIKeyActivity) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.calls = append(f.calls, batches)
+	return nil
+}
+
+func (f *fakeActivityRepo) RequestCountsSince(context.Context, time.Time) (map[string]int64, error) {
+	return nil, nil
+}
+
+func TestRecorder_BatchesPerKeyAndDay(t *testing.T) {
+	repo := &fakeActivityRepo{}
+	rec, err := NewRecorder(repo, Config{})
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	rec.Record("key_b", "10.0.0.1:4000", day.Add(23*time.Hour))
+	rec.Record("key_b", "[2001:db8::1]:4000", day.Add(25*time.Hour))
+	rec.Record("key_a", "10.0.0.2:4000", day.Add(2*time.Hour))
+	rec.Record("key_a", "10.0.0.3", day.Add(3*time.Hour))
+	// An out-of-order request counts but does not move last_used back.
+	rec.Record("key_a", "10.0.0.9:4000", day.Add(time.Hour))
+	rec.Record("", "10.0.0.1:4000", day)
+
+	if err := rec.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(repo.calls) != 1 || len(repo.calls[0]) != 3 {
+		t.Fatalf("expected one write of three batches, got %+v", repo.calls)
+	}
+	got := repo.calls[0]
+	if a := got[0]; a.APIKeyID != "key_a" || !a.Day.Equal(day) || a.Requests != 3 || a.LastUsedIP != "10.0.0.3" || !a.LastUsedAt.Equal(day.Add(3*time.Hour)) {
+		t.Fatalf("unexpected key_a batch: %+v", a)
+	}
+	if b := got[1]; b.APIKeyID != "key_b" || !b.Day.Equal(day) || b.Requests != 1 || b.LastUsedIP != "10.0.0.1" {
+		t.Fatalf("unexpected first key_b batch: %+v", b)
+	}
+	if b := got[2]; b.APIKeyID != "key_b" || !b.Day.Equal(day.AddDate(0, 0, 1)) || b.LastUsedIP != "2001:db8::1" {
+		t.Fatalf("unexpected second key_b batch: %+v", b)
+	}
+
+	if err := rec.Flush(context.Background()); err != nil || len(repo.calls) != 1 {
+		t.Fatalf("expected an empty flush to skip the write, got %d calls, %v", len(repo.calls), err)
+	}
+}
+
+func TestRecorder_KeepsCountsWhenFlushFails(t *testing.T) {
+	repo := &fakeActivityRepo{err: errors.New("database is down")}
+	rec, err := NewRecorder(repo, Config{})
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	rec.Record("key_a", "10.0.0.1:4000", at)
+	if err := rec.Flush(context.Background()); err == nil {
+		t.Fatalf("expected the flush to fail")
+	}
+	rec.Record("key_a", "10.0.0.2:4000", at.Add(time.Minute))
+
+	repo.err = nil
+	if err := rec.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(repo.calls) != 1 || len(repo.calls[0]) != 1 {
+		t.Fatalf("unexpected writes: %+v", repo.calls)
+	}
+	if b := repo.calls[0][0]; b.Requests != 2 || b.LastUsedIP != "10.0.0.2" {
+		t.Fatalf("expected the failed count to be retried, got %+v", b)
+	}
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultReloadInterval = 10 * time.Second
+
+type LoaderConfig struct {
+	// ReloadInterval is how often Run checks the file for changes. Zero
+	// disables polling; Reload can still be called explicitly.
+	ReloadInterval time.Duration
+	Logger         *slog.Logger
+}
+
+// Loader serves the policy in a file and swaps it in place when the file
+// changes. A file that fails to parse leaves the previous policy in force.
+type Loader struct {
+	path     string
+	interval time.Duration
+	logger   *slog.Logger
+
+	current atomic.Pointer[Policy]
+
+	mu      sync.Mutex
+	lastRaw []byte
+}
+
+// NewLoader reads and compiles path; a broken file at startup is an error.
+func NewLoader(path string, cfg LoaderConfig) (*Loader, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := &Loader{path: path, interval: cfg.ReloadInterval, logger: logger}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Policy returns the policy currently in force.
+func (l *Loader) Policy() *Policy {
+	return l.current.Load()
+}
+
+// Evaluate checks body against the policy currently in force.
+func (l *Loader) Evaluate(apiKeyID string, body []byte) Decision {
+	return l.Policy().Evaluate(apiKeyID, body)
+}
+
+// Reload re-reads the file and reports whether a new policy was installed.
+func (l *Loader) Reload() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	raw, err := os.ReadFile(l.path)
+	if err != nil {
+		return false, fmt.Errorf("read policy file %s: %w", l.path, err)
+	}
+	if l.current.Load() != nil && bytes.Equal(raw, l.lastRaw) {
+		return false, nil
+	}
+	p, err := Parse(raw)
+	if err != nil {
+		return false, fmt.Errorf("load policy file %s: %w", l.path, err)
+	}
+	l.current.Store(p)
+	l.lastRaw = raw
+	return true, nil
+}
+
+// Run polls the file until ctx is done.
+func (l *Loader) Run(ctx context.Context) {
+	if l.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := l.Reload()
+			if err != nil {
+				l.logger.ErrorContext(ctx, "policy reload failed; keeping previous policy", "error", err.Error(), "version", l.Policy().Version())
+				continue
+			}
+			if changed {
+				l.logger.InfoContext(ctx, "policy reloaded", "path", l.path, "version", l.Policy().Version())
+			}
+		}
+	}
+}
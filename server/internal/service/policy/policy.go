@@ -0,0 +1,237 @@
+// Package policy decides whether a submit request may be relayed based on
+// operator-defined content rules (banned terms, brand rules per client).
+//
+// A policy file looks like:
+//
+//	global:
+//	  - id: banned-terms
+//	    terms: ["gore", "self-harm"]
+//	rule_sets:
+//	  acme-brand:
+//	    - id: no-competitors
+//	      patterns: ['(?i)\bglobex\b']
+//	      message: competitor brands are not allowed
+//	keys:
+//	  key_acme: [acme-brand]
+//
+// Global rules apply to every API key; keys additionally get the rule sets
+// listed for them. Rules look at the prompt unless fields says otherwise.
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDenyMessage is returned to the client when a rule has no message.
+const DefaultDenyMessage = "request rejected by content policy"
+
+const globalSet = "global"
+
+var defaultFields = []string{"prompt"}
+
+// Decision is the outcome of evaluating a request against a policy.
+type Decision struct {
+	Allowed bool
+	// RuleID, RuleSet and Field identify the first rule that denied the
+	// request; they are empty when it was allowed.
+	RuleID  string
+	RuleSet string
+	Field   string
+	Message string
+	// Version identifies the policy content that made the decision.
+	Version string
+}
+
+// Policy is a parsed, compiled policy file.
+type Policy struct {
+	version string
+	global  []rule
+	sets    map[string][]rule
+	keys    map[string][]string
+}
+
+type rule struct {
+	id       string
+	set      string
+	terms    []string
+	patterns []*regexp.Regexp
+	fields   []string
+	message  string
+}
+
+type fileRule struct {
+	ID       string   `yaml:"id"`
+	Terms    []string `yaml:"terms"`
+	Patterns []string `yaml:"patterns"`
+	Fields   []string `yaml:"fields"`
+	Message  string   `yaml:"message"`
+}
+
+type file struct {
+	Global   []fileRule            `yaml:"global"`
+	RuleSets map[string][]fileRule `yaml:"rule_sets"`
+	Keys     map[string][]string   `yaml:"keys"`
+}
+
+// Parse compiles a YAML (or JSON) policy document.
+func Parse(data []byte) (*Policy, error) {
+	var f file
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode policy: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	p := &Policy{
+		version: hex.EncodeToString(sum[:])[:12],
+		sets:    make(map[string][]rule, len(f.RuleSets)),
+		keys:    make(map[string][]string, len(f.Keys)),
+	}
+	seen := map[string]bool{}
+	var err error
+	if p.global, err = compileRules(globalSet, f.Global, seen); err != nil {
+		return nil, err
+	}
+	for name, rules := range f.RuleSets {
+		name = strings.TrimSpace(name)
+		if name == "" || name == globalSet {
+			return nil, fmt.Errorf("rule set name %q is reserved or empty", name)
+		}
+		if p.sets[name], err = compileRules(name, rules, seen); err != nil {
+			return nil, err
+		}
+	}
+	for keyID, sets := range f.Keys {
+		keyID = strings.TrimSpace(keyID)
+		if keyID == "" {
+			return nil, fmt.Errorf("keys: api key id must not be empty")
+		}
+		for _, set := range sets {
+			if _, ok := p.sets[set]; !ok {
+				return nil, fmt.Errorf("keys.%s: unknown rule set %q", keyID, set)
+			}
+		}
+		p.keys[keyID] = sets
+	}
+	return p, nil
+}
+
+func compileRules(set string, in []fileRule, seen map[string]bool) ([]rule, error) {
+	out := make([]rule, 0, len(in))
+	for i, fr := range in {
+		id := strings.TrimSpace(fr.ID)
+		if id == "" {
+			return nil, fmt.Errorf("%s[%d]: id is required", set, i)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%s[%d]: duplicate rule id %q", set, i, id)
+		}
+		seen[id] = true
+		r := rule{id: id, set: set, fields: defaultFields, message: strings.TrimSpace(fr.Message)}
+		for _, term := range fr.Terms {
+			if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+				r.terms = append(r.terms, term)
+			}
+		}
+		for _, expr := range fr.Patterns {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %q: invalid pattern %q: %w", set, id, expr, err)
+			}
+			r.patterns = append(r.patterns, re)
+		}
+		if len(r.terms) == 0 && len(r.patterns) == 0 {
+			return nil, fmt.Errorf("%s: rule %q needs at least one term or pattern", set, id)
+		}
+		if len(fr.Fields) > 0 {
+			r.fields = fr.Fields
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Version is a short content hash of the policy document.
+func (p *Policy) Version() string {
+	return p.version
+}
+
+// Evaluate checks a submit body for apiKeyID. Bodies that are not JSON objects
+// are allowed; schema validation is responsible for rejecting them.
+func (p *Policy) Evaluate(apiKeyID string, body []byte) Decision {
+	allowed := Decision{Allowed: true, Version: p.version}
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return allowed
+	}
+	if d, ok := p.match(p.global, fields); ok {
+		return d
+	}
+	for _, set := range p.keys[apiKeyID] {
+		if d, ok := p.match(p.sets[set], fields); ok {
+			return d
+		}
+	}
+	return allowed
+}
+
+func (p *Policy) match(rules []rule, fields map[string]any) (Decision, bool) {
+	for _, r := range rules {
+		for _, field := range r.fields {
+			for _, text := range fieldStrings(fields[field]) {
+				if !r.matches(text) {
+					continue
+				}
+				msg := r.message
+				if msg == "" {
+					msg = DefaultDenyMessage
+				}
+				return Decision{RuleID: r.id, RuleSet: r.set, Field: field, Message: msg, Version: p.version}, true
+			}
+		}
+	}
+	return Decision{}, false
+}
+
+func (r rule) matches(text string) bool {
+	lower := strings.ToLower(text)
+	for _, term := range r.terms {
+		if strings.Contains(lower, term) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldStrings(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
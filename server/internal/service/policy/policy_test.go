@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+global:
+  - id: banned-terms
+    terms: ["Gore"]
+rule_sets:
+  acme-brand:
+    - id: no-competitors
+      patterns: ['(?i)\bglobex\b']
+      fields: [prompt, image_urls]
+      message: competitor brands are not allowed
+keys:
+  key_acme: [acme-brand]
+`
+
+func mustParse(t *testing.T, doc string) *Policy {
+	t.Helper()
+	p, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return p
+}
+
+func TestEvaluate(t *testing.T) {
+	p := mustParse(t, testPolicy)
+
+	tests := []struct {
+		name    string
+		key     string
+		body    string
+		allowed bool
+		ruleID  string
+		field   string
+		message string
+	}{
+		{name: "clean prompt", key: "key_acme", body: `{"prompt":"a cat in the rain"}`, allowed: true},
+		{name: "global term is case-insensitive", key: "key_other", body: `{"prompt":"so much GORE"}`, ruleID: "banned-terms", field: "prompt", message: DefaultDenyMessage},
+		{name: "key rule set applies to its key", key: "key_acme", body: `{"prompt":"a Globex billboard"}`, ruleID: "no-competitors", field: "prompt", message: "competitor brands are not allowed"},
+		{name: "key rule set skips other keys", key: "key_other", body: `{"prompt":"a Globex billboard"}`, allowed: true},
+		{name: "rule checks listed array fields", key: "key_acme", body: `{"prompt":"cat","image_urls":["https://cdn.example.com/globex.png"]}`, ruleID: "no-competitors", field: "image_urls"},
+		{name: "non-object body", key: "key_acme", body: `not json`, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.key, []byte(tt.body))
+			if d.Allowed != tt.allowed || d.RuleID != tt.ruleID || d.Field != tt.field || d.Version != p.Version() {
+				t.Fatalf("unexpected decision: %+v", d)
+			}
+			if tt.message != "" && d.Message != tt.message {
+				t.Fatalf("expected message %q, got %q", tt.message, d.Message)
+			}
+		})
+	}
+}
+
+func TestParse_Rejects(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":    "global:\n  - id: a\n    term: [x]\n",
+		"missing id":       "global:\n  - terms: [x]\n",
+		"duplicate id":     "global:\n  - id: a\n    terms: [x]\nrule_sets:\n  s:\n    - id: a\n      terms: [y]\n",
+		"empty rule":       "global:\n  - id: a\n",
+		"bad pattern":      "global:\n  - id: a\n    patterns: ['(']\n",
+		"unknown rule set": "keys:\n  key_1: [missing]\n",
+		"reserved set":     "rule_sets:\n  global:\n    - id: a\n      terms: [x]\n",
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(doc)); err == nil {
+				t.Fatalf("expected parse error")
+			}
+		})
+	}
+	if p := mustParse(t, ""); !p.Evaluate("k", []byte(`{"prompt":"x"}`)).Allowed {
+		t.Fatalf("expected empty policy to allow everything")
+	}
+}
+
+func TestLoader_ReloadKeepsLastGoodPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	l, err := NewLoader(path, LoaderConfig{})
+	if err != nil {
+		t.Fatalf("NewLoader: %v", err)
+	}
+	first := l.Policy().Version()
+
+	if changed, err := l.Reload(); err != nil || changed {
+		t.Fatalf("expected unchanged file to be a no-op, got changed=%v err=%v", changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte("global:\n  - id: cats\n    terms: [cat]\n"), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	if changed, err := l.Reload(); err != nil || !changed {
+		t.Fatalf("expected edited file to reload, got changed=%v err=%v", changed, err)
+	}
+	if l.Policy().Version() == first || l.Evaluate("k", []byte(`{"prompt":"a cat"}`)).Allowed {
+		t.Fatalf("expected new policy to be in force")
+	}
+
+	if err := os.WriteFile(path, []byte("global: [\n"), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	if _, err := l.Reload(); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("expected reload error naming the file, got %v", err)
+	}
+	if l.Evaluate("k", []byte(`{"prompt":"a cat"}`)).Allowed {
+		t.Fatalf("expected previous policy to stay in force after a bad edit")
+	}
+
+	if _, err := NewLoader(filepath.Join(t.TempDir(), "missing.yaml"), LoaderConfig{}); err == nil {
+		t.Fatalf("expected missing policy file to fail at startup")
+	}
+}
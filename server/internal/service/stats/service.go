@@ -0,0 +1,239 @@
+// Package stats keeps hourly rollups of upstream attempts up to date and
+// reads them back for reporting.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const (
+	DefaultInterval = 5 * time.Minute
+	// Lookback is how far before the watermark each run recomputes, so
+	// attempts written late (long upstream calls, slow audit writes) still
+	// land in their hour. Hours older than that are never rewritten, which
+	// keeps them intact once the raw rows are purged.
+	Lookback = 2 * time.Hour
+	// maxChunk bounds the samples held in memory while catching up on a
+	// backlog.
+	maxChunk = 24 * time.Hour
+)
+
+type Config struct {
+	// Interval is how often Run refreshes the rollups. Defaults to
+	// DefaultInterval.
+	Interval time.Duration
+	Logger   *slog.Logger
+	Now      func() time.Time
+}
+
+type Service struct {
+	repo     repository.StatsRollupRepository
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+func NewService(repo repository.StatsRollupRepository, cfg Config) (*Service, error) {
+	if repo == nil {
+		return nil, internalerrors.New(internalerrors.ErrInternalError, "stats rollup repository is required", nil)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	nowFn := cfg.Now
+	if nowFn == nil {
+		nowFn = func() time.Time { return time.Now().UTC() }
+	}
+	return &Service{repo: repo, interval: interval, logger: logger, now: nowFn}, nil
+}
+
+// Rollup recomputes every hour from Lookback before the watermark through
+// the current hour and returns the number of rollups written. The first run
+// starts at the earliest upstream attempt.
+func (s *Service) Rollup(ctx context.Context) (int, error) {
+	end := s.now().UTC().Truncate(time.Hour).Add(time.Hour)
+	watermark, err := s.repo.Watermark(ctx)
+	if err != nil {
+		return 0, internalerrors.New(internalerrors.ErrDatabaseError, "get stats rollup watermark", err)
+	}
+	var start time.Time
+	if watermark.IsZero() {
+		first, err := s.repo.FirstSampleTime(ctx)
+		if repository.IsNotFound(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, internalerrors.New(internalerrors.ErrDatabaseError, "get first upstream attempt time", err)
+		}
+		start = first.UTC().Truncate(time.Hour)
+	} else {
+		start = watermark.UTC().Add(-Lookback).Truncate(time.Hour)
+	}
+
+	written := 0
+	for from := start; from.Before(end); from = from.Add(maxChunk) {
+		to := from.Add(maxChunk)
+		if to.After(end) {
+			to = end
+		}
+		samples, err := s.repo.RollupSamples(ctx, from, to)
+		if err != nil {
+			return written, internalerrors.New(internalerrors.ErrDatabaseError, "read upstream attempts for stats rollup", err)
+		}
+		rollups := Aggregate(samples)
+		if err := s.repo.ReplaceRollups(ctx, from, to, rollups); err != nil {
+			return written, internalerrors.New(internalerrors.ErrDatabaseError, "write stats rollups", err)
+		}
+		written += len(rollups)
+	}
+	return written, nil
+}
+
+// Run refreshes the rollups once and then every interval until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Rollup(ctx); err != nil && ctx.Err() == nil {
+			s.logger.ErrorContext(ctx, "stats rollup failed", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// List returns the stored rollups of the hours in [from, to). An empty
+// apiKeyID lists every key.
+func (s *Service) List(ctx context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error) {
+	rollups, err := s.repo.ListRollups(ctx, from.UTC().Truncate(time.Hour), to.UTC(), apiKeyID)
+	if err != nil {
+		return nil, internalerrors.New(internalerrors.ErrDatabaseError, "list stats rollups", err)
+	}
+	return rollups, nil
+}
+
+type rollupKey struct {
+	hour        time.Time
+	apiKeyID    string
+	action      models.DownstreamAction
+	statusClass string
+}
+
+// Aggregate groups samples by hour, API key, action and status class.
+// Latency percentiles use the nearest-rank method.
+func Aggregate(samples []models.RollupSample) []models.StatsRollup {
+	groups := map[rollupKey][]models.RollupSample{}
+	for _, sample := range samples {
+		key := rollupKey{
+			hour:        sample.SentAt.UTC().Truncate(time.Hour),
+			apiKeyID:    sample.APIKeyID,
+			action:      sample.Action,
+			statusClass: models.StatusClass(sample.ResponseStatus),
+		}
+		groups[key] = append(groups[key], sample)
+	}
+
+	out := make([]models.StatsRollup, 0, len(groups))
+	for key, group := range groups {
+		rollup := models.StatsRollup{
+			Hour:        key.hour,
+			APIKeyID:    key.apiKeyID,
+			Action:      key.action,
+			StatusClass: key.statusClass,
+			Attempts:    int64(len(group)),
+		}
+		latencies := make([]int64, 0, len(group))
+		for _, sample := range group {
+			if sample.AttemptNumber > 1 {
+				rollup.Retries++
+			}
+			if sample.Failed {
+				rollup.Errors++
+			}
+			rollup.LatencySumMs += sample.LatencyMs
+			latencies = append(latencies, sample.LatencyMs)
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		rollup.LatencyP50Ms = percentile(latencies, 0.50)
+		rollup.LatencyP95Ms = percentile(latencies, 0.95)
+		rollup.LatencyMaxMs = latencies[len(latencies)-1]
+		out = append(out, rollup)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.APIKeyID != b.APIKeyID {
+			return a.APIKeyID < b.APIKeyID
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.StatusClass < b.StatusClass
+	})
+	return out
+}
+
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// WriteTable prints one line per rollup and a TOTAL line. Percentiles do
+// not add up across rollups, so the total shows the mean latency instead.
+func WriteTable(w io.Writer, rollups []models.StatsRollup) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "HOUR\tAPI KEY\tACTION\tSTATUS\tATTEMPTS\tRETRIES\tERRORS\tP50 MS\tP95 MS\tMAX MS"); err != nil {
+		return err
+	}
+	var total models.StatsRollup
+	for _, r := range rollups {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			r.Hour.UTC().Format("2006-01-02T15:04Z"), r.APIKeyID, r.Action, r.StatusClass,
+			r.Attempts, r.Retries, r.Errors, r.LatencyP50Ms, r.LatencyP95Ms, r.LatencyMaxMs,
+		); err != nil {
+			return err
+		}
+		total.Attempts += r.Attempts
+		total.Retries += r.Retries
+		total.Errors += r.Errors
+		total.LatencySumMs += r.LatencySumMs
+		if r.LatencyMaxMs > total.LatencyMaxMs {
+			total.LatencyMaxMs = r.LatencyMaxMs
+		}
+	}
+	mean := "-"
+	if total.Attempts > 0 {
+		mean = "avg " + strconv.FormatInt(total.LatencySumMs/total.Attempts, 10)
+	}
+	if _, err := fmt.Fprintf(tw, "TOTAL\t\t\t\t%d\t%d\t%d\t%s\t\t%d\n",
+		total.Attempts, total.Retries, total.Errors, mean, total.LatencyMaxMs,
+	); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+type replaced struct{ from, to time.Time }
+
+// fakeStatsRepo serves samples from memory and keeps rollups keyed the way
+// the SQL tables are.
+type fakeStatsRepo struct {
+	samples   []models.RollupSample
+	rollups   []models.StatsRollup
+	watermark time.Time
+	replaced  []replaced
+}
+
+func (f *fakeStatsRepo) RollupSamples(_ context.Context, from, to time.Time) ([]models.RollupSample, error) {
+	var out []models.RollupSample
+	for _, s := range f.samples {
+		if !s.SentAt.Before(from) && s.SentAt.Before(to) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeStatsRepo) FirstSampleTime(context.Context) (time.Time, error) {
+	if len(f.samples) == 0 {
+		return time.Time{}, repository.ErrNotFound
+	}
+	first := f.samples[0].SentAt
+	for _, s := range f.samples[1:] {
+		if s.SentAt.Before(first) {
+			first = s.SentAt
+		}
+	}
+	return first, nil
+}
+
+func (f *fakeStatsRepo) Watermark(context.Context) (time.Time, error) {
+	return f.watermark, nil
+}
+
+func (f *fakeStatsRepo) ReplaceRollups(_ context.Context, from, to time.Time, rollups []models.StatsRollup) error {
+	f.replaced = append(f.replaced, replaced{from, to})
+	kept := f.rollups[:0:0]
+	for _, r := range f.rollups {
+		if r.Hour.Before(from) || !r.Hour.Before(to) {
+			kept = append(kept, r)
+		}
+	}
+	f.rollups = append(kept, rollups...)
+	if to.After(f.watermark) {
+		f.watermark = to
+	}
+	return nil
+}
+
+func (f *fakeStatsRepo) ListRollups(_ context.Context, from, to time.Time, apiKeyID string) ([]models.StatsRollup, error) {
+	var out []models.StatsRollup
+	for _, r := range f.rollups {
+		if r.Hour.Before(from) || !r.Hour.Before(to) {
+			continue
+		}
+		if apiKeyID != "" && r.APIKeyID != apiKeyID {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func TestAggregate(t *testing.T) {
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	var samples []models.RollupSample
+	for i := 1; i <= 20; i++ {
+		samples = append(samples, models.RollupSample{
+			APIKeyID:       "k1",
+			Action:         models.DownstreamActionCVSync2AsyncSubmitTask,
+			AttemptNumber:  1 + i%2,
+			ResponseStatus: 200,
+			LatencyMs:      int64(i * 10),
+			SentAt:         hour.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	samples = append(samples,
+		models.RollupSample{APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, AttemptNumber: 1, ResponseStatus: 0, LatencyMs: 5000, Failed: true, SentAt: hour.Add(5 * time.Minute)},
+		models.RollupSample{APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, AttemptNumber: 1, ResponseStatus: 200, LatencyMs: 7, SentAt: hour.Add(time.Hour)},
+	)
+
+	got := Aggregate(samples)
+	if len(got) != 3 {
+		t.Fatalf("len = %d, want 3: %+v", len(got), got)
+	}
+	ok := got[0]
+	if !ok.Hour.Equal(hour) || ok.StatusClass != "2xx" {
+		t.Fatalf("first rollup = %+v", ok)
+	}
+	if ok.Attempts != 20 || ok.Retries != 10 || ok.Errors != 0 {
+		t.Fatalf("counts = %d/%d/%d", ok.Attempts, ok.Retries, ok.Errors)
+	}
+	if ok.LatencyP50Ms != 100 || ok.LatencyP95Ms != 190 || ok.LatencyMaxMs != 200 || ok.LatencySumMs != 2100 {
+		t.Fatalf("latency = p50 %d p95 %d max %d sum %d", ok.LatencyP50Ms, ok.LatencyP95Ms, ok.LatencyMaxMs, ok.LatencySumMs)
+	}
+	failed := got[1]
+	if failed.StatusClass != models.StatusClassNoResponse || failed.Errors != 1 || failed.LatencyP95Ms != 5000 {
+		t.Fatalf("failed rollup = %+v", failed)
+	}
+	if !got[2].Hour.Equal(hour.Add(time.Hour)) || got[2].Attempts != 1 {
+		t.Fatalf("next hour rollup = %+v", got[2])
+	}
+}
+
+func TestService_Rollup(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 30, 0, 0, time.UTC)
+	now := start.Add(30 * time.Hour)
+	repo := &fakeStatsRepo{}
+	svc, err := NewService(repo, Config{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+
+	if n, err := svc.Rollup(context.Background()); err != nil || n != 0 {
+		t.Fatalf("empty Rollup = %d, %v", n, err)
+	}
+	if len(repo.replaced) != 0 {
+		t.Fatalf("empty Rollup wrote %v", repo.replaced)
+	}
+
+	for _, at := range []time.Time{start, start.Add(29 * time.Hour)} {
+		repo.samples = append(repo.samples, models.RollupSample{APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, AttemptNumber: 1, ResponseStatus: 200, LatencyMs: 10, SentAt: at})
+	}
+	if n, err := svc.Rollup(context.Background()); err != nil || n != 2 {
+		t.Fatalf("first Rollup = %d, %v", n, err)
+	}
+	// The backlog is written in day-sized chunks through the current hour.
+	wantEnd := time.Date(2026, 3, 2, 7, 0, 0, 0, time.UTC)
+	if len(repo.replaced) != 2 || !repo.replaced[0].from.Equal(start.Truncate(time.Hour)) || !repo.replaced[1].to.Equal(wantEnd) {
+		t.Fatalf("replaced = %v", repo.replaced)
+	}
+	if !repo.watermark.Equal(wantEnd) {
+		t.Fatalf("watermark = %s, want %s", repo.watermark, wantEnd)
+	}
+
+	// Raw rows are purged; the next run only revisits the lookback window,
+	// so the first day's rollup survives.
+	repo.samples = repo.samples[1:]
+	repo.replaced = nil
+	if _, err := svc.Rollup(context.Background()); err != nil {
+		t.Fatalf("second Rollup: %v", err)
+	}
+	if len(repo.replaced) != 1 || !repo.replaced[0].from.Equal(wantEnd.Add(-Lookback)) {
+		t.Fatalf("replaced = %v", repo.replaced)
+	}
+	got, err := svc.List(context.Background(), start, now, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("rollups after purge = %+v", got)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	err := WriteTable(&buf, []models.StatsRollup{
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "2xx", Attempts: 3, Retries: 1, LatencySumMs: 300, LatencyP50Ms: 100, LatencyP95Ms: 150, LatencyMaxMs: 150},
+		{Hour: hour, APIKeyID: "k1", Action: models.DownstreamActionCVSync2AsyncSubmitTask, StatusClass: "5xx", Attempts: 1, Errors: 1, LatencySumMs: 900, LatencyP50Ms: 900, LatencyP95Ms: 900, LatencyMaxMs: 900},
+	})
+	if err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines = %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "2026-03-01T10:00Z") || !strings.Contains(lines[1], "2xx") {
+		t.Fatalf("row = %q", lines[1])
+	}
+	if fields := strings.Fields(lines[3]); strings.Join(fields, " ") != "TOTAL 4 1 1 avg 300 900" {
+		t.Fatalf("total = %q", lines[3])
+	}
+}
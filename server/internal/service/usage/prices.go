@@ -0,0 +1,166 @@
+// Package usage turns successful submits into per-key, per-model usage and
+// applies an operator-maintained price table to it.
+//
+// A price file looks like:
+//
+//	currency: CNY
+//	models:
+//	  jimeng_t2i_v40:
+//	    per_image: 0.2
+//	  jimeng_t2v_v30:
+//	    per_second: 0.28
+//	  jimeng_t2v_v30_1080p:
+//	    per_second: 0.63
+//	  jimeng_ti2v_v30_pro:
+//	    resolutions:
+//	      720p: {per_second: 0.3}
+//	      1080p: {per_second: 0.7}
+//	default:
+//	  per_request: 0.1
+//
+// A submit is priced by the resolutions entry of its req_key when one
+// matches, then by the req_key entry, then by default. Submits with no
+// matching price are reported as unpriced rather than free.
+package usage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// microsPerUnit is the fixed-point scale costs are summed in, so totals do
+// not drift with float rounding.
+const microsPerUnit = 1_000_000
+
+// Price is what one successful submit costs. All fields add up; a video
+// priced per_second and per_request pays both.
+type Price struct {
+	PerRequest float64 `yaml:"per_request"`
+	PerImage   float64 `yaml:"per_image"`
+	PerSecond  float64 `yaml:"per_second"`
+	PerFrame   float64 `yaml:"per_frame"`
+}
+
+type modelPrice struct {
+	Price       `yaml:",inline"`
+	Resolutions map[string]Price `yaml:"resolutions"`
+}
+
+type priceFile struct {
+	Currency string                `yaml:"currency"`
+	Models   map[string]modelPrice `yaml:"models"`
+	Default  *Price                `yaml:"default"`
+}
+
+// PriceTable is a parsed price file. A nil *PriceTable prices nothing.
+type PriceTable struct {
+	currency string
+	models   map[string]modelPrice
+	fallback *Price
+}
+
+// ParsePrices parses a YAML (or JSON) price document.
+func ParsePrices(data []byte) (*PriceTable, error) {
+	var f priceFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode price file: %w", err)
+	}
+
+	t := &PriceTable{
+		currency: strings.TrimSpace(f.Currency),
+		models:   make(map[string]modelPrice, len(f.Models)),
+		fallback: f.Default,
+	}
+	if t.fallback != nil {
+		if err := t.fallback.validate("default"); err != nil {
+			return nil, err
+		}
+	}
+	for reqKey, mp := range f.Models {
+		reqKey = strings.TrimSpace(reqKey)
+		if reqKey == "" {
+			return nil, fmt.Errorf("models: req_key must not be empty")
+		}
+		if err := mp.Price.validate("models." + reqKey); err != nil {
+			return nil, err
+		}
+		for res, p := range mp.Resolutions {
+			if err := p.validate("models." + reqKey + ".resolutions." + res); err != nil {
+				return nil, err
+			}
+		}
+		t.models[reqKey] = mp
+	}
+	return t, nil
+}
+
+// LoadPriceFile reads and parses the price file at path.
+func LoadPriceFile(path string) (*PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read price file: %w", err)
+	}
+	t, err := ParsePrices(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Currency is the currency label from the price file, if any.
+func (t *PriceTable) Currency() string {
+	if t == nil {
+		return ""
+	}
+	return t.currency
+}
+
+// lookup returns the price for reqKey at resolution.
+func (t *PriceTable) lookup(reqKey, resolution string) (Price, bool) {
+	if t == nil {
+		return Price{}, false
+	}
+	if mp, ok := t.models[reqKey]; ok {
+		if p, ok := mp.Resolutions[resolution]; ok && resolution != "" {
+			return p, true
+		}
+		return mp.Price, true
+	}
+	if t.fallback != nil {
+		return *t.fallback, true
+	}
+	return Price{}, false
+}
+
+func (p Price) validate(field string) error {
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{{"per_request", p.PerRequest}, {"per_image", p.PerImage}, {"per_second", p.PerSecond}, {"per_frame", p.PerFrame}} {
+		if f.v < 0 || math.IsNaN(f.v) || math.IsInf(f.v, 0) {
+			return fmt.Errorf("%s.%s must be a non-negative number", field, f.name)
+		}
+	}
+	return nil
+}
+
+// costMicros prices one submit with the given units.
+func (p Price) costMicros(images int64, seconds float64, frames int64) int64 {
+	return toMicros(p.PerRequest) +
+		toMicros(p.PerImage)*images +
+		int64(math.Round(float64(toMicros(p.PerSecond))*seconds)) +
+		toMicros(p.PerFrame)*frames
+}
+
+func toMicros(v float64) int64 {
+	return int64(math.Round(v * microsPerUnit))
+}
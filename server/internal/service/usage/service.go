@@ -0,0 +1,316 @@
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+	"github.com/jimeng-relay/server/internal/repository"
+)
+
+const (
+	// DefaultVideoFrames is what the upstream generates when a video submit
+	// does not set frames (5 seconds).
+	DefaultVideoFrames = 121
+	videoFPS           = 24
+)
+
+// Row is the usage of one API key and req_key on one UTC day. In a report
+// grouped by a label, Group holds the label's value and APIKeyID is empty.
+type Row struct {
+	Day          string  `json:"day"`
+	APIKeyID     string  `json:"api_key_id,omitempty"`
+	Group        string  `json:"group,omitempty"`
+	ReqKey       string  `json:"req_key"`
+	Requests     int64   `json:"requests"`
+	Images       int64   `json:"images"`
+	VideoSeconds float64 `json:"video_seconds"`
+	Frames       int64   `json:"frames"`
+	Cost         float64 `json:"cost"`
+	// Unpriced counts requests with no matching entry in the price table;
+	// they contribute nothing to Cost.
+	Unpriced int64 `json:"unpriced,omitempty"`
+
+	costMicros int64
+}
+
+// Totals sums every row of a report.
+type Totals struct {
+	Requests     int64   `json:"requests"`
+	Images       int64   `json:"images"`
+	VideoSeconds float64 `json:"video_seconds"`
+	Frames       int64   `json:"frames"`
+	Cost         float64 `json:"cost"`
+	Unpriced     int64   `json:"unpriced,omitempty"`
+}
+
+// Report is the usage of [From, To), ordered by day, API key and req_key.
+type Report struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	APIKeyID string    `json:"api_key_id,omitempty"`
+	// Selector and GroupBy record the LabelQuery applied to the report.
+	Selector string `json:"selector,omitempty"`
+	GroupBy  string `json:"group_by,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Rows     []Row  `json:"rows"`
+	Totals   Totals `json:"totals"`
+}
+
+type Service struct {
+	repo   repository.UsageRepository
+	prices *PriceTable
+}
+
+// NewService reports usage from repo. prices may be nil, in which case every
+// request is reported as unpriced.
+func NewService(repo repository.UsageRepository, prices *PriceTable) *Service {
+	return &Service{repo: repo, prices: prices}
+}
+
+// Report summarizes successful submits received in [from, to). An empty
+// apiKeyID covers every key.
+func (s *Service) Report(ctx context.Context, from, to time.Time, apiKeyID string) (Report, error) {
+	if s.repo == nil {
+		return Report{}, internalerrors.New(internalerrors.ErrInternalError, "usage repository is required", nil)
+	}
+	if from.IsZero() || to.IsZero() {
+		return Report{}, internalerrors.New(internalerrors.ErrValidationFailed, "from and to are required", nil)
+	}
+	if !from.Before(to) {
+		return Report{}, internalerrors.New(internalerrors.ErrValidationFailed, "from must be before to", nil)
+	}
+
+	usage, err := s.repo.SummarizeSubmits(ctx, from.UTC(), to.UTC(), apiKeyID)
+	if err != nil {
+		return Report{}, internalerrors.New(internalerrors.ErrDatabaseError, "summarize submits", err)
+	}
+
+	report := Report{From: from.UTC(), To: to.UTC(), APIKeyID: apiKeyID, Currency: s.prices.Currency(), Rows: []Row{}}
+	for _, u := range usage {
+		// The repository orders by day, key and req_key, so rows for the same
+		// triple are adjacent.
+		n := len(report.Rows)
+		if n == 0 || report.Rows[n-1].Day != u.Day || report.Rows[n-1].APIKeyID != u.APIKeyID || report.Rows[n-1].ReqKey != u.ReqKey {
+			report.Rows = append(report.Rows, Row{Day: u.Day, APIKeyID: u.APIKeyID, ReqKey: u.ReqKey})
+			n++
+		}
+		row := &report.Rows[n-1]
+
+		images, seconds, frames := units(u)
+		row.Requests += u.Count
+		row.Images += images * u.Count
+		row.VideoSeconds += seconds * float64(u.Count)
+		row.Frames += frames * u.Count
+		if price, ok := s.prices.lookup(u.ReqKey, resolution(u)); ok {
+			row.costMicros += price.costMicros(images, seconds, frames) * u.Count
+		} else {
+			row.Unpriced += u.Count
+		}
+	}
+	report.sum()
+	return report, nil
+}
+
+// sum fills in the cost of every row and the totals.
+func (r *Report) sum() {
+	var totalMicros int64
+	r.Totals = Totals{}
+	for i := range r.Rows {
+		row := &r.Rows[i]
+		row.Cost = fromMicros(row.costMicros)
+		totalMicros += row.costMicros
+		r.Totals.Requests += row.Requests
+		r.Totals.Images += row.Images
+		r.Totals.VideoSeconds += row.VideoSeconds
+		r.Totals.Frames += row.Frames
+		r.Totals.Unpriced += row.Unpriced
+	}
+	r.Totals.Cost = fromMicros(totalMicros)
+}
+
+// NoLabel is the group of keys that do not have the GroupBy label. It cannot
+// collide with a label value, which never contains parentheses.
+const NoLabel = "(none)"
+
+// LabelQuery narrows and regroups a report by the labels of its API keys.
+type LabelQuery struct {
+	// Selector keeps the rows of keys whose labels match it.
+	Selector models.LabelSelector
+	// GroupBy, when set, sums rows per value of this label instead of per
+	// API key.
+	GroupBy string
+}
+
+// ApplyLabels applies q to report using the labels of keys. Rows of keys
+// missing from keys are treated as unlabeled.
+func ApplyLabels(report Report, keys []models.APIKey, q LabelQuery) Report {
+	labels := make(map[string]map[string]string, len(keys))
+	for _, key := range keys {
+		labels[key.ID] = key.Labels
+	}
+	out := report
+	out.Selector = q.Selector.String()
+	out.GroupBy = q.GroupBy
+	out.Rows = []Row{}
+	index := map[Row]int{}
+	for _, row := range report.Rows {
+		keyLabels := labels[row.APIKeyID]
+		if !q.Selector.Matches(keyLabels) {
+			continue
+		}
+		if q.GroupBy == "" {
+			out.Rows = append(out.Rows, row)
+			continue
+		}
+		group, ok := keyLabels[q.GroupBy]
+		if !ok {
+			group = NoLabel
+		}
+		id := Row{Day: row.Day, Group: group, ReqKey: row.ReqKey}
+		i, ok := index[id]
+		if !ok {
+			i = len(out.Rows)
+			index[id] = i
+			out.Rows = append(out.Rows, id)
+		}
+		merged := &out.Rows[i]
+		merged.Requests += row.Requests
+		merged.Images += row.Images
+		merged.VideoSeconds += row.VideoSeconds
+		merged.Frames += row.Frames
+		merged.Unpriced += row.Unpriced
+		merged.costMicros += row.costMicros
+	}
+	sort.SliceStable(out.Rows, func(i, j int) bool {
+		a, b := out.Rows[i], out.Rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.ReqKey < b.ReqKey
+	})
+	out.sum()
+	return out
+}
+
+// IsVideo reports whether reqKey generates video (t2v, i2v, ti2v).
+func IsVideo(reqKey string) bool {
+	return strings.Contains(reqKey, "2v_")
+}
+
+// units is what one submit of u produces: an image, or a video of
+// frames at 24 fps.
+func units(u models.SubmitUsage) (images int64, seconds float64, frames int64) {
+	if !IsVideo(u.ReqKey) {
+		return 1, 0, 0
+	}
+	f := u.Frames
+	if f <= 0 {
+		f = DefaultVideoFrames
+	}
+	return 0, float64(f-1) / videoFPS, int64(f)
+}
+
+// resolution names the price tier of u: 720p or 1080p for video, WxH for
+// images that set both dimensions.
+func resolution(u models.SubmitUsage) string {
+	if IsVideo(u.ReqKey) {
+		if strings.Contains(u.ReqKey, "1080") {
+			return "1080p"
+		}
+		return "720p"
+	}
+	if u.Width > 0 && u.Height > 0 {
+		return strconv.Itoa(u.Width) + "x" + strconv.Itoa(u.Height)
+	}
+	return ""
+}
+
+func fromMicros(v int64) float64 {
+	return float64(v) / microsPerUnit
+}
+
+// WriteCSV writes a header and one line per row. Totals are left out so the
+// output loads as a plain table. A grouped report has a column named after
+// the label in place of api_key_id.
+func WriteCSV(w io.Writer, report Report) error {
+	cw := csv.NewWriter(w)
+	keyColumn := "api_key_id"
+	if report.GroupBy != "" {
+		keyColumn = report.GroupBy
+	}
+	if err := cw.Write([]string{"day", keyColumn, "req_key", "requests", "images", "video_seconds", "frames", "cost", "currency", "unpriced"}); err != nil {
+		return err
+	}
+	for _, r := range report.Rows {
+		key := r.APIKeyID
+		if report.GroupBy != "" {
+			key = r.Group
+		}
+		if err := cw.Write([]string{
+			r.Day,
+			key,
+			r.ReqKey,
+			strconv.FormatInt(r.Requests, 10),
+			strconv.FormatInt(r.Images, 10),
+			strconv.FormatFloat(r.VideoSeconds, 'f', -1, 64),
+			strconv.FormatInt(r.Frames, 10),
+			strconv.FormatFloat(r.Cost, 'f', -1, 64),
+			report.Currency,
+			strconv.FormatInt(r.Unpriced, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+const dateLayout = "2006-01-02"
+
+// ParseRange parses report bounds given as YYYY-MM-DD or RFC3339. A date for
+// to includes that whole UTC day, so 2026-03-01..2026-03-31 covers March.
+// An empty from is the start of now's UTC month; an empty to is now.
+func ParseRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := now
+	if v := strings.TrimSpace(from); v != "" {
+		t, _, err := parseBound(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, internalerrors.New(internalerrors.ErrValidationFailed, "invalid from: expected YYYY-MM-DD or RFC3339", err)
+		}
+		start = t
+	}
+	if v := strings.TrimSpace(to); v != "" {
+		t, isDate, err := parseBound(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, internalerrors.New(internalerrors.ErrValidationFailed, "invalid to: expected YYYY-MM-DD or RFC3339", err)
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		end = t
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, internalerrors.New(internalerrors.ErrValidationFailed, "from must be before to", nil)
+	}
+	return start, end, nil
+}
+
+func parseBound(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(dateLayout, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t.UTC(), false, err
+}
@@ -0,0 +1,215 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	internalerrors "github.com/jimeng-relay/server/internal/errors"
+	"github.com/jimeng-relay/server/internal/models"
+)
+
+const testPrices = `
+currency: CNY
+models:
+  jimeng_t2i_v40:
+    per_image: 0.2
+    resolutions:
+      4096x4096: {per_image: 0.5}
+  jimeng_t2v_v30:
+    per_second: 0.28
+  jimeng_ti2v_v30_pro:
+    resolutions:
+      720p: {per_second: 0.3}
+      1080p: {per_second: 0.7}
+`
+
+type fakeUsageRepo struct {
+	rows     []models.SubmitUsage
+	apiKeyID string
+}
+
+func (f *fakeUsageRepo) SummarizeSubmits(_ context.Context, _, _ time.Time, apiKeyID string) ([]models.SubmitUsage, error) {
+	f.apiKeyID = apiKeyID
+	return f.rows, nil
+}
+
+func mustParsePrices(t *testing.T, doc string) *PriceTable {
+	t.Helper()
+	p, err := ParsePrices([]byte(doc))
+	if err != nil {
+		t.Fatalf("ParsePrices: %v", err)
+	}
+	return p
+}
+
+func TestService_Report(t *testing.T) {
+	repo := &fakeUsageRepo{rows: []models.SubmitUsage{
+		{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2i_v40", Count: 3},
+		{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2i_v40", Width: 4096, Height: 4096, Count: 1},
+		{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Count: 2},
+		{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Frames: 241, Count: 1},
+		{Day: "2026-03-01", APIKeyID: "k2", ReqKey: "jimeng_ti2v_v30_pro", Count: 1},
+		{Day: "2026-03-02", APIKeyID: "k1", ReqKey: "jimeng_i2v_recamera_v30", Count: 4},
+	}}
+	svc := NewService(repo, mustParsePrices(t, testPrices))
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	report, err := svc.Report(context.Background(), from, from.AddDate(0, 0, 2), "")
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Currency != "CNY" || len(report.Rows) != 4 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	images := report.Rows[0]
+	if images.Requests != 4 || images.Images != 4 || images.Cost != 1.1 {
+		t.Fatalf("unexpected image row: %+v", images)
+	}
+	video := report.Rows[1]
+	if video.Requests != 3 || video.VideoSeconds != 20 || video.Frames != 2*121+241 || video.Cost != 5.6 {
+		t.Fatalf("unexpected video row: %+v", video)
+	}
+	if pro := report.Rows[2]; pro.APIKeyID != "k2" || pro.Cost != 1.5 {
+		t.Fatalf("unexpected resolution-priced row: %+v", pro)
+	}
+	if unpriced := report.Rows[3]; unpriced.Unpriced != 4 || unpriced.Cost != 0 {
+		t.Fatalf("expected a req_key without a price to be reported as unpriced: %+v", unpriced)
+	}
+	if tot := report.Totals; tot.Requests != 12 || tot.Cost != 8.2 || tot.Unpriced != 4 || tot.VideoSeconds != 45 {
+		t.Fatalf("unexpected totals: %+v", tot)
+	}
+
+	if _, err := svc.Report(context.Background(), from, from, "k1"); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+		t.Fatalf("expected an empty range to be rejected, got %v", err)
+	}
+	if _, err := svc.Report(context.Background(), from, from.Add(time.Hour), "k1"); err != nil || repo.apiKeyID != "k1" {
+		t.Fatalf("expected the key filter to reach the repository, got %q, %v", repo.apiKeyID, err)
+	}
+}
+
+func TestService_ReportWithoutPrices(t *testing.T) {
+	repo := &fakeUsageRepo{rows: []models.SubmitUsage{{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2i_v40", Count: 2}}}
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	report, err := NewService(repo, nil).Report(context.Background(), from, from.AddDate(0, 0, 1), "")
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Totals.Unpriced != 2 || report.Totals.Cost != 0 || report.Currency != "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestApplyLabels(t *testing.T) {
+	repo := &fakeUsageRepo{rows: []models.SubmitUsage{
+		{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2i_v40", Count: 1},
+		{Day: "2026-03-01", APIKeyID: "k2", ReqKey: "jimeng_t2i_v40", Count: 2},
+		{Day: "2026-03-01", APIKeyID: "k3", ReqKey: "jimeng_t2i_v40", Count: 4},
+		{Day: "2026-03-01", APIKeyID: "k4", ReqKey: "jimeng_t2i_v40", Count: 8},
+	}}
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	report, err := NewService(repo, mustParsePrices(t, testPrices)).Report(context.Background(), from, from.AddDate(0, 0, 1), "")
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	keys := []models.APIKey{
+		{ID: "k1", Labels: map[string]string{"team": "growth", "environment": "prod"}},
+		{ID: "k2", Labels: map[string]string{"team": "growth", "environment": "staging"}},
+		{ID: "k3", Labels: map[string]string{"team": "search", "environment": "prod"}},
+		{ID: "k4"},
+	}
+
+	grouped := ApplyLabels(report, keys, LabelQuery{GroupBy: "team"})
+	if len(grouped.Rows) != 3 || grouped.GroupBy != "team" {
+		t.Fatalf("unexpected grouped report: %+v", grouped)
+	}
+	for i, want := range []struct {
+		group    string
+		requests int64
+	}{{NoLabel, 8}, {"growth", 3}, {"search", 4}} {
+		row := grouped.Rows[i]
+		if row.Group != want.group || row.Requests != want.requests || row.APIKeyID != "" {
+			t.Fatalf("row %d: expected %s with %d requests, got %+v", i, want.group, want.requests, row)
+		}
+	}
+	if grouped.Rows[1].Cost != 0.6 || grouped.Totals.Requests != 15 || grouped.Totals.Cost != 3 {
+		t.Fatalf("unexpected grouped costs: %+v", grouped)
+	}
+
+	sel, err := models.ParseLabelSelector("environment=prod")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector: %v", err)
+	}
+	filtered := ApplyLabels(report, keys, LabelQuery{Selector: sel})
+	if len(filtered.Rows) != 2 || filtered.Rows[0].APIKeyID != "k1" || filtered.Rows[1].APIKeyID != "k3" || filtered.Totals.Requests != 5 {
+		t.Fatalf("unexpected filtered report: %+v", filtered)
+	}
+	if filtered.Selector != "environment=prod" || len(report.Rows) != 4 {
+		t.Fatalf("expected the selector echoed and the original report untouched: %+v", filtered)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, ApplyLabels(report, keys, LabelQuery{Selector: sel, GroupBy: "team"})); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	want := "day,team,req_key,requests,images,video_seconds,frames,cost,currency,unpriced\n" +
+		"2026-03-01,growth,jimeng_t2i_v40,1,1,0,0,0.2,CNY,0\n" +
+		"2026-03-01,search,jimeng_t2i_v40,4,4,0,0,0.8,CNY,0\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected grouped csv:\n%s", strings.TrimSpace(got))
+	}
+}
+
+func TestParsePrices(t *testing.T) {
+	p := mustParsePrices(t, "default:\n  per_request: 0.1\n")
+	if price, ok := p.lookup("anything", ""); !ok || price.PerRequest != 0.1 {
+		t.Fatalf("expected default price, got %+v %v", price, ok)
+	}
+	for _, doc := range []string{
+		"models:\n  jimeng_t2i_v40:\n    per_image: -1\n",
+		"models:\n  jimeng_t2i_v40:\n    per_pixel: 1\n",
+		"currency: [CNY]\n",
+	} {
+		if _, err := ParsePrices([]byte(doc)); err == nil {
+			t.Fatalf("expected %q to be rejected", doc)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	report := Report{Currency: "CNY", Rows: []Row{{Day: "2026-03-01", APIKeyID: "k1", ReqKey: "jimeng_t2v_v30", Requests: 1, VideoSeconds: 5, Frames: 121, Cost: 1.4}}}
+	if err := WriteCSV(&buf, report); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	want := "day,api_key_id,req_key,requests,images,video_seconds,frames,cost,currency,unpriced\n" +
+		"2026-03-01,k1,jimeng_t2v_v30,1,0,5,121,1.4,CNY,0\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected csv:\n%s", strings.TrimSpace(got))
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	from, to, err := ParseRange("", "", now)
+	if err != nil || !from.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(now) {
+		t.Fatalf("unexpected default range %s..%s: %v", from, to, err)
+	}
+	from, to, err = ParseRange("2026-02-01", "2026-02-28", now)
+	if err != nil || !from.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected a date to to include that day, got %s..%s: %v", from, to, err)
+	}
+	_, to, err = ParseRange("2026-02-01", "2026-02-10T08:00:00+08:00", now)
+	if err != nil || !to.Equal(time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected an RFC3339 to to be used as is, got %s: %v", to, err)
+	}
+	for _, tc := range [][2]string{{"yesterday", ""}, {"", "2026/02/01"}, {"2026-03-02", "2026-03-01"}} {
+		if _, _, err := ParseRange(tc[0], tc[1], now); internalerrors.GetCode(err) != internalerrors.ErrValidationFailed {
+			t.Fatalf("expected %q..%q to be rejected, got %v", tc[0], tc[1], err)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package testharness
 
 import (
-	"reflect"
 	"testing"
 
 	"github.com/jimeng-relay/server/internal/relay/upstream"
@@ -32,17 +31,12 @@ func AssertMaxInFlight(t *testing.T, client *upstream.Client, max int) {
 		t.Fatalf("max must be positive, got %d", max)
 	}
 
-	v := reflect.ValueOf(client).Elem().FieldByName("sem")
-	if !v.IsValid() || v.IsNil() || v.Kind() != reflect.Chan {
-		t.Fatalf("upstream client semaphore not found")
+	st := client.QueueStats()
+	if st.MaxConcurrent != max {
+		t.Fatalf("unexpected max in-flight cap: got=%d want=%d", st.MaxConcurrent, max)
 	}
-
-	capSem := v.Cap()
-	if capSem != max {
-		t.Fatalf("unexpected max in-flight cap: got=%d want=%d", capSem, max)
-	}
-	if v.Len() > max {
-		t.Fatalf("in-flight exceeded max: inFlight=%d max=%d", v.Len(), max)
+	if st.Active > max {
+		t.Fatalf("in-flight exceeded max: inFlight=%d max=%d", st.Active, max)
 	}
 }
 
@@ -0,0 +1,194 @@
+// Package tlsconfig builds the relay's server-side TLS configuration and keeps
+// it in sync with the certificate, key and client CA files on disk.
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultReloadInterval = 10 * time.Second
+
+const (
+	ClientAuthOff      = "off"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is "1.2" or "1.3". Defaults to "1.2".
+	MinVersion string
+	// ClientCAFile holds the PEM CAs trusted to sign client certificates.
+	// Required unless ClientAuth is off.
+	ClientCAFile string
+	// ClientAuth is off, optional (verify a certificate when one is sent) or
+	// require (reject handshakes without a valid certificate).
+	ClientAuth string
+	// ReloadInterval is how often Run checks the files for changes; 0 disables
+	// polling.
+	ReloadInterval time.Duration
+	Logger         *slog.Logger
+}
+
+// Reloader serves the most recently loaded TLS configuration. A failed reload
+// keeps the previous configuration in place.
+type Reloader struct {
+	cfg        Config
+	minVersion uint16
+	clientAuth tls.ClientAuthType
+	logger     *slog.Logger
+
+	mu      sync.Mutex
+	lastRaw [][]byte
+	current atomic.Pointer[tls.Config]
+}
+
+func New(cfg Config) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls cert and key files are required")
+	}
+	minVersion, err := parseMinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := parseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("tls client auth %q requires a client CA file", cfg.ClientAuth)
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := &Reloader{cfg: cfg, minVersion: minVersion, clientAuth: clientAuth, logger: logger}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a config for tls.NewListener that resolves to the current
+// configuration on every handshake, so reloads apply to new connections.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Reload re-reads the files and reports whether a new configuration was
+// installed.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paths := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		paths = append(paths, r.cfg.ClientCAFile)
+	}
+	raw := make([][]byte, len(paths))
+	for i, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("read tls file %s: %w", path, err)
+		}
+		raw[i] = b
+	}
+	if r.current.Load() != nil && sameFiles(raw, r.lastRaw) {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(raw[0], raw[1])
+	if err != nil {
+		return false, fmt.Errorf("load tls key pair %s, %s: %w", r.cfg.CertFile, r.cfg.KeyFile, err)
+	}
+	next := &tls.Config{
+		MinVersion:   r.minVersion,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+	if len(raw) > 2 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw[2]) {
+			return false, fmt.Errorf("no certificates found in tls client CA file %s", r.cfg.ClientCAFile)
+		}
+		next.ClientCAs = pool
+	}
+	r.current.Store(next)
+	r.lastRaw = raw
+	return true, nil
+}
+
+// Run polls the files until ctx is done.
+func (r *Reloader) Run(ctx context.Context) {
+	if r.cfg.ReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				r.logger.ErrorContext(ctx, "tls reload failed; keeping previous certificate", "error", err.Error())
+				continue
+			}
+			if changed {
+				r.logger.InfoContext(ctx, "tls certificate reloaded", "cert_file", r.cfg.CertFile)
+			}
+		}
+	}
+}
+
+func sameFiles(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseMinVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls min version %q (expected 1.2 or 1.3)", v)
+	}
+}
+
+func parseClientAuth(v string) (tls.ClientAuthType, error) {
+	switch v {
+	case "", ClientAuthOff:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls client auth %q (expected %s, %s or %s)", v, ClientAuthOff, ClientAuthOptional, ClientAuthRequire)
+	}
+}
@@ -0,0 +1,178 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, cn string, parent *testCert, isCA bool) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// handshake serves one TLS connection with r and returns the server's leaf
+// certificate serial as seen by the client, or the handshake error.
+func handshake(t *testing.T, r *Reloader, clientCert *testCert) (int64, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{clientCert.cert.Raw}, PrivateKey: clientCert.key}}
+	}
+	conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	// With TLS 1.3 a rejected client certificate surfaces on the first read.
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return 0, err
+		}
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestReloader_ReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestCert(t, 1, "relay", nil, false)
+	writeFile(t, certFile, first.certPEM)
+	writeFile(t, keyFile, first.keyPEM)
+
+	r, err := New(Config{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if serial, err := handshake(t, r, nil); err != nil || serial != 1 {
+		t.Fatalf("expected first certificate, got serial=%d err=%v", serial, err)
+	}
+	if changed, err := r.Reload(); err != nil || changed {
+		t.Fatalf("expected unchanged files to be a no-op, got changed=%v err=%v", changed, err)
+	}
+
+	writeFile(t, keyFile, []byte("not a key"))
+	if _, err := r.Reload(); err == nil {
+		t.Fatalf("expected a broken key pair to fail reload")
+	}
+	if serial, err := handshake(t, r, nil); err != nil || serial != 1 {
+		t.Fatalf("expected previous certificate after failed reload, got serial=%d err=%v", serial, err)
+	}
+
+	second := newTestCert(t, 2, "relay", nil, false)
+	writeFile(t, certFile, second.certPEM)
+	writeFile(t, keyFile, second.keyPEM)
+	if changed, err := r.Reload(); err != nil || !changed {
+		t.Fatalf("expected reload, got changed=%v err=%v", changed, err)
+	}
+	if serial, err := handshake(t, r, nil); err != nil || serial != 2 {
+		t.Fatalf("expected rotated certificate, got serial=%d err=%v", serial, err)
+	}
+}
+
+func TestReloader_RequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 10, "test-ca", nil, true)
+	server := newTestCert(t, 11, "relay", &ca, false)
+	client := newTestCert(t, 12, "worker-a", &ca, false)
+	stranger := newTestCert(t, 13, "worker-b", nil, false)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, server.certPEM)
+	writeFile(t, keyFile, server.keyPEM)
+	writeFile(t, caFile, ca.certPEM)
+
+	if _, err := New(Config{CertFile: certFile, KeyFile: keyFile, ClientAuth: ClientAuthRequire}); err == nil {
+		t.Fatalf("expected client auth without a CA file to be rejected")
+	}
+	r, err := New(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: ClientAuthRequire, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if _, err := handshake(t, r, &client); err != nil {
+		t.Fatalf("expected CA-signed client certificate to be accepted: %v", err)
+	}
+	if _, err := handshake(t, r, nil); err == nil {
+		t.Fatalf("expected handshake without a client certificate to fail")
+	}
+	if _, err := handshake(t, r, &stranger); err == nil {
+		t.Fatalf("expected client certificate from an unknown CA to fail")
+	}
+}
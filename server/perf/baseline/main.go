@@ -31,6 +31,8 @@ import (
 	"github.com/jimeng-relay/server/internal/middleware/observability"
 	"github.com/jimeng-relay/server/internal/middleware/sigv4"
 	"github.com/jimeng-relay/server/internal/relay/upstream"
+	"github.com/jimeng-relay/server/internal/repository"
+	"github.com/jimeng-relay/server/internal/repository/memory"
 	"github.com/jimeng-relay/server/internal/repository/sqlite"
 	"github.com/jimeng-relay/server/internal/secretcrypto"
 	apikeyservice "github.com/jimeng-relay/server/internal/service/apikey"
@@ -85,6 +87,41 @@ type runReport struct {
 	Scenarios []result `json:"scenarios"`
 }
 
+type benchRepos struct {
+	APIKeys            repository.APIKeyRepository
+	DownstreamRequests repository.DownstreamRequestRepository
+	UpstreamAttempts   repository.UpstreamAttemptRepository
+	AuditEvents        repository.AuditEventRepository
+	IdempotencyRecords repository.IdempotencyRecordRepository
+}
+
+// openBenchRepos opens the backend under test. The sqlite database lives in a
+// temp dir that the returned close func removes.
+func openBenchRepos(ctx context.Context, database string) (benchRepos, func(), error) {
+	switch database {
+	case "memory":
+		repos := memory.New()
+		return benchRepos{APIKeys: repos.APIKeys, DownstreamRequests: repos.DownstreamRequests, UpstreamAttempts: repos.UpstreamAttempts, AuditEvents: repos.AuditEvents, IdempotencyRecords: repos.IdempotencyRecords}, func() {}, nil
+	case "sqlite":
+		tmpDir, err := os.MkdirTemp("", "relay-perf-")
+		if err != nil {
+			return benchRepos{}, nil, err
+		}
+		repos, err := sqlite.Open(ctx, filepath.Join(tmpDir, "perf.db"))
+		if err != nil {
+			_ = os.RemoveAll(tmpDir)
+			return benchRepos{}, nil, fmt.Errorf("open sqlite: %w", err)
+		}
+		closeRepos := func() {
+			_ = repos.Close()
+			_ = os.RemoveAll(tmpDir)
+		}
+		return benchRepos{APIKeys: repos.APIKeys, DownstreamRequests: repos.DownstreamRequests, UpstreamAttempts: repos.UpstreamAttempts, AuditEvents: repos.AuditEvents, IdempotencyRecords: repos.IdempotencyRecords}, closeRepos, nil
+	default:
+		return benchRepos{}, nil, fmt.Errorf("unsupported database %q (expected sqlite or memory)", database)
+	}
+}
+
 type benchEnv struct {
 	serverURL   string
 	accessKey   string
@@ -105,6 +142,7 @@ func main() {
 	clientMaxIdle := flag.Int("client-max-idle-per-host", 256, "load client MaxIdleConnsPerHost")
 	upstreamDelay := flag.Duration("upstream-delay", 20*time.Millisecond, "fake upstream latency per request")
 	writeJSON := flag.String("out", "", "optional json output path")
+	database := flag.String("database", "sqlite", "repository backend: sqlite (temp file) or memory")
 	flag.Parse()
 
 	if *low <= 0 || *high <= 0 {
@@ -120,7 +158,7 @@ func main() {
 		log.Fatalf("max-retries must be >= 0")
 	}
 
-	env, err := setupBench(*timeout, *maxRetries, *clientMaxIdle, *upstreamDelay)
+	env, err := setupBench(*database, *timeout, *maxRetries, *clientMaxIdle, *upstreamDelay)
 	if err != nil {
 		log.Fatalf("setup bench env: %v", err)
 	}
@@ -145,8 +183,10 @@ func main() {
 	report.Config.LowConc = *low
 	report.Config.HighConc = *high
 	report.Config.ClientConns = *clientMaxIdle
-	report.Config.Database = "sqlite"
-	report.Config.DatabaseURL = "temp-file"
+	report.Config.Database = *database
+	if *database == "sqlite" {
+		report.Config.DatabaseURL = "temp-file"
+	}
 	report.Config.UpstreamDelay = *upstreamDelay
 
 	fmt.Printf("# Relay Submit Baseline\n")
@@ -170,19 +210,12 @@ func main() {
 	}
 }
 
-func setupBench(timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDelay time.Duration) (*benchEnv, error) {
+func setupBench(database string, timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDelay time.Duration) (*benchEnv, error) {
 	ctx := context.Background()
-	tmpDir, err := os.MkdirTemp("", "relay-perf-")
+	repos, closeRepos, err := openBenchRepos(ctx, database)
 	if err != nil {
 		return nil, err
 	}
-	dbPath := filepath.Join(tmpDir, "perf.db")
-
-	repos, err := sqlite.Open(ctx, dbPath)
-	if err != nil {
-		_ = os.RemoveAll(tmpDir)
-		return nil, fmt.Errorf("open sqlite: %w", err)
-	}
 
 	upstreamSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if upstreamDelay > 0 {
@@ -214,8 +247,7 @@ func setupBench(timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDe
 	secretCipher, err := secretcrypto.NewAESCipher([]byte("0123456789abcdef0123456789abcdef"))
 	if err != nil {
 		upstreamSrv.Close()
-		_ = repos.Close()
-		_ = os.RemoveAll(tmpDir)
+		closeRepos()
 		return nil, fmt.Errorf("new cipher: %w", err)
 	}
 
@@ -223,8 +255,7 @@ func setupBench(timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDe
 	created, err := apikeySvc.Create(ctx, apikeyservice.CreateRequest{Description: "perf-baseline"})
 	if err != nil {
 		upstreamSrv.Close()
-		_ = repos.Close()
-		_ = os.RemoveAll(tmpDir)
+		closeRepos()
 		return nil, fmt.Errorf("create api key: %w", err)
 	}
 
@@ -240,8 +271,7 @@ func setupBench(timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDe
 	upstreamClient, err := upstream.NewClient(upstreamCfg, upstream.Options{MaxRetries: maxRetries})
 	if err != nil {
 		upstreamSrv.Close()
-		_ = repos.Close()
-		_ = os.RemoveAll(tmpDir)
+		closeRepos()
 		return nil, fmt.Errorf("new upstream client: %w", err)
 	}
 
@@ -273,8 +303,7 @@ func setupBench(timeout time.Duration, maxRetries, clientMaxIdle int, upstreamDe
 	cleanup := func() {
 		relaySrv.Close()
 		upstreamSrv.Close()
-		_ = repos.Close()
-		_ = os.RemoveAll(tmpDir)
+		closeRepos()
 		transport.CloseIdleConnections()
 	}
 